@@ -0,0 +1,275 @@
+package errstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+)
+
+const (
+	// DefaultMaxRows 默认最多保留的错误条数
+	DefaultMaxRows = 100000
+	// DefaultQueueSize 默认等待写入的错误条数上限, 超过后丢弃
+	DefaultQueueSize = 10000
+	// DefaultQueryLimit 查询默认返回的条数, MaxQueryLimit 最多返回的条数
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+	// 错误行最多保留的字节数
+	excerptBytes = 256
+)
+
+// Entry is one error of worker
+type Entry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	StrategyID  int64     `json:"strategy_id"`
+	FilePath    string    `json:"file_path"`
+	LineExcerpt string    `json:"line_excerpt"`
+	ErrorReason string    `json:"error_reason"`
+	WorkerID    string    `json:"worker_id"`
+}
+
+// Store to persist worker errors for post-incident analysis
+// 每条错误追加一行json到文件(JSON Lines, 代替sqlite以免新增vendor依赖), 内存中保留最近maxRows条供查询;
+// 文件行数超过2倍maxRows时重写为最近的maxRows条, 最老的先删除
+type Store struct {
+	sync.RWMutex
+	path    string
+	maxRows int
+	rows    []*Entry //按时间从老到新
+	lines   int      //文件中的行数
+	f       *os.File
+}
+
+// Open to open the store, load existing entries from file
+func Open(path string, maxRows int) (*Store, error) {
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+	s := &Store{path: path, maxRows: maxRows}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+func (s *Store) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		s.lines++
+		e := new(Entry)
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			// 写到一半的行, 跳过
+			continue
+		}
+		s.rows = append(s.rows, e)
+		if len(s.rows) > 2*s.maxRows {
+			s.rows = append(s.rows[:0], s.rows[len(s.rows)-s.maxRows:]...)
+		}
+	}
+	if len(s.rows) > s.maxRows {
+		s.rows = s.rows[len(s.rows)-s.maxRows:]
+	}
+	return sc.Err()
+}
+
+// Add to record an error
+func (s *Store) Add(e *Entry) error {
+	if len(e.LineExcerpt) > excerptBytes {
+		// 在字符边界截断, 不切开多字节字符
+		cut := excerptBytes
+		for cut > 0 && !utf8.RuneStart(e.LineExcerpt[cut]) {
+			cut--
+		}
+		e.LineExcerpt = e.LineExcerpt[:cut] + "..."
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.rows = append(s.rows, e)
+	if len(s.rows) > s.maxRows {
+		s.rows = s.rows[len(s.rows)-s.maxRows:]
+	}
+	if s.f == nil {
+		return fmt.Errorf("error store closed")
+	}
+	if _, err := s.f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	s.lines++
+	if s.lines > 2*s.maxRows {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact to rewrite file with entries in memory, must be called with lock held
+func (s *Store) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range s.rows {
+		bs, _ := json.Marshal(e)
+		w.Write(bs)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.f.Close()
+	s.f, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.f = nil
+		return err
+	}
+	s.lines = len(s.rows)
+	return nil
+}
+
+// Query to get the latest limit entries not earlier than since, sid <= 0 means all strategies
+// 结果按时间从老到新, limit <= 0 不限制条数
+func (s *Store) Query(since time.Time, sid int64, limit int) []*Entry {
+	s.RLock()
+	defer s.RUnlock()
+	ret := make([]*Entry, 0)
+	for i := len(s.rows) - 1; i >= 0 && (limit <= 0 || len(ret) < limit); i-- {
+		e := s.rows[i]
+		if e.Timestamp.Before(since) || (sid > 0 && e.StrategyID != sid) {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret
+}
+
+// Close to close the store
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+var (
+	defaultStore *Store
+	defaultQueue chan *Entry
+	defaultLock  = new(sync.RWMutex)
+	// 队列满丢弃的条数, 写入goroutine下次写入时打日志并清零
+	dropped int64
+)
+
+// Init to open the global store, path is empty means disabled
+// Record只把错误放入长度为queueSize的队列, 由后台goroutine写入, queueSize <= 0 时取DefaultQueueSize
+func Init(path string, maxRows, queueSize int) error {
+	if path == "" {
+		return nil
+	}
+	s, err := Open(path, maxRows)
+	if err != nil {
+		return err
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	queue := make(chan *Entry, queueSize)
+	go s.consume(queue)
+	defaultLock.Lock()
+	defaultStore = s
+	defaultQueue = queue
+	defaultLock.Unlock()
+	dlog.Infof("error store opened [path:%s][rows:%d]", path, len(s.rows))
+	return nil
+}
+
+// consume to write the queued errors to the store
+func (s *Store) consume(queue <-chan *Entry) {
+	for e := range queue {
+		if err := s.Add(e); err != nil {
+			dlog.Errorf("record error failed [path:%s][err:%v]", s.path, err)
+		}
+		if n := atomic.SwapInt64(&dropped, 0); n > 0 {
+			dlog.Warningf("error store queue is full, %d errors dropped [path:%s]", n, s.path)
+		}
+	}
+}
+
+// Enabled to check whether the global store is opened
+func Enabled() bool {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultStore != nil
+}
+
+// Record to queue an error for the global store, ignored if disabled
+// 不等待写文件, 队列满时丢弃并计数, worker不会被错误记录拖慢
+func Record(e *Entry) {
+	defaultLock.RLock()
+	queue := defaultQueue
+	defaultLock.RUnlock()
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- e:
+	default:
+		atomic.AddInt64(&dropped, 1)
+	}
+}
+
+// Query to query the global store, limit <= 0 取DefaultQueryLimit, 最多MaxQueryLimit
+func Query(since time.Time, sid int64, limit int) ([]*Entry, error) {
+	defaultLock.RLock()
+	s := defaultStore
+	defaultLock.RUnlock()
+	if s == nil {
+		return nil, fmt.Errorf("error store is not enabled")
+	}
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+	return s.Query(since, sid, limit), nil
+}
@@ -0,0 +1,130 @@
+package errstore
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "errors.db")
+
+	s, err := Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		err := s.Add(&Entry{
+			Timestamp:   base.Add(time.Duration(i) * time.Second),
+			StrategyID:  int64(i%2 + 1),
+			FilePath:    "/home/app/log/api.log",
+			LineExcerpt: fmt.Sprintf("line %d %s", i, strings.Repeat("x", 300)),
+			ErrorReason: "cannot get timestamp",
+			WorkerID:    "[worker][0]",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 只保留最近10条, 最老的先删除
+	all := s.Query(time.Time{}, 0, 0)
+	if len(all) != 10 || !strings.HasPrefix(all[0].LineExcerpt, "line 15 ") {
+		t.Fatalf("oldest rows should be deleted first, got %d rows from %q", len(all), all[0].LineExcerpt[:10])
+	}
+	if len(all[0].LineExcerpt) > excerptBytes+3 {
+		t.Errorf("line excerpt should be truncated: %d", len(all[0].LineExcerpt))
+	}
+	// 文件也被压缩, 不会无限增长
+	if n := countLines(t, path); n > 20 {
+		t.Errorf("file should be compacted, %d lines", n)
+	}
+
+	got := s.Query(base.Add(20*time.Second), 1, 0)
+	if len(got) != 3 || got[0].StrategyID != 1 || got[1].Timestamp != base.Add(22*time.Second) {
+		t.Errorf("unexpected query result: %+v", got)
+	}
+
+	// 重新打开后数据还在
+	s.Close()
+	s, err = Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if again := s.Query(time.Time{}, 0, 0); len(again) != 10 || again[9].Timestamp != base.Add(24*time.Second) {
+		t.Errorf("entries should survive reopen: %d", len(again))
+	}
+
+	// limit取最近的几条, 仍按时间从老到新
+	if got := s.Query(time.Time{}, 0, 3); len(got) != 3 || got[0].Timestamp != base.Add(22*time.Second) || got[2].Timestamp != base.Add(24*time.Second) {
+		t.Errorf("limit should keep the latest entries: %+v", got)
+	}
+
+	// 截断不切开多字节字符
+	wide := &Entry{Timestamp: base, LineExcerpt: "ab" + strings.Repeat("错", 200)}
+	if err := s.Add(wide); err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(wide.LineExcerpt) || len(wide.LineExcerpt) > excerptBytes+3 {
+		t.Errorf("line excerpt should be cut at a rune boundary: %q", wide.LineExcerpt)
+	}
+}
+
+func TestRecordQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := Init(filepath.Join(dir, "errors.db"), 100, 5); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		defaultLock.Lock()
+		defaultStore.Close()
+		defaultStore, defaultQueue = nil, nil
+		defaultLock.Unlock()
+	}()
+
+	// 写入在后台进行, 队列满时丢弃, Record不阻塞
+	for i := 0; i < 1000; i++ {
+		Record(&Entry{Timestamp: time.Now(), StrategyID: 1, ErrorReason: "cannot get timestamp"})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	var got []*Entry
+	for time.Now().Before(deadline) {
+		if got, _ = Query(time.Time{}, 1, MaxQueryLimit+1); len(got) > 0 && len(defaultQueue) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) == 0 || len(got) > 100 {
+		t.Errorf("queued errors should be written and capped: %d", len(got))
+	}
+}
@@ -0,0 +1,207 @@
+// Package expr is a tiny arithmetic expression evaluator used by composite strategies.
+//
+// Supported syntax: float numbers, variables $<strategy id>, + - * /, unary minus and parentheses,
+// e.g. ($12 - $11) * 1000
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	root *node
+	vars []int64
+}
+
+type node struct {
+	op    byte //0 数字, '$' 变量, 其余为运算符
+	value float64
+	id    int64
+	l, r  *node
+}
+
+// Parse to compile an expression
+func Parse(s string) (*Expr, error) {
+	p := &parser{src: s, vars: make(map[int64]bool)}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(s) {
+		return nil, fmt.Errorf("unexpected %q at %d", s[p.pos], p.pos)
+	}
+	e := &Expr{src: s, root: root}
+	for id := range p.vars {
+		e.vars = append(e.vars, id)
+	}
+	sort.Slice(e.vars, func(i, j int) bool { return e.vars[i] < e.vars[j] })
+	return e, nil
+}
+
+// String to get the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars to get ids of variables referenced, sorted
+func (e *Expr) Vars() []int64 {
+	return e.vars
+}
+
+// Eval to evaluate the expression, vars holds the value of each $id
+func (e *Expr) Eval(vars map[int64]float64) (float64, error) {
+	return eval(e.root, vars)
+}
+
+func eval(n *node, vars map[int64]float64) (float64, error) {
+	switch n.op {
+	case 0:
+		return n.value, nil
+	case '$':
+		v, ok := vars[n.id]
+		if !ok {
+			return 0, fmt.Errorf("no value of $%d", n.id)
+		}
+		return v, nil
+	case 'n':
+		v, err := eval(n.l, vars)
+		return -v, err
+	}
+	l, err := eval(n.l, vars)
+	if err != nil {
+		return 0, err
+	}
+	r, err := eval(n.r, vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	default:
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}
+}
+
+type parser struct {
+	src  string
+	pos  int
+	vars map[int64]bool
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek to get next non-space char, 0 at end
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// expr := term (('+'|'-') term)*
+func (p *parser) expr() (*node, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+// term := unary (('*'|'/') unary)*
+func (p *parser) term() (*node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &node{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+// unary := '-' unary | primary
+func (p *parser) unary() (*node, error) {
+	if p.peek() == '-' {
+		p.pos++
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: 'n', l: n}, nil
+	}
+	return p.primary()
+}
+
+// primary := number | '$' id | '(' expr ')'
+func (p *parser) primary() (*node, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return n, nil
+	case c == '$':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		id, err := strconv.ParseInt(p.src[start:p.pos], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad variable at %d, should be $<strategy id>", start-1)
+		}
+		p.vars[id] = true
+		return &node{op: '$', id: id}, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", p.src[start:p.pos], start)
+		}
+		return &node{value: v}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+}
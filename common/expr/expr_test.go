@@ -0,0 +1,59 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[int64]float64{1: 10, 2: 4, 30: 0.5}
+	for src, want := range map[string]float64{
+		"1":               1,
+		"$1":              10,
+		"$1 - $2":         6,
+		"$1 - $2 * 2":     2,
+		"($1 - $2) * 2":   12,
+		"-$2 + 1":         -3,
+		"$1 / $30":        20,
+		"$1 - -$2":        14,
+		" 1.5*(2+$30) ":   3.75,
+		"$1 - $2 - $30":   5.5,
+		"$1 / $2 / $30":   5,
+		"((($2)))":        4,
+		"--$2":            4,
+		"2 * 3 + 4 * 5.0": 26,
+	} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		got, err := e.Eval(vars)
+		if err != nil || got != want {
+			t.Fatalf("eval %q: expect %v, got %v %v", src, want, got, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, src := range []string{"", "$", "$a", "1 +", "(1", "1)", "1 2", "$1 % 2", "1..2"} {
+		if _, err := Parse(src); err == nil {
+			t.Fatalf("parse %q should fail", src)
+		}
+	}
+}
+
+func TestVarsAndEvalError(t *testing.T) {
+	e, err := Parse("$3 / ($1 - $3) + $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.Vars(), []int64{1, 3}) {
+		t.Fatalf("unexpected vars %v", e.Vars())
+	}
+	if _, err := e.Eval(map[int64]float64{1: 2}); err == nil {
+		t.Fatal("missing var should fail")
+	}
+	if _, err := e.Eval(map[int64]float64{1: 2, 3: 2}); err == nil {
+		t.Fatal("division by zero should fail")
+	}
+}
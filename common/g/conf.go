@@ -19,31 +19,232 @@ type logConfig struct {
 }
 
 type httpConfig struct {
-	HTTPPort int `json:"http_port"`
+	HTTPPort        int    `json:"http_port"`
+	StreamMaxEvents int    `json:"stream_max_events"`
+	StreamBuffer    int    `json:"stream_buffer"`
+	StreamWebsocket bool   `json:"stream_websocket"`
+	Token           string `json:"token"` //配置后HTTP及gRPC接口都要求Authorization: Bearer <token>, /health除外
+}
+
+type grpcConfig struct {
+	Listen string `json:"listen"` //gRPC控制接口的监听地址, 为空不开启
 }
 
 type loadConfig struct {
-	UpdateDuration int `json:"update_duration"`
-	DefaultDegree  int `json:"default_degree"`
+	UpdateDuration  int    `json:"update_duration"`
+	DefaultDegree   int    `json:"default_degree"`
+	StepPolicy      string `json:"step_policy"`
+	RegexpBudget    int    `json:"regexp_budget"`
+	RegexpHardLimit int    `json:"regexp_hard_limit"`
+	DefaultTimeZone string `json:"default_time_zone"` //策略没有配置time_zone时的时区, 为空取本机时区; 与之前固定按东八区解析一致需配置Asia/Shanghai
+
+	Etcd etcdConfig `json:"etcd"`
+}
+
+// etcdConfig 配置endpoints后从etcd的prefix下加载并监听策略, [-s | -sf]不再生效
+type etcdConfig struct {
+	Endpoints   []string `json:"endpoints"`
+	Prefix      string   `json:"prefix"`       //策略所在的key前缀, 默认/falcon-log-agent/strategies/
+	TimeoutMs   int      `json:"timeout_ms"`   //请求etcd的超时, 默认3000
+	Election    bool     `json:"election"`     //多个agent监听同一前缀时通过租约锁依次热加载
+	ElectionKey string   `json:"election_key"` //租约锁的key, 默认为prefix去掉末尾的/加上.lock
+	LeaseTTL    int      `json:"lease_ttl"`    //租约的ttl(秒), 应大于一轮策略更新的耗时, 默认10
 }
 
 type workerConfig struct {
-	WorkerNum    int    `json:"worker_num"`
-	QueueSize    int    `json:"queue_size"`
-	PushInterval int    `json:"push_interval"`
-	PushURL      string `json:"push_url"`
+	WorkerNum            int      `json:"worker_num"`
+	QueueSize            int      `json:"queue_size"`
+	PushInterval         int      `json:"push_interval"`
+	PushURL              string   `json:"push_url"`
+	PushCompression      string   `json:"push_compression"`     //none(默认)或auto
+	PushMaxRetries       int      `json:"push_max_retries"`     //推送falcon-agent失败(网络错误、429、5xx)后的重试次数, 0不重试
+	PushBackoffBaseMs    int      `json:"push_backoff_base_ms"` //第一次重试前的等待, 之后翻倍, 默认1000
+	PushBackoffMaxMs     int      `json:"push_backoff_max_ms"`  //等待的上限, 默认30000
+	PushBackoffJitter    *float64 `json:"push_backoff_jitter"`  //乘性抖动比例, 等待在[d*(1-j), d*(1+j)]内随机, 默认0.2
+	PushWorkers          int      `json:"push_workers"`         //并发推送falcon-agent的协程数, 每批点拆成相同份数, 默认1
+	PushSerialization    string   `json:"push_serialization"`   //推送内容的序列化格式, json(默认)或msgpack
+	MaxStrategiesPerFile int      `json:"max_strategies_per_file"`
+	ShedFactor           float64  `json:"shed_factor"`
+	ShedRecoverRatio     float64  `json:"shed_recover_ratio"`
+	MaxPointsPerSecond   int64    `json:"max_points_per_second"`
+	BurstAllowance       int64    `json:"burst_allowance"`
+	MaxTagValueLen       int      `json:"max_tag_value_len"`      //tag取值的最大字节数, 默认255, 策略的tag_limits可以单独配置
+	LockDir              string   `json:"lock_dir"`               //本机多个agent协调用的锁文件目录, 默认/tmp/falcon-log-agent/locks, 为-时不加锁
+	StepAggregate        bool     `json:"step_aggregate"`         //worker内先按step合并同一序列的点, 推送前再合入counter
+	MaxLineBytes         int      `json:"max_line_bytes"`         //进入匹配的行的最大字节数, 超出部分截断, 默认65536
+	GenerationMixedTag   *bool    `json:"generation_mixed_tag"`   //周期内策略更新过的点带上generation_mixed=true, 默认true
+	MaxTagCardinality    int      `json:"max_tag_cardinality"`    //同一文件所有策略的同一tag的取值数上限, 超过后该tag取值为__high_cardinality__, 0不限制
+	TagCardinalityWindow int      `json:"tag_cardinality_window"` //取值数的统计窗口(秒), 到期后重新统计, 默认3600
+	ReceiveOrder         string   `json:"receive_order"`          //worker取行的顺序, fifo(默认)或lifo(先处理最新的行)
+	DelayStableWindow    int      `json:"delay_stable_window"`    //乱序最大差值持续多少秒没有变大才做每日重置, 默认300, 负数不等待
+	StrategyMaxPoints    int64    `json:"strategy_max_points"`    //单个策略每秒产生的点数上限, 之后仍受max_points_per_second限制, 0不限制
+	BoostMaxTTL          int      `json:"boost_max_ttl"`          //策略boost时长的上限(秒), 超过按上限, 默认1800
+	CPUYieldLines        int      `json:"cpu_yield_lines"`        //每个worker每处理多少行让出一次处理器(runtime.Gosched), 0不让出
+	GroupParallelMin     int      `json:"group_parallel_min"`     //时间正则相同的策略组至少多少个策略时并行计算pattern, 默认4, 负数不并行
+
+	RiskWeights map[string]float64 `json:"risk_weights"` ///v1/report/files各因素的权重, 未配置的取默认值, 0表示不计入
+
+	RateLimitRedis rateLimitRedisConfig `json:"rate_limit_redis"`
+	PushConsul     pushConsulConfig     `json:"push_consul"`
+}
+
+// pushConsulConfig 配置service后从consul的健康实例中轮询选择推送地址, push_url作为兜底
+type pushConsulConfig struct {
+	Addr            string `json:"addr"` //consul agent的地址, 默认127.0.0.1:8500
+	Service         string `json:"service"`
+	Tag             string `json:"tag"`
+	Token           string `json:"token"`
+	Scheme          string `json:"scheme"`           //推送地址的协议, 默认http
+	Path            string `json:"path"`             //推送地址的路径, 如/v1/push
+	RefreshInterval int    `json:"refresh_interval"` //刷新实例列表的间隔, 秒, 默认30
+	TimeoutMs       int    `json:"timeout_ms"`       //请求consul的超时, 默认3000
+}
+
+type rateLimitRedisConfig struct {
+	Addr      string `json:"addr"`
+	Password  string `json:"password"`
+	Key       string `json:"key"`
+	Batch     int64  `json:"batch"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+type selfMetricConfig struct {
+	Interval int `json:"interval"` //自监控的上报间隔(秒), 1-300, 默认10
+}
+
+// clockSkewConfig 按刚写入的行估计写日志的机器时钟与本机的偏差
+type clockSkewConfig struct {
+	Threshold int `json:"threshold"` //偏差中位数的绝对值超过多少秒算可疑, 默认60, 负数关闭
+	Window    int `json:"window"`    //中位数的采样窗口, 也是持续超过阈值多久才报出, 秒, 默认300
+}
+
+type alertingConfig struct {
+	PanicWebhookURL             string `json:"panic_webhook_url"`              //worker panic时告警的webhook, 为空不告警
+	PanicWebhookPayloadTemplate string `json:"panic_webhook_payload_template"` //告警内容的text/template, 为空时发送默认的json
+}
+
+type checkpointConfig struct {
+	Path     string `json:"path"`
+	Interval int    `json:"interval"`
+}
+
+type readerConfig struct {
+	FIFOOpenTimeout   int    `json:"fifo_open_timeout"`
+	FingerprintEvery  int    `json:"fingerprint_every"`
+	FingerprintWindow int    `json:"fingerprint_window"`
+	OpenRetryMax      int    `json:"open_retry_max"`       //打开文件失败后重试间隔的上限, 秒
+	WatchMode         string `json:"watch_mode"`           //poll(默认)或inotify
+	LineReadTimeoutMs int    `json:"line_read_timeout_ms"` //半行等待换行的最长时间, 超时丢弃, 默认0一直等待
+	CatchUpMaxMB      int    `json:"catchup_max_mb"`       //停机期间轮转过时, 从轮转出去的文件追赶的上限, 默认1024, 负数不追赶
+
+	MaxFileSizeWarningMB int `json:"max_file_size_warning_mb"` //正在读的文件超过该大小时上报log.agent.file.size_warning_mb, 0不检查
+}
+
+type errorStoreConfig struct {
+	Path      string `json:"path"`
+	MaxRows   int    `json:"max_rows"`
+	QueueSize int    `json:"queue_size"` //等待写入的错误条数上限, 超过后丢弃, 默认10000
+}
+
+type sinkConfig struct {
+	Addr      string `json:"addr"`
+	Window    int    `json:"window"`
+	QueueSize int    `json:"queue_size"`
+	BatchSize int    `json:"batch_size"`
+	Listen    string `json:"listen"`
+	Format    string `json:"format"`   //protobuf(默认)或packed
+	External  bool   `json:"external"` //发往外部, 不能与配置了noise的策略同时使用(点在聚合前发送, 无法加噪)
+
+	OTLP     otlpSinkConfig     `json:"otlp"`
+	InfluxDB influxDBSinkConfig `json:"influxdb"`
+	StatsD   statsDSinkConfig   `json:"statsd"`
+}
+
+// statsDSinkConfig 每个周期聚合后的点额外以StatsD gauge经UDP发往本机的StatsD/Telegraf
+type statsDSinkConfig struct {
+	Addr           string `json:"addr"` //如127.0.0.1:8125, 为空不发送
+	MaxPacketBytes int    `json:"max_packet_bytes"`
+	BufferSize     int    `json:"buffer_size"` //socket写缓冲, 默认系统值
+	BatchWaitMs    int    `json:"batch_wait_ms"`
+	QueueSize      int    `json:"queue_size"`
+	MetricPrefix   string `json:"metric_prefix"` //默认log.
+	External       bool   `json:"external"`      //发往外部, 配置了noise的策略发送加噪后的值
+}
+
+// influxDBSinkConfig 每个周期聚合后的点额外以line protocol写入InfluxDB v2
+type influxDBSinkConfig struct {
+	URL               string `json:"url"` //如http://127.0.0.1:8086, 为空不写入
+	Org               string `json:"org"`
+	Bucket            string `json:"bucket"`
+	Token             string `json:"token"`
+	BatchSize         int    `json:"batch_size"`
+	BatchWaitMs       int    `json:"batch_wait_ms"`
+	QueueSize         int    `json:"queue_size"`
+	TimeoutMs         int    `json:"timeout_ms"`
+	MeasurementPrefix string `json:"measurement_prefix"` //默认log.
+	External          bool   `json:"external"`           //发往外部, 配置了noise的策略写入加噪后的值
+}
+
+// otlpSinkConfig 每个周期聚合后的点额外导出到OpenTelemetry collector
+type otlpSinkConfig struct {
+	Endpoint     string            `json:"endpoint"` //如http://127.0.0.1:4317, 为空不导出
+	Protocol     string            `json:"protocol"` //grpc(默认)或http/protobuf
+	Headers      map[string]string `json:"headers"`
+	CAFile       string            `json:"ca_file"`
+	CertFile     string            `json:"cert_file"`
+	KeyFile      string            `json:"key_file"`
+	Insecure     bool              `json:"insecure"` //https时不校验证书
+	BatchSize    int               `json:"batch_size"`
+	BatchWaitMs  int               `json:"batch_wait_ms"`
+	QueueSize    int               `json:"queue_size"`
+	TimeoutMs    int               `json:"timeout_ms"`
+	MetricPrefix string            `json:"metric_prefix"` //默认log.
+	External     bool              `json:"external"`      //发往外部, 配置了noise的策略发送加噪后的值
+}
+
+type profilingConfig struct {
+	Continuous bool `json:"continuous"` //CPU profile按策略打label, 并开启/debug/pprof
+}
+
+type writeBackConfig struct {
+	Dir       string `json:"dir"` //策略的write_back_path相对于该目录, 默认./log/write_back
+	MaxSizeMB int    `json:"max_size_mb"`
+	QueueSize int    `json:"queue_size"`
+}
+
+type auditLogConfig struct {
+	Enable    bool   `json:"enable"`      //每次推送的每个点写一条json记录到审计日志
+	Path      string `json:"path"`        //默认./log/push_audit.log
+	MaxSizeMB int    `json:"max_size_mb"` //超过后轮转为.1, 默认100
+}
+
+type replayConfig struct {
+	Files  []string `json:"files"`
+	Window int      `json:"window"`
 }
 
 type Config struct {
-	Log        logConfig    `json:"log"`
-	Http       httpConfig   `json:"http"`
-	Strategy   loadConfig   `json:"strategy"`
-	Worker     workerConfig `json:"worker"`
-	Endpoint   string       `json:"endpoint"`
-	MaxCPURate float64      `json:"max_cpu_rate"`
-	MaxCPUNum  int          `json:"max_cpu_num"`
-	MaxMemRate float64      `json:"max_mem_rate"`
-	MaxMemMB   int          `json:"max_mem_MB"`
+	Log        logConfig        `json:"log"`
+	Http       httpConfig       `json:"http"`
+	Grpc       grpcConfig       `json:"grpc"`
+	Strategy   loadConfig       `json:"strategy"`
+	Worker     workerConfig     `json:"worker"`
+	Checkpoint checkpointConfig `json:"checkpoint"`
+	Replay     replayConfig     `json:"replay"`
+	Reader     readerConfig     `json:"reader"`
+	ErrorStore errorStoreConfig `json:"error_store"`
+	Sink       sinkConfig       `json:"sink"`
+	WriteBack  writeBackConfig  `json:"write_back"`
+	AuditLog   auditLogConfig   `json:"audit_log"`
+	Profiling  profilingConfig  `json:"profiling"`
+	SelfMetric selfMetricConfig `json:"self_metric"`
+	Alerting   alertingConfig   `json:"alerting"`
+	ClockSkew  clockSkewConfig  `json:"clock_skew"`
+	Endpoint   string           `json:"endpoint"`
+	MaxCPURate float64          `json:"max_cpu_rate"`
+	MaxCPUNum  int              `json:"max_cpu_num"`
+	MaxMemRate float64          `json:"max_mem_rate"`
+	MaxMemMB   int              `json:"max_mem_MB"`
 }
 
 func Conf() *Config {
@@ -51,10 +252,15 @@ func Conf() *Config {
 }
 
 var (
-	cfg        = flag.String("c", "./cfg/dev.cfg", "specify config file")
-	ConfigFile string
-	config     *Config
-	configLock = new(sync.RWMutex)
+	cfg                = flag.String("c", "./cfg/dev.cfg", "specify config file")
+	migrateCheckpoints = flag.Bool("migrate-checkpoints", false, "upgrade checkpoint file of old version in-place and exit")
+	check              = flag.Bool("check", false, "load and validate strategies, print the report and exit")
+	ConfigFile         string
+	MigrateCheckpoints bool
+	Check              bool
+	AgentVersion       string
+	config             *Config
+	configLock         = new(sync.RWMutex)
 )
 
 func InitConfig() {
@@ -71,6 +277,8 @@ func InitConfig() {
 	}
 
 	ConfigFile = cfgFile
+	MigrateCheckpoints = *migrateCheckpoints
+	Check = *check
 	dlog.Infof("use config file : %s", ConfigFile)
 
 	if bs, err := ioutil.ReadFile(cfgFile); err != nil {
@@ -90,3 +298,17 @@ func InitConfig() {
 	dlog.Infof("config file content : %v", config)
 	dlog.Infof("memory limit : %dMB", config.MaxMemMB)
 }
+
+// ReadConfig to read the config file again, e.g. on SIGHUP
+// 只返回新的配置, 不替换当前配置, 由调用方应用支持热加载的项
+func ReadConfig() (*Config, error) {
+	bs, err := ioutil.ReadFile(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := json.Unmarshal(bs, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -19,8 +19,17 @@ func InitStrategyFile() {
 	cfgFile := *strategyCfg
 	cfgFolder := *strategyFolderCfg
 
+	// 配置了strategy.etcd时从etcd加载策略
+	if config != nil && len(config.Strategy.Etcd.Endpoints) > 0 {
+		if cfgFile != "" || cfgFolder != "" {
+			dlog.Warningf("strategy.etcd is configured, [-s | -sf] will be ignored")
+		}
+		dlog.Infof("use strategy from etcd : %v", config.Strategy.Etcd.Endpoints)
+		return
+	}
+
 	if cfgFile == "" && cfgFolder == "" {
-		dlog.Fatal("strategy file/folder not specified: use [-s | -sf] $target")
+		dlog.Fatal("strategy file/folder not specified: use [-s | -sf] $target or strategy.etcd")
 		os.Exit(1)
 	}
 
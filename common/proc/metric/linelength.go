@@ -0,0 +1,191 @@
+package metric
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LineLengthBounds 行长直方图各桶的上限(字节), 最后一个桶为超过64KB的行
+var LineLengthBounds = [...]int64{128, 512, 2 * 1024, 16 * 1024, 64 * 1024}
+
+// LineLengthBuckets 行长直方图的桶数
+const LineLengthBuckets = len(LineLengthBounds) + 1
+
+// LineLengthLabels 各桶在status/prometheus中的名字
+var LineLengthLabels = [LineLengthBuckets]string{"128", "512", "2048", "16384", "65536", "+Inf"}
+
+// LineLengths to keep a fixed-bucket histogram of line lengths of one file
+// 每行只做一次比较链及原子累加, 计数累计不清零, 各桶的速率由共享的ticker衰减
+type LineLengths struct {
+	Counts [LineLengthBuckets]int64
+	Max    int64
+	Sum    int64
+	rates  [LineLengthBuckets]*EWMA
+}
+
+// LineLengthStat is a snapshot of LineLengths
+type LineLengthStat struct {
+	Counts [LineLengthBuckets]int64   `json:"counts"`   //各桶累计的行数, 上限见LineLengthLabels
+	Rates  [LineLengthBuckets]float64 `json:"rates_1m"` //各桶1分钟窗口的每秒行数
+	Max    int64                      `json:"max"`
+	Sum    int64                      `json:"sum"`
+	Lines  int64                      `json:"lines"`
+	P99    int64                      `json:"p99"` //按桶线性插值估算
+}
+
+// NewLineLengths to create an empty histogram
+func NewLineLengths() *LineLengths {
+	h := &LineLengths{}
+	for i := range h.rates {
+		h.rates[i] = NewEWMA(time.Minute, RateTick)
+	}
+	return h
+}
+
+// Observe to count one line of size bytes, without the line break
+func (h *LineLengths) Observe(size int) {
+	n := int64(size)
+	i := 0
+	for i < len(LineLengthBounds) && n > LineLengthBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.Counts[i], 1)
+	atomic.AddInt64(&h.Sum, n)
+	h.rates[i].Update(1)
+	for {
+		max := atomic.LoadInt64(&h.Max)
+		if n <= max || atomic.CompareAndSwapInt64(&h.Max, max, n) {
+			return
+		}
+	}
+}
+
+// TickElapsed to tick rates of all buckets with the elapsed period
+func (h *LineLengths) TickElapsed(elapsed time.Duration) {
+	for _, r := range h.rates {
+		r.TickElapsed(elapsed)
+	}
+}
+
+// Stat to get a snapshot
+func (h *LineLengths) Stat() LineLengthStat {
+	var s LineLengthStat
+	for i := range h.Counts {
+		s.Counts[i] = atomic.LoadInt64(&h.Counts[i])
+		s.Rates[i] = h.rates[i].Rate()
+		s.Lines += s.Counts[i]
+	}
+	s.Max = atomic.LoadInt64(&h.Max)
+	s.Sum = atomic.LoadInt64(&h.Sum)
+	s.P99 = s.Percentile(0.99)
+	return s
+}
+
+// bucketRange to get the lower & upper bound of bucket i, the upper bound is capped by max
+func (s LineLengthStat) bucketRange(i int) (int64, int64) {
+	var lower int64
+	if i > 0 {
+		lower = LineLengthBounds[i-1]
+	}
+	upper := s.Max
+	if i < len(LineLengthBounds) && LineLengthBounds[i] < upper {
+		upper = LineLengthBounds[i]
+	}
+	if upper < lower {
+		upper = lower
+	}
+	return lower, upper
+}
+
+// Percentile to estimate the q quantile of line length, interpolated linearly in the bucket
+func (s LineLengthStat) Percentile(q float64) int64 {
+	if s.Lines == 0 {
+		return 0
+	}
+	rank := q * float64(s.Lines)
+	var seen float64
+	for i, c := range s.Counts {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+		lower, upper := s.bucketRange(i)
+		return lower + int64(float64(upper-lower)*(rank-seen)/float64(c))
+	}
+	return s.Max
+}
+
+// OverFraction to estimate the fraction of lines longer than limit
+// 跨过limit的桶按线性插值计入
+func (s LineLengthStat) OverFraction(limit int64) float64 {
+	if s.Lines == 0 || s.Max <= limit {
+		return 0
+	}
+	var over float64
+	for i, c := range s.Counts {
+		lower, upper := s.bucketRange(i)
+		switch {
+		case c == 0 || upper <= limit:
+		case lower >= limit:
+			over += float64(c)
+		default:
+			over += float64(c) * float64(upper-limit) / float64(upper-lower)
+		}
+	}
+	return over / float64(s.Lines)
+}
+
+// TruncationWarning to warn when the p99 line length exceeds limit, empty if not
+func (s LineLengthStat) TruncationWarning(file string, limit int64) string {
+	if s.P99 <= limit {
+		return ""
+	}
+	return fmt.Sprintf("p99 line length %d exceeds max_line_bytes %d, you will truncate ~%.1f%% of lines on %s",
+		s.P99, limit, s.OverFraction(limit)*100, file)
+}
+
+var (
+	lineLengths     = make(map[string]*LineLengths)
+	lineLengthsLock = new(sync.RWMutex)
+)
+
+// LineLength to get the line length histogram of a file
+// 调用方持有返回值, 避免每行查map
+func LineLength(file string) *LineLengths {
+	lineLengthsLock.RLock()
+	h, ok := lineLengths[file]
+	lineLengthsLock.RUnlock()
+	if ok {
+		return h
+	}
+
+	lineLengthsLock.Lock()
+	defer lineLengthsLock.Unlock()
+	if h, ok = lineLengths[file]; !ok {
+		h = NewLineLengths()
+		lineLengths[file] = h
+	}
+	return h
+}
+
+// LineLengthStats to get snapshots of all files
+func LineLengthStats() map[string]LineLengthStat {
+	lineLengthsLock.RLock()
+	defer lineLengthsLock.RUnlock()
+	ret := make(map[string]LineLengthStat, len(lineLengths))
+	for file, h := range lineLengths {
+		ret[file] = h.Stat()
+	}
+	return ret
+}
+
+// TickLineLengths to tick rates of all files, driven by the shared ticker
+func TickLineLengths(elapsed time.Duration) {
+	lineLengthsLock.RLock()
+	for _, h := range lineLengths {
+		h.TickElapsed(elapsed)
+	}
+	lineLengthsLock.RUnlock()
+}
@@ -0,0 +1,99 @@
+package metric
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLineLengthBuckets(t *testing.T) {
+	h := NewLineLengths()
+	// 边界值落在上限所在的桶
+	for _, n := range []int{0, 128, 129, 512, 513, 2048, 2049, 16384, 16385, 65536, 65537, 100000} {
+		h.Observe(n)
+	}
+	stat := h.Stat()
+	want := [LineLengthBuckets]int64{2, 2, 2, 2, 2, 2}
+	if stat.Counts != want {
+		t.Fatalf("counts %v, want %v", stat.Counts, want)
+	}
+	if stat.Max != 100000 || stat.Lines != 12 {
+		t.Fatalf("max %d lines %d", stat.Max, stat.Lines)
+	}
+}
+
+func TestLineLengthConcurrent(t *testing.T) {
+	h := NewLineLengths()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.Observe(i*1000 + j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	stat := h.Stat()
+	if stat.Lines != 8000 || stat.Max != 7999 {
+		t.Fatalf("lines %d max %d", stat.Lines, stat.Max)
+	}
+	// 计数累计不清零, 速率由Tick衰减
+	h.TickElapsed(5 * time.Second)
+	if stat = h.Stat(); stat.Lines != 8000 || stat.Rates[3] != 5951.0/5 { //2049-7999落在16KB的桶
+		t.Fatalf("after tick %+v", stat)
+	}
+}
+
+func TestLineLengthPercentile(t *testing.T) {
+	h := NewLineLengths()
+	// 97%在100字节左右, 3%为100KB
+	for i := 0; i < 970; i++ {
+		h.Observe(100)
+	}
+	for i := 0; i < 30; i++ {
+		h.Observe(100 * 1024)
+	}
+	stat := h.Stat()
+	if stat.P99 <= 64*1024 || stat.P99 > stat.Max {
+		t.Fatalf("p99 %d, want between 64KB and max %d", stat.P99, stat.Max)
+	}
+	if f := stat.OverFraction(64 * 1024); f != 0.03 {
+		t.Fatalf("over fraction %v, want 0.03", f)
+	}
+	w := stat.TruncationWarning("/var/log/app.log", 64*1024)
+	if !strings.Contains(w, "you will truncate ~3.0% of lines on /var/log/app.log") {
+		t.Fatalf("warning %q", w)
+	}
+	// 上限更大时没有告警
+	if w := stat.TruncationWarning("/var/log/app.log", 128*1024); w != "" {
+		t.Fatalf("warning %q above max", w)
+	}
+	if f := stat.OverFraction(128 * 1024); f != 0 {
+		t.Fatalf("over fraction %v above max", f)
+	}
+}
+
+func TestLineLengthNoWarning(t *testing.T) {
+	h := NewLineLengths()
+	// 超长的行不到1%, p99未超过上限
+	for i := 0; i < 995; i++ {
+		h.Observe(300)
+	}
+	for i := 0; i < 5; i++ {
+		h.Observe(200 * 1024)
+	}
+	stat := h.Stat()
+	if stat.P99 > 512 {
+		t.Fatalf("p99 %d, want in the 512 bucket", stat.P99)
+	}
+	if w := stat.TruncationWarning("/var/log/app.log", 64*1024); w != "" {
+		t.Fatalf("unexpected warning %q", w)
+	}
+	// 上限在桶中间时按插值估算
+	if f := stat.OverFraction(256); f <= 0.5 || f >= 1 {
+		t.Fatalf("over fraction %v of limit in bucket", f)
+	}
+}
@@ -2,7 +2,9 @@ package metric
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
@@ -23,6 +25,13 @@ func (m *MetricTags) HasKey(k string) bool {
 	}
 }
 
+// Get to get the count of k, 0 if not counted
+func (m *MetricTags) Get(k string) int64 {
+	m.RLock()
+	defer m.RUnlock()
+	return m.Counters[k]
+}
+
 func (m *MetricTags) AddCount(k string, v int64) {
 	m.Lock()
 	defer m.Unlock()
@@ -41,6 +50,17 @@ type SelfMonitMetrics struct {
 	DropLineCnt     *MetricTags `json:"drop_line_cnt"`
 	AnalysisCnt     *MetricTags `json:"analysis_cnt"`
 	AnalysisSuccCnt *MetricTags `json:"analysis_succ_cnt"`
+	ReplayLineCnt   *MetricTags `json:"replay_line_cnt"`
+	FormatChangeCnt *MetricTags `json:"format_change_cnt"`
+	PausedLineCnt   *MetricTags `json:"paused_line_cnt"`
+	AnomalyCnt      *MetricTags `json:"anomaly_cnt"`
+	WriteBackDrop   *MetricTags `json:"write_back_drop_cnt"`
+	SinkSentCnt     *MetricTags `json:"sink_sent_cnt"`    //各sink送达的点数, tag为sink
+	AggregatedCnt   *MetricTags `json:"aggregated_cnt"`   //worker内按step合并后合入counter的点数
+	SinkErrorCnt    *MetricTags `json:"sink_error_cnt"`   //各sink被拒绝而丢弃的点数
+	ReadTimeoutCnt  *MetricTags `json:"read_timeout_cnt"` //等不到换行而丢弃的半行数
+	LimitedCnt      int64       `json:"limited_cnt"`
+	SinkDropCnt     int64       `json:"sink_drop_cnt"`
 	PushCnt         int64       `json:"push_cnt"`
 	PushErrorCnt    int64       `json:"push_err_cnt"`
 	PushLatency     int64       `json:"push_latency"`
@@ -49,6 +69,14 @@ type SelfMonitMetrics struct {
 
 var (
 	globalSelfMonit *SelfMonitMetrics = newSelfMonitMetrics()
+
+	// 没有读权限的文件, 状态持续期间每个周期都上报
+	permissionDenied     = make(map[string]struct{})
+	permissionDeniedLock = new(sync.RWMutex)
+
+	// 超过max_file_size_warning_mb的文件及其大小(MB), 状态持续期间每个周期都上报
+	fileSizeWarnings     = make(map[string]float64)
+	fileSizeWarningsLock = new(sync.RWMutex)
 )
 
 func newSelfMonitMetrics() *SelfMonitMetrics {
@@ -58,6 +86,15 @@ func newSelfMonitMetrics() *SelfMonitMetrics {
 		DropLineCnt:     newMetricTags(),
 		AnalysisCnt:     newMetricTags(),
 		AnalysisSuccCnt: newMetricTags(),
+		ReplayLineCnt:   newMetricTags(),
+		FormatChangeCnt: newMetricTags(),
+		PausedLineCnt:   newMetricTags(),
+		AnomalyCnt:      newMetricTags(),
+		WriteBackDrop:   newMetricTags(),
+		SinkSentCnt:     newMetricTags(),
+		AggregatedCnt:   newMetricTags(),
+		SinkErrorCnt:    newMetricTags(),
+		ReadTimeoutCnt:  newMetricTags(),
 		PushCnt:         0,
 		PushErrorCnt:    0,
 		PushLatency:     0,
@@ -87,17 +124,40 @@ func HandleMetrics(step int64) {
 
 	logFormat := fmt.Sprintf("self monit [metric:%%s][tms:%d][value:%%v]", tms)
 	dlog.Debugf(logFormat, "log.agent.mem.used.mb", statSelfMonit.MemUsedMB)
+	dlog.Debugf(logFormat, "log.agent.limited.cnt", statSelfMonit.LimitedCnt)
+	dlog.Debugf(logFormat, "log.agent.sink.drop.cnt", statSelfMonit.SinkDropCnt)
 	dlog.Debugf(logFormat, "log.agent.push.cnt", statSelfMonit.PushCnt)
 	dlog.Debugf(logFormat, "log.agent.push.err.cnt", statSelfMonit.PushErrorCnt)
 	dlog.Debugf(logFormat, "log.agent.read.line.cnt", statSelfMonit.ReadLineCnt)
 	dlog.Debugf(logFormat, "log.agent.drop.line.cnt", statSelfMonit.DropLineCnt)
 	dlog.Debugf(logFormat, "log.agent.analysis.cnt", statSelfMonit.AnalysisCnt)
 	dlog.Debugf(logFormat, "log.agent.analysis.succ", statSelfMonit.AnalysisSuccCnt)
+	dlog.Debugf(logFormat, "log.agent.replay.line.cnt", statSelfMonit.ReplayLineCnt)
+	dlog.Debugf(logFormat, "log.agent.file.format_change", statSelfMonit.FormatChangeCnt)
+	dlog.Debugf(logFormat, "log.agent.paused.line.cnt", statSelfMonit.PausedLineCnt)
+	dlog.Debugf(logFormat, "log.agent.anomaly.cnt", statSelfMonit.AnomalyCnt)
+	dlog.Debugf(logFormat, "log.agent.write_back.drop.cnt", statSelfMonit.WriteBackDrop)
+	dlog.Debugf(logFormat, "log.agent.sink.sent.cnt", statSelfMonit.SinkSentCnt)
+	dlog.Debugf(logFormat, "log.agent.sink.err.cnt", statSelfMonit.SinkErrorCnt)
+	dlog.Debugf(logFormat, "log.agent.aggregated.cnt", statSelfMonit.AggregatedCnt)
+	dlog.Debugf(logFormat, "log.agent.read.timeout.cnt", statSelfMonit.ReadTimeoutCnt)
 
 	if statSelfMonit.PushCnt != 0 {
 		latency := statSelfMonit.PushLatency / statSelfMonit.PushCnt
 		dlog.Debugf(logFormat, "log.agent.push.latency.avg", latency)
 	}
+
+	fileLogFormat := fmt.Sprintf("self monit [metric:%%s][tms:%d][tags:file=%%s][value:%%v]", tms)
+	for file, stat := range ThroughputStats() {
+		dlog.Debugf(fileLogFormat, "log.agent.file.lines_rate", file, stat.LinesRate1m)
+		dlog.Debugf(fileLogFormat, "log.agent.file.bytes_rate", file, stat.BytesRate1m)
+	}
+	for _, file := range PermissionDeniedFiles() {
+		dlog.Debugf(fileLogFormat, "log.agent.file.permission_denied", file, 1)
+	}
+	for file, mb := range FileSizeWarnings() {
+		dlog.Debugf(fileLogFormat, "log.agent.file.size_warning_mb", file, mb)
+	}
 }
 
 func MetricMem(size int64) {
@@ -120,6 +180,129 @@ func MetricAnalysisSucc(file string, num int64) {
 	globalSelfMonit.AnalysisSuccCnt.AddCount(file, num)
 }
 
+func MetricReplayLine(file string, num int64) {
+	globalSelfMonit.ReplayLineCnt.AddCount(file, num)
+}
+
+func MetricFormatChange(file string, num int64) {
+	globalSelfMonit.FormatChangeCnt.AddCount(file, num)
+}
+
+func MetricPausedLine(file string, num int64) {
+	globalSelfMonit.PausedLineCnt.AddCount(file, num)
+}
+
+func MetricAnomalyPoint(file string, num int64) {
+	globalSelfMonit.AnomalyCnt.AddCount(file, num)
+}
+
+func MetricAggregated(file string, num int64) {
+	globalSelfMonit.AggregatedCnt.AddCount(file, num)
+}
+
+func MetricWriteBackDrop(path string, num int64) {
+	globalSelfMonit.WriteBackDrop.AddCount(path, num)
+}
+
+func MetricReadTimeout(file string, num int64) {
+	globalSelfMonit.ReadTimeoutCnt.AddCount(file, num)
+}
+
+// SetPermissionDenied to mark whether the file is not readable for permission
+func SetPermissionDenied(file string, denied bool) {
+	permissionDeniedLock.Lock()
+	defer permissionDeniedLock.Unlock()
+	if denied {
+		permissionDenied[file] = struct{}{}
+	} else {
+		delete(permissionDenied, file)
+	}
+}
+
+// PermissionDeniedFiles to get files not readable for permission
+func PermissionDeniedFiles() []string {
+	permissionDeniedLock.RLock()
+	defer permissionDeniedLock.RUnlock()
+	ret := make([]string, 0, len(permissionDenied))
+	for file := range permissionDenied {
+		ret = append(ret, file)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// MetricFileSizeWarning to mark the file larger than max_file_size_warning_mb with its size
+func MetricFileSizeWarning(filePath string, sizeMB float64) {
+	fileSizeWarningsLock.Lock()
+	defer fileSizeWarningsLock.Unlock()
+	fileSizeWarnings[filePath] = sizeMB
+}
+
+// ClearFileSizeWarning to unmark the file after it shrinks below the threshold or is no longer read
+func ClearFileSizeWarning(filePath string) {
+	fileSizeWarningsLock.Lock()
+	defer fileSizeWarningsLock.Unlock()
+	delete(fileSizeWarnings, filePath)
+}
+
+// FileSizeWarnings to get the size(MB) of files larger than max_file_size_warning_mb
+func FileSizeWarnings() map[string]float64 {
+	fileSizeWarningsLock.RLock()
+	defer fileSizeWarningsLock.RUnlock()
+	ret := make(map[string]float64, len(fileSizeWarnings))
+	for file, mb := range fileSizeWarnings {
+		ret[file] = mb
+	}
+	return ret
+}
+
+// FileCount is the counts of one file in the current self-metric period
+type FileCount struct {
+	Read         int64 `json:"read"`
+	Dropped      int64 `json:"dropped"`
+	ReadTimeout  int64 `json:"read_timeout"`
+	Analysis     int64 `json:"analysis"`
+	AnalysisSucc int64 `json:"analysis_succ"`
+	FormatChange int64 `json:"format_change"`
+}
+
+// FileCounts to get the counts of the file since the current period started, reset by HandleMetrics
+func FileCounts(file string) FileCount {
+	m := globalSelfMonit
+	return FileCount{
+		Read:         m.ReadLineCnt.Get(file),
+		Dropped:      m.DropLineCnt.Get(file),
+		ReadTimeout:  m.ReadTimeoutCnt.Get(file),
+		Analysis:     m.AnalysisCnt.Get(file),
+		AnalysisSucc: m.AnalysisSuccCnt.Get(file),
+		FormatChange: m.FormatChangeCnt.Get(file),
+	}
+}
+
+// IsPermissionDenied to check whether the file is not readable for permission
+func IsPermissionDenied(file string) bool {
+	permissionDeniedLock.RLock()
+	defer permissionDeniedLock.RUnlock()
+	_, ok := permissionDenied[file]
+	return ok
+}
+
+func MetricLimitedPoint(num int64) {
+	atomic.AddInt64(&globalSelfMonit.LimitedCnt, num)
+}
+
+func MetricSinkDropPoint(num int64) {
+	atomic.AddInt64(&globalSelfMonit.SinkDropCnt, num)
+}
+
+func MetricSinkSent(sink string, num int64) {
+	globalSelfMonit.SinkSentCnt.AddCount(sink, num)
+}
+
+func MetricSinkError(sink string, num int64) {
+	globalSelfMonit.SinkErrorCnt.AddCount(sink, num)
+}
+
 func MetricPushCnt(num int64, succ bool) {
 	globalSelfMonit.PushCnt = globalSelfMonit.PushCnt + num
 	if !succ {
@@ -0,0 +1,184 @@
+package metric
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateTick 速率统计的衰减周期
+const RateTick = 5 * time.Second
+
+// EWMA to calculate exponentially weighted moving average rate
+// 窗口速率统计, Update只做原子累加, 由Tick按固定周期衰减
+type EWMA struct {
+	sync.Mutex
+	uncounted int64
+	alpha     float64
+	window    time.Duration
+	tick      time.Duration
+	rate      float64
+	init      bool
+}
+
+// NewEWMA to create a rate over the window, ticked every tick
+func NewEWMA(window, tick time.Duration) *EWMA {
+	return &EWMA{
+		alpha:  1 - math.Exp(-float64(tick)/float64(window)),
+		window: window,
+		tick:   tick,
+	}
+}
+
+// Update to add n events
+func (e *EWMA) Update(n int64) {
+	atomic.AddInt64(&e.uncounted, n)
+}
+
+// Tick to fold events since last tick into the rate
+func (e *EWMA) Tick() {
+	e.fold(e.tick, e.alpha)
+}
+
+// TickElapsed to fold events of the elapsed period into the rate
+// 周期由共享的ticker驱动, 长度可能变化(修改间隔、不完整的周期), 衰减系数按实际长度计算
+func (e *EWMA) TickElapsed(elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	e.fold(elapsed, 1-math.Exp(-float64(elapsed)/float64(e.window)))
+}
+
+func (e *EWMA) fold(elapsed time.Duration, alpha float64) {
+	count := atomic.SwapInt64(&e.uncounted, 0)
+	instant := float64(count) / elapsed.Seconds()
+
+	e.Lock()
+	if e.init {
+		e.rate += alpha * (instant - e.rate)
+	} else {
+		e.rate = instant
+		e.init = true
+	}
+	e.Unlock()
+}
+
+// Rate to get events per second
+func (e *EWMA) Rate() float64 {
+	e.Lock()
+	defer e.Unlock()
+	return e.rate
+}
+
+// FileThroughput to count lines & bytes read from one file
+// 在reader处统计, 包含被丢弃的行, 反映的是读入量而非分析量
+type FileThroughput struct {
+	Lines      int64
+	Bytes      int64
+	lineRate1  *EWMA
+	lineRate15 *EWMA
+	byteRate1  *EWMA
+	byteRate15 *EWMA
+}
+
+// ThroughputStat is a snapshot of FileThroughput
+type ThroughputStat struct {
+	Lines        int64   `json:"lines"`
+	Bytes        int64   `json:"bytes"`
+	LinesRate1m  float64 `json:"lines_rate_1m"`
+	LinesRate15m float64 `json:"lines_rate_15m"`
+	BytesRate1m  float64 `json:"bytes_rate_1m"`
+	BytesRate15m float64 `json:"bytes_rate_15m"`
+}
+
+func newFileThroughput() *FileThroughput {
+	return &FileThroughput{
+		lineRate1:  NewEWMA(time.Minute, RateTick),
+		lineRate15: NewEWMA(15*time.Minute, RateTick),
+		byteRate1:  NewEWMA(time.Minute, RateTick),
+		byteRate15: NewEWMA(15*time.Minute, RateTick),
+	}
+}
+
+// Add to count one line of size bytes
+func (t *FileThroughput) Add(size int) {
+	atomic.AddInt64(&t.Lines, 1)
+	atomic.AddInt64(&t.Bytes, int64(size))
+	t.lineRate1.Update(1)
+	t.lineRate15.Update(1)
+	t.byteRate1.Update(int64(size))
+	t.byteRate15.Update(int64(size))
+}
+
+// Tick to tick all rates
+func (t *FileThroughput) Tick() {
+	t.lineRate1.Tick()
+	t.lineRate15.Tick()
+	t.byteRate1.Tick()
+	t.byteRate15.Tick()
+}
+
+// TickElapsed to tick all rates with the elapsed period
+func (t *FileThroughput) TickElapsed(elapsed time.Duration) {
+	t.lineRate1.TickElapsed(elapsed)
+	t.lineRate15.TickElapsed(elapsed)
+	t.byteRate1.TickElapsed(elapsed)
+	t.byteRate15.TickElapsed(elapsed)
+}
+
+// Stat to get a snapshot
+func (t *FileThroughput) Stat() ThroughputStat {
+	return ThroughputStat{
+		Lines:        atomic.LoadInt64(&t.Lines),
+		Bytes:        atomic.LoadInt64(&t.Bytes),
+		LinesRate1m:  t.lineRate1.Rate(),
+		LinesRate15m: t.lineRate15.Rate(),
+		BytesRate1m:  t.byteRate1.Rate(),
+		BytesRate15m: t.byteRate15.Rate(),
+	}
+}
+
+var (
+	throughputs     = make(map[string]*FileThroughput)
+	throughputsLock = new(sync.RWMutex)
+)
+
+// Throughput to get the throughput counter of a file
+// 调用方持有返回值, 避免每行查map
+func Throughput(file string) *FileThroughput {
+	throughputsLock.RLock()
+	t, ok := throughputs[file]
+	throughputsLock.RUnlock()
+	if ok {
+		return t
+	}
+
+	throughputsLock.Lock()
+	defer throughputsLock.Unlock()
+	if t, ok = throughputs[file]; !ok {
+		t = newFileThroughput()
+		throughputs[file] = t
+	}
+	return t
+}
+
+// ThroughputStats to get snapshots of all files
+func ThroughputStats() map[string]ThroughputStat {
+	throughputsLock.RLock()
+	defer throughputsLock.RUnlock()
+	ret := make(map[string]ThroughputStat, len(throughputs))
+	for file, t := range throughputs {
+		ret[file] = t.Stat()
+	}
+	return ret
+}
+
+// TickThroughputs to tick rates of all files, driven by the shared ticker
+func TickThroughputs(elapsed time.Duration) {
+	throughputsLock.RLock()
+	for _, t := range throughputs {
+		t.TickElapsed(elapsed)
+	}
+	throughputsLock.RUnlock()
+}
@@ -0,0 +1,83 @@
+package metric
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestThroughputRateConverge(t *testing.T) {
+	tp := newFileThroughput()
+
+	// 先以2行/s跑一段, 再切到20行/s(每行50字节), 验证速率收敛
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			tp.Add(50)
+		}
+		tp.Tick()
+	}
+	// 45分钟, 足够15m窗口收敛
+	for i := 0; i < 540; i++ {
+		for j := 0; j < 100; j++ {
+			tp.Add(50)
+		}
+		tp.Tick()
+	}
+
+	stat := tp.Stat()
+	if stat.Lines != 20*10+540*100 {
+		t.Errorf("unexpected lines: %d", stat.Lines)
+	}
+	if stat.Bytes != stat.Lines*50 {
+		t.Errorf("unexpected bytes: %d", stat.Bytes)
+	}
+
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"lines_rate_1m", stat.LinesRate1m, 20},
+		{"lines_rate_15m", stat.LinesRate15m, 20},
+		{"bytes_rate_1m", stat.BytesRate1m, 1000},
+		{"bytes_rate_15m", stat.BytesRate15m, 1000},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want)/c.want > 0.05 {
+			t.Errorf("%s not converged: got %v want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestEWMAFirstTick(t *testing.T) {
+	e := NewEWMA(RateTick*12, RateTick)
+	e.Update(50)
+	e.Tick()
+	if e.Rate() != 10 {
+		t.Errorf("first tick should take instant rate, got %v", e.Rate())
+	}
+	e.Tick()
+	if e.Rate() >= 10 {
+		t.Errorf("rate should decay without events, got %v", e.Rate())
+	}
+}
+
+func TestEWMATickElapsed(t *testing.T) {
+	a, b := NewEWMA(time.Minute, RateTick), NewEWMA(time.Minute, RateTick)
+	// 按RateTick推进时与Tick一致, 不同长度的周期按实际长度折算速率
+	for i := 0; i < 10; i++ {
+		a.Update(50)
+		b.Update(50)
+		a.Tick()
+		b.TickElapsed(RateTick)
+	}
+	if math.Abs(a.Rate()-b.Rate()) > 1e-9 || math.Abs(a.Rate()-10) > 1e-9 {
+		t.Fatalf("unexpected rates %v %v", a.Rate(), b.Rate())
+	}
+	b.Update(300)
+	b.TickElapsed(30 * time.Second)
+	if math.Abs(b.Rate()-10) > 1e-9 {
+		t.Fatalf("rate of a 30s period should stay 10, got %v", b.Rate())
+	}
+	b.TickElapsed(0)
+}
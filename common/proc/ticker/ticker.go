@@ -0,0 +1,232 @@
+// Package ticker drives all self-metric reporters from one wall-clock aligned ticker
+// 所有自监控的上报(读入/丢弃行数、分析行数、吞吐速率等)共用一个ticker, 修改间隔对所有上报同时生效
+package ticker
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval 默认的上报间隔
+	DefaultInterval = 10 * time.Second
+	MinInterval     = time.Second
+	MaxInterval     = 300 * time.Second
+	// 各机器的上报时间在对齐点之后错开, 最多错开间隔的该比例
+	jitterRatio = 4
+)
+
+// Clock to get the time, replaced by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Window is the period covered by one report
+// 正常的窗口为[k*interval+jitter, (k+1)*interval+jitter); 启动后的第一个窗口、修改间隔时及注销时的窗口不完整, Partial为true
+type Window struct {
+	Start   time.Time
+	End     time.Time
+	Partial bool
+}
+
+// Duration to get the length of the window
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// Reporter reports the deltas of its counters in the window
+// 上报方自己记录上次上报时的累计值, 每个窗口上报差值, 各窗口之和等于累计值
+type Reporter func(w Window)
+
+// Registration is a registered reporter
+type Registration struct {
+	s    *Service
+	id   int64
+	name string
+}
+
+// Service to call reporters on aligned windows
+type Service struct {
+	clock Clock
+	host  string
+
+	lock      sync.Mutex //保护interval及reporters
+	interval  time.Duration
+	reporters map[int64]*reporterEntry
+	nextID    int64
+	reset     chan struct{}
+
+	fireLock sync.Mutex //上报串行执行, 同一个reporter不会并发调用
+}
+
+type reporterEntry struct {
+	name string
+	fn   Reporter
+	last time.Time //该reporter上一次上报的窗口结束时间, 注册之后的第一个窗口从注册时开始
+}
+
+// New to create a service, host is used to compute the jitter
+func New(clock Clock, host string, interval time.Duration) *Service {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Service{
+		clock:     clock,
+		host:      host,
+		interval:  ClampInterval(interval),
+		reporters: make(map[int64]*reporterEntry),
+		reset:     make(chan struct{}, 1),
+	}
+}
+
+// ClampInterval to limit the interval to [1s, 300s], 0 means the default
+func ClampInterval(d time.Duration) time.Duration {
+	switch {
+	case d <= 0:
+		return DefaultInterval
+	case d < MinInterval:
+		return MinInterval
+	case d > MaxInterval:
+		return MaxInterval
+	}
+	return d
+}
+
+// HostJitter to get the fixed offset of the host within the interval
+// 按主机名hash, 同一台机器每次启动都相同, 不同机器的上报错开
+func HostJitter(host string, interval time.Duration) time.Duration {
+	max := int64(interval / jitterRatio)
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// Interval to get the current interval
+func (s *Service) Interval() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.interval
+}
+
+// SetInterval to change the interval, e.g. on config reload
+// 正在进行的窗口立即结束, 作为不完整的窗口上报(不按比例折算), 之后按新的间隔对齐; 不会丢失或重复计数
+func (s *Service) SetInterval(d time.Duration) {
+	d = ClampInterval(d)
+	s.lock.Lock()
+	changed := d != s.interval
+	s.interval = d
+	s.lock.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case s.reset <- struct{}{}:
+	default:
+	}
+}
+
+// Register to add a reporter, called on every window until unregistered
+// reporter在ticker的goroutine中串行调用, 不能在其中调用Unregister
+func (s *Service) Register(name string, fn Reporter) *Registration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nextID++
+	s.reporters[s.nextID] = &reporterEntry{name: name, fn: fn, last: s.clock.Now()}
+	return &Registration{s: s, id: s.nextID, name: name}
+}
+
+// Unregister to remove the reporter after reporting the partial window since its last report
+// 停止前把最后不完整的窗口也上报掉, 计数不会丢
+func (r *Registration) Unregister() {
+	s := r.s
+	s.fireLock.Lock()
+	defer s.fireLock.Unlock()
+	s.lock.Lock()
+	e, ok := s.reporters[r.id]
+	delete(s.reporters, r.id)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	now := s.clock.Now()
+	e.fn(Window{Start: e.last, End: now, Partial: true})
+}
+
+// next to get the next aligned report time after now
+func (s *Service) next(now time.Time) time.Time {
+	s.lock.Lock()
+	interval, host := s.interval, s.host
+	s.lock.Unlock()
+	jitter := HostJitter(host, interval)
+	return now.Add(-jitter).Truncate(interval).Add(interval + jitter)
+}
+
+// fire to call all reporters with the window ending at end
+func (s *Service) fire(end time.Time, partial bool) {
+	s.fireLock.Lock()
+	defer s.fireLock.Unlock()
+
+	s.lock.Lock()
+	interval := s.interval
+	entries := make([]*reporterEntry, 0, len(s.reporters))
+	ids := make([]int64, 0, len(s.reporters))
+	for id := range s.reporters {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		entries = append(entries, s.reporters[id])
+	}
+	s.lock.Unlock()
+
+	for _, e := range entries {
+		w := Window{Start: e.last, End: end}
+		w.Partial = partial || w.Duration() != interval
+		e.fn(w)
+		e.last = end
+	}
+}
+
+// Run to report until stop is closed
+func (s *Service) Run(stop <-chan struct{}) {
+	for {
+		now := s.clock.Now()
+		next := s.next(now)
+		select {
+		case <-s.clock.After(next.Sub(now)):
+			s.fire(next, false)
+		case <-s.reset:
+			s.fire(s.clock.Now(), true)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Default is the service of the agent, started by Start
+var Default = New(nil, "", DefaultInterval)
+
+// Start to run the default service with the host and interval
+func Start(host string, interval time.Duration) {
+	Default.lock.Lock()
+	Default.host = host
+	Default.interval = ClampInterval(interval)
+	Default.lock.Unlock()
+	go Default.Run(nil)
+}
+
+// Register to add a reporter to the default service
+func Register(name string, fn Reporter) *Registration {
+	return Default.Register(name, fn)
+}
@@ -0,0 +1,234 @@
+package ticker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when Advance is called
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	added   chan struct{}
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, added: make(chan struct{}, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	c.added <- struct{}{}
+	return w.c
+}
+
+// waitTimer to wait until the service is waiting on the clock
+func (c *fakeClock) waitTimer(t *testing.T) {
+	select {
+	case <-c.added:
+	case <-time.After(3 * time.Second):
+		t.Fatal("service is not waiting on the clock")
+	}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	rest := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.at.After(c.now) {
+			w.c <- c.now
+		} else {
+			rest = append(rest, w)
+		}
+	}
+	c.waiters = rest
+	c.Unlock()
+}
+
+type windowLog struct {
+	sync.Mutex
+	windows []Window
+	fired   chan struct{}
+}
+
+func newWindowLog() *windowLog {
+	return &windowLog{fired: make(chan struct{}, 100)}
+}
+
+func (l *windowLog) report(w Window) {
+	l.Lock()
+	l.windows = append(l.windows, w)
+	l.Unlock()
+	l.fired <- struct{}{}
+}
+
+func (l *windowLog) wait(t *testing.T) Window {
+	select {
+	case <-l.fired:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no report")
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.windows[len(l.windows)-1]
+}
+
+func TestAlignment(t *testing.T) {
+	host := "host-a"
+	jitter := HostJitter(host, 10*time.Second)
+	if jitter < 0 || jitter >= 10*time.Second/jitterRatio || jitter != HostJitter(host, 10*time.Second) {
+		t.Fatalf("unexpected jitter %v", jitter)
+	}
+	if HostJitter("host-b", 10*time.Second) == jitter && HostJitter("host-c", 10*time.Second) == jitter {
+		t.Fatal("jitter should differ between hosts")
+	}
+
+	start := time.Unix(1700000003, 0)
+	clock := newFakeClock(start)
+	s := New(clock, host, 10*time.Second)
+	log := newWindowLog()
+	s.Register("test", log.report)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+
+	// 第一个窗口从注册时开始, 不完整
+	clock.waitTimer(t)
+	clock.Advance(10 * time.Second)
+	w := log.wait(t)
+	boundary := time.Unix(1700000010, 0).Add(jitter)
+	if !w.Start.Equal(start) || !w.End.Equal(boundary) || !w.Partial {
+		t.Fatalf("unexpected first window: %+v, boundary %v", w, boundary)
+	}
+	// 之后的窗口按间隔对齐
+	for i := 0; i < 3; i++ {
+		clock.waitTimer(t)
+		clock.Advance(10 * time.Second)
+		w = log.wait(t)
+		if w.Partial || w.Duration() != 10*time.Second || (w.End.Sub(time.Unix(0, 0))-jitter)%(10*time.Second) != 0 {
+			t.Fatalf("window %d not aligned: %+v", i, w)
+		}
+	}
+}
+
+func TestIntervalReload(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	s := New(clock, "host-a", 10*time.Second)
+
+	var total, reported, swp int64
+	log := newWindowLog()
+	s.Register("counter", func(w Window) {
+		a := atomic.LoadInt64(&total)
+		reported += a - swp
+		swp = a
+		log.report(w)
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+
+	// 每次推进到下一个对齐点
+	tick := func(n int64) Window {
+		atomic.AddInt64(&total, n)
+		clock.waitTimer(t)
+		now := clock.Now()
+		clock.Advance(s.next(now).Sub(now))
+		return log.wait(t)
+	}
+	first := tick(5)
+	last := tick(7)
+	if !first.Partial || last.Partial || !last.Start.Equal(first.End) {
+		t.Fatalf("unexpected windows: %+v %+v", first, last)
+	}
+
+	// 窗口进行到一半时改为30s: 当前窗口立即作为不完整窗口上报(不折算), 不丢不重
+	atomic.AddInt64(&total, 3)
+	clock.waitTimer(t)
+	clock.Advance(4 * time.Second)
+	s.SetInterval(30 * time.Second)
+	w := log.wait(t)
+	if !w.Partial || !w.Start.Equal(last.End) || w.Duration() != 4*time.Second {
+		t.Fatalf("unexpected reload window: %+v, last %+v", w, last)
+	}
+	last = w
+
+	// 之后按30s对齐, 窗口首尾相接
+	jitter := HostJitter("host-a", 30*time.Second)
+	for i := 0; i < 3; i++ {
+		w = tick(int64(i + 1))
+		if !w.Start.Equal(last.End) || (w.End.Sub(time.Unix(0, 0))-jitter)%(30*time.Second) != 0 {
+			t.Fatalf("window %d after reload not aligned: %+v", i, w)
+		}
+		if i > 0 && (w.Partial || w.Duration() != 30*time.Second) {
+			t.Fatalf("window %d after reload should be complete: %+v", i, w)
+		}
+		last = w
+	}
+	if i := s.Interval(); i != 30*time.Second {
+		t.Fatalf("unexpected interval %v", i)
+	}
+	// 相同的间隔不触发
+	s.SetInterval(30 * time.Second)
+	select {
+	case <-log.fired:
+		t.Fatal("same interval should not report")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 各窗口的差值之和等于累计值
+	if reported != atomic.LoadInt64(&total) {
+		t.Fatalf("reported %d, total %d", reported, total)
+	}
+}
+
+func TestUnregisterFlushes(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	s := New(clock, "h", time.Minute)
+	log := newWindowLog()
+	r := s.Register("test", log.report)
+	clock.Advance(7 * time.Second)
+	r.Unregister()
+	w := log.wait(t)
+	if !w.Partial || w.Duration() != 7*time.Second {
+		t.Fatalf("unexpected final window: %+v", w)
+	}
+	// 重复注销不再上报
+	r.Unregister()
+	select {
+	case <-log.fired:
+		t.Fatal("should report once")
+	default:
+	}
+}
+
+func TestClampInterval(t *testing.T) {
+	cases := map[time.Duration]time.Duration{
+		0:                DefaultInterval,
+		time.Millisecond: MinInterval,
+		time.Hour:        MaxInterval,
+		time.Minute:      time.Minute,
+	}
+	for in, want := range cases {
+		if got := ClampInterval(in); got != want {
+			t.Errorf("ClampInterval(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
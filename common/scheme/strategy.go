@@ -1,6 +1,16 @@
 package scheme
 
-import "regexp"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/expr"
+)
 
 /*
 Name		- 监控策略名
@@ -13,25 +23,552 @@ Tags		- Tags
 Func		- 采集方式（max/min/avg/cnt）
 Degree		- 精度位数
 Comment		- 备注
+MaxLagSeconds	- 可接受的最大处理延迟, 处理跟不上时, 未声明或容忍度更大的策略会被暂停
+Status		- 加载时校验发现的问题, 为空表示正常
+Warnings	- 加载时的提示, 不影响策略生效, 如tag正则可能捕获超长的值
+RegexpBudget	- 调高本策略的正则大小预算(编译后的指令数), 不能超过全局的regexp_hard_limit
+RegexpSize	- 加载时测得的正则大小
+ParseMode	- 解析方式, 为空表示按正则匹配整行, logfmt表示按 key=value 解析, json表示每行是一个JSON对象, windows_event_xml表示每行是Windows事件的XML, auto表示启动时按文件开头的行检测
+TimeField	- logfmt模式下时间所在的key, 为空则在整行中匹配时间
+TagFields	- 按parse_mode解析出的key取tag, 如{"code": "status"}, 不需要写正则
+Template	- 内置的模板, 如nginx_json, 预先填好parse_mode、time_field、time_format及tag_fields, 策略中配置的字段优先
+ValueField	- logfmt模式下取值的key, 值可带单位(如42ms), 取开头的数字
+ChangeSet	- 所属的change-set, 同一change-set的策略全部校验通过才一起生效, 否则整组沿用旧版本
+ChangeSetVersion	- change-set的版本
+Variant		- A/B测试的候选策略, 未填写的字段沿用本策略
+VariantWeight	- 按该比例(0.0-1.0)的日志行使用Variant计算, 点带有variant=control/test的tag
+ValueGroup	- 取值的捕获组, 序号或命名分组的名字, 默认为1
+ValueIndex	- 加载时由ValueGroup解析出的捕获组序号
+AnomalyDetect	- 是否检测异常值, 与最近60个值的均值偏离超过阈值个标准差的点带上anomaly=true的tag
+AnomalyStddevThreshold	- 异常的标准差倍数, 默认3
+AnomalySuppress	- 异常的点不推送
+PatternRegFlags	- pattern的正则标志, i忽略大小写, m多行(^$匹配行首尾), s让.匹配换行, 如"is"
+TimeRegFlags	- 时间正则的标志, 取值同PatternRegFlags
+WriteBackPath	- 调试用, 把匹配的行及解析出的点写到该文件
+CompositeOf	- 组合策略引用的策略ID, 配置后本策略不匹配日志, 所有引用的策略在窗口内都产生了点时, 按CompositeExpr计算出一个点
+CompositeWindowSecs	- 组合的时间窗口(秒), 超过窗口未凑齐的值被丢弃, 默认为step
+CompositeExpr	- 组合的计算表达式, 用$id引用各策略的值, 如($12 - $11) * 1000
+ValueRange	- 取值的合法范围, 超出范围的值按OnOutOfRange处理(drop丢弃/clamp取最近的边界/keep保留), 在异常检测之前
+ValueRoundDecimals	- 取值保留的小数位数, 不配置或为负数时不处理, NaN和Inf保持不变
+GapSeconds	- Func为episodes时, 间隔超过该秒数的匹配行算作新的一次
+RetireAt	- 策略的退役时间(RFC 3339), 之后不再计算, 在[RetireAt, RetireAt+step)内推送一次RetirementValue(时间戳为RetireAt之后的第一个周期), 告知下游指标是主动下线而不是丢失
+RetirementValue	- 退役时推送的值
+TagLimits	- 各tag取值的长度限制, 如{"url": {"max_len": 128, "on_oversize": "drop"}}, max_len默认取全局的worker.max_tag_value_len(255), 超长时truncate(默认)截断或drop丢弃该点
+MaxTagSets	- 单周期内最多的tag组合数, 默认5000, 负数不限制; 超过后新的组合合并到overflow=true的序列, 并推送suppressed_tag_sets
+FirstPeriod	- 开始消费文件后第一个不完整周期的处理方式, emit(默认)照常推送, suppress不推送, partial_tag推送并带上partial=true的tag
+MaskPatterns	- 脱敏规则, 在匹配之前把行中命中regex的部分替换为replacement(默认[REDACTED]), 避免卡号、邮箱等进入tag及调试输出
+MustNotContain	- 匹配了pattern的行中, 包含其中任一项的不计入本策略, 如缺少traceid的请求用["traceid="]; 不含正则元字符的按字面量查找, 在exclude之前
+TagTypes	- 内置的tag类型, 如{"src": "ipv6"}, 该tag使用内置的正则(ipv4/ipv6/mac/uuid), tags中可以不写或写相同的正则
+Generation	- 加载时生成, 本策略当前定义首次发布时策略表的代数, 定义不变的重新加载沿用原值
+EndpointSource	- 点的endpoint, 为空使用agent的endpoint, tag:<tagname>使用该tag的取值(从tag中去掉), 取不到时推送到默认endpoint并带上endpoint_fallback=true
+MaxEndpoints	- EndpointSource为tag时单周期内最多的endpoint数, 默认100, 负数不限制; 超过的推送到默认endpoint并带上endpoint_fallback=true
+CatchAll	- 只统计同一文件其他策略都没有匹配的行, 推送每秒的行数; 不配置pattern, func为cnt, 每个文件最多一个
+EmitExcludeRatio	- 每个周期推送<name>.exclude_ratio, 值为被exclude排除的行占(计入的行+被排除的行)的比例, 需要配置exclude
+WindowType	- 聚合窗口, tumbling(默认)每个step一个不重叠的窗口, sliding每WindowSlide推送一次最近一个step内的聚合值
+WindowSlide	- sliding窗口的滑动间隔, 配置为window_slide字符串如"10s", 整秒且能整除step, 加载时解析
+TimestampPrecision	- 日志时间的精度, second(默认)、millisecond或nanosecond, 逐点发送的sink及调试输出按该精度带上日志时间, 聚合仍按秒
+ValueMap	- 按行命中的条目取值, 如{"mode": "max_value", "entries": [{"regex": "fatal", "value": 3}, {"regex": "started", "value": 0}]}, 替代pattern提取的值, 一个条目都没命中的行不产生点;
+		  同时命中多个条目时按mode取值, first_match(默认)取第一个, max_value取最大, min_value取最小, sum取和
+ValueTier	- 按取值所在的区间给点加上tag, 如{"tag": "tier", "tiers": [{"upper_bound": 100, "label": "fast"}, {"upper_bound": 500, "label": "ok"}, {"label": "slow"}]},
+		  取值小于等于upper_bound的第一档, 最后一档可以不写upper_bound兜底; 在value_map、value_round_decimals、value_range之后, NaN不加tag
+TimeZone	- 解析日志时间使用的时区, 如America/New_York, Local为本机时区, 配置为空字符串时为UTC;
+		  不配置时取全局的strategy.default_time_zone, 也没有配置时为本机时区;
+		  日志时间后带有时区偏移(如+0800)的按偏移解析; 加载时解析为TimeLoc, 不合法的策略不加载
+MetricType	- 取值的类型, 为空表示取值本身, delta表示取值是累计计数器(如启动以来的请求总数), 按tag组合取与上一个值的差; 变小视为计数器重置, 取0
+OrderedTagExtracts	- 按顺序提取的tag, 如[{"key": "dc", "regex": "dc=(\\w+)"}, {"key": "region", "regex": "^([a-z]+)", "source_tag": "dc"}],
+		  source_tag为空时匹配整行, 否则匹配之前已提取的tag(tags、tag_fields或排在前面的条目)的值; 取第一个捕获组, 没匹配到的行不产生点
+Description	- 指标的业务含义, 只用于/debug/workers及diff-strategies的展示, 不影响计算
+Owner		- 指标的负责人或团队, 同上只用于展示
+RunbookURL	- 指标异常时的处理手册地址, 同上只用于展示
+Noise		- 差分隐私噪声, 如{"mechanism": "laplace", "epsilon": 0.5, "sensitivity": 1}, 推送时只给标记为external的sink的聚合值加噪, 只支持cnt/sum
 */
 
+// ParseModeLogfmt 按logfmt(key=value)解析日志行
+const ParseModeLogfmt = "logfmt"
+
+// ParseModeWindowsEventXML 每行是一个Windows事件的<Event>元素, 正则作用于其中的Message
+const ParseModeWindowsEventXML = "windows_event_xml"
+
+// ParseModeJSON 每行是一个JSON对象, 按第一层的key取值
+const ParseModeJSON = "json"
+
+// ParseModeAuto worker group启动时检测文件的格式, 检测结果只会是json、logfmt或按正则
+const ParseModeAuto = "auto"
+
 type Strategy struct {
-	ID         int64                     `json:"id"`
-	Name       string                    `json:"name"`
-	FilePath   string                    `json:"file_path"`
-	TimeFormat string                    `json:"time_format"`
-	Pattern    string                    `json:"pattern"`
-	Exclude    string                    `json:"exclude"`
-	Interval   int64                     `json:"step"`
-	Tags       map[string]string         `json:"tags"`
-	Func       string                    `json:"func"`
-	Degree     int64                     `json:"degree"`
-	Comment    string                    `json:"comment"`
-	TimeReg    *regexp.Regexp            `json:"-"`
-	PatternReg *regexp.Regexp            `json:"-"`
-	ExcludeReg *regexp.Regexp            `json:"-"`
-	TagRegs    map[string]*regexp.Regexp `json:"-"`
-	ParseSucc  bool                      `json:"parse_succ"`
+	ID            int64                     `json:"id"`
+	Name          string                    `json:"name"`
+	FilePath      string                    `json:"file_path"`
+	TimeFormat    string                    `json:"time_format"`
+	Pattern       string                    `json:"pattern"`
+	Exclude       string                    `json:"exclude"`
+	Interval      int64                     `json:"step"`
+	Tags          map[string]string         `json:"tags"`
+	Func          string                    `json:"func"`
+	Degree        int64                     `json:"degree"`
+	Comment       string                    `json:"comment"`
+	MaxLagSeconds int64                     `json:"max_lag_seconds"`
+	TimeReg       *regexp.Regexp            `json:"-"`
+	PatternReg    *regexp.Regexp            `json:"-"`
+	ExcludeReg    *regexp.Regexp            `json:"-"`
+	TagRegs       map[string]*regexp.Regexp `json:"-"`
+	ParseSucc     bool                      `json:"parse_succ"`
+	Status        string                    `json:"status,omitempty"`
+
+	RegexpBudget int `json:"regexp_budget,omitempty"`
+	RegexpSize   int `json:"regexp_size"`
+
+	ParseMode  string `json:"parse_mode,omitempty"`
+	TimeField  string `json:"time_field,omitempty"`
+	ValueField string `json:"value_field,omitempty"`
+
+	ChangeSet        string `json:"change_set,omitempty"`
+	ChangeSetVersion int64  `json:"change_set_version,omitempty"`
+
+	Variant       *Strategy `json:"variant,omitempty"`
+	VariantWeight float64   `json:"variant_weight,omitempty"`
+
+	ValueGroup ValueGroup `json:"value_group,omitempty"`
+	ValueIndex int        `json:"-"`
+
+	AnomalyDetect          bool    `json:"anomaly_detect,omitempty"`
+	AnomalyStddevThreshold float64 `json:"anomaly_stddev_threshold,omitempty"`
+	AnomalySuppress        bool    `json:"anomaly_suppress,omitempty"`
+
+	PatternRegFlags string `json:"pattern_reg_flags,omitempty"`
+	TimeRegFlags    string `json:"time_reg_flags,omitempty"`
+
+	WriteBackPath string `json:"write_back_path,omitempty"`
+
+	CompositeOf         []int64    `json:"composite_of,omitempty"`
+	CompositeWindowSecs int        `json:"composite_window_secs,omitempty"`
+	CompositeExpr       string     `json:"composite_expr,omitempty"`
+	CompositeCalc       *expr.Expr `json:"-"`
+	CompositeRefs       []int64    `json:"-"` //引用本策略的组合策略, 加载时生成
+
+	ValueRange         *ValueRange `json:"value_range,omitempty"`
+	ValueRoundDecimals *int        `json:"value_round_decimals,omitempty"`
+
+	GapSeconds int64 `json:"gap_seconds,omitempty"`
+
+	RetireAtSpec    string    `json:"retire_at,omitempty"`
+	RetireAt        time.Time `json:"-"` //加载时由RetireAtSpec解析, 为空不退役
+	RetirementValue float64   `json:"retirement_value,omitempty"`
+
+	MaxTagSets int `json:"max_tag_sets,omitempty"`
+
+	TagLimits map[string]*TagLimit `json:"tag_limits,omitempty"`
+	Warnings  []string             `json:"warnings,omitempty"`
+
+	FirstPeriod string `json:"first_period,omitempty"`
+
+	MaskPatterns []MaskPattern `json:"mask_patterns,omitempty"`
+
+	Noise *Noise `json:"noise,omitempty"`
+
+	MustNotContain     []string         `json:"must_not_contain,omitempty"`
+	MustNotContainLits []string         `json:"-"` //加载时分出的字面量, 用strings.Contains查找
+	MustNotContainRegs []*regexp.Regexp `json:"-"`
+
+	TagTypes  map[string]string `json:"tag_types,omitempty"`
+	TagFields map[string]string `json:"tag_fields,omitempty"`
+	Template  string            `json:"template,omitempty"`
+
+	Generation int64 `json:"generation"`
+
+	EndpointSource string `json:"endpoint_source,omitempty"`
+	MaxEndpoints   int    `json:"max_endpoints,omitempty"`
+	CatchAll       bool   `json:"catch_all,omitempty"`
+
+	EmitExcludeRatio bool `json:"emit_exclude_ratio,omitempty"`
+
+	WindowType      string        `json:"window_type,omitempty"`
+	WindowSlideSpec string        `json:"window_slide,omitempty"`
+	WindowSlide     time.Duration `json:"-"` //加载时由WindowSlideSpec解析
+
+	TimestampPrecision string `json:"timestamp_precision,omitempty"`
+
+	ValueMap *ValueMap `json:"value_map,omitempty"`
+
+	ValueTier *ValueTier `json:"value_tier,omitempty"`
+
+	MetricType string `json:"metric_type,omitempty"`
+
+	TimeZone *string        `json:"time_zone,omitempty"` //nil表示没有配置, 与配置为空字符串(UTC)区分
+	TimeLoc  *time.Location `json:"-"`                   //加载时由TimeZone解析
+
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	RunbookURL  string `json:"runbook_url,omitempty"`
+
+	OrderedTagExtracts []TagExtract `json:"ordered_tag_extracts,omitempty"`
+}
+
+const (
+	// TimestampSecond 日志时间精确到秒
+	TimestampSecond = "second"
+	// TimestampMillisecond 日志时间精确到毫秒
+	TimestampMillisecond = "millisecond"
+	// TimestampNanosecond 日志时间精确到纳秒
+	TimestampNanosecond = "nanosecond"
+)
+
+// EventTms to convert the time parsed from a line to a unix timestamp at the precision of the strategy
+func (s *Strategy) EventTms(t time.Time) int64 {
+	switch s.TimestampPrecision {
+	case TimestampMillisecond:
+		return t.UnixMilli()
+	case TimestampNanosecond:
+		return t.UnixNano()
+	}
+	return t.Unix()
+}
+
+const (
+	// WindowTumbling 每个step一个不重叠的窗口
+	WindowTumbling = "tumbling"
+	// WindowSliding 窗口长度为step, 每WindowSlide推送一次
+	WindowSliding = "sliding"
+)
+
+// Sliding to check whether the strategy aggregates over sliding windows
+func (s *Strategy) Sliding() bool {
+	return s.WindowType == WindowSliding && s.WindowSlide > 0
+}
+
+// EndpointSourceTagPrefix endpoint_source为tag:<tagname>时, endpoint取该tag的值
+const EndpointSourceTagPrefix = "tag:"
+
+// EndpointTag to get the tag whose value is the endpoint of points, empty means the agent's endpoint
+func (s *Strategy) EndpointTag() string {
+	if !strings.HasPrefix(s.EndpointSource, EndpointSourceTagPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(s.EndpointSource, EndpointSourceTagPrefix)
+}
+
+// HasTag to check whether the tag is extracted by tags, tag_fields or ordered_tag_extracts
+// tag_fields中取值为空的表示去掉模板中的该tag
+func (s *Strategy) HasTag(tagk string) bool {
+	if _, ok := s.Tags[tagk]; ok {
+		return true
+	}
+	for _, e := range s.OrderedTagExtracts {
+		if e.Key == tagk {
+			return true
+		}
+	}
+	return s.TagFields[tagk] != ""
+}
+
+// Retired to check whether the strategy is retired at now
+func (s *Strategy) Retired(now time.Time) bool {
+	return !s.RetireAt.IsZero() && !now.Before(s.RetireAt)
+}
+
+// MaskLine to redact the line with mask patterns of the strategy, in order
+func (s *Strategy) MaskLine(line string) string {
+	for i := range s.MaskPatterns {
+		m := &s.MaskPatterns[i]
+		if m.Reg == nil {
+			continue
+		}
+		repl := m.Replacement
+		if repl == "" {
+			repl = DefaultMaskReplacement
+		}
+		line = m.Reg.ReplaceAllString(line, repl)
+	}
+	return line
+}
+
+// MetricTypeDelta 取值是累计计数器, 产生的点取与同一tag组合上一个值的差
+const MetricTypeDelta = "delta"
+
+// FuncEpisodes 统计匹配行的突发次数, 间隔超过GapSeconds的两行属于不同的episode
+const FuncEpisodes = "episodes"
+
+// Location to get the location to parse times of lines without zone offset in
+// 没有经过加载的策略(TimeLoc为nil)使用本机时区
+func (s *Strategy) Location() *time.Location {
+	if s.TimeLoc != nil {
+		return s.TimeLoc
+	}
+	return time.Local
+}
+
+// 超出value_range时的处理方式
+const (
+	OutOfRangeDrop  = "drop"  //默认, 丢弃该点
+	OutOfRangeClamp = "clamp" //取最近的边界, 点带上clamped=true的tag
+	OutOfRangeKeep  = "keep"  //保留原值, 只计数
+)
+
+// tag取值超长时的处理方式
+const (
+	OversizeTruncate = "truncate" //默认, 在字符边界截断并加上...
+	OversizeDrop     = "drop"     //丢弃该点
+)
+
+// 冷启动后第一个不完整周期的处理方式
+const (
+	FirstPeriodEmit       = "emit"        //默认, 照常推送
+	FirstPeriodSuppress   = "suppress"    //不推送, 计入/status的cold_start
+	FirstPeriodPartialTag = "partial_tag" //推送并带上partial=true的tag
+)
+
+// NoiseLaplace 拉普拉斯机制, 噪声的尺度为sensitivity/epsilon
+const NoiseLaplace = "laplace"
+
+// Noise is the calibrated noise added to aggregated values sent to external sinks
+type Noise struct {
+	Mechanism   string   `json:"mechanism"`
+	Epsilon     float64  `json:"epsilon"`
+	Sensitivity *float64 `json:"sensitivity"` //单条日志对聚合值的最大影响, 必须配置
+}
+
+// DeepCopyNoise to copy a noise config, nil is kept
+func DeepCopyNoise(p *Noise) *Noise {
+	if p == nil {
+		return nil
+	}
+	r := &Noise{Mechanism: p.Mechanism, Epsilon: p.Epsilon}
+	if p.Sensitivity != nil {
+		v := *p.Sensitivity
+		r.Sensitivity = &v
+	}
+	return r
+}
+
+// DefaultMaskReplacement 脱敏规则未填写replacement时的替换内容
+const DefaultMaskReplacement = "[REDACTED]"
+
+// MaskPattern is a rule to redact sensitive values of lines
+// replacement中可以用$1、${name}引用捕获组, 如保留卡号后四位
+type MaskPattern struct {
+	Regex       string         `json:"regex"`
+	Replacement string         `json:"replacement,omitempty"`
+	Reg         *regexp.Regexp `json:"-"`
+}
+
+// DeepCopyMaskPatterns to copy mask patterns, the compiled regexp is shared
+func DeepCopyMaskPatterns(p []MaskPattern) []MaskPattern {
+	if p == nil {
+		return nil
+	}
+	return append([]MaskPattern{}, p...)
+}
+
+// 内置的tag类型
+const (
+	TagTypeIPv4 = "ipv4"
+	TagTypeIPv6 = "ipv6"
+	TagTypeMAC  = "mac"
+	TagTypeUUID = "uuid"
+)
+
+const (
+	ipv4Seg  = `(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`
+	ipv4Addr = ipv4Seg + `(?:\.` + ipv4Seg + `){3}`
+	ipv6Seg  = `[0-9A-Fa-f]{1,4}`
+	// 按RFC 4291的各种写法, 包括::缩写、内嵌IPv4及fe80::1%eth0这样的zone
+	ipv6Addr = `(?:` +
+		`(?:` + ipv6Seg + `:){7}` + ipv6Seg + `|` +
+		`(?:` + ipv6Seg + `:){1,7}:|` +
+		`(?:` + ipv6Seg + `:){1,6}:` + ipv6Seg + `|` +
+		`(?:` + ipv6Seg + `:){1,5}(?::` + ipv6Seg + `){1,2}|` +
+		`(?:` + ipv6Seg + `:){1,4}(?::` + ipv6Seg + `){1,3}|` +
+		`(?:` + ipv6Seg + `:){1,3}(?::` + ipv6Seg + `){1,4}|` +
+		`(?:` + ipv6Seg + `:){1,2}(?::` + ipv6Seg + `){1,5}|` +
+		ipv6Seg + `:(?::` + ipv6Seg + `){1,6}|` +
+		`:(?:(?::` + ipv6Seg + `){1,7}|:)|` +
+		`[Ff][Ee]80:(?::[0-9A-Fa-f]{0,4}){0,4}%[0-9A-Za-z]{1,15}|` +
+		`::(?:[Ff]{4}(?::0{1,4})?:)?` + ipv4Addr + `|` +
+		`(?:` + ipv6Seg + `:){1,4}:` + ipv4Addr + `|` +
+		`(?:` + ipv6Seg + `:){6}` + ipv4Addr +
+		`)`
+	macAddr = `(?:[0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}|(?:[0-9A-Fa-f]{2}-){5}[0-9A-Fa-f]{2}|(?:[0-9A-Fa-f]{4}\.){2}[0-9A-Fa-f]{4}`
+	uuid    = `[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}`
+)
+
+// TagTypePatterns 内置tag类型对应的正则, 第1个捕获组是取值
+// 前后要求不能紧挨着字母数字等, 避免从1.2.3.4.5、更长的十六进制串中截取出一段; 结尾可以是句号
+var TagTypePatterns = map[string]string{
+	TagTypeIPv4: `(?:^|[^0-9A-Za-z.])(` + ipv4Addr + `)(?:$|[^0-9A-Za-z.]|\.(?:$|[^0-9A-Za-z]))`,
+	TagTypeIPv6: `(?:^|[^0-9A-Za-z:.%])(` + ipv6Addr + `)(?:$|[^0-9A-Za-z:.%]|\.(?:$|[^0-9A-Za-z]))`,
+	TagTypeMAC:  `(?:^|[^0-9A-Za-z:.-])(` + macAddr + `)(?:$|[^0-9A-Za-z:.-]|\.(?:$|[^0-9A-Za-z]))`,
+	TagTypeUUID: `(?:^|[^0-9A-Za-z-])(` + uuid + `)(?:$|[^0-9A-Za-z-])`,
+}
+
+// TagLimit is the length limit of one tag value
+type TagLimit struct {
+	MaxLen     int    `json:"max_len,omitempty"` //字节数, 0取全局配置
+	OnOversize string `json:"on_oversize,omitempty"`
+}
+
+// DeepCopyTagLimits to copy tag limits, nil is kept
+func DeepCopyTagLimits(p map[string]*TagLimit) map[string]*TagLimit {
+	if p == nil {
+		return nil
+	}
+	r := make(map[string]*TagLimit, len(p))
+	for k, v := range p {
+		if v != nil {
+			l := *v
+			v = &l
+		}
+		r[k] = v
+	}
+	return r
+}
+
+// ValueRange is the valid range of extracted values, min and max are both optional
+type ValueRange struct {
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	OnOutOfRange string   `json:"on_out_of_range,omitempty"`
+}
+
+// DeepCopyValueRange to copy a value range, nil is kept
+func DeepCopyValueRange(p *ValueRange) *ValueRange {
+	if p == nil {
+		return nil
+	}
+	r := &ValueRange{OnOutOfRange: p.OnOutOfRange}
+	if p.Min != nil {
+		min := *p.Min
+		r.Min = &min
+	}
+	if p.Max != nil {
+		max := *p.Max
+		r.Max = &max
+	}
+	return r
+}
+
+// 行同时命中多个value_map条目时的取值方式
+const (
+	ValueMapFirstMatch = "first_match" //默认, 取第一个命中的条目
+	ValueMapMaxValue   = "max_value"   //取最大的, 如值表示严重程度
+	ValueMapMinValue   = "min_value"   //取最小的
+	ValueMapSum        = "sum"         //取所有命中条目的和
+)
+
+// ValueMap is the entries mapping lines to values and how to resolve lines matching several of them
+type ValueMap struct {
+	Mode    string          `json:"mode,omitempty"`
+	Entries []ValueMapEntry `json:"entries"`
+}
+
+// ValueMapEntry maps lines matching regex to value
+type ValueMapEntry struct {
+	Regex string         `json:"regex"`
+	Value float64        `json:"value"`
+	Reg   *regexp.Regexp `json:"-"`
+}
+
+// DeepCopyValueMap to copy a value map, nil is kept and the compiled regexps are shared
+func DeepCopyValueMap(p *ValueMap) *ValueMap {
+	if p == nil {
+		return nil
+	}
+	return &ValueMap{Mode: p.Mode, Entries: append([]ValueMapEntry{}, p.Entries...)}
+}
+
+// TagExtract extracts the tag Key by the first capture group of Regex
+// SourceTag为空时匹配整行, 否则匹配之前已提取的该tag的值
+type TagExtract struct {
+	Key       string         `json:"key"`
+	Regex     string         `json:"regex"`
+	SourceTag string         `json:"source_tag,omitempty"`
+	Reg       *regexp.Regexp `json:"-"`
+}
+
+// DeepCopyTagExtracts to copy tag extracts, nil is kept and the compiled regexps are shared
+func DeepCopyTagExtracts(p []TagExtract) []TagExtract {
+	if p == nil {
+		return nil
+	}
+	return append([]TagExtract{}, p...)
+}
+
+// DefaultValueTierTag value_tier未配置tag时的tag名
+const DefaultValueTierTag = "tier"
+
+// ValueTier is the ordered tiers to label a point by its value
+type ValueTier struct {
+	Tag   string          `json:"tag,omitempty"`
+	Tiers []ValueTierItem `json:"tiers"`
+}
+
+// ValueTierItem is one tier, values not greater than UpperBound belong to it, nil UpperBound means no bound
+type ValueTierItem struct {
+	UpperBound *float64 `json:"upper_bound,omitempty"`
+	Label      string   `json:"label"`
+}
+
+// TagName to get the tag of the tier label
+func (t *ValueTier) TagName() string {
+	if t.Tag == "" {
+		return DefaultValueTierTag
+	}
+	return t.Tag
+}
+
+// Label to get the label of the tier v falls in, empty if v is NaN or above all bounds
+// 上界是闭区间: 等于upper_bound的值属于该档
+func (t *ValueTier) Label(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	for _, item := range t.Tiers {
+		if item.UpperBound == nil || v <= *item.UpperBound {
+			return item.Label
+		}
+	}
+	return ""
+}
+
+// DeepCopyValueTier to copy a value tier, nil is kept
+func DeepCopyValueTier(p *ValueTier) *ValueTier {
+	if p == nil {
+		return nil
+	}
+	r := &ValueTier{Tag: p.Tag, Tiers: make([]ValueTierItem, len(p.Tiers))}
+	for i, item := range p.Tiers {
+		r.Tiers[i].Label = item.Label
+		if item.UpperBound != nil {
+			b := *item.UpperBound
+			r.Tiers[i].UpperBound = &b
+		}
+	}
+	return r
+}
+
+// ValueGroup is index or name of a capture group, both number and string are accepted in json
+type ValueGroup string
+
+// UnmarshalJSON to accept both 2 and "2" and "latency"
+func (v *ValueGroup) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*v = ValueGroup(name)
+		return nil
+	}
+	var index int
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("value_group should be a group index or name: %s", b)
+	}
+	*v = ValueGroup(strconv.Itoa(index))
+	return nil
+}
+
+// MarshalJSON to output index as number
+func (v ValueGroup) MarshalJSON() ([]byte, error) {
+	if index, err := strconv.Atoi(string(v)); err == nil {
+		return json.Marshal(index)
+	}
+	return json.Marshal(string(v))
 }
 
 type LimitResp struct {
@@ -51,6 +588,70 @@ func DeepCopyStrategy(p *Strategy) *Strategy {
 	s.Func = p.Func
 	s.Degree = p.Degree
 	s.Comment = p.Comment
+	s.MaxLagSeconds = p.MaxLagSeconds
+	s.Status = p.Status
+	s.RegexpBudget = p.RegexpBudget
+	s.RegexpSize = p.RegexpSize
+	s.ParseMode = p.ParseMode
+	s.TimeField = p.TimeField
+	s.ValueField = p.ValueField
+	s.ChangeSet = p.ChangeSet
+	s.ChangeSetVersion = p.ChangeSetVersion
+	if p.Variant != nil {
+		s.Variant = DeepCopyStrategy(p.Variant)
+	}
+	s.VariantWeight = p.VariantWeight
+	s.ValueGroup = p.ValueGroup
+	s.ValueIndex = p.ValueIndex
+	s.AnomalyDetect = p.AnomalyDetect
+	s.AnomalyStddevThreshold = p.AnomalyStddevThreshold
+	s.AnomalySuppress = p.AnomalySuppress
+	s.PatternRegFlags = p.PatternRegFlags
+	s.TimeRegFlags = p.TimeRegFlags
+	s.WriteBackPath = p.WriteBackPath
+	s.CompositeOf = DeepCopyInt64Slice(p.CompositeOf)
+	s.CompositeWindowSecs = p.CompositeWindowSecs
+	s.CompositeExpr = p.CompositeExpr
+	s.CompositeCalc = p.CompositeCalc
+	s.CompositeRefs = DeepCopyInt64Slice(p.CompositeRefs)
+	s.ValueRange = DeepCopyValueRange(p.ValueRange)
+	s.ValueRoundDecimals = DeepCopyIntPtr(p.ValueRoundDecimals)
+	s.GapSeconds = p.GapSeconds
+	s.RetireAtSpec = p.RetireAtSpec
+	s.RetireAt = p.RetireAt
+	s.RetirementValue = p.RetirementValue
+	s.MaxTagSets = p.MaxTagSets
+	s.TagLimits = DeepCopyTagLimits(p.TagLimits)
+	s.TagTypes = DeepCopyStringMap(p.TagTypes)
+	s.TagFields = DeepCopyStringMap(p.TagFields)
+	s.Template = p.Template
+	s.Generation = p.Generation
+	s.EndpointSource = p.EndpointSource
+	s.MaxEndpoints = p.MaxEndpoints
+	s.CatchAll = p.CatchAll
+	s.EmitExcludeRatio = p.EmitExcludeRatio
+	s.WindowType = p.WindowType
+	s.WindowSlideSpec = p.WindowSlideSpec
+	s.WindowSlide = p.WindowSlide
+	s.TimestampPrecision = p.TimestampPrecision
+	s.ValueMap = DeepCopyValueMap(p.ValueMap)
+	s.ValueTier = DeepCopyValueTier(p.ValueTier)
+	s.MetricType = p.MetricType
+	s.TimeZone = DeepCopyStringPtr(p.TimeZone)
+	s.TimeLoc = p.TimeLoc
+	s.Description = p.Description
+	s.Owner = p.Owner
+	s.RunbookURL = p.RunbookURL
+	s.OrderedTagExtracts = DeepCopyTagExtracts(p.OrderedTagExtracts)
+	if p.Warnings != nil {
+		s.Warnings = append([]string{}, p.Warnings...)
+	}
+	s.FirstPeriod = p.FirstPeriod
+	s.MaskPatterns = DeepCopyMaskPatterns(p.MaskPatterns)
+	s.Noise = DeepCopyNoise(p.Noise)
+	if p.MustNotContain != nil {
+		s.MustNotContain = append([]string{}, p.MustNotContain...)
+	}
 
 	return &s
 }
@@ -63,6 +664,31 @@ func DeepCopyStringMap(p map[string]string) map[string]string {
 	return r
 }
 
+func DeepCopyIntPtr(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	r := *p
+	return &r
+}
+
+func DeepCopyStringPtr(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	r := *p
+	return &r
+}
+
+func DeepCopyInt64Slice(p []int64) []int64 {
+	if p == nil {
+		return nil
+	}
+	r := make([]int64, len(p))
+	copy(r, p)
+	return r
+}
+
 func DeepCopyStringSlice(p []string) []string {
 	r := make([]string, len(p))
 	for i, v := range p {
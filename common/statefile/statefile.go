@@ -0,0 +1,232 @@
+package statefile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Magic is the first word of the header line of every state file
+// 头部一行: <magic> <kind> v<version> <body字节数> <body的crc32>, 之后是json的body
+const Magic = "#falcon-log-agent-state"
+
+// ErrFutureVersion 文件由更新版本的agent写入, 不能读取, 也不能覆盖
+var ErrFutureVersion = errors.New("state file is written by a newer agent")
+
+// Converter converts a body of one version to the next version
+type Converter func(body []byte) ([]byte, error)
+
+// Schema describes one kind of state file and how to read its old versions
+// 不兼容地修改结构时升级Version, 并在Converters中加上从上一个版本的转换; 新增可选字段不需要升级
+type Schema struct {
+	Kind       string
+	Version    int               //当前版本, 从1开始
+	Converters map[int]Converter //Converters[v]把版本v的body转换为版本v+1
+	// Legacy to get the version of a file written before headers were introduced, nil if there is no such file
+	Legacy func(body []byte) (int, error)
+}
+
+// CorruptError is returned when the file cannot be decoded, the file is renamed aside to Quarantine
+type CorruptError struct {
+	Path       string
+	Quarantine string //为空表示重命名也失败了
+	Err        error
+}
+
+func (e *CorruptError) Error() string {
+	if e.Quarantine == "" {
+		return fmt.Sprintf("state file %s is corrupted: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("state file %s is corrupted and moved to %s: %v", e.Path, e.Quarantine, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// corruption is the reason a file cannot be decoded, as opposed to a version the agent refuses to read
+type corruption struct {
+	reason string
+}
+
+func (c *corruption) Error() string {
+	return c.reason
+}
+
+func corrupt(format string, args ...interface{}) error {
+	return &corruption{reason: fmt.Sprintf(format, args...)}
+}
+
+// Load to read the state file into v, returns the version the file was written at
+// 文件不存在时返回的err满足os.IsNotExist; 版本更新的文件返回ErrFutureVersion, 文件原样保留;
+// 损坏的文件重命名为<path>.corrupt.<时间>后返回*CorruptError. 只有返回nil时v才是完整的, 否则调用方应丢弃v
+func Load(path string, s *Schema, v interface{}) (int, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	body, version, err := Decode(bs, s)
+	if err == nil {
+		if err = json.Unmarshal(body, v); err != nil {
+			err = corrupt("decode body of version %d: %v", version, err)
+		}
+	}
+	if err == nil {
+		return version, nil
+	}
+	if _, ok := err.(*corruption); !ok {
+		return version, fmt.Errorf("state file %s: %w", path, err)
+	}
+	ce := &CorruptError{Path: path, Err: err}
+	q := fmt.Sprintf("%s.corrupt.%s", path, time.Now().Format("20060102150405"))
+	if os.Rename(path, q) == nil {
+		ce.Quarantine = q
+	}
+	return version, ce
+}
+
+// Decode to check the header of a state file and convert its body to the current version
+func Decode(bs []byte, s *Schema) ([]byte, int, error) {
+	var body []byte
+	var version int
+	if bytes.HasPrefix(bs, []byte(Magic)) {
+		var err error
+		if body, version, err = decodeHeader(bs, s); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		if s.Legacy == nil {
+			return nil, 0, corrupt("missing header")
+		}
+		var err error
+		if version, err = s.Legacy(bs); err != nil {
+			return nil, 0, corrupt("legacy file: %v", err)
+		}
+		body = bs
+	}
+
+	if version > s.Version {
+		return nil, version, fmt.Errorf("%w: version %d, this agent reads up to %d", ErrFutureVersion, version, s.Version)
+	}
+	for v := version; v < s.Version; v++ {
+		convert, ok := s.Converters[v]
+		if !ok {
+			return nil, version, fmt.Errorf("no converter of %s from version %d to %d", s.Kind, v, v+1)
+		}
+		var err error
+		if body, err = convert(body); err != nil {
+			return nil, version, corrupt("convert version %d to %d: %v", v, v+1, err)
+		}
+	}
+	return body, version, nil
+}
+
+// decodeHeader to check the header and get the body
+// 先看版本, 更新的版本可能改变了头部之后的字段, 交给调用方拒绝读取
+func decodeHeader(bs []byte, s *Schema) ([]byte, int, error) {
+	i := bytes.IndexByte(bs, '\n')
+	if i < 0 {
+		return nil, 0, corrupt("header is not terminated")
+	}
+	fields := strings.Fields(string(bs[:i]))
+	if len(fields) < 3 || fields[0] != Magic {
+		return nil, 0, corrupt("malformed header %q", bs[:i])
+	}
+	if fields[1] != s.Kind {
+		return nil, 0, corrupt("kind %s, want %s", fields[1], s.Kind)
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(fields[2], "v"))
+	if err != nil || !strings.HasPrefix(fields[2], "v") || version < 1 {
+		return nil, 0, corrupt("bad version %q", fields[2])
+	}
+	if version > s.Version {
+		return nil, version, nil
+	}
+	if len(fields) != 5 {
+		return nil, 0, corrupt("malformed header %q", bs[:i])
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil || size < 0 {
+		return nil, 0, corrupt("bad body size %q", fields[3])
+	}
+	sum, err := strconv.ParseUint(fields[4], 16, 32)
+	if err != nil {
+		return nil, 0, corrupt("bad checksum %q", fields[4])
+	}
+	body := bs[i+1:]
+	if len(body) != size {
+		return nil, 0, corrupt("body is %d bytes, header says %d", len(body), size)
+	}
+	if crc32.ChecksumIEEE(body) != uint32(sum) {
+		return nil, 0, corrupt("checksum mismatch")
+	}
+	return body, version, nil
+}
+
+// Encode to encode v with the header of the current version
+func Encode(s *Schema, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("%s %s v%d %d %08x\n", Magic, s.Kind, s.Version, len(body), crc32.ChecksumIEEE(body))
+	return append([]byte(header), body...), nil
+}
+
+// Write to write v as the current version atomically
+// 先写临时文件并fsync, rename后再fsync目录, 进程或机器在任何时刻退出都只会留下完整的旧文件或新文件;
+// 临时文件名每次不同, 同时写同一个文件时不会互相覆盖写了一半的临时文件, 最后rename的生效
+func Write(path string, s *Schema, v interface{}) error {
+	bs, err := Encode(s, v)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := writeTemp(f, bs); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeTemp to write and sync the temp file, the file is closed
+func writeTemp(f *os.File, bs []byte) error {
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,215 @@
+package statefile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type testState struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// testSchema 版本1是没有头部的{"n": ...}, 版本2改名为name, 版本3加上count
+var testSchema = &Schema{
+	Kind:    "test",
+	Version: 3,
+	Converters: map[int]Converter{
+		1: func(body []byte) ([]byte, error) {
+			var v struct {
+				N string `json:"n"`
+			}
+			if err := json.Unmarshal(body, &v); err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]string{"name": v.N})
+		},
+		2: func(body []byte) ([]byte, error) {
+			var v testState
+			if err := json.Unmarshal(body, &v); err != nil {
+				return nil, err
+			}
+			v.Count = 1
+			return json.Marshal(&v)
+		},
+	},
+	Legacy: func(body []byte) (int, error) {
+		if !json.Valid(body) {
+			return 0, fmt.Errorf("not json")
+		}
+		return 1, nil
+	},
+}
+
+// header to encode body as a state file of the kind and version
+func header(kind string, version int, body string) string {
+	bs, _ := Encode(&Schema{Kind: kind, Version: version}, json.RawMessage(body))
+	return string(bs)
+}
+
+func TestLoadConvert(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "statefile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	cases := []struct {
+		content string
+		version int
+	}{
+		{`{"n":"a"}`, 1},
+		{header("test", 2, `{"name":"a"}`), 2},
+		{header("test", 3, `{"name":"a","count":1}`), 3},
+	}
+	for _, c := range cases {
+		ioutil.WriteFile(path, []byte(c.content), 0644)
+		var v testState
+		version, err := Load(path, testSchema, &v)
+		if err != nil || version != c.version || v != (testState{Name: "a", Count: 1}) {
+			t.Errorf("%q: got %+v version %d err %v", c.content, v, version, err)
+		}
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing"), testSchema, &testState{}); !os.IsNotExist(err) {
+		t.Errorf("missing file should be reported as not exist, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "statefile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	if err := Write(path, testSchema, &testState{Name: "b", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if tmps, _ := filepath.Glob(path + ".tmp*"); len(tmps) != 0 {
+		t.Errorf("temp file should be renamed: %v", tmps)
+	}
+	bs, _ := ioutil.ReadFile(path)
+	if !bytes.HasPrefix(bs, []byte(Magic+" test v3 ")) {
+		t.Errorf("should be written as the current version: %q", bs)
+	}
+	var v testState
+	if version, err := Load(path, testSchema, &v); err != nil || version != 3 || v != (testState{Name: "b", Count: 2}) {
+		t.Errorf("got %+v version %d err %v", v, version, err)
+	}
+}
+
+func TestLoadRefuseAndQuarantine(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "statefile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	// 更新的版本: 拒绝读取, 文件保留
+	future := Magic + " test v4 some new header\n{}"
+	ioutil.WriteFile(path, []byte(future), 0644)
+	if _, err := Load(path, testSchema, &testState{}); !errors.Is(err, ErrFutureVersion) {
+		t.Fatalf("future version should be refused, got %v", err)
+	}
+	if bs, _ := ioutil.ReadFile(path); string(bs) != future {
+		t.Fatal("file of future version should be left as is")
+	}
+
+	valid := header("test", 3, `{"name":"a","count":1}`)
+	for _, content := range []string{
+		valid[:len(valid)-3],               //body被截断
+		valid[:10],                         //头部被截断
+		header("other", 3, `{"name":"a"}`), //其他类型的文件
+		header("test", 3, `{"name":1}`),    //body与结构不符
+		header("test", 2, `{"name":`),      //转换失败
+		Magic + " test v0 2 00000000\n{}",  //没有的版本
+		Magic + " test v3 2 00000000\n{}",  //校验和不对
+		"not json",                         //无法识别的旧文件
+	} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		_, err := Load(path, testSchema, &testState{})
+		ce, ok := err.(*CorruptError)
+		if !ok {
+			t.Errorf("%q: should be corrupt, got %v", content, err)
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%q: corrupted file should be moved aside", content)
+		}
+		if bs, _ := ioutil.ReadFile(ce.Quarantine); string(bs) != content {
+			t.Errorf("%q: quarantined file should keep the content, got %q", content, bs)
+		}
+		os.Remove(ce.Quarantine)
+	}
+
+	// 缺少转换是代码的问题, 不是文件损坏
+	s := &Schema{Kind: "test", Version: 3}
+	ioutil.WriteFile(path, []byte(header("test", 2, `{}`)), 0644)
+	if _, err := Load(path, s, &testState{}); err == nil {
+		t.Fatal("version without converter should be refused")
+	} else if _, ok := err.(*CorruptError); ok {
+		t.Fatalf("missing converter should not quarantine the file: %v", err)
+	}
+}
+
+// FuzzDecode checks the loader against corrupted headers and truncated bodies
+// 任何输入都不能panic; 通过校验的输入必须能解出完整的当前版本
+func FuzzDecode(f *testing.F) {
+	valid := header("test", 3, `{"name":"a","count":1}`)
+	f.Add([]byte(valid))
+	f.Add([]byte(header("test", 2, `{"name":"a"}`)))
+	f.Add([]byte(`{"n":"a"}`))
+	for i := 0; i < len(valid); i += 7 {
+		f.Add([]byte(valid[:i]))
+	}
+	f.Add([]byte(Magic + " test v3 -1 zz\n"))
+	f.Add([]byte(Magic + " test v99999999999999999999 2 0\n{}"))
+	f.Add([]byte(Magic + "\n"))
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		body, version, err := Decode(bs, testSchema)
+		if err != nil {
+			return
+		}
+		if version < 1 || version > testSchema.Version {
+			t.Fatalf("decoded version %d out of range", version)
+		}
+		if bytes.HasPrefix(bs, []byte(Magic)) && version == testSchema.Version && !bytes.HasSuffix(bs, body) {
+			t.Fatalf("body of current version should be returned as is")
+		}
+	})
+}
+
+// 同时写同一个文件, 各自的临时文件互不影响, 留下的是某一次完整的写入
+func TestWriteConcurrent(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "statefile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := Write(path, testSchema, &testState{Name: fmt.Sprintf("writer-%d", i), Count: j}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var v testState
+	if _, err := Load(path, testSchema, &v); err != nil {
+		t.Fatalf("file should be complete: %v", err)
+	}
+	if tmps, _ := filepath.Glob(path + ".tmp*"); len(tmps) != 0 {
+		t.Errorf("temp files left: %v", tmps)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("unexpected mode: %v %v", fi.Mode(), err)
+	}
+}
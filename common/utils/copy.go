@@ -4,17 +4,98 @@ import "github.com/didi/falcon-log-agent/common/scheme"
 
 func DeepCopyStrategy(ori *scheme.Strategy) *scheme.Strategy {
 	ret := &scheme.Strategy{
-		ID:         ori.ID,
-		Name:       ori.Name,
-		FilePath:   ori.FilePath,
-		TimeFormat: ori.TimeFormat,
-		Pattern:    ori.Pattern,
-		Interval:   ori.Interval,
-		Tags:       DeepCopyStringMap(ori.Tags),
-		Func:       ori.Func,
-		Degree:     ori.Degree,
-		Comment:    ori.Comment,
-		ParseSucc:  ori.ParseSucc,
+		ID:            ori.ID,
+		Name:          ori.Name,
+		FilePath:      ori.FilePath,
+		TimeFormat:    ori.TimeFormat,
+		Pattern:       ori.Pattern,
+		Interval:      ori.Interval,
+		Tags:          DeepCopyStringMap(ori.Tags),
+		Func:          ori.Func,
+		Degree:        ori.Degree,
+		Comment:       ori.Comment,
+		MaxLagSeconds: ori.MaxLagSeconds,
+		ParseSucc:     ori.ParseSucc,
+		Status:        ori.Status,
+		RegexpBudget:  ori.RegexpBudget,
+		RegexpSize:    ori.RegexpSize,
+		ParseMode:     ori.ParseMode,
+		TimeField:     ori.TimeField,
+		ValueField:    ori.ValueField,
+
+		ChangeSet:        ori.ChangeSet,
+		ChangeSetVersion: ori.ChangeSetVersion,
+
+		VariantWeight: ori.VariantWeight,
+		ValueGroup:    ori.ValueGroup,
+		ValueIndex:    ori.ValueIndex,
+
+		AnomalyDetect:          ori.AnomalyDetect,
+		AnomalyStddevThreshold: ori.AnomalyStddevThreshold,
+		AnomalySuppress:        ori.AnomalySuppress,
+
+		PatternRegFlags: ori.PatternRegFlags,
+		TimeRegFlags:    ori.TimeRegFlags,
+
+		WriteBackPath: ori.WriteBackPath,
+
+		CompositeOf:         scheme.DeepCopyInt64Slice(ori.CompositeOf),
+		CompositeWindowSecs: ori.CompositeWindowSecs,
+		CompositeExpr:       ori.CompositeExpr,
+		CompositeCalc:       ori.CompositeCalc,
+		CompositeRefs:       scheme.DeepCopyInt64Slice(ori.CompositeRefs),
+
+		ValueRange:         scheme.DeepCopyValueRange(ori.ValueRange),
+		ValueRoundDecimals: scheme.DeepCopyIntPtr(ori.ValueRoundDecimals),
+
+		GapSeconds: ori.GapSeconds,
+
+		RetireAtSpec:    ori.RetireAtSpec,
+		RetireAt:        ori.RetireAt,
+		RetirementValue: ori.RetirementValue,
+
+		MaxTagSets: ori.MaxTagSets,
+		TagLimits:  scheme.DeepCopyTagLimits(ori.TagLimits),
+		TagTypes:   scheme.DeepCopyStringMap(ori.TagTypes),
+		TagFields:  scheme.DeepCopyStringMap(ori.TagFields),
+		Template:   ori.Template,
+		Generation: ori.Generation,
+
+		EndpointSource: ori.EndpointSource,
+		MaxEndpoints:   ori.MaxEndpoints,
+		CatchAll:       ori.CatchAll,
+
+		EmitExcludeRatio: ori.EmitExcludeRatio,
+
+		WindowType:      ori.WindowType,
+		WindowSlideSpec: ori.WindowSlideSpec,
+		WindowSlide:     ori.WindowSlide,
+
+		TimestampPrecision: ori.TimestampPrecision,
+		ValueMap:           scheme.DeepCopyValueMap(ori.ValueMap),
+		ValueTier:          scheme.DeepCopyValueTier(ori.ValueTier),
+		MetricType:         ori.MetricType,
+		TimeZone:           scheme.DeepCopyStringPtr(ori.TimeZone),
+		TimeLoc:            ori.TimeLoc,
+
+		Description: ori.Description,
+		Owner:       ori.Owner,
+		RunbookURL:  ori.RunbookURL,
+
+		OrderedTagExtracts: scheme.DeepCopyTagExtracts(ori.OrderedTagExtracts),
+
+		FirstPeriod:  ori.FirstPeriod,
+		MaskPatterns: scheme.DeepCopyMaskPatterns(ori.MaskPatterns),
+		Noise:        scheme.DeepCopyNoise(ori.Noise),
+	}
+	if ori.Warnings != nil {
+		ret.Warnings = append([]string{}, ori.Warnings...)
+	}
+	if ori.MustNotContain != nil {
+		ret.MustNotContain = append([]string{}, ori.MustNotContain...)
+	}
+	if ori.Variant != nil {
+		ret.Variant = DeepCopyStrategy(ori.Variant)
 	}
 	return ret
 }
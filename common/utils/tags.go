@@ -6,6 +6,8 @@ import (
 	"strings"
 )
 
+// SortedTags to serialize tags as k1=v1,k2=v2 sorted by key
+// 是序列的唯一标识: counter按它聚合, 推送的tags也用它, 与map的插入、遍历顺序无关
 func SortedTags(tags map[string]string) string {
 	if tags == nil {
 		return ""
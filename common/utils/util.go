@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
 )
@@ -52,6 +53,12 @@ func GetEndpoint(endpoint string) (string, error) {
 
 }
 
+// TimeFormatUnixNano 日志时间是纳秒时间戳, 如OTLP日志的TimeUnixNano
+const TimeFormatUnixNano = "otlp_unix_nano"
+
+// TimeFormatRFC3339Nano 带时区及纳秒的RFC 3339时间, 如Windows事件的TimeCreated SystemTime
+const TimeFormatRFC3339Nano = "rfc3339_nano"
+
 //根据配置的时间格式，获取对应的正则匹配pattern和time包用的时间格式
 func GetPatAndTimeFormat(tf string) (string, string) {
 	var pat, timeFormat string
@@ -59,6 +66,10 @@ func GetPatAndTimeFormat(tf string) (string, string) {
 	case "dd/mmm/yyyy:HH:MM:SS":
 		pat = `([012][0-9]|3[01])/[JFMASOND][a-z]{2}/(2[0-9]{3}):([01][0-9]|2[0-4])(:[012345][0-9]){2}`
 		timeFormat = "02/Jan/2006:15:04:05"
+	case "dd/mmm/yyyy:HH:MM:SS Z":
+		// 带时区偏移, 如nginx的$time_local, 按日志中的偏移解析
+		pat = `([012][0-9]|3[01])/[JFMASOND][a-z]{2}/(2[0-9]{3}):([01][0-9]|2[0-4])(:[012345][0-9]){2}\s[+-][0-9]{4}`
+		timeFormat = "02/Jan/2006:15:04:05 -0700"
 	case "dd/mmm/yyyy HH:MM:SS":
 		pat = `([012][0-9]|3[01])/[JFMASOND][a-z]{2}/(2[0-9]{3})\s([01][0-9]|2[0-4])(:[012345][0-9]){2}`
 		timeFormat = "02/Jan/2006 15:04:05"
@@ -80,6 +91,12 @@ func GetPatAndTimeFormat(tf string) (string, string) {
 	case "mmm dd HH:MM:SS":
 		pat = `[JFMASOND][a-z]{2}\s+([1-9]|[1-2][0-9]|3[01])\s([01][0-9]|2[0-4])(:[012345][0-9]){2}`
 		timeFormat = "Jan 2 15:04:05"
+	case TimeFormatUnixNano:
+		pat = `^[0-9]{1,19}`
+		timeFormat = TimeFormatUnixNano
+	case TimeFormatRFC3339Nano:
+		pat = `(2[0-9]{3})-(0[1-9]|1[012])-([012][0-9]|3[01])T([01][0-9]|2[0-4])(:[012345][0-9]){2}(\.[0-9]{1,9})?(Z|[+-][0-9]{2}:[0-9]{2})`
+		timeFormat = time.RFC3339Nano
 	default:
 		dlog.Errorf("match time pac failed : [timeFormat:%s]", tf)
 		return "", ""
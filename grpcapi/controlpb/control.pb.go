@@ -0,0 +1,762 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: control.proto
+
+/*
+Package controlpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	control.proto
+
+It has these top-level messages:
+
+	Strategy
+	ListStrategiesRequest
+	ListStrategiesResponse
+	GetStrategyStatsRequest
+	StrategyStats
+	PauseStrategiesRequest
+	Pause
+	SeekFileRequest
+	SeekFileResponse
+	GetWorkerStatusRequest
+	WorkerStatus
+	TestStrategyRequest
+	Match
+	TestStrategyResponse
+	StreamEventsRequest
+	Event
+*/
+package controlpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type Strategy struct {
+	Id         int64             `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Name       string            `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	FilePath   string            `protobuf:"bytes,3,opt,name=file_path,json=filePath" json:"file_path,omitempty"`
+	TimeFormat string            `protobuf:"bytes,4,opt,name=time_format,json=timeFormat" json:"time_format,omitempty"`
+	Pattern    string            `protobuf:"bytes,5,opt,name=pattern" json:"pattern,omitempty"`
+	Exclude    string            `protobuf:"bytes,6,opt,name=exclude" json:"exclude,omitempty"`
+	Func       string            `protobuf:"bytes,7,opt,name=func" json:"func,omitempty"`
+	Step       int64             `protobuf:"varint,8,opt,name=step" json:"step,omitempty"`
+	Tags       map[string]string `protobuf:"bytes,9,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ParseSucc  bool              `protobuf:"varint,10,opt,name=parse_succ,json=parseSucc" json:"parse_succ,omitempty"`
+	Status     string            `protobuf:"bytes,11,opt,name=status" json:"status,omitempty"`
+	Warnings   []string          `protobuf:"bytes,12,rep,name=warnings" json:"warnings,omitempty"`
+	Json       []byte            `protobuf:"bytes,13,opt,name=json,proto3" json:"json,omitempty"`
+}
+
+func (m *Strategy) Reset()                    { *m = Strategy{} }
+func (m *Strategy) String() string            { return proto.CompactTextString(m) }
+func (*Strategy) ProtoMessage()               {}
+func (*Strategy) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *Strategy) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Strategy) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Strategy) GetFilePath() string {
+	if m != nil {
+		return m.FilePath
+	}
+	return ""
+}
+
+func (m *Strategy) GetTimeFormat() string {
+	if m != nil {
+		return m.TimeFormat
+	}
+	return ""
+}
+
+func (m *Strategy) GetPattern() string {
+	if m != nil {
+		return m.Pattern
+	}
+	return ""
+}
+
+func (m *Strategy) GetExclude() string {
+	if m != nil {
+		return m.Exclude
+	}
+	return ""
+}
+
+func (m *Strategy) GetFunc() string {
+	if m != nil {
+		return m.Func
+	}
+	return ""
+}
+
+func (m *Strategy) GetStep() int64 {
+	if m != nil {
+		return m.Step
+	}
+	return 0
+}
+
+func (m *Strategy) GetTags() map[string]string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *Strategy) GetParseSucc() bool {
+	if m != nil {
+		return m.ParseSucc
+	}
+	return false
+}
+
+func (m *Strategy) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *Strategy) GetWarnings() []string {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
+func (m *Strategy) GetJson() []byte {
+	if m != nil {
+		return m.Json
+	}
+	return nil
+}
+
+type ListStrategiesRequest struct {
+}
+
+func (m *ListStrategiesRequest) Reset()                    { *m = ListStrategiesRequest{} }
+func (m *ListStrategiesRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListStrategiesRequest) ProtoMessage()               {}
+func (*ListStrategiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type ListStrategiesResponse struct {
+	Strategies []*Strategy `protobuf:"bytes,1,rep,name=strategies" json:"strategies,omitempty"`
+}
+
+func (m *ListStrategiesResponse) Reset()                    { *m = ListStrategiesResponse{} }
+func (m *ListStrategiesResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListStrategiesResponse) ProtoMessage()               {}
+func (*ListStrategiesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ListStrategiesResponse) GetStrategies() []*Strategy {
+	if m != nil {
+		return m.Strategies
+	}
+	return nil
+}
+
+type GetStrategyStatsRequest struct {
+	StrategyId int64 `protobuf:"varint,1,opt,name=strategy_id,json=strategyId" json:"strategy_id,omitempty"`
+}
+
+func (m *GetStrategyStatsRequest) Reset()                    { *m = GetStrategyStatsRequest{} }
+func (m *GetStrategyStatsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetStrategyStatsRequest) ProtoMessage()               {}
+func (*GetStrategyStatsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *GetStrategyStatsRequest) GetStrategyId() int64 {
+	if m != nil {
+		return m.StrategyId
+	}
+	return 0
+}
+
+type StrategyStats struct {
+	StrategyId          int64  `protobuf:"varint,1,opt,name=strategy_id,json=strategyId" json:"strategy_id,omitempty"`
+	Paused              string `protobuf:"bytes,2,opt,name=paused" json:"paused,omitempty"`
+	Matched             int64  `protobuf:"varint,3,opt,name=matched" json:"matched,omitempty"`
+	MustNotContain      int64  `protobuf:"varint,4,opt,name=must_not_contain,json=mustNotContain" json:"must_not_contain,omitempty"`
+	Excluded            int64  `protobuf:"varint,5,opt,name=excluded" json:"excluded,omitempty"`
+	ValueRangeDropped   int64  `protobuf:"varint,6,opt,name=value_range_dropped,json=valueRangeDropped" json:"value_range_dropped,omitempty"`
+	ValueRangeClamped   int64  `protobuf:"varint,7,opt,name=value_range_clamped,json=valueRangeClamped" json:"value_range_clamped,omitempty"`
+	ValueRangeKept      int64  `protobuf:"varint,8,opt,name=value_range_kept,json=valueRangeKept" json:"value_range_kept,omitempty"`
+	TagTruncated        int64  `protobuf:"varint,9,opt,name=tag_truncated,json=tagTruncated" json:"tag_truncated,omitempty"`
+	TagDropped          int64  `protobuf:"varint,10,opt,name=tag_dropped,json=tagDropped" json:"tag_dropped,omitempty"`
+	ColdStartSuppressed int64  `protobuf:"varint,11,opt,name=cold_start_suppressed,json=coldStartSuppressed" json:"cold_start_suppressed,omitempty"`
+	ColdStartTagged     int64  `protobuf:"varint,12,opt,name=cold_start_tagged,json=coldStartTagged" json:"cold_start_tagged,omitempty"`
+}
+
+func (m *StrategyStats) Reset()                    { *m = StrategyStats{} }
+func (m *StrategyStats) String() string            { return proto.CompactTextString(m) }
+func (*StrategyStats) ProtoMessage()               {}
+func (*StrategyStats) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *StrategyStats) GetStrategyId() int64 {
+	if m != nil {
+		return m.StrategyId
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetPaused() string {
+	if m != nil {
+		return m.Paused
+	}
+	return ""
+}
+
+func (m *StrategyStats) GetMatched() int64 {
+	if m != nil {
+		return m.Matched
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetMustNotContain() int64 {
+	if m != nil {
+		return m.MustNotContain
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetExcluded() int64 {
+	if m != nil {
+		return m.Excluded
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetValueRangeDropped() int64 {
+	if m != nil {
+		return m.ValueRangeDropped
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetValueRangeClamped() int64 {
+	if m != nil {
+		return m.ValueRangeClamped
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetValueRangeKept() int64 {
+	if m != nil {
+		return m.ValueRangeKept
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetTagTruncated() int64 {
+	if m != nil {
+		return m.TagTruncated
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetTagDropped() int64 {
+	if m != nil {
+		return m.TagDropped
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetColdStartSuppressed() int64 {
+	if m != nil {
+		return m.ColdStartSuppressed
+	}
+	return 0
+}
+
+func (m *StrategyStats) GetColdStartTagged() int64 {
+	if m != nil {
+		return m.ColdStartTagged
+	}
+	return 0
+}
+
+type PauseStrategiesRequest struct {
+	Files     []string `protobuf:"bytes,1,rep,name=files" json:"files,omitempty"`
+	Ids       []int64  `protobuf:"varint,2,rep,packed,name=ids" json:"ids,omitempty"`
+	All       bool     `protobuf:"varint,3,opt,name=all" json:"all,omitempty"`
+	Duration  string   `protobuf:"bytes,4,opt,name=duration" json:"duration,omitempty"`
+	Principal string   `protobuf:"bytes,5,opt,name=principal" json:"principal,omitempty"`
+}
+
+func (m *PauseStrategiesRequest) Reset()                    { *m = PauseStrategiesRequest{} }
+func (m *PauseStrategiesRequest) String() string            { return proto.CompactTextString(m) }
+func (*PauseStrategiesRequest) ProtoMessage()               {}
+func (*PauseStrategiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *PauseStrategiesRequest) GetFiles() []string {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+func (m *PauseStrategiesRequest) GetIds() []int64 {
+	if m != nil {
+		return m.Ids
+	}
+	return nil
+}
+
+func (m *PauseStrategiesRequest) GetAll() bool {
+	if m != nil {
+		return m.All
+	}
+	return false
+}
+
+func (m *PauseStrategiesRequest) GetDuration() string {
+	if m != nil {
+		return m.Duration
+	}
+	return ""
+}
+
+func (m *PauseStrategiesRequest) GetPrincipal() string {
+	if m != nil {
+		return m.Principal
+	}
+	return ""
+}
+
+type Pause struct {
+	Id          int64    `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Files       []string `protobuf:"bytes,2,rep,name=files" json:"files,omitempty"`
+	Ids         []int64  `protobuf:"varint,3,rep,packed,name=ids" json:"ids,omitempty"`
+	All         bool     `protobuf:"varint,4,opt,name=all" json:"all,omitempty"`
+	Principal   string   `protobuf:"bytes,5,opt,name=principal" json:"principal,omitempty"`
+	Since       int64    `protobuf:"varint,6,opt,name=since" json:"since,omitempty"`
+	Until       int64    `protobuf:"varint,7,opt,name=until" json:"until,omitempty"`
+	PausedLines int64    `protobuf:"varint,8,opt,name=paused_lines,json=pausedLines" json:"paused_lines,omitempty"`
+}
+
+func (m *Pause) Reset()                    { *m = Pause{} }
+func (m *Pause) String() string            { return proto.CompactTextString(m) }
+func (*Pause) ProtoMessage()               {}
+func (*Pause) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *Pause) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Pause) GetFiles() []string {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+func (m *Pause) GetIds() []int64 {
+	if m != nil {
+		return m.Ids
+	}
+	return nil
+}
+
+func (m *Pause) GetAll() bool {
+	if m != nil {
+		return m.All
+	}
+	return false
+}
+
+func (m *Pause) GetPrincipal() string {
+	if m != nil {
+		return m.Principal
+	}
+	return ""
+}
+
+func (m *Pause) GetSince() int64 {
+	if m != nil {
+		return m.Since
+	}
+	return 0
+}
+
+func (m *Pause) GetUntil() int64 {
+	if m != nil {
+		return m.Until
+	}
+	return 0
+}
+
+func (m *Pause) GetPausedLines() int64 {
+	if m != nil {
+		return m.PausedLines
+	}
+	return 0
+}
+
+type SeekFileRequest struct {
+	FilePath string `protobuf:"bytes,1,opt,name=file_path,json=filePath" json:"file_path,omitempty"`
+	Offset   int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+}
+
+func (m *SeekFileRequest) Reset()                    { *m = SeekFileRequest{} }
+func (m *SeekFileRequest) String() string            { return proto.CompactTextString(m) }
+func (*SeekFileRequest) ProtoMessage()               {}
+func (*SeekFileRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *SeekFileRequest) GetFilePath() string {
+	if m != nil {
+		return m.FilePath
+	}
+	return ""
+}
+
+func (m *SeekFileRequest) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+type SeekFileResponse struct {
+}
+
+func (m *SeekFileResponse) Reset()                    { *m = SeekFileResponse{} }
+func (m *SeekFileResponse) String() string            { return proto.CompactTextString(m) }
+func (*SeekFileResponse) ProtoMessage()               {}
+func (*SeekFileResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type GetWorkerStatusRequest struct {
+}
+
+func (m *GetWorkerStatusRequest) Reset()                    { *m = GetWorkerStatusRequest{} }
+func (m *GetWorkerStatusRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetWorkerStatusRequest) ProtoMessage()               {}
+func (*GetWorkerStatusRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+type WorkerStatus struct {
+	Files []string `protobuf:"bytes,1,rep,name=files" json:"files,omitempty"`
+	Json  []byte   `protobuf:"bytes,2,opt,name=json,proto3" json:"json,omitempty"`
+}
+
+func (m *WorkerStatus) Reset()                    { *m = WorkerStatus{} }
+func (m *WorkerStatus) String() string            { return proto.CompactTextString(m) }
+func (*WorkerStatus) ProtoMessage()               {}
+func (*WorkerStatus) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *WorkerStatus) GetFiles() []string {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+func (m *WorkerStatus) GetJson() []byte {
+	if m != nil {
+		return m.Json
+	}
+	return nil
+}
+
+type TestStrategyRequest struct {
+	Log string `protobuf:"bytes,1,opt,name=log" json:"log,omitempty"`
+}
+
+func (m *TestStrategyRequest) Reset()                    { *m = TestStrategyRequest{} }
+func (m *TestStrategyRequest) String() string            { return proto.CompactTextString(m) }
+func (*TestStrategyRequest) ProtoMessage()               {}
+func (*TestStrategyRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *TestStrategyRequest) GetLog() string {
+	if m != nil {
+		return m.Log
+	}
+	return ""
+}
+
+type Match struct {
+	Strategy *Strategy         `protobuf:"bytes,1,opt,name=strategy" json:"strategy,omitempty"`
+	Detail   map[string]string `protobuf:"bytes,2,rep,name=detail" json:"detail,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *Match) Reset()                    { *m = Match{} }
+func (m *Match) String() string            { return proto.CompactTextString(m) }
+func (*Match) ProtoMessage()               {}
+func (*Match) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *Match) GetStrategy() *Strategy {
+	if m != nil {
+		return m.Strategy
+	}
+	return nil
+}
+
+func (m *Match) GetDetail() map[string]string {
+	if m != nil {
+		return m.Detail
+	}
+	return nil
+}
+
+type TestStrategyResponse struct {
+	Matched bool     `protobuf:"varint,1,opt,name=matched" json:"matched,omitempty"`
+	Matches []*Match `protobuf:"bytes,2,rep,name=matches" json:"matches,omitempty"`
+}
+
+func (m *TestStrategyResponse) Reset()                    { *m = TestStrategyResponse{} }
+func (m *TestStrategyResponse) String() string            { return proto.CompactTextString(m) }
+func (*TestStrategyResponse) ProtoMessage()               {}
+func (*TestStrategyResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *TestStrategyResponse) GetMatched() bool {
+	if m != nil {
+		return m.Matched
+	}
+	return false
+}
+
+func (m *TestStrategyResponse) GetMatches() []*Match {
+	if m != nil {
+		return m.Matches
+	}
+	return nil
+}
+
+type StreamEventsRequest struct {
+	StrategyId int64 `protobuf:"varint,1,opt,name=strategy_id,json=strategyId" json:"strategy_id,omitempty"`
+	Verbose    bool  `protobuf:"varint,2,opt,name=verbose" json:"verbose,omitempty"`
+}
+
+func (m *StreamEventsRequest) Reset()                    { *m = StreamEventsRequest{} }
+func (m *StreamEventsRequest) String() string            { return proto.CompactTextString(m) }
+func (*StreamEventsRequest) ProtoMessage()               {}
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *StreamEventsRequest) GetStrategyId() int64 {
+	if m != nil {
+		return m.StrategyId
+	}
+	return 0
+}
+
+func (m *StreamEventsRequest) GetVerbose() bool {
+	if m != nil {
+		return m.Verbose
+	}
+	return false
+}
+
+type Event struct {
+	Type       string            `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
+	StrategyId int64             `protobuf:"varint,2,opt,name=strategy_id,json=strategyId" json:"strategy_id,omitempty"`
+	LogTms     int64             `protobuf:"varint,3,opt,name=log_tms,json=logTms" json:"log_tms,omitempty"`
+	HasValue   bool              `protobuf:"varint,4,opt,name=has_value,json=hasValue" json:"has_value,omitempty"`
+	Value      float64           `protobuf:"fixed64,5,opt,name=value" json:"value,omitempty"`
+	Tags       map[string]string `protobuf:"bytes,6,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Line       string            `protobuf:"bytes,7,opt,name=line" json:"line,omitempty"`
+	Reason     string            `protobuf:"bytes,8,opt,name=reason" json:"reason,omitempty"`
+	Sent       int64             `protobuf:"varint,9,opt,name=sent" json:"sent,omitempty"`
+	Dropped    int64             `protobuf:"varint,10,opt,name=dropped" json:"dropped,omitempty"`
+}
+
+func (m *Event) Reset()                    { *m = Event{} }
+func (m *Event) String() string            { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()               {}
+func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *Event) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Event) GetStrategyId() int64 {
+	if m != nil {
+		return m.StrategyId
+	}
+	return 0
+}
+
+func (m *Event) GetLogTms() int64 {
+	if m != nil {
+		return m.LogTms
+	}
+	return 0
+}
+
+func (m *Event) GetHasValue() bool {
+	if m != nil {
+		return m.HasValue
+	}
+	return false
+}
+
+func (m *Event) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+func (m *Event) GetTags() map[string]string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *Event) GetLine() string {
+	if m != nil {
+		return m.Line
+	}
+	return ""
+}
+
+func (m *Event) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *Event) GetSent() int64 {
+	if m != nil {
+		return m.Sent
+	}
+	return 0
+}
+
+func (m *Event) GetDropped() int64 {
+	if m != nil {
+		return m.Dropped
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*Strategy)(nil), "falcon.logagent.control.v1.Strategy")
+	proto.RegisterType((*ListStrategiesRequest)(nil), "falcon.logagent.control.v1.ListStrategiesRequest")
+	proto.RegisterType((*ListStrategiesResponse)(nil), "falcon.logagent.control.v1.ListStrategiesResponse")
+	proto.RegisterType((*GetStrategyStatsRequest)(nil), "falcon.logagent.control.v1.GetStrategyStatsRequest")
+	proto.RegisterType((*StrategyStats)(nil), "falcon.logagent.control.v1.StrategyStats")
+	proto.RegisterType((*PauseStrategiesRequest)(nil), "falcon.logagent.control.v1.PauseStrategiesRequest")
+	proto.RegisterType((*Pause)(nil), "falcon.logagent.control.v1.Pause")
+	proto.RegisterType((*SeekFileRequest)(nil), "falcon.logagent.control.v1.SeekFileRequest")
+	proto.RegisterType((*SeekFileResponse)(nil), "falcon.logagent.control.v1.SeekFileResponse")
+	proto.RegisterType((*GetWorkerStatusRequest)(nil), "falcon.logagent.control.v1.GetWorkerStatusRequest")
+	proto.RegisterType((*WorkerStatus)(nil), "falcon.logagent.control.v1.WorkerStatus")
+	proto.RegisterType((*TestStrategyRequest)(nil), "falcon.logagent.control.v1.TestStrategyRequest")
+	proto.RegisterType((*Match)(nil), "falcon.logagent.control.v1.Match")
+	proto.RegisterType((*TestStrategyResponse)(nil), "falcon.logagent.control.v1.TestStrategyResponse")
+	proto.RegisterType((*StreamEventsRequest)(nil), "falcon.logagent.control.v1.StreamEventsRequest")
+	proto.RegisterType((*Event)(nil), "falcon.logagent.control.v1.Event")
+}
+
+func init() { proto.RegisterFile("control.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 1203 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x57, 0xdb, 0x6e, 0xe3, 0x44,
+	0x18, 0x96, 0xe3, 0x36, 0x4d, 0xfe, 0xa4, 0x87, 0x9d, 0xee, 0x76, 0xad, 0x00, 0x22, 0x6b, 0x90,
+	0x08, 0x87, 0xa6, 0xbb, 0x59, 0x24, 0x96, 0xe5, 0x02, 0xb4, 0x3d, 0xac, 0x10, 0x0b, 0xaa, 0x9c,
+	0x0a, 0x24, 0x2e, 0xb0, 0xa6, 0xf6, 0xc4, 0x31, 0x75, 0x3c, 0x5e, 0xcf, 0xb8, 0x4b, 0xde, 0x81,
+	0x1b, 0xde, 0x83, 0x67, 0xe0, 0x05, 0x78, 0x01, 0x2e, 0x78, 0x18, 0x34, 0x27, 0xe7, 0xd0, 0xd4,
+	0xcd, 0x72, 0x37, 0xff, 0x69, 0xfc, 0xcd, 0x3f, 0xdf, 0x7c, 0x7f, 0x02, 0xdb, 0x01, 0x4d, 0x79,
+	0x4e, 0x93, 0x7e, 0x96, 0x53, 0x4e, 0x51, 0x67, 0x84, 0x93, 0x80, 0xa6, 0xfd, 0x84, 0x46, 0x38,
+	0x22, 0x29, 0xef, 0x9b, 0xf0, 0xf5, 0x13, 0xf7, 0x4f, 0x1b, 0x1a, 0x43, 0x9e, 0x63, 0x4e, 0xa2,
+	0x29, 0xda, 0x81, 0x5a, 0x1c, 0x3a, 0x56, 0xd7, 0xea, 0xd9, 0x5e, 0x2d, 0x0e, 0x11, 0x82, 0x8d,
+	0x14, 0x4f, 0x88, 0x53, 0xeb, 0x5a, 0xbd, 0xa6, 0x27, 0xd7, 0xe8, 0x1d, 0x68, 0x8e, 0xe2, 0x84,
+	0xf8, 0x19, 0xe6, 0x63, 0xc7, 0x96, 0x81, 0x86, 0x70, 0x9c, 0x63, 0x3e, 0x46, 0xef, 0x43, 0x8b,
+	0xc7, 0x13, 0xe2, 0x8f, 0x68, 0x3e, 0xc1, 0xdc, 0xd9, 0x90, 0x61, 0x10, 0xae, 0x33, 0xe9, 0x41,
+	0x0e, 0x6c, 0x65, 0x98, 0x73, 0x92, 0xa7, 0xce, 0xa6, 0x0c, 0x1a, 0x53, 0x44, 0xc8, 0x6f, 0x41,
+	0x52, 0x84, 0xc4, 0xa9, 0xab, 0x88, 0x36, 0x05, 0x8a, 0x51, 0x91, 0x06, 0xce, 0x96, 0x42, 0x21,
+	0xd6, 0xc2, 0xc7, 0x38, 0xc9, 0x9c, 0x86, 0xc4, 0x2a, 0xd7, 0xe8, 0x05, 0x6c, 0x70, 0x1c, 0x31,
+	0xa7, 0xd9, 0xb5, 0x7b, 0xad, 0x41, 0xbf, 0x7f, 0xfb, 0xa9, 0xfb, 0xe6, 0xc4, 0xfd, 0x0b, 0x1c,
+	0xb1, 0xd3, 0x94, 0xe7, 0x53, 0x4f, 0xd6, 0xa2, 0xf7, 0x00, 0x32, 0x9c, 0x33, 0xe2, 0xb3, 0x22,
+	0x08, 0x1c, 0xe8, 0x5a, 0xbd, 0x86, 0xd7, 0x94, 0x9e, 0x61, 0x11, 0x04, 0xe8, 0x00, 0xea, 0x8c,
+	0x63, 0x5e, 0x30, 0xa7, 0x25, 0xc1, 0x68, 0x0b, 0x75, 0xa0, 0xf1, 0x06, 0xe7, 0x69, 0x9c, 0x46,
+	0xcc, 0x69, 0x77, 0x6d, 0xd1, 0x13, 0x63, 0x0b, 0xa8, 0xbf, 0x32, 0x9a, 0x3a, 0xdb, 0x5d, 0xab,
+	0xd7, 0xf6, 0xe4, 0xba, 0xf3, 0x05, 0x34, 0xcb, 0x2f, 0xa3, 0x3d, 0xb0, 0xaf, 0xc8, 0x54, 0xb6,
+	0xbd, 0xe9, 0x89, 0x25, 0xba, 0x0f, 0x9b, 0xd7, 0x38, 0x29, 0x4c, 0xe3, 0x95, 0xf1, 0xbc, 0xf6,
+	0xcc, 0x72, 0x1f, 0xc2, 0x83, 0x57, 0x31, 0xe3, 0x1a, 0x7f, 0x4c, 0x98, 0x47, 0x5e, 0x17, 0x84,
+	0x71, 0xf7, 0x17, 0x38, 0x58, 0x0e, 0xb0, 0x8c, 0xa6, 0x8c, 0xa0, 0x13, 0x00, 0x56, 0x7a, 0x1d,
+	0x4b, 0x36, 0xe7, 0xc3, 0x75, 0x9a, 0xe3, 0xcd, 0xd5, 0xb9, 0xcf, 0xe1, 0xe1, 0x4b, 0x62, 0xb6,
+	0x9f, 0x0e, 0x39, 0xe6, 0xe6, 0xd3, 0xe2, 0xd2, 0x75, 0xe2, 0xd4, 0x2f, 0xe9, 0x63, 0x6a, 0xa7,
+	0xdf, 0x86, 0xee, 0x3f, 0x36, 0x6c, 0x2f, 0x54, 0xde, 0x59, 0x22, 0x1a, 0x9d, 0xe1, 0x82, 0x91,
+	0x50, 0xb7, 0x40, 0x5b, 0x82, 0x25, 0x13, 0xcc, 0x83, 0x31, 0x09, 0x25, 0xf7, 0x6c, 0xcf, 0x98,
+	0xa8, 0x07, 0x7b, 0x93, 0x82, 0x71, 0x3f, 0xa5, 0xdc, 0x17, 0x87, 0xc1, 0x71, 0x2a, 0xf9, 0x67,
+	0x7b, 0x3b, 0xc2, 0xff, 0x03, 0xe5, 0xc7, 0xca, 0x2b, 0x2e, 0x4b, 0x53, 0x2b, 0x94, 0x24, 0xb4,
+	0xbd, 0xd2, 0x46, 0x7d, 0xd8, 0x97, 0xcd, 0xf6, 0x73, 0x9c, 0x46, 0xc4, 0x0f, 0x73, 0x9a, 0x65,
+	0x24, 0x94, 0x8c, 0xb4, 0xbd, 0x7b, 0x32, 0xe4, 0x89, 0xc8, 0x89, 0x0a, 0x2c, 0xe7, 0x07, 0x09,
+	0x9e, 0x88, 0xfc, 0xad, 0xe5, 0xfc, 0x63, 0x15, 0x10, 0x28, 0xe7, 0xf3, 0xaf, 0x48, 0xc6, 0x35,
+	0x87, 0x77, 0x66, 0xc9, 0xdf, 0x91, 0x8c, 0xa3, 0x0f, 0x60, 0x9b, 0xe3, 0xc8, 0xe7, 0x79, 0x91,
+	0x06, 0x98, 0x93, 0xd0, 0x69, 0xca, 0xb4, 0x36, 0xc7, 0xd1, 0x85, 0xf1, 0xc9, 0xf7, 0x86, 0xa3,
+	0x12, 0x26, 0xa8, 0x3e, 0x72, 0x1c, 0x19, 0x7c, 0x03, 0x78, 0x10, 0xd0, 0x24, 0xf4, 0x19, 0xc7,
+	0x39, 0xf7, 0x59, 0x91, 0x65, 0x39, 0x61, 0xa2, 0xad, 0x2d, 0x99, 0xba, 0x2f, 0x82, 0x43, 0x11,
+	0x1b, 0x96, 0x21, 0xf4, 0x09, 0xdc, 0x9b, 0xab, 0xe1, 0x38, 0x8a, 0x48, 0xe8, 0xb4, 0x65, 0xfe,
+	0x6e, 0x99, 0x7f, 0x21, 0xdd, 0xee, 0xef, 0x16, 0x1c, 0x9c, 0x8b, 0xab, 0xb9, 0xc1, 0x48, 0x41,
+	0x62, 0xa1, 0x0b, 0x8a, 0x72, 0x4d, 0x4f, 0x19, 0x82, 0xec, 0x71, 0xc8, 0x9c, 0x5a, 0xd7, 0xee,
+	0xd9, 0x9e, 0x58, 0x0a, 0x0f, 0x4e, 0x12, 0x79, 0x9d, 0x0d, 0x4f, 0x2c, 0xc5, 0x05, 0x85, 0x45,
+	0x8e, 0x79, 0x4c, 0x53, 0x2d, 0x21, 0xa5, 0x8d, 0xde, 0x85, 0x66, 0x96, 0xc7, 0x69, 0x10, 0x67,
+	0x38, 0xd1, 0x12, 0x32, 0x73, 0xb8, 0x7f, 0x59, 0xb0, 0x29, 0xe1, 0xdc, 0x90, 0xb2, 0x12, 0x4d,
+	0x6d, 0x05, 0x1a, 0xfb, 0x06, 0x9a, 0x8d, 0x19, 0x9a, 0xca, 0x2f, 0x8a, 0x7d, 0x59, 0x9c, 0x06,
+	0x44, 0x53, 0x44, 0x19, 0xc2, 0x5b, 0xa4, 0x3c, 0x4e, 0x34, 0x11, 0x94, 0x81, 0x1e, 0x41, 0x5b,
+	0xd1, 0xd8, 0x4f, 0xe2, 0x94, 0x30, 0x7d, 0xf1, 0x2d, 0xe5, 0x7b, 0x25, 0x5c, 0xee, 0x19, 0xec,
+	0x0e, 0x09, 0xb9, 0x3a, 0x8b, 0x13, 0x62, 0xfa, 0xb8, 0x20, 0xb8, 0xd6, 0x92, 0xe0, 0x1e, 0x40,
+	0x9d, 0x8e, 0x46, 0x8c, 0x70, 0xf9, 0x4e, 0x6c, 0x4f, 0x5b, 0x2e, 0x82, 0xbd, 0xd9, 0x3e, 0x4a,
+	0x08, 0x5c, 0x07, 0x0e, 0x5e, 0x12, 0xfe, 0x13, 0xcd, 0xaf, 0x48, 0x3e, 0x94, 0xba, 0x65, 0xc4,
+	0xe3, 0x19, 0xb4, 0xe7, 0xdd, 0xb7, 0x5c, 0x9d, 0x11, 0xb2, 0xda, 0x4c, 0xc8, 0xdc, 0x8f, 0x60,
+	0xff, 0x82, 0x94, 0xb2, 0x33, 0x35, 0x98, 0xf7, 0xc0, 0x4e, 0x68, 0x64, 0x24, 0x2d, 0xa1, 0x91,
+	0xfb, 0xb7, 0x05, 0x9b, 0xdf, 0x8b, 0xa7, 0x8a, 0xbe, 0x81, 0x86, 0x79, 0xe8, 0x32, 0x61, 0x5d,
+	0x35, 0x2a, 0xab, 0xd0, 0x29, 0xd4, 0x43, 0xc2, 0x71, 0x9c, 0xc8, 0xcb, 0x6c, 0x0d, 0x0e, 0xab,
+	0xea, 0xe5, 0x47, 0xfb, 0x27, 0x32, 0x5f, 0x29, 0xbd, 0x2e, 0xee, 0x7c, 0x09, 0xad, 0x39, 0xf7,
+	0x5b, 0xc9, 0xf0, 0x04, 0xee, 0x2f, 0x1e, 0x5b, 0x6b, 0xed, 0x9c, 0x3c, 0x59, 0x92, 0x41, 0xc6,
+	0x44, 0x5f, 0x99, 0x08, 0xd3, 0xa0, 0x1f, 0xdd, 0x09, 0xda, 0x14, 0x33, 0xf7, 0x1c, 0xf6, 0x87,
+	0x3c, 0x27, 0x78, 0x72, 0x7a, 0x4d, 0xd2, 0xf5, 0x85, 0x57, 0xc0, 0xb9, 0x26, 0xf9, 0x25, 0x65,
+	0xea, 0x08, 0x0d, 0xcf, 0x98, 0xee, 0xbf, 0x35, 0xd8, 0x94, 0x9b, 0x89, 0x5b, 0xe5, 0xd3, 0x8c,
+	0xe8, 0x73, 0xcb, 0xf5, 0xf2, 0xc6, 0xb5, 0x1b, 0x1b, 0x3f, 0x84, 0xad, 0x84, 0x46, 0x3e, 0x9f,
+	0x30, 0x2d, 0xc3, 0xf5, 0x84, 0x46, 0x17, 0x13, 0x26, 0xc8, 0x3a, 0xc6, 0xcc, 0x57, 0x6d, 0x53,
+	0x8f, 0xa8, 0x31, 0xc6, 0xec, 0x47, 0x61, 0xcf, 0xfa, 0x29, 0x5e, 0x91, 0xa5, 0xfb, 0x89, 0xbe,
+	0xd6, 0x63, 0xbb, 0x2e, 0xdb, 0xf2, 0x69, 0x55, 0x5b, 0x24, 0xe2, 0x1b, 0x33, 0x1b, 0xc1, 0x86,
+	0x78, 0x4f, 0xe6, 0xf7, 0x81, 0x58, 0x8b, 0x77, 0x91, 0x13, 0x2c, 0xd8, 0xda, 0x50, 0xf3, 0x43,
+	0x59, 0x22, 0x97, 0x91, 0x94, 0x6b, 0x31, 0x95, 0x6b, 0xd1, 0xa5, 0x45, 0x01, 0x35, 0xe6, 0xff,
+	0x1e, 0xd3, 0x83, 0x3f, 0xea, 0xb0, 0x75, 0xac, 0x70, 0xa3, 0x37, 0xb0, 0xb3, 0x38, 0x99, 0xd1,
+	0x93, 0xaa, 0x33, 0xae, 0x1c, 0xef, 0x9d, 0xc1, 0xdb, 0x94, 0x68, 0x32, 0xe6, 0xb0, 0xb7, 0x3c,
+	0xb2, 0xd1, 0xd3, 0xaa, 0x7d, 0x6e, 0x19, 0xf0, 0x9d, 0x8f, 0xd7, 0x79, 0x9f, 0x6a, 0xff, 0x31,
+	0xec, 0x2e, 0x8d, 0x03, 0x54, 0x09, 0x7d, 0xf5, 0xec, 0xe8, 0x3c, 0xba, 0xb3, 0x06, 0x11, 0x68,
+	0x18, 0x85, 0x43, 0x95, 0xa4, 0x59, 0xd2, 0xd3, 0xce, 0x67, 0xeb, 0x25, 0xeb, 0x26, 0x52, 0xd8,
+	0x5d, 0x12, 0xcd, 0xea, 0x03, 0xad, 0x56, 0xd8, 0x4e, 0xaf, 0xaa, 0x66, 0x61, 0xf7, 0xd7, 0xd0,
+	0x9e, 0x97, 0x16, 0x74, 0x54, 0x55, 0xb9, 0x42, 0x7b, 0x3b, 0x8f, 0xd7, 0x2f, 0xd0, 0x67, 0x0c,
+	0xa1, 0x3d, 0x2f, 0x2f, 0xd5, 0x9f, 0x5c, 0x21, 0x44, 0xd5, 0xd7, 0x25, 0x53, 0x1f, 0x5b, 0x2f,
+	0x3e, 0xff, 0x79, 0x10, 0xc5, 0x7c, 0x5c, 0x5c, 0xf6, 0x03, 0x3a, 0x39, 0x0a, 0xe3, 0x30, 0x3e,
+	0x52, 0x55, 0x87, 0x09, 0x8d, 0x0e, 0x65, 0xd9, 0x51, 0x94, 0x67, 0x01, 0xce, 0xe2, 0x23, 0x5d,
+	0x9e, 0x5d, 0x5e, 0xd6, 0xe5, 0x5f, 0x98, 0xa7, 0xff, 0x0d, 0x00, 0x29, 0x0c, 0xfe, 0x11, 0xd3,
+	0x0c, 0x00, 0x00,
+}
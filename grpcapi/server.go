@@ -0,0 +1,406 @@
+// Package grpcapi is the gRPC control api of the agent, see controlpb/control.proto
+// grpc-go没有vendor, 按gRPC的HTTP/2协议直接用net/http实现服务端, 明文时为h2c
+package grpcapi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/grpcapi/controlpb"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/reader/otlplogpb"
+	"github.com/didi/falcon-log-agent/service"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ServicePath is the path prefix of the methods of the Control service
+const ServicePath = "/falcon.logagent.control.v1.Control/"
+
+// OTLPLogsExportPath is the Export method of the OTLP/gRPC LogsService, 与控制接口在同一端口
+const OTLPLogsExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// maxMessageSize 请求消息的最大字节数, 与grpc-go默认值相同
+const maxMessageSize = 4 << 20
+
+// gRPC状态码
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeNotFound          = 5
+	codeAlreadyExists     = 6
+	codeInternal          = 13
+	codeUnimplemented     = 12
+	codeUnauthenticated   = 16
+	codeResourceExhausted = 8
+)
+
+// statusError is an error with its gRPC status code
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+// method is one rpc, read to decode the request, send to write one response message
+// 一元调用只send一次, 流式调用send多次
+type method func(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error
+
+var methods = map[string]method{
+	"ListStrategies":   listStrategies,
+	"GetStrategyStats": getStrategyStats,
+	"PauseStrategies":  pauseStrategies,
+	"SeekFile":         seekFile,
+	"GetWorkerStatus":  getWorkerStatus,
+	"TestStrategy":     testStrategy,
+	"StreamEvents":     streamEvents,
+}
+
+// Start to serve the control api on addr, blocks until the listener fails
+func Start(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		dlog.Errorf("grpc control api listen failed [addr:%s][err:%v]", addr, err)
+		return
+	}
+	dlog.Infof("grpc control api listening [addr:%s]", addr)
+	if err := Serve(ln); err != nil {
+		dlog.Errorf("grpc control api stopped [addr:%s][err:%v]", addr, err)
+	}
+}
+
+// Serve to serve the control api on the listener with h2c
+func Serve(ln net.Listener) error {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Handler: http.HandlerFunc(serveGRPC), Protocols: protocols}
+	return srv.Serve(ln)
+}
+
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	m, ok := lookupMethod(r.URL.Path)
+	if !ok {
+		writeStatus(w, false, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	// 与HTTP接口相同的token
+	if !service.Authorized(service.BearerToken(r.Header.Get("Authorization"))) {
+		writeStatus(w, false, codeUnauthenticated, "bad token")
+		return
+	}
+
+	started := false
+	read := func(msg proto.Message) error {
+		return readMessage(r.Body, msg)
+	}
+	send := func(msg proto.Message) error {
+		if !started {
+			started = true
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := writeMessage(w, msg); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+	err := m(r, read, send)
+	code, msg := codeOf(err)
+	if code != codeOK {
+		dlog.Warningf("grpc call failed [method:%s][remote:%s][code:%d][err:%s]", r.URL.Path, r.RemoteAddr, code, msg)
+	}
+	writeStatus(w, started, code, msg)
+}
+
+// lookupMethod to find the rpc of a path, Control的方法及OTLP日志的Export
+func lookupMethod(path string) (method, bool) {
+	if path == OTLPLogsExportPath {
+		return exportLogs, true
+	}
+	if !strings.HasPrefix(path, ServicePath) {
+		return nil, false
+	}
+	m, ok := methods[strings.TrimPrefix(path, ServicePath)]
+	return m, ok
+}
+
+// writeStatus to end the call with grpc-status, 没有消息时只有header(Trailers-Only)
+// 已发送过消息时, 在send中声明过的trailer里给出
+func writeStatus(w http.ResponseWriter, started bool, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", encodeGrpcMessage(msg))
+	}
+	if !started {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// codeOf to map an error of the service layer to the gRPC status code
+func codeOf(err error) (int, string) {
+	if err == nil {
+		return codeOK, ""
+	}
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.code, se.msg
+	case errors.Is(err, service.ErrInvalid):
+		return codeInvalidArgument, err.Error()
+	case errors.Is(err, service.ErrNotFound):
+		return codeNotFound, err.Error()
+	case errors.Is(err, service.ErrUnimplemented):
+		return codeUnimplemented, err.Error()
+	case errors.Is(err, service.ErrConflict):
+		return codeAlreadyExists, err.Error()
+	}
+	return codeInternal, err.Error()
+}
+
+// encodeGrpcMessage to percent-encode grpc-message as the spec requires
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// readMessage to read one length-prefixed message: 1字节压缩标志 + 4字节大端长度 + 消息
+func readMessage(r io.Reader, msg proto.Message) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return &statusError{code: codeInvalidArgument, msg: "read request: " + err.Error()}
+	}
+	if prefix[0] != 0 {
+		return &statusError{code: codeUnimplemented, msg: "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxMessageSize {
+		return &statusError{code: codeResourceExhausted, msg: fmt.Sprintf("message of %d bytes exceeds %d", n, maxMessageSize)}
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return &statusError{code: codeInvalidArgument, msg: "read request: " + err.Error()}
+	}
+	if err := proto.Unmarshal(buf, msg); err != nil {
+		return &statusError{code: codeInvalidArgument, msg: "decode request: " + err.Error()}
+	}
+	return nil
+}
+
+func writeMessage(w io.Writer, msg proto.Message) error {
+	bs, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5+len(bs))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(bs)))
+	copy(frame[5:], bs)
+	_, err = w.Write(frame)
+	return err
+}
+
+// exportLogs to feed OTLP/gRPC logs to the readers of otlp:// strategies, 与POST /v1/logs相同
+func exportLogs(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(otlplogpb.ExportLogsServiceRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	reader.ExportOTLPLogs(req)
+	return send(new(otlplogpb.ExportLogsServiceResponse))
+}
+
+func listStrategies(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	if err := read(new(controlpb.ListStrategiesRequest)); err != nil {
+		return err
+	}
+	resp := new(controlpb.ListStrategiesResponse)
+	for _, st := range service.ListStrategies() {
+		resp.Strategies = append(resp.Strategies, toPBStrategy(st))
+	}
+	return send(resp)
+}
+
+func getStrategyStats(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(controlpb.GetStrategyStatsRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	s, err := service.GetStrategyStats(req.StrategyId)
+	if err != nil {
+		return err
+	}
+	return send(&controlpb.StrategyStats{
+		StrategyId:          s.StrategyID,
+		Paused:              s.Paused,
+		Matched:             s.Funnel.Matched,
+		MustNotContain:      s.Funnel.MustNotContain,
+		Excluded:            s.Funnel.Excluded,
+		ValueRangeDropped:   s.ValueRange.Dropped,
+		ValueRangeClamped:   s.ValueRange.Clamped,
+		ValueRangeKept:      s.ValueRange.Kept,
+		TagTruncated:        s.TagOversize.Truncated,
+		TagDropped:          s.TagOversize.Dropped,
+		ColdStartSuppressed: s.ColdStart.Suppressed,
+		ColdStartTagged:     s.ColdStart.Tagged,
+	})
+}
+
+func pauseStrategies(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(controlpb.PauseStrategiesRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	// 与HTTP一致, 未指定时用x-principal或来源地址
+	principal := req.Principal
+	if principal == "" {
+		principal = r.Header.Get("X-Principal")
+	}
+	if principal == "" {
+		principal, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	sel := worker.PauseSelector{Files: req.Files, IDs: req.Ids, All: req.All}
+	p, err := service.PauseStrategies(sel, principal, req.Duration)
+	if err != nil {
+		return err
+	}
+	return send(&controlpb.Pause{
+		Id:          p.ID,
+		Files:       p.Selector.Files,
+		Ids:         p.Selector.IDs,
+		All:         p.Selector.All,
+		Principal:   p.Principal,
+		Since:       p.Since,
+		Until:       p.Until,
+		PausedLines: p.PausedLines,
+	})
+}
+
+func seekFile(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(controlpb.SeekFileRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	if err := service.SeekFile(req.FilePath, req.Offset); err != nil {
+		return err
+	}
+	return send(new(controlpb.SeekFileResponse))
+}
+
+func getWorkerStatus(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	if err := read(new(controlpb.GetWorkerStatusRequest)); err != nil {
+		return err
+	}
+	st := service.GetStatus()
+	bs, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	resp := &controlpb.WorkerStatus{Json: bs}
+	for file := range st.Files {
+		resp.Files = append(resp.Files, file)
+	}
+	sort.Strings(resp.Files)
+	return send(resp)
+}
+
+func testStrategy(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(controlpb.TestStrategyRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	ret := service.CheckLogByStrategy(req.Log)
+	resp := &controlpb.TestStrategyResponse{Matched: ret.Matched}
+	for _, b := range ret.Body {
+		resp.Matches = append(resp.Matches, &controlpb.Match{Strategy: toPBStrategy(b.Strategy), Detail: b.Detail})
+	}
+	return send(resp)
+}
+
+func streamEvents(r *http.Request, read func(proto.Message) error, send func(proto.Message) error) error {
+	req := new(controlpb.StreamEventsRequest)
+	if err := read(req); err != nil {
+		return err
+	}
+	client, err := service.SubscribeEvents(req.StrategyId, req.Verbose)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	dlog.Infof("grpc event stream connected [sid:%d][verbose:%v][remote:%s]", req.StrategyId, req.Verbose, r.RemoteAddr)
+	service.StreamEvents(client, r.Context().Done(), func(ev *worker.TapEvent) error {
+		return send(toPBEvent(ev))
+	})
+	dlog.Infof("grpc event stream disconnected [sid:%d][remote:%s]", req.StrategyId, r.RemoteAddr)
+	return nil
+}
+
+func toPBStrategy(st *scheme.Strategy) *controlpb.Strategy {
+	bs, _ := json.Marshal(st)
+	return &controlpb.Strategy{
+		Id:         st.ID,
+		Name:       st.Name,
+		FilePath:   st.FilePath,
+		TimeFormat: st.TimeFormat,
+		Pattern:    st.Pattern,
+		Exclude:    st.Exclude,
+		Func:       st.Func,
+		Step:       st.Interval,
+		Tags:       st.Tags,
+		ParseSucc:  st.ParseSucc,
+		Status:     st.Status,
+		Warnings:   st.Warnings,
+		Json:       bs,
+	}
+}
+
+func toPBEvent(ev *worker.TapEvent) *controlpb.Event {
+	e := &controlpb.Event{
+		Type:       ev.Type,
+		StrategyId: ev.StrategyID,
+		LogTms:     ev.LogTms,
+		Tags:       ev.Tags,
+		Line:       ev.Line,
+		Reason:     ev.Reason,
+		Sent:       ev.Sent,
+		Dropped:    ev.Dropped,
+	}
+	// 事件中的值是数字或"NaN"
+	switch v := ev.Value.(type) {
+	case float64:
+		e.HasValue, e.Value = true, v
+	case string:
+		e.HasValue, e.Value = true, math.NaN()
+	}
+	return e
+}
@@ -0,0 +1,398 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/grpcapi/controlpb"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/reader/otlplogpb"
+	"github.com/didi/falcon-log-agent/service"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const testToken = "s3cret"
+
+// bufListener is an in-process listener like grpc's bufconn, connections are net.Pipe
+type bufListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newBufListener() *bufListener {
+	return &bufListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *bufListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *bufListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *bufListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "bufconn", Net: "pipe"}
+}
+
+func (l *bufListener) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+var testClient *http.Client
+
+func TestMain(m *testing.M) {
+	dir, _ := ioutil.TempDir("", "grpcapi")
+	cfg := filepath.Join(dir, "test.cfg")
+	ioutil.WriteFile(cfg, []byte(`{"http": {"token": "`+testToken+`"}}`), 0644)
+	flag.Set("c", cfg)
+	g.InitConfig()
+
+	ln := newBufListener()
+	go Serve(ln)
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	testClient = &http.Client{Transport: &http.Transport{Protocols: protocols, DialContext: ln.Dial}}
+
+	code := m.Run()
+	ln.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func testStrategies(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, Name: "err", FilePath: "/var/log/app.log", TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Pattern: `cost=(\d+)`, Func: "cnt", Interval: 60, Tags: map[string]string{"code": `code=(\d+)`},
+		MaskPatterns: []scheme.MaskPattern{{Regex: `card=\d+`, Replacement: "card=***"}}}
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{st})
+	t.Cleanup(func() { strategy.UpdateGlobalStrategy(nil) })
+}
+
+// call to start a call, the caller reads the response
+// method为Control的方法名, 以/开头时为完整路径
+func call(ctx context.Context, method, token string, req proto.Message) (*http.Response, error) {
+	bs, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 5+len(bs))
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(bs)))
+	copy(body[5:], bs)
+	path := ServicePath + method
+	if strings.HasPrefix(method, "/") {
+		path = method
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", "http://bufconn"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/grpc")
+	hreq.Header.Set("TE", "trailers")
+	hreq.Header.Set("X-Principal", "ops")
+	if token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return testClient.Do(hreq)
+}
+
+// grpcCode to get grpc-status from trailers, or headers of a Trailers-Only response
+func grpcCode(resp *http.Response) (int, string) {
+	status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return -1, "bad grpc-status " + status
+	}
+	return code, msg
+}
+
+// invoke to make a unary call, resp is decoded when the status is OK
+func invoke(t *testing.T, method, token string, req, resp proto.Message) (int, string) {
+	t.Helper()
+	hresp, err := call(context.Background(), method, token, req)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	defer hresp.Body.Close()
+	var got [][]byte
+	for {
+		msg, err := readFrame(hresp.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: read response: %v", method, err)
+		}
+		got = append(got, msg)
+	}
+	code, msg := grpcCode(hresp)
+	if code == codeOK {
+		if len(got) != 1 {
+			t.Fatalf("%s: %d response messages", method, len(got))
+		}
+		if err := proto.Unmarshal(got[0], resp); err != nil {
+			t.Fatalf("%s: decode response: %v", method, err)
+		}
+	}
+	return code, msg
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+func TestAuth(t *testing.T) {
+	testStrategies(t)
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := invoke(t, "ListStrategies", token, new(controlpb.ListStrategiesRequest), new(controlpb.ListStrategiesResponse)); code != codeUnauthenticated {
+			t.Errorf("token %q: code %d, want %d", token, code, codeUnauthenticated)
+		}
+	}
+	if code, _ := invoke(t, "NoSuchMethod", testToken, new(controlpb.ListStrategiesRequest), new(controlpb.ListStrategiesResponse)); code != codeUnimplemented {
+		t.Errorf("unknown method: code %d", code)
+	}
+}
+
+func TestListStrategies(t *testing.T) {
+	testStrategies(t)
+	resp := new(controlpb.ListStrategiesResponse)
+	if code, msg := invoke(t, "ListStrategies", testToken, new(controlpb.ListStrategiesRequest), resp); code != codeOK {
+		t.Fatalf("code %d: %s", code, msg)
+	}
+	// 与HTTP /strategy一样取自service.ListStrategies
+	want := service.ListStrategies()
+	if len(resp.Strategies) != len(want) || resp.Strategies[0].Id != 1 || resp.Strategies[0].Tags["code"] != `code=(\d+)` || resp.Strategies[0].Step != 60 {
+		t.Fatalf("got %v", resp.Strategies)
+	}
+	wantJSON, _ := json.Marshal(want[0])
+	if !bytes.Equal(resp.Strategies[0].Json, wantJSON) {
+		t.Errorf("json %s, want %s", resp.Strategies[0].Json, wantJSON)
+	}
+}
+
+func TestGetStrategyStats(t *testing.T) {
+	testStrategies(t)
+	resp := new(controlpb.StrategyStats)
+	if code, msg := invoke(t, "GetStrategyStats", testToken, &controlpb.GetStrategyStatsRequest{StrategyId: 1}, resp); code != codeOK || resp.StrategyId != 1 {
+		t.Fatalf("code %d: %s, stats %v", code, msg, resp)
+	}
+	if code, _ := invoke(t, "GetStrategyStats", testToken, &controlpb.GetStrategyStatsRequest{StrategyId: 404}, resp); code != codeNotFound {
+		t.Errorf("missing strategy: code %d, want %d", code, codeNotFound)
+	}
+}
+
+func TestPauseStrategies(t *testing.T) {
+	testStrategies(t)
+	t.Cleanup(func() { worker.ResumeStrategies(worker.PauseSelector{IDs: []int64{1}}, "test") })
+
+	resp := new(controlpb.Pause)
+	code, msg := invoke(t, "PauseStrategies", testToken, &controlpb.PauseStrategiesRequest{Ids: []int64{1}, Duration: "30m"}, resp)
+	if code != codeOK {
+		t.Fatalf("code %d: %s", code, msg)
+	}
+	if resp.Principal != "ops" || len(resp.Ids) != 1 || resp.Until-resp.Since != 1800 {
+		t.Errorf("pause %v", resp)
+	}
+	if ps := worker.PauseStatus(1, "/var/log/app.log"); ps == "" {
+		t.Error("strategy not paused")
+	}
+	stats := new(controlpb.StrategyStats)
+	invoke(t, "GetStrategyStats", testToken, &controlpb.GetStrategyStatsRequest{StrategyId: 1}, stats)
+	if stats.Paused == "" {
+		t.Error("stats should show the pause")
+	}
+
+	for _, req := range []*controlpb.PauseStrategiesRequest{{}, {Ids: []int64{1}, Duration: "-1m"}} {
+		if code, _ := invoke(t, "PauseStrategies", testToken, req, resp); code != codeInvalidArgument {
+			t.Errorf("request %v: code %d, want %d", req, code, codeInvalidArgument)
+		}
+	}
+}
+
+func TestSeekFile(t *testing.T) {
+	code, _ := invoke(t, "SeekFile", testToken, &controlpb.SeekFileRequest{FilePath: "/var/log/app.log"}, new(controlpb.SeekFileResponse))
+	if code != codeUnimplemented {
+		t.Errorf("code %d, want %d", code, codeUnimplemented)
+	}
+}
+
+func TestGetWorkerStatus(t *testing.T) {
+	resp := new(controlpb.WorkerStatus)
+	if code, msg := invoke(t, "GetWorkerStatus", testToken, new(controlpb.GetWorkerStatusRequest), resp); code != codeOK {
+		t.Fatalf("code %d: %s", code, msg)
+	}
+	var st service.Status
+	if err := json.Unmarshal(resp.Json, &st); err != nil {
+		t.Fatalf("decode status %s: %v", resp.Json, err)
+	}
+	if len(st.Files) != len(resp.Files) {
+		t.Errorf("files %v of status %s", resp.Files, resp.Json)
+	}
+}
+
+func TestTestStrategy(t *testing.T) {
+	testStrategies(t)
+	resp := new(controlpb.TestStrategyResponse)
+	line := "2018-01-01 12:00:01 card=4111111111111111 code=500 cost=12"
+	if code, msg := invoke(t, "TestStrategy", testToken, &controlpb.TestStrategyRequest{Log: line}, resp); code != codeOK {
+		t.Fatalf("code %d: %s", code, msg)
+	}
+	if !resp.Matched || len(resp.Matches) != 1 || resp.Matches[0].Strategy.Id != 1 {
+		t.Fatalf("got %v", resp)
+	}
+	// 与HTTP /check相同的结果, 包括脱敏
+	want := service.CheckLogByStrategy(line)
+	for k, v := range want.Body[0].Detail {
+		if resp.Matches[0].Detail[k] != v {
+			t.Errorf("detail %s = %q, want %q", k, resp.Matches[0].Detail[k], v)
+		}
+	}
+	if resp.Matches[0].Detail["code"] != "code=500" {
+		t.Errorf("detail %v", resp.Matches[0].Detail)
+	}
+
+	resp = new(controlpb.TestStrategyResponse)
+	invoke(t, "TestStrategy", testToken, &controlpb.TestStrategyRequest{Log: "no time here"}, resp)
+	if resp.Matched {
+		t.Errorf("got %v", resp)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	testStrategies(t)
+	interval := service.StreamSummaryInterval
+	service.StreamSummaryInterval = 20 * time.Millisecond
+	t.Cleanup(func() { service.StreamSummaryInterval = interval })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := call(ctx, "StreamEvents", testToken, &controlpb.StreamEventsRequest{StrategyId: 1, Verbose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	for i := 0; i < 3; i++ {
+		msg, err := readFrame(resp.Body)
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		ev := new(controlpb.Event)
+		if err := proto.Unmarshal(msg, ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != worker.TapSummary || ev.StrategyId != 1 {
+			t.Errorf("event %v", ev)
+		}
+	}
+	if worker.TapClientCount() != 1 {
+		t.Errorf("tap clients %d, want 1", worker.TapClientCount())
+	}
+
+	// 客户端取消后服务端退订
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for worker.TapClientCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := worker.TapClientCount(); n != 0 {
+		t.Errorf("tap clients %d after cancel", n)
+	}
+
+	// 策略不存在时直接返回状态
+	resp2, err := call(context.Background(), "StreamEvents", testToken, &controlpb.StreamEventsRequest{StrategyId: 404})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp2.Body)
+	resp2.Body.Close()
+	if code, _ := grpcCode(resp2); code != codeNotFound {
+		t.Errorf("missing strategy: code %d", code)
+	}
+}
+
+func TestExportLogs(t *testing.T) {
+	stream := make(chan reader.Line, 10)
+	r, _ := reader.NewOTLPLogReader("otlp://payment", stream)
+	go r.Start()
+	defer r.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	str := func(s string) *otlplogpb.AnyValue {
+		return &otlplogpb.AnyValue{Value: &otlplogpb.AnyValue_StringValue{StringValue: s}}
+	}
+	req := &otlplogpb.ExportLogsServiceRequest{ResourceLogs: []*otlplogpb.ResourceLogs{
+		{
+			Resource: &otlplogpb.Resource{Attributes: []*otlplogpb.KeyValue{{Key: "service.name", Value: str("payment")}}},
+			ScopeLogs: []*otlplogpb.ScopeLogs{{LogRecords: []*otlplogpb.LogRecord{
+				{TimeUnixNano: 1700000000000000000, Body: str("pay cost=12")},
+				{ObservedTimeUnixNano: 1700000001000000000, Body: &otlplogpb.AnyValue{Value: &otlplogpb.AnyValue_KvlistValue{
+					KvlistValue: &otlplogpb.KeyValueList{Values: []*otlplogpb.KeyValue{
+						{Key: "cost", Value: &otlplogpb.AnyValue{Value: &otlplogpb.AnyValue_IntValue{IntValue: 7}}},
+					}},
+				}}},
+			}}},
+		},
+		{
+			Resource:  &otlplogpb.Resource{Attributes: []*otlplogpb.KeyValue{{Key: "service.name", Value: str("order")}}},
+			ScopeLogs: []*otlplogpb.ScopeLogs{{LogRecords: []*otlplogpb.LogRecord{{TimeUnixNano: 1700000002000000000, Body: str("order ok")}}}},
+		},
+	}}
+	if code, _ := invoke(t, OTLPLogsExportPath, "", req, new(otlplogpb.ExportLogsServiceResponse)); code != codeUnauthenticated {
+		t.Errorf("export without token: code %d", code)
+	}
+	if code, msg := invoke(t, OTLPLogsExportPath, testToken, req, new(otlplogpb.ExportLogsServiceResponse)); code != codeOK {
+		t.Fatalf("code %d: %s", code, msg)
+	}
+
+	// 行的格式与OTLP/HTTP json相同
+	if len(stream) != 2 {
+		t.Fatalf("otlp://payment should receive payment records only, got %d", len(stream))
+	}
+	if line := <-stream; line.Text != "1700000000000000000 pay cost=12" {
+		t.Errorf("unexpected line: %s", line.Text)
+	}
+	if line := <-stream; line.Text != `1700000001000000000 {"cost":7}` {
+		t.Errorf("unexpected structured line: %s", line.Text)
+	}
+}
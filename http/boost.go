@@ -0,0 +1,72 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/didi/falcon-log-agent/service"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// boostRequest is the body of POST /v1/strategy/:id/boost, DELETE时只用principal
+type boostRequest struct {
+	worker.BoostOverrides
+	TTL       string `json:"ttl"` //生效时长, 如10m, 超过boost_max_ttl时按上限
+	Principal string `json:"principal"`
+}
+
+// principal to get who operates, 未指定时用X-Principal头或来源IP
+func (r *boostRequest) principal(c *gin.Context) string {
+	if r.Principal != "" {
+		return r.Principal
+	}
+	if p := c.GetHeader("X-Principal"); p != "" {
+		return p
+	}
+	return c.ClientIP()
+}
+
+// BoostStrategy to apply temporary overrides to a strategy for troubleshooting
+func BoostStrategy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy id %s", c.Param("id")))
+		return
+	}
+	var req boostRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	b, err := service.BoostStrategy(id, req.principal(c), req.TTL, req.BoostOverrides)
+	if err != nil {
+		c.JSON(errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// ClearBoost to remove the boost of a strategy, the body is optional
+func ClearBoost(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy id %s", c.Param("id")))
+		return
+	}
+	var req boostRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	b, err := service.ClearBoost(id, req.principal(c))
+	if err != nil {
+		c.JSON(errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
@@ -1,12 +1,19 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/didi/falcon-log-agent/common/errstore"
 	"github.com/didi/falcon-log-agent/common/utils"
 
 	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/service"
 	"github.com/didi/falcon-log-agent/strategy"
 	"github.com/didi/falcon-log-agent/worker"
 
@@ -16,25 +23,188 @@ import (
 // Start http api
 func Start() {
 	router := gin.Default()
+	router.Use(authorize)
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, "ok")
 	})
 	router.GET("/strategy", func(c *gin.Context) {
-		c.JSON(http.StatusOK, strategy.GetListAll())
+		c.JSON(http.StatusOK, service.ListStrategies())
+	})
+	router.GET("/v1/strategy/:id/stats", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy id %s", c.Param("id")))
+			return
+		}
+		stats, err := service.GetStrategyStats(id)
+		if err != nil {
+			c.JSON(errorStatus(err), err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	// 维护期批量暂停/恢复策略
+	router.POST("/v1/pause", PauseStrategies)
+	router.POST("/v1/resume", ResumeStrategies)
+	router.GET("/v1/pause", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.GetPauses())
+	})
+
+	// change-set的生效情况, 推迟的change-set带有每个成员的原因
+	router.GET("/strategy/changesets", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"generation": strategy.Generation(),
+			"changesets": strategy.GetChangeSets(),
+		})
+	})
+
+	// 策略的实时事件流, 调试策略用
+	router.GET("/v1/strategy/:id/stream", StreamStrategy)
+	router.GET("/v1/strategy/:id/suggest-excludes", SuggestExcludes)
+
+	// 临时放宽单个策略的限制、打开调试, 到期自动恢复, 记录在/v1/worker/lifecycle
+	router.POST("/v1/strategy/:id/boost", BoostStrategy)
+	router.DELETE("/v1/strategy/:id/boost", ClearBoost)
+	router.GET("/v1/boost", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.GetBoosts())
+	})
+
+	// 当前周期如果立即结束将推送的内容, 排查与falcon不一致时使用
+	router.GET("/v1/push/preview", PushPreview)
+
+	// 发往外部sink的加噪值与精确值, 内部审计用
+	router.GET("/v1/noise/audit", NoiseAudit)
+	router.GET("/v1/generation/mixed", GenerationMixes)
+
+	// worker group的启停记录, 可按file过滤
+	router.GET("/v1/worker/lifecycle", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.GroupLifecycleEvents(c.Query("file")))
+	})
+
+	// 各worker group处理的策略及其说明、负责人、处理手册
+	router.GET("/debug/workers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.DebugWorkers())
+	})
+
+	// 各文件的风险评分, 从最差的开始
+	router.GET("/v1/report/files", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.FileRiskReport())
+	})
+
+	// 已登记的扩展(sink等), 按调用顺序
+	router.GET("/v1/extensions", func(c *gin.Context) {
+		c.JSON(http.StatusOK, worker.ListExtensions())
 	})
 
 	router.GET("/cached", func(c *gin.Context) {
 		c.String(http.StatusOK, worker.GetCachedAll())
 	})
 
+	// Accept为prometheus文本格式时, 按exposition format输出, 便于prometheus直接抓取
+	router.GET("/status", func(c *gin.Context) {
+		if acceptPrometheus(c.GetHeader("Accept")) {
+			c.Data(http.StatusOK, PrometheusContentType, []byte(RenderStatusPrometheus(service.GetStatus())))
+			return
+		}
+		c.JSON(http.StatusOK, service.GetStatus())
+	})
+
+	router.GET("/metrics", func(c *gin.Context) {
+		c.Data(http.StatusOK, PrometheusContentType, []byte(RenderPrometheus()))
+	})
+
+	// OTLP/HTTP json日志接入, 供file_path为otlp://的策略使用
+	router.POST("/v1/logs", gin.WrapF(reader.ServeOTLPLogs))
+
+	// 文件格式变化检测结果, 如 /v1/files/var/log/nginx/access.log/format
+	router.GET("/v1/files/*path", func(c *gin.Context) {
+		path := c.Param("path")
+		if !strings.HasSuffix(path, "/format") {
+			c.JSON(http.StatusNotFound, "not found")
+			return
+		}
+		file := strings.TrimSuffix(path, "/format")
+		stat, ok := reader.GetFormatStat(file)
+		if !ok {
+			c.JSON(http.StatusNotFound, fmt.Sprintf("file %s not sampled", file))
+			return
+		}
+		c.JSON(http.StatusOK, stat)
+	})
+
+	// 持久化的worker错误, 如 /api/errors?since=2018-01-01T00:00:00Z&strategy_id=1
+	router.GET("/api/errors", func(c *gin.Context) {
+		var since time.Time
+		if v := c.Query("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, fmt.Sprintf("bad since %s, RFC3339 expected", v))
+				return
+			}
+			since = t
+		}
+		var sid int64
+		if v := c.Query("strategy_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy_id %s", v))
+				return
+			}
+			sid = id
+		}
+		var limit int
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, fmt.Sprintf("bad limit %s", v))
+				return
+			}
+			limit = n
+		}
+		entries, err := errstore.Query(since, sid, limit)
+		if err != nil {
+			c.JSON(http.StatusNotFound, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	})
+
 	router.POST("/check", func(c *gin.Context) {
 		log := c.PostForm("log")
-		c.JSON(http.StatusOK, CheckLogByStrategy(log))
+		c.JSON(http.StatusOK, service.CheckLogByStrategy(log))
 	})
 
+	if g.Conf().Profiling.Continuous {
+		registerPprof(router)
+	}
+
 	ip, err := utils.LocalIP()
 	if err != nil {
 		ip = "127.0.0.1"
 	}
 	router.Run(fmt.Sprintf("%s:%d", ip, g.Conf().Http.HTTPPort))
 }
+
+// authorize to check http.token, /health不校验
+func authorize(c *gin.Context) {
+	if c.Request.URL.Path == "/health" || service.Authorized(service.BearerToken(c.GetHeader("Authorization"))) {
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, "bad token")
+}
+
+// errorStatus to get the http status of an error of the service layer
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUnimplemented):
+		return http.StatusNotImplemented
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,24 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NoiseAudit to show the exact and noised values sent to external sinks, for internal audit
+func NoiseAudit(c *gin.Context) {
+	var sid int64
+	var err error
+	if v := c.Query("strategy_id"); v != "" {
+		if sid, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy_id %s", v))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, worker.NoiseAudit(sid))
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/didi/falcon-log-agent/service"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pauseRequest is the body of /v1/pause and /v1/resume
+type pauseRequest struct {
+	worker.PauseSelector
+	Duration  string `json:"duration"` //自动恢复时长, 如30m, 为空则需手动恢复
+	Principal string `json:"principal"`
+}
+
+// principal to get who operates, 未指定时用X-Principal头或来源IP
+func (r *pauseRequest) principal(c *gin.Context) string {
+	if r.Principal != "" {
+		return r.Principal
+	}
+	if p := c.GetHeader("X-Principal"); p != "" {
+		return p
+	}
+	return c.ClientIP()
+}
+
+// PauseStrategies to pause strategies during maintenance
+func PauseStrategies(c *gin.Context) {
+	var req pauseRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := service.PauseStrategies(req.PauseSelector, req.principal(c), req.Duration)
+	if err != nil {
+		c.JSON(errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// ResumeStrategies to resume strategies paused with the same selector
+func ResumeStrategies(c *gin.Context) {
+	var req pauseRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	removed, err := service.ResumeStrategies(req.PauseSelector, req.principal(c))
+	if err != nil {
+		c.JSON(errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, removed)
+}
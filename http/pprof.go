@@ -0,0 +1,22 @@
+package http
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof to serve runtime profiles under /debug/pprof, for go tool pprof or Pyroscope to scrape
+// worker的CPU样本带有strategy_id、file_path两个label
+func registerPprof(router *gin.Engine) {
+	r := router.Group("/debug/pprof")
+	r.GET("/", gin.WrapF(pprof.Index))
+	r.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	r.GET("/profile", gin.WrapF(pprof.Profile))
+	r.GET("/symbol", gin.WrapF(pprof.Symbol))
+	r.POST("/symbol", gin.WrapF(pprof.Symbol))
+	r.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		r.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PushPreview to show the points that would be pushed if the in-progress periods ended now
+// 可按strategy_id、file、metric过滤, offset/limit分页; 只读取counter的副本, 不影响正在累加的值
+func PushPreview(c *gin.Context) {
+	var f worker.PreviewFilter
+	var err error
+	if v := c.Query("strategy_id"); v != "" {
+		if f.StrategyID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy_id %s", v))
+			return
+		}
+	}
+	f.FilePath = c.Query("file")
+	f.Metric = c.Query("metric")
+	if v := c.Query("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad offset %s", v))
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad limit %s, at most %d", v, worker.MaxPreviewLimit))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, worker.PreviewPush(f))
+}
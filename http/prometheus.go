@@ -0,0 +1,287 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/service"
+)
+
+// PrometheusContentType is the prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type promSample struct {
+	suffix string //histogram的_bucket/_sum/_count
+	labels string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []promSample
+}
+
+func (f *promFamily) add(value float64, labels ...string) {
+	f.addSuffix("", value, labels...)
+}
+
+func (f *promFamily) addSuffix(suffix string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], promEscape(labels[i+1])))
+	}
+	f.samples = append(f.samples, promSample{suffix: suffix, labels: strings.Join(pairs, ","), value: value})
+}
+
+func (f *promFamily) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range f.samples {
+		if s.labels == "" {
+			fmt.Fprintf(buf, "%s%s %v\n", f.name, s.suffix, s.value)
+		} else {
+			fmt.Fprintf(buf, "%s%s{%s} %v\n", f.name, s.suffix, s.labels, s.value)
+		}
+	}
+}
+
+func promEscape(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+// RenderPrometheus to render self monitor metrics in prometheus text format
+func RenderPrometheus() string {
+	lines := &promFamily{name: "falcon_log_agent_file_read_lines_total", help: "Lines read from the file.", typ: "counter"}
+	bs := &promFamily{name: "falcon_log_agent_file_read_bytes_total", help: "Bytes read from the file.", typ: "counter"}
+	lineRate := &promFamily{name: "falcon_log_agent_file_lines_rate", help: "EWMA of lines read per second.", typ: "gauge"}
+	byteRate := &promFamily{name: "falcon_log_agent_file_bytes_rate", help: "EWMA of bytes read per second.", typ: "gauge"}
+
+	stats := metric.ThroughputStats()
+	files := make([]string, 0, len(stats))
+	for file := range stats {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		stat := stats[file]
+		lines.add(float64(stat.Lines), "file", file)
+		bs.add(float64(stat.Bytes), "file", file)
+		lineRate.add(stat.LinesRate1m, "file", file, "window", "1m")
+		lineRate.add(stat.LinesRate15m, "file", file, "window", "15m")
+		byteRate.add(stat.BytesRate1m, "file", file, "window", "1m")
+		byteRate.add(stat.BytesRate15m, "file", file, "window", "15m")
+	}
+
+	// 行长直方图按prometheus histogram的约定输出, bucket为累计值
+	lengths := &promFamily{name: "falcon_log_agent_file_line_bytes", help: "Histogram of line lengths read from the file.", typ: "histogram"}
+	lengthMax := &promFamily{name: "falcon_log_agent_file_line_bytes_max", help: "Max line length observed.", typ: "gauge"}
+	lengthRate := &promFamily{name: "falcon_log_agent_file_line_bytes_rate", help: "EWMA of lines per second in the line length bucket.", typ: "gauge"}
+	lengthStats := metric.LineLengthStats()
+	files = files[:0]
+	for file := range lengthStats {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		stat := lengthStats[file]
+		var cum int64
+		for i, c := range stat.Counts {
+			cum += c
+			le := metric.LineLengthLabels[i]
+			lengths.addSuffix("_bucket", float64(cum), "file", file, "le", le)
+			lengthRate.add(stat.Rates[i], "file", file, "le", le, "window", "1m")
+		}
+		lengths.addSuffix("_sum", float64(stat.Sum), "file", file)
+		lengths.addSuffix("_count", float64(stat.Lines), "file", file)
+		lengthMax.add(float64(stat.Max), "file", file)
+	}
+
+	denied := &promFamily{name: "falcon_log_agent_file_permission_denied", help: "Whether the file cannot be opened for permission.", typ: "gauge"}
+	for _, file := range metric.PermissionDeniedFiles() {
+		denied.add(1, "file", file)
+	}
+
+	size := &promFamily{name: "falcon_log_agent_file_size_warning_mb", help: "Size in MB of the file larger than max_file_size_warning_mb.", typ: "gauge"}
+	for file, mb := range metric.FileSizeWarnings() {
+		size.add(mb, "file", file)
+	}
+
+	var buf bytes.Buffer
+	for _, f := range []*promFamily{lines, bs, lineRate, byteRate, lengths, lengthMax, lengthRate, denied, size} {
+		f.write(&buf)
+	}
+	return buf.String()
+}
+
+// acceptPrometheus to check whether the client asks for prometheus text format
+// prometheus抓取时带 Accept: text/plain; version=0.0.4, 浏览器等其他情况仍返回json
+func acceptPrometheus(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "text/plain" {
+			continue
+		}
+		for _, p := range params[1:] {
+			if strings.Replace(strings.TrimSpace(p), " ", "", -1) == "version=0.0.4" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RenderStatusPrometheus to render the worker status in prometheus text format
+// 吞吐已在/metrics中, 这里不重复输出
+func RenderStatusPrometheus(st *service.Status) string {
+	suspended := &promFamily{name: "falcon_log_agent_strategy_suspended", help: "Whether the strategy is suspended for processing lag.", typ: "gauge"}
+	suspends := &promFamily{name: "falcon_log_agent_strategy_suspend_total", help: "Times the strategy was suspended for processing lag.", typ: "counter"}
+	resumes := &promFamily{name: "falcon_log_agent_strategy_resume_total", help: "Times the suspended strategy was resumed.", typ: "counter"}
+	replayed := &promFamily{name: "falcon_log_agent_file_replayed_lines_total", help: "Replayed lines skipped by the replay guard.", typ: "counter"}
+	access := &promFamily{name: "falcon_log_agent_file_access_retries", help: "Retries of the file that cannot be opened.", typ: "gauge"}
+	paused := &promFamily{name: "falcon_log_agent_worker_group_paused_seconds", help: "Seconds since the worker group was paused.", typ: "gauge"}
+	parked := &promFamily{name: "falcon_log_agent_worker_group_parked_workers", help: "Workers parked in the paused worker group.", typ: "gauge"}
+	tagValues := &promFamily{name: "falcon_log_agent_file_tag_distinct_values", help: "Distinct values of the tag across strategies of the file in the window.", typ: "gauge"}
+	tagHigh := &promFamily{name: "falcon_log_agent_file_tag_high_cardinality_total", help: "Tag values replaced by __high_cardinality__.", typ: "counter"}
+
+	files := make([]string, 0, len(st.Files))
+	for file := range st.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fs := st.Files[file]
+		ids := make([]int64, 0, len(fs.Shed))
+		for id := range fs.Shed {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			shed := fs.Shed[id]
+			sid := fmt.Sprint(id)
+			v := 0.0
+			if shed.Suspended {
+				v = 1
+			}
+			suspended.add(v, "file", file, "strategy_id", sid)
+			suspends.add(float64(shed.SuspendCnt), "file", file, "strategy_id", sid)
+			resumes.add(float64(shed.ResumeCnt), "file", file, "strategy_id", sid)
+		}
+		if fs.Replayed > 0 {
+			replayed.add(float64(fs.Replayed), "file", file)
+		}
+		if fs.Access != nil {
+			access.add(float64(fs.Access.Retries), "file", file, "class", fs.Access.Class)
+		}
+		for _, p := range fs.Paused {
+			shard := fmt.Sprint(p.Shard)
+			paused.add(float64(p.Duration), "file", file, "shard", shard, "principal", p.Principal)
+			parked.add(float64(p.Parked), "file", file, "shard", shard)
+		}
+		tagks := make([]string, 0, len(fs.TagCardinality))
+		for tagk := range fs.TagCardinality {
+			tagks = append(tagks, tagk)
+		}
+		sort.Strings(tagks)
+		for _, tagk := range tagks {
+			tc := fs.TagCardinality[tagk]
+			tagValues.add(float64(tc.Distinct), "file", file, "tag", tagk)
+			tagHigh.add(float64(tc.Replaced), "file", file, "tag", tagk)
+		}
+	}
+
+	shardStras := &promFamily{name: "falcon_log_agent_counter_shard_strategies", help: "Strategies in the counter shard.", typ: "gauge"}
+	shardTms := &promFamily{name: "falcon_log_agent_counter_shard_pending_periods", help: "Periods waiting to be pushed in the counter shard.", typ: "gauge"}
+	shardWaits := &promFamily{name: "falcon_log_agent_counter_shard_lock_waits_total", help: "Contended lock acquisitions of the counter shard.", typ: "counter"}
+	shardWaitSecs := &promFamily{name: "falcon_log_agent_counter_shard_lock_wait_seconds_total", help: "Time spent waiting for the counter shard lock.", typ: "counter"}
+	for _, s := range st.CounterShards {
+		shard := fmt.Sprint(s.Shard)
+		shardStras.add(float64(s.Strategies), "shard", shard)
+		shardTms.add(float64(s.Tms), "shard", shard)
+		shardWaits.add(float64(s.LockWaits), "shard", shard)
+		shardWaitSecs.add(s.LockWaitMs/1000, "shard", shard)
+	}
+
+	watch := &promFamily{name: "falcon_log_agent_watch_resources", help: "Resources held by the shared inotify watcher.", typ: "gauge"}
+	watch.add(float64(st.Watch.Dirs), "resource", "dirs", "mode", st.Watch.Mode)
+	watch.add(float64(st.Watch.Files), "resource", "files", "mode", st.Watch.Mode)
+	watch.add(float64(st.Watch.FDs), "resource", "fds", "mode", st.Watch.Mode)
+	watch.add(float64(st.Watch.Goroutines), "resource", "goroutines", "mode", st.Watch.Mode)
+
+	valueRange := &promFamily{name: "falcon_log_agent_value_range_total", help: "Values out of value_range by action.", typ: "counter"}
+	ids := make([]int64, 0, len(st.ValueRange))
+	for id := range st.ValueRange {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		vr := st.ValueRange[id]
+		sid := fmt.Sprint(id)
+		valueRange.add(float64(vr.Dropped), "strategy_id", sid, "action", "drop")
+		valueRange.add(float64(vr.Clamped), "strategy_id", sid, "action", "clamp")
+		valueRange.add(float64(vr.Kept), "strategy_id", sid, "action", "keep")
+	}
+
+	oversize := &promFamily{name: "falcon_log_agent_tag_oversize_total", help: "Oversized tag values by action.", typ: "counter"}
+	ids = ids[:0]
+	for id := range st.TagOversize {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		ts := st.TagOversize[id]
+		sid := fmt.Sprint(id)
+		oversize.add(float64(ts.Truncated), "strategy_id", sid, "action", "truncate")
+		oversize.add(float64(ts.Dropped), "strategy_id", sid, "action", "drop")
+	}
+
+	coldStart := &promFamily{name: "falcon_log_agent_cold_start_periods_total", help: "First partial periods after consumption began by action.", typ: "counter"}
+	ids = ids[:0]
+	for id := range st.ColdStart {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		cs := st.ColdStart[id]
+		sid := fmt.Sprint(id)
+		coldStart.add(float64(cs.Suppressed), "strategy_id", sid, "action", "suppress")
+		coldStart.add(float64(cs.Tagged), "strategy_id", sid, "action", "partial_tag")
+	}
+
+	funnel := &promFamily{name: "falcon_log_agent_funnel_lines_total", help: "Lines of strategies by filter stage.", typ: "counter"}
+	ids = ids[:0]
+	for id := range st.Funnel {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		fs := st.Funnel[id]
+		sid := fmt.Sprint(id)
+		funnel.add(float64(fs.Matched), "strategy_id", sid, "stage", "matched")
+		funnel.add(float64(fs.MustNotContain), "strategy_id", sid, "stage", "must_not_contain")
+		funnel.add(float64(fs.Excluded), "strategy_id", sid, "stage", "excluded")
+	}
+
+	endpoints := &promFamily{name: "falcon_log_agent_push_endpoint_compressed", help: "Whether pushes to the endpoint are compressed.", typ: "gauge"}
+	for _, ep := range st.PushEndpoints {
+		v := 0.0
+		if ep.Encoding != "" {
+			v = 1
+		}
+		endpoints.add(v, "endpoint", ep.Endpoint, "encoding", ep.Encoding)
+	}
+
+	var buf bytes.Buffer
+	for _, f := range []*promFamily{suspended, suspends, resumes, replayed, access, paused, parked, tagValues, tagHigh,
+		shardStras, shardTms, shardWaits, shardWaitSecs, watch, valueRange, oversize, coldStart, funnel, endpoints} {
+		f.write(&buf)
+	}
+	return buf.String()
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/service"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// StreamStrategy to stream live events of a strategy
+// 默认使用Server-Sent Events, 开启stream_websocket后也接受WebSocket连接; verbose=1时包含miss/exclude
+func StreamStrategy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy id %s", c.Param("id")))
+		return
+	}
+	verbose := c.Query("verbose") == "1"
+	client, err := service.SubscribeEvents(id, verbose)
+	if err != nil {
+		c.JSON(errorStatus(err), err.Error())
+		return
+	}
+	defer client.Close()
+
+	if g.Conf() != nil && g.Conf().Http.StreamWebsocket &&
+		strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		websocket.Handler(func(ws *websocket.Conn) {
+			gone := make(chan struct{})
+			go func() {
+				// 只用于感知断开, 客户端发来的内容忽略
+				var msg string
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				close(gone)
+			}()
+			service.StreamEvents(client, gone, func(ev *worker.TapEvent) error {
+				return websocket.JSON.Send(ws, ev)
+			})
+		}).ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	dlog.Infof("strategy stream connected [sid:%d][verbose:%v][remote:%s]", id, verbose, c.Request.RemoteAddr)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		service.StreamEvents(client, c.Request.Context().Done(), func(ev *worker.TapEvent) error {
+			c.SSEvent(ev.Type, ev)
+			c.Writer.Flush()
+			return nil
+		})
+		return false
+	})
+	dlog.Infof("strategy stream disconnected [sid:%d][remote:%s]", id, c.Request.RemoteAddr)
+}
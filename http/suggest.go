@@ -0,0 +1,39 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SuggestExcludes to suggest excludes from the most frequent templates of matched lines
+// min_share为模板占比的下限, 默认0.3; 只给出建议, 不修改策略
+func SuggestExcludes(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("bad strategy id %s", c.Param("id")))
+		return
+	}
+	if _, err := strategy.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	}
+	minShare := worker.DefaultSuggestMinShare
+	if v := c.Query("min_share"); v != "" {
+		if minShare, err = strconv.ParseFloat(v, 64); err != nil || minShare <= 0 || minShare > 1 {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("bad min_share %s, (0, 1] expected", v))
+			return
+		}
+	}
+	ret, err := worker.SuggestExcludes(id, minShare)
+	if err != nil {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
@@ -3,29 +3,178 @@ package main
 import (
 	"github.com/didi/falcon-log-agent/http"
 
+	"github.com/didi/falcon-log-agent/common/errstore"
 	"github.com/didi/falcon-log-agent/common/proc/metric"
 	"github.com/didi/falcon-log-agent/common/proc/patrol"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
 	"github.com/didi/falcon-log-agent/common/utils"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
 	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/grpcapi"
 	"github.com/didi/falcon-log-agent/worker"
 
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 )
 
+// GitCommit is set by Makefile
+var GitCommit string
+
 func main() {
+	// 子命令不需要agent的配置及日志
+	if len(os.Args) > 1 && os.Args[1] == "diff-strategies" {
+		os.Exit(diffStrategies(os.Args[2:]))
+	}
+
+	g.AgentVersion = GitCommit
 	g.InitAll()
 	defer g.CloseLog()
 
+	if g.Check {
+		code := 0
+		report, err := strategy.CheckReport()
+		if err != nil {
+			dlog.Errorf("check strategies failed [err:%v]", err)
+			code = 1
+		} else {
+			for _, r := range report {
+				if !r.ParseSucc || r.Status != "" {
+					code = 1
+				}
+			}
+			bs, _ := json.MarshalIndent(report, "", "    ")
+			fmt.Println(string(bs))
+		}
+		g.CloseLog()
+		os.Exit(code)
+	}
+
+	cp := g.Conf().Checkpoint.Path
+	if g.MigrateCheckpoints {
+		code := 0
+		if err := reader.MigrateCheckpoints(cp); err != nil {
+			dlog.Errorf("migrate checkpoints failed [path:%s][err:%v]", cp, err)
+			code = 1
+		}
+		g.CloseLog()
+		os.Exit(code)
+	}
+	if cp != "" {
+		if err := reader.LoadCheckpoints(cp); err != nil {
+			dlog.Errorf("load checkpoints failed, refuse to start rather than overwrite it [path:%s][err:%v]", cp, err)
+			g.CloseLog()
+			os.Exit(1)
+		}
+		go reader.CheckpointLoop(cp, g.Conf().Checkpoint.Interval)
+	}
+	worker.LoadPauses()
+	go worker.PauseLoop()
+
+	if err := errstore.Init(g.Conf().ErrorStore.Path, g.Conf().ErrorStore.MaxRows, g.Conf().ErrorStore.QueueSize); err != nil {
+		dlog.Errorf("open error store failed, errors will not be persisted [path:%s][err:%v]", g.Conf().ErrorStore.Path, err)
+	}
+
 	maxCoreNum := utils.GetCPULimitNum(g.Conf().MaxCPURate)
 	dlog.Infof("bind [%d] cpu core", maxCoreNum)
 	runtime.GOMAXPROCS(maxCoreNum)
 
 	go metric.MetricLoop(60)
+	ticker.Start(g.Conf().Endpoint, time.Duration(g.Conf().SelfMetric.Interval)*time.Second)
+	ticker.Register("throughput", func(w ticker.Window) {
+		metric.TickThroughputs(w.Duration())
+		metric.TickLineLengths(w.Duration())
+	})
+	ticker.Register("line_truncation", worker.WarnLineTruncation)
+	ticker.Register("file_risk", worker.ReportFileRisks)
+	ticker.Register("clock_skew", worker.ReportClockSkews)
+	ticker.Register("file_size", worker.ReportFileSizeWarnings)
+	go reloadLoop()
+	go shutdownLoop()
+	strategy.StartEtcdLoader()
 	go worker.UpdateConfigsLoop()
 	go patrol.PatrolLoop()
 	go worker.PusherStart()
+	go worker.ShedLoop()
+	if g.Conf().Sink.Listen != "" {
+		go worker.StartPointStreamServer(g.Conf().Sink.Listen)
+	}
+	if g.Conf().Grpc.Listen != "" {
+		go grpcapi.Start(g.Conf().Grpc.Listen)
+	}
 
 	http.Start()
 }
+
+// diffStrategies to print what metrics would change from the old strategy config to the new one
+// usage: falcon-log-agent diff-strategies [-summary] old.json new.json
+func diffStrategies(args []string) int {
+	fs := flag.NewFlagSet("diff-strategies", flag.ContinueOnError)
+	summary := fs.Bool("summary", false, "print a human-readable summary instead of json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: falcon-log-agent diff-strategies [-summary] old.json new.json")
+		return 2
+	}
+	report, err := strategy.DiffStrategyFiles(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff strategies failed: %v\n", err)
+		return 1
+	}
+	if *summary {
+		report.WriteSummary(os.Stdout)
+		return 0
+	}
+	bs, _ := json.MarshalIndent(report, "", "    ")
+	fmt.Println(string(bs))
+	return 0
+}
+
+// shutdownLoop to release the file locks on SIGTERM/SIGINT
+// kill -9时锁文件残留, 持有者进程不存在的锁会被其他agent接管
+func shutdownLoop() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-c
+	dlog.Infof("received %s, release file locks and exit", sig)
+	if r := worker.GetRegistry(); r != nil {
+		r.ReleaseAll()
+	}
+	worker.CloseAuditLog()
+	g.CloseLog()
+	os.Exit(0)
+}
+
+// reloadLoop to apply reloadable config on SIGHUP
+// 目前只有self_metric.interval及sink.otlp/influxdb/statsd支持热加载, 其余配置需要重启
+func reloadLoop() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		conf, err := g.ReadConfig()
+		if err != nil {
+			dlog.Errorf("reload config failed [file:%s][err:%v]", g.ConfigFile, err)
+			continue
+		}
+		interval := ticker.ClampInterval(time.Duration(conf.SelfMetric.Interval) * time.Second)
+		dlog.Infof("reload config [file:%s][self_metric.interval:%s]", g.ConfigFile, interval)
+		ticker.Default.SetInterval(interval)
+		diff, err := worker.ReloadExtensions(conf)
+		if err != nil {
+			dlog.Errorf("reload sinks failed [err:%v]", err)
+			continue
+		}
+		dlog.Infof("reload sinks [added:%v][removed:%v][replaced:%v]", diff.Added, diff.Removed, diff.Replaced)
+	}
+}
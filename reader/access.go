@@ -0,0 +1,115 @@
+package reader
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// 打开文件失败的分类
+const (
+	AccessOK               = "ok"
+	AccessNotExist         = "not_exist"
+	AccessPermissionDenied = "permission_denied"
+	AccessError            = "error"
+)
+
+const (
+	openRetryMin        = time.Second
+	defaultOpenRetryMax = 30 * time.Second
+)
+
+// ClassifyOpenError to classify the error of opening a file
+func ClassifyOpenError(err error) string {
+	switch {
+	case err == nil:
+		return AccessOK
+	case os.IsNotExist(err):
+		return AccessNotExist
+	case os.IsPermission(err):
+		return AccessPermissionDenied
+	default:
+		return AccessError
+	}
+}
+
+// CheckOpen to check whether the file can be opened for read
+// tail在goroutine中异步打开文件, 打开失败不会返回给调用方, 需要先检查一次
+func CheckOpen(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openRetryBackoff to get the wait before the n-th retry of opening
+func openRetryBackoff(n int) time.Duration {
+	max := defaultOpenRetryMax
+	if g.Conf() != nil && g.Conf().Reader.OpenRetryMax > 0 {
+		max = time.Duration(g.Conf().Reader.OpenRetryMax) * time.Second
+	}
+	d := openRetryMin
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// FileAccess is the open failure of a configured file
+type FileAccess struct {
+	Class     string `json:"class"`
+	Path      string `json:"path"` //实际打开的路径
+	Error     string `json:"error"`
+	Since     int64  `json:"since"`
+	Retries   int    `json:"retries"`
+	NextRetry int64  `json:"next_retry"`
+}
+
+var (
+	fileAccess     = make(map[string]*FileAccess)
+	fileAccessLock = new(sync.RWMutex)
+)
+
+// setFileAccess to record the open failure of a file, nil means the file is readable
+func setFileAccess(filePath string, fa *FileAccess) {
+	fileAccessLock.Lock()
+	defer fileAccessLock.Unlock()
+	if fa == nil {
+		delete(fileAccess, filePath)
+	} else {
+		if old, ok := fileAccess[filePath]; ok && old.Class == fa.Class {
+			fa.Since = old.Since
+		}
+		fileAccess[filePath] = fa
+	}
+	metric.SetPermissionDenied(filePath, fa != nil && fa.Class == AccessPermissionDenied)
+}
+
+// GetFileAccess to get the open failure of a file
+func GetFileAccess(filePath string) (FileAccess, bool) {
+	fileAccessLock.RLock()
+	defer fileAccessLock.RUnlock()
+	fa, ok := fileAccess[filePath]
+	if !ok {
+		return FileAccess{}, false
+	}
+	return *fa, true
+}
+
+// FileAccessStats to get open failures of all files
+func FileAccessStats() map[string]FileAccess {
+	fileAccessLock.RLock()
+	defer fileAccessLock.RUnlock()
+	ret := make(map[string]FileAccess, len(fileAccess))
+	for k, v := range fileAccess {
+		ret[k] = *v
+	}
+	return ret
+}
@@ -0,0 +1,96 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+func TestClassifyOpenError(t *testing.T) {
+	cases := map[error]string{
+		nil: AccessOK,
+		&os.PathError{Op: "open", Path: "/a", Err: syscall.ENOENT}: AccessNotExist,
+		&os.PathError{Op: "open", Path: "/a", Err: syscall.EACCES}: AccessPermissionDenied,
+		&os.PathError{Op: "open", Path: "/a", Err: syscall.EMFILE}: AccessError,
+	}
+	for err, want := range cases {
+		if got := ClassifyOpenError(err); got != want {
+			t.Errorf("classify %v: got %s, want %s", err, got, want)
+		}
+	}
+
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 5: 16 * time.Second, 10: defaultOpenRetryMax} {
+		if got := openRetryBackoff(n); got != want {
+			t.Errorf("backoff %d: got %v, want %v", n, got, want)
+		}
+	}
+}
+
+func deniedMetric(file string) bool {
+	for _, f := range metric.PermissionDeniedFiles() {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPermissionDeniedRecovery(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission is not checked for root")
+	}
+	dir, _ := ioutil.TempDir("", "access")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, []byte("old\n"), 0644)
+	os.Chmod(path, 0000)
+
+	stream := make(chan Line, 10)
+	r, err := NewReader(path, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	fa, ok := GetFileAccess(path)
+	if !ok || fa.Class != AccessPermissionDenied || r.t != nil {
+		t.Fatalf("should wait for permission, got %+v", fa)
+	}
+	if !deniedMetric(path) {
+		t.Fatal("permission_denied metric should be set")
+	}
+
+	// 权限仍未恢复, 重试失败并退避
+	r.nextRetry = time.Now()
+	r.check()
+	if fa, _ := GetFileAccess(path); fa.Retries != 2 || r.t != nil {
+		t.Fatalf("retry should fail, got %+v", fa)
+	}
+
+	// 权限恢复后在下一次重试时打开, 按默认的起始位置(末尾)读
+	os.Chmod(path, 0644)
+	r.nextRetry = time.Now()
+	r.check()
+	if r.t == nil {
+		t.Fatal("should open after permission fixed")
+	}
+	if _, ok := GetFileAccess(path); ok || deniedMetric(path) {
+		t.Fatal("access failure should be cleared")
+	}
+	time.Sleep(200 * time.Millisecond)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new\n")
+	f.Close()
+	select {
+	case l := <-stream:
+		if l.Text != "new" {
+			t.Fatalf("should start from end, got %q", l.Text)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no line read after recovery")
+	}
+}
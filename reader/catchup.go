@@ -0,0 +1,364 @@
+package reader
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+)
+
+// 追赶的阶段
+const (
+	CatchUpRotated = "rotated" //正在按轮转顺序读取轮转出去的文件
+	CatchUpLive    = "live"    //轮转出去的文件已读完, 从头读当前文件
+	CatchUpCapped  = "capped"  //超过catchup_max_mb, 剩余部分跳过, 从当前文件末尾开始读
+)
+
+const (
+	defaultCatchUpMaxMB = 1024
+	// checkpointHeadBytes 记录文件开头的字节数, 用于找到被压缩(inode已变)的轮转文件
+	checkpointHeadBytes = 1024
+)
+
+// catchUpMaxBytes to get the cap of bytes read from rotated files, negative means catch-up is disabled, replaced in test
+var catchUpMaxBytes = func() int64 {
+	mb := int64(defaultCatchUpMaxMB)
+	if g.Conf() != nil && g.Conf().Reader.CatchUpMaxMB != 0 {
+		mb = int64(g.Conf().Reader.CatchUpMaxMB)
+	}
+	return mb * 1024 * 1024
+}
+
+// catchUpFile is a rotated file read during catch-up
+type catchUpFile struct {
+	path    string
+	gz      bool
+	offset  int64 //开始读取的位置(解压后), 只有checkpoint所在的文件不为0
+	modTime time.Time
+}
+
+// CatchUpStat is the progress of reading the rotated files of a file after downtime
+type CatchUpStat struct {
+	Phase     string   `json:"phase"`
+	Files     []string `json:"files"`   //依次读取的轮转文件, 最早的在前
+	Current   string   `json:"current"` //正在读的文件
+	Lines     int64    `json:"lines"`
+	BytesRead int64    `json:"bytes_read"`
+	MaxBytes  int64    `json:"max_bytes"`
+	Since     int64    `json:"since"`
+	Finished  int64    `json:"finished,omitempty"`
+}
+
+var (
+	catchUps     = make(map[string]*CatchUpStat)
+	catchUpsLock = new(sync.RWMutex)
+)
+
+func setCatchUp(filePath string, stat *CatchUpStat) {
+	catchUpsLock.Lock()
+	defer catchUpsLock.Unlock()
+	if stat == nil {
+		delete(catchUps, filePath)
+		return
+	}
+	catchUps[filePath] = stat
+}
+
+// CatchUpStats to get the catch-up progress of all files, kept after it finishes until the reader stops
+func CatchUpStats() map[string]CatchUpStat {
+	catchUpsLock.RLock()
+	defer catchUpsLock.RUnlock()
+	ret := make(map[string]CatchUpStat, len(catchUps))
+	for k, v := range catchUps {
+		stat := *v
+		stat.Files = append([]string{}, v.Files...)
+		ret[k] = stat
+	}
+	return ret
+}
+
+// fileHead to get the identity of the first bytes of a file as "<length>:<hash>"
+func fileHead(path string, gz bool, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var rd io.Reader = f
+	if gz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		rd = zr
+	}
+	bs, err := ioutil.ReadAll(io.LimitReader(rd, int64(n)))
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(bs)
+	return fmt.Sprintf("%d:%x", len(bs), h.Sum64()), nil
+}
+
+// headMatches to check whether the file starts with the bytes recorded in head, empty head matches any file
+func headMatches(path string, gz bool, head string) bool {
+	if head == "" {
+		return true
+	}
+	var n int
+	if _, err := fmt.Sscanf(head, "%d:", &n); err != nil {
+		return false
+	}
+	got, err := fileHead(path, gz, n)
+	return err == nil && got == head
+}
+
+// fileIdentity to get the inode and head of a file, recorded in checkpoint
+func fileIdentity(path string, gz bool) (uint64, string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, ""
+	}
+	head, _ := fileHead(path, gz, checkpointHeadBytes)
+	return fileInode(fi), head
+}
+
+// planCatchUp to find the rotated files holding the lines after the checkpoint, oldest first
+// checkpoint记录的inode与当前文件不同时, 在同一目录下以文件名为前缀的文件(如app.log.1、app.log-20180101、app.log.2.gz)中,
+// 找inode相同的文件, 压缩过的按文件开头的内容找; 之后修改时间更晚的轮转文件按修改时间依次读取
+func planCatchUp(cp *Checkpoint, livePath string) []catchUpFile {
+	if cp.Inode == 0 || catchUpMaxBytes() < 0 {
+		return nil
+	}
+	if fi, err := os.Stat(livePath); err == nil && fileInode(fi) == cp.Inode && headMatches(livePath, false, cp.Head) {
+		return nil
+	}
+	dir, base := filepath.Dir(livePath), filepath.Base(livePath)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var found *catchUpFile
+	rotated := make([]catchUpFile, 0)
+	for _, fi := range entries {
+		name := fi.Name()
+		if fi.IsDir() || name == base || !strings.HasPrefix(name, base) {
+			continue
+		}
+		f := catchUpFile{path: filepath.Join(dir, name), gz: strings.HasSuffix(name, ".gz"), modTime: fi.ModTime()}
+		rotated = append(rotated, f)
+		if found != nil {
+			continue
+		}
+		if ((f.gz && cp.Head != "") || (!f.gz && fileInode(fi) == cp.Inode)) && headMatches(f.path, f.gz, cp.Head) {
+			f.offset = cp.Offset
+			found = &f
+		}
+	}
+	if found == nil {
+		dlog.Warningf("rotated file of checkpoint not found, lines after checkpoint are lost [path:%s][inode:%d][offset:%d]",
+			livePath, cp.Inode, cp.Offset)
+		return nil
+	}
+
+	plan := []catchUpFile{*found}
+	newer := make([]catchUpFile, 0)
+	for _, f := range rotated {
+		if f.path != found.path && f.modTime.After(found.modTime) {
+			newer = append(newer, f)
+		}
+	}
+	sort.Slice(newer, func(i, j int) bool {
+		if !newer[i].modTime.Equal(newer[j].modTime) {
+			return newer[i].modTime.Before(newer[j].modTime)
+		}
+		return newer[i].path < newer[j].path
+	})
+	return append(plan, newer...)
+}
+
+// catchUpProgress is the position of catch-up, saved as checkpoint periodically
+type catchUpProgress struct {
+	sync.Mutex
+	file  catchUpFile
+	gen   int64
+	taken *handoff //worker已取走的位置
+}
+
+// runCatchUp to read the rotated files in order into the stream before the live file
+// 与读当前文件使用同一个stream, 队列满时阻塞等待, 不丢行; 返回是否超过上限
+func (r *Reader) runCatchUp() bool {
+	max := catchUpMaxBytes()
+	stat := &CatchUpStat{Phase: CatchUpRotated, MaxBytes: max, Since: time.Now().Unix()}
+	for _, f := range r.catchUp {
+		stat.Files = append(stat.Files, f.path)
+	}
+	setCatchUp(r.FilePath, stat)
+	dlog.Infof("catch up rotated files [file:%s][files:%v][offset:%d]", r.FilePath, stat.Files, r.catchUp[0].offset)
+
+	since := time.Now().Unix()
+	if r.resumedAt > 0 && r.resumedAt < since {
+		since = r.resumedAt
+	}
+	atomic.CompareAndSwapInt64(&r.since, 0, since)
+
+	var lines, bytes, linesSwp int64
+	progress := &catchUpProgress{}
+	// checkpoint记录正在读的轮转文件, 追赶中重启时从该位置继续
+	report := ticker.Register("catchup:"+r.FilePath, func(ticker.Window) {
+		n := atomic.LoadInt64(&lines)
+		metric.MetricReadLine(r.FilePath, n-linesSwp)
+		linesSwp = n
+		if atomic.LoadInt32(&r.stopped) == 1 {
+			return
+		}
+		progress.Lock()
+		f, gen, taken := progress.file, progress.gen, progress.taken
+		progress.Unlock()
+		if f.path != "" {
+			inode, head := fileIdentity(f.path, f.gz)
+			setCheckpoint(r.FilePath, &Checkpoint{Path: r.CurrentPath, Gen: gen, Offset: taken.load(), Inode: inode, Head: head})
+		}
+	})
+	defer report.Unregister()
+
+	throughput := metric.Throughput(r.FilePath)
+	lengths := metric.LineLength(r.FilePath)
+	capped := false
+	gen := r.gen - int64(len(r.catchUp))
+	for _, f := range r.catchUp {
+		catchUpsLock.Lock()
+		stat.Current = f.path
+		catchUpsLock.Unlock()
+		taken := newHandoff(f.offset)
+		progress.Lock()
+		progress.file, progress.gen, progress.taken = f, gen, taken
+		progress.Unlock()
+		err := readRotated(f, func(text string, offset int64, size int) bool {
+			if bytes+int64(size) > max {
+				capped = true
+				return false
+			}
+			select {
+			case r.Stream <- Line{Text: text, Gen: gen, Offset: offset, handoff: taken}:
+			case <-r.Close:
+				return false
+			}
+			bytes += int64(size)
+			throughput.Add(size)
+			lengths.Observe(len(text))
+			atomic.AddInt64(&lines, 1)
+			catchUpsLock.Lock()
+			stat.Lines, stat.BytesRead = atomic.LoadInt64(&lines), bytes
+			catchUpsLock.Unlock()
+			return true
+		})
+		if err != nil {
+			dlog.Errorf("read rotated file failed, skip it [file:%s][path:%s][err:%v]", r.FilePath, f.path, err)
+		}
+		if capped || atomic.LoadInt32(&r.stopped) == 1 {
+			break
+		}
+		gen++
+	}
+
+	catchUpsLock.Lock()
+	stat.Current, stat.Finished = "", time.Now().Unix()
+	stat.Phase = CatchUpLive
+	if capped {
+		stat.Phase = CatchUpCapped
+	}
+	catchUpsLock.Unlock()
+	if capped {
+		dlog.Errorf("catch-up exceeds catchup_max_mb, the rest of rotated files and the live file before now are SKIPPED, tail from end [file:%s][read:%d bytes][max:%d bytes]",
+			r.FilePath, bytes, max)
+	} else {
+		dlog.Infof("catch up rotated files done, switch to live file [file:%s][lines:%d][bytes:%d]", r.FilePath, lines, bytes)
+	}
+	return capped
+}
+
+// readRotated to call fn with each line from the offset of a rotated file, stops when fn returns false
+// 轮转出去的文件不再增长, 末尾没有换行的行也读出
+func readRotated(f catchUpFile, fn func(text string, offset int64, size int) bool) error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	var rd io.Reader = fd
+	if f.gz {
+		zr, err := gzip.NewReader(fd)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		if _, err := io.CopyN(ioutil.Discard, zr, f.offset); err != nil {
+			return err
+		}
+		rd = zr
+	} else if _, err := fd.Seek(f.offset, os.SEEK_SET); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(rd)
+	offset := f.offset
+	for {
+		text, err := br.ReadString('\n')
+		if len(text) > 0 {
+			offset += int64(len(text))
+			if !fn(strings.TrimSuffix(text, "\n"), offset, len(text)) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// finishCatchUp to open the live file after catch-up, from its start, or from its end if capped
+func (r *Reader) finishCatchUp(capped bool) {
+	r.catchUp = nil
+	if atomic.LoadInt32(&r.stopped) == 1 {
+		return
+	}
+	offset, whence := int64(0), os.SEEK_SET
+	if capped {
+		whence = os.SEEK_END
+	}
+	if err := CheckOpen(r.CurrentPath); err != nil && ClassifyOpenError(err) != AccessNotExist {
+		r.waitOpen(r.CurrentPath, offset, whence, err)
+		return
+	}
+	if err := r.openFile(offset, whence, r.CurrentPath); err != nil {
+		r.waitOpen(r.CurrentPath, offset, whence, err)
+	}
+}
+
+// rotatedSince to check whether the file is not the one of the checkpoint
+// checkpoint记录了inode而当前文件不同, 但轮转出去的文件已找不到时, 不能按偏移续读当前文件
+func rotatedSince(cp *Checkpoint, fi os.FileInfo) bool {
+	inode := fileInode(fi)
+	return cp.Inode != 0 && inode != 0 && inode != cp.Inode
+}
@@ -0,0 +1,182 @@
+package reader
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func gzipFile(t *testing.T, src, dst string) {
+	bs, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write(bs)
+	zw.Close()
+	f.Close()
+	os.Remove(src)
+}
+
+// downtimeRotations to simulate an agent down across two rotations after reading line 4
+// 停机后: app.log(line 1-12, checkpoint在line 4之后) -> app.log.1 -> app.log.2.gz; app.log.1为line 13-20; app.log为line 21-25
+func downtimeRotations(t *testing.T, dir string) string {
+	live := filepath.Join(dir, "app.log")
+	appendLines(t, live, 1, 11)
+	inode, head := fileIdentity(live, false)
+	setCheckpoint(live, &Checkpoint{Path: live, Gen: 3, Offset: int64(len("line 1\nline 2\nline 3\nline 4\n")), Inode: inode, Head: head})
+	appendLines(t, live, 11, 13)
+
+	os.Rename(live, live+".1")
+	appendLines(t, live, 13, 21)
+
+	os.Rename(live+".1", live+".2")
+	gzipFile(t, live+".2", live+".2.gz")
+	os.Rename(live, live+".1")
+	appendLines(t, live, 21, 26)
+
+	// 更早的无关轮转文件及其他文件不读
+	appendLines(t, live+".3", 100, 102)
+	appendLines(t, filepath.Join(dir, "other.log"), 200, 201)
+	base := time.Now().Add(-time.Hour)
+	os.Chtimes(live+".3", base, base)
+	os.Chtimes(live+".2.gz", base.Add(time.Minute), base.Add(time.Minute))
+	os.Chtimes(live+".1", base.Add(2*time.Minute), base.Add(2*time.Minute))
+	return live
+}
+
+// receiveUntil to receive lines until the text, fails on timeout
+func receiveUntil(t *testing.T, stream chan Line, text string) []Line {
+	var ret []Line
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case l := <-stream:
+			ret = append(ret, l)
+			if l.Text == text {
+				return ret
+			}
+		case <-timeout:
+			t.Fatalf("%q not received, got %v", text, ret)
+		}
+	}
+}
+
+func stopReader(r *Reader, stream chan Line) {
+	r.Stop()
+	for range stream {
+	}
+}
+
+func TestCatchUpRotations(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "catchup")
+	defer os.RemoveAll(dir)
+	live := downtimeRotations(t, dir)
+	defer RemoveCheckpoint(live)
+
+	// 队列只有1, 追赶时阻塞等待而不丢行; 当前文件的行队列满时默认丢弃, 用HoldStream让其等待
+	stream := make(chan Line, 1)
+	HoldStream(live)
+	defer ReleaseStream(live)
+	r, err := NewReader(live, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	defer stopReader(r, stream)
+
+	got := receiveUntil(t, stream, "line 25")
+	if len(got) != 21 {
+		t.Fatalf("%d lines received, want line 5-25 exactly once: %v", len(got), got)
+	}
+	for i, l := range got {
+		n := i + 5
+		if l.Text != fmt.Sprintf("line %d", n) {
+			t.Fatalf("line %d is %q, want in order", i, l.Text)
+		}
+		// 每个文件一个代数, checkpoint所在的文件沿用原代数
+		wantGen := int64(3)
+		if n > 20 {
+			wantGen = 5
+		} else if n > 12 {
+			wantGen = 4
+		}
+		if l.Gen != wantGen {
+			t.Errorf("%q gen %d, want %d", l.Text, l.Gen, wantGen)
+		}
+	}
+	if want := int64(len("line 1\nline 2\nline 3\nline 4\nline 5\n")); got[0].Offset != want {
+		t.Errorf("offset of line 5 is %d, want %d continued from checkpoint", got[0].Offset, want)
+	}
+
+	stat := CatchUpStats()[live]
+	if stat.Phase != CatchUpLive || stat.Lines != 16 || len(stat.Files) != 2 ||
+		stat.Files[0] != live+".2.gz" || stat.Files[1] != live+".1" {
+		t.Fatalf("catch-up stat %+v", stat)
+	}
+}
+
+func TestCatchUpCap(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "catchup")
+	defer os.RemoveAll(dir)
+	live := downtimeRotations(t, dir)
+	defer RemoveCheckpoint(live)
+	saved := catchUpMaxBytes
+	catchUpMaxBytes = func() int64 { return 30 }
+	defer func() { catchUpMaxBytes = saved }()
+
+	stream := make(chan Line, 100)
+	r, err := NewReader(live, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	defer stopReader(r, stream)
+
+	// 上限内的line 5-8(28字节)完整按序送达, 之后从当前文件末尾开始读
+	got := receiveUntil(t, stream, "line 8")
+	if len(got) != 4 || got[0].Text != "line 5" {
+		t.Fatalf("lines before cap %v", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for CatchUpStats()[live].Phase != CatchUpCapped {
+		if time.Now().After(deadline) {
+			t.Fatalf("catch-up stat %+v, want capped", CatchUpStats()[live])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	appendLines(t, live, 26, 27)
+	got = receiveUntil(t, stream, "line 26")
+	if len(got) != 1 {
+		t.Fatalf("lines skipped after cap should not be read, got %v", got)
+	}
+}
+
+// 没有inode的旧checkpoint及inode未变的文件不追赶
+func TestPlanCatchUpSameFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "catchup")
+	defer os.RemoveAll(dir)
+	live := filepath.Join(dir, "app.log")
+	appendLines(t, live, 1, 4)
+	appendLines(t, live+".1", 1, 4)
+	inode, head := fileIdentity(live, false)
+	if plan := planCatchUp(&Checkpoint{Path: live, Offset: 7}, live); plan != nil {
+		t.Fatalf("checkpoint without inode planned %v", plan)
+	}
+	if plan := planCatchUp(&Checkpoint{Path: live, Offset: 7, Inode: inode, Head: head}, live); plan != nil {
+		t.Fatalf("same file planned %v", plan)
+	}
+	// 找不到checkpoint所在的文件
+	if plan := planCatchUp(&Checkpoint{Path: live, Offset: 7, Inode: inode + 1000, Head: "7:1"}, live); plan != nil {
+		t.Fatalf("missing rotated file planned %v", plan)
+	}
+}
@@ -0,0 +1,236 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/statefile"
+)
+
+// CheckpointVersion is the schema version of checkpoint file
+// 不兼容地修改CheckpointFile结构时需升级此版本, 并在checkpointSchema.Converters中加上从上一个版本的转换
+// 新增可选字段不需要升级
+const CheckpointVersion = 2
+
+// Checkpoint to record read position of one file
+type Checkpoint struct {
+	Path   string        `json:"path"` //实际读取的文件路径, 动态路径下与配置路径不同
+	Gen    int64         `json:"gen,omitempty"`
+	Offset int64         `json:"offset"`
+	Time   int64         `json:"time,omitempty"`  //记录该位置的时间, 之前版本写入的为0
+	Marks  []*ReplayMark `json:"marks,omitempty"` //开启防重放时, 已推送周期的高水位
+	Inode  uint64        `json:"inode,omitempty"` //Offset所在文件的inode, 与当前文件不同时从轮转出去的文件追赶
+	Head   string        `json:"head,omitempty"`  //Offset所在文件开头的长度及哈希, 用于找到被压缩的轮转文件
+}
+
+// ReplayMark is the largest offset aggregated into a pushed period
+type ReplayMark struct {
+	Gen        int64 `json:"gen"`
+	StrategyID int64 `json:"sid"`
+	Tms        int64 `json:"tms"`
+	Offset     int64 `json:"offset"`
+}
+
+// CheckpointFile is the content of checkpoint file
+type CheckpointFile struct {
+	AgentVersion string                 `json:"agent_version"`
+	Files        map[string]*Checkpoint `json:"files"`            //以配置的文件路径为key
+	Pauses       json.RawMessage        `json:"pauses,omitempty"` //维护期暂停, 内容由worker维护
+}
+
+// checkpointSchema 历史版本:
+// 1: 没有statefile头部的json, version字段为"1", 更早的没有version字段, 结构相同
+// 2: statefile头部, body去掉了version字段
+var checkpointSchema = &statefile.Schema{
+	Kind:    "checkpoint",
+	Version: CheckpointVersion,
+	Converters: map[int]statefile.Converter{
+		1: func(body []byte) ([]byte, error) {
+			var m map[string]json.RawMessage
+			if err := json.Unmarshal(body, &m); err != nil {
+				return nil, err
+			}
+			delete(m, "version")
+			return json.Marshal(m)
+		},
+	},
+	Legacy: func(body []byte) (int, error) {
+		var v struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return 0, err
+		}
+		if v.Version != "" && v.Version != "1" {
+			return 0, fmt.Errorf("unknown version %q", v.Version)
+		}
+		return 1, nil
+	},
+}
+
+var (
+	checkpoints     = make(map[string]*Checkpoint)
+	replayMarks     = make(map[string][]*ReplayMark)
+	pauseState      json.RawMessage
+	checkpointsLock = new(sync.RWMutex)
+)
+
+// GetCheckpoint to get checkpoint of a file
+func GetCheckpoint(filePath string) (*Checkpoint, bool) {
+	checkpointsLock.RLock()
+	cp, ok := checkpoints[filePath]
+	checkpointsLock.RUnlock()
+	return cp, ok
+}
+
+// SetCheckpoint to record read position of a file
+func SetCheckpoint(filePath, currentPath string, gen, offset int64) {
+	setCheckpoint(filePath, &Checkpoint{Path: currentPath, Gen: gen, Offset: offset})
+}
+
+func setCheckpoint(filePath string, cp *Checkpoint) {
+	cp.Time = time.Now().Unix()
+	checkpointsLock.Lock()
+	checkpoints[filePath] = cp
+	checkpointsLock.Unlock()
+}
+
+// SetReplayMarks to record replay marks of a file, saved along with its checkpoint
+func SetReplayMarks(filePath string, marks []*ReplayMark) {
+	checkpointsLock.Lock()
+	replayMarks[filePath] = marks
+	checkpointsLock.Unlock()
+}
+
+// GetReplayMarks to get replay marks of a file
+func GetReplayMarks(filePath string) []*ReplayMark {
+	checkpointsLock.RLock()
+	defer checkpointsLock.RUnlock()
+	return replayMarks[filePath]
+}
+
+// RemoveCheckpoint to forget a file
+func RemoveCheckpoint(filePath string) {
+	checkpointsLock.Lock()
+	delete(checkpoints, filePath)
+	delete(replayMarks, filePath)
+	checkpointsLock.Unlock()
+}
+
+// SetPauseState to record pauses, saved along with checkpoints
+func SetPauseState(state json.RawMessage) {
+	checkpointsLock.Lock()
+	pauseState = state
+	checkpointsLock.Unlock()
+}
+
+// GetPauseState to get pauses loaded from checkpoint file
+func GetPauseState() json.RawMessage {
+	checkpointsLock.RLock()
+	defer checkpointsLock.RUnlock()
+	return pauseState
+}
+
+func readCheckpointFile(path string) (*CheckpointFile, int, error) {
+	cf := new(CheckpointFile)
+	version, err := statefile.Load(path, checkpointSchema, cf)
+	if err != nil {
+		return nil, version, err
+	}
+	return cf, version, nil
+}
+
+func writeCheckpointFile(path string, files map[string]*Checkpoint, pauses json.RawMessage) error {
+	return statefile.Write(path, checkpointSchema, &CheckpointFile{
+		AgentVersion: g.AgentVersion,
+		Files:        files,
+		Pauses:       pauses,
+	})
+}
+
+// LoadCheckpoints to load checkpoints from file, old versions are converted
+// 文件损坏时移到一边(.corrupt.<时间>)留待排查, 所有文件从末尾开始读;
+// 由更新版本的agent写入时返回错误, 不读取也不覆盖
+func LoadCheckpoints(path string) error {
+	cf, version, err := readCheckpointFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if _, ok := err.(*statefile.CorruptError); ok {
+		dlog.Warningf("%v, all files will be read from end", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	checkpointsLock.Lock()
+	for k, v := range cf.Files {
+		if v != nil {
+			checkpoints[k] = v
+			if len(v.Marks) > 0 {
+				replayMarks[k] = v.Marks
+			}
+		}
+	}
+	pauseState = cf.Pauses
+	checkpointsLock.Unlock()
+	dlog.Infof("load checkpoints success [path:%s][files:%d][version:%d][agent_version:%s]", path, len(cf.Files), version, cf.AgentVersion)
+	return nil
+}
+
+// saveLock 串行化SaveCheckpoints, CheckpointLoop与暂停操作同时保存时后取的快照后写入, 不会被旧快照覆盖
+var saveLock = new(sync.Mutex)
+
+// SaveCheckpoints to save checkpoints to file
+func SaveCheckpoints(path string) error {
+	saveLock.Lock()
+	defer saveLock.Unlock()
+	checkpointsLock.RLock()
+	files := make(map[string]*Checkpoint, len(checkpoints))
+	for k, v := range checkpoints {
+		cp := *v
+		if marks, ok := replayMarks[k]; ok {
+			cp.Marks = marks
+		}
+		files[k] = &cp
+	}
+	pauses := pauseState
+	checkpointsLock.RUnlock()
+	return writeCheckpointFile(path, files, pauses)
+}
+
+// MigrateCheckpoints to upgrade checkpoint file of old version in-place
+func MigrateCheckpoints(path string) error {
+	cf, version, err := readCheckpointFile(path)
+	if err != nil {
+		return err
+	}
+	if version == CheckpointVersion {
+		dlog.Infof("checkpoint file is up to date [path:%s][version:%d]", path, version)
+		return nil
+	}
+	if err := writeCheckpointFile(path, cf.Files, cf.Pauses); err != nil {
+		return err
+	}
+	dlog.Infof("migrate checkpoint file success [path:%s][from:%d][to:%d]", path, version, CheckpointVersion)
+	return nil
+}
+
+// CheckpointLoop to save checkpoints periodically
+func CheckpointLoop(path string, interval int) {
+	if interval <= 0 {
+		interval = 10
+	}
+	for {
+		time.Sleep(time.Second * time.Duration(interval))
+		if err := SaveCheckpoints(path); err != nil {
+			dlog.Errorf("save checkpoints failed [path:%s][err:%v]", path, err)
+		}
+	}
+}
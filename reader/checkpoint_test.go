@@ -0,0 +1,159 @@
+package reader
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/statefile"
+)
+
+// resetCheckpoints to clear the checkpoints loaded by a test
+func resetCheckpoints() {
+	checkpointsLock.Lock()
+	checkpoints = make(map[string]*Checkpoint)
+	replayMarks = make(map[string][]*ReplayMark)
+	pauseState = nil
+	checkpointsLock.Unlock()
+}
+
+// TestCheckpointCompatibility loads checkpoint files written at each historical version
+func TestCheckpointCompatibility(t *testing.T) {
+	defer resetCheckpoints()
+	full := map[string]*Checkpoint{
+		"/var/log/api.log": {Path: "/var/log/api.log.1", Gen: 3, Offset: 42, Time: 1500000000,
+			Marks: []*ReplayMark{{Gen: 3, StrategyID: 12, Tms: 1499999940, Offset: 40}}, Inode: 1234, Head: "1024:9f86d081"},
+		"/var/log/db.log": {Path: "/var/log/db.log", Offset: 7, Time: 1500000000},
+	}
+	cases := []struct {
+		fixture string
+		version int
+		files   map[string]*Checkpoint
+		pauses  string
+	}{
+		{"v1_unversioned.json", 1, map[string]*Checkpoint{"/var/log/api.log": {Path: "/var/log/api.log", Offset: 42}}, ""},
+		{"v1.json", 1, full, `[{"id":1,"ids":[12],"principal":"alice"}]`},
+		{"v2.state", 2, full, `[{"id":1,"ids":[12],"principal":"alice"}]`},
+	}
+	for _, c := range cases {
+		dir, _ := ioutil.TempDir("", "checkpoint")
+		defer os.RemoveAll(dir)
+		bs, err := ioutil.ReadFile(filepath.Join("testdata", "checkpoint", c.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "checkpoint.json")
+		ioutil.WriteFile(path, bs, 0644)
+
+		cf, version, err := readCheckpointFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.fixture, err)
+		}
+		if version != c.version || !reflect.DeepEqual(cf.Files, c.files) || string(cf.Pauses) != c.pauses {
+			t.Errorf("%s: got version %d files %+v pauses %s", c.fixture, version, cf.Files, cf.Pauses)
+		}
+
+		resetCheckpoints()
+		if err := LoadCheckpoints(path); err != nil {
+			t.Fatalf("%s: %v", c.fixture, err)
+		}
+		for k, want := range c.files {
+			if cp, ok := GetCheckpoint(k); !ok || cp.Offset != want.Offset || !reflect.DeepEqual(GetReplayMarks(k), want.Marks) {
+				t.Errorf("%s: checkpoint of %s not loaded: %+v", c.fixture, k, cp)
+			}
+		}
+
+		// 迁移后是当前版本, 内容不变
+		if err := MigrateCheckpoints(path); err != nil {
+			t.Fatalf("%s: migrate failed: %v", c.fixture, err)
+		}
+		cf, version, err = readCheckpointFile(path)
+		if err != nil || version != CheckpointVersion || !reflect.DeepEqual(cf.Files, c.files) || string(cf.Pauses) != c.pauses {
+			t.Errorf("%s: after migrate got version %d files %+v err %v", c.fixture, version, cf, err)
+		}
+	}
+}
+
+func TestCheckpointCorruptQuarantined(t *testing.T) {
+	defer resetCheckpoints()
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	for _, content := range []string{
+		"{broken",
+		`{"version":"0","files":{"/tmp/a.log":{"path":"/tmp/a.log","offset":100}}}`,
+		"#falcon-log-agent-state checkpoint v2 100 00000000\n{\"files\":{}}",
+	} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if err := LoadCheckpoints(path); err != nil {
+			t.Fatalf("corrupted file should not stop loading: %v", err)
+		}
+		if _, ok := GetCheckpoint("/tmp/a.log"); ok {
+			t.Errorf("checkpoint of corrupted file should be ignored: %q", content)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("corrupted file should be moved aside: %q", content)
+		}
+		quarantined, _ := filepath.Glob(path + ".corrupt.*")
+		if len(quarantined) != 1 {
+			t.Fatalf("corrupted file should be kept for inspection, got %v", quarantined)
+		}
+		os.Remove(quarantined[0])
+	}
+}
+
+func TestCheckpointFutureVersionRefused(t *testing.T) {
+	defer resetCheckpoints()
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	content := "#falcon-log-agent-state checkpoint v99 2 00000000\n{}"
+	ioutil.WriteFile(path, []byte(content), 0644)
+	if err := LoadCheckpoints(path); !errors.Is(err, statefile.ErrFutureVersion) {
+		t.Fatalf("future version should be refused, got %v", err)
+	}
+	if err := MigrateCheckpoints(path); !errors.Is(err, statefile.ErrFutureVersion) {
+		t.Fatalf("future version should not be migrated, got %v", err)
+	}
+	if bs, _ := ioutil.ReadFile(path); string(bs) != content {
+		t.Error("file of future version should be left as is")
+	}
+}
+
+func TestConsumeSinceCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log")
+	ioutil.WriteFile(path, []byte("line 1\nline 2\n"), 0644)
+
+	// 从checkpoint续读时, 消费从checkpoint记录的时间算起
+	resumed := time.Now().Add(-time.Minute).Unix()
+	checkpointsLock.Lock()
+	checkpoints[path] = &Checkpoint{Path: path, Offset: 14, Time: resumed}
+	checkpointsLock.Unlock()
+	r, err := NewReader(path, make(chan Line, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ConsumeSince(); got != resumed {
+		t.Errorf("resumed reader consumes since %d, want checkpoint time %d", got, resumed)
+	}
+	r.Stop()
+
+	// 没有checkpoint时从打开文件时算起
+	before := time.Now().Unix()
+	r, err = NewReader(path, make(chan Line, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ConsumeSince(); got < before || got > time.Now().Unix() {
+		t.Errorf("new reader consumes since %d, want about %d", got, before)
+	}
+	r.Stop()
+}
@@ -0,0 +1,81 @@
+package reader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// 自动检测出的日志格式
+const (
+	LineFormatJSON   = "json"
+	LineFormatLogfmt = "logfmt"
+	LineFormatSyslog = "syslog"
+	LineFormatPlain  = "plain"
+)
+
+// DetectFormatLines 检测格式时从文件开头取的行数
+const DetectFormatLines = 10
+
+// syslogPrefixReg RFC3164(可带<PRI>)或RFC5424的行首
+var syslogPrefixReg = regexp.MustCompile(`^(<\d{1,3}>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2} \S+ |\d \d{4}-\d{2}-\d{2}T\S+ \S+ )`)
+
+// DetectLineFormat to detect the format of the lines, 空行不参与判断, 没有可判断的行时为plain
+// 全部是JSON对象为json, 全部是key=value为logfmt, 全部有syslog行首为syslog, 否则为plain
+func DetectLineFormat(lines []string) string {
+	var sample []string
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			sample = append(sample, l)
+		}
+	}
+	if len(sample) == 0 {
+		return LineFormatPlain
+	}
+	all := func(fn func(string) bool) bool {
+		for _, l := range sample {
+			if !fn(l) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case all(func(l string) bool { _, err := (JSONLineParser{}).Parse(l); return err == nil }):
+		return LineFormatJSON
+	case all(func(l string) bool { _, err := parseLogfmt(l, true); return err == nil }):
+		return LineFormatLogfmt
+	case all(syslogPrefixReg.MatchString):
+		return LineFormatSyslog
+	}
+	return LineFormatPlain
+}
+
+// DetectFileFormat to detect the format by the first lines of a regular file, returns the format and lines sampled
+// 命名管道等非普通文件读开头会阻塞或消费掉数据, 直接返回错误
+func DetectFileFormat(path string) (string, int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("%s is not a regular file", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	lines := make([]string, 0, DetectFormatLines)
+	for len(lines) < DetectFormatLines && sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return "", 0, err
+	}
+	return DetectLineFormat(lines), len(lines), nil
+}
@@ -0,0 +1,57 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectLineFormat(t *testing.T) {
+	cases := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{`{"level":"info","cost":35}`, "", `{"level":"warn"}`}, LineFormatJSON},
+		{[]string{`time=2018-01-01T12:00:01Z level=error msg="read timeout"`, `level=info latency=3ms empty=`}, LineFormatLogfmt},
+		{[]string{"Jan  5 08:01:02 host01 kernel: Out of memory", "<34>Oct 11 22:14:15 mymachine su: 'su root' failed"}, LineFormatSyslog},
+		{[]string{"<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - An application event"}, LineFormatSyslog},
+		// 各行格式不一致的按纯文本
+		{[]string{`{"level":"info"}`, `level=info`}, LineFormatPlain},
+		{[]string{`level=info`, `level info`}, LineFormatPlain},
+		{[]string{`[1,2]`}, LineFormatPlain},
+		{[]string{"2018-01-01 12:00:01 ERROR cost=35"}, LineFormatPlain},
+		{[]string{"", "  "}, LineFormatPlain},
+		{nil, LineFormatPlain},
+	}
+	for i, c := range cases {
+		if got := DetectLineFormat(c.lines); got != c.want {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestDetectFileFormat(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "detect")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.log")
+
+	// 只看开头的行
+	lines := make([]string, 0, 20)
+	for i := 0; i < DetectFormatLines; i++ {
+		lines = append(lines, `{"n":1}`)
+	}
+	lines = append(lines, "not json")
+	ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+	if format, n, err := DetectFileFormat(file); err != nil || format != LineFormatJSON || n != DetectFormatLines {
+		t.Errorf("got %s %d %v", format, n, err)
+	}
+
+	if _, _, err := DetectFileFormat(dir); err == nil {
+		t.Error("directory should be rejected")
+	}
+	if _, _, err := DetectFileFormat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package reader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+)
+
+// DefaultFIFOOpenTimeout 等待写端连接的默认超时
+const DefaultFIFOOpenTimeout = 10 * time.Second
+
+const (
+	// 停止后唤醒阻塞中open的间隔及次数, FIFO被删除等原因唤醒不了时放弃
+	fifoWakeInterval = 100 * time.Millisecond
+	fifoWakeRetries  = 50
+)
+
+// IsFIFO to check whether the path is a named pipe
+func IsFIFO(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// FIFOReader to read lines from a named pipe
+// 打开FIFO会阻塞到有写端连接, 写端断开(EOF)后重新打开, 等待下一个写端, 不退出
+// 每个写端连接算一代, Offset为本次连接内的字节偏移
+type FIFOReader struct {
+	FilePath    string
+	Stream      chan Line
+	Close       chan struct{}
+	OpenTimeout time.Duration
+
+	lock    sync.Mutex
+	f       *os.File            //当前连接的fd, 与Close的关闭都在lock下进行
+	pending chan fifoOpenResult //等待中的open
+	gen     int64
+	readCnt int64
+	dropCnt int64
+}
+
+// NewFIFOReader to create a FIFO reader
+func NewFIFOReader(filepath string, stream chan Line, openTimeout time.Duration) (*FIFOReader, error) {
+	if !IsFIFO(filepath) {
+		return nil, fmt.Errorf("not a named pipe: %s", filepath)
+	}
+	if openTimeout <= 0 {
+		openTimeout = DefaultFIFOOpenTimeout
+	}
+	return &FIFOReader{
+		FilePath:    filepath,
+		Stream:      stream,
+		Close:       make(chan struct{}),
+		OpenTimeout: openTimeout,
+	}, nil
+}
+
+type fifoOpenResult struct {
+	f   *os.File
+	err error
+}
+
+// open to open the FIFO, wait for a writer at most OpenTimeout
+// 超时后下次调用继续等同一个open, 避免新连上的写端被丢弃的open占用
+func (r *FIFOReader) open() (*os.File, error) {
+	if r.pending == nil {
+		ch := make(chan fifoOpenResult, 1)
+		go func() {
+			f, err := os.OpenFile(r.FilePath, os.O_RDONLY, os.ModeNamedPipe)
+			ch <- fifoOpenResult{f, err}
+		}()
+		r.pending = ch
+	}
+
+	select {
+	case res := <-r.pending:
+		r.pending = nil
+		return res.f, res.err
+	case <-time.After(r.OpenTimeout):
+		return nil, fmt.Errorf("no writer connected in %v", r.OpenTimeout)
+	case <-r.Close:
+	}
+	// 已停止, 以非阻塞方式打开写端唤醒阻塞中的open, 并把打开的fd关掉
+	ch := r.pending
+	r.pending = nil
+	go func() {
+		for i := 0; ; i++ {
+			r.wakeOpen()
+			select {
+			case res := <-ch:
+				if res.f != nil {
+					res.f.Close()
+				}
+				return
+			case <-time.After(fifoWakeInterval):
+			}
+			if i >= fifoWakeRetries {
+				dlog.Warningf("cannot wake the pending open of stopped fifo [path:%s]", r.FilePath)
+				<-ch
+				return
+			}
+		}
+	}()
+	return nil, fmt.Errorf("reader stopped")
+}
+
+// wakeOpen to connect and disconnect a writer, 没有阻塞中的读端时打开失败(ENXIO), 忽略
+func (r *FIFOReader) wakeOpen() {
+	if w, err := os.OpenFile(r.FilePath, os.O_WRONLY|syscall.O_NONBLOCK, os.ModeNamedPipe); err == nil {
+		w.Close()
+	}
+}
+
+func (r *FIFOReader) closed() bool {
+	select {
+	case <-r.Close:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start to read until stopped
+func (r *FIFOReader) Start() {
+	var readSwp, dropSwp int64
+	report := ticker.Register("fifo:"+r.FilePath, func(ticker.Window) {
+		r.lock.Lock()
+		a, b := r.readCnt, r.dropCnt
+		r.lock.Unlock()
+		metric.MetricReadLine(r.FilePath, a-readSwp)
+		metric.MetricDropLine(r.FilePath, b-dropSwp)
+		readSwp, dropSwp = a, b
+	})
+	defer func() {
+		report.Unregister()
+		removeFormatSampler(r.FilePath)
+		close(r.Stream)
+	}()
+
+	throughput := metric.Throughput(r.FilePath)
+	fingerprint := FormatSamplerOf(r.FilePath)
+	for !r.closed() {
+		f, err := r.open()
+		if err != nil {
+			if !r.closed() {
+				dlog.Warningf("open fifo failed, retry [path:%s][err:%v]", r.FilePath, err)
+				if r.pending == nil {
+					// 不是等待超时, 而是打开出错, 避免空转
+					time.Sleep(time.Second)
+				}
+			}
+			continue
+		}
+		r.lock.Lock()
+		if r.closed() {
+			// open返回后才Stop的, Stop看不到这个fd
+			r.lock.Unlock()
+			f.Close()
+			break
+		}
+		r.f = f
+		r.gen++
+		gen := r.gen
+		r.lock.Unlock()
+		dlog.Infof("fifo writer connected [path:%s][gen:%d]", r.FilePath, gen)
+
+		var offset int64
+		rd := bufio.NewReader(newDeadlineReader(f, lineReadTimeout()))
+		for {
+			text, err := rd.ReadString('\n')
+			if isReadTimeout(err) {
+				// 写端连着但超时没有新数据, 已读到的半行丢弃
+				if len(text) > 0 {
+					offset += int64(len(text))
+					discardPartialLine(r.FilePath, int64(len(text)))
+				}
+				continue
+			}
+			if len(text) > 0 {
+				offset += int64(len(text))
+				text = strings.TrimRight(text, "\r\n")
+				throughput.Add(len(text) + 1)
+				fingerprint.Observe(text)
+				r.lock.Lock()
+				r.readCnt++
+				r.lock.Unlock()
+				l := Line{Text: text, Gen: gen, Offset: offset}
+				select {
+				case r.Stream <- l:
+				default:
+					if WaitStream(r.FilePath, r.Stream, l, r.Close) {
+						break
+					}
+					r.lock.Lock()
+					r.dropCnt++
+					r.lock.Unlock()
+				}
+			}
+			if err != nil {
+				if err != io.EOF && !r.closed() {
+					dlog.Errorf("read fifo failed [path:%s][err:%v]", r.FilePath, err)
+				}
+				break
+			}
+		}
+
+		r.lock.Lock()
+		r.f = nil
+		r.lock.Unlock()
+		f.Close()
+		if !r.closed() {
+			dlog.Infof("fifo writer disconnected, wait for next one [path:%s][gen:%d]", r.FilePath, gen)
+		}
+	}
+}
+
+// Stop to stop the reader
+// 关闭当前的fd, 让阻塞中的读返回; 阻塞中的open由open自己唤醒
+func (r *FIFOReader) Stop() {
+	r.lock.Lock()
+	close(r.Close)
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.lock.Unlock()
+}
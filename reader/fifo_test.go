@@ -0,0 +1,158 @@
+//go:build linux || darwin || freebsd || openbsd
+// +build linux darwin freebsd openbsd
+
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFIFOReader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "fifo")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo not supported: %v", err)
+	}
+	if !IsFIFO(path) {
+		t.Fatal("should detect named pipe")
+	}
+
+	stream := make(chan Line, 10)
+	r, err := NewFIFOReader(path, stream, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+
+	// 没有写端时等待超时后重试, 不退出
+	time.Sleep(120 * time.Millisecond)
+
+	write := func(content string) {
+		w, err := os.OpenFile(path, os.O_WRONLY, os.ModeNamedPipe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteString(content)
+		w.Close()
+	}
+	recv := func() Line {
+		select {
+		case l := <-stream:
+			return l
+		case <-time.After(2 * time.Second):
+			t.Fatal("no line received")
+		}
+		return Line{}
+	}
+
+	write("first writer\nline two\n")
+	if l := recv(); l.Text != "first writer" || l.Gen != 1 {
+		t.Errorf("unexpected line %+v", l)
+	}
+	if l := recv(); l.Text != "line two" || l.Offset != int64(len("first writer\nline two\n")) {
+		t.Errorf("unexpected line %+v", l)
+	}
+
+	// 写端断开后等待下一个写端
+	write("second writer\n")
+	if l := recv(); l.Text != "second writer" || l.Gen != 2 {
+		t.Errorf("unexpected line %+v", l)
+	}
+
+	r.Stop()
+	select {
+	case _, ok := <-stream:
+		for ok {
+			_, ok = <-stream
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream should be closed after stop")
+	}
+}
+
+// 写端写了半行后卡住, 超时后丢弃半行, 之后的行正常读取
+func TestFIFOLineReadTimeout(t *testing.T) {
+	setLineReadTimeout(t, 100*time.Millisecond)
+	dir, _ := ioutil.TempDir("", "fifo")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo not supported: %v", err)
+	}
+	stream := make(chan Line, 10)
+	r, err := NewFIFOReader(path, stream, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	defer r.Stop()
+
+	w, err := os.OpenFile(path, os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	before := ReadTimeouts()
+	w.WriteString("first\nhalf a li")
+	waitReadTimeouts(t, before, 1)
+	// 写端连着但没有数据时不算超时
+	time.Sleep(300 * time.Millisecond)
+	w.WriteString("second\n")
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case l := <-stream:
+			if l.Text != want {
+				t.Fatalf("got %q, want %q", l.Text, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q not read", want)
+		}
+	}
+	if n := ReadTimeouts() - before; n != 1 {
+		t.Errorf("discarded %d partial lines, want 1", n)
+	}
+}
+
+// 没有写端时停止, 阻塞在open中的goroutine被唤醒退出, 不再占着读端
+func TestFIFOStopWithoutWriter(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "fifo")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo not supported: %v", err)
+	}
+
+	stream := make(chan Line, 10)
+	r, err := NewFIFOReader(path, stream, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+	select {
+	case <-stream:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream should be closed after stop")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, os.ModeNamedPipe)
+		if err != nil {
+			break
+		}
+		w.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("pending open should exit after stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,48 @@
+package reader
+
+import (
+	"os"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// fileSizeCheckInterval 检查正在读的文件大小的间隔
+const fileSizeCheckInterval = 10 * time.Second
+
+// maxFileSizeWarningMB to get the size over which a file is reported, 0 means not checked
+func maxFileSizeWarningMB() int64 {
+	if g.Conf() != nil && g.Conf().Reader.MaxFileSizeWarningMB > 0 {
+		return int64(g.Conf().Reader.MaxFileSizeWarningMB)
+	}
+	return 0
+}
+
+// checkFileSize to report the file being read when it is larger than maxMB
+// 日志打印失控时文件增长很快, 在磁盘写满之前通过log.agent.file.size_warning_mb告警; 轮转或截断后变小则取消
+func (r *Reader) checkFileSize(now time.Time, maxMB int64) {
+	if maxMB <= 0 || now.Sub(r.sizeChecked) < fileSizeCheckInterval {
+		return
+	}
+	r.sizeChecked = now
+	fi, err := os.Stat(r.CurrentPath)
+	if err != nil {
+		return
+	}
+	mb := float64(fi.Size()) / (1 << 20)
+	if fi.Size() > maxMB<<20 {
+		if !r.sizeWarned {
+			dlog.Warningf("log file grows beyond max_file_size_warning_mb [file:%s][path:%s][size_mb:%.1f][max_mb:%d]", r.FilePath, r.CurrentPath, mb, maxMB)
+		}
+		r.sizeWarned = true
+		metric.MetricFileSizeWarning(r.FilePath, mb)
+		return
+	}
+	if r.sizeWarned {
+		dlog.Infof("log file size back under max_file_size_warning_mb [file:%s][path:%s][size_mb:%.1f]", r.FilePath, r.CurrentPath, mb)
+		r.sizeWarned = false
+		metric.ClearFileSizeWarning(r.FilePath)
+	}
+}
@@ -0,0 +1,46 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+func TestCheckFileSize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "filesize")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, make([]byte, 3<<20), 0644)
+
+	r := &Reader{FilePath: path, CurrentPath: path}
+	defer metric.ClearFileSizeWarning(path)
+	now := time.Now()
+
+	// 没有配置时不检查
+	r.checkFileSize(now, 0)
+	if _, ok := metric.FileSizeWarnings()[path]; ok {
+		t.Fatal("should not be checked without threshold")
+	}
+
+	r.checkFileSize(now, 2)
+	if mb := metric.FileSizeWarnings()[path]; mb != 3 {
+		t.Fatalf("file larger than threshold should be reported, got %v", mb)
+	}
+
+	// 间隔内不重复stat
+	os.Truncate(path, 1<<20)
+	r.checkFileSize(now.Add(time.Second), 2)
+	if _, ok := metric.FileSizeWarnings()[path]; !ok {
+		t.Fatal("should not be checked again within the interval")
+	}
+
+	// 轮转或截断后变小则取消
+	r.checkFileSize(now.Add(fileSizeCheckInterval), 2)
+	if _, ok := metric.FileSizeWarnings()[path]; ok {
+		t.Fatal("warning should be cleared after the file shrinks")
+	}
+}
@@ -0,0 +1,352 @@
+package reader
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+const (
+	defaultFingerprintEvery  = 100
+	defaultFingerprintWindow = 200
+	// 每行最多扫描的字节数及sketch最多保留的token数, 保证单次采样的开销有上限
+	fingerprintScanBytes = 512
+	fingerprintMaxTokens = 24
+	// 占比超过该值的sketch才作为基线
+	fingerprintDominantShare = 0.5
+	// 基线占比跌到建立时的该比例以下, 视为格式变化
+	fingerprintDropRatio  = 0.5
+	fingerprintMaxChanges = 10
+)
+
+// token的类型
+const (
+	tokenDigits    = "D"
+	tokenHex       = "H"
+	tokenQuoted    = "Q"
+	tokenBracketed = "B"
+	tokenIP        = "I"
+	tokenTime      = "T"
+	tokenWord      = "W"
+)
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isHexLetter(c byte) bool {
+	return (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+func isWordChar(c byte) bool {
+	return isDigit(c) || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// classifyNumeric to classify a token starting with digit
+func classifyNumeric(tok string) string {
+	dots, seps, hex := 0, 0, false
+	for i := 0; i < len(tok); i++ {
+		switch c := tok[i]; {
+		case c == '.':
+			dots++
+		case c == ':' || c == '-' || c == '/' || c == 'T' || c == '+':
+			seps++
+		case isHexLetter(c):
+			hex = true
+		}
+	}
+	switch {
+	case dots == 3 && seps == 0 && !hex:
+		return tokenIP
+	case seps > 0:
+		return tokenTime
+	case hex:
+		return tokenHex
+	}
+	return tokenDigits
+}
+
+// Sketch to compute the structural sketch of a line
+// 只保留token的类型序列, 如 `1.1.1.1 - [01/Jan/2018:12:00:00] "GET /" 200` -> `I - B Q D`
+func Sketch(line string) string {
+	return strings.Join(scanTokens(line, false), " ")
+}
+
+// Template to compute the template of a line
+// 与Sketch相同, 但保留不含数字的单词, 其余token替换为<类型>, 如 `GET /api 200` -> `GET / api <D>`
+func Template(line string) []string {
+	return scanTokens(line, true)
+}
+
+// scanTokens to split the line into typed tokens, keepWords to keep words without digits literally
+func scanTokens(line string, keepWords bool) []string {
+	if len(line) > fingerprintScanBytes {
+		line = line[:fingerprintScanBytes]
+	}
+	tokens := make([]string, 0, fingerprintMaxTokens)
+	for i := 0; i < len(line) && len(tokens) < fingerprintMaxTokens; {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '"':
+			j := strings.IndexByte(line[i+1:], '"')
+			if j < 0 {
+				i = len(line)
+			} else {
+				i += j + 2
+			}
+			tokens = append(tokens, placeholder(tokenQuoted, keepWords))
+			continue
+		case c == '[':
+			j := strings.IndexByte(line[i+1:], ']')
+			if j < 0 {
+				i = len(line)
+			} else {
+				i += j + 2
+			}
+			tokens = append(tokens, placeholder(tokenBracketed, keepWords))
+			continue
+		case isDigit(c):
+			j := i
+			for j < len(line) && (isWordChar(line[j]) || strings.IndexByte(".:-/+", line[j]) >= 0) {
+				j++
+			}
+			tokens = append(tokens, placeholder(classifyNumeric(line[i:j]), keepWords))
+			i = j
+			continue
+		case isWordChar(c):
+			j, digits, hex := i, false, true
+			for j < len(line) && isWordChar(line[j]) {
+				digits = digits || isDigit(line[j])
+				hex = hex && (isDigit(line[j]) || isHexLetter(line[j]))
+				j++
+			}
+			switch {
+			case hex && digits && j-i >= 8:
+				tokens = append(tokens, placeholder(tokenHex, keepWords))
+			case keepWords && !digits:
+				tokens = append(tokens, line[i:j])
+			default:
+				tokens = append(tokens, placeholder(tokenWord, keepWords))
+			}
+			i = j
+			continue
+		}
+		tokens = append(tokens, string(c))
+		i++
+	}
+	return tokens
+}
+
+// placeholder to wrap the token type in templates, so it cannot be confused with a word
+func placeholder(typ string, template bool) string {
+	if template {
+		return "<" + typ + ">"
+	}
+	return typ
+}
+
+// FormatChange is a detected change of the dominant sketch
+type FormatChange struct {
+	Tms         int64   `json:"tms"`
+	Before      string  `json:"before"`
+	After       string  `json:"after"`
+	BeforeShare float64 `json:"before_share"`
+	AfterShare  float64 `json:"after_share"`
+}
+
+// FormatStat is a snapshot of FormatSampler
+type FormatStat struct {
+	File     string          `json:"file"`
+	Sampled  int64           `json:"sampled"`
+	Baseline string          `json:"baseline"`
+	Dominant string          `json:"dominant"`
+	Share    float64         `json:"share"`
+	Changes  []*FormatChange `json:"changes"`
+}
+
+// FormatSampler to detect format change of one file
+// 每every行采样一行计算sketch, 在最近window个样本上统计各sketch的占比,
+// 基线sketch占比骤降或出现新的主导sketch时, 认为格式发生了变化
+type FormatSampler struct {
+	sync.Mutex
+	file   string
+	every  int64
+	lines  int64
+	ring   []string
+	pos    int
+	full   bool
+	counts map[string]int
+
+	sampled       int64
+	baseline      string
+	baselineShare float64
+	changes       []*FormatChange
+}
+
+// NewFormatSampler to create a sampler
+func NewFormatSampler(file string, every, window int) *FormatSampler {
+	if every <= 0 {
+		every = defaultFingerprintEvery
+	}
+	if window <= 0 {
+		window = defaultFingerprintWindow
+	}
+	return &FormatSampler{
+		file:   file,
+		every:  int64(every),
+		ring:   make([]string, window),
+		counts: make(map[string]int),
+	}
+}
+
+// Observe to count a line, only every n-th line is sketched
+func (s *FormatSampler) Observe(line string) {
+	if atomic.AddInt64(&s.lines, 1)%s.every != 0 {
+		return
+	}
+	s.add(Sketch(line))
+}
+
+func (s *FormatSampler) add(sketch string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sampled++
+	if s.full {
+		old := s.ring[s.pos]
+		if s.counts[old]--; s.counts[old] <= 0 {
+			delete(s.counts, old)
+		}
+	}
+	s.ring[s.pos] = sketch
+	s.counts[sketch]++
+	s.pos = (s.pos + 1) % len(s.ring)
+	if s.pos == 0 {
+		s.full = true
+	}
+	if s.full {
+		s.check()
+	}
+}
+
+func (s *FormatSampler) dominant() (string, float64) {
+	var best string
+	var cnt int
+	for sketch, c := range s.counts {
+		if c > cnt || (c == cnt && sketch < best) {
+			best, cnt = sketch, c
+		}
+	}
+	return best, float64(cnt) / float64(len(s.ring))
+}
+
+// check to compare dominant sketch with baseline, called when window is full
+func (s *FormatSampler) check() {
+	dominant, share := s.dominant()
+	if s.baseline == "" {
+		if share >= fingerprintDominantShare {
+			s.baseline, s.baselineShare = dominant, share
+		}
+		return
+	}
+
+	baselineShare := float64(s.counts[s.baseline]) / float64(len(s.ring))
+	newDominant := dominant != s.baseline && share >= fingerprintDominantShare
+	dropped := baselineShare < s.baselineShare*fingerprintDropRatio
+	if !newDominant && !dropped {
+		if baselineShare > s.baselineShare {
+			s.baselineShare = baselineShare
+		}
+		return
+	}
+
+	change := &FormatChange{
+		Tms:         time.Now().Unix(),
+		Before:      s.baseline,
+		After:       dominant,
+		BeforeShare: baselineShare,
+		AfterShare:  share,
+	}
+	s.changes = append(s.changes, change)
+	if len(s.changes) > fingerprintMaxChanges {
+		s.changes = s.changes[1:]
+	}
+	metric.MetricFormatChange(s.file, 1)
+	dlog.Warningf("log format changed [file:%s][before:%s][after:%s][before_share:%.2f][after_share:%.2f]",
+		s.file, change.Before, change.After, change.BeforeShare, change.AfterShare)
+
+	// 新格式稳定时作为新的基线, 否则等待稳定
+	if share >= fingerprintDominantShare {
+		s.baseline, s.baselineShare = dominant, share
+	} else {
+		s.baseline, s.baselineShare = "", 0
+	}
+}
+
+// Stat to get a snapshot
+func (s *FormatSampler) Stat() *FormatStat {
+	s.Lock()
+	defer s.Unlock()
+	dominant, share := s.dominant()
+	changes := make([]*FormatChange, len(s.changes))
+	copy(changes, s.changes)
+	return &FormatStat{
+		File:     s.file,
+		Sampled:  s.sampled,
+		Baseline: s.baseline,
+		Dominant: dominant,
+		Share:    share,
+		Changes:  changes,
+	}
+}
+
+var (
+	formatSamplers     = make(map[string]*FormatSampler)
+	formatSamplersLock = new(sync.RWMutex)
+)
+
+// FormatSamplerOf to get the format sampler of a file
+// 调用方持有返回值, 避免每行查map
+func FormatSamplerOf(file string) *FormatSampler {
+	formatSamplersLock.RLock()
+	s, ok := formatSamplers[file]
+	formatSamplersLock.RUnlock()
+	if ok {
+		return s
+	}
+
+	every, window := 0, 0
+	if g.Conf() != nil {
+		every, window = g.Conf().Reader.FingerprintEvery, g.Conf().Reader.FingerprintWindow
+	}
+	formatSamplersLock.Lock()
+	defer formatSamplersLock.Unlock()
+	if s, ok = formatSamplers[file]; !ok {
+		s = NewFormatSampler(file, every, window)
+		formatSamplers[file] = s
+	}
+	return s
+}
+
+// removeFormatSampler to drop the sampler of a file when its reader stops
+// 仍持有旧sampler的读取goroutine退出前的采样不再可见, 同一文件重新开始读时从头建立基线
+func removeFormatSampler(file string) {
+	formatSamplersLock.Lock()
+	delete(formatSamplers, file)
+	formatSamplersLock.Unlock()
+}
+
+// GetFormatStat to get format stat of a file
+func GetFormatStat(file string) (*FormatStat, bool) {
+	formatSamplersLock.RLock()
+	s, ok := formatSamplers[file]
+	formatSamplersLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return s.Stat(), true
+}
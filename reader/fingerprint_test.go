@@ -0,0 +1,100 @@
+package reader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	cases := map[string]string{
+		`10.0.0.1 - - [01/Jan/2018:12:00:00 +0800] "GET / HTTP/1.1" 200 512`: "I - - B Q D D",
+		`2018-01-01 12:00:01 ERROR request failed`:                           "T T W W W",
+		`{"level":"info","cost":12}`:                                         "{ Q : Q , Q : D }",
+		`trace 5f2b9c0e1a7d deadbeef`:                                        "W H W",
+	}
+	for line, want := range cases {
+		if got := Sketch(line); got != want {
+			t.Errorf("Sketch(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	cases := map[string]string{
+		`2018-01-01 12:00:01 ERROR request failed cost=12`: "<T> <T> ERROR request failed cost = <D>",
+		`GET /api/user42 "ok" [x]`:                          "GET / api / <W> <Q> <B>",
+	}
+	for line, want := range cases {
+		if got := strings.Join(Template(line), " "); got != want {
+			t.Errorf("Template(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestFormatSamplerStable(t *testing.T) {
+	s := NewFormatSampler("stable.log", 1, 20)
+	for i := 0; i < 1000; i++ {
+		s.Observe(fmt.Sprintf(`10.0.%d.%d - - [01/Jan/2018:12:00:%02d +0800] "GET /api/%d HTTP/1.1" %d %d`,
+			i%256, i%200, i%60, i, 200+i%5, i*7))
+	}
+	stat := s.Stat()
+	if len(stat.Changes) != 0 {
+		t.Fatalf("stable file alerted: %+v", stat.Changes[0])
+	}
+	if stat.Baseline == "" || stat.Sampled != 1000 {
+		t.Fatalf("unexpected stat: %+v", stat)
+	}
+}
+
+func TestFormatSamplerChange(t *testing.T) {
+	every, window := 10, 20
+	s := NewFormatSampler("change.log", every, window)
+	for i := 0; i < 500; i++ {
+		s.Observe(fmt.Sprintf(`2018-01-01 12:00:%02d INFO cost %d`, i%60, i))
+	}
+	baseline := s.Stat().Baseline
+	if baseline == "" {
+		t.Fatal("baseline not established")
+	}
+
+	detected := -1
+	for i := 0; i < every*window; i++ {
+		s.Observe(fmt.Sprintf(`{"level":"info","cost":%d}`, i))
+		if len(s.Stat().Changes) > 0 {
+			detected = i
+			break
+		}
+	}
+	if detected < 0 {
+		t.Fatalf("format change not detected in %d lines", every*window)
+	}
+	c := s.Stat().Changes[0]
+	if c.Before != baseline {
+		t.Errorf("before = %q, want %q", c.Before, baseline)
+	}
+}
+
+// 停止reader时删除文件的sampler, 不随文件路径的变化一直增长
+func TestFormatSamplerRemovedOnStop(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "fingerprint")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log")
+	ioutil.WriteFile(path, []byte("line 1\n"), 0644)
+
+	r, err := NewReader(path, make(chan Line, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	FormatSamplerOf(path).Observe("line 1")
+	if _, ok := GetFormatStat(path); !ok {
+		t.Fatal("sampler should exist while reading")
+	}
+	r.Stop()
+	if _, ok := GetFormatStat(path); ok {
+		t.Error("sampler should be removed after stop")
+	}
+}
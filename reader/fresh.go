@@ -0,0 +1,47 @@
+package reader
+
+import (
+	"os"
+	"time"
+)
+
+// freshSampleInterval 同一文件最多每隔这么久抽样一行, 判断读取是否已追上写入
+const freshSampleInterval = time.Second
+
+// freshSampler marks sampled lines read while the reader is at the end of the file
+// 读到的位置已到文件大小说明没有积压, 该行是刚写入的; 只在抽样时stat文件
+type freshSampler struct {
+	path string
+	last time.Time
+	tell func() (int64, error)            //tail读到的位置
+	size func(path string) (int64, error) //文件当前的大小
+}
+
+func newFreshSampler(path string, tell func() (int64, error)) *freshSampler {
+	return &freshSampler{path: path, tell: tell, size: fileSize}
+}
+
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// sample to get the read time in unix milliseconds if the line is sampled and fresh, 0 otherwise
+func (s *freshSampler) sample(now time.Time) int64 {
+	if now.Sub(s.last) < freshSampleInterval {
+		return 0
+	}
+	s.last = now
+	pos, err := s.tell()
+	if err != nil {
+		return 0
+	}
+	size, err := s.size(s.path)
+	if err != nil || pos < size {
+		return 0
+	}
+	return now.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,30 @@
+package reader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreshSampler(t *testing.T) {
+	var pos, size int64 = 100, 100
+	s := newFreshSampler("/var/log/fresh.log", func() (int64, error) { return pos, nil })
+	s.size = func(string) (int64, error) { return size, nil }
+
+	now := time.Unix(1514779200, 0)
+	if got := s.sample(now); got != now.UnixNano()/int64(time.Millisecond) {
+		t.Fatalf("line read at the end of file should be fresh, got %d", got)
+	}
+	// 一秒内只抽样一次
+	if got := s.sample(now.Add(500 * time.Millisecond)); got != 0 {
+		t.Fatalf("lines within the sample interval should not be sampled, got %d", got)
+	}
+	// 读取落后于写入
+	size = 4096
+	if got := s.sample(now.Add(2 * time.Second)); got != 0 {
+		t.Fatalf("line read with backlog should not be fresh, got %d", got)
+	}
+	pos = 4096
+	if got := s.sample(now.Add(3 * time.Second)); got == 0 {
+		t.Fatal("line should be fresh again after catching up")
+	}
+}
@@ -0,0 +1,35 @@
+package reader
+
+import "sync/atomic"
+
+// handoff is the end offset of the last line of one file taken by the workers
+// checkpoint记录worker已取走的位置而不是tail读到的位置, 还在stream(及lifo队列)中的行重启后会重新读到
+type handoff struct {
+	offset int64
+}
+
+func newHandoff(offset int64) *handoff {
+	return &handoff{offset: offset}
+}
+
+// ack to move the offset forward to a taken line, lines taken out of order do not move it back
+func (h *handoff) ack(offset int64) {
+	for {
+		cur := atomic.LoadInt64(&h.offset)
+		if offset <= cur || atomic.CompareAndSwapInt64(&h.offset, cur, offset) {
+			return
+		}
+	}
+}
+
+func (h *handoff) load() int64 {
+	return atomic.LoadInt64(&h.offset)
+}
+
+// Ack to record that the workers have taken the line, the checkpoint of its file can move past it
+// 不是从文件读到的行(如otlp)及拆分后其他shard重复Ack不影响
+func (l Line) Ack() {
+	if l.handoff != nil {
+		l.handoff.ack(l.Offset)
+	}
+}
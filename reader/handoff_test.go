@@ -0,0 +1,55 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandoffAck(t *testing.T) {
+	h := newHandoff(10)
+	Line{Offset: 20, handoff: h}.Ack()
+	// 多个worker乱序取走, 不回退
+	Line{Offset: 15, handoff: h}.Ack()
+	if n := h.load(); n != 20 {
+		t.Fatalf("handoff should keep the largest offset, got %d", n)
+	}
+	// 不是从文件读到的行
+	Line{Offset: 30}.Ack()
+}
+
+func TestCheckpointTakenLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("a\nbb\nccc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveCheckpoint(path)
+
+	r := &Reader{FilePath: path, Stream: make(chan Line, 10), Close: make(chan struct{}), wake: make(chan struct{}, 1)}
+	if err := r.openFile(0, os.SEEK_SET, path); err != nil {
+		t.Fatal(err)
+	}
+	r.startRead()
+	for deadline := time.Now().Add(5 * time.Second); len(r.Stream) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 3 lines read, got %d", len(r.Stream))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 只取走第一行, 另外两行还在stream中
+	(<-r.Stream).Ack()
+	r.t.Stop()
+	r.reading.Wait()
+
+	cp, ok := GetCheckpoint(path)
+	if !ok || cp.Offset != int64(len("a\n")) {
+		t.Fatalf("checkpoint should stop after the taken line, got %+v", cp)
+	}
+}
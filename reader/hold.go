@@ -0,0 +1,72 @@
+package reader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// holdRecheck 阻塞写入期间检查暂停是否已解除的间隔
+var holdRecheck = 100 * time.Millisecond
+
+var (
+	holdCnt   int64 //被暂停的文件数, 为0时不查map
+	holdsLock sync.RWMutex
+	holds     = make(map[string]int)
+)
+
+// HoldStream to make readers of the file wait instead of dropping lines when the stream is full
+// 下游worker group暂停时调用, 可重入, 需与ReleaseStream成对调用
+func HoldStream(filePath string) {
+	holdsLock.Lock()
+	holds[filePath]++
+	if holds[filePath] == 1 {
+		atomic.AddInt64(&holdCnt, 1)
+	}
+	holdsLock.Unlock()
+}
+
+// ReleaseStream to release a hold of the file
+func ReleaseStream(filePath string) {
+	holdsLock.Lock()
+	if n, ok := holds[filePath]; ok {
+		if n <= 1 {
+			delete(holds, filePath)
+			atomic.AddInt64(&holdCnt, -1)
+		} else {
+			holds[filePath] = n - 1
+		}
+	}
+	holdsLock.Unlock()
+}
+
+// StreamHeld to check whether the stream of the file is held
+func StreamHeld(filePath string) bool {
+	if atomic.LoadInt64(&holdCnt) == 0 {
+		return false
+	}
+	holdsLock.RLock()
+	_, ok := holds[filePath]
+	holdsLock.RUnlock()
+	return ok
+}
+
+// WaitStream to send a line to a full stream while the file is held
+// 暂停期间阻塞等待(反压, 不再往下读), 解除后仍写不进去返回false, 由调用方按原逻辑丢弃
+func WaitStream(filePath string, stream chan Line, line Line, closed <-chan struct{}) bool {
+	for StreamHeld(filePath) {
+		select {
+		case stream <- line:
+			return true
+		case <-closed:
+			return false
+		case <-time.After(holdRecheck):
+		}
+	}
+	select {
+	case stream <- line:
+		return true
+	default:
+		return false
+	}
+}
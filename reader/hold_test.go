@@ -0,0 +1,52 @@
+package reader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitStream(t *testing.T) {
+	defer func(d time.Duration) { holdRecheck = d }(holdRecheck)
+	holdRecheck = 10 * time.Millisecond
+
+	stream := make(chan Line, 1)
+	stream <- Line{Text: "a"}
+	// 未暂停时不等待
+	if WaitStream("/a.log", stream, Line{Text: "b"}, nil) {
+		t.Fatal("should not wait when not held")
+	}
+
+	HoldStream("/a.log")
+	HoldStream("/a.log")
+	ReleaseStream("/a.log")
+	if !StreamHeld("/a.log") || StreamHeld("/b.log") {
+		t.Fatal("hold should be counted per file")
+	}
+	done := make(chan bool)
+	go func() { done <- WaitStream("/a.log", stream, Line{Text: "b"}, nil) }()
+	select {
+	case <-done:
+		t.Fatal("should wait while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-stream
+	if !<-done || (<-stream).Text != "b" {
+		t.Fatal("line should be sent once there is room")
+	}
+
+	// 关闭时放弃
+	stream <- Line{Text: "a"}
+	closed := make(chan struct{})
+	close(closed)
+	if WaitStream("/a.log", stream, Line{Text: "b"}, closed) {
+		t.Fatal("should give up when closed")
+	}
+
+	// 解除暂停后按原逻辑丢弃
+	go func() { done <- WaitStream("/a.log", stream, Line{Text: "b"}, nil) }()
+	time.Sleep(20 * time.Millisecond)
+	ReleaseStream("/a.log")
+	if <-done || StreamHeld("/a.log") {
+		t.Fatal("should give up after release")
+	}
+}
@@ -0,0 +1,14 @@
+package reader
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode to get the inode of a file, 0 if unknown
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package reader
+
+import "os"
+
+// fileInode to get the inode of a file, 0 if unknown
+// 取不到inode时checkpoint不记录, 不做轮转追赶
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
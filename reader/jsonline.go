@@ -0,0 +1,42 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONLineParser to split a line of one JSON object into key/value pairs
+// 如nginx的`log_format ... escape=json '{"time_local":"$time_local","status":"$status",...}'`
+// 只取第一层的key; 字符串取解码后的值, 数字、true/false保持原文, null为空值, 对象及数组为紧凑的json
+type JSONLineParser struct{}
+
+// Parse to parse one line
+func (p JSONLineParser) Parse(line string) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, fmt.Errorf("json: %v", err)
+	}
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v[0] {
+		case '"':
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return nil, fmt.Errorf("json: value of %s: %v", k, err)
+			}
+			fields[k] = s
+		case 'n':
+			fields[k] = ""
+		case '{', '[':
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, v); err != nil {
+				return nil, fmt.Errorf("json: value of %s: %v", k, err)
+			}
+			fields[k] = buf.String()
+		default:
+			fields[k] = string(v)
+		}
+	}
+	return fields, nil
+}
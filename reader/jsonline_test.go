@@ -0,0 +1,38 @@
+package reader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONLineParse(t *testing.T) {
+	cases := map[string]map[string]string{
+		// nginx escape=json的输出, 所有变量都是字符串
+		`{"time_local":"01/Jan/2018:12:00:01 +0800","request":"GET /a?b=1 HTTP/1.1","status":"200","http_user_agent":"curl \"x\"\u001b","upstream_addr":""}`: {
+			"time_local": "01/Jan/2018:12:00:01 +0800", "request": "GET /a?b=1 HTTP/1.1", "status": "200",
+			"http_user_agent": "curl \"x\"\x1b", "upstream_addr": "",
+		},
+		`{"cost": 1.50, "ok": true, "trace": null, "ctx": {"a": [1, 2]}, "ids": ["x"]}`: {
+			"cost": "1.50", "ok": "true", "trace": "", "ctx": `{"a":[1,2]}`, "ids": `["x"]`,
+		},
+		` {"a":"1","a":"2"} `: {"a": "2"},
+		`{}`:                  {},
+	}
+	var p JSONLineParser
+	for line, want := range cases {
+		got, err := p.Parse(line)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %v, want %v", line, got, want)
+		}
+	}
+
+	for _, line := range []string{`{"status":"200"`, `["a"]`, `status=200`, ``} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) should fail", line)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package reader
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// SampleLineLengths to read the tail of the file and count line lengths, used by --check
+// 只读最后maxBytes字节, 从中间开始时丢弃第一行不完整的部分, 最后一行没有换行符时不计入
+func SampleLineLengths(path string, maxBytes int64) (metric.LineLengthStat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return metric.LineLengthStat{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return metric.LineLengthStat{}, err
+	}
+	skip := fi.Size() > maxBytes
+	if skip {
+		if _, err := f.Seek(fi.Size()-maxBytes, io.SeekStart); err != nil {
+			return metric.LineLengthStat{}, err
+		}
+	}
+
+	h := metric.NewLineLengths()
+	br := bufio.NewReader(f)
+	size := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		size += len(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if !skip {
+			h.Observe(size - 1)
+		}
+		skip, size = false, 0
+	}
+	return h.Stat(), nil
+}
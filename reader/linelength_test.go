@@ -0,0 +1,42 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+func TestSampleLineLengths(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sample")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	// 3行短行, 1行超过缓冲区的长行, 最后一行没有换行符
+	content := "short\n" + strings.Repeat("a", 200) + "\n" + strings.Repeat("b", 70*1024) + "\nok\npartial"
+	ioutil.WriteFile(path, []byte(content), 0644)
+
+	stat, err := SampleLineLengths(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [metric.LineLengthBuckets]int64{2, 1, 0, 0, 0, 1}
+	if stat.Counts != want || stat.Max != 70*1024 {
+		t.Fatalf("sample %+v", stat)
+	}
+
+	// 只读末尾时丢弃第一行不完整的部分
+	stat, err = SampleLineLengths(path, int64(len("bb\nok\npartial")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Lines != 1 || stat.Max != 2 {
+		t.Fatalf("tail sample %+v", stat)
+	}
+
+	if _, err := SampleLineLengths(filepath.Join(dir, "missing.log"), 1024); err == nil {
+		t.Fatal("sample of missing file should fail")
+	}
+}
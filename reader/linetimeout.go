@@ -0,0 +1,141 @@
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// readTimeouts 因等不到换行而丢弃的半行数
+var readTimeouts int64
+
+// ReadTimeouts to get the count of partial lines discarded for timeout
+func ReadTimeouts() int64 {
+	return atomic.LoadInt64(&readTimeouts)
+}
+
+// lineReadTimeout to get how long a partial line may wait for its newline, 0 means forever, replaced in test
+var lineReadTimeout = func() time.Duration {
+	if g.Conf() == nil || g.Conf().Reader.LineReadTimeoutMs <= 0 {
+		return 0
+	}
+	return time.Duration(g.Conf().Reader.LineReadTimeoutMs) * time.Millisecond
+}
+
+// discardPartialLine to count a partial line given up
+func discardPartialLine(file string, size int64) {
+	atomic.AddInt64(&readTimeouts, 1)
+	metric.MetricReadTimeout(file, 1)
+	dlog.Warningf("no newline in %v, discard the partial line [file:%s][bytes:%d]", lineReadTimeout(), file, size)
+}
+
+// deadlineReader to set a read deadline before each read of the fifo
+// 写端写了半行后卡住或崩溃时, 读在超时后返回os.ErrDeadlineExceeded, 而不是一直阻塞
+type deadlineReader struct {
+	f       *os.File
+	timeout time.Duration
+}
+
+// newDeadlineReader to wrap the file, the file itself is returned if deadline is not supported
+func newDeadlineReader(f *os.File, timeout time.Duration) io.Reader {
+	if timeout <= 0 || f.SetReadDeadline(time.Time{}) != nil {
+		return f
+	}
+	return &deadlineReader{f: f, timeout: timeout}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.f.SetReadDeadline(time.Now().Add(d.timeout))
+	return d.f.Read(p)
+}
+
+func isReadTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// partialLine 文件末尾没有换行的未读部分, 位置和大小都不变的持续时间超过超时后丢弃
+type partialLine struct {
+	offset int64
+	size   int64
+	since  time.Time
+}
+
+// checkPartialLine to skip a partial line at the end of the file that has waited too long for its newline
+// tail读到不完整的行时退回行首等待换行, 写端在行中间崩溃后会一直等下去, 重启后写入的内容还会拼到这半行后面;
+// 超时后从文件末尾重新开始读, 同一个文件代数不变
+func (r *Reader) checkPartialLine(now time.Time) {
+	timeout := lineReadTimeout()
+	if timeout <= 0 {
+		return
+	}
+	select {
+	case <-r.t.Dead():
+		return
+	default:
+	}
+	offset, err := r.t.Tell()
+	if err != nil {
+		return
+	}
+	fi, err := os.Stat(r.CurrentPath)
+	if err != nil || fi.Size() <= offset {
+		r.partial = partialLine{}
+		return
+	}
+	if r.partial.offset != offset || r.partial.size != fi.Size() || r.partial.since.IsZero() {
+		r.partial = partialLine{offset: offset, size: fi.Size(), since: now}
+		return
+	}
+	if now.Sub(r.partial.since) < timeout {
+		return
+	}
+	// 未读部分有换行说明只是处理得慢
+	if hasNewline(r.CurrentPath, offset, fi.Size()) {
+		r.partial.since = now
+		return
+	}
+	r.partial = partialLine{}
+	discardPartialLine(r.FilePath, fi.Size()-offset)
+	// 等正在读的goroutine退出后再打开, 它最后一次上报时r.t已不是它的tail, 不会用停掉的tail写checkpoint
+	t := r.t
+	r.setTail(nil)
+	t.Stop()
+	r.reading.Wait()
+	if err := r.openFile(fi.Size(), os.SEEK_SET, r.CurrentPath); err != nil {
+		r.waitOpen(r.CurrentPath, fi.Size(), os.SEEK_SET, err)
+		return
+	}
+	r.startRead()
+}
+
+// hasNewline to check whether there is a newline in [from, to) of the file
+func hasNewline(path string, from, to int64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, 32*1024)
+	for from < to {
+		n := int64(len(buf))
+		if to-from < n {
+			n = to - from
+		}
+		l, err := f.ReadAt(buf[:n], from)
+		if bytes.IndexByte(buf[:l], '\n') >= 0 {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		from += int64(l)
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setLineReadTimeout(t *testing.T, d time.Duration) {
+	saved := lineReadTimeout
+	lineReadTimeout = func() time.Duration { return d }
+	t.Cleanup(func() { lineReadTimeout = saved })
+}
+
+// waitReadTimeouts to wait until n more partial lines are discarded
+func waitReadTimeouts(t *testing.T, from, n int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for ReadTimeouts() < from+n {
+		if time.Now().After(deadline) {
+			t.Fatalf("discarded %d partial lines, want %d", ReadTimeouts()-from, n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// 写端在行中间崩溃, 半行超时后丢弃, 重启后写入的行不会拼到半行后面
+func TestFileLineReadTimeout(t *testing.T) {
+	setLineReadTimeout(t, 300*time.Millisecond)
+	dir, _ := ioutil.TempDir("", "linetimeout")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, 0, 1)
+
+	stream := make(chan Line, 100)
+	startReader(t, path, stream)
+	appendLines(t, path, 1, 2)
+	expectLines(t, stream, 1, 2)
+
+	before := ReadTimeouts()
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("line 2 trunc")
+	f.Close()
+	waitReadTimeouts(t, before, 1)
+
+	appendLines(t, path, 3, 5)
+	expectLines(t, stream, 3, 5)
+	select {
+	case l := <-stream:
+		t.Fatalf("unexpected line %+v", l)
+	default:
+	}
+}
+
+// 处理得慢时未读部分有换行, 不算半行
+func TestHasNewline(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "linetimeout")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	content := "partial" + string(make([]byte, 40*1024)) + "\nrest"
+	ioutil.WriteFile(path, []byte(content), 0644)
+	size := int64(len(content))
+	if !hasNewline(path, 0, size) {
+		t.Error("newline after the first chunk not found")
+	}
+	if hasNewline(path, size-4, size) {
+		t.Error("no newline in the last partial line")
+	}
+}
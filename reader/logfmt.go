@@ -0,0 +1,75 @@
+package reader
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LogfmtParser to split a logfmt line into key/value pairs
+// 如 `time=2024-01-01T00:00:00Z level=error msg="read timeout" latency=42ms`
+// 值可以用双引号包含空格及转义字符, 只有key没有=的按空值处理, 重复的key以最后一个为准
+type LogfmtParser struct{}
+
+// Parse to parse one line
+func (p LogfmtParser) Parse(line string) (map[string]string, error) {
+	return parseLogfmt(line, false)
+}
+
+// parseLogfmt to parse one line, strict时只有key没有=的也报错, 用于判断一行是否是logfmt
+func parseLogfmt(line string, strict bool) (map[string]string, error) {
+	fields := make(map[string]string)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		// key
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("logfmt: missing key at column %d", i)
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			if i < len(line) && line[i] == '"' {
+				return nil, fmt.Errorf("logfmt: unexpected quote in key at column %d", i)
+			}
+			if strict {
+				return nil, fmt.Errorf("logfmt: missing = after key %s", key)
+			}
+			fields[key] = ""
+			continue
+		}
+		i++
+
+		// value
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("logfmt: unterminated quoted value of key %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("logfmt: bad quoted value of key %s: %v", key, err)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] > ' ' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	return fields, nil
+}
@@ -0,0 +1,36 @@
+package reader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogfmtParse(t *testing.T) {
+	cases := map[string]map[string]string{
+		`time=2024-01-01T00:00:00Z level=error latency=42ms`: {
+			"time": "2024-01-01T00:00:00Z", "level": "error", "latency": "42ms",
+		},
+		`msg="read \"a\" timeout"  debug path=/a=b empty=`: {
+			"msg": `read "a" timeout`, "debug": "", "path": "/a=b", "empty": "",
+		},
+		`a=1 a=2`: {"a": "2"},
+		``:        {},
+	}
+	var p LogfmtParser
+	for line, want := range cases {
+		got, err := p.Parse(line)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %v, want %v", line, got, want)
+		}
+	}
+
+	for _, line := range []string{`=1`, `msg="abc`, `a"b=1`} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) should fail", line)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package reader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+	"github.com/didi/falcon-log-agent/reader/otlplogpb"
+)
+
+// OTLPPathPrefix 以此为前缀的file_path, 数据来源是OTLP日志而非文件
+// otlp:// 接收全部日志, otlp://{service.name} 只接收该服务的日志
+const OTLPPathPrefix = "otlp://"
+
+// IsOTLPPath to check whether the path is an OTLP source
+func IsOTLPPath(path string) bool {
+	return strings.HasPrefix(path, OTLPPathPrefix)
+}
+
+// OTLPLogReader to feed OTLP log records to stream
+// 每条LogRecord转为一行: "{timeUnixNano} {body}", 配合time_format otlp_unix_nano使用
+type OTLPLogReader struct {
+	FilePath string
+	Service  string
+	Stream   chan Line
+	Close    chan struct{}
+	readCnt  int64
+	dropCnt  int64
+}
+
+var (
+	otlpReaders     = make(map[string]*OTLPLogReader)
+	otlpReadersLock = new(sync.RWMutex)
+)
+
+// NewOTLPLogReader to create an OTLP reader
+func NewOTLPLogReader(filepath string, stream chan Line) (*OTLPLogReader, error) {
+	if !IsOTLPPath(filepath) {
+		return nil, fmt.Errorf("not an otlp path: %s", filepath)
+	}
+	r := &OTLPLogReader{
+		FilePath: filepath,
+		Service:  strings.TrimPrefix(filepath, OTLPPathPrefix),
+		Stream:   stream,
+		Close:    make(chan struct{}),
+	}
+	return r, nil
+}
+
+// Start to receive records until stopped
+func (r *OTLPLogReader) Start() {
+	otlpReadersLock.Lock()
+	otlpReaders[r.FilePath] = r
+	otlpReadersLock.Unlock()
+
+	var readSwp, dropSwp int64
+	report := ticker.Register("otlp:"+r.FilePath, func(ticker.Window) {
+		otlpReadersLock.RLock()
+		a, b := r.readCnt, r.dropCnt
+		otlpReadersLock.RUnlock()
+		metric.MetricReadLine(r.FilePath, a-readSwp)
+		metric.MetricDropLine(r.FilePath, b-dropSwp)
+		readSwp, dropSwp = a, b
+	})
+	<-r.Close
+	otlpReadersLock.Lock()
+	delete(otlpReaders, r.FilePath)
+	otlpReadersLock.Unlock()
+	report.Unregister()
+	removeFormatSampler(r.FilePath)
+	close(r.Stream)
+}
+
+// Stop to stop the reader
+func (r *OTLPLogReader) Stop() {
+	close(r.Close)
+}
+
+// otlp/v1 ExportLogsServiceRequest 的json编码, 只解析需要的字段
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BytesValue  *string  `json:"bytesValue,omitempty"`
+	ArrayValue  *struct {
+		Values []*otlpAnyValue `json:"values"`
+	} `json:"arrayValue,omitempty"`
+	KvlistValue *struct {
+		Values []*otlpKeyValue `json:"values"`
+	} `json:"kvlistValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string        `json:"key"`
+	Value *otlpAnyValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string        `json:"timeUnixNano"`
+	ObservedTimeUnixNano string        `json:"observedTimeUnixNano"`
+	Body                 *otlpAnyValue `json:"body"`
+}
+
+type otlpScopeLogs struct {
+	LogRecords []*otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []*otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs                  []*otlpScopeLogs `json:"scopeLogs"`
+	InstrumentationLibraryLogs []*otlpScopeLogs `json:"instrumentationLibraryLogs"` //旧版本协议
+}
+
+type otlpExportLogsRequest struct {
+	ResourceLogs []*otlpResourceLogs `json:"resourceLogs"`
+}
+
+// plain to convert AnyValue to value for json encoding
+func (v *otlpAnyValue) plain() interface{} {
+	switch {
+	case v == nil:
+		return nil
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		if i, err := strconv.ParseInt(*v.IntValue, 10, 64); err == nil {
+			return i
+		}
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		if bs, err := base64.StdEncoding.DecodeString(*v.BytesValue); err == nil {
+			return string(bs)
+		}
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		ret := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			ret = append(ret, item.plain())
+		}
+		return ret
+	case v.KvlistValue != nil:
+		ret := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			ret[kv.Key] = kv.Value.plain()
+		}
+		return ret
+	}
+	return nil
+}
+
+// otlpLine to convert a LogRecord to a line
+// 字符串body直接使用, 结构化body编码为json
+func otlpLine(record *otlpLogRecord) string {
+	tms := record.TimeUnixNano
+	if tms == "" || tms == "0" {
+		tms = record.ObservedTimeUnixNano
+	}
+
+	var body string
+	if record.Body != nil && record.Body.StringValue != nil {
+		body = *record.Body.StringValue
+	} else if v := record.Body.plain(); v != nil {
+		bs, _ := json.Marshal(v)
+		body = string(bs)
+	}
+	return fmt.Sprintf("%s %s", tms, body)
+}
+
+func otlpServiceName(rl *otlpResourceLogs) string {
+	for _, kv := range rl.Resource.Attributes {
+		if kv.Key == "service.name" && kv.Value != nil && kv.Value.StringValue != nil {
+			return *kv.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// dispatchOTLP to feed lines of one service to matched readers
+func dispatchOTLP(service string, lines []string) {
+	otlpReadersLock.Lock()
+	defer otlpReadersLock.Unlock()
+	for _, r := range otlpReaders {
+		if r.Service != "" && r.Service != service {
+			continue
+		}
+		throughput := metric.Throughput(r.FilePath)
+		fingerprint := FormatSamplerOf(r.FilePath)
+		for _, line := range lines {
+			r.readCnt++
+			throughput.Add(len(line) + 1)
+			fingerprint.Observe(line)
+			select {
+			case r.Stream <- Line{Text: line}:
+			default:
+				r.dropCnt++
+			}
+		}
+	}
+}
+
+// ServeOTLPLogs to receive OTLP/HTTP json logs, mount at POST /v1/logs
+func ServeOTLPLogs(w http.ResponseWriter, req *http.Request) {
+	if ct := req.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		http.Error(w, "only application/json is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	bs, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var export otlpExportLogsRequest
+	if err := json.Unmarshal(bs, &export); err != nil {
+		dlog.Errorf("decode otlp logs failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, rl := range export.ResourceLogs {
+		lines := make([]string, 0)
+		for _, sl := range append(rl.ScopeLogs, rl.InstrumentationLibraryLogs...) {
+			for _, record := range sl.LogRecords {
+				lines = append(lines, otlpLine(record))
+			}
+		}
+		dispatchOTLP(otlpServiceName(rl), lines)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// ExportOTLPLogs to receive OTLP/gRPC logs, the Export method of LogsService served by grpcapi
+// 转为与json编码相同的结构, 行的格式与ServeOTLPLogs一致
+func ExportOTLPLogs(req *otlplogpb.ExportLogsServiceRequest) {
+	for _, rl := range req.GetResourceLogs() {
+		converted := &otlpResourceLogs{}
+		for _, kv := range rl.GetResource().GetAttributes() {
+			converted.Resource.Attributes = append(converted.Resource.Attributes, otlpKeyValueOf(kv))
+		}
+		lines := make([]string, 0)
+		for _, sl := range append(rl.GetScopeLogs(), rl.GetInstrumentationLibraryLogs()...) {
+			for _, record := range sl.GetLogRecords() {
+				lines = append(lines, otlpLine(&otlpLogRecord{
+					TimeUnixNano:         strconv.FormatUint(record.GetTimeUnixNano(), 10),
+					ObservedTimeUnixNano: strconv.FormatUint(record.GetObservedTimeUnixNano(), 10),
+					Body:                 otlpAnyValueOf(record.GetBody()),
+				}))
+			}
+		}
+		dispatchOTLP(otlpServiceName(converted), lines)
+	}
+}
+
+func otlpKeyValueOf(kv *otlplogpb.KeyValue) *otlpKeyValue {
+	return &otlpKeyValue{Key: kv.GetKey(), Value: otlpAnyValueOf(kv.GetValue())}
+}
+
+// otlpAnyValueOf to convert the protobuf AnyValue to its json form, int及bytes按json编码转为字符串
+func otlpAnyValueOf(v *otlplogpb.AnyValue) *otlpAnyValue {
+	if v == nil {
+		return nil
+	}
+	ret := &otlpAnyValue{}
+	switch x := v.GetValue().(type) {
+	case *otlplogpb.AnyValue_StringValue:
+		ret.StringValue = &x.StringValue
+	case *otlplogpb.AnyValue_BoolValue:
+		ret.BoolValue = &x.BoolValue
+	case *otlplogpb.AnyValue_IntValue:
+		i := strconv.FormatInt(x.IntValue, 10)
+		ret.IntValue = &i
+	case *otlplogpb.AnyValue_DoubleValue:
+		ret.DoubleValue = &x.DoubleValue
+	case *otlplogpb.AnyValue_BytesValue:
+		bs := base64.StdEncoding.EncodeToString(x.BytesValue)
+		ret.BytesValue = &bs
+	case *otlplogpb.AnyValue_ArrayValue:
+		ret.ArrayValue = &struct {
+			Values []*otlpAnyValue `json:"values"`
+		}{}
+		for _, item := range x.ArrayValue.GetValues() {
+			ret.ArrayValue.Values = append(ret.ArrayValue.Values, otlpAnyValueOf(item))
+		}
+	case *otlplogpb.AnyValue_KvlistValue:
+		ret.KvlistValue = &struct {
+			Values []*otlpKeyValue `json:"values"`
+		}{}
+		for _, kv := range x.KvlistValue.GetValues() {
+			ret.KvlistValue.Values = append(ret.KvlistValue.Values, otlpKeyValueOf(kv))
+		}
+	default:
+		return nil
+	}
+	return ret
+}
@@ -0,0 +1,61 @@
+package reader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeOTLPLogs(t *testing.T) {
+	all := make(chan Line, 10)
+	svc := make(chan Line, 10)
+	ra, _ := NewOTLPLogReader("otlp://", all)
+	rs, _ := NewOTLPLogReader("otlp://payment", svc)
+	go ra.Start()
+	go rs.Start()
+	defer ra.Stop()
+	defer rs.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	body := `{"resourceLogs":[
+	{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"payment"}}]},
+	 "scopeLogs":[{"logRecords":[
+		{"timeUnixNano":"1700000000000000000","body":{"stringValue":"pay cost=12"}},
+		{"observedTimeUnixNano":"1700000001000000000","body":{"kvlistValue":{"values":[{"key":"cost","value":{"intValue":"7"}}]}}}
+	 ]}]},
+	{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"order"}}]},
+	 "scopeLogs":[{"logRecords":[{"timeUnixNano":"1700000002000000000","body":{"stringValue":"order ok"}}]}]}
+	]}`
+	req := httptest.NewRequest("POST", "/v1/logs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ServeOTLPLogs(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(all) != 3 {
+		t.Errorf("otlp:// should receive all records, got %d", len(all))
+	}
+	if len(svc) != 2 {
+		t.Fatalf("otlp://payment should receive payment records only, got %d", len(svc))
+	}
+	if line := <-svc; line.Text != "1700000000000000000 pay cost=12" {
+		t.Errorf("unexpected line: %s", line.Text)
+	}
+	if line := <-svc; line.Text != `1700000001000000000 {"cost":7}` {
+		t.Errorf("unexpected structured line: %s", line.Text)
+	}
+}
+
+func TestServeOTLPLogsProtobuf(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/logs", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	ServeOTLPLogs(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unexpected code %d", w.Code)
+	}
+}
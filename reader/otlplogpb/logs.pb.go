@@ -0,0 +1,569 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: logs.proto
+
+/*
+Package otlplogpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	logs.proto
+
+It has these top-level messages:
+
+	ExportLogsServiceRequest
+	ExportLogsServiceResponse
+	ExportLogsPartialSuccess
+	ResourceLogs
+	Resource
+	ScopeLogs
+	LogRecord
+	AnyValue
+	ArrayValue
+	KeyValueList
+	KeyValue
+*/
+package otlplogpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type ExportLogsServiceRequest struct {
+	ResourceLogs []*ResourceLogs `protobuf:"bytes,1,rep,name=resource_logs,json=resourceLogs" json:"resource_logs,omitempty"`
+}
+
+func (m *ExportLogsServiceRequest) Reset()                    { *m = ExportLogsServiceRequest{} }
+func (m *ExportLogsServiceRequest) String() string            { return proto.CompactTextString(m) }
+func (*ExportLogsServiceRequest) ProtoMessage()               {}
+func (*ExportLogsServiceRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *ExportLogsServiceRequest) GetResourceLogs() []*ResourceLogs {
+	if m != nil {
+		return m.ResourceLogs
+	}
+	return nil
+}
+
+type ExportLogsServiceResponse struct {
+	PartialSuccess *ExportLogsPartialSuccess `protobuf:"bytes,1,opt,name=partial_success,json=partialSuccess" json:"partial_success,omitempty"`
+}
+
+func (m *ExportLogsServiceResponse) Reset()                    { *m = ExportLogsServiceResponse{} }
+func (m *ExportLogsServiceResponse) String() string            { return proto.CompactTextString(m) }
+func (*ExportLogsServiceResponse) ProtoMessage()               {}
+func (*ExportLogsServiceResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *ExportLogsServiceResponse) GetPartialSuccess() *ExportLogsPartialSuccess {
+	if m != nil {
+		return m.PartialSuccess
+	}
+	return nil
+}
+
+type ExportLogsPartialSuccess struct {
+	RejectedLogRecords int64  `protobuf:"varint,1,opt,name=rejected_log_records,json=rejectedLogRecords" json:"rejected_log_records,omitempty"`
+	ErrorMessage       string `protobuf:"bytes,2,opt,name=error_message,json=errorMessage" json:"error_message,omitempty"`
+}
+
+func (m *ExportLogsPartialSuccess) Reset()                    { *m = ExportLogsPartialSuccess{} }
+func (m *ExportLogsPartialSuccess) String() string            { return proto.CompactTextString(m) }
+func (*ExportLogsPartialSuccess) ProtoMessage()               {}
+func (*ExportLogsPartialSuccess) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ExportLogsPartialSuccess) GetRejectedLogRecords() int64 {
+	if m != nil {
+		return m.RejectedLogRecords
+	}
+	return 0
+}
+
+func (m *ExportLogsPartialSuccess) GetErrorMessage() string {
+	if m != nil {
+		return m.ErrorMessage
+	}
+	return ""
+}
+
+type ResourceLogs struct {
+	Resource                   *Resource    `protobuf:"bytes,1,opt,name=resource" json:"resource,omitempty"`
+	ScopeLogs                  []*ScopeLogs `protobuf:"bytes,2,rep,name=scope_logs,json=scopeLogs" json:"scope_logs,omitempty"`
+	InstrumentationLibraryLogs []*ScopeLogs `protobuf:"bytes,1000,rep,name=instrumentation_library_logs,json=instrumentationLibraryLogs" json:"instrumentation_library_logs,omitempty"`
+}
+
+func (m *ResourceLogs) Reset()                    { *m = ResourceLogs{} }
+func (m *ResourceLogs) String() string            { return proto.CompactTextString(m) }
+func (*ResourceLogs) ProtoMessage()               {}
+func (*ResourceLogs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ResourceLogs) GetResource() *Resource {
+	if m != nil {
+		return m.Resource
+	}
+	return nil
+}
+
+func (m *ResourceLogs) GetScopeLogs() []*ScopeLogs {
+	if m != nil {
+		return m.ScopeLogs
+	}
+	return nil
+}
+
+func (m *ResourceLogs) GetInstrumentationLibraryLogs() []*ScopeLogs {
+	if m != nil {
+		return m.InstrumentationLibraryLogs
+	}
+	return nil
+}
+
+type Resource struct {
+	Attributes []*KeyValue `protobuf:"bytes,1,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *Resource) Reset()                    { *m = Resource{} }
+func (m *Resource) String() string            { return proto.CompactTextString(m) }
+func (*Resource) ProtoMessage()               {}
+func (*Resource) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Resource) GetAttributes() []*KeyValue {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+type ScopeLogs struct {
+	LogRecords []*LogRecord `protobuf:"bytes,2,rep,name=log_records,json=logRecords" json:"log_records,omitempty"`
+}
+
+func (m *ScopeLogs) Reset()                    { *m = ScopeLogs{} }
+func (m *ScopeLogs) String() string            { return proto.CompactTextString(m) }
+func (*ScopeLogs) ProtoMessage()               {}
+func (*ScopeLogs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *ScopeLogs) GetLogRecords() []*LogRecord {
+	if m != nil {
+		return m.LogRecords
+	}
+	return nil
+}
+
+type LogRecord struct {
+	TimeUnixNano         uint64    `protobuf:"fixed64,1,opt,name=time_unix_nano,json=timeUnixNano" json:"time_unix_nano,omitempty"`
+	ObservedTimeUnixNano uint64    `protobuf:"fixed64,11,opt,name=observed_time_unix_nano,json=observedTimeUnixNano" json:"observed_time_unix_nano,omitempty"`
+	Body                 *AnyValue `protobuf:"bytes,5,opt,name=body" json:"body,omitempty"`
+}
+
+func (m *LogRecord) Reset()                    { *m = LogRecord{} }
+func (m *LogRecord) String() string            { return proto.CompactTextString(m) }
+func (*LogRecord) ProtoMessage()               {}
+func (*LogRecord) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *LogRecord) GetTimeUnixNano() uint64 {
+	if m != nil {
+		return m.TimeUnixNano
+	}
+	return 0
+}
+
+func (m *LogRecord) GetObservedTimeUnixNano() uint64 {
+	if m != nil {
+		return m.ObservedTimeUnixNano
+	}
+	return 0
+}
+
+func (m *LogRecord) GetBody() *AnyValue {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+type AnyValue struct {
+	// Types that are valid to be assigned to Value:
+	//	*AnyValue_StringValue
+	//	*AnyValue_BoolValue
+	//	*AnyValue_IntValue
+	//	*AnyValue_DoubleValue
+	//	*AnyValue_ArrayValue
+	//	*AnyValue_KvlistValue
+	//	*AnyValue_BytesValue
+	Value isAnyValue_Value `protobuf_oneof:"value"`
+}
+
+func (m *AnyValue) Reset()                    { *m = AnyValue{} }
+func (m *AnyValue) String() string            { return proto.CompactTextString(m) }
+func (*AnyValue) ProtoMessage()               {}
+func (*AnyValue) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type isAnyValue_Value interface{ isAnyValue_Value() }
+
+type AnyValue_StringValue struct {
+	StringValue string `protobuf:"bytes,1,opt,name=string_value,json=stringValue,oneof"`
+}
+type AnyValue_BoolValue struct {
+	BoolValue bool `protobuf:"varint,2,opt,name=bool_value,json=boolValue,oneof"`
+}
+type AnyValue_IntValue struct {
+	IntValue int64 `protobuf:"varint,3,opt,name=int_value,json=intValue,oneof"`
+}
+type AnyValue_DoubleValue struct {
+	DoubleValue float64 `protobuf:"fixed64,4,opt,name=double_value,json=doubleValue,oneof"`
+}
+type AnyValue_ArrayValue struct {
+	ArrayValue *ArrayValue `protobuf:"bytes,5,opt,name=array_value,json=arrayValue,oneof"`
+}
+type AnyValue_KvlistValue struct {
+	KvlistValue *KeyValueList `protobuf:"bytes,6,opt,name=kvlist_value,json=kvlistValue,oneof"`
+}
+type AnyValue_BytesValue struct {
+	BytesValue []byte `protobuf:"bytes,7,opt,name=bytes_value,json=bytesValue,proto3,oneof"`
+}
+
+func (*AnyValue_StringValue) isAnyValue_Value() {}
+func (*AnyValue_BoolValue) isAnyValue_Value()   {}
+func (*AnyValue_IntValue) isAnyValue_Value()    {}
+func (*AnyValue_DoubleValue) isAnyValue_Value() {}
+func (*AnyValue_ArrayValue) isAnyValue_Value()  {}
+func (*AnyValue_KvlistValue) isAnyValue_Value() {}
+func (*AnyValue_BytesValue) isAnyValue_Value()  {}
+
+func (m *AnyValue) GetValue() isAnyValue_Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *AnyValue) GetStringValue() string {
+	if x, ok := m.GetValue().(*AnyValue_StringValue); ok {
+		return x.StringValue
+	}
+	return ""
+}
+
+func (m *AnyValue) GetBoolValue() bool {
+	if x, ok := m.GetValue().(*AnyValue_BoolValue); ok {
+		return x.BoolValue
+	}
+	return false
+}
+
+func (m *AnyValue) GetIntValue() int64 {
+	if x, ok := m.GetValue().(*AnyValue_IntValue); ok {
+		return x.IntValue
+	}
+	return 0
+}
+
+func (m *AnyValue) GetDoubleValue() float64 {
+	if x, ok := m.GetValue().(*AnyValue_DoubleValue); ok {
+		return x.DoubleValue
+	}
+	return 0
+}
+
+func (m *AnyValue) GetArrayValue() *ArrayValue {
+	if x, ok := m.GetValue().(*AnyValue_ArrayValue); ok {
+		return x.ArrayValue
+	}
+	return nil
+}
+
+func (m *AnyValue) GetKvlistValue() *KeyValueList {
+	if x, ok := m.GetValue().(*AnyValue_KvlistValue); ok {
+		return x.KvlistValue
+	}
+	return nil
+}
+
+func (m *AnyValue) GetBytesValue() []byte {
+	if x, ok := m.GetValue().(*AnyValue_BytesValue); ok {
+		return x.BytesValue
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*AnyValue) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _AnyValue_OneofMarshaler, _AnyValue_OneofUnmarshaler, _AnyValue_OneofSizer, []interface{}{
+		(*AnyValue_StringValue)(nil),
+		(*AnyValue_BoolValue)(nil),
+		(*AnyValue_IntValue)(nil),
+		(*AnyValue_DoubleValue)(nil),
+		(*AnyValue_ArrayValue)(nil),
+		(*AnyValue_KvlistValue)(nil),
+		(*AnyValue_BytesValue)(nil),
+	}
+}
+
+func _AnyValue_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*AnyValue)
+	// value
+	switch x := m.Value.(type) {
+	case *AnyValue_StringValue:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.StringValue)
+	case *AnyValue_BoolValue:
+		t := uint64(0)
+		if x.BoolValue {
+			t = 1
+		}
+		b.EncodeVarint(2<<3 | proto.WireVarint)
+		b.EncodeVarint(t)
+	case *AnyValue_IntValue:
+		b.EncodeVarint(3<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.IntValue))
+	case *AnyValue_DoubleValue:
+		b.EncodeVarint(4<<3 | proto.WireFixed64)
+		b.EncodeFixed64(math.Float64bits(x.DoubleValue))
+	case *AnyValue_ArrayValue:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ArrayValue); err != nil {
+			return err
+		}
+	case *AnyValue_KvlistValue:
+		b.EncodeVarint(6<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.KvlistValue); err != nil {
+			return err
+		}
+	case *AnyValue_BytesValue:
+		b.EncodeVarint(7<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.BytesValue)
+	case nil:
+	default:
+		return fmt.Errorf("AnyValue.Value has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _AnyValue_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*AnyValue)
+	switch tag {
+	case 1: // value.string_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Value = &AnyValue_StringValue{x}
+		return true, err
+	case 2: // value.bool_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &AnyValue_BoolValue{x != 0}
+		return true, err
+	case 3: // value.int_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &AnyValue_IntValue{int64(x)}
+		return true, err
+	case 4: // value.double_value
+		if wire != proto.WireFixed64 {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeFixed64()
+		m.Value = &AnyValue_DoubleValue{math.Float64frombits(x)}
+		return true, err
+	case 5: // value.array_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ArrayValue)
+		err := b.DecodeMessage(msg)
+		m.Value = &AnyValue_ArrayValue{msg}
+		return true, err
+	case 6: // value.kvlist_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(KeyValueList)
+		err := b.DecodeMessage(msg)
+		m.Value = &AnyValue_KvlistValue{msg}
+		return true, err
+	case 7: // value.bytes_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Value = &AnyValue_BytesValue{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _AnyValue_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*AnyValue)
+	// value
+	switch x := m.Value.(type) {
+	case *AnyValue_StringValue:
+		n += proto.SizeVarint(1<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.StringValue)))
+		n += len(x.StringValue)
+	case *AnyValue_BoolValue:
+		n += proto.SizeVarint(2<<3 | proto.WireVarint)
+		n += 1
+	case *AnyValue_IntValue:
+		n += proto.SizeVarint(3<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.IntValue))
+	case *AnyValue_DoubleValue:
+		n += proto.SizeVarint(4<<3 | proto.WireFixed64)
+		n += 8
+	case *AnyValue_ArrayValue:
+		s := proto.Size(x.ArrayValue)
+		n += proto.SizeVarint(5<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *AnyValue_KvlistValue:
+		s := proto.Size(x.KvlistValue)
+		n += proto.SizeVarint(6<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *AnyValue_BytesValue:
+		n += proto.SizeVarint(7<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.BytesValue)))
+		n += len(x.BytesValue)
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+type ArrayValue struct {
+	Values []*AnyValue `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
+}
+
+func (m *ArrayValue) Reset()                    { *m = ArrayValue{} }
+func (m *ArrayValue) String() string            { return proto.CompactTextString(m) }
+func (*ArrayValue) ProtoMessage()               {}
+func (*ArrayValue) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *ArrayValue) GetValues() []*AnyValue {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+type KeyValueList struct {
+	Values []*KeyValue `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
+}
+
+func (m *KeyValueList) Reset()                    { *m = KeyValueList{} }
+func (m *KeyValueList) String() string            { return proto.CompactTextString(m) }
+func (*KeyValueList) ProtoMessage()               {}
+func (*KeyValueList) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *KeyValueList) GetValues() []*KeyValue {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+type KeyValue struct {
+	Key   string    `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value *AnyValue `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *KeyValue) Reset()                    { *m = KeyValue{} }
+func (m *KeyValue) String() string            { return proto.CompactTextString(m) }
+func (*KeyValue) ProtoMessage()               {}
+func (*KeyValue) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *KeyValue) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *KeyValue) GetValue() *AnyValue {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ExportLogsServiceRequest)(nil), "opentelemetry.proto.collector.logs.v1.ExportLogsServiceRequest")
+	proto.RegisterType((*ExportLogsServiceResponse)(nil), "opentelemetry.proto.collector.logs.v1.ExportLogsServiceResponse")
+	proto.RegisterType((*ExportLogsPartialSuccess)(nil), "opentelemetry.proto.collector.logs.v1.ExportLogsPartialSuccess")
+	proto.RegisterType((*ResourceLogs)(nil), "opentelemetry.proto.collector.logs.v1.ResourceLogs")
+	proto.RegisterType((*Resource)(nil), "opentelemetry.proto.collector.logs.v1.Resource")
+	proto.RegisterType((*ScopeLogs)(nil), "opentelemetry.proto.collector.logs.v1.ScopeLogs")
+	proto.RegisterType((*LogRecord)(nil), "opentelemetry.proto.collector.logs.v1.LogRecord")
+	proto.RegisterType((*AnyValue)(nil), "opentelemetry.proto.collector.logs.v1.AnyValue")
+	proto.RegisterType((*ArrayValue)(nil), "opentelemetry.proto.collector.logs.v1.ArrayValue")
+	proto.RegisterType((*KeyValueList)(nil), "opentelemetry.proto.collector.logs.v1.KeyValueList")
+	proto.RegisterType((*KeyValue)(nil), "opentelemetry.proto.collector.logs.v1.KeyValue")
+}
+
+func init() { proto.RegisterFile("logs.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 724 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x55, 0xdb, 0x6e, 0xeb, 0x44,
+	0x14, 0x8d, 0x93, 0x36, 0x4d, 0xb6, 0xdd, 0x82, 0x46, 0x95, 0x08, 0x15, 0x88, 0xe0, 0x82, 0x94,
+	0x97, 0x26, 0xbd, 0x88, 0x67, 0xa0, 0xa8, 0x22, 0x52, 0xc3, 0x6d, 0xda, 0x42, 0x05, 0x12, 0x96,
+	0x2f, 0x1b, 0x77, 0xa8, 0x33, 0xe3, 0xce, 0x8c, 0xa3, 0xe6, 0x03, 0x78, 0xe2, 0x13, 0x78, 0xe2,
+	0x03, 0xce, 0x7f, 0x9d, 0xc7, 0xf3, 0x09, 0x47, 0xf6, 0xd8, 0xae, 0x5b, 0x9d, 0x23, 0x25, 0x79,
+	0xf3, 0xac, 0xbd, 0xf6, 0xda, 0x57, 0xcf, 0x00, 0x24, 0x22, 0x56, 0xe3, 0x54, 0x0a, 0x2d, 0xc8,
+	0x97, 0x22, 0x45, 0xae, 0x31, 0xc1, 0x39, 0x6a, 0xb9, 0x34, 0xe0, 0x38, 0x14, 0x49, 0x82, 0xa1,
+	0x16, 0x72, 0x5c, 0x30, 0x17, 0x27, 0xae, 0x86, 0xc1, 0xc5, 0x63, 0x2a, 0xa4, 0x9e, 0x89, 0x58,
+	0x5d, 0xa1, 0x5c, 0xb0, 0x10, 0x29, 0x3e, 0x64, 0xa8, 0x34, 0xb9, 0x85, 0x5d, 0x89, 0x4a, 0x64,
+	0x32, 0x44, 0x2f, 0xe7, 0x0f, 0xac, 0x61, 0x67, 0x64, 0x9f, 0x9e, 0x8d, 0x57, 0x92, 0x1e, 0xd3,
+	0xd2, 0x37, 0x57, 0xa6, 0x8e, 0x6c, 0x9c, 0xdc, 0x7f, 0x2c, 0xf8, 0xf8, 0x1d, 0x61, 0x55, 0x2a,
+	0xb8, 0x42, 0x72, 0x07, 0x1f, 0xa4, 0xbe, 0xd4, 0xcc, 0x4f, 0x3c, 0x95, 0x85, 0x21, 0xaa, 0x3c,
+	0xb2, 0x35, 0xb2, 0x4f, 0xbf, 0x5e, 0x31, 0xf2, 0x93, 0xf4, 0xcf, 0x46, 0xe7, 0xca, 0xc8, 0xd0,
+	0xbd, 0xf4, 0xd9, 0xd9, 0x7d, 0x80, 0xc1, 0xfb, 0xb8, 0xe4, 0x18, 0xf6, 0x25, 0xfe, 0x8d, 0xa1,
+	0xc6, 0x28, 0xaf, 0xde, 0x93, 0x18, 0x0a, 0x19, 0x99, 0x54, 0x3a, 0x94, 0x54, 0xb6, 0x99, 0x88,
+	0xa9, 0xb1, 0x90, 0x43, 0xd8, 0x45, 0x29, 0x85, 0xf4, 0xe6, 0xa8, 0x94, 0x1f, 0xe3, 0xa0, 0x3d,
+	0xb4, 0x46, 0x7d, 0xea, 0x14, 0xe0, 0x0f, 0x06, 0x73, 0xff, 0x6f, 0x83, 0xd3, 0xec, 0x0c, 0xb9,
+	0x84, 0x5e, 0xd5, 0x9b, 0xb2, 0xcc, 0xc9, 0x9a, 0x0d, 0xa6, 0xb5, 0x00, 0xf9, 0x09, 0x40, 0x85,
+	0x22, 0x2d, 0xe7, 0xd5, 0x2e, 0xe6, 0x75, 0xbc, 0xa2, 0xdc, 0x55, 0xee, 0x58, 0x0c, 0xab, 0xaf,
+	0xaa, 0x4f, 0xa2, 0xe0, 0x13, 0xc6, 0x95, 0x96, 0xd9, 0x1c, 0xb9, 0xf6, 0x35, 0x13, 0xdc, 0x4b,
+	0x58, 0x20, 0x7d, 0xb9, 0x34, 0x21, 0x5e, 0xef, 0x6c, 0x18, 0xe3, 0xe0, 0x85, 0xec, 0xcc, 0xa8,
+	0x16, 0xeb, 0xf1, 0x07, 0xf4, 0x68, 0xa3, 0x22, 0x5f, 0x6b, 0xc9, 0x82, 0x4c, 0x63, 0xb5, 0x81,
+	0xab, 0x36, 0xe8, 0x12, 0x97, 0xbf, 0xfa, 0x49, 0x86, 0xb4, 0x21, 0xe1, 0xfe, 0x09, 0xfd, 0x3a,
+	0x0b, 0xf2, 0x0b, 0xd8, 0xcd, 0xd9, 0xae, 0xd7, 0xb0, 0x7a, 0xf4, 0x14, 0x92, 0xea, 0x53, 0xb9,
+	0xaf, 0x2c, 0xe8, 0xd7, 0x16, 0xf2, 0x05, 0xec, 0x69, 0x36, 0x47, 0x2f, 0xe3, 0xec, 0xd1, 0xe3,
+	0x3e, 0x17, 0xc5, 0x8c, 0xbb, 0xd4, 0xc9, 0xd1, 0x1b, 0xce, 0x1e, 0x7f, 0xf4, 0xb9, 0x20, 0x5f,
+	0xc1, 0x47, 0x22, 0x50, 0x28, 0x17, 0x18, 0x79, 0x2f, 0xe8, 0x76, 0x41, 0xdf, 0xaf, 0xcc, 0xd7,
+	0x4d, 0xb7, 0xef, 0x60, 0x2b, 0x10, 0xd1, 0x72, 0xb0, 0xbd, 0xd6, 0xda, 0x7c, 0xcb, 0xcb, 0xae,
+	0x14, 0xce, 0xee, 0x9b, 0x36, 0xf4, 0x2a, 0x88, 0x1c, 0x82, 0xa3, 0xb4, 0x64, 0x3c, 0xf6, 0x16,
+	0xf9, 0xb9, 0x48, 0xb6, 0x3f, 0x6d, 0x51, 0xdb, 0xa0, 0x86, 0xf4, 0x19, 0x40, 0x20, 0x44, 0x52,
+	0x52, 0xf2, 0x25, 0xef, 0x4d, 0x5b, 0xb4, 0x9f, 0x63, 0x86, 0xf0, 0x29, 0xf4, 0x19, 0xd7, 0xa5,
+	0xbd, 0x93, 0xff, 0x2f, 0xd3, 0x16, 0xed, 0x31, 0xae, 0xeb, 0x20, 0x91, 0xc8, 0x82, 0x04, 0x4b,
+	0xc6, 0xd6, 0xd0, 0x1a, 0x59, 0x79, 0x10, 0x83, 0x1a, 0xd2, 0x35, 0xd8, 0xbe, 0x94, 0xfe, 0xb2,
+	0xe4, 0x98, 0x12, 0x4f, 0x56, 0x2d, 0x31, 0xf7, 0x2c, 0x74, 0xa6, 0x2d, 0x0a, 0x7e, 0x7d, 0x22,
+	0xb7, 0xe0, 0xdc, 0x2f, 0x12, 0xa6, 0xaa, 0xe4, 0xba, 0x43, 0x6b, 0x8d, 0x1b, 0xad, 0xda, 0xa7,
+	0x19, 0x53, 0x3a, 0xcf, 0xd7, 0x48, 0x19, 0xe5, 0xcf, 0xc1, 0x0e, 0x96, 0x1a, 0x55, 0x29, 0xbc,
+	0x33, 0xb4, 0x46, 0x4e, 0x1e, 0xbc, 0x00, 0x0b, 0xca, 0xf9, 0x0e, 0x6c, 0x17, 0x46, 0xf7, 0x06,
+	0xe0, 0x29, 0x43, 0xf2, 0x3d, 0x74, 0x0b, 0x78, 0xdd, 0xed, 0xae, 0xe7, 0x58, 0xba, 0xbb, 0xbf,
+	0x81, 0xd3, 0xcc, 0x70, 0x63, 0xe1, 0xfa, 0xb7, 0xa9, 0x84, 0x43, 0xe8, 0x55, 0x18, 0xf9, 0x10,
+	0x3a, 0xf7, 0xb8, 0x34, 0x8b, 0x41, 0xf3, 0x4f, 0x72, 0x01, 0xdb, 0x4f, 0x9b, 0xb0, 0x41, 0xfa,
+	0xc6, 0xfb, 0xf4, 0x3f, 0x0b, 0xec, 0xc6, 0x6b, 0x40, 0xfe, 0xb5, 0xa0, 0x6b, 0x2e, 0x67, 0xb2,
+	0xfe, 0xbd, 0xff, 0xfc, 0x25, 0x3b, 0xf8, 0x66, 0x73, 0x01, 0xf3, 0x26, 0x9d, 0x9f, 0xfd, 0x7e,
+	0x12, 0x33, 0x7d, 0x97, 0x05, 0xe3, 0x50, 0xcc, 0x27, 0x11, 0x8b, 0xd8, 0xe4, 0x2f, 0x3f, 0x09,
+	0x05, 0x3f, 0x4a, 0x44, 0x7c, 0xe4, 0xc7, 0xc8, 0xf5, 0x44, 0xa2, 0x1f, 0xa1, 0x9c, 0x08, 0x9d,
+	0xa4, 0x89, 0x88, 0xd3, 0x20, 0xe8, 0x16, 0x71, 0xce, 0xde, 0x0e, 0x00, 0x19, 0xa6, 0xe0, 0x27,
+	0x98, 0x07, 0x00, 0x00,
+}
@@ -2,86 +2,277 @@ package reader
 
 import (
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/didi/falcon-log-agent/common/dlog"
 	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
 
 	"github.com/hpcloud/tail"
 )
 
+// Line is a line read from file
+type Line struct {
+	Text   string
+	Gen    int64 //文件轮转代数, 每打开一个新文件加1
+	Offset int64 //该行结束处(含换行符)在文件中的字节偏移
+	// FreshMs 抽样的行读到时reader已在文件末尾, 为读取时间(unix毫秒); 0表示未抽样或读取有积压
+	FreshMs int64
+	handoff *handoff //worker取走后由Ack推进, checkpoint记录它的位置
+}
+
 // Reader to read file
 type Reader struct {
 	FilePath    string //配置的路径 正则路径
 	t           *tail.Tail
-	Stream      chan string
+	tLock       sync.Mutex //Start所在的goroutine替换t时持有, 其他goroutine读t时持有
+	Stream      chan Line
 	CurrentPath string //当前的路径
 	Close       chan struct{}
+	gen         int64
+	startOffset int64 //当前文件开始读取的位置
+	stopped     int32
+	since       int64 //开始消费的时间, 从checkpoint续读时为checkpoint记录的时间, 未打开前为0
+	resumedAt   int64 //续读的checkpoint的时间
+
+	// 文件打不开时(如没有读权限), 按退避间隔重试, 成功后从retryOffset开始读
+	retryPath   string
+	retryOffset int64
+	retryWhence int
+	retries     int
+	nextRetry   time.Time
+
+	// inotify模式下, 目录中有文件创建、改名时被唤醒检查轮转
+	wake        chan struct{}
+	watchedPath string
+
+	// 停机期间文件轮转过时, 先按顺序读完轮转出去的文件, 再读当前文件
+	catchUp []catchUpFile
+
+	// 超过max_file_size_warning_mb时上报, 每fileSizeCheckInterval检查一次
+	sizeChecked time.Time
+	sizeWarned  bool
+
+	// 文件末尾等待换行的半行
+	partial partialLine
+	reading sync.WaitGroup //正在读的StartRead, 轮转时旧文件的可能还没读完
 }
 
 // NewReader to create a reader
-func NewReader(filepath string, stream chan string) (*Reader, error) {
+func NewReader(filepath string, stream chan Line) (*Reader, error) {
 	r := &Reader{
 		FilePath: filepath,
 		Stream:   stream,
 		Close:    make(chan struct{}),
+		wake:     make(chan struct{}, 1),
 	}
 	path := GetCurrentPath(filepath)
-	err := r.openFile(os.SEEK_END, path) //默认打开seek_end
+	offset, whence := int64(0), os.SEEK_END //默认打开seek_end
+	if cp, ok := GetCheckpoint(filepath); ok {
+		// 同一个文件沿用checkpoint中的代数, 否则视为轮转过
+		r.gen = cp.Gen + 1
+		if cp.Path == path {
+			if plan := planCatchUp(cp, path); len(plan) > 0 {
+				// 轮转出去的文件读完后从头读当前文件, 每个文件一个代数
+				r.catchUp = plan
+				r.CurrentPath = path
+				r.gen = cp.Gen + int64(len(plan))
+				r.resumedAt = cp.Time
+				return r, nil
+			}
+			// 文件比checkpoint还短, 说明已被截断或替换, 仍从末尾开始
+			if fi, err := os.Stat(path); err == nil && fi.Size() >= cp.Offset && !rotatedSince(cp, fi) {
+				offset, whence = cp.Offset, os.SEEK_SET
+				r.gen = cp.Gen
+				r.resumedAt = cp.Time
+			}
+		}
+	}
+	// 文件不存在时由tail等待文件出现, 其他错误(如没有读权限)定期重试
+	if err := CheckOpen(path); err != nil && ClassifyOpenError(err) != AccessNotExist {
+		r.waitOpen(path, offset, whence, err)
+		return r, nil
+	}
+	err := r.openFile(offset, whence, path)
 
 	return r, err
 }
 
-func (r *Reader) openFile(whence int, filepath string) error {
+// waitOpen to retry opening the file later
+func (r *Reader) waitOpen(path string, offset int64, whence int, err error) {
+	r.setTail(nil)
+	r.retryPath, r.retryOffset, r.retryWhence = path, offset, whence
+	r.retries++
+	r.nextRetry = time.Now().Add(openRetryBackoff(r.retries))
+	class := ClassifyOpenError(err)
+	setFileAccess(r.FilePath, &FileAccess{
+		Class:     class,
+		Path:      path,
+		Error:     err.Error(),
+		Since:     time.Now().Unix(),
+		Retries:   r.retries,
+		NextRetry: r.nextRetry.Unix(),
+	})
+	if r.retries == 1 {
+		dlog.Errorf("open file failed, will retry [file:%s][path:%s][class:%s][err:%v]", r.FilePath, path, class, err)
+	}
+}
+
+// retryOpen to open the file again if it is time to retry
+// 返回是否已打开
+func (r *Reader) retryOpen() bool {
+	if time.Now().Before(r.nextRetry) {
+		return false
+	}
+	err := CheckOpen(r.retryPath)
+	if err == nil {
+		err = r.openFile(r.retryOffset, r.retryWhence, r.retryPath)
+	}
+	if err != nil {
+		r.waitOpen(r.retryPath, r.retryOffset, r.retryWhence, err)
+		return false
+	}
+	dlog.Infof("open file success after %d retries [file:%s][path:%s]", r.retries, r.FilePath, r.retryPath)
+	r.retries = 0
+	setFileAccess(r.FilePath, nil)
+	r.startRead()
+	return true
+}
+
+func (r *Reader) openFile(offset int64, whence int, filepath string) error {
 	seekinfo := &tail.SeekInfo{
-		Offset: 0,
+		Offset: offset,
 		Whence: whence,
 	}
 	config := tail.Config{
 		Location: seekinfo,
 		ReOpen:   true,
-		Poll:     true,
+		Poll:     !r.watch(filepath),
 		Follow:   true,
 	}
 
+	if whence == os.SEEK_END {
+		if fi, err := os.Stat(filepath); err == nil {
+			offset = fi.Size() + offset
+		}
+	}
+
 	t, err := tail.TailFile(filepath, config)
 	if err != nil {
 		return err
 	}
-	r.t = t
+	r.setTail(t)
+	r.startOffset = offset
 	r.CurrentPath = filepath
+	if atomic.LoadInt64(&r.since) == 0 {
+		since := time.Now().Unix()
+		// checkpoint之后的行都会读到, 消费从checkpoint的时间算起
+		if r.resumedAt > 0 && r.resumedAt < since {
+			since = r.resumedAt
+		}
+		atomic.StoreInt64(&r.since, since)
+	}
 	return nil
 }
 
+// setTail to replace the tail being read
+func (r *Reader) setTail(t *tail.Tail) {
+	r.tLock.Lock()
+	r.t = t
+	r.tLock.Unlock()
+}
+
+// tail to get the tail being read from a goroutine other than the one running Start
+func (r *Reader) tail() *tail.Tail {
+	r.tLock.Lock()
+	defer r.tLock.Unlock()
+	return r.t
+}
+
+// ConsumeSince to get when line consumption of the file began, 0 if not opened yet
+// 从checkpoint续读时返回checkpoint记录的时间, 该时间之后的行都会被读到
+func (r *Reader) ConsumeSince() int64 {
+	return atomic.LoadInt64(&r.since)
+}
+
+// watch to subscribe the shared watcher for the file, false means polling
+func (r *Reader) watch(path string) bool {
+	if watchMode() != WatchModeNotify {
+		return false
+	}
+	if r.watchedPath == path {
+		return true
+	}
+	r.unwatch()
+	if err := watcher.subscribe(path, r.wake); err != nil {
+		return false
+	}
+	r.watchedPath = path
+	return true
+}
+
+func (r *Reader) unwatch() {
+	if r.watchedPath != "" {
+		watcher.unsubscribe(r.watchedPath, r.wake)
+		r.watchedPath = ""
+	}
+}
+
+// startRead to read the current tail in a new goroutine
+func (r *Reader) startRead() {
+	r.reading.Add(1)
+	go func() {
+		defer r.reading.Done()
+		r.StartRead()
+	}()
+}
+
 // StartRead to start to read
 func (r *Reader) StartRead() {
 	var readCnt, readSwp int64
 	var dropCnt, dropSwp int64
+	// 轮转后旧文件的goroutine仍在读剩余内容, tail、路径、代数和偏移在开始时确定
+	t, path, gen, offset := r.t, r.CurrentPath, atomic.LoadInt64(&r.gen), r.startOffset
 
-	analysClose := make(chan int, 0)
-	go func() {
-		for {
-			// 十秒钟统计一次
-			select {
-			case <-analysClose:
-				return
-			case <-time.After(time.Second * 10):
-			}
-			// 统计时间戳可以不准，但是不能漏
-			a := readCnt
-			b := dropCnt
-			metric.MetricReadLine(r.FilePath, a-readSwp)
-			metric.MetricDropLine(r.FilePath, b-dropSwp)
-			readSwp = a
-			dropSwp = b
+	// checkpoint只推进到worker已取走的行
+	taken := newHandoff(offset)
+	// 由共享的ticker按周期统计, 统计时间戳可以不准，但是不能漏
+	report := ticker.Register("reader:"+r.FilePath, func(ticker.Window) {
+		a := atomic.LoadInt64(&readCnt)
+		b := atomic.LoadInt64(&dropCnt)
+		metric.MetricReadLine(r.FilePath, a-readSwp)
+		metric.MetricDropLine(r.FilePath, b-dropSwp)
+		readSwp = a
+		dropSwp = b
+		// 停止后checkpoint已删除, 最后一次上报不再写入; 已被轮转或重新打开替换的tail也不再写入
+		if atomic.LoadInt32(&r.stopped) == 1 || r.tail() != t {
+			return
 		}
-	}()
+		inode, head := fileIdentity(path, false)
+		setCheckpoint(r.FilePath, &Checkpoint{Path: path, Gen: gen, Offset: taken.load(), Inode: inode, Head: head})
+	})
 
-	for line := range r.t.Lines {
-		readCnt = readCnt + 1
+	throughput := metric.Throughput(r.FilePath)
+	fingerprint := FormatSamplerOf(r.FilePath)
+	lengths := metric.LineLength(r.FilePath)
+	fresh := newFreshSampler(path, t.Tell)
+	for line := range t.Lines {
+		atomic.AddInt64(&readCnt, 1)
+		// 读入量按原始行长统计(含换行符), 被丢弃的行也算在内
+		throughput.Add(len(line.Text) + 1)
+		lengths.Observe(len(line.Text))
+		fingerprint.Observe(line.Text)
+		offset += int64(len(line.Text) + 1)
+		l := Line{Text: line.Text, Gen: gen, Offset: offset, FreshMs: fresh.sample(line.Time), handoff: taken}
 		select {
-		case r.Stream <- line.Text:
+		case r.Stream <- l:
 		default:
-			dropCnt = dropCnt + 1
+			if WaitStream(r.FilePath, r.Stream, l, r.Close) {
+				continue
+			}
+			atomic.AddInt64(&dropCnt, 1)
 			//TODO 数据丢失处理，从现时间戳开始截断上报5周期
 			// 是否真的要做？
 			// 首先，5 周期也是拍脑袋的，只能拍脑袋丢数据，并不能保证准确性
@@ -89,29 +280,51 @@ func (r *Reader) StartRead() {
 			// 结论，暂且不做，后人注意
 		}
 	}
-	analysClose <- 0
+	report.Unregister()
 }
 
 // StopRead to stop a read instance
 func (r *Reader) StopRead() error {
-	return r.t.Stop()
+	t := r.tail()
+	if t == nil {
+		return nil
+	}
+	return t.Stop()
 }
 
 // Stop to stop a reader
 func (r *Reader) Stop() {
+	atomic.StoreInt32(&r.stopped, 1)
 	r.StopRead()
+	RemoveCheckpoint(r.FilePath)
+	setFileAccess(r.FilePath, nil)
+	setCatchUp(r.FilePath, nil)
+	removeFormatSampler(r.FilePath)
+	metric.ClearFileSizeWarning(r.FilePath)
 	close(r.Close)
 
 }
 
 // Start a reader
 func (r *Reader) Start() {
-	go r.StartRead()
+	if len(r.catchUp) > 0 {
+		r.finishCatchUp(r.runCatchUp())
+		// 追赶中被停止, 刚打开的文件不再读
+		if atomic.LoadInt32(&r.stopped) == 1 {
+			r.StopRead()
+		}
+	}
+	if r.t != nil && atomic.LoadInt32(&r.stopped) == 0 {
+		r.startRead()
+	}
 	for {
 		select {
 		case <-time.After(time.Second):
 			r.check()
+		case <-r.wake:
+			r.check()
 		case <-r.Close:
+			r.unwatch()
 			close(r.Stream)
 			return
 		}
@@ -120,14 +333,37 @@ func (r *Reader) Start() {
 }
 
 func (r *Reader) check() {
+	if atomic.LoadInt32(&r.stopped) == 1 {
+		return
+	}
+	if r.t == nil {
+		r.retryOpen()
+		return
+	}
+	// tail重新打开文件失败(如logrotate重建的文件没有读权限)时会退出, 从头重试该文件
+	select {
+	case <-r.t.Dead():
+		if err := CheckOpen(r.CurrentPath); err != nil {
+			atomic.AddInt64(&r.gen, 1)
+			r.waitOpen(r.CurrentPath, 0, os.SEEK_SET, err)
+			return
+		}
+	default:
+	}
+
+	r.checkFileSize(time.Now(), maxFileSizeWarningMB())
+
 	nextpath := GetNowPath(r.FilePath)
 	if r.CurrentPath != nextpath {
 		if _, err := os.Stat(nextpath); err != nil {
 			return
 		}
 		r.t.StopAtEOF()
-		if err := r.openFile(os.SEEK_SET, nextpath); err == nil { //从文件开始打开
-			go r.StartRead()
+		atomic.AddInt64(&r.gen, 1)
+		if err := r.openFile(0, os.SEEK_SET, nextpath); err == nil { //从文件开始打开
+			r.startRead()
 		}
+		return
 	}
+	r.checkPartialLine(time.Now())
 }
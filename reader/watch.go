@@ -0,0 +1,202 @@
+package reader
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// 等待文件新数据的方式
+const (
+	WatchModePoll   = "poll"    //默认, 定期stat文件
+	WatchModeNotify = "inotify" //由inotify事件唤醒, 不支持时回退为poll
+)
+
+// watchMode to get the configured watch mode
+var watchMode = func() string {
+	if g.Conf() == nil || g.Conf().Reader.WatchMode == "" {
+		return WatchModePoll
+	}
+	return g.Conf().Reader.WatchMode
+}
+
+// dirWatcher is the watcher shared by all readers, it watches parent directories of tailed files
+// 目录中的文件被创建、改名时唤醒该目录下的reader检查轮转; 新数据由tail自己的inotify唤醒,
+// tail每次被唤醒都会读到EOF, 唤醒后写入的数据会再次触发事件, 不会漏读
+type dirWatcher struct {
+	sync.Mutex
+	w    *fsnotify.Watcher
+	dirs map[string]map[chan struct{}]string //目录 -> 订阅的reader -> 文件
+	err  error                               //不可用的原因, 之后的文件都使用poll
+
+	fallbacks map[string]string //回退为poll的文件 -> 原因
+}
+
+var (
+	watcher = &dirWatcher{
+		dirs:      make(map[string]map[chan struct{}]string),
+		fallbacks: make(map[string]string),
+	}
+
+	// 测试中替换以模拟失败
+	newFsWatcher = fsnotify.NewWatcher
+	addFsWatch   = func(w *fsnotify.Watcher, dir string) error { return w.Add(dir) }
+)
+
+// watchError to explain errors of inotify
+func watchError(err error) error {
+	switch err {
+	case syscall.ENOSPC:
+		return fmt.Errorf("inotify watch limit reached, raise it by sysctl -w fs.inotify.max_user_watches=<n>: %v", err)
+	case syscall.EMFILE:
+		return fmt.Errorf("inotify instance limit reached, raise it by sysctl -w fs.inotify.max_user_instances=<n>: %v", err)
+	}
+	return err
+}
+
+// subscribe to wake the reader of path when the file or its directory changes
+// 返回错误时调用方应使用poll
+func (d *dirWatcher) subscribe(path string, wake chan struct{}) error {
+	dir := filepath.Dir(path)
+	if remote, fs := remoteFS(dir); remote {
+		return d.fallback(path, fmt.Errorf("%s filesystem does not support inotify reliably", fs))
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if d.err != nil {
+		d.fallbacks[path] = d.err.Error()
+		return d.err
+	}
+	if d.w == nil {
+		w, err := newFsWatcher()
+		if err != nil {
+			return d.disable(path, watchError(err))
+		}
+		d.w = w
+		go d.loop(w)
+	}
+	subs, ok := d.dirs[dir]
+	if !ok {
+		if err := addFsWatch(d.w, dir); err != nil {
+			if err == syscall.ENOSPC {
+				return d.disable(path, watchError(err))
+			}
+			d.fallbacks[path] = err.Error()
+			return err
+		}
+		subs = make(map[chan struct{}]string)
+		d.dirs[dir] = subs
+	}
+	subs[wake] = path
+	delete(d.fallbacks, path)
+	return nil
+}
+
+// unsubscribe to stop waking the reader, the directory is unwatched when no reader left
+func (d *dirWatcher) unsubscribe(path string, wake chan struct{}) {
+	dir := filepath.Dir(path)
+	d.Lock()
+	defer d.Unlock()
+	delete(d.fallbacks, path)
+	subs, ok := d.dirs[dir]
+	if !ok {
+		return
+	}
+	delete(subs, wake)
+	if len(subs) == 0 {
+		delete(d.dirs, dir)
+		if d.w != nil {
+			d.w.Remove(dir)
+		}
+	}
+}
+
+func (d *dirWatcher) fallback(path string, err error) error {
+	d.Lock()
+	defer d.Unlock()
+	d.fallbacks[path] = err.Error()
+	dlog.Warningf("watch file failed, fall back to poll [path:%s][err:%v]", path, err)
+	return err
+}
+
+// disable to stop using inotify for files opened later, caller should hold the lock
+func (d *dirWatcher) disable(path string, err error) error {
+	d.err = err
+	d.fallbacks[path] = err.Error()
+	dlog.Errorf("inotify unavailable, fall back to poll for new files: %v", err)
+	return err
+}
+
+func (d *dirWatcher) loop(w *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			d.dispatch(ev)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			dlog.Warningf("inotify error: %v", watchError(err))
+		}
+	}
+}
+
+// dispatch to wake readers of the event
+// 创建、改名、删除可能是轮转, 唤醒同目录的所有reader检查; 写入由tail处理
+func (d *dirWatcher) dispatch(ev fsnotify.Event) {
+	if ev.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	for wake := range d.dirs[filepath.Dir(ev.Name)] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WatchStat is the resource usage of the shared watcher
+type WatchStat struct {
+	Mode       string            `json:"mode"`
+	Dirs       int               `json:"dirs"`       //监听的目录数, 即占用的inotify watch数
+	Files      int               `json:"files"`      //使用inotify的文件数
+	FDs        int               `json:"fds"`        //inotify实例占用的fd
+	Goroutines int               `json:"goroutines"` //分发事件的goroutine
+	Disabled   string            `json:"disabled,omitempty"`
+	Fallbacks  map[string]string `json:"fallbacks,omitempty"` //回退为poll的文件及原因
+}
+
+// GetWatchStat to get resource usage of the shared watcher
+func GetWatchStat() WatchStat {
+	watcher.Lock()
+	defer watcher.Unlock()
+	ret := WatchStat{Mode: watchMode(), Dirs: len(watcher.dirs)}
+	for _, subs := range watcher.dirs {
+		ret.Files += len(subs)
+	}
+	if watcher.w != nil {
+		ret.FDs, ret.Goroutines = 1, 1
+	}
+	if watcher.err != nil {
+		ret.Disabled = watcher.err.Error()
+	}
+	if len(watcher.fallbacks) > 0 {
+		ret.Fallbacks = make(map[string]string, len(watcher.fallbacks))
+		for path, reason := range watcher.fallbacks {
+			ret.Fallbacks[path] = reason
+		}
+	}
+	return ret
+}
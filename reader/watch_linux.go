@@ -0,0 +1,23 @@
+package reader
+
+import "syscall"
+
+// 不可靠地支持inotify的文件系统, 其他机器的写入不会产生事件
+var remoteFSMagic = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x01021997: "9p",
+	0x65735546: "fuse",
+}
+
+// remoteFS to check whether dir is on a network filesystem
+func remoteFS(dir string) (bool, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, ""
+	}
+	fs, ok := remoteFSMagic[int64(st.Type)]
+	return ok, fs
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package reader
+
+// remoteFS to check whether dir is on a network filesystem, only detected on linux
+func remoteFS(dir string) (bool, string) {
+	return false, ""
+}
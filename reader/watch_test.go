@@ -0,0 +1,162 @@
+package reader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// useNotify to switch to inotify mode and reset the shared watcher
+func useNotify(t *testing.T) {
+	mode := watchMode
+	watchMode = func() string { return WatchModeNotify }
+	t.Cleanup(func() {
+		watchMode = mode
+		watcher.Lock()
+		if watcher.w != nil {
+			watcher.w.Close()
+		}
+		watcher.w, watcher.err = nil, nil
+		watcher.dirs = make(map[string]map[chan struct{}]string)
+		watcher.fallbacks = make(map[string]string)
+		watcher.Unlock()
+	})
+}
+
+func appendLines(t *testing.T, path string, from, to int) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := from; i < to; i++ {
+		fmt.Fprintf(f, "line %d\n", i)
+	}
+}
+
+func expectLines(t *testing.T, stream chan Line, from, to int) {
+	for i := from; i < to; i++ {
+		select {
+		case l := <-stream:
+			if want := fmt.Sprintf("line %d", i); l.Text != want {
+				t.Fatalf("expect %q, got %q", want, l.Text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("line %d not read", i)
+		}
+	}
+}
+
+func startReader(t *testing.T, path string, stream chan Line) *Reader {
+	r, err := NewReader(path, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	t.Cleanup(r.Stop)
+	// tail在goroutine中打开文件并seek到末尾
+	time.Sleep(200 * time.Millisecond)
+	return r
+}
+
+func TestWatchWrite(t *testing.T) {
+	useNotify(t)
+	dir, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, 0, 1)
+
+	stream := make(chan Line, 100)
+	r := startReader(t, path, stream)
+	if r.watchedPath != path || r.t.Poll {
+		t.Fatalf("should use inotify, watched %q poll %v", r.watchedPath, r.t.Poll)
+	}
+	if st := GetWatchStat(); st.Dirs != 1 || st.Files != 1 || st.FDs != 1 || st.Goroutines != 1 {
+		t.Fatalf("unexpected watch stat %+v", st)
+	}
+	appendLines(t, path, 1, 3)
+	expectLines(t, stream, 1, 3)
+}
+
+func TestWatchRotateByRename(t *testing.T) {
+	useNotify(t)
+	dir, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, 0, 1)
+
+	stream := make(chan Line, 100)
+	r := startReader(t, path, stream)
+	appendLines(t, path, 1, 2)
+	expectLines(t, stream, 1, 2)
+
+	// 同目录的其他订阅者也被唤醒, 检查轮转
+	wake := make(chan struct{}, 1)
+	if err := watcher.subscribe(filepath.Join(dir, "other.log"), wake); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.unsubscribe(filepath.Join(dir, "other.log"), wake)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-wake:
+	case <-time.After(3 * time.Second):
+		t.Fatal("rename should wake readers of the directory")
+	}
+	appendLines(t, path, 2, 4)
+	expectLines(t, stream, 2, 4)
+	if r.watchedPath != path {
+		t.Fatalf("should keep watching %s, got %s", path, r.watchedPath)
+	}
+}
+
+func TestWatchFallback(t *testing.T) {
+	useNotify(t)
+	add := addFsWatch
+	addFsWatch = func(w *fsnotify.Watcher, dir string) error { return syscall.ENOSPC }
+	defer func() { addFsWatch = add }()
+
+	dir, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, 0, 1)
+
+	stream := make(chan Line, 100)
+	r := startReader(t, path, stream)
+	if r.watchedPath != "" || !r.t.Poll {
+		t.Fatal("should fall back to poll")
+	}
+	st := GetWatchStat()
+	if st.Disabled == "" || st.Fallbacks[path] == "" {
+		t.Fatalf("fallback should be reported, got %+v", st)
+	}
+	appendLines(t, path, 1, 3)
+	expectLines(t, stream, 1, 3)
+}
+
+func TestWatchNoMissedData(t *testing.T) {
+	useNotify(t)
+	dir, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, 0, 1)
+
+	stream := make(chan Line, 10000)
+	startReader(t, path, stream)
+	n := 1
+	for i := 0; i < 200; i++ {
+		appendLines(t, path, n, n+i%7+1)
+		n += i%7 + 1
+		if i%50 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	expectLines(t, stream, 1, n)
+}
@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// WindowsEvent is an <Event> element exported by Windows event forwarding
+// 每行一个完整的<Event>元素, 命名空间不影响解析
+type WindowsEvent struct {
+	XMLName  xml.Name `xml:"Event"`
+	EventID  string   `xml:"System>EventID"`
+	Level    string   `xml:"System>Level"`
+	Computer string   `xml:"System>Computer"`
+	Provider struct {
+		Name string `xml:"Name,attr"`
+	} `xml:"System>Provider"`
+	TimeCreated struct {
+		SystemTime string `xml:"SystemTime,attr"` //RFC 3339, 带纳秒
+	} `xml:"System>TimeCreated"`
+	Data []struct {
+		Name  string `xml:"Name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"EventData>Data"`
+	Message string `xml:"RenderingInfo>Message"`
+}
+
+// ParseWindowsEvent to parse a line of Windows Event Log XML
+func ParseWindowsEvent(line string) (*WindowsEvent, error) {
+	ev := new(WindowsEvent)
+	if err := xml.Unmarshal([]byte(line), ev); err != nil {
+		return nil, fmt.Errorf("windows_event_xml: %v", err)
+	}
+	if ev.TimeCreated.SystemTime == "" {
+		return nil, fmt.Errorf("windows_event_xml: no TimeCreated SystemTime")
+	}
+	return ev, nil
+}
+
+// Text to get the message of the event
+// 没有RenderingInfo(未渲染的事件)时, 用EventData中的值拼接
+func (ev *WindowsEvent) Text() string {
+	if ev.Message != "" {
+		return strings.TrimSpace(ev.Message)
+	}
+	values := make([]string, 0, len(ev.Data))
+	for _, d := range ev.Data {
+		values = append(values, d.Value)
+	}
+	return strings.Join(values, " ")
+}
+
+// Fields to get fields of the event, named EventData are included
+func (ev *WindowsEvent) Fields() map[string]string {
+	fields := map[string]string{
+		"EventID":     ev.EventID,
+		"Level":       ev.Level,
+		"Computer":    ev.Computer,
+		"Provider":    ev.Provider.Name,
+		"TimeCreated": ev.TimeCreated.SystemTime,
+		"Message":     ev.Text(),
+	}
+	for _, d := range ev.Data {
+		if d.Name != "" {
+			fields[d.Name] = d.Value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,51 @@
+package reader
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+const winEventLine = `<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-Security-Auditing"/><EventID>4625</EventID><Level>0</Level><TimeCreated SystemTime="2024-03-01T08:15:30.1234567Z"/><Computer>dc01</Computer></System><EventData><Data Name="TargetUserName">bob</Data><Data Name="IpAddress">10.0.0.8</Data></EventData><RenderingInfo Culture="en-US"><Message>An account failed to log on. cost=12</Message></RenderingInfo></Event>`
+
+func TestParseWindowsEvent(t *testing.T) {
+	ev, err := ParseWindowsEvent(winEventLine)
+	if err != nil {
+		t.Fatalf("ParseWindowsEvent failed: %v", err)
+	}
+	if ev.EventID != "4625" || ev.Provider.Name != "Microsoft-Windows-Security-Auditing" {
+		t.Errorf("unexpected system: %+v", ev)
+	}
+	if got := ev.Text(); got != "An account failed to log on. cost=12" {
+		t.Errorf("Text() = %q", got)
+	}
+	fields := ev.Fields()
+	if fields["TargetUserName"] != "bob" || fields["EventID"] != "4625" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	// 时间按rfc3339_nano解析
+	pat, format := utils.GetPatAndTimeFormat(utils.TimeFormatRFC3339Nano)
+	st := ev.TimeCreated.SystemTime
+	if !regexp.MustCompile(pat).MatchString(st) {
+		t.Fatalf("pattern %s not match %s", pat, st)
+	}
+	tm, err := time.Parse(format, st)
+	if err != nil || tm.UnixNano() != time.Date(2024, 3, 1, 8, 15, 30, 123456700, time.UTC).UnixNano() {
+		t.Errorf("parse %s = %v, %v", st, tm, err)
+	}
+
+	// 未渲染的事件用EventData拼接
+	noMsg := `<Event><System><EventID>1</EventID><TimeCreated SystemTime="2024-03-01T08:15:30Z"/></System><EventData><Data>a</Data><Data>b</Data></EventData></Event>`
+	if ev, err = ParseWindowsEvent(noMsg); err != nil || ev.Text() != "a b" {
+		t.Errorf("no message event: %v %v", ev, err)
+	}
+
+	for _, line := range []string{`<Event><System>`, `<Event><System><EventID>1</EventID></System></Event>`, `plain text`} {
+		if _, err := ParseWindowsEvent(line); err == nil {
+			t.Errorf("ParseWindowsEvent(%q) should fail", line)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+type MatchBody struct {
+	Strategy *scheme.Strategy  `json:"strategy"`
+	Detail   map[string]string `json:"detail"`
+}
+type CheckRet struct {
+	Matched bool         `json:"matched"`
+	Body    []*MatchBody `json:"body"`
+}
+
+func NewCheckRet() *CheckRet {
+	return &CheckRet{Body: make([]*MatchBody, 0)}
+}
+
+func CheckLogByStrategy(content string) *CheckRet {
+	var ret = NewCheckRet()
+
+	sts := strategy.GetListAll()
+	for idx, st := range sts {
+		matched, detail := matchedStrategy(content, st)
+		if matched {
+			ret.Matched = true
+			tmp := &MatchBody{
+				Strategy: sts[idx],
+				Detail:   detail,
+			}
+			ret.Body = append(ret.Body, tmp)
+		}
+	}
+	return ret
+}
+
+func matchedStrategy(content string, strategy *scheme.Strategy) (bool, map[string]string) {
+	var detail = make(map[string]string, 0)
+	// 与worker一致, 先按mask_patterns脱敏
+	content = strategy.MaskLine(content)
+	valid, patMap := getRegsFromOneStrategy(strategy)
+	if !valid {
+		return false, map[string]string{}
+	}
+
+	for key, pat := range patMap {
+		reg, err := regexp.Compile(pat)
+		if err != nil {
+			return false, map[string]string{}
+		}
+		l := reg.FindStringSubmatch(content)
+		if len(l) == 0 {
+			if key != "exclude_" {
+				return false, map[string]string{}
+			}
+			detail[key] = ""
+			continue
+		}
+		detail[key] = l[0]
+		if key == "pattern_" {
+			groupDetail(detail, reg, l, strategy)
+		}
+	}
+
+	if !orderedTagDetail(detail, content, strategy) {
+		return false, map[string]string{}
+	}
+
+	// 匹配了pattern后命中的must_not_contain, 与exclude一样只在详情中给出
+	for _, c := range strategy.MustNotContain {
+		if hit, _ := regexp.MatchString(c, content); hit {
+			detail["must_not_contain_"] = c
+			break
+		}
+	}
+
+	return true, detail
+}
+
+// orderedTagDetail to add values of ordered_tag_extracts to the detail, false if one is not matched
+// 与worker一致按顺序提取, source_tag取其捕获的值; 来自tag_fields的source_tag这里没有解析, 跳过
+func orderedTagDetail(detail map[string]string, content string, strategy *scheme.Strategy) bool {
+	if len(strategy.OrderedTagExtracts) == 0 {
+		return true
+	}
+	captured := make(map[string]string, len(strategy.TagRegs)+len(strategy.OrderedTagExtracts))
+	for tagk, reg := range strategy.TagRegs {
+		if t := reg.FindStringSubmatch(content); len(t) > 1 {
+			captured[tagk] = t[1]
+		}
+	}
+	for _, e := range strategy.OrderedTagExtracts {
+		if e.Reg == nil {
+			return false
+		}
+		src := content
+		if e.SourceTag != "" {
+			v, ok := captured[e.SourceTag]
+			if !ok {
+				continue
+			}
+			src = v
+		}
+		t := e.Reg.FindStringSubmatch(src)
+		if len(t) <= 1 {
+			return false
+		}
+		captured[e.Key] = t[1]
+		detail[e.Key] = t[1]
+	}
+	return true
+}
+
+func getRegsFromOneStrategy(st *scheme.Strategy) (stValid bool, regs map[string]string) {
+	var ret = make(map[string]string, 0)
+
+	// pattern、exclude、time后带下划线，用来与重名tag区分
+	// pattern字段必须有
+	if st.Pattern == "" {
+		return false, map[string]string{}
+	}
+	// 已编译的正则带有pattern_reg_flags
+	ret["pattern_"] = st.Pattern
+	if st.PatternReg != nil {
+		ret["pattern_"] = st.PatternReg.String()
+	}
+
+	// exclude可以缺省
+	if st.Exclude != "" {
+		ret["exclude_"] = st.Exclude
+	}
+
+	// timeFormat必须有,且必须匹配到pat
+	if st.TimeFormat == "" {
+		return false, map[string]string{}
+	} else {
+		pat, _ := utils.GetPatAndTimeFormat(st.TimeFormat)
+		if pat == "" {
+			return false, map[string]string{}
+		} else {
+			ret["time_"] = pat
+			if st.TimeReg != nil {
+				ret["time_"] = st.TimeReg.String()
+			}
+		}
+	}
+
+	// tags可以缺省
+	for k, v := range st.Tags {
+		ret[k] = v
+	}
+
+	return true, ret
+}
+
+// groupDetail to show capture groups of pattern with their numbering, and which one is the value
+// 如 pattern_group_1、pattern_group_2(latency), value_为value_group对应组的内容
+func groupDetail(detail map[string]string, reg *regexp.Regexp, l []string, strategy *scheme.Strategy) {
+	names := reg.SubexpNames()
+	for i := 1; i < len(l); i++ {
+		key := fmt.Sprintf("pattern_group_%d", i)
+		if names[i] != "" {
+			key = fmt.Sprintf("%s(%s)", key, names[i])
+		}
+		detail[key] = l[i]
+	}
+	index := strategy.ValueIndex
+	if index <= 0 {
+		index = 1
+	}
+	if index < len(l) {
+		detail["value_"] = l[index]
+		detail["value_group_"] = strconv.Itoa(index)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"time"
+
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker"
+)
+
+// StreamSummaryInterval 发送summary事件的周期
+var StreamSummaryInterval = 5 * time.Second
+
+// SubscribeEvents to subscribe live events of a strategy, verbose时包含miss/exclude
+func SubscribeEvents(id int64, verbose bool) (*worker.TapClient, error) {
+	if _, err := strategy.GetByID(id); err != nil {
+		return nil, newError(ErrNotFound, err.Error())
+	}
+	return worker.SubscribeTap(id, verbose), nil
+}
+
+// StreamEvents to forward events of the client until it is gone or disconnected
+// gone在客户端断开时关闭, send出错时也会退出
+func StreamEvents(client *worker.TapClient, gone <-chan struct{}, send func(*worker.TapEvent) error) {
+	ticker := time.NewTicker(StreamSummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-client.Events():
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := send(client.Summary()); err != nil {
+				return
+			}
+		case <-client.Done():
+			// 消费太慢被断开, 告知原因
+			summary := client.Summary()
+			if client.Slow() {
+				summary.Reason = "client too slow, disconnected"
+			}
+			send(summary)
+			return
+		case <-gone:
+			return
+		}
+	}
+}
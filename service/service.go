@@ -0,0 +1,55 @@
+// Package service is the control surface shared by the HTTP and gRPC apis
+// 两边的handler只做参数转换, 逻辑都在这里, 行为不会不一致
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// 错误的类别, HTTP和gRPC据此给出状态码
+var (
+	ErrInvalid       = errors.New("invalid argument")
+	ErrNotFound      = errors.New("not found")
+	ErrUnimplemented = errors.New("unimplemented")
+	ErrConflict      = errors.New("conflict")
+)
+
+// Error is an error of the service layer with its kind
+type Error struct {
+	Kind error //ErrInvalid, ErrNotFound, ErrUnimplemented或ErrConflict
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Unwrap to make errors.Is work with the kind
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func newError(kind error, msg string) error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// Authorized to check the token sent by the caller
+// 未配置http.token时不校验; HTTP用Authorization: Bearer头, gRPC用同名metadata
+func Authorized(token string) bool {
+	if g.Conf() == nil || g.Conf().Http.Token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(g.Conf().Http.Token)) == 1
+}
+
+// BearerToken to get the token from an Authorization value
+func BearerToken(authorization string) string {
+	const prefix = "Bearer "
+	if len(authorization) > len(prefix) && authorization[:len(prefix)] == prefix {
+		return authorization[len(prefix):]
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/worker"
+)
+
+func TestErrorKinds(t *testing.T) {
+	if _, err := GetStrategyStats(404); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing strategy: %v, want not found", err)
+	}
+	if _, err := PauseStrategies(worker.PauseSelector{IDs: []int64{1}}, "ops", "soon"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("bad duration: %v, want invalid", err)
+	}
+	if _, err := PauseStrategies(worker.PauseSelector{}, "ops", ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty selector: %v, want invalid", err)
+	}
+	if _, err := ResumeStrategies(worker.PauseSelector{IDs: []int64{404}}, "ops"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("resume without pause: %v, want not found", err)
+	}
+	if _, err := BoostStrategy(404, "ops", "10m", worker.BoostOverrides{Trace: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("boost missing strategy: %v, want not found", err)
+	}
+	if _, err := ClearBoost(404, "ops"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("clear without boost: %v, want not found", err)
+	}
+	if err := SeekFile("/var/log/app.log", 0); !errors.Is(err, ErrUnimplemented) {
+		t.Errorf("seek: %v, want unimplemented", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	cases := map[string]string{"Bearer abc": "abc", "bearer abc": "", "Bearer ": "", "abc": ""}
+	for in, want := range cases {
+		if got := BearerToken(in); got != want {
+			t.Errorf("BearerToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+	// 未配置token时不校验
+	if !Authorized("") {
+		t.Error("no token configured, should be authorized")
+	}
+}
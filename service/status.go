@@ -0,0 +1,148 @@
+package service
+
+import (
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker"
+)
+
+// FileStatus to show status of one tailed file
+type FileStatus struct {
+	Throughput     metric.ThroughputStat                `json:"throughput"`
+	Shed           map[int64]worker.ShedStat            `json:"shed,omitempty"`
+	Replayed       int64                                `json:"replayed,omitempty"`        //开启防重放时, 被跳过的重放行数
+	Access         *reader.FileAccess                   `json:"access,omitempty"`          //文件打不开时的分类及重试情况
+	Paused         []worker.GroupPauseStat              `json:"paused,omitempty"`          //被Pause停下的worker group
+	TagCardinality map[string]worker.TagCardinalityStat `json:"tag_cardinality,omitempty"` //开启max_tag_cardinality时, 所有策略各tag的取值数
+	Groups         []worker.GroupLifecycleStat          `json:"groups,omitempty"`          //各worker group的生命周期状态
+	CatchUp        *reader.CatchUpStat                  `json:"catch_up,omitempty"`        //停机期间轮转过时, 从轮转出去的文件追赶的进度
+	LineLengths    *metric.LineLengthStat               `json:"line_lengths,omitempty"`    //行长分布
+	ClockSkew      *worker.ClockSkewStat                `json:"clock_skew,omitempty"`      //按刚写入的行估计的写日志机器的时钟偏差
+	StrategyGroups []*strategy.Group                    `json:"strategy_groups,omitempty"` //时间正则相同、每行只提取一次时间的策略组
+}
+
+// Status to show agent status
+type Status struct {
+	Files         map[string]*FileStatus           `json:"files"`
+	CounterShards []worker.CounterShardStat        `json:"counter_shards"`           //counter各分片的深度及锁等待
+	Watch         reader.WatchStat                 `json:"watch"`                    //共享的inotify watcher占用的资源
+	ValueRange    map[int64]worker.ValueRangeStat  `json:"value_range,omitempty"`    //各策略超出value_range的值的个数
+	ValueMap      map[int64]worker.ValueMapStat    `json:"value_map,omitempty"`      //各策略value_map每个条目的命中次数
+	TagOversize   map[int64]worker.TagOversizeStat `json:"tag_oversize,omitempty"`   //各策略tag取值超长被截断、丢弃的个数
+	ColdStart     map[int64]worker.ColdStartStat   `json:"cold_start,omitempty"`     //各策略冷启动后第一个不完整周期被丢弃、打tag的次数
+	Funnel        map[int64]worker.FunnelStat      `json:"funnel,omitempty"`         //各策略匹配pattern、被must_not_contain及exclude排除的行数
+	PushEndpoints []worker.EndpointCapabilities    `json:"push_endpoints,omitempty"` //push_compression为auto时各推送地址的协商结果
+	PushConsul    *worker.ConsulResolverStat       `json:"push_consul,omitempty"`    //从consul解析出的推送地址
+	StrategyEtcd  *strategy.EtcdLoaderStat         `json:"strategy_etcd,omitempty"`  //从etcd加载策略的版本及错误
+}
+
+// GetStatus to collect status of all files
+func GetStatus() *Status {
+	ret := &Status{
+		Files:         make(map[string]*FileStatus),
+		CounterShards: worker.GlobalCount.ShardStats(),
+		Watch:         reader.GetWatchStat(),
+		ValueRange:    worker.ValueRangeStats(),
+		ValueMap:      worker.ValueMapStats(),
+		TagOversize:   worker.TagOversizeStats(),
+		ColdStart:     worker.ColdStartStats(),
+		Funnel:        worker.FunnelStats(),
+		PushEndpoints: worker.GetEndpointCapabilities(),
+		StrategyEtcd:  strategy.GetEtcdLoaderStat(),
+	}
+	if stat, ok := worker.GetPushResolverStat(); ok {
+		ret.PushConsul = &stat
+	}
+	for file, stat := range metric.ThroughputStats() {
+		ret.Files[file] = &FileStatus{Throughput: stat}
+	}
+	for file, stat := range metric.LineLengthStats() {
+		stat := stat
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.LineLengths = &stat
+	}
+	for file, stats := range worker.ShedStats() {
+		if len(stats) == 0 {
+			continue
+		}
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.Shed = stats
+	}
+	for file, access := range reader.FileAccessStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		access := access
+		fs.Access = &access
+	}
+	for file, replayed := range worker.ReplayStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.Replayed = replayed
+	}
+	for file, stats := range worker.TagCardinalityStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.TagCardinality = stats
+	}
+	for file, paused := range worker.GroupPauseStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.Paused = paused
+	}
+	for file, groups := range worker.GroupLifecycleStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		fs.Groups = groups
+	}
+	for _, grp := range strategy.GetGroups() {
+		fs, ok := ret.Files[grp.FilePath]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[grp.FilePath] = fs
+		}
+		fs.StrategyGroups = append(fs.StrategyGroups, grp)
+	}
+	for file, catchUp := range reader.CatchUpStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		catchUp := catchUp
+		fs.CatchUp = &catchUp
+	}
+	for file, skew := range worker.ClockSkewStats() {
+		fs, ok := ret.Files[file]
+		if !ok {
+			fs = &FileStatus{}
+			ret.Files[file] = fs
+		}
+		skew := skew
+		fs.ClockSkew = &skew
+	}
+	return ret
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker"
+)
+
+// ListStrategies to get copies of all strategies, with pauses and boosts in their status
+func ListStrategies() []*scheme.Strategy {
+	sts := strategy.GetListAll()
+	for _, st := range sts {
+		for _, s := range []string{worker.PauseStatus(st.ID, st.FilePath), worker.BoostStatus(st.ID)} {
+			if s == "" {
+				continue
+			}
+			if st.Status != "" {
+				s = st.Status + "; " + s
+			}
+			st.Status = s
+		}
+	}
+	return sts
+}
+
+// StrategyStats is the processing stats of one strategy
+type StrategyStats struct {
+	StrategyID  int64                  `json:"strategy_id"`
+	Paused      string                 `json:"paused,omitempty"`
+	Boost       *worker.Boost          `json:"boost,omitempty"`
+	Funnel      worker.FunnelStat      `json:"funnel"`
+	ValueRange  worker.ValueRangeStat  `json:"value_range"`
+	ValueMap    worker.ValueMapStat    `json:"value_map"`
+	TagOversize worker.TagOversizeStat `json:"tag_oversize"`
+	ColdStart   worker.ColdStartStat   `json:"cold_start"`
+}
+
+// GetStrategyStats to collect stats of one strategy, 没有统计的项为0
+func GetStrategyStats(id int64) (*StrategyStats, error) {
+	st, err := strategy.GetByID(id)
+	if err != nil {
+		return nil, newError(ErrNotFound, err.Error())
+	}
+	return &StrategyStats{
+		StrategyID:  id,
+		Paused:      worker.PauseStatus(id, st.FilePath),
+		Boost:       worker.GetBoost(id),
+		Funnel:      worker.FunnelStats()[id],
+		ValueRange:  worker.ValueRangeStats()[id],
+		ValueMap:    worker.ValueMapStats()[id],
+		TagOversize: worker.TagOversizeStats()[id],
+		ColdStart:   worker.ColdStartStats()[id],
+	}, nil
+}
+
+// PauseStrategies to pause strategies during maintenance
+// duration为自动恢复时长, 如30m, 为空则需手动恢复
+func PauseStrategies(sel worker.PauseSelector, principal, duration string) (*worker.Pause, error) {
+	var d time.Duration
+	if duration != "" {
+		var err error
+		if d, err = time.ParseDuration(duration); err != nil || d <= 0 {
+			return nil, newError(ErrInvalid, fmt.Sprintf("bad duration %s", duration))
+		}
+	}
+	p, err := worker.PauseStrategies(sel, principal, d)
+	if err != nil {
+		return nil, newError(ErrInvalid, err.Error())
+	}
+	return p, nil
+}
+
+// ResumeStrategies to resume strategies paused with the same selector
+func ResumeStrategies(sel worker.PauseSelector, principal string) ([]*worker.Pause, error) {
+	removed := worker.ResumeStrategies(sel, principal)
+	if len(removed) == 0 {
+		return nil, newError(ErrNotFound, "no pause with the selector")
+	}
+	return removed, nil
+}
+
+// BoostStrategy to apply temporary overrides to a strategy for ttl, such as 10m
+// 同一策略已有boost时返回ErrConflict, ttl超过boost_max_ttl时按上限
+func BoostStrategy(id int64, principal, ttl string, o worker.BoostOverrides) (*worker.Boost, error) {
+	if _, err := strategy.GetByID(id); err != nil {
+		return nil, newError(ErrNotFound, err.Error())
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return nil, newError(ErrInvalid, fmt.Sprintf("bad ttl %s", ttl))
+	}
+	b, err := worker.BoostStrategy(id, principal, d, o)
+	if errors.Is(err, worker.ErrBoostActive) {
+		return nil, newError(ErrConflict, err.Error())
+	}
+	if err != nil {
+		return nil, newError(ErrInvalid, err.Error())
+	}
+	return b, nil
+}
+
+// ClearBoost to remove the boost of a strategy before it expires
+func ClearBoost(id int64, principal string) (*worker.Boost, error) {
+	b := worker.ClearBoost(id, principal)
+	if b == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("strategy %d is not boosted", id))
+	}
+	return b, nil
+}
+
+// SeekFile to move the read position of a tailed file
+// reader没有运行中重新定位的能力, 只能通过checkpoint在启动时续读
+func SeekFile(filePath string, offset int64) error {
+	return newError(ErrUnimplemented, "seeking a running reader is not supported, restore a checkpoint and restart instead")
+}
@@ -0,0 +1,32 @@
+package strategy
+
+import (
+	"sort"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// buildFileIndex to index strategies by file path, strategies of each file are ordered by id
+// worker每行只取所在文件的策略, 不再遍历全部策略比较file_path
+func buildFileIndex(sts map[int64]*scheme.Strategy) map[string][]*scheme.Strategy {
+	ret := make(map[string][]*scheme.Strategy)
+	for _, st := range sts {
+		ret[st.FilePath] = append(ret[st.FilePath], st)
+	}
+	for _, list := range ret {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	return ret
+}
+
+// GetByFilePath to get strategies of the file ordered by id, nil if the file has none
+// 返回的切片属于已发布的策略表, 只读
+func GetByFilePath(file string) []*scheme.Strategy {
+	return current().byFile[file]
+}
+
+// GetByFilePathWithGroups to get strategies of the file and the groups of all strategies, both belong to the same update
+func GetByFilePathWithGroups(file string) ([]*scheme.Strategy, map[int64]*Group) {
+	snap := current()
+	return snap.byFile[file], snap.groups
+}
@@ -0,0 +1,84 @@
+package strategy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestGetByFilePath(t *testing.T) {
+	defer UpdateGlobalStrategy(nil)
+	UpdateGlobalStrategy([]*scheme.Strategy{
+		{ID: 3, FilePath: "/var/log/a.log"},
+		{ID: 1, FilePath: "/var/log/a.log"},
+		{ID: 2, FilePath: "/var/log/b.log"},
+	})
+	sts := GetByFilePath("/var/log/a.log")
+	if len(sts) != 2 || sts[0].ID != 1 || sts[1].ID != 3 {
+		t.Fatalf("strategies of the file should be ordered by id: %v", sts)
+	}
+	if sts := GetByFilePath("/var/log/none.log"); sts != nil {
+		t.Errorf("file without strategies should get nil, got %v", sts)
+	}
+
+	// 更新后索引整体替换, 之前取到的切片不受影响
+	UpdateGlobalStrategy([]*scheme.Strategy{{ID: 2, FilePath: "/var/log/a.log"}})
+	if len(sts) != 2 || sts[0].ID != 1 {
+		t.Errorf("published index should not be modified: %v", sts)
+	}
+	if sts := GetByFilePath("/var/log/a.log"); len(sts) != 1 || sts[0].ID != 2 {
+		t.Errorf("unexpected strategies after update: %v", sts)
+	}
+	if sts := GetByFilePath("/var/log/b.log"); sts != nil {
+		t.Errorf("removed file should have no strategies: %v", sts)
+	}
+}
+
+// worker每行取所在文件策略的开销, 300个策略分布在100个文件
+// go test -run xxx -bench StrategyLookup ./strategy/
+func BenchmarkStrategyLookup(b *testing.B) {
+	sts := make([]*scheme.Strategy, 0, 300)
+	for i := 0; i < 300; i++ {
+		sts = append(sts, &scheme.Strategy{ID: int64(i + 1), FilePath: fmt.Sprintf("/var/log/app%d.log", i%100), ParseSucc: true})
+	}
+	UpdateGlobalStrategy(sts)
+	defer UpdateGlobalStrategy(nil)
+
+	const file = "/var/log/app7.log"
+	for _, bc := range []struct {
+		name   string
+		lookup func(string) int
+	}{
+		// 之前的做法: 遍历全部策略比较file_path
+		{"scan_all", func(file string) int {
+			n := 0
+			for _, st := range GetAll() {
+				if st.FilePath == file {
+					n++
+				}
+			}
+			return n
+		}},
+		{"by_file", func(file string) int {
+			return len(GetByFilePath(file))
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if n := bc.lookup(file); n != 3 {
+					b.Fatalf("expect 3 strategies, got %d", n)
+				}
+			}
+		})
+	}
+	b.Run("no_strategy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if len(GetByFilePath("/var/log/none.log")) != 0 {
+				b.Fatal("expect no strategy")
+			}
+		}
+	})
+}
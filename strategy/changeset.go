@@ -0,0 +1,132 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// ChangeSetRejection is the rejection reason of one member
+type ChangeSetRejection struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ChangeSetStatus is the apply result of one change-set
+type ChangeSetStatus struct {
+	Name       string                `json:"name"`
+	Version    int64                 `json:"version"`
+	Members    []int64               `json:"members"`
+	Applied    bool                  `json:"applied"`
+	Active     int64                 `json:"active_version"` //当前生效的版本
+	Tms        int64                 `json:"tms"`
+	Rejections []*ChangeSetRejection `json:"rejections,omitempty"`
+}
+
+var (
+	changeSetStatus     = make(map[string]*ChangeSetStatus)
+	changeSetStatusLock = new(sync.RWMutex)
+)
+
+// memberRejection to get why a member cannot be applied, empty if it is valid
+func memberRejection(st *scheme.Strategy) string {
+	if st.ParseSucc {
+		return ""
+	}
+	if st.Status != "" {
+		return st.Status
+	}
+	return "parse failed, see log for detail"
+}
+
+// applyChangeSets to decide which strategies to load
+// 不属于任何change-set的策略照旧独立加载; 同一change-set的策略只有全部编译、校验通过才一起加载,
+// 否则整组推迟, 继续使用当前生效的旧版本(没有旧版本则都不加载), 并记录每个成员不能加载的原因
+func applyChangeSets(strategys []*scheme.Strategy, active map[int64]*scheme.Strategy) []*scheme.Strategy {
+	sets := make(map[string][]*scheme.Strategy)
+	ret := make([]*scheme.Strategy, 0, len(strategys))
+	for _, st := range strategys {
+		if st.ChangeSet == "" {
+			ret = append(ret, st)
+			continue
+		}
+		sets[st.ChangeSet] = append(sets[st.ChangeSet], st)
+	}
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now().Unix()
+	statuses := make(map[string]*ChangeSetStatus, len(sets))
+	for _, name := range names {
+		members := sets[name]
+		status := &ChangeSetStatus{Name: name, Tms: now}
+		var invalid []int64
+		for _, st := range members {
+			status.Members = append(status.Members, st.ID)
+			if st.ChangeSetVersion > status.Version {
+				status.Version = st.ChangeSetVersion
+			}
+			if reason := memberRejection(st); reason != "" {
+				invalid = append(invalid, st.ID)
+				status.Rejections = append(status.Rejections, &ChangeSetRejection{ID: st.ID, Name: st.Name, Reason: reason})
+			}
+		}
+
+		if len(invalid) == 0 {
+			status.Applied = true
+			status.Active = status.Version
+			ret = append(ret, members...)
+			statuses[name] = status
+			continue
+		}
+
+		// 整组推迟, 合法的成员也要记录原因
+		for _, st := range members {
+			if memberRejection(st) == "" {
+				status.Rejections = append(status.Rejections, &ChangeSetRejection{
+					ID:     st.ID,
+					Name:   st.Name,
+					Reason: fmt.Sprintf("deferred, invalid members in change-set: %v", invalid),
+				})
+			}
+		}
+		sort.Slice(status.Rejections, func(i, j int) bool { return status.Rejections[i].ID < status.Rejections[j].ID })
+		for _, st := range active {
+			if st.ChangeSet == name {
+				ret = append(ret, st)
+				status.Active = st.ChangeSetVersion
+			}
+		}
+		statuses[name] = status
+		for _, r := range status.Rejections {
+			dlog.Warningf("change-set deferred [set:%s][version:%d][sid:%d][reason:%s]", name, status.Version, r.ID, r.Reason)
+		}
+	}
+
+	// 只保留本次下发的change-set
+	changeSetStatusLock.Lock()
+	changeSetStatus = statuses
+	changeSetStatusLock.Unlock()
+	return ret
+}
+
+// GetChangeSets to get apply results of change-sets in latest update
+func GetChangeSets() []*ChangeSetStatus {
+	changeSetStatusLock.RLock()
+	defer changeSetStatusLock.RUnlock()
+	ret := make([]*ChangeSetStatus, 0, len(changeSetStatus))
+	for _, s := range changeSetStatus {
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
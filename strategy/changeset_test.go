@@ -0,0 +1,148 @@
+package strategy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// latencySet to build a change-set of 3 related strategies
+func latencySet(version int64, pattern string) []*scheme.Strategy {
+	sts := make([]*scheme.Strategy, 0, 3)
+	for i, name := range []string{"api.count", "api.latency", "api.error_rate"} {
+		p := `cost=(\d+)`
+		if i == 1 {
+			p = pattern
+		}
+		sts = append(sts, &scheme.Strategy{
+			ID:               int64(i + 1),
+			Name:             fmt.Sprintf("%s.v%d", name, version),
+			FilePath:         "/home/app/log/api.log",
+			TimeFormat:       "yyyy-mm-dd HH:MM:SS",
+			Pattern:          p,
+			Interval:         60,
+			Degree:           1,
+			ChangeSet:        "api",
+			ChangeSetVersion: version,
+		})
+	}
+	return sts
+}
+
+// load to run the same steps as Update on the given payload
+func load(sts []*scheme.Strategy) {
+	parsePattern(sts)
+	updateRegs(sts)
+	UpdateGlobalStrategy(applyChangeSets(sts, GetAll()))
+}
+
+func TestChangeSet(t *testing.T) {
+	defer UpdateGlobalStrategy(nil)
+	standalone := func() *scheme.Strategy {
+		return &scheme.Strategy{ID: 100, FilePath: "/home/app/log/api.log", TimeFormat: "yyyy-mm-dd HH:MM:SS",
+			Pattern: "error", Interval: 60, Degree: 1}
+	}
+
+	load(append(latencySet(1, `cost=(\d+)`), standalone()))
+
+	// 观察者: 任意时刻看到的change-set成员都属于同一个版本
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var mixed []string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			sts, gen := GetAllWithGeneration()
+			versions := map[int64]bool{}
+			for _, st := range sts {
+				if st.ChangeSet == "api" {
+					versions[st.ChangeSetVersion] = true
+				}
+			}
+			if len(versions) > 1 {
+				mixed = append(mixed, fmt.Sprintf("gen %d: %v", gen, versions))
+			}
+		}
+	}()
+
+	// 一个成员的正则非法, 整组推迟, 独立的策略照常更新
+	gen := Generation()
+	bad := append(latencySet(2, `cost=(\d+`), standalone())
+	bad[3].Pattern = "fatal"
+	load(bad)
+	if Generation() != gen+1 {
+		t.Errorf("generation should bump once, got %d -> %d", gen, Generation())
+	}
+	for id := int64(1); id <= 3; id++ {
+		st, err := GetByID(id)
+		if err != nil || st.ChangeSetVersion != 1 {
+			t.Fatalf("member %d should stay at version 1: %+v %v", id, st, err)
+		}
+	}
+	if st, _ := GetByID(100); st.Pattern != "fatal" {
+		t.Errorf("standalone strategy should be updated independently: %+v", st)
+	}
+	sets := GetChangeSets()
+	if len(sets) != 1 || sets[0].Applied || sets[0].Version != 2 || sets[0].Active != 1 || len(sets[0].Rejections) != 3 {
+		t.Fatalf("unexpected change-set status: %+v", sets)
+	}
+	if r := sets[0].Rejections[1]; r.ID != 2 || r.Reason == "" {
+		t.Errorf("invalid member should have a reason: %+v", r)
+	}
+
+	// 修正后整组在同一代生效
+	gen = Generation()
+	load(append(latencySet(3, `cost=(\d+)ms`), standalone()))
+	if Generation() != gen+1 {
+		t.Errorf("generation should bump once, got %d -> %d", gen, Generation())
+	}
+	sts, _ := GetAllWithGeneration()
+	for id := int64(1); id <= 3; id++ {
+		if st := sts[id]; st == nil || st.ChangeSetVersion != 3 || !st.ParseSucc {
+			t.Fatalf("member %d should be at version 3: %+v", id, st)
+		}
+	}
+	if sets = GetChangeSets(); !sets[0].Applied || sets[0].Active != 3 || len(sets[0].Rejections) != 0 {
+		t.Errorf("unexpected change-set status: %+v", sets[0])
+	}
+
+	close(done)
+	wg.Wait()
+	if len(mixed) > 0 {
+		t.Errorf("workers observed mixed change-set versions: %v", mixed)
+	}
+}
+
+func TestStrategyGeneration(t *testing.T) {
+	defer UpdateGlobalStrategy(nil)
+	load := func(pattern string) *scheme.Strategy {
+		return &scheme.Strategy{ID: 1, Name: "gen", Pattern: pattern, Interval: 60, Func: "cnt", Degree: 1}
+	}
+
+	UpdateGlobalStrategy([]*scheme.Strategy{load("error")})
+	first, _ := GetByID(1)
+	if first.Generation != Generation() {
+		t.Fatalf("new strategy generation %d, want %d", first.Generation, Generation())
+	}
+
+	// 重新加载出的新对象, 定义不变时沿用原来的代数
+	UpdateGlobalStrategy([]*scheme.Strategy{load("error")})
+	same, _ := GetByID(1)
+	if same == first || same.Generation != first.Generation {
+		t.Fatalf("unchanged strategy generation %d, want %d", same.Generation, first.Generation)
+	}
+
+	UpdateGlobalStrategy([]*scheme.Strategy{load("error|fatal")})
+	changed, _ := GetByID(1)
+	if changed.Generation != Generation() || changed.Generation == first.Generation {
+		t.Fatalf("changed strategy generation %d, want %d", changed.Generation, Generation())
+	}
+}
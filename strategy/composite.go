@@ -0,0 +1,72 @@
+package strategy
+
+import (
+	"fmt"
+
+	"github.com/didi/falcon-log-agent/common/expr"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// validateComposites to compile composite strategies and link them to the referenced strategies
+// 组合策略不匹配日志, 文件取第一个引用的策略的文件, 推送时按该文件的进度判断周期是否结束
+func validateComposites(strategys []*scheme.Strategy) {
+	byID := make(map[int64]*scheme.Strategy, len(strategys))
+	for _, st := range strategys {
+		st.CompositeRefs = nil
+		byID[st.ID] = st
+	}
+	for _, st := range strategys {
+		if len(st.CompositeOf) == 0 {
+			continue
+		}
+		if member, ok := byID[st.CompositeOf[0]]; ok {
+			st.FilePath = member.FilePath
+		}
+		if err := compileComposite(st, byID); err != nil {
+			addStatus(st, "composite: "+err.Error())
+			continue
+		}
+		for _, id := range st.CompositeOf {
+			byID[id].CompositeRefs = append(byID[id].CompositeRefs, st.ID)
+		}
+		st.ParseSucc = true
+	}
+}
+
+func compileComposite(st *scheme.Strategy, byID map[int64]*scheme.Strategy) error {
+	if len(st.CompositeOf) < 2 {
+		return fmt.Errorf("composite_of needs at least 2 strategies")
+	}
+	seen := make(map[int64]bool, len(st.CompositeOf))
+	for _, id := range st.CompositeOf {
+		member, ok := byID[id]
+		switch {
+		case seen[id]:
+			return fmt.Errorf("strategy %d referenced twice", id)
+		case !ok:
+			return fmt.Errorf("strategy %d not found", id)
+		case len(member.CompositeOf) > 0:
+			return fmt.Errorf("strategy %d is also a composite", id)
+		case !member.ParseSucc:
+			return fmt.Errorf("strategy %d is invalid", id)
+		}
+		seen[id] = true
+	}
+	calc, err := expr.Parse(st.CompositeExpr)
+	if err != nil {
+		return fmt.Errorf("bad composite_expr %q: %v", st.CompositeExpr, err)
+	}
+	for _, id := range calc.Vars() {
+		if !seen[id] {
+			return fmt.Errorf("composite_expr references $%d which is not in composite_of", id)
+		}
+	}
+	if st.CompositeWindowSecs < 0 {
+		return fmt.Errorf("composite_window_secs %d is negative", st.CompositeWindowSecs)
+	}
+	if st.CompositeWindowSecs == 0 {
+		st.CompositeWindowSecs = int(st.Interval)
+	}
+	st.CompositeCalc = calc
+	return nil
+}
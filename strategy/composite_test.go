@@ -0,0 +1,59 @@
+package strategy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func compositeFixture(composite *scheme.Strategy) []*scheme.Strategy {
+	sts := []*scheme.Strategy{
+		{ID: 1, FilePath: "/tmp/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `start (\d+)`, Interval: 60},
+		{ID: 2, FilePath: "/tmp/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `done (\d+)`, Interval: 60},
+		{ID: 3, FilePath: "/tmp/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Interval: 60}, //没有pattern, 不可用
+	}
+	return append(sts, composite)
+}
+
+func TestValidateComposites(t *testing.T) {
+	st := &scheme.Strategy{ID: 10, CompositeOf: []int64{1, 2}, CompositeExpr: "$2 - $1", Interval: 60}
+	sts := compositeFixture(st)
+	updateRegs(sts)
+	if !st.ParseSucc || st.CompositeCalc == nil {
+		t.Fatalf("composite should be valid: %s", st.Status)
+	}
+	if st.FilePath != "/tmp/a.log" || st.CompositeWindowSecs != 60 {
+		t.Fatalf("unexpected file %q window %d", st.FilePath, st.CompositeWindowSecs)
+	}
+	if !reflect.DeepEqual(sts[0].CompositeRefs, []int64{10}) || !reflect.DeepEqual(sts[1].CompositeRefs, []int64{10}) {
+		t.Fatalf("members should reference composite: %v %v", sts[0].CompositeRefs, sts[1].CompositeRefs)
+	}
+
+	for _, c := range []struct {
+		of     []int64
+		expr   string
+		window int
+		status string
+	}{
+		{[]int64{1}, "$1", 0, "at least 2"},
+		{[]int64{1, 1}, "$1", 0, "referenced twice"},
+		{[]int64{1, 4}, "$1", 0, "not found"},
+		{[]int64{1, 3}, "$1", 0, "invalid"},
+		{[]int64{1, 10}, "$1", 0, "also a composite"},
+		{[]int64{1, 2}, "$1 -", 0, "bad composite_expr"},
+		{[]int64{1, 2}, "$2 - $5", 0, "not in composite_of"},
+		{[]int64{1, 2}, "$2 - $1", -1, "negative"},
+	} {
+		bad := &scheme.Strategy{ID: 11, CompositeOf: c.of, CompositeExpr: c.expr, CompositeWindowSecs: c.window, Interval: 60}
+		sts := append(compositeFixture(&scheme.Strategy{ID: 10, CompositeOf: []int64{1, 2}, CompositeExpr: "$1", Interval: 60}), bad)
+		updateRegs(sts)
+		if bad.ParseSucc || !strings.Contains(bad.Status, c.status) {
+			t.Errorf("composite_of %v expr %q: expect %q, got succ %v status %q", c.of, c.expr, c.status, bad.ParseSucc, bad.Status)
+		}
+		if len(sts[0].CompositeRefs) != 1 {
+			t.Errorf("invalid composite should not be referenced: %v", sts[0].CompositeRefs)
+		}
+	}
+}
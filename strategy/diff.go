@@ -0,0 +1,265 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// 变更影响的方面
+const (
+	ImpactMetricName = "metric_name" //推送的metric名变化, 旧的序列断掉
+	ImpactTags       = "tags"        //tag组合变化, 序列的划分不同
+	ImpactValue      = "value"       //取值的方式变化, 同一份日志算出的值不同
+)
+
+// diffIgnoredFields 加载时生成的字段, 不是配置, 不比较
+var diffIgnoredFields = map[string]bool{
+	"parse_succ":  true,
+	"status":      true,
+	"warnings":    true,
+	"regexp_size": true,
+	"generation":  true,
+}
+
+// diffImpacts 各字段变化影响的方面, 没有列出的(如step、file_path)不影响metric名、tag及取值
+var diffImpacts = map[string][]string{
+	"name":                 {ImpactMetricName},
+	"tags":                 {ImpactTags},
+	"tag_types":            {ImpactTags},
+	"tag_fields":           {ImpactTags},
+	"ordered_tag_extracts": {ImpactTags},
+	"template":             {ImpactValue, ImpactTags},
+	"tag_limits":           {ImpactTags},
+	"max_tag_sets":         {ImpactTags},
+	"endpoint_source":      {ImpactTags},
+	"max_endpoints":        {ImpactTags},
+	"pattern":              {ImpactValue},
+	"exclude":              {ImpactValue},
+	"must_not_contain":     {ImpactValue},
+	"mask_patterns":        {ImpactValue, ImpactTags},
+	"func":                 {ImpactValue},
+	"degree":               {ImpactValue},
+	"parse_mode":           {ImpactValue},
+	"value_field":          {ImpactValue},
+	"value_group":          {ImpactValue},
+	"value_map":            {ImpactValue},
+	"value_tier":           {ImpactTags},
+	"value_range":          {ImpactValue},
+	"value_round_decimals": {ImpactValue},
+	"pattern_reg_flags":    {ImpactValue},
+	"composite_of":         {ImpactValue},
+	"composite_expr":       {ImpactValue},
+	"variant":              {ImpactValue},
+	"variant_weight":       {ImpactValue},
+	"metric_type":          {ImpactValue},
+}
+
+// StrategyRef identifies a strategy added or removed
+// description/owner/runbook_url是策略中的说明字段, 便于判断变更找谁确认
+type StrategyRef struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	FilePath    string `json:"file_path"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	RunbookURL  string `json:"runbook_url,omitempty"`
+}
+
+func newStrategyRef(st *scheme.Strategy) StrategyRef {
+	return StrategyRef{
+		ID:          st.ID,
+		Name:        st.Name,
+		FilePath:    st.FilePath,
+		Description: st.Description,
+		Owner:       st.Owner,
+		RunbookURL:  st.RunbookURL,
+	}
+}
+
+// FieldChange is one changed field, old or new is nil when the field is not set on that side
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// StrategyDiff is the changes of one strategy existing in both configs
+type StrategyDiff struct {
+	StrategyRef
+	Changes []FieldChange `json:"changes"`
+	Impacts []string      `json:"impacts"` //为空表示不影响metric名、tag及取值
+}
+
+// DiffReport is the result of comparing two strategy configs, strategies are matched by id
+type DiffReport struct {
+	Added   []StrategyRef   `json:"added"`
+	Removed []StrategyRef   `json:"removed"`
+	Changed []*StrategyDiff `json:"changed"`
+}
+
+// DiffStrategyFiles to compare strategies in two config files
+func DiffStrategyFiles(oldFile, newFile string) (*DiffReport, error) {
+	olds, err := readStrategyFile(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	news, err := readStrategyFile(newFile)
+	if err != nil {
+		return nil, err
+	}
+	return DiffStrategies(olds, news)
+}
+
+// readStrategyFile to decode a strategy config file without logging, for command line tools
+func readStrategyFile(file string) ([]*scheme.Strategy, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var sts []*scheme.Strategy
+	if err := json.Unmarshal(bs, &sts); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", file, err)
+	}
+	return sts, nil
+}
+
+// DiffStrategies to compare two lists of strategies
+// pattern中用```EXCLUDE```分隔的写法先拆开, 与分开写pattern、exclude的视为相同
+func DiffStrategies(olds, news []*scheme.Strategy) (*DiffReport, error) {
+	parsePattern(olds)
+	parsePattern(news)
+	oldMap, err := strategyFieldsByID(olds)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := strategyFieldsByID(news)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &DiffReport{Added: []StrategyRef{}, Removed: []StrategyRef{}, Changed: []*StrategyDiff{}}
+	for _, st := range news {
+		if _, ok := oldMap[st.ID]; !ok {
+			ret.Added = append(ret.Added, newStrategyRef(st))
+		}
+	}
+	newByID := make(map[int64]*scheme.Strategy, len(news))
+	for _, st := range news {
+		newByID[st.ID] = st
+	}
+	for _, st := range olds {
+		newFields, ok := newMap[st.ID]
+		if !ok {
+			ret.Removed = append(ret.Removed, newStrategyRef(st))
+			continue
+		}
+		if d := diffFields(oldMap[st.ID], newFields); len(d.Changes) > 0 {
+			//名字、文件按旧策略, 说明字段按新策略
+			d.StrategyRef = newStrategyRef(newByID[st.ID])
+			d.Name, d.FilePath = st.Name, st.FilePath
+			ret.Changed = append(ret.Changed, d)
+		}
+	}
+	sort.Slice(ret.Added, func(i, j int) bool { return ret.Added[i].ID < ret.Added[j].ID })
+	sort.Slice(ret.Removed, func(i, j int) bool { return ret.Removed[i].ID < ret.Removed[j].ID })
+	sort.Slice(ret.Changed, func(i, j int) bool { return ret.Changed[i].ID < ret.Changed[j].ID })
+	return ret, nil
+}
+
+// strategyFieldsByID to convert strategies to their json fields, a later duplicated id is rejected
+func strategyFieldsByID(sts []*scheme.Strategy) (map[int64]map[string]interface{}, error) {
+	ret := make(map[int64]map[string]interface{}, len(sts))
+	for _, st := range sts {
+		if _, ok := ret[st.ID]; ok {
+			return nil, fmt.Errorf("reduplicated strategy id %d", st.ID)
+		}
+		bs, err := json.Marshal(st)
+		if err != nil {
+			return nil, fmt.Errorf("encode strategy %d: %v", st.ID, err)
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(bs, &fields); err != nil {
+			return nil, fmt.Errorf("decode strategy %d: %v", st.ID, err)
+		}
+		ret[st.ID] = fields
+	}
+	return ret, nil
+}
+
+// diffFields to compare fields of one strategy, changes are sorted by field
+func diffFields(olds, news map[string]interface{}) *StrategyDiff {
+	keys := make(map[string]bool, len(olds)+len(news))
+	for k := range olds {
+		keys[k] = true
+	}
+	for k := range news {
+		keys[k] = true
+	}
+	fields := make([]string, 0, len(keys))
+	for k := range keys {
+		if !diffIgnoredFields[k] {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	d := &StrategyDiff{Changes: []FieldChange{}, Impacts: []string{}}
+	impacts := make(map[string]bool)
+	for _, f := range fields {
+		o, n := olds[f], news[f]
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		d.Changes = append(d.Changes, FieldChange{Field: f, Old: o, New: n})
+		for _, i := range diffImpacts[f] {
+			impacts[i] = true
+		}
+	}
+	for _, i := range []string{ImpactMetricName, ImpactTags, ImpactValue} {
+		if impacts[i] {
+			d.Impacts = append(d.Impacts, i)
+		}
+	}
+	return d
+}
+
+// WriteSummary to write a human-readable summary of the report
+func (r *DiffReport) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "added: %d, removed: %d, changed: %d\n", len(r.Added), len(r.Removed), len(r.Changed))
+	for _, s := range r.Added {
+		fmt.Fprintf(w, "+ [%d] %s (%s)\n", s.ID, s.Name, s.FilePath)
+		s.writeDoc(w)
+	}
+	for _, s := range r.Removed {
+		fmt.Fprintf(w, "- [%d] %s (%s)\n", s.ID, s.Name, s.FilePath)
+		s.writeDoc(w)
+	}
+	for _, d := range r.Changed {
+		fields := make([]string, 0, len(d.Changes))
+		for _, c := range d.Changes {
+			fields = append(fields, c.Field)
+		}
+		impact := "no metric impact"
+		if len(d.Impacts) > 0 {
+			impact = "affects " + strings.Join(d.Impacts, ", ")
+		}
+		fmt.Fprintf(w, "~ [%d] %s (%s): %s [%s]\n", d.ID, d.Name, d.FilePath, strings.Join(fields, ", "), impact)
+		d.writeDoc(w)
+	}
+}
+
+// writeDoc to write the documentation fields set, one indented line each
+func (s StrategyRef) writeDoc(w io.Writer) {
+	for _, f := range [][2]string{{"description", s.Description}, {"owner", s.Owner}, {"runbook", s.RunbookURL}} {
+		if f[1] != "" {
+			fmt.Fprintf(w, "    %s: %s\n", f[0], f[1])
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package strategy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+const diffOld = `[
+	{"id": 1, "name": "api.cost", "file_path": "/var/log/api.log", "pattern": "cost=(\\d+)` + "```EXCLUDE```" + `healthcheck", "func": "avg", "step": 60, "tags": {"path": "path=(\\S+)"}},
+	{"id": 2, "name": "api.err", "file_path": "/var/log/api.log", "pattern": "ERROR", "func": "cnt", "step": 60},
+	{"id": 3, "name": "api.slow", "file_path": "/var/log/api.log", "pattern": "slow", "func": "cnt", "step": 60},
+	{"id": 4, "name": "api.total", "file_path": "/var/log/api.log", "pattern": "GET", "func": "cnt", "step": 60}
+]`
+
+const diffNew = `[
+	{"id": 1, "name": "api.cost", "file_path": "/var/log/api.log", "pattern": "cost=(\\d+)", "exclude": "healthcheck", "func": "avg", "step": 60, "tags": {"path": "path=(\\S+)"}},
+	{"id": 2, "name": "api.error", "file_path": "/var/log/api.log", "pattern": "ERROR", "func": "cnt", "step": 30, "tags": {"code": "code=(\\d+)"}},
+	{"id": 4, "name": "api.total", "file_path": "/var/log/api.log", "pattern": "GET|POST", "func": "cnt", "step": 30},
+	{"id": 5, "name": "api.timeout", "file_path": "/var/log/api.log", "pattern": "timeout", "func": "cnt", "step": 60}
+]`
+
+func TestDiffStrategyFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "diff")
+	defer os.RemoveAll(dir)
+	oldFile, newFile := filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json")
+	ioutil.WriteFile(oldFile, []byte(diffOld), 0644)
+	ioutil.WriteFile(newFile, []byte(diffNew), 0644)
+
+	r, err := DiffStrategyFiles(oldFile, newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added) != 1 || r.Added[0].ID != 5 || r.Added[0].Name != "api.timeout" {
+		t.Errorf("unexpected added: %+v", r.Added)
+	}
+	if len(r.Removed) != 1 || r.Removed[0].ID != 3 {
+		t.Errorf("unexpected removed: %+v", r.Removed)
+	}
+	// 1只是把```EXCLUDE```拆开写, 不算变化
+	if len(r.Changed) != 2 || r.Changed[0].ID != 2 || r.Changed[1].ID != 4 {
+		t.Fatalf("unexpected changed: %+v", r.Changed)
+	}
+
+	c := r.Changed[0]
+	var fields []string
+	for _, f := range c.Changes {
+		fields = append(fields, f.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"name", "step", "tags"}) {
+		t.Errorf("unexpected changed fields: %v", fields)
+	}
+	if c.Changes[0].Old != "api.err" || c.Changes[0].New != "api.error" {
+		t.Errorf("unexpected name change: %+v", c.Changes[0])
+	}
+	if !reflect.DeepEqual(c.Impacts, []string{ImpactMetricName, ImpactTags}) {
+		t.Errorf("unexpected impacts: %v", c.Impacts)
+	}
+	if !reflect.DeepEqual(r.Changed[1].Impacts, []string{ImpactValue}) {
+		t.Errorf("unexpected impacts: %v", r.Changed[1].Impacts)
+	}
+
+	var buf bytes.Buffer
+	r.WriteSummary(&buf)
+	summary := buf.String()
+	for _, want := range []string{
+		"added: 1, removed: 1, changed: 2\n",
+		"+ [5] api.timeout (/var/log/api.log)\n",
+		"- [3] api.slow (/var/log/api.log)\n",
+		"~ [2] api.err (/var/log/api.log): name, step, tags [affects metric_name, tags]\n",
+		"~ [4] api.total (/var/log/api.log): pattern, step [affects value]\n",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary should contain %q, got:\n%s", want, summary)
+		}
+	}
+}
+
+func TestDiffStrategiesNoImpact(t *testing.T) {
+	olds := readStrategyFileContent(t, `[{"id": 1, "name": "a", "file_path": "/var/log/a.log", "pattern": "x", "step": 60}]`)
+	news := readStrategyFileContent(t, `[{"id": 1, "name": "a", "file_path": "/var/log/b.log", "pattern": "x", "step": 60, "comment": "moved"}]`)
+	r, err := DiffStrategies(olds, news)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Changed) != 1 || len(r.Changed[0].Impacts) != 0 || len(r.Changed[0].Changes) != 2 {
+		t.Fatalf("unexpected diff: %+v", r.Changed)
+	}
+
+	// 重复的id无法对应
+	dup := readStrategyFileContent(t, `[{"id": 1, "name": "a"}, {"id": 1, "name": "b"}]`)
+	if _, err := DiffStrategies(olds, dup); err == nil {
+		t.Error("duplicated id should be rejected")
+	}
+}
+
+func TestDiffStrategiesDoc(t *testing.T) {
+	olds := readStrategyFileContent(t, `[
+		{"id": 1, "name": "a", "pattern": "x", "step": 60, "owner": "team-a"},
+		{"id": 2, "name": "b", "pattern": "y", "step": 60, "description": "retired metric", "runbook_url": "https://wiki/b"}
+	]`)
+	news := readStrategyFileContent(t, `[
+		{"id": 1, "name": "a", "pattern": "x", "step": 60, "owner": "team-b", "description": "errors of a"},
+		{"id": 3, "name": "c", "pattern": "z", "step": 60}
+	]`)
+	r, err := DiffStrategies(olds, news)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 说明字段的变化不影响metric, 条目带上新配置中的值
+	if len(r.Changed) != 1 || len(r.Changed[0].Changes) != 2 || len(r.Changed[0].Impacts) != 0 {
+		t.Fatalf("unexpected diff: %+v", r.Changed)
+	}
+	if c := r.Changed[0]; c.Owner != "team-b" || c.Description != "errors of a" {
+		t.Errorf("changed strategy should carry the new documentation: %+v", c.StrategyRef)
+	}
+	if rm := r.Removed[0]; rm.Description != "retired metric" || rm.RunbookURL != "https://wiki/b" {
+		t.Errorf("removed strategy should carry its documentation: %+v", rm)
+	}
+
+	var buf bytes.Buffer
+	r.WriteSummary(&buf)
+	for _, want := range []string{
+		"~ [1] a (): description, owner [no metric impact]\n    description: errors of a\n    owner: team-b\n",
+		"- [2] b ()\n    description: retired metric\n    runbook: https://wiki/b\n",
+		"+ [3] c ()\n- [2]",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary should contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func readStrategyFileContent(t *testing.T, content string) []*scheme.Strategy {
+	f, _ := ioutil.TempFile("", "diff")
+	defer os.Remove(f.Name())
+	f.WriteString(content)
+	f.Close()
+	sts, err := readStrategyFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sts
+}
@@ -12,8 +12,12 @@ import (
 	"github.com/didi/falcon-log-agent/common/scheme"
 )
 
-// 如果有folder，将屏蔽单个配置文件
+// 如果有folder，将屏蔽单个配置文件; 配置了strategy.etcd时只从etcd加载
 func GetAllStrategies() ([]*scheme.Strategy, error) {
+	if etcdConfigured() {
+		return getEtcdStrategy()
+	}
+
 	if g.StrategyFolder != "" {
 		sts, err := getFolderStrategy()
 		return sts, err
@@ -0,0 +1,566 @@
+package strategy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+const (
+	defaultEtcdPrefix    = "/falcon-log-agent/strategies/"
+	defaultEtcdTimeoutMs = 3000
+	defaultEtcdLeaseTTL  = 10
+	etcdRetryInterval    = time.Second
+)
+
+// errEtcdCompacted 监听的版本已被压缩, 需要重新全量加载
+var errEtcdCompacted = errors.New("etcd watch revision compacted")
+
+// etcdHeader/etcdKV/etcdEvent are the json of the etcd v3 grpc gateway, only the fields used
+// key、value为base64, int64为字符串
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type etcdKV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdEvent struct {
+	Type string  `json:"type"` //PUT为默认值, 不输出
+	KV   *etcdKV `json:"kv"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader `json:"header"`
+	KVs    []*etcdKV  `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result *struct {
+		Header          etcdHeader   `json:"header"`
+		Created         bool         `json:"created"`
+		Canceled        bool         `json:"canceled"`
+		CompactRevision int64        `json:"compact_revision,string"`
+		Events          []*etcdEvent `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type etcdLeaseResponse struct {
+	ID int64 `json:"ID,string"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// EtcdLoader to load strategies from the keys under a prefix of etcd
+// 只使用etcd v3的grpc gateway(/v3/kv/range、/v3/watch、/v3/lease/*、/v3/kv/txn), 不依赖etcd的客户端库;
+// 每个key的value为一个策略或策略数组, 启动时全量加载, 之后监听变化并立即触发一轮策略更新
+type EtcdLoader struct {
+	endpoints []string
+	prefix    string
+	client    *http.Client
+	stream    *http.Client //watch是长连接, 不设置超时
+
+	// 开启选主后, 更新策略前先抢到electionKey的租约锁, 多个agent依次热加载
+	electionKey string
+	leaseTTL    int
+
+	// reload 触发一轮策略更新并等待完成, 测试中替换
+	reload func() bool
+
+	lock     sync.RWMutex
+	next     int               //当前使用的endpoint
+	values   map[string][]byte //已校验过能解析的value, 取策略时再解析, 每次得到新的策略对象
+	revision int64
+	loaded   bool
+	lastErr  string
+}
+
+// EtcdLoaderStat is the state of the etcd loader
+type EtcdLoaderStat struct {
+	Prefix   string `json:"prefix"`
+	Keys     int    `json:"keys"`
+	Revision int64  `json:"revision"`
+	Error    string `json:"error,omitempty"` //最近一次加载或监听的错误
+}
+
+// NewEtcdLoader to create a loader of the strategies under the prefix
+func NewEtcdLoader(endpoints []string, prefix string, timeout time.Duration) *EtcdLoader {
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+	eps := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = "http://" + ep
+		}
+		eps = append(eps, strings.TrimSuffix(ep, "/"))
+	}
+	l := &EtcdLoader{
+		endpoints: eps,
+		prefix:    prefix,
+		client:    &http.Client{Timeout: timeout},
+		stream:    &http.Client{},
+		values:    make(map[string][]byte),
+	}
+	l.reload = func() bool { return RequestReload(timeout + reloadWaitTimeout) }
+	return l
+}
+
+// EnableElection to hot-reload only when holding the lease lock of the key
+// 租约在本轮更新完成后撤销; agent异常退出时ttl秒后自动释放, ttl应大于一轮策略更新的耗时
+func (l *EtcdLoader) EnableElection(key string, ttl int) {
+	if key == "" {
+		key = strings.TrimSuffix(l.prefix, "/") + ".lock"
+	}
+	if ttl <= 0 {
+		ttl = defaultEtcdLeaseTTL
+	}
+	l.electionKey = key
+	l.leaseTTL = ttl
+}
+
+// post to call the etcd gateway, switch to the next endpoint when failed
+func (l *EtcdLoader) post(ctx context.Context, client *http.Client, path string, req interface{}) (*http.Response, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for i := 0; i < len(l.endpoints); i++ {
+		l.lock.RLock()
+		ep := l.endpoints[(l.next+i)%len(l.endpoints)]
+		l.lock.RUnlock()
+		r, err := http.NewRequest(http.MethodPost, ep+path, bytes.NewReader(bs))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(r.WithContext(ctx))
+		if err == nil && resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			err = fmt.Errorf("etcd %s returns %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		if err == nil {
+			if i > 0 {
+				l.lock.Lock()
+				l.next = (l.next + i) % len(l.endpoints)
+				l.lock.Unlock()
+			}
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+		dlog.Warningf("request etcd failed [endpoint:%s][path:%s][err:%v]", ep, path, err)
+	}
+	return nil, lastErr
+}
+
+// call to post a request and decode the whole response
+func (l *EtcdLoader) call(path string, req, resp interface{}) error {
+	r, err := l.post(context.Background(), l.client, path, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// prefixEnd to get the range end of all keys with the prefix, same as clientv3.GetPrefixRangeEnd
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// decodeEtcdValue to decode a strategy or an array of strategies
+func decodeEtcdValue(value []byte) ([]*scheme.Strategy, error) {
+	value = bytes.TrimSpace(value)
+	if bytes.HasPrefix(value, []byte("[")) {
+		var sts []*scheme.Strategy
+		err := json.Unmarshal(value, &sts)
+		return sts, err
+	}
+	st := new(scheme.Strategy)
+	if err := json.Unmarshal(value, st); err != nil {
+		return nil, err
+	}
+	return []*scheme.Strategy{st}, nil
+}
+
+// setErr to record the last error, empty to clear
+func (l *EtcdLoader) setErr(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err == nil {
+		l.lastErr = ""
+		return
+	}
+	l.lastErr = err.Error()
+}
+
+// Load to list all keys under the prefix, replacing the loaded strategies
+// 无法解析的key跳过并记录错误, 不影响其他key
+func (l *EtcdLoader) Load() error {
+	var resp etcdRangeResponse
+	req := map[string]interface{}{"key": []byte(l.prefix), "range_end": prefixEnd(l.prefix)}
+	if err := l.call("/v3/kv/range", req, &resp); err != nil {
+		l.setErr(err)
+		return err
+	}
+
+	values := make(map[string][]byte, len(resp.KVs))
+	var badErr error
+	for _, kv := range resp.KVs {
+		if _, err := decodeEtcdValue(kv.Value); err != nil {
+			badErr = fmt.Errorf("decode strategy of key %s failed: %v", kv.Key, err)
+			dlog.Errorf("%v", badErr)
+			continue
+		}
+		values[string(kv.Key)] = kv.Value
+	}
+
+	l.lock.Lock()
+	l.values = values
+	l.revision = resp.Header.Revision
+	l.loaded = true
+	l.lock.Unlock()
+	l.setErr(badErr)
+	dlog.Infof("load strategies from etcd success [prefix:%s][keys:%d][revision:%d]", l.prefix, len(values), resp.Header.Revision)
+	return nil
+}
+
+// applyEvents to apply watch events to the loaded strategies, returns whether anything changed
+// 无法解析的PUT保留该key之前的策略, 避免写错一个值导致策略被删除
+func (l *EtcdLoader) applyEvents(events []*etcdEvent, revision int64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	changed := false
+	for _, e := range events {
+		if e.KV == nil {
+			continue
+		}
+		key := string(e.KV.Key)
+		if e.KV.ModRevision > l.revision {
+			l.revision = e.KV.ModRevision
+		}
+		if e.Type == "DELETE" {
+			if _, ok := l.values[key]; ok {
+				delete(l.values, key)
+				changed = true
+			}
+			dlog.Infof("strategy key deleted from etcd [key:%s]", key)
+			continue
+		}
+		sts, err := decodeEtcdValue(e.KV.Value)
+		if err != nil {
+			l.lastErr = fmt.Sprintf("decode strategy of key %s failed: %v", key, err)
+			dlog.Errorf("%s, keep the previous value", l.lastErr)
+			continue
+		}
+		l.values[key] = e.KV.Value
+		changed = true
+		dlog.Infof("strategy key updated in etcd [key:%s][num:%d]", key, len(sts))
+	}
+	if revision > l.revision {
+		l.revision = revision
+	}
+	return changed
+}
+
+// Watch to watch the prefix from the loaded revision until the stream breaks or stop is closed
+// 每个事件批次更新后立即触发一轮策略更新
+func (l *EtcdLoader) Watch(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	l.lock.RLock()
+	start := l.revision + 1
+	l.lock.RUnlock()
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(l.prefix),
+			"range_end":      prefixEnd(l.prefix),
+			"start_revision": start,
+		},
+	}
+	r, err := l.post(ctx, l.stream, "/v3/watch", req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+	for {
+		var resp etcdWatchResponse
+		if err := dec.Decode(&resp); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("etcd watch error: %s", resp.Error.Message)
+		}
+		res := resp.Result
+		if res == nil {
+			continue
+		}
+		if res.CompactRevision > 0 {
+			return errEtcdCompacted
+		}
+		if res.Canceled {
+			return fmt.Errorf("etcd watch canceled")
+		}
+		if len(res.Events) == 0 {
+			continue
+		}
+		if l.applyEvents(res.Events, res.Header.Revision) {
+			l.apply(stop)
+		}
+	}
+}
+
+// Run to load and watch the prefix until stop is closed, retrying on errors
+func (l *EtcdLoader) Run(stop <-chan struct{}) {
+	needLoad := true
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var err error
+		if needLoad {
+			if err = l.Load(); err == nil {
+				needLoad = false
+				l.apply(stop)
+			}
+		} else {
+			err = l.Watch(stop)
+			if err == errEtcdCompacted {
+				needLoad = true
+			}
+		}
+		if err != nil {
+			dlog.Errorf("etcd strategy loader failed, retry later [prefix:%s][err:%v]", l.prefix, err)
+			l.setErr(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(etcdRetryInterval):
+		}
+	}
+}
+
+// apply to trigger an update of strategies, holding the election lock if enabled
+// 抢锁时etcd出错则不等待直接更新, 宁可同时热加载也不阻塞策略更新
+func (l *EtcdLoader) apply(stop <-chan struct{}) {
+	if l.electionKey == "" {
+		l.reload()
+		return
+	}
+	lease, err := l.acquire(stop)
+	if err != nil {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		dlog.Warningf("acquire etcd election lock failed, reload without it [key:%s][err:%v]", l.electionKey, err)
+		l.reload()
+		return
+	}
+	dlog.Infof("etcd election lock acquired, reload strategies [key:%s][lease:%d]", l.electionKey, lease)
+	l.reload()
+	l.release(lease)
+}
+
+// acquire to wait for the election lock, returns the lease holding it
+// 每次尝试都申请新的租约, 等待期间租约不会过期; 没抢到就撤销
+func (l *EtcdLoader) acquire(stop <-chan struct{}) (int64, error) {
+	hostname, _ := utils.LocalHostname()
+	for {
+		var lease etcdLeaseResponse
+		if err := l.call("/v3/lease/grant", map[string]interface{}{"TTL": l.leaseTTL}, &lease); err != nil {
+			return 0, err
+		}
+		var txn etcdTxnResponse
+		req := map[string]interface{}{
+			"compare": []interface{}{map[string]interface{}{
+				"key":             []byte(l.electionKey),
+				"result":          "EQUAL",
+				"target":          "CREATE",
+				"create_revision": 0,
+			}},
+			"success": []interface{}{map[string]interface{}{
+				"request_put": map[string]interface{}{
+					"key":   []byte(l.electionKey),
+					"value": []byte(hostname),
+					"lease": lease.ID,
+				},
+			}},
+		}
+		if err := l.call("/v3/kv/txn", req, &txn); err != nil {
+			l.release(lease.ID)
+			return 0, err
+		}
+		if txn.Succeeded {
+			return lease.ID, nil
+		}
+		l.release(lease.ID)
+		dlog.Debugf("etcd election lock is held by another agent, wait [key:%s]", l.electionKey)
+		select {
+		case <-stop:
+			return 0, fmt.Errorf("stopped")
+		case <-time.After(etcdRetryInterval):
+		}
+	}
+}
+
+// release to revoke the lease, the key attached is deleted with it
+func (l *EtcdLoader) release(lease int64) {
+	var resp map[string]interface{}
+	if err := l.call("/v3/lease/revoke", map[string]interface{}{"ID": lease}, &resp); err != nil {
+		dlog.Warningf("revoke etcd lease failed, released after ttl [lease:%d][err:%v]", lease, err)
+	}
+}
+
+// Strategies to get the loaded strategies ordered by key, a later duplicated id is dropped
+func (l *EtcdLoader) Strategies() ([]*scheme.Strategy, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if !l.loaded {
+		return nil, fmt.Errorf("strategies not loaded from etcd yet [prefix:%s][err:%s]", l.prefix, l.lastErr)
+	}
+	keys := make([]string, 0, len(l.values))
+	for k := range l.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]*scheme.Strategy, 0)
+	seen := make(map[int64]string)
+	for _, k := range keys {
+		sts, _ := decodeEtcdValue(l.values[k])
+		for _, st := range sts {
+			if other, ok := seen[st.ID]; ok {
+				dlog.Errorf("reduplicated strategy ID : [%d] in key [%s] and [%s], will drop the latter", st.ID, other, k)
+				continue
+			}
+			seen[st.ID] = k
+			ret = append(ret, st)
+		}
+	}
+	return ret, nil
+}
+
+// Stat to get the state of the loader
+func (l *EtcdLoader) Stat() EtcdLoaderStat {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return EtcdLoaderStat{Prefix: l.prefix, Keys: len(l.values), Revision: l.revision, Error: l.lastErr}
+}
+
+var (
+	etcdLoader     *EtcdLoader
+	etcdLoaderLock = new(sync.RWMutex)
+)
+
+// etcdConfigured to check whether strategies are loaded from etcd
+func etcdConfigured() bool {
+	return g.Conf() != nil && len(g.Conf().Strategy.Etcd.Endpoints) > 0
+}
+
+// newEtcdLoaderByConf to create the loader by strategy.etcd
+func newEtcdLoaderByConf() *EtcdLoader {
+	c := g.Conf().Strategy.Etcd
+	timeout := c.TimeoutMs
+	if timeout <= 0 {
+		timeout = defaultEtcdTimeoutMs
+	}
+	l := NewEtcdLoader(c.Endpoints, c.Prefix, time.Duration(timeout)*time.Millisecond)
+	if c.Election {
+		l.EnableElection(c.ElectionKey, c.LeaseTTL)
+	}
+	return l
+}
+
+// StartEtcdLoader to load and watch strategies from etcd if strategy.etcd.endpoints is configured
+func StartEtcdLoader() {
+	if !etcdConfigured() {
+		return
+	}
+	l := newEtcdLoaderByConf()
+	etcdLoaderLock.Lock()
+	etcdLoader = l
+	etcdLoaderLock.Unlock()
+	dlog.Infof("load strategies from etcd [endpoints:%v][prefix:%s][election:%s]", l.endpoints, l.prefix, l.electionKey)
+	go l.Run(make(chan struct{}))
+}
+
+// GetEtcdLoaderStat to get the state of the etcd loader, nil if not started
+func GetEtcdLoaderStat() *EtcdLoaderStat {
+	etcdLoaderLock.RLock()
+	l := etcdLoader
+	etcdLoaderLock.RUnlock()
+	if l == nil {
+		return nil
+	}
+	st := l.Stat()
+	return &st
+}
+
+// getEtcdStrategy to get strategies from the etcd loader
+// 没有启动loader时(如-check)直接全量加载一次
+func getEtcdStrategy() ([]*scheme.Strategy, error) {
+	etcdLoaderLock.RLock()
+	l := etcdLoader
+	etcdLoaderLock.RUnlock()
+	if l == nil {
+		l = newEtcdLoaderByConf()
+		if err := l.Load(); err != nil {
+			return nil, err
+		}
+	}
+	return l.Strategies()
+}
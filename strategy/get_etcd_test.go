@@ -0,0 +1,327 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEtcd is an in-memory etcd v3 grpc gateway with only the apis used by EtcdLoader
+type fakeEtcd struct {
+	mu        sync.Mutex
+	kvs       map[string]string
+	leases    map[int64]string //租约及绑定的key
+	rev       int64
+	nextLease int64
+	watchers  map[chan []byte]bool
+}
+
+func newFakeEtcd(t *testing.T) (*fakeEtcd, *httptest.Server) {
+	f := &fakeEtcd{kvs: make(map[string]string), leases: make(map[int64]string), watchers: make(map[chan []byte]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/kv/range", f.handleRange)
+	mux.HandleFunc("/v3/watch", f.handleWatch)
+	mux.HandleFunc("/v3/lease/grant", f.handleGrant)
+	mux.HandleFunc("/v3/lease/revoke", f.handleRevoke)
+	mux.HandleFunc("/v3/kv/txn", f.handleTxn)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func fakeKV(key, value string, rev int64) map[string]interface{} {
+	return map[string]interface{}{"key": []byte(key), "value": []byte(value), "mod_revision": fmt.Sprint(rev)}
+}
+
+// notifyLocked to send an event to all watchers, must be called with mu held
+func (f *fakeEtcd) notifyLocked(typ, key, value string) {
+	e := map[string]interface{}{"kv": fakeKV(key, value, f.rev)}
+	if typ != "" {
+		e["type"] = typ
+	}
+	bs, _ := json.Marshal(map[string]interface{}{"result": map[string]interface{}{
+		"header": map[string]string{"revision": fmt.Sprint(f.rev)},
+		"events": []interface{}{e},
+	}})
+	for c := range f.watchers {
+		c <- bs
+	}
+}
+
+func (f *fakeEtcd) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	f.kvs[key] = value
+	f.notifyLocked("", key, value)
+}
+
+func (f *fakeEtcd) del(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	delete(f.kvs, key)
+	f.notifyLocked("DELETE", key, "")
+}
+
+func (f *fakeEtcd) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.kvs[key]
+	return v, ok
+}
+
+func (f *fakeEtcd) handleRange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0)
+	for k := range f.kvs {
+		if k >= string(req.Key) && k < string(req.RangeEnd) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	kvs := make([]interface{}, 0)
+	for _, k := range keys {
+		kvs = append(kvs, fakeKV(k, f.kvs[k], f.rev))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"header": map[string]string{"revision": fmt.Sprint(f.rev)}, "kvs": kvs})
+}
+
+// handleWatch 只推送之后的事件, 足够测试使用
+func (f *fakeEtcd) handleWatch(w http.ResponseWriter, r *http.Request) {
+	c := make(chan []byte, 16)
+	f.mu.Lock()
+	f.watchers[c] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.watchers, c)
+		f.mu.Unlock()
+	}()
+	fmt.Fprintln(w, `{"result":{"header":{"revision":"1"},"created":true}}`)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case bs := <-c:
+			w.Write(append(bs, '\n'))
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeEtcd) handleGrant(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.nextLease++
+	id := f.nextLease
+	f.leases[id] = ""
+	f.mu.Unlock()
+	fmt.Fprintf(w, `{"ID":"%d","TTL":"10"}`, id)
+}
+
+func (f *fakeEtcd) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int64 `json:"ID"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	if key := f.leases[req.ID]; key != "" {
+		delete(f.kvs, key)
+	}
+	delete(f.leases, req.ID)
+	f.mu.Unlock()
+	fmt.Fprintln(w, `{}`)
+}
+
+func (f *fakeEtcd) handleTxn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Compare []struct {
+			Key []byte `json:"key"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut struct {
+				Key   []byte `json:"key"`
+				Value []byte `json:"value"`
+				Lease int64  `json:"lease"`
+			} `json:"request_put"`
+		} `json:"success"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.kvs[string(req.Compare[0].Key)]; ok {
+		fmt.Fprintln(w, `{}`)
+		return
+	}
+	put := req.Success[0].RequestPut
+	f.kvs[string(put.Key)] = string(put.Value)
+	f.leases[put.Lease] = string(put.Key)
+	fmt.Fprintln(w, `{"succeeded":true}`)
+}
+
+// waitReload to wait for a reload triggered by the loader
+func waitReload(t *testing.T, reloads chan struct{}) {
+	t.Helper()
+	select {
+	case <-reloads:
+	case <-time.After(3 * time.Second):
+		t.Fatal("reload not triggered")
+	}
+}
+
+func loadedIDs(t *testing.T, l *EtcdLoader) []int64 {
+	t.Helper()
+	sts, err := l.Strategies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0, len(sts))
+	for _, st := range sts {
+		ids = append(ids, st.ID)
+	}
+	return ids
+}
+
+func TestEtcdLoaderLoadAndWatch(t *testing.T) {
+	f, srv := newFakeEtcd(t)
+	f.put("/s/a", `{"id":1,"name":"a","file_path":"/tmp/a.log"}`)
+	f.put("/s/b", `[{"id":2,"name":"b"},{"id":3,"name":"c"}]`)
+	f.put("/s/bad", `{"id":`)
+	f.put("/s/dup", `{"id":1,"name":"dup"}`)
+	f.put("/t/other", `{"id":9}`)
+
+	// 第一个endpoint不可用时换下一个
+	l := NewEtcdLoader([]string{"127.0.0.1:1", srv.URL}, "/s/", time.Second)
+	if _, err := l.Strategies(); err == nil {
+		t.Fatal("strategies should not be available before loaded")
+	}
+	reloads := make(chan struct{}, 16)
+	l.reload = func() bool { reloads <- struct{}{}; return true }
+	stop := make(chan struct{})
+	defer close(stop)
+	go l.Run(stop)
+
+	waitReload(t, reloads)
+	if ids := loadedIDs(t, l); !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected strategies after load: %v", ids)
+	}
+	if st := l.Stat(); st.Keys != 3 || st.Error == "" {
+		t.Errorf("bad key should be skipped and reported: %+v", st)
+	}
+
+	// 等watch建立后再修改
+	for i := 0; i < 100; i++ {
+		f.mu.Lock()
+		n := len(f.watchers)
+		f.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f.put("/s/b", `{"id":4,"name":"d"}`)
+	waitReload(t, reloads)
+	if ids := loadedIDs(t, l); !reflect.DeepEqual(ids, []int64{1, 4}) {
+		t.Errorf("unexpected strategies after put: %v", ids)
+	}
+
+	f.del("/s/a")
+	waitReload(t, reloads)
+	if ids := loadedIDs(t, l); !reflect.DeepEqual(ids, []int64{4, 1}) {
+		t.Errorf("unexpected strategies after delete: %v", ids)
+	}
+
+	// 写错的值保留之前的策略, 也不触发更新
+	f.put("/s/b", `not json`)
+	f.put("/s/e", `{"id":5}`)
+	waitReload(t, reloads)
+	if ids := loadedIDs(t, l); !reflect.DeepEqual(ids, []int64{4, 1, 5}) {
+		t.Errorf("bad value should keep the previous strategies: %v", ids)
+	}
+	if st := l.Stat(); st.Revision != 9 {
+		t.Errorf("unexpected revision %+v", st)
+	}
+
+	// 每次得到新的策略对象
+	a, _ := l.Strategies()
+	b, _ := l.Strategies()
+	if a[0] == b[0] {
+		t.Error("strategies should be decoded every time")
+	}
+}
+
+func TestEtcdLoaderElection(t *testing.T) {
+	f, srv := newFakeEtcd(t)
+	f.put("/s/a", `{"id":1}`)
+
+	var active, maxActive, total int32
+	reloads := make(chan struct{}, 16)
+	stop := make(chan struct{})
+	defer close(stop)
+	loaders := make([]*EtcdLoader, 3)
+	for i := range loaders {
+		l := NewEtcdLoader([]string{srv.URL}, "/s/", time.Second)
+		l.EnableElection("", 0)
+		l.reload = func() bool {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				m := atomic.LoadInt32(&maxActive)
+				if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+					break
+				}
+			}
+			if _, ok := f.get("/s.lock"); !ok {
+				t.Error("reload without holding the lock")
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			atomic.AddInt32(&total, 1)
+			reloads <- struct{}{}
+			return true
+		}
+		loaders[i] = l
+		go l.Run(stop)
+	}
+	for range loaders {
+		waitReload(t, reloads)
+	}
+	if maxActive != 1 || total != 3 {
+		t.Errorf("agents should reload one at a time [max_active:%d][total:%d]", maxActive, total)
+	}
+	// 通知在释放锁之前发出, 稍等一下
+	released := false
+	for i := 0; i < 100 && !released; i++ {
+		_, held := f.get("/s.lock")
+		released = !held
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !released {
+		t.Error("lock should be released after reload")
+	}
+	if loaders[0].electionKey != "/s.lock" {
+		t.Errorf("unexpected default election key %s", loaders[0].electionKey)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, end := range map[string]string{"/s/": "/s0", "a\xff": "b", "\xff\xff": "\x00"} {
+		if got := string(prefixEnd(prefix)); got != end {
+			t.Errorf("%q: expect %q, got %q", prefix, end, got)
+		}
+	}
+}
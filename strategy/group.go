@@ -0,0 +1,86 @@
+package strategy
+
+import (
+	"sort"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// Group is the strategies of a file sharing the same time regexp
+// 同一行的时间只需提取一次, 之后各策略的pattern可以一起计算
+type Group struct {
+	Key        string             `json:"key"`
+	FilePath   string             `json:"file_path"`
+	TimeFormat string             `json:"time_format"`
+	TimeReg    string             `json:"time_reg"`
+	Members    []*scheme.Strategy `json:"-"`
+	MemberIDs  []int64            `json:"strategy_ids"`
+}
+
+// groupable to check whether the strategy extracts its timestamp from the raw line by time regexp
+// 脱敏、logfmt/json等解析模式及A/B测试的策略作用的行或取时间的方式各不相同, 不参与分组
+func groupable(st *scheme.Strategy) bool {
+	return st.ParseSucc && st.TimeReg != nil && st.ParseMode == "" && len(st.MaskPatterns) == 0 &&
+		st.Variant == nil && len(st.CompositeOf) == 0 && !st.CatchAll
+}
+
+// groupKey to get the key of the group the strategy belongs to
+func groupKey(st *scheme.Strategy) string {
+	return st.FilePath + "\x00" + st.TimeFormat + "\x00" + st.TimeReg.String() + "\x00" + st.Location().String()
+}
+
+// buildGroups to group strategies by (file_path, time_format, time_reg, time_zone), only groups of at least 2 strategies are kept
+// 返回策略ID → 所在的组, 组内按ID排序
+func buildGroups(sts map[int64]*scheme.Strategy) map[int64]*Group {
+	byKey := make(map[string]*Group)
+	for _, st := range sts {
+		if !groupable(st) {
+			continue
+		}
+		key := groupKey(st)
+		grp, ok := byKey[key]
+		if !ok {
+			grp = &Group{Key: key, FilePath: st.FilePath, TimeFormat: st.TimeFormat, TimeReg: st.TimeReg.String()}
+			byKey[key] = grp
+		}
+		grp.Members = append(grp.Members, st)
+	}
+
+	ret := make(map[int64]*Group)
+	for _, grp := range byKey {
+		if len(grp.Members) < 2 {
+			continue
+		}
+		sort.Slice(grp.Members, func(i, j int) bool { return grp.Members[i].ID < grp.Members[j].ID })
+		for _, st := range grp.Members {
+			grp.MemberIDs = append(grp.MemberIDs, st.ID)
+			ret[st.ID] = grp
+		}
+	}
+	return ret
+}
+
+// GetAllWithGroups to get all strategy and the groups of them, both belong to the same update
+func GetAllWithGroups() (map[int64]*scheme.Strategy, map[int64]*Group) {
+	snap := current()
+	return snap.strategies, snap.groups
+}
+
+// GetGroups to get all groups ordered by file path
+func GetGroups() []*Group {
+	seen := make(map[*Group]bool)
+	ret := make([]*Group, 0)
+	for _, grp := range current().groups {
+		if !seen[grp] {
+			seen[grp] = true
+			ret = append(ret, grp)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].FilePath != ret[j].FilePath {
+			return ret[i].FilePath < ret[j].FilePath
+		}
+		return ret[i].MemberIDs[0] < ret[j].MemberIDs[0]
+	})
+	return ret
+}
@@ -0,0 +1,54 @@
+package strategy
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestBuildGroups(t *testing.T) {
+	timeReg := regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`)
+	mk := func(id int64, file string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: file, TimeFormat: "yyyy-mm-dd HH:MM:SS", TimeReg: timeReg, ParseSucc: true}
+	}
+	a, b, c := mk(3, "/a.log"), mk(1, "/a.log"), mk(2, "/a.log")
+	other := mk(4, "/b.log")
+	masked := mk(5, "/a.log")
+	masked.MaskPatterns = []scheme.MaskPattern{{Regex: "x"}}
+	logfmt := mk(6, "/a.log")
+	logfmt.ParseMode = scheme.ParseModeLogfmt
+	failed := mk(7, "/a.log")
+	failed.ParseSucc = false
+	slash := mk(8, "/a.log")
+	slash.TimeFormat = "yyyy/mm/dd HH:MM:SS"
+	slash.TimeReg = regexp.MustCompile(`\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}`)
+
+	sts := make(map[int64]*scheme.Strategy)
+	for _, st := range []*scheme.Strategy{a, b, c, other, masked, logfmt, failed, slash} {
+		sts[st.ID] = st
+	}
+	groups := buildGroups(sts)
+	grp := groups[1]
+	if grp == nil || groups[2] != grp || groups[3] != grp {
+		t.Fatalf("strategies of the same file and time regexp should share a group: %v", groups)
+	}
+	if !reflect.DeepEqual(grp.MemberIDs, []int64{1, 2, 3}) || grp.FilePath != "/a.log" {
+		t.Errorf("unexpected group %+v", grp)
+	}
+	for _, id := range []int64{4, 5, 6, 7, 8} {
+		if groups[id] != nil {
+			t.Errorf("strategy %d should not be grouped", id)
+		}
+	}
+
+	UpdateGlobalStrategy([]*scheme.Strategy{a, b, c, other})
+	defer UpdateGlobalStrategy(nil)
+	if all := GetGroups(); len(all) != 1 || all[0].Members[0] != b {
+		t.Errorf("groups should be published with strategies: %+v", all)
+	}
+	if _, groups := GetAllWithGroups(); groups[3] == nil {
+		t.Error("groups should be indexed by strategy id")
+	}
+}
@@ -0,0 +1,106 @@
+package strategy
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// 正则大小以编译后的指令数衡量
+const (
+	defaultRegexpBudget    = 20000
+	defaultRegexpHardLimit = 200000
+)
+
+// RegexpSize to measure instruction count of the compiled program of a pattern
+func RegexpSize(pat string) (int, error) {
+	if pat == "" {
+		return 0, nil
+	}
+	re, err := syntax.Parse(pat, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+	return len(prog.Inst), nil
+}
+
+// strategyRegexpSize to sum sizes of pattern, exclude, tags, ordered_tag_extracts, mask_patterns, must_not_contain and value_map of a strategy
+func strategyRegexpSize(st *scheme.Strategy) (int, error) {
+	pattern, err := withRegexpFlags(st.Pattern, st.PatternRegFlags)
+	if err != nil {
+		return 0, err
+	}
+	pats := []string{pattern, st.Exclude}
+	for _, tagv := range st.Tags {
+		pats = append(pats, tagv)
+	}
+	for _, e := range st.OrderedTagExtracts {
+		pats = append(pats, e.Regex)
+	}
+	for _, m := range st.MaskPatterns {
+		pats = append(pats, m.Regex)
+	}
+	// 只有编译为正则的项计入, 字面量走strings.Contains
+	for _, c := range st.MustNotContain {
+		if regexp.QuoteMeta(c) != c {
+			pats = append(pats, c)
+		}
+	}
+	if st.ValueMap != nil {
+		for _, e := range st.ValueMap.Entries {
+			pats = append(pats, e.Regex)
+		}
+	}
+	total := 0
+	for _, pat := range pats {
+		size, err := RegexpSize(pat)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// regexpLimits to get soft budget and hard limit from config
+func regexpLimits() (int, int) {
+	budget, hard := defaultRegexpBudget, defaultRegexpHardLimit
+	if g.Conf() != nil {
+		if g.Conf().Strategy.RegexpBudget > 0 {
+			budget = g.Conf().Strategy.RegexpBudget
+		}
+		if g.Conf().Strategy.RegexpHardLimit > 0 {
+			hard = g.Conf().Strategy.RegexpHardLimit
+		}
+	}
+	return budget, hard
+}
+
+// checkRegexpSize to measure the strategy and gate it by budget
+// 策略可以通过regexp_budget调高自己的预算, 但不能超过hard limit
+func checkRegexpSize(st *scheme.Strategy, budget, hard int) error {
+	size, err := strategyRegexpSize(st)
+	if err != nil {
+		// 语法错误交给后面的regexp.Compile报
+		return nil
+	}
+	st.RegexpSize = size
+
+	if st.RegexpBudget > 0 {
+		budget = st.RegexpBudget
+	}
+	if budget > hard {
+		budget = hard
+	}
+	if size > budget {
+		return fmt.Errorf("regexp too large: %d instructions over budget %d, use a literal list match instead of a huge alternation", size, budget)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package strategy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// alternation to build a pattern of n literal ids OR'd together
+func alternation(n int) string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("uid=%08d", i*7919))
+	}
+	return "(" + strings.Join(ids, "|") + ")"
+}
+
+func TestCheckRegexpSize(t *testing.T) {
+	small := &scheme.Strategy{ID: 1, Pattern: `error code=(\d+)`}
+	if err := checkRegexpSize(small, 1000, 10000); err != nil || small.RegexpSize == 0 {
+		t.Errorf("small pattern should pass with size measured: %v %d", err, small.RegexpSize)
+	}
+
+	large := &scheme.Strategy{ID: 2, Pattern: alternation(50)}
+	if err := checkRegexpSize(large, 1000, 10000); err != nil {
+		t.Errorf("large but allowed pattern should pass: %v", err)
+	}
+	if large.RegexpSize <= small.RegexpSize || large.RegexpSize > 1000 {
+		t.Fatalf("unexpected size of large pattern: %d", large.RegexpSize)
+	}
+
+	over := &scheme.Strategy{ID: 3, Pattern: alternation(500)}
+	err := checkRegexpSize(over, 1000, 10000)
+	if err == nil {
+		t.Fatalf("over budget pattern should be rejected, size %d", over.RegexpSize)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprint(over.RegexpSize)) || !strings.Contains(err.Error(), "1000") {
+		t.Errorf("status should explain size and budget: %v", err)
+	}
+
+	// 策略显式调高预算
+	over.RegexpBudget = 20000
+	if err := checkRegexpSize(over, 1000, 30000); err != nil {
+		t.Errorf("override should raise the budget: %v", err)
+	}
+	// 但不能超过hard limit
+	if err := checkRegexpSize(over, 1000, 2000); err == nil {
+		t.Error("override should be capped by hard limit")
+	}
+
+	// tag和exclude也计入
+	tagged := &scheme.Strategy{ID: 4, Pattern: "error", Tags: map[string]string{"uid": alternation(500)}}
+	if err := checkRegexpSize(tagged, 1000, 10000); err == nil {
+		t.Error("tag patterns should be counted")
+	}
+	masked := &scheme.Strategy{ID: 5, Pattern: "error", MaskPatterns: []scheme.MaskPattern{{Regex: alternation(500)}}}
+	if err := checkRegexpSize(masked, 1000, 10000); err == nil {
+		t.Error("mask patterns should be counted")
+	}
+	mustNot := &scheme.Strategy{ID: 6, Pattern: "error", MustNotContain: []string{alternation(500)}}
+	if err := checkRegexpSize(mustNot, 1000, 10000); err == nil {
+		t.Error("must_not_contain regexes should be counted")
+	}
+	literal := &scheme.Strategy{ID: 7, Pattern: "error", MustNotContain: []string{strings.Repeat("traceid=", 500)}}
+	if err := checkRegexpSize(literal, 1000, 10000); err != nil {
+		t.Errorf("must_not_contain literals should not be counted: %v", err)
+	}
+	mapped := &scheme.Strategy{ID: 8, Pattern: "error", ValueMap: &scheme.ValueMap{Entries: []scheme.ValueMapEntry{
+		{Regex: "fatal", Value: 3},
+		{Regex: alternation(500), Value: 1},
+	}}}
+	if err := checkRegexpSize(mapped, 1000, 10000); err == nil {
+		t.Error("value_map entries should be counted")
+	}
+}
+
+func TestRegexpSizeReport(t *testing.T) {
+	sts := []*scheme.Strategy{
+		{ID: 1, Pattern: "error", Degree: 1},
+		{ID: 2, Pattern: alternation(100), Degree: 1},
+		{ID: 3, Pattern: alternation(10), Degree: 1},
+	}
+	for _, st := range sts {
+		checkRegexpSize(st, defaultRegexpBudget, defaultRegexpHardLimit)
+	}
+	UpdateGlobalStrategy(sts)
+	defer UpdateGlobalStrategy(nil)
+
+	list := GetListAll()
+	ids := make([]int64, 0, len(list))
+	for _, st := range list {
+		ids = append(ids, st.ID)
+	}
+	if fmt.Sprint(ids) != "[2 3 1]" {
+		t.Errorf("strategies should be sorted by regexp size descending, got %v", ids)
+	}
+}
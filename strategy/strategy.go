@@ -1,7 +1,11 @@
 package strategy
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"sync/atomic"
 
 	"github.com/didi/falcon-log-agent/common/g"
 	"github.com/didi/falcon-log-agent/common/scheme"
@@ -9,41 +13,106 @@ import (
 )
 
 // 后续开发者切记 : 没有锁，不要修改globalStrategy，更新的时候直接替换，否则会panic
+// 策略表与代数一起整体替换, 读到的一定是同一次更新的结果
+// 发布后的策略(包括Tags、TagRegs等map)只读, worker不加锁直接遍历; 需要修改时先DeepCopy
+type strategySnapshot struct {
+	gen        int64
+	strategies map[int64]*scheme.Strategy
+	groups     map[int64]*Group              //时间正则相同的策略分组, 见buildGroups
+	byFile     map[string][]*scheme.Strategy //按file_path索引, 见buildFileIndex
+}
+
 var (
-	globalStrategy map[int64]*scheme.Strategy
+	globalStrategy atomic.Value
 )
 
 func init() {
-	globalStrategy = make(map[int64]*scheme.Strategy, 0)
+	globalStrategy.Store(&strategySnapshot{
+		strategies: make(map[int64]*scheme.Strategy, 0),
+		groups:     make(map[int64]*Group),
+		byFile:     make(map[string][]*scheme.Strategy),
+	})
+}
+
+func current() *strategySnapshot {
+	return globalStrategy.Load().(*strategySnapshot)
 }
 
 // UpdateGlobalStrategy to update strategy
+// 每次替换代数加一; 定义有变化的策略记下本次的代数, 没变化的沿用原来的
 func UpdateGlobalStrategy(sts []*scheme.Strategy) error {
+	snap := current()
+	gen := snap.gen + 1
 	tmpStrategyMap := make(map[int64]*scheme.Strategy, 0)
 	for _, st := range sts {
-		if st.Degree == 0 {
+		old := snap.strategies[st.ID]
+		// 整组推迟的change-set沿用已发布的策略, worker正在读, 不能再修改
+		if old == st {
+			tmpStrategyMap[st.ID] = st
+			continue
+		}
+		if st.Degree == 0 && g.Conf() != nil {
 			st.Degree = int64(g.Conf().Strategy.DefaultDegree)
 		}
+		if old != nil && sameDefinition(old, st) {
+			st.Generation = old.Generation
+		} else {
+			st.Generation = gen
+		}
 		tmpStrategyMap[st.ID] = st
 	}
-	globalStrategy = tmpStrategyMap
+	globalStrategy.Store(&strategySnapshot{
+		gen:        gen,
+		strategies: tmpStrategyMap,
+		groups:     buildGroups(tmpStrategyMap),
+		byFile:     buildFileIndex(tmpStrategyMap),
+	})
 	return nil
 }
 
+// sameDefinition to check whether two versions of a strategy are defined the same, ignoring generation
+// 每次加载都会重新解析出新的策略对象, 按序列化后的内容比较
+func sameDefinition(a, b *scheme.Strategy) bool {
+	ac, bc := *a, *b
+	ac.Generation, bc.Generation = 0, 0
+	aj, err := json.Marshal(&ac)
+	if err != nil {
+		return false
+	}
+	bj, err := json.Marshal(&bc)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aj, bj)
+}
+
+// Generation to get generation of current strategies
+func Generation() int64 {
+	return current().gen
+}
+
 // GetListAll to get all strategy
+// 按正则大小从大到小排序, 方便找到开销大的策略
 func GetListAll() []*scheme.Strategy {
 	stmap := GetDeepCopyAll()
 	var ret []*scheme.Strategy
 	for _, st := range stmap {
 		ret = append(ret, st)
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].RegexpSize != ret[j].RegexpSize {
+			return ret[i].RegexpSize > ret[j].RegexpSize
+		}
+		return ret[i].ID < ret[j].ID
+	})
 	return ret
 }
 
 // GetDeepCopyAll to get all strategy deep copy
 func GetDeepCopyAll() map[int64]*scheme.Strategy {
-	ret := make(map[int64]*scheme.Strategy, len(globalStrategy))
-	for k, v := range globalStrategy {
+	sts := current().strategies
+	ret := make(map[int64]*scheme.Strategy, len(sts))
+	for k, v := range sts {
 		ret[k] = utils.DeepCopyStrategy(v)
 	}
 	return ret
@@ -51,12 +120,18 @@ func GetDeepCopyAll() map[int64]*scheme.Strategy {
 
 // GetAll to get all strategy
 func GetAll() map[int64]*scheme.Strategy {
-	return globalStrategy
+	return current().strategies
+}
+
+// GetAllWithGeneration to get all strategy and the generation they belong to
+func GetAllWithGeneration() (map[int64]*scheme.Strategy, int64) {
+	snap := current()
+	return snap.strategies, snap.gen
 }
 
 // GetByID to get strategy by id
 func GetByID(id int64) (*scheme.Strategy, error) {
-	st, ok := globalStrategy[id]
+	st, ok := current().strategies[id]
 
 	if !ok {
 		return nil, fmt.Errorf("ID : %d is not exists in global Cache", id)
@@ -0,0 +1,43 @@
+package strategy
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// compileTagExtracts to compile ordered_tag_extracts of the strategy
+// key不能与tags、tag_fields及前面的条目重复; source_tag只能引用tags、tag_fields或排在前面的条目, 提取时它们已有值
+func compileTagExtracts(st *scheme.Strategy) error {
+	extracted := make(map[string]bool, len(st.OrderedTagExtracts))
+	before := func(tagk string) bool {
+		_, ok := st.Tags[tagk]
+		return ok || st.TagFields[tagk] != "" || extracted[tagk]
+	}
+	for i := range st.OrderedTagExtracts {
+		e := &st.OrderedTagExtracts[i]
+		if e.Key == "" {
+			return fmt.Errorf("ordered_tag_extracts[%d]: key is empty", i)
+		}
+		if before(e.Key) {
+			return fmt.Errorf("ordered_tag_extracts[%d]: tag %s is extracted more than once", i, e.Key)
+		}
+		if e.SourceTag != "" && !before(e.SourceTag) {
+			return fmt.Errorf("ordered_tag_extracts[%d]: source_tag %s is not extracted before %s", i, e.SourceTag, e.Key)
+		}
+		if e.Regex == "" {
+			return fmt.Errorf("ordered_tag_extracts[%d]: regex is empty", i)
+		}
+		reg, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("ordered_tag_extracts[%d]: %v", i, err)
+		}
+		if reg.NumSubexp() == 0 {
+			return fmt.Errorf("ordered_tag_extracts[%d]: regex of %s has no capture group", i, e.Key)
+		}
+		e.Reg = reg
+		extracted[e.Key] = true
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package strategy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestCompileTagExtracts(t *testing.T) {
+	base := `"file_path": "/a.log", "time_format": "yyyy-mm-dd HH:MM:SS", "pattern": "cost=(\\d+)", "func": "avg", "step": 60, "tags": {"dc": "dc=(\\w+)"}`
+	cases := []struct {
+		extracts string
+		status   string //为空表示正常加载
+	}{
+		{`[{"key": "region", "regex": "^([a-z]+)", "source_tag": "dc"}, {"key": "zone", "regex": "(\\d)$", "source_tag": "region"}]`, ""},
+		{`[{"key": "api", "regex": "GET (/\\w{1,64})"}]`, ""},
+		{`[{"key": "", "regex": "(x)"}]`, "key is empty"},
+		{`[{"key": "dc", "regex": "(x)"}]`, "tag dc is extracted more than once"},
+		{`[{"key": "a", "regex": "(x)"}, {"key": "a", "regex": "(y)"}]`, "tag a is extracted more than once"},
+		{`[{"key": "zone", "regex": "(x)", "source_tag": "region"}, {"key": "region", "regex": "(y)", "source_tag": "dc"}]`, "source_tag region is not extracted before zone"},
+		{`[{"key": "region", "regex": "(x)", "source_tag": "region"}]`, "source_tag region is not extracted before region"},
+		{`[{"key": "region", "regex": ""}]`, "regex is empty"},
+		{`[{"key": "region", "regex": "(x"}]`, "missing closing )"},
+		{`[{"key": "region", "regex": "x"}]`, "has no capture group"},
+	}
+	for i, c := range cases {
+		var st scheme.Strategy
+		if err := json.Unmarshal([]byte(`{"id": 1, `+base+`, "ordered_tag_extracts": `+c.extracts+`}`), &st); err != nil {
+			t.Fatal(err)
+		}
+		updateRegs([]*scheme.Strategy{&st})
+		if c.status == "" {
+			if !st.ParseSucc {
+				t.Errorf("case %d: should be loaded, status %q", i, st.Status)
+				continue
+			}
+			for _, e := range st.OrderedTagExtracts {
+				if e.Reg == nil || !st.HasTag(e.Key) {
+					t.Errorf("case %d: extract %s should be compiled", i, e.Key)
+				}
+			}
+			continue
+		}
+		if st.ParseSucc || !strings.HasPrefix(st.Status, "ordered_tag_extracts[") || !strings.Contains(st.Status, c.status) {
+			t.Errorf("case %d: expect status containing %q, got %q", i, c.status, st.Status)
+		}
+	}
+
+	// 提取出的tag可以被tag_limits等引用, 无界的捕获组给出提示
+	var st scheme.Strategy
+	json.Unmarshal([]byte(`{"id": 2, `+base+`, "ordered_tag_extracts": [{"key": "url", "regex": "GET (\\S+)"}], "tag_limits": {"url": {"max_len": 64}}}`), &st)
+	updateRegs([]*scheme.Strategy{&st})
+	if !st.ParseSucc || len(st.Warnings) != 1 || !strings.HasPrefix(st.Warnings[0], "tag url:") {
+		t.Errorf("unexpected result [status:%q][warnings:%v]", st.Status, st.Warnings)
+	}
+}
@@ -0,0 +1,76 @@
+package strategy
+
+import (
+	"fmt"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// TemplateNginxJSON nginx按json输出的access日志, 如
+// log_format json escape=json '{"time_local":"$time_local","status":"$status","request_method":"$request_method",...}'
+const TemplateNginxJSON = "nginx_json"
+
+// strategyTemplates 内置模板, 策略中没有配置的字段取模板的值
+var strategyTemplates = map[string]*scheme.Strategy{
+	TemplateNginxJSON: {
+		ParseMode:  scheme.ParseModeJSON,
+		TimeField:  "time_local",
+		TimeFormat: "dd/mmm/yyyy:HH:MM:SS Z",
+		TagFields: map[string]string{
+			"status":          "status",
+			"request_method":  "request_method",
+			"upstream_addr":   "upstream_addr",
+			"body_bytes_sent": "body_bytes_sent",
+		},
+	},
+}
+
+// applyTemplate to fill fields not set by the strategy from its template
+// tag_fields逐个合并, 策略中取值为空的去掉模板的该tag, tags中用正则提取的同名tag优先; 重复调用结果不变
+func applyTemplate(st *scheme.Strategy) error {
+	if st.Template == "" {
+		return nil
+	}
+	t, ok := strategyTemplates[st.Template]
+	if !ok {
+		return fmt.Errorf("unknown template %s", st.Template)
+	}
+	if st.ParseMode == "" {
+		st.ParseMode = t.ParseMode
+	}
+	if st.TimeField == "" {
+		st.TimeField = t.TimeField
+	}
+	if st.TimeFormat == "" {
+		st.TimeFormat = t.TimeFormat
+	}
+	for tagk, field := range t.TagFields {
+		if _, ok := st.TagFields[tagk]; ok {
+			continue
+		}
+		if _, ok := st.Tags[tagk]; ok {
+			continue
+		}
+		if st.TagFields == nil {
+			st.TagFields = make(map[string]string, len(t.TagFields))
+		}
+		st.TagFields[tagk] = field
+	}
+	return nil
+}
+
+// checkTagFields to check tag_fields can be extracted
+func checkTagFields(st *scheme.Strategy) error {
+	for tagk, field := range st.TagFields {
+		if field == "" {
+			continue
+		}
+		if st.ParseMode == "" {
+			return fmt.Errorf("tag_fields[%s]: no fields without parse_mode", tagk)
+		}
+		if _, ok := st.Tags[tagk]; ok {
+			return fmt.Errorf("tag_fields[%s]: tags[%s] is also set, remove one of them", tagk, tagk)
+		}
+	}
+	return nil
+}
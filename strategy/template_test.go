@@ -0,0 +1,74 @@
+package strategy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestApplyTemplate(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, Template: TemplateNginxJSON}
+	if err := applyTemplate(st); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"status": "status", "request_method": "request_method", "upstream_addr": "upstream_addr", "body_bytes_sent": "body_bytes_sent",
+	}
+	if st.ParseMode != scheme.ParseModeJSON || st.TimeField != "time_local" || st.TimeFormat != "dd/mmm/yyyy:HH:MM:SS Z" ||
+		!reflect.DeepEqual(st.TagFields, want) {
+		t.Fatalf("unexpected strategy from template: %+v", st)
+	}
+	// 模板本身不被修改
+	if len(strategyTemplates[TemplateNginxJSON].TagFields) != 4 {
+		t.Fatal("template should not be modified")
+	}
+
+	// 策略中配置的字段优先, 空值去掉该tag, tags中的同名tag优先
+	st = &scheme.Strategy{
+		ID:         2,
+		Template:   TemplateNginxJSON,
+		TimeField:  "time_iso8601",
+		TimeFormat: "rfc3339_nano",
+		Tags:       map[string]string{"status": `"status":"(\d)`},
+		TagFields:  map[string]string{"body_bytes_sent": "", "host": "host", "upstream_addr": "upstream"},
+	}
+	applyTemplate(st)
+	applyTemplate(st) //重复调用结果不变
+	want = map[string]string{"request_method": "request_method", "upstream_addr": "upstream", "body_bytes_sent": "", "host": "host"}
+	if st.TimeField != "time_iso8601" || st.TimeFormat != "rfc3339_nano" || !reflect.DeepEqual(st.TagFields, want) {
+		t.Fatalf("fields of strategy should override template: %+v", st)
+	}
+	if st.HasTag("body_bytes_sent") || !st.HasTag("status") || !st.HasTag("host") {
+		t.Errorf("unexpected tags %v %v", st.Tags, st.TagFields)
+	}
+
+	if err := applyTemplate(&scheme.Strategy{Template: "apache_json"}); err == nil {
+		t.Error("unknown template should be rejected")
+	}
+}
+
+func TestUpdateRegsTemplate(t *testing.T) {
+	sts := []*scheme.Strategy{
+		{ID: 1, FilePath: "/var/log/nginx/access.json", Template: TemplateNginxJSON, ValueField: "request_time", Func: "avg", Interval: 60},
+		{ID: 2, FilePath: "/var/log/nginx/access.json", Template: "nginx", ValueField: "request_time", Func: "avg", Interval: 60},
+		{ID: 3, FilePath: "/var/log/nginx/access.json", Template: TemplateNginxJSON, ValueField: "request_time", Func: "avg", Interval: 60,
+			Tags: map[string]string{"host": `host=(\S+)`}, TagFields: map[string]string{"host": "host"}},
+		{ID: 4, FilePath: "/var/log/app.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "cost=(\\d+)", Func: "avg", Interval: 60,
+			TagFields: map[string]string{"host": "host"}},
+	}
+	updateRegs(sts)
+	if !sts[0].ParseSucc || sts[0].TimeReg == nil || !sts[0].TimeReg.MatchString("01/Jan/2018:12:00:01 +0800") {
+		t.Errorf("nginx_json strategy should be loaded: %q", sts[0].Status)
+	}
+	if sts[1].ParseSucc || !strings.Contains(sts[1].Status, "unknown template") {
+		t.Errorf("unknown template should not be loaded: %q", sts[1].Status)
+	}
+	if sts[2].ParseSucc || !strings.HasPrefix(sts[2].Status, "tag_fields[host]") {
+		t.Errorf("tag in both tags and tag_fields should not be loaded: %q", sts[2].Status)
+	}
+	if sts[3].ParseSucc || !strings.HasPrefix(sts[3].Status, "tag_fields[host]") {
+		t.Errorf("tag_fields without parse_mode should not be loaded: %q", sts[3].Status)
+	}
+}
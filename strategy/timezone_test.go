@@ -0,0 +1,57 @@
+package strategy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestUpdateRegsTimeZone(t *testing.T) {
+	var sts []*scheme.Strategy
+	err := json.Unmarshal([]byte(`[
+		{"id": 1, "file_path": "/a.log", "time_format": "yyyy-mm-dd HH:MM:SS", "pattern": "error", "func": "cnt", "step": 60},
+		{"id": 2, "file_path": "/a.log", "time_format": "yyyy-mm-dd HH:MM:SS", "pattern": "error", "func": "cnt", "step": 60, "time_zone": ""},
+		{"id": 3, "file_path": "/a.log", "time_format": "yyyy-mm-dd HH:MM:SS", "pattern": "error", "func": "cnt", "step": 60, "time_zone": "America/New_York"},
+		{"id": 4, "file_path": "/a.log", "time_format": "yyyy-mm-dd HH:MM:SS", "pattern": "error", "func": "cnt", "step": 60, "time_zone": "Mars/Olympus"}
+	]`), &sts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateRegs(sts)
+	for i, want := range []string{time.Local.String(), "UTC", "America/New_York"} {
+		if st := sts[i]; !st.ParseSucc || st.TimeLoc == nil || st.TimeLoc.String() != want {
+			t.Errorf("sid %d: expect location %s, got %v (%s)", st.ID, want, st.TimeLoc, st.Status)
+		}
+	}
+	if st := sts[3]; st.ParseSucc || !strings.HasPrefix(st.Status, "time_zone: ") {
+		t.Errorf("invalid time zone should not be loaded: %q", st.Status)
+	}
+
+	// Local为本机时区
+	local := []*scheme.Strategy{{ID: 5, FilePath: "/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "error", Func: "cnt", Interval: 60}}
+	tz := "Local"
+	local[0].TimeZone = &tz
+	updateRegs(local)
+	if st := local[0]; !st.ParseSucc || st.TimeLoc != time.Local {
+		t.Errorf("Local should be the local zone, got %v (%s)", st.TimeLoc, st.Status)
+	}
+
+	// 没有配置time_zone时取default_time_zone, 发布的配置为Asia/Shanghai, 与之前的版本一致
+	if loc, err := resolveLocation(sts[0], "Asia/Shanghai"); err != nil || loc.String() != "Asia/Shanghai" {
+		t.Errorf("default_time_zone should be used when time_zone is unset, got %v %v", loc, err)
+	}
+	if loc, err := resolveLocation(sts[2], "Asia/Shanghai"); err != nil || loc.String() != "America/New_York" {
+		t.Errorf("time_zone should win over default_time_zone, got %v %v", loc, err)
+	}
+	if _, err := resolveLocation(sts[0], "Mars/Olympus"); err == nil || !strings.HasPrefix(err.Error(), "default_time_zone: ") {
+		t.Errorf("invalid default_time_zone should be reported, got %v", err)
+	}
+
+	// 已解析的时区复用
+	if loc, _ := loadLocation("America/New_York"); loc != sts[2].TimeLoc {
+		t.Error("locations should be cached")
+	}
+}
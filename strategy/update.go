@@ -1,10 +1,13 @@
 package strategy
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
 	"github.com/didi/falcon-log-agent/common/scheme"
 	"github.com/didi/falcon-log-agent/common/utils"
 
@@ -14,6 +17,36 @@ import (
 // PatternExcludePartition to separate pattern and exclude
 const PatternExcludePartition = "```EXCLUDE```"
 
+// reloadWaitTimeout 等待触发的一轮策略更新完成的超时
+const reloadWaitTimeout = 30 * time.Second
+
+// reloadRequests 要求立即更新策略, 更新循环在本轮完成后关闭收到的channel
+var reloadRequests = make(chan chan struct{})
+
+// RequestReload to ask the update loop for an update of strategies right now and wait until it is done
+// 更新循环没有运行或超时返回false, 策略仍会在下一个周期更新
+func RequestReload(timeout time.Duration) bool {
+	done := make(chan struct{})
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case reloadRequests <- done:
+	case <-t.C:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// ReloadRequests to get the requests of RequestReload, close the received channel when the update is done
+func ReloadRequests() <-chan chan struct{} {
+	return reloadRequests
+}
+
 // Update to update strategy
 func Update() error {
 	markTms := time.Now().Unix()
@@ -28,9 +61,11 @@ func Update() error {
 	}
 	dlog.Infof("[%d]Get my Strategy success, num : [%d]", markTms, len(strategys))
 
+	// change-set整组生效或整组推迟, 随本次替换一起对worker可见
+	strategys = applyChangeSets(strategys, GetAll())
 	err = UpdateGlobalStrategy(strategys)
 	if err != nil {
-		dlog.Errorf("[%d]Update Strategy cache error ! [msg:%v]", err)
+		dlog.Errorf("[%d]Update Strategy cache error ! [msg:%v]", markTms, err)
 		return err
 	}
 	dlog.Infof("[%d]Update Strategy end", markTms)
@@ -55,12 +90,33 @@ func parsePattern(strategys []*scheme.Strategy) {
 }
 
 func updateRegs(strategys []*scheme.Strategy) {
+	budget, hard := regexpLimits()
 	for _, st := range strategys {
 		st.TagRegs = make(map[string]*regexp.Regexp, 0)
 		st.ParseSucc = false
+		st.Status = ""
+		st.Warnings = nil
+
+		//组合策略没有正则, 在validateComposites中处理
+		if len(st.CompositeOf) > 0 {
+			continue
+		}
+
+		//先用模板填充没有配置的字段
+		if err := applyTemplate(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
 
 		//更新时间正则
 		pat, _ := utils.GetPatAndTimeFormat(st.TimeFormat)
+		pat, err := withRegexpFlags(pat, st.TimeRegFlags)
+		if err != nil {
+			st.Status = "time_reg_flags: " + err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
 		reg, err := regexp.Compile(pat)
 		if err != nil {
 			dlog.Errorf("compile time regexp failed:[sid:%d][format:%s][pat:%s][err:%v]", st.ID, st.TimeFormat, pat, err)
@@ -68,14 +124,65 @@ func updateRegs(strategys []*scheme.Strategy) {
 		}
 		st.TimeReg = reg
 
-		if len(st.Pattern) == 0 && len(st.Exclude) == 0 {
+		//解析时区
+		loc, err := strategyLocation(st)
+		if err != nil {
+			st.Status = "time_zone: " + err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+		st.TimeLoc = loc
+
+		switch st.ParseMode {
+		case "", scheme.ParseModeLogfmt, scheme.ParseModeJSON, scheme.ParseModeWindowsEventXML, scheme.ParseModeAuto:
+		default:
+			st.Status = "unknown parse_mode " + st.ParseMode
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+		if err := checkTagFields(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
+		//编译脱敏规则, 在其他正则之前作用于整行
+		if err := compileMaskPatterns(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
+		//logfmt模式下可以只按value_field取值
+		valueByField := st.ParseMode != "" && st.ValueField != ""
+		if len(st.Pattern) == 0 && len(st.Exclude) == 0 && !valueByField && !st.CatchAll {
 			dlog.Errorf("pattern and exclude are all empty, sid:[%d]", st.ID)
 			continue
 		}
 
+		//内置类型的tag换成对应的正则, 算入正则大小
+		if err := applyTagTypes(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
+		//先估算正则大小, 超过预算的不再编译
+		if err := checkRegexpSize(st, budget, hard); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
 		//更新pattern
 		if len(st.Pattern) != 0 {
-			reg, err = regexp.Compile(st.Pattern)
+			pat, err = withRegexpFlags(st.Pattern, st.PatternRegFlags)
+			if err != nil {
+				st.Status = "pattern_reg_flags: " + err.Error()
+				dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+				continue
+			}
+			reg, err = regexp.Compile(pat)
 			if err != nil {
 				dlog.Errorf("compile pattern regexp failed:[sid:%d][pat:%s][err:%v]", st.ID, st.Pattern, err)
 				continue
@@ -83,6 +190,13 @@ func updateRegs(strategys []*scheme.Strategy) {
 			st.PatternReg = reg
 		}
 
+		//解析取值的捕获组
+		if err := resolveValueGroup(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
 		//更新exclude
 		if len(st.Exclude) != 0 {
 			reg, err = regexp.Compile(st.Exclude)
@@ -93,6 +207,20 @@ func updateRegs(strategys []*scheme.Strategy) {
 			st.ExcludeReg = reg
 		}
 
+		//更新must_not_contain
+		if err := compileMustNotContain(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
+		//更新value_map
+		if err := compileValueMap(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
+
 		//更新tags
 		for tagk, tagv := range st.Tags {
 			reg, err = regexp.Compile(tagv)
@@ -102,6 +230,158 @@ func updateRegs(strategys []*scheme.Strategy) {
 			}
 			st.TagRegs[tagk] = reg
 		}
+
+		//更新ordered_tag_extracts, source_tag依赖tags及tag_fields
+		if err := compileTagExtracts(st); err != nil {
+			st.Status = err.Error()
+			dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+			continue
+		}
 		st.ParseSucc = true
 	}
+
+	//编译组合策略, 依赖被引用策略的解析结果
+	validateComposites(strategys)
+
+	//校验step与推送周期
+	validateSteps(strategys)
+	validateAnomalies(strategys)
+	validateValueRanges(strategys)
+	validateTagLimits(strategys)
+	validateFirstPeriods(strategys)
+	validateNoises(strategys)
+	validateEpisodes(strategys)
+	validateEndpointSources(strategys)
+	validateCatchAlls(strategys)
+	validateExcludeRatios(strategys)
+	validateWindows(strategys)
+	validateTimestampPrecisions(strategys)
+	validateWriteBackPaths(strategys)
+	validateRetireAts(strategys)
+	validateValueMaps(strategys)
+	validateValueTiers(strategys)
+	validateMetricTypes(strategys)
+
+	//编译A/B测试的variant
+	updateVariants(strategys)
+}
+
+// locations 已解析的时区, 每次加载不再重复读时区数据库
+var (
+	locations     = make(map[string]*time.Location)
+	locationsLock sync.Mutex
+)
+
+// strategyLocation to get the location of the strategy with strategy.default_time_zone from config
+func strategyLocation(st *scheme.Strategy) (*time.Location, error) {
+	defaultZone := ""
+	if g.Conf() != nil {
+		defaultZone = g.Conf().Strategy.DefaultTimeZone
+	}
+	return resolveLocation(st, defaultZone)
+}
+
+// resolveLocation to get the location of the strategy, falling back to defaultZone and then the local zone
+func resolveLocation(st *scheme.Strategy, defaultZone string) (*time.Location, error) {
+	if st.TimeZone != nil {
+		return loadLocation(*st.TimeZone)
+	}
+	if defaultZone != "" {
+		loc, err := loadLocation(defaultZone)
+		if err != nil {
+			return nil, fmt.Errorf("default_time_zone: %v", err)
+		}
+		return loc, nil
+	}
+	return time.Local, nil
+}
+
+// loadLocation to load the location by IANA name, "" is UTC and "Local" is the local zone
+func loadLocation(name string) (*time.Location, error) {
+	locationsLock.Lock()
+	defer locationsLock.Unlock()
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// compileMaskPatterns to compile regexes of mask_patterns
+func compileMaskPatterns(st *scheme.Strategy) error {
+	for i := range st.MaskPatterns {
+		m := &st.MaskPatterns[i]
+		if m.Regex == "" {
+			return fmt.Errorf("mask_patterns[%d]: regex is empty", i)
+		}
+		reg, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("mask_patterns[%d]: %v", i, err)
+		}
+		m.Reg = reg
+	}
+	return nil
+}
+
+// compileMustNotContain to split must_not_contain into literals and compiled regexes
+// 不含正则元字符的项按字面量处理, 走strings.Contains
+func compileMustNotContain(st *scheme.Strategy) error {
+	st.MustNotContainLits, st.MustNotContainRegs = nil, nil
+	for i, c := range st.MustNotContain {
+		if c == "" {
+			return fmt.Errorf("must_not_contain[%d] is empty", i)
+		}
+		if regexp.QuoteMeta(c) == c {
+			st.MustNotContainLits = append(st.MustNotContainLits, c)
+			continue
+		}
+		reg, err := regexp.Compile(c)
+		if err != nil {
+			return fmt.Errorf("must_not_contain[%d]: %v", i, err)
+		}
+		st.MustNotContainRegs = append(st.MustNotContainRegs, reg)
+	}
+	return nil
+}
+
+// compileValueMap to compile regexes of value_map entries
+func compileValueMap(st *scheme.Strategy) error {
+	if st.ValueMap == nil {
+		return nil
+	}
+	for i := range st.ValueMap.Entries {
+		e := &st.ValueMap.Entries[i]
+		if e.Regex == "" {
+			return fmt.Errorf("value_map.entries[%d]: regex is empty", i)
+		}
+		reg, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("value_map.entries[%d]: %v", i, err)
+		}
+		e.Reg = reg
+	}
+	return nil
+}
+
+// applyTagTypes to fill tags of built-in types with their regexes
+// tags中已经写了正则的, 必须与内置的一致, 避免不清楚实际用的是哪个
+func applyTagTypes(st *scheme.Strategy) error {
+	for tagk, typ := range st.TagTypes {
+		pat, ok := scheme.TagTypePatterns[typ]
+		if !ok {
+			return fmt.Errorf("tag_types[%s]: unknown type %s", tagk, typ)
+		}
+		if v := st.Tags[tagk]; v != "" && v != pat {
+			return fmt.Errorf("tag_types[%s]: tags[%s] is also set, remove one of them", tagk, tagk)
+		}
+		if st.Tags == nil {
+			st.Tags = make(map[string]string, len(st.TagTypes))
+		}
+		st.Tags[tagk] = pat
+	}
+	return nil
 }
@@ -0,0 +1,677 @@
+package strategy
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// step与推送周期不兼容时的处理策略
+const (
+	StepPolicyReject = "reject" //标记为不可用, 不参与计算
+	StepPolicyClamp  = "clamp"  //step向上取整为推送周期的整数倍
+)
+
+// StatusStepIncompatible is the status prefix of strategies whose step conflicts with push cycle
+const StatusStepIncompatible = "step incompatible with push cycle"
+
+// PushCycle to get the effective push cycle of counter, in seconds
+func PushCycle() int64 {
+	if g.Conf() == nil || g.Conf().Worker.PushInterval <= 0 {
+		return 1
+	}
+	return int64(g.Conf().Worker.PushInterval)
+}
+
+// CheckStep to check whether counter can represent the step
+// counter按step对齐时间戳, 每个推送周期检查一次, 所以step必须是推送周期的整数倍
+func CheckStep(step, cycle int64) error {
+	if step <= 0 {
+		return fmt.Errorf("step must be positive [step:%d]", step)
+	}
+	if cycle <= 0 {
+		return nil
+	}
+	if step < cycle {
+		return fmt.Errorf("step less than push cycle [step:%d][push_cycle:%d]", step, cycle)
+	}
+	if step%cycle != 0 {
+		return fmt.Errorf("step not a multiple of push cycle [step:%d][push_cycle:%d]", step, cycle)
+	}
+	return nil
+}
+
+// clampStep to round step up to a multiple of cycle
+func clampStep(step, cycle int64) int64 {
+	if step < cycle {
+		return cycle
+	}
+	if step%cycle != 0 {
+		return (step/cycle + 1) * cycle
+	}
+	return step
+}
+
+// validateStep to check step of one strategy and apply the policy
+// 不兼容时一定写Status, reject置ParseSucc为false, clamp修改Interval, 不会静默处理
+func validateStep(st *scheme.Strategy, cycle int64, policy string) {
+	err := CheckStep(st.Interval, cycle)
+	if err == nil {
+		return
+	}
+
+	if policy == StepPolicyClamp && cycle > 0 {
+		step := clampStep(st.Interval, cycle)
+		st.Status = fmt.Sprintf("%s: %v, clamped to %d", StatusStepIncompatible, err, step)
+		dlog.Warningf("%s [sid:%d]", st.Status, st.ID)
+		st.Interval = step
+		return
+	}
+
+	st.Status = fmt.Sprintf("%s: %v, rejected", StatusStepIncompatible, err)
+	st.ParseSucc = false
+	dlog.Errorf("%s [sid:%d]", st.Status, st.ID)
+}
+
+func validateSteps(strategys []*scheme.Strategy) {
+	policy := StepPolicyReject
+	if g.Conf() != nil && g.Conf().Strategy.StepPolicy != "" {
+		policy = g.Conf().Strategy.StepPolicy
+	}
+	cycle := PushCycle()
+	for _, st := range strategys {
+		if st.ParseSucc {
+			validateStep(st, cycle, policy)
+		}
+	}
+}
+
+// validateAnomalies to check anomaly detection settings
+// 阈值不合法时使用默认值, 原因写入Status
+func validateAnomalies(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if st.AnomalyDetect && st.AnomalyStddevThreshold < 0 {
+			addStatus(st, fmt.Sprintf("anomaly_stddev_threshold %v is negative, use default", st.AnomalyStddevThreshold))
+			st.AnomalyStddevThreshold = 0
+		}
+	}
+}
+
+// validateEpisodes to check gap_seconds of strategies counting episodes
+func validateEpisodes(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if st.Func == scheme.FuncEpisodes && st.ParseSucc && st.GapSeconds <= 0 {
+			addStatus(st, fmt.Sprintf("gap_seconds %d should be positive for func episodes", st.GapSeconds))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateValueRanges to check bounds of value_range
+// 边界不合法的策略不加载, 原因写入Status
+func validateValueRanges(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if st.ValueRange == nil || !st.ParseSucc {
+			continue
+		}
+		if err := checkValueRange(st.ValueRange); err != nil {
+			addStatus(st, "value_range: "+err.Error())
+			st.ParseSucc = false
+		}
+	}
+}
+
+func checkValueRange(r *scheme.ValueRange) error {
+	switch r.OnOutOfRange {
+	case "", scheme.OutOfRangeDrop, scheme.OutOfRangeClamp, scheme.OutOfRangeKeep:
+	default:
+		return fmt.Errorf("unknown on_out_of_range %q, should be drop, clamp or keep", r.OnOutOfRange)
+	}
+	for _, bound := range []*float64{r.Min, r.Max} {
+		if bound != nil && (math.IsNaN(*bound) || math.IsInf(*bound, 0)) {
+			return fmt.Errorf("bound %v is not finite", *bound)
+		}
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("min %v is greater than max %v", *r.Min, *r.Max)
+	}
+	return nil
+}
+
+// validateTagLimits to check tag_limits and warn about tag regexes capturing unbounded input
+// on_oversize、max_len不合法的策略不加载; 无界的捕获组只写入Warnings, 超长的值在提取时按长度限制处理
+func validateTagLimits(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if !st.ParseSucc {
+			continue
+		}
+		for tagk, l := range st.TagLimits {
+			if l == nil {
+				continue
+			}
+			if !st.HasTag(tagk) {
+				addStatus(st, fmt.Sprintf("tag_limits: no tag %s", tagk))
+			}
+			if l.MaxLen < 0 {
+				addStatus(st, fmt.Sprintf("tag_limits: max_len %d of tag %s is negative", l.MaxLen, tagk))
+				st.ParseSucc = false
+			}
+			switch l.OnOversize {
+			case "", scheme.OversizeTruncate, scheme.OversizeDrop:
+			default:
+				addStatus(st, fmt.Sprintf("tag_limits: unknown on_oversize %q of tag %s, should be truncate or drop", l.OnOversize, tagk))
+				st.ParseSucc = false
+			}
+		}
+
+		tagks := make([]string, 0, len(st.Tags))
+		for tagk := range st.Tags {
+			tagks = append(tagks, tagk)
+		}
+		sort.Strings(tagks)
+		for _, tagk := range tagks {
+			if unboundedCapture(st.Tags[tagk]) {
+				warning := fmt.Sprintf("tag %s: capture group can match unbounded input, use a bounded quantifier such as {1,128}", tagk)
+				dlog.Warningf("%s [sid:%d][pattern:%s]", warning, st.ID, st.Tags[tagk])
+				st.Warnings = append(st.Warnings, warning)
+			}
+		}
+		for _, e := range st.OrderedTagExtracts {
+			if unboundedCapture(e.Regex) {
+				warning := fmt.Sprintf("tag %s: capture group can match unbounded input, use a bounded quantifier such as {1,128}", e.Key)
+				dlog.Warningf("%s [sid:%d][pattern:%s]", warning, st.ID, e.Regex)
+				st.Warnings = append(st.Warnings, warning)
+			}
+		}
+	}
+}
+
+// validateFirstPeriods to check first_period, unknown policy is not loaded
+func validateFirstPeriods(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		switch st.FirstPeriod {
+		case "", scheme.FirstPeriodEmit, scheme.FirstPeriodSuppress, scheme.FirstPeriodPartialTag:
+		default:
+			addStatus(st, fmt.Sprintf("unknown first_period %q, should be emit, suppress or partial_tag", st.FirstPeriod))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateNoises to check noise, invalid noise is not loaded rather than sending exact values out
+func validateNoises(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		n := st.Noise
+		if n == nil {
+			continue
+		}
+		var reasons []string
+		if n.Mechanism != scheme.NoiseLaplace {
+			reasons = append(reasons, fmt.Sprintf("noise: unknown mechanism %q, should be laplace", n.Mechanism))
+		}
+		if !(n.Epsilon > 0) || math.IsInf(n.Epsilon, 1) {
+			reasons = append(reasons, fmt.Sprintf("noise: epsilon %v should be positive", n.Epsilon))
+		}
+		if n.Sensitivity == nil {
+			reasons = append(reasons, "noise: sensitivity is missing")
+		} else if !(*n.Sensitivity > 0) || math.IsInf(*n.Sensitivity, 1) {
+			reasons = append(reasons, fmt.Sprintf("noise: sensitivity %v should be positive", *n.Sensitivity))
+		}
+		if st.Func != "cnt" && st.Func != "sum" {
+			reasons = append(reasons, fmt.Sprintf("noise: func %s is not supported, only cnt and sum", st.Func))
+		}
+		if c := g.Conf(); c != nil && c.Sink.Addr != "" && c.Sink.External {
+			reasons = append(reasons, "noise: sink "+c.Sink.Addr+" is external and receives points before aggregation")
+		}
+		for _, r := range reasons {
+			addStatus(st, r)
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateEndpointSources to check endpoint_source, the tag must be one of tags
+func validateEndpointSources(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if st.EndpointSource == "" {
+			continue
+		}
+		tagk := st.EndpointTag()
+		if tagk == "" {
+			addStatus(st, fmt.Sprintf("unknown endpoint_source %q, should be tag:<tagname>", st.EndpointSource))
+			st.ParseSucc = false
+			continue
+		}
+		if !st.HasTag(tagk) {
+			addStatus(st, fmt.Sprintf("endpoint_source: no tag %s", tagk))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateCatchAlls to check catch-all strategies, at most one for each file
+// 同一文件有多个时保留ID最小的, 其余不加载, 原因写入Status
+func validateCatchAlls(strategys []*scheme.Strategy) {
+	max := 0
+	if g.Conf() != nil {
+		max = g.Conf().Worker.MaxStrategiesPerFile
+	}
+	validateCatchAllShards(strategys, max)
+
+	byFile := make(map[string]*scheme.Strategy)
+	for _, st := range strategys {
+		if !st.CatchAll || !st.ParseSucc {
+			continue
+		}
+		if st.Pattern != "" || st.ValueField != "" {
+			addStatus(st, "catch_all strategy should not have pattern or value_field")
+			st.ParseSucc = false
+			continue
+		}
+		if st.Func != "cnt" {
+			addStatus(st, fmt.Sprintf("func %s of catch_all strategy should be cnt", st.Func))
+			st.ParseSucc = false
+			continue
+		}
+		if kept, ok := byFile[st.FilePath]; !ok || st.ID < kept.ID {
+			byFile[st.FilePath] = st
+		}
+	}
+	for _, st := range strategys {
+		if kept, ok := byFile[st.FilePath]; st.CatchAll && st.ParseSucc && ok && kept != st {
+			addStatus(st, fmt.Sprintf("more than one catch_all strategy for %s, strategy %d is used", st.FilePath, kept.ID))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateCatchAllShards to reject catch-all strategies of files split into shards by max_strategies_per_file
+// 其他shard的策略在另一个group中判断, catch-all无法知道行是否被它们匹配
+func validateCatchAllShards(strategys []*scheme.Strategy, max int) {
+	if max <= 0 {
+		return
+	}
+	fileCount := make(map[string]int)
+	for _, st := range strategys {
+		fileCount[st.FilePath]++
+	}
+	for _, st := range strategys {
+		if st.CatchAll && st.ParseSucc && fileCount[st.FilePath] > max {
+			addStatus(st, fmt.Sprintf("catch_all is not supported for %s split by max_strategies_per_file %d", st.FilePath, max))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateExcludeRatios to warn about emit_exclude_ratio without exclude, the ratio is never pushed
+func validateExcludeRatios(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		if st.EmitExcludeRatio && st.ParseSucc && st.ExcludeReg == nil {
+			warning := "emit_exclude_ratio without exclude, exclude_ratio is not pushed"
+			dlog.Warningf("%s [sid:%d]", warning, st.ID)
+			st.Warnings = append(st.Warnings, warning)
+		}
+	}
+}
+
+// validateWindows to parse window_slide of sliding windows, a slide not dividing step is not loaded
+func validateWindows(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		st.WindowSlide = 0
+		switch st.WindowType {
+		case "", scheme.WindowTumbling:
+			continue
+		case scheme.WindowSliding:
+		default:
+			addStatus(st, fmt.Sprintf("unknown window_type %q, should be tumbling or sliding", st.WindowType))
+			st.ParseSucc = false
+			continue
+		}
+		slide, err := time.ParseDuration(st.WindowSlideSpec)
+		if err != nil {
+			addStatus(st, fmt.Sprintf("bad window_slide %q of sliding window: %v", st.WindowSlideSpec, err))
+			st.ParseSucc = false
+			continue
+		}
+		secs := int64(slide / time.Second)
+		if slide%time.Second != 0 || secs <= 0 || secs > st.Interval || st.Interval%secs != 0 {
+			addStatus(st, fmt.Sprintf("window_slide %s should be whole seconds dividing step %d", st.WindowSlideSpec, st.Interval))
+			st.ParseSucc = false
+			continue
+		}
+		if st.Func == scheme.FuncEpisodes || len(st.CompositeOf) > 0 {
+			addStatus(st, "sliding window does not support episodes or composite strategies")
+			st.ParseSucc = false
+			continue
+		}
+		st.WindowSlide = slide
+	}
+}
+
+// validateTimestampPrecisions to reject unknown timestamp_precision
+func validateTimestampPrecisions(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		switch st.TimestampPrecision {
+		case "", scheme.TimestampSecond, scheme.TimestampMillisecond, scheme.TimestampNanosecond:
+		default:
+			addStatus(st, fmt.Sprintf("unknown timestamp_precision %q, should be second, millisecond or nanosecond", st.TimestampPrecision))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateRetireAts to parse retire_at, an empty one means not retired and a bad one is not loaded
+func validateRetireAts(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		st.RetireAt = time.Time{}
+		if st.RetireAtSpec == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, st.RetireAtSpec)
+		if err != nil {
+			addStatus(st, fmt.Sprintf("bad retire_at %q, should be RFC 3339: %v", st.RetireAtSpec, err))
+			st.ParseSucc = false
+			continue
+		}
+		st.RetireAt = at
+	}
+}
+
+// validateWriteBackPaths to confine write_back_path to write_back.dir, 绝对路径及含..的路径不加载
+func validateWriteBackPaths(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		p := st.WriteBackPath
+		if p == "" {
+			continue
+		}
+		if filepath.IsAbs(p) || p != filepath.Clean(p) || p == "." || hasDotDot(p) {
+			addStatus(st, fmt.Sprintf("write_back_path %q should be a clean relative path under write_back.dir", p))
+			st.ParseSucc = false
+		}
+	}
+}
+
+func hasDotDot(p string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(p), "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// validateValueMaps to check mode and entries of value_map
+// sum对不使用取值的func(cnt、episodes)没有意义, 不加载
+func validateValueMaps(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		m := st.ValueMap
+		if m == nil || !st.ParseSucc {
+			continue
+		}
+		if len(m.Entries) == 0 {
+			addStatus(st, "value_map: entries is empty")
+			st.ParseSucc = false
+		}
+		for i, e := range m.Entries {
+			if math.IsNaN(e.Value) || math.IsInf(e.Value, 0) {
+				addStatus(st, fmt.Sprintf("value_map: value %v of entries[%d] is not finite", e.Value, i))
+				st.ParseSucc = false
+			}
+		}
+		switch m.Mode {
+		case "", scheme.ValueMapFirstMatch, scheme.ValueMapMaxValue, scheme.ValueMapMinValue:
+		case scheme.ValueMapSum:
+			if st.Func == "cnt" || st.Func == scheme.FuncEpisodes {
+				addStatus(st, fmt.Sprintf("value_map: mode sum makes no sense with func %s, which ignores values", st.Func))
+				st.ParseSucc = false
+			}
+		default:
+			addStatus(st, fmt.Sprintf("value_map: unknown mode %q, should be first_match, max_value, min_value or sum", m.Mode))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateMetricTypes to reject unknown metric_type, and delta with func ignoring values
+func validateMetricTypes(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		switch st.MetricType {
+		case "":
+		case scheme.MetricTypeDelta:
+			if st.Func == "cnt" || st.Func == scheme.FuncEpisodes || st.CatchAll {
+				addStatus(st, fmt.Sprintf("metric_type delta makes no sense with func %s, which ignores values", st.Func))
+				st.ParseSucc = false
+			}
+		default:
+			addStatus(st, fmt.Sprintf("unknown metric_type %q, should be empty or delta", st.MetricType))
+			st.ParseSucc = false
+		}
+	}
+}
+
+// validateValueTiers to check tiers of value_tier
+// 上界严格递增, label不重复, 只有最后一档可以不写上界; catch_all只计数行, 没有取值可分档
+func validateValueTiers(strategys []*scheme.Strategy) {
+	for _, st := range strategys {
+		t := st.ValueTier
+		if t == nil || !st.ParseSucc {
+			continue
+		}
+		if st.CatchAll {
+			addStatus(st, "value_tier: catch_all strategy only counts lines and has no value to tier")
+			st.ParseSucc = false
+			continue
+		}
+		if len(t.Tiers) == 0 {
+			addStatus(st, "value_tier: tiers is empty")
+			st.ParseSucc = false
+			continue
+		}
+		if st.HasTag(t.TagName()) {
+			addStatus(st, fmt.Sprintf("value_tier: tag %s is also extracted from the line", t.TagName()))
+			st.ParseSucc = false
+		}
+		labels := make(map[string]bool, len(t.Tiers))
+		var last *float64
+		for i, item := range t.Tiers {
+			if item.Label == "" {
+				addStatus(st, fmt.Sprintf("value_tier: label of tiers[%d] is empty", i))
+				st.ParseSucc = false
+			} else if labels[item.Label] {
+				addStatus(st, fmt.Sprintf("value_tier: label %s is reduplicated", item.Label))
+				st.ParseSucc = false
+			}
+			labels[item.Label] = true
+
+			b := item.UpperBound
+			if b == nil {
+				if i != len(t.Tiers)-1 {
+					addStatus(st, fmt.Sprintf("value_tier: only the last tier can omit upper_bound, tiers[%d] does not have one", i))
+					st.ParseSucc = false
+				}
+				continue
+			}
+			if math.IsNaN(*b) || math.IsInf(*b, 0) {
+				addStatus(st, fmt.Sprintf("value_tier: upper_bound %v of tiers[%d] is not finite", *b, i))
+				st.ParseSucc = false
+				continue
+			}
+			if last != nil && *b <= *last {
+				addStatus(st, fmt.Sprintf("value_tier: upper_bound %v of tiers[%d] is not greater than the previous %v", *b, i, *last))
+				st.ParseSucc = false
+			}
+			last = b
+		}
+	}
+}
+
+// unboundedCapture to check whether the first capture group can match input of any length
+// 只检查组内顶层的 *、+、{n,} 是否作用于宽泛的字符类(., \S, [^x]等), 如(.*)、(\S+); (\w+)、([0-9]+)不算
+func unboundedCapture(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	group := findCapture(re, 1)
+	if group == nil || len(group.Sub) == 0 {
+		return false
+	}
+	body := group.Sub[0]
+	subs := []*syntax.Regexp{body}
+	if body.Op == syntax.OpConcat {
+		subs = body.Sub
+	}
+	for _, sub := range subs {
+		unbounded := sub.Op == syntax.OpStar || sub.Op == syntax.OpPlus || (sub.Op == syntax.OpRepeat && sub.Max == -1)
+		if unbounded && broadClass(sub.Sub[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func findCapture(re *syntax.Regexp, index int) *syntax.Regexp {
+	if re.Op == syntax.OpCapture && re.Cap == index {
+		return re
+	}
+	for _, sub := range re.Sub {
+		if c := findCapture(sub, index); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// broadClass to check whether the regexp matches most runes, such as . or a negated class
+func broadClass(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpCharClass:
+		n := 0
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			n += int(re.Rune[i+1]-re.Rune[i]) + 1
+		}
+		return n >= 1<<16
+	}
+	return false
+}
+
+// regexpFlags 支持的正则标志
+const regexpFlags = "ims"
+
+// withRegexpFlags to prepend flags to the pattern, e.g. pattern with flags "is" => (?is)pattern
+func withRegexpFlags(pat, flags string) (string, error) {
+	for _, f := range flags {
+		if !strings.ContainsRune(regexpFlags, f) {
+			return "", fmt.Errorf("unknown regexp flag %q in %q, only i, m, s supported", f, flags)
+		}
+	}
+	if flags == "" || pat == "" {
+		return pat, nil
+	}
+	return "(?" + flags + ")" + pat, nil
+}
+
+// CheckResult is the validation result of one strategy
+type CheckResult struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	FilePath  string `json:"file_path"`
+	Step      int64  `json:"step"`
+	ParseSucc bool   `json:"parse_succ"`
+	Status    string `json:"status,omitempty"`
+
+	FileAccess string `json:"file_access,omitempty"` //文件打不开时的分类, 如permission_denied
+	FileError  string `json:"file_error,omitempty"`
+
+	LineLengths       *metric.LineLengthStat `json:"line_lengths,omitempty"`       //文件末尾采样的行长分布
+	TruncationWarning string                 `json:"truncation_warning,omitempty"` //采样的p99行长超过max_line_bytes时的提示
+}
+
+// checkSampleBytes --check时每个文件采样末尾的字节数
+const checkSampleBytes = 4 * 1024 * 1024
+
+// checkLineLimit to get max_line_bytes, the default is the same as worker.DefaultMaxLineBytes
+func checkLineLimit() int64 {
+	if g.Conf() != nil && g.Conf().Worker.MaxLineBytes > 0 {
+		return int64(g.Conf().Worker.MaxLineBytes)
+	}
+	return 64 * 1024
+}
+
+// CheckReport to load strategies and report validation results, used by --check
+func CheckReport() ([]*CheckResult, error) {
+	strategys, err := GetAllStrategies()
+	if err != nil {
+		return nil, err
+	}
+	parsePattern(strategys)
+	updateRegs(strategys)
+
+	ret := make([]*CheckResult, 0, len(strategys))
+	for _, st := range strategys {
+		status := st.Status
+		if !st.ParseSucc && status == "" {
+			status = "parse failed, see log for detail"
+		}
+		r := &CheckResult{
+			ID:        st.ID,
+			Name:      st.Name,
+			FilePath:  st.FilePath,
+			Step:      st.Interval,
+			ParseSucc: st.ParseSucc,
+			Status:    status,
+		}
+		if !reader.IsOTLPPath(st.FilePath) {
+			path := reader.GetCurrentPath(st.FilePath)
+			if err := reader.CheckOpen(path); err != nil {
+				r.FileAccess = reader.ClassifyOpenError(err)
+				r.FileError = err.Error()
+			} else if stat, err := reader.SampleLineLengths(path, checkSampleBytes); err == nil && stat.Lines > 0 {
+				r.LineLengths = &stat
+				r.TruncationWarning = stat.TruncationWarning(st.FilePath, checkLineLimit())
+			}
+		}
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+// resolveValueGroup to resolve value_group to index against the compiled pattern
+// 未配置时沿用第1组; 配置了但pattern没有对应的组时拒绝加载
+func resolveValueGroup(st *scheme.Strategy) error {
+	st.ValueIndex = 1
+	if st.ValueGroup == "" {
+		return nil
+	}
+	if st.PatternReg == nil {
+		return fmt.Errorf("value_group %s set without pattern", st.ValueGroup)
+	}
+	if index, err := strconv.Atoi(string(st.ValueGroup)); err == nil {
+		if index < 1 || index > st.PatternReg.NumSubexp() {
+			return fmt.Errorf("value_group %d out of range, pattern has %d groups", index, st.PatternReg.NumSubexp())
+		}
+		st.ValueIndex = index
+		return nil
+	}
+	for i, name := range st.PatternReg.SubexpNames() {
+		if i > 0 && name == string(st.ValueGroup) {
+			st.ValueIndex = i
+			return nil
+		}
+	}
+	return fmt.Errorf("value_group %s not found in pattern", st.ValueGroup)
+}
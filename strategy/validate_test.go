@@ -0,0 +1,678 @@
+package strategy
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestCheckStep(t *testing.T) {
+	cases := []struct {
+		step, cycle int64
+		ok          bool
+	}{
+		{60, 60, true},
+		{60, 10, true},
+		{60, 1, true},
+		{10, 1, true},
+		{120, 60, true},
+		{30, 60, false}, //小于推送周期
+		{45, 10, false}, //不是整数倍
+		{0, 10, false},
+		{-10, 10, false},
+		{10, 0, true},
+	}
+	for _, c := range cases {
+		err := CheckStep(c.step, c.cycle)
+		if (err == nil) != c.ok {
+			t.Errorf("CheckStep(%d, %d) = %v, want ok %v", c.step, c.cycle, err, c.ok)
+		}
+	}
+}
+
+func TestValidateStepPolicy(t *testing.T) {
+	cases := []struct {
+		policy    string
+		step      int64
+		cycle     int64
+		wantStep  int64
+		wantSucc  bool
+		wantClean bool
+	}{
+		{StepPolicyReject, 60, 10, 60, true, true},
+		{StepPolicyReject, 30, 60, 30, false, false},
+		{StepPolicyReject, 45, 10, 45, false, false},
+		{StepPolicyClamp, 60, 10, 60, true, true},
+		{StepPolicyClamp, 30, 60, 60, true, false},
+		{StepPolicyClamp, 45, 10, 50, true, false},
+		{StepPolicyClamp, 0, 10, 10, true, false},
+		{"", 30, 60, 30, false, false},
+	}
+	for _, c := range cases {
+		st := &scheme.Strategy{ID: 1, Interval: c.step, ParseSucc: true}
+		validateStep(st, c.cycle, c.policy)
+		if st.Interval != c.wantStep || st.ParseSucc != c.wantSucc {
+			t.Errorf("policy %q step %d cycle %d: got step %d succ %v, want step %d succ %v",
+				c.policy, c.step, c.cycle, st.Interval, st.ParseSucc, c.wantStep, c.wantSucc)
+		}
+		if c.wantClean != (st.Status == "") {
+			t.Errorf("policy %q step %d cycle %d: unexpected status %q", c.policy, c.step, c.cycle, st.Status)
+		}
+		if !c.wantClean && !strings.HasPrefix(st.Status, StatusStepIncompatible) {
+			t.Errorf("status should start with %q, got %q", StatusStepIncompatible, st.Status)
+		}
+	}
+}
+
+func TestResolveValueGroup(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		group     string
+		wantIndex int
+		wantSucc  bool
+	}{
+		{`cost=(\d+)`, "", 1, true},
+		{`(GET|POST) /api \S+ (\d+)ms`, "2", 2, true},
+		{`(GET|POST) (?P<path>\S+) (?P<cost>\d+)ms`, "cost", 3, true},
+		{`(GET|POST) /api (\d+)ms`, "3", 0, false},
+		{`(GET|POST) /api (\d+)ms`, "0", 0, false},
+		{`(GET|POST) /api (?P<cost>\d+)ms`, "latency", 0, false},
+	}
+	for _, c := range cases {
+		st := &scheme.Strategy{
+			ID:         1,
+			TimeFormat: "yyyy-mm-dd HH:MM:SS",
+			Pattern:    c.pattern,
+			Interval:   60,
+			ValueGroup: scheme.ValueGroup(c.group),
+		}
+		updateRegs([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("pattern %s group %q: succ %v, want %v (%s)", c.pattern, c.group, st.ParseSucc, c.wantSucc, st.Status)
+			continue
+		}
+		if c.wantSucc && st.ValueIndex != c.wantIndex {
+			t.Errorf("pattern %s group %q: index %d, want %d", c.pattern, c.group, st.ValueIndex, c.wantIndex)
+		}
+		if !c.wantSucc && !strings.Contains(st.Status, "value_group") {
+			t.Errorf("pattern %s group %q: status should explain value_group, got %q", c.pattern, c.group, st.Status)
+		}
+	}
+
+	// json中序号和名字都可以
+	var st scheme.Strategy
+	if err := json.Unmarshal([]byte(`{"value_group": 2}`), &st); err != nil || st.ValueGroup != "2" {
+		t.Errorf("numeric value_group: %q %v", st.ValueGroup, err)
+	}
+	if err := json.Unmarshal([]byte(`{"value_group": "cost"}`), &st); err != nil || st.ValueGroup != "cost" {
+		t.Errorf("named value_group: %q %v", st.ValueGroup, err)
+	}
+	if bs, _ := json.Marshal(scheme.Strategy{ValueGroup: "2"}); !strings.Contains(string(bs), `"value_group":2`) {
+		t.Errorf("index should be marshaled as number: %s", bs)
+	}
+}
+
+func TestRegexpFlags(t *testing.T) {
+	cases := []struct {
+		patternFlags, timeFlags string
+		line                    string
+		wantSucc, wantMatch     bool
+	}{
+		{"", "", "ERROR: cost=12", true, false},
+		{"i", "", "ERROR: cost=12", true, true},
+		{"is", "m", "ERROR:\ncost=12", true, true},
+		{"i", "", "ERROR:\ncost=12", true, false}, //没有s时.不匹配换行
+		{"x", "", "", false, false},
+		{"", "iU", "", false, false},
+	}
+	for _, c := range cases {
+		st := &scheme.Strategy{
+			ID:              1,
+			TimeFormat:      "yyyy-mm-dd HH:MM:SS",
+			Pattern:         `error:.cost=(\d+)`,
+			Interval:        60,
+			PatternRegFlags: c.patternFlags,
+			TimeRegFlags:    c.timeFlags,
+		}
+		updateRegs([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("flags %q/%q: succ %v, want %v (%s)", c.patternFlags, c.timeFlags, st.ParseSucc, c.wantSucc, st.Status)
+			continue
+		}
+		if !c.wantSucc {
+			if !strings.Contains(st.Status, "reg_flags") {
+				t.Errorf("flags %q/%q: status should explain flags, got %q", c.patternFlags, c.timeFlags, st.Status)
+			}
+			continue
+		}
+		if got := st.PatternReg.MatchString(c.line); got != c.wantMatch {
+			t.Errorf("flags %q: match %q = %v, want %v", c.patternFlags, c.line, got, c.wantMatch)
+		}
+		if c.timeFlags != "" && !strings.HasPrefix(st.TimeReg.String(), "(?"+c.timeFlags+")") {
+			t.Errorf("time flags not applied: %s", st.TimeReg)
+		}
+	}
+}
+
+func TestValidateValueRanges(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	cases := []struct {
+		r        *scheme.ValueRange
+		wantSucc bool
+	}{
+		{&scheme.ValueRange{Min: f(0), Max: f(100)}, true},
+		{&scheme.ValueRange{Min: f(5), Max: f(5), OnOutOfRange: scheme.OutOfRangeClamp}, true},
+		{&scheme.ValueRange{Max: f(-1), OnOutOfRange: scheme.OutOfRangeKeep}, true},
+		{&scheme.ValueRange{Min: f(10), Max: f(1)}, false},
+		{&scheme.ValueRange{Min: f(math.Inf(-1))}, false},
+		{&scheme.ValueRange{Max: f(math.NaN())}, false},
+		{&scheme.ValueRange{Min: f(0), OnOutOfRange: "ignore"}, false},
+	}
+	for _, c := range cases {
+		st := &scheme.Strategy{ID: 1, ParseSucc: true, ValueRange: c.r}
+		validateValueRanges([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("range %+v: succ %v, want %v (%s)", c.r, st.ParseSucc, c.wantSucc, st.Status)
+		}
+		if !c.wantSucc && !strings.HasPrefix(st.Status, "value_range: ") {
+			t.Errorf("range %+v: status should explain, got %q", c.r, st.Status)
+		}
+	}
+}
+
+func TestValidateEpisodes(t *testing.T) {
+	for _, c := range []struct {
+		gap      int64
+		wantSucc bool
+	}{{30, true}, {0, false}, {-1, false}} {
+		st := &scheme.Strategy{ID: 1, ParseSucc: true, Func: scheme.FuncEpisodes, GapSeconds: c.gap}
+		validateEpisodes([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("gap %d: succ %v, want %v (%s)", c.gap, st.ParseSucc, c.wantSucc, st.Status)
+		}
+	}
+}
+
+func TestUnboundedCapture(t *testing.T) {
+	cases := map[string]bool{
+		`user=(.*)`:              true,
+		`user=(\S+) `:            true,
+		`ua="([^"]+)"`:           true,
+		`msg:(.+)$`:              true,
+		`id=(x.{3,})`:            true,
+		`user=(\w+)`:             false,
+		`code=([0-9]+)`:          false,
+		`user=(.{1,128})`:        false,
+		`no capture .*`:          false,
+		`(?:skip .*) then (\d+)`: false,
+		`bad regexp (`:           false,
+	}
+	for pattern, want := range cases {
+		if got := unboundedCapture(pattern); got != want {
+			t.Errorf("unboundedCapture(%q) = %v, want %v", pattern, got, want)
+		}
+	}
+}
+
+func TestValidateTagLimits(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, ParseSucc: true, Tags: map[string]string{
+		"user": `user=(\S+)`,
+		"code": `code=(\d+)`,
+	}}
+	validateTagLimits([]*scheme.Strategy{st})
+	if !st.ParseSucc || st.Status != "" {
+		t.Fatalf("unbounded capture should only warn, got succ %v status %q", st.ParseSucc, st.Status)
+	}
+	if len(st.Warnings) != 1 || !strings.HasPrefix(st.Warnings[0], "tag user:") {
+		t.Errorf("warnings %v, want one for tag user", st.Warnings)
+	}
+
+	for _, c := range []struct {
+		l        *scheme.TagLimit
+		wantSucc bool
+	}{
+		{&scheme.TagLimit{MaxLen: 64}, true},
+		{&scheme.TagLimit{MaxLen: 64, OnOversize: scheme.OversizeDrop}, true},
+		{&scheme.TagLimit{MaxLen: -1}, false},
+		{&scheme.TagLimit{OnOversize: "ignore"}, false},
+	} {
+		st := &scheme.Strategy{ID: 1, ParseSucc: true,
+			Tags:      map[string]string{"code": `code=(\d+)`},
+			TagLimits: map[string]*scheme.TagLimit{"code": c.l}}
+		validateTagLimits([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("limit %+v: succ %v, want %v (%s)", c.l, st.ParseSucc, c.wantSucc, st.Status)
+		}
+	}
+}
+
+func TestCompileMaskPatterns(t *testing.T) {
+	for _, c := range []struct {
+		masks    []scheme.MaskPattern
+		wantSucc bool
+	}{
+		{nil, true},
+		{[]scheme.MaskPattern{{Regex: `\d{3}-\d{2}-\d{4}`, Replacement: "[SSN]"}}, true},
+		{[]scheme.MaskPattern{{Regex: `(\d+`}}, false},
+		{[]scheme.MaskPattern{{Replacement: "x"}}, false},
+	} {
+		st := &scheme.Strategy{ID: 1, TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `cost=(\d+)`, Interval: 60, MaskPatterns: c.masks}
+		updateRegs([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("masks %+v: succ %v, want %v (%s)", c.masks, st.ParseSucc, c.wantSucc, st.Status)
+			continue
+		}
+		if !c.wantSucc && !strings.HasPrefix(st.Status, "mask_patterns[0]") {
+			t.Errorf("status should point at the mask, got %q", st.Status)
+		}
+		if c.wantSucc && len(c.masks) > 0 && st.MaskLine("id 123-45-6789") != "id [SSN]" {
+			t.Errorf("mask not compiled: %q", st.MaskLine("id 123-45-6789"))
+		}
+	}
+}
+
+func TestValidateNoises(t *testing.T) {
+	one, zero := 1.0, 0.0
+	for _, c := range []struct {
+		fn       string
+		noise    *scheme.Noise
+		wantSucc bool
+	}{
+		{"cnt", nil, true},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: 0.5, Sensitivity: &one}, true},
+		{"sum", &scheme.Noise{Mechanism: "laplace", Epsilon: 2, Sensitivity: &one}, true},
+		{"avg", &scheme.Noise{Mechanism: "laplace", Epsilon: 0.5, Sensitivity: &one}, false},
+		{"max", &scheme.Noise{Mechanism: "laplace", Epsilon: 0.5, Sensitivity: &one}, false},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: 0, Sensitivity: &one}, false},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: -1, Sensitivity: &one}, false},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: math.NaN(), Sensitivity: &one}, false},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: 0.5}, false},
+		{"cnt", &scheme.Noise{Mechanism: "laplace", Epsilon: 0.5, Sensitivity: &zero}, false},
+		{"cnt", &scheme.Noise{Mechanism: "gaussian", Epsilon: 0.5, Sensitivity: &one}, false},
+	} {
+		st := &scheme.Strategy{ID: 1, Func: c.fn, ParseSucc: true, Noise: c.noise}
+		validateNoises([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("func %s noise %+v: succ %v, want %v (%s)", c.fn, c.noise, st.ParseSucc, c.wantSucc, st.Status)
+		}
+		if !c.wantSucc && !strings.HasPrefix(st.Status, "noise:") {
+			t.Errorf("status should explain noise, got %q", st.Status)
+		}
+	}
+}
+
+func TestCompileMustNotContain(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `GET (\d+)`, Interval: 60,
+		MustNotContain: []string{"traceid=", `trace_id=[0-9a-f]{16}`, "a.b"}}
+	updateRegs([]*scheme.Strategy{st})
+	if !st.ParseSucc {
+		t.Fatalf("status %q", st.Status)
+	}
+	if len(st.MustNotContainLits) != 1 || st.MustNotContainLits[0] != "traceid=" || len(st.MustNotContainRegs) != 2 {
+		t.Errorf("literals %v regexes %v, want 1 literal and 2 regexes", st.MustNotContainLits, st.MustNotContainRegs)
+	}
+
+	for _, clauses := range [][]string{{""}, {"traceid=", "(a"}} {
+		st := &scheme.Strategy{ID: 1, TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `GET (\d+)`, Interval: 60, MustNotContain: clauses}
+		updateRegs([]*scheme.Strategy{st})
+		if st.ParseSucc || !strings.HasPrefix(st.Status, "must_not_contain[") {
+			t.Errorf("clauses %q: succ %v status %q, want rejected", clauses, st.ParseSucc, st.Status)
+		}
+	}
+}
+
+func TestTagTypes(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `GET`, Interval: 60,
+		Tags:     map[string]string{"code": `code=(\d+)`, "dst": scheme.TagTypePatterns[scheme.TagTypeIPv4]},
+		TagTypes: map[string]string{"src": "ipv6", "dst": "ipv4", "mac": "mac", "req": "uuid"}}
+	updateRegs([]*scheme.Strategy{st})
+	if !st.ParseSucc {
+		t.Fatalf("status %q", st.Status)
+	}
+	if len(st.TagRegs) != 5 || len(st.Warnings) != 0 {
+		t.Errorf("tag regs %v warnings %v", st.TagRegs, st.Warnings)
+	}
+	line := "2018-01-01 12:00:01 GET src=[2001:db8::8a2e:370:7334]:443 dst=10.0.0.1. mac=00:1A:2b:3c:4d:5e req=123e4567-e89b-12d3-a456-426614174000 code=200"
+	want := map[string]string{"src": "2001:db8::8a2e:370:7334", "dst": "10.0.0.1", "mac": "00:1A:2b:3c:4d:5e",
+		"req": "123e4567-e89b-12d3-a456-426614174000", "code": "200"}
+	for k, v := range want {
+		if got := st.TagRegs[k].FindStringSubmatch(line); len(got) < 2 || got[1] != v {
+			t.Errorf("tag %s: got %q, want %q", k, got, v)
+		}
+	}
+
+	for _, c := range []struct {
+		tags, types map[string]string
+	}{
+		{nil, map[string]string{"src": "ipv5"}},
+		{map[string]string{"src": `src=(\S+)`}, map[string]string{"src": "ipv6"}},
+	} {
+		st := &scheme.Strategy{ID: 1, TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: `GET`, Interval: 60, Tags: c.tags, TagTypes: c.types}
+		updateRegs([]*scheme.Strategy{st})
+		if st.ParseSucc || !strings.HasPrefix(st.Status, "tag_types[src]") {
+			t.Errorf("tags %v types %v: succ %v status %q, want rejected", c.tags, c.types, st.ParseSucc, st.Status)
+		}
+	}
+}
+
+func TestTagTypeIP(t *testing.T) {
+	ipv6 := regexp.MustCompile(scheme.TagTypePatterns[scheme.TagTypeIPv6])
+	ipv4 := regexp.MustCompile(scheme.TagTypePatterns[scheme.TagTypeIPv4])
+	addrs := []string{
+		"2001:0db8:85a3:0000:0000:8a2e:0370:7334", "2001:db8:85a3::8a2e:370:7334", "::1", "::", "1::", "1:2:3:4:5:6:7::",
+		"1::8", "1:2:3:4:5:6::8", "1::7:8", "1:2:3:4:5::7:8", "1::6:7:8", "1::5:6:7:8", "1::4:5:6:7:8", "1::3:4:5:6:7:8",
+		"::2:3:4:5:6:7:8", "FE80::1", "::ffff:192.0.2.128", "::ffff:0:192.0.2.128", "64:ff9b::192.0.2.33", "::192.0.2.1",
+		"1:2:3:4:5:6:1.2.3.4",
+	}
+	for _, addr := range addrs {
+		if net.ParseIP(addr) == nil {
+			t.Fatalf("bad case %s", addr)
+		}
+		for _, line := range []string{addr, "from " + addr + " port 22", "[" + addr + "]:8080", "ip=" + addr + "."} {
+			if got := ipv6.FindStringSubmatch(line); len(got) < 2 || got[1] != addr {
+				t.Errorf("ipv6 in %q: got %q", line, got)
+			}
+		}
+	}
+	if got := ipv6.FindStringSubmatch("fe80::7:8%eth0 up"); len(got) < 2 || got[1] != "fe80::7:8%eth0" {
+		t.Errorf("ipv6 with zone: got %q", got)
+	}
+	// 不是合法地址的不应匹配出一部分
+	for _, line := range []string{"12:30:45", "1:2:3:4:5:6:7:8:9", "1::2::3", "12345::1", "2001:db8::g", "1.2.3.4", "std::map", "1:2:3:4:5:6:7:8.9"} {
+		if got := ipv6.FindStringSubmatch(line); got != nil {
+			t.Errorf("ipv6 in %q: got %q", line, got[1])
+		}
+	}
+
+	for _, c := range []struct{ line, want string }{
+		{"10.0.0.1", "10.0.0.1"}, {"from 255.255.255.255:80", "255.255.255.255"}, {"ip=192.168.1.20.", "192.168.1.20"},
+		{"1.2.3.4.5", ""}, {"256.1.1.1", ""}, {"01.2.3.4", ""}, {"v1.2.3.4", ""}, {"1.2.3", ""},
+	} {
+		got := ipv4.FindStringSubmatch(c.line)
+		if (c.want == "" && got != nil) || (c.want != "" && (got == nil || got[1] != c.want)) {
+			t.Errorf("ipv4 in %q: got %q, want %q", c.line, got, c.want)
+		}
+	}
+}
+
+func TestTagTypeMACAndUUID(t *testing.T) {
+	mac := regexp.MustCompile(scheme.TagTypePatterns[scheme.TagTypeMAC])
+	uuid := regexp.MustCompile(scheme.TagTypePatterns[scheme.TagTypeUUID])
+	for _, c := range []struct {
+		reg        *regexp.Regexp
+		line, want string
+	}{
+		{mac, "link 00:1a:2b:3c:4d:5e up", "00:1a:2b:3c:4d:5e"},
+		{mac, "hw=00-1A-2B-3C-4D-5E", "00-1A-2B-3C-4D-5E"},
+		{mac, "cisco 001a.2b3c.4d5e.", "001a.2b3c.4d5e"},
+		{mac, "00:1a:2b:3c:4d:5e:6f", ""},
+		{mac, "00:1a-2b:3c:4d:5e", ""},
+		{mac, "2001:db8::1", ""},
+		{uuid, "req=123E4567-E89B-12D3-A456-426614174000", "123E4567-E89B-12D3-A456-426614174000"},
+		{uuid, "{123e4567-e89b-12d3-a456-426614174000}", "123e4567-e89b-12d3-a456-426614174000"},
+		{uuid, "123e4567-e89b-12d3-a456-4266141740001", ""},
+		{uuid, "x123e4567-e89b-12d3-a456-426614174000", ""},
+	} {
+		got := c.reg.FindStringSubmatch(c.line)
+		if (c.want == "" && got != nil) || (c.want != "" && (got == nil || got[1] != c.want)) {
+			t.Errorf("%q: got %q, want %q", c.line, got, c.want)
+		}
+	}
+}
+
+func TestValidateCatchAlls(t *testing.T) {
+	catchAll := func(id int64, file string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: file, TimeFormat: "yyyy-mm-dd HH:MM:SS", Func: "cnt", Interval: 60, CatchAll: true}
+	}
+	a, b, c := catchAll(3, "/var/log/a.log"), catchAll(2, "/var/log/a.log"), catchAll(4, "/var/log/b.log")
+	withPattern := catchAll(5, "/var/log/c.log")
+	withPattern.Pattern = "error"
+	avg := catchAll(6, "/var/log/d.log")
+	avg.Func = "avg"
+	updateRegs([]*scheme.Strategy{a, b, c, withPattern, avg})
+
+	// 同一文件保留ID最小的
+	if !b.ParseSucc || !c.ParseSucc {
+		t.Fatalf("catch-all without pattern should load: %q %q", b.Status, c.Status)
+	}
+	if a.ParseSucc || !strings.Contains(a.Status, "strategy 2 is used") {
+		t.Fatalf("second catch-all of the file loaded: %q", a.Status)
+	}
+	if withPattern.ParseSucc || avg.ParseSucc {
+		t.Fatalf("catch-all with pattern %q or func avg %q loaded", withPattern.Status, avg.Status)
+	}
+
+	// 文件的策略数超过max_strategies_per_file被拆分时不支持catch-all
+	other := &scheme.Strategy{ID: 7, FilePath: "/var/log/b.log", ParseSucc: true}
+	validateCatchAllShards([]*scheme.Strategy{c, other}, 2)
+	if !c.ParseSucc {
+		t.Fatalf("catch-all of a file not split should load: %q", c.Status)
+	}
+	validateCatchAllShards([]*scheme.Strategy{c, other}, 1)
+	if c.ParseSucc || !strings.Contains(c.Status, "max_strategies_per_file") {
+		t.Fatalf("catch-all of a sharded file loaded: %q", c.Status)
+	}
+}
+
+func TestValidateExcludeRatios(t *testing.T) {
+	ratio := func(id int64, exclude string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "GET", Exclude: exclude,
+			Func: "cnt", Interval: 60, EmitExcludeRatio: true}
+	}
+	a, b := ratio(1, "health"), ratio(2, "")
+	updateRegs([]*scheme.Strategy{a, b})
+
+	if !a.ParseSucc || len(a.Warnings) != 0 {
+		t.Fatalf("exclude ratio with exclude: %q %v", a.Status, a.Warnings)
+	}
+	// 没有exclude只提示, 策略照常生效
+	if !b.ParseSucc || len(b.Warnings) != 1 || !strings.Contains(b.Warnings[0], "emit_exclude_ratio") {
+		t.Fatalf("exclude ratio without exclude: %q %v", b.Status, b.Warnings)
+	}
+}
+
+func TestValidateWindows(t *testing.T) {
+	window := func(id int64, typ, slide string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "cost=(\\d+)",
+			Func: "avg", Interval: 60, WindowType: typ, WindowSlideSpec: slide}
+	}
+	ok, tumbling := window(1, scheme.WindowSliding, "15s"), window(2, "", "")
+	bad := []*scheme.Strategy{
+		window(3, scheme.WindowSliding, ""),
+		window(4, scheme.WindowSliding, "7s"),     //不能整除step
+		window(5, scheme.WindowSliding, "1500ms"), //不是整秒
+		window(6, scheme.WindowSliding, "2m"),     //超过step
+		window(7, "hopping", "15s"),
+	}
+	updateRegs(append([]*scheme.Strategy{ok, tumbling}, bad...))
+
+	if !ok.ParseSucc || ok.WindowSlide != 15*time.Second || !ok.Sliding() {
+		t.Fatalf("sliding window not loaded: %q %v", ok.Status, ok.WindowSlide)
+	}
+	if !tumbling.ParseSucc || tumbling.Sliding() {
+		t.Fatalf("tumbling window: %q", tumbling.Status)
+	}
+	for _, st := range bad {
+		if st.ParseSucc {
+			t.Errorf("strategy %d with window_type %q window_slide %q loaded", st.ID, st.WindowType, st.WindowSlideSpec)
+		}
+	}
+}
+
+func TestValidateTimestampPrecisions(t *testing.T) {
+	precision := func(id int64, p string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "cost=(\\d+)",
+			Func: "avg", Interval: 60, TimestampPrecision: p}
+	}
+	sts := []*scheme.Strategy{precision(1, ""), precision(2, scheme.TimestampMillisecond), precision(3, scheme.TimestampNanosecond), precision(4, "microsecond")}
+	updateRegs(sts)
+	for _, st := range sts[:3] {
+		if !st.ParseSucc {
+			t.Errorf("strategy %d with timestamp_precision %q not loaded: %q", st.ID, st.TimestampPrecision, st.Status)
+		}
+	}
+	if sts[3].ParseSucc {
+		t.Errorf("unknown timestamp_precision loaded")
+	}
+}
+
+func TestValidateWriteBackPaths(t *testing.T) {
+	writeBack := func(id int64, p string) *scheme.Strategy {
+		return &scheme.Strategy{ID: id, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "cost=(\\d+)",
+			Func: "avg", Interval: 60, WriteBackPath: p}
+	}
+	good := []*scheme.Strategy{writeBack(1, ""), writeBack(2, "a.log"), writeBack(3, "debug/a.log"), writeBack(4, "a..log")}
+	bad := []*scheme.Strategy{writeBack(5, "/var/log/messages"), writeBack(6, "../a.log"), writeBack(7, "debug/../../a.log"),
+		writeBack(8, "./a.log"), writeBack(9, "."), writeBack(10, "..")}
+	updateRegs(append(good, bad...))
+	for _, st := range good {
+		if !st.ParseSucc {
+			t.Errorf("strategy %d with write_back_path %q not loaded: %q", st.ID, st.WriteBackPath, st.Status)
+		}
+	}
+	for _, st := range bad {
+		if st.ParseSucc {
+			t.Errorf("strategy %d with write_back_path %q loaded", st.ID, st.WriteBackPath)
+		}
+	}
+}
+
+func TestValidateRetireAts(t *testing.T) {
+	var sts []*scheme.Strategy
+	for i, js := range []string{`{}`, `{"retire_at": ""}`, `{"retire_at": "2024-06-01T00:00:00+08:00"}`, `{"retire_at": "2024-06-01"}`} {
+		st := &scheme.Strategy{}
+		if err := json.Unmarshal([]byte(js), st); err != nil {
+			t.Fatalf("unmarshal %s: %v", js, err)
+		}
+		st.ID, st.FilePath, st.TimeFormat, st.Pattern = int64(i+1), "/var/log/a.log", "yyyy-mm-dd HH:MM:SS", "cost=(\\d+)"
+		st.Func, st.Interval = "avg", 60
+		sts = append(sts, st)
+	}
+	updateRegs(sts)
+	for _, st := range sts[:2] {
+		if !st.ParseSucc || !st.RetireAt.IsZero() {
+			t.Errorf("strategy %d without retire_at should be loaded and not retired: %q", st.ID, st.Status)
+		}
+	}
+	if !sts[2].ParseSucc || sts[2].RetireAt.Unix() != 1717171200 {
+		t.Errorf("retire_at not parsed: %v %q", sts[2].RetireAt, sts[2].Status)
+	}
+	if sts[3].ParseSucc {
+		t.Errorf("bad retire_at loaded")
+	}
+	if bs, _ := json.Marshal(sts[0]); strings.Contains(string(bs), "retire_at") {
+		t.Errorf("empty retire_at should be omitted: %s", bs)
+	}
+}
+
+func TestValidateValueMaps(t *testing.T) {
+	entries := []scheme.ValueMapEntry{{Regex: "fatal", Value: 3}, {Regex: "started", Value: 0}}
+	cases := []struct {
+		fn       string
+		m        *scheme.ValueMap
+		wantSucc bool
+	}{
+		{"max", &scheme.ValueMap{Entries: entries}, true},
+		{"max", &scheme.ValueMap{Mode: scheme.ValueMapMaxValue, Entries: entries}, true},
+		{"avg", &scheme.ValueMap{Mode: scheme.ValueMapSum, Entries: entries}, true},
+		{"cnt", &scheme.ValueMap{Mode: scheme.ValueMapMinValue, Entries: entries}, true},
+		{"cnt", &scheme.ValueMap{Mode: scheme.ValueMapSum, Entries: entries}, false},
+		{scheme.FuncEpisodes, &scheme.ValueMap{Mode: scheme.ValueMapSum, Entries: entries}, false},
+		{"max", &scheme.ValueMap{Mode: "last_match", Entries: entries}, false},
+		{"max", &scheme.ValueMap{}, false},
+		{"max", &scheme.ValueMap{Entries: []scheme.ValueMapEntry{{Regex: "fatal", Value: math.Inf(1)}}}, false},
+	}
+	for _, c := range cases {
+		st := &scheme.Strategy{ID: 1, ParseSucc: true, Func: c.fn, GapSeconds: 30, ValueMap: c.m}
+		validateValueMaps([]*scheme.Strategy{st})
+		if st.ParseSucc != c.wantSucc {
+			t.Errorf("func %s value_map %+v: succ %v, want %v (%s)", c.fn, c.m, st.ParseSucc, c.wantSucc, st.Status)
+		}
+		if !c.wantSucc && !strings.HasPrefix(st.Status, "value_map: ") {
+			t.Errorf("func %s value_map %+v: status should explain, got %q", c.fn, c.m, st.Status)
+		}
+	}
+
+	// 条目的正则在加载时编译
+	sts := []*scheme.Strategy{
+		{ID: 1, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "status", Func: "max", Interval: 60,
+			ValueMap: &scheme.ValueMap{Entries: []scheme.ValueMapEntry{{Regex: "fatal", Value: 3}}}},
+		{ID: 2, FilePath: "/var/log/a.log", TimeFormat: "yyyy-mm-dd HH:MM:SS", Pattern: "status", Func: "max", Interval: 60,
+			ValueMap: &scheme.ValueMap{Entries: []scheme.ValueMapEntry{{Regex: "fatal(", Value: 3}}}},
+	}
+	updateRegs(sts)
+	if !sts[0].ParseSucc || sts[0].ValueMap.Entries[0].Reg == nil {
+		t.Errorf("value_map not compiled: %q", sts[0].Status)
+	}
+	if sts[1].ParseSucc || !strings.HasPrefix(sts[1].Status, "value_map.entries[0]: ") {
+		t.Errorf("bad value_map regex should not be loaded: %q", sts[1].Status)
+	}
+}
+
+func TestValidateValueTiers(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	cases := []struct {
+		name     string
+		st       *scheme.Strategy
+		wantSucc bool
+	}{
+		{"ok", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(100), Label: "fast"}, {Label: "slow"}}}}, true},
+		{"no catch-all", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(100), Label: "fast"}, {UpperBound: f(200), Label: "slow"}}}}, true},
+		{"empty", &scheme.Strategy{ValueTier: &scheme.ValueTier{}}, false},
+		{"equal bounds", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(100), Label: "fast"}, {UpperBound: f(100), Label: "slow"}}}}, false},
+		{"decreasing", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(100), Label: "fast"}, {UpperBound: f(50), Label: "slow"}}}}, false},
+		{"duplicated label", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(100), Label: "fast"}, {Label: "fast"}}}}, false},
+		{"empty label", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{{Label: ""}}}}, false},
+		{"catch-all not last", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{Label: "slow"}, {UpperBound: f(100), Label: "fast"}}}}, false},
+		{"inf bound", &scheme.Strategy{ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+			{UpperBound: f(math.Inf(1)), Label: "all"}}}}, false},
+		{"tag collision", &scheme.Strategy{Tags: map[string]string{"tier": "tier=(\\w+)"},
+			ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{{Label: "all"}}}}, false},
+		{"catch_all strategy", &scheme.Strategy{CatchAll: true, Func: "cnt",
+			ValueTier: &scheme.ValueTier{Tiers: []scheme.ValueTierItem{{Label: "all"}}}}, false},
+	}
+	for _, c := range cases {
+		c.st.ID, c.st.ParseSucc = 1, true
+		validateValueTiers([]*scheme.Strategy{c.st})
+		if c.st.ParseSucc != c.wantSucc {
+			t.Errorf("%s: succ %v, want %v (%s)", c.name, c.st.ParseSucc, c.wantSucc, c.st.Status)
+		}
+		if !c.wantSucc && !strings.HasPrefix(c.st.Status, "value_tier: ") {
+			t.Errorf("%s: status should explain, got %q", c.name, c.st.Status)
+		}
+	}
+}
+
+func TestValidateMetricTypes(t *testing.T) {
+	cases := []struct {
+		name     string
+		st       *scheme.Strategy
+		wantSucc bool
+	}{
+		{"gauge", &scheme.Strategy{Func: "cnt"}, true},
+		{"delta", &scheme.Strategy{Func: "sum", MetricType: scheme.MetricTypeDelta}, true},
+		{"unknown", &scheme.Strategy{Func: "sum", MetricType: "counter"}, false},
+		{"delta cnt", &scheme.Strategy{Func: "cnt", MetricType: scheme.MetricTypeDelta}, false},
+		{"delta episodes", &scheme.Strategy{Func: scheme.FuncEpisodes, MetricType: scheme.MetricTypeDelta}, false},
+		{"delta catch_all", &scheme.Strategy{Func: "sum", CatchAll: true, MetricType: scheme.MetricTypeDelta}, false},
+	}
+	for _, c := range cases {
+		c.st.ID, c.st.ParseSucc = 1, true
+		validateMetricTypes([]*scheme.Strategy{c.st})
+		if c.st.ParseSucc != c.wantSucc {
+			t.Errorf("%s: succ %v, want %v (%s)", c.name, c.st.ParseSucc, c.wantSucc, c.st.Status)
+		}
+	}
+}
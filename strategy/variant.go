@@ -0,0 +1,91 @@
+package strategy
+
+import (
+	"fmt"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// inheritVariant to fill fields of variant not set from the primary strategy
+// ID及文件、周期、聚合方式必须和主策略一致, 两者的点才能进入同一个counter, 只用tag区分
+func inheritVariant(st *scheme.Strategy) {
+	v := st.Variant
+	v.ID = st.ID
+	v.FilePath = st.FilePath
+	v.Interval = st.Interval
+	v.Func = st.Func
+	v.Degree = st.Degree
+	v.Variant = nil
+	v.VariantWeight = 0
+	if v.Name == "" {
+		v.Name = st.Name
+	}
+	if v.Description == "" && v.Owner == "" && v.RunbookURL == "" {
+		v.Description, v.Owner, v.RunbookURL = st.Description, st.Owner, st.RunbookURL
+	}
+	if v.TimeFormat == "" {
+		v.TimeFormat, v.TimeRegFlags, v.TimeZone = st.TimeFormat, st.TimeRegFlags, scheme.DeepCopyStringPtr(st.TimeZone)
+	}
+	if v.Pattern == "" && v.Exclude == "" {
+		v.Pattern, v.Exclude = st.Pattern, st.Exclude
+		v.PatternRegFlags = st.PatternRegFlags
+		if v.ValueGroup == "" {
+			v.ValueGroup = st.ValueGroup
+		}
+	}
+	if v.Tags == nil {
+		v.Tags = scheme.DeepCopyStringMap(st.Tags)
+	}
+	if v.OrderedTagExtracts == nil {
+		v.OrderedTagExtracts = scheme.DeepCopyTagExtracts(st.OrderedTagExtracts)
+	}
+	if v.TagFields == nil {
+		v.TagFields = scheme.DeepCopyStringMap(st.TagFields)
+	}
+	if v.ParseMode == "" {
+		v.ParseMode, v.TimeField, v.ValueField = st.ParseMode, st.TimeField, st.ValueField
+	}
+}
+
+// updateVariants to compile variants of strategies
+// variant不合法时主策略照常运行, 所有行都按control计算, 原因写入主策略的Status
+func updateVariants(strategys []*scheme.Strategy) {
+	variants := make([]*scheme.Strategy, 0)
+	for _, st := range strategys {
+		if st.Variant == nil {
+			continue
+		}
+		if st.VariantWeight < 0 || st.VariantWeight > 1 {
+			addStatus(st, fmt.Sprintf("variant_weight %v out of range [0, 1], variant disabled", st.VariantWeight))
+			st.VariantWeight = 0
+		}
+		inheritVariant(st)
+		variants = append(variants, st.Variant)
+	}
+	if len(variants) == 0 {
+		return
+	}
+
+	parsePattern(variants)
+	updateRegs(variants)
+	for _, st := range strategys {
+		if st.Variant == nil || st.Variant.ParseSucc {
+			continue
+		}
+		reason := st.Variant.Status
+		if reason == "" {
+			reason = "parse failed, see log for detail"
+		}
+		addStatus(st, "variant invalid: "+reason)
+	}
+}
+
+// addStatus to append a problem to status of the strategy
+func addStatus(st *scheme.Strategy, status string) {
+	dlog.Errorf("%s [sid:%d]", status, st.ID)
+	if st.Status != "" {
+		status = st.Status + "; " + status
+	}
+	st.Status = status
+}
@@ -0,0 +1,47 @@
+package strategy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestUpdateVariants(t *testing.T) {
+	st := &scheme.Strategy{
+		ID:            1,
+		Name:          "api.latency",
+		FilePath:      "/home/app/log/api.log",
+		TimeFormat:    "yyyy-mm-dd HH:MM:SS",
+		Pattern:       `cost=(\d+)`,
+		Interval:      60,
+		Tags:          map[string]string{"path": `path=(\S+)`},
+		VariantWeight: 0.1,
+		Variant:       &scheme.Strategy{ID: 99, Pattern: `cost=(\d+)ms`, Interval: 10},
+	}
+	updateRegs([]*scheme.Strategy{st})
+	v := st.Variant
+	if !st.ParseSucc || st.Status != "" || !v.ParseSucc {
+		t.Fatalf("strategy and variant should be loaded: %+v %+v", st, v)
+	}
+	if v.ID != st.ID || v.Interval != st.Interval || v.FilePath != st.FilePath || v.TimeReg == nil || v.TagRegs["path"] == nil {
+		t.Errorf("variant should inherit from primary: %+v", v)
+	}
+	if v.PatternReg.String() != `cost=(\d+)ms` {
+		t.Errorf("variant pattern should be its own: %s", v.PatternReg)
+	}
+
+	// variant不合法时主策略照常加载
+	st.Variant = &scheme.Strategy{Pattern: `cost=(\d+`}
+	updateRegs([]*scheme.Strategy{st})
+	if !st.ParseSucc || st.Variant.ParseSucc || !strings.HasPrefix(st.Status, "variant invalid") {
+		t.Errorf("invalid variant should only be reported: %+v", st)
+	}
+
+	st.Variant = &scheme.Strategy{}
+	st.VariantWeight = 1.5
+	updateRegs([]*scheme.Strategy{st})
+	if st.VariantWeight != 0 || !strings.Contains(st.Status, "out of range") {
+		t.Errorf("out of range weight should disable variant: %+v", st)
+	}
+}
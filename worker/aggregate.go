@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"math"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// aggKey is one series of one step
+type aggKey struct {
+	sid       int64
+	tms       int64 //按step对齐后的时间
+	tagstring string
+}
+
+// aggPart is the partial aggregation of a series
+type aggPart struct {
+	st *scheme.Strategy
+	PointCounter
+}
+
+// stepAggregator to accumulate the points of a worker for a step before handing them to counter
+// 同一step内同一序列的点先在worker内合并为cnt/sum/max/min, 推送前由PusherLoop一次合入counter,
+// 结果与逐点Push相同, 但每个序列每个step只拿一次counter的锁
+// 只有所属worker和PusherLoop访问, 锁几乎没有竞争
+type stepAggregator struct {
+	sync.Mutex
+	gc    *GlobalCounter
+	parts map[aggKey]*aggPart
+}
+
+func newStepAggregator(gc *GlobalCounter) *stepAggregator {
+	return &stepAggregator{
+		gc:    gc,
+		parts: make(map[aggKey]*aggPart),
+	}
+}
+
+// add to accumulate the point
+func (a *stepAggregator) add(st *scheme.Strategy, p *AnalysPoint) {
+	key := aggKey{
+		sid:       p.StrategyID,
+		tms:       AlignStepTms(st.Interval, p.Tms),
+		tagstring: utils.SortedTags(p.Tags),
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	if p.Unmatched {
+		// 补零的点会清零counter中的计数, 结果依赖到达顺序: 先合入之前的值再直接Push
+		if part, ok := a.parts[key]; ok {
+			delete(a.parts, key)
+			a.merge(key, part)
+		}
+		if err := a.gc.Push(p); err != nil {
+			dlog.Errorf("push to counter error [sid:%d]: %v", p.StrategyID, err)
+		}
+		return
+	}
+
+	part, ok := a.parts[key]
+	if !ok {
+		part = &aggPart{st: st, PointCounter: PointCounter{Max: math.NaN(), Min: math.NaN()}}
+		a.parts[key] = part
+	}
+	part.Count++
+	part.Sum += p.Value
+	if math.IsNaN(part.Max) || p.Value > part.Max {
+		part.Max = p.Value
+	}
+	if math.IsNaN(part.Min) || p.Value < part.Min {
+		part.Min = p.Value
+	}
+	part.Gens.add(p.Gen, 1)
+}
+
+func (a *stepAggregator) merge(key aggKey, part *aggPart) {
+	if err := a.gc.Merge(key.sid, key.tms, key.tagstring, &part.PointCounter); err != nil {
+		dlog.Errorf("merge to counter error [sid:%d][tms:%d]: %v", key.sid, key.tms, err)
+		return
+	}
+	metric.MetricAggregated(part.st.FilePath, part.Count)
+}
+
+// flush to hand the steps accepted by ready to counter, nil ready means all
+func (a *stepAggregator) flush(ready func(st *scheme.Strategy, tms int64) bool) {
+	a.Lock()
+	defer a.Unlock()
+	for key, part := range a.parts {
+		if ready == nil || ready(part.st, key.tms) {
+			delete(a.parts, key)
+			a.merge(key, part)
+		}
+	}
+}
+
+// snapshot to copy the series not handed to counter, for preview
+func (a *stepAggregator) snapshot() map[aggKey]*aggPart {
+	a.Lock()
+	defer a.Unlock()
+	ret := make(map[aggKey]*aggPart, len(a.parts))
+	for key, part := range a.parts {
+		ret[key] = &aggPart{st: part.st, PointCounter: part.copy()}
+	}
+	return ret
+}
+
+// pending to get the count of series not handed to counter
+func (a *stepAggregator) pending() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.parts)
+}
+
+var (
+	stepAggregators     = make(map[*stepAggregator]struct{})
+	stepAggregatorsLock sync.Mutex
+)
+
+// stepAggregateEnabled to check worker.step_aggregate
+func stepAggregateEnabled() bool {
+	return g.Conf() != nil && g.Conf().Worker.StepAggregate
+}
+
+func registerStepAggregator(a *stepAggregator) {
+	stepAggregatorsLock.Lock()
+	stepAggregators[a] = struct{}{}
+	stepAggregatorsLock.Unlock()
+}
+
+// unregisterStepAggregator to flush all steps and forget the aggregator, called when the worker quits
+func unregisterStepAggregator(a *stepAggregator) {
+	stepAggregatorsLock.Lock()
+	delete(stepAggregators, a)
+	stepAggregatorsLock.Unlock()
+	a.flush(nil)
+}
+
+func getStepAggregators() []*stepAggregator {
+	stepAggregatorsLock.Lock()
+	defer stepAggregatorsLock.Unlock()
+	ret := make([]*stepAggregator, 0, len(stepAggregators))
+	for a := range stepAggregators {
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// flushStepAggregators to hand the steps accepted by ready of all workers to counter
+// PusherLoop在判断哪些step可以推送前调用, 保证推送时该step的点都已合入counter
+func flushStepAggregators(ready func(st *scheme.Strategy, tms int64) bool) {
+	for _, a := range getStepAggregators() {
+		a.flush(ready)
+	}
+}
+
+// toCounter to hand the point to the step aggregator of the worker, or to counter directly if disabled
+func (w *Worker) toCounter(st *scheme.Strategy, p *AnalysPoint) {
+	// 先过strategy_max_points(可被boost放大), 再过max_points_per_second
+	if !allowStrategyPoint(st.ID) {
+		metric.MetricLimitedPoint(1)
+		return
+	}
+	// 滑动窗口按slide分桶, 不进counter
+	if st.Sliding() {
+		if l := getLimiter(); l != nil && !l.Allow() {
+			metric.MetricLimitedPoint(1)
+			return
+		}
+		observeSliding(st, p)
+		return
+	}
+	if w.Aggregator == nil || getSink() != nil {
+		toCounter(p, w.Mark)
+		return
+	}
+	if l := getLimiter(); l != nil && !l.Allow() {
+		metric.MetricLimitedPoint(1)
+		return
+	}
+	w.Aggregator.add(st, p)
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func testStrategy(id int64) *scheme.Strategy {
+	return &scheme.Strategy{ID: id, Interval: 10, Func: "cnt"}
+}
+
+// 按step合并后的结果应与逐点Push完全相同
+func TestStepAggregatorDifferential(t *testing.T) {
+	points := testPoints(2, 500)
+
+	want := newTestCounter(4)
+	for _, ps := range points {
+		for _, p := range ps {
+			want.Push(p)
+		}
+	}
+
+	got := newTestCounter(4)
+	for _, ps := range points {
+		a := newStepAggregator(got)
+		for _, p := range ps {
+			a.add(testStrategy(p.StrategyID), p)
+		}
+		a.flush(nil)
+		if n := a.pending(); n != 0 {
+			t.Fatalf("%d series left after flush", n)
+		}
+	}
+
+	if w, g := dumpCounter(want), dumpCounter(got); !reflect.DeepEqual(w, g) {
+		t.Errorf("aggregated counter differs from direct push\nwant %v\ngot  %v", w, g)
+	}
+}
+
+func TestStepAggregatorZeroFillOrder(t *testing.T) {
+	values := []float64{3, 5, -1, 7, -1, -1, 2}
+	point := func(v float64) *AnalysPoint {
+		return &AnalysPoint{StrategyID: 1, Value: v, Tms: 1500000003, Tags: map[string]string{"host": "h1"}}
+	}
+
+	want := newTestCounter(1)
+	for _, v := range values {
+		want.Push(point(v))
+	}
+
+	got := newTestCounter(1)
+	a := newStepAggregator(got)
+	for _, v := range values {
+		a.add(testStrategy(1), point(v))
+	}
+	a.flush(nil)
+
+	if w, g := dumpCounter(want), dumpCounter(got); !reflect.DeepEqual(w, g) {
+		t.Errorf("zero-fill order not kept\nwant %v\ngot  %v", w, g)
+	}
+}
+
+func TestStepAggregatorFlushReady(t *testing.T) {
+	gc := newTestCounter(1)
+	a := newStepAggregator(gc)
+	st := testStrategy(1)
+	for _, tms := range []int64{1500000001, 1500000005, 1500000012} {
+		a.add(st, &AnalysPoint{StrategyID: 1, Value: 1, Tms: tms, Tags: map[string]string{}})
+	}
+
+	// 只合入已结束的step
+	a.flush(func(st *scheme.Strategy, tms int64) bool { return tms < 1500000010 })
+	if n := a.pending(); n != 1 {
+		t.Fatalf("pending %d, want 1", n)
+	}
+	sc, _ := gc.GetStrategyCountByID(1)
+	if tmsList := sc.GetTmsList(); len(tmsList) != 1 || tmsList[0] != 1500000000 {
+		t.Fatalf("counter steps %v, want [1500000000]", tmsList)
+	}
+	pc, _ := sc.GetByTms(1500000000)
+	if p := pc.TagstringMap[""]; p == nil || p.Count != 2 || p.Sum != 2 {
+		t.Errorf("merged %+v, want count 2 sum 2", p)
+	}
+
+	registerStepAggregator(a)
+	unregisterStepAggregator(a)
+	if n := a.pending(); n != 0 {
+		t.Errorf("pending %d after worker quit, want 0", n)
+	}
+}
+
+// BenchmarkStepAggregator 多个worker写同一序列, 对比逐点Push和按step合并
+func BenchmarkStepAggregator(b *testing.B) {
+	st := testStrategy(1)
+	p := &AnalysPoint{StrategyID: 1, Value: 1, Tms: 1500000000, Tags: map[string]string{"host": "h1"}}
+	b.Run("push", func(b *testing.B) {
+		gc := newTestCounter(DefaultCounterShards)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				gc.Push(p)
+			}
+		})
+	})
+	b.Run("aggregate", func(b *testing.B) {
+		gc := newTestCounter(DefaultCounterShards)
+		b.RunParallel(func(pb *testing.PB) {
+			a := newStepAggregator(gc)
+			for pb.Next() {
+				a.add(st, p)
+			}
+			a.flush(nil)
+		})
+	})
+}
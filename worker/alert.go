@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// PanicAlert to tell the on-call that a worker panicked
+type PanicAlert struct {
+	Reason     string `json:"reason"`
+	FilePath   string `json:"file_path"`
+	StrategyID int64  `json:"strategy_id"` //0表示panic时不在处理某个策略
+	Worker     string `json:"worker"`
+	Endpoint   string `json:"endpoint"`
+	Time       int64  `json:"time"`
+}
+
+var (
+	// panicAlertTimeout 告警请求的超时, 不影响worker继续工作
+	panicAlertTimeout = 5 * time.Second
+	// panicAlertInterval 同一文件同一策略的告警间隔, 避免每一行都panic时打爆webhook
+	panicAlertInterval = time.Minute
+
+	panicAlertsLock sync.Mutex
+	panicAlerts     = make(map[string]time.Time)
+)
+
+// renderPanicAlert to build the webhook payload
+// 模板中可以使用.Reason .FilePath .StrategyID .Worker .Endpoint .Time, 以及json函数输出转义后的json字符串,
+// 如slack: {"text": {{json (printf "%s panic: %s" .FilePath .Reason)}}}
+func renderPanicAlert(tmpl string, alert *PanicAlert) ([]byte, error) {
+	if tmpl == "" {
+		return json.Marshal(alert)
+	}
+	t, err := template.New("panic_alert").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			bs, err := json.Marshal(v)
+			return string(bs), err
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("bad panic_webhook_payload_template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, alert); err != nil {
+		return nil, fmt.Errorf("render panic alert: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// allowPanicAlert to check the alert interval of the file and strategy
+func allowPanicAlert(alert *PanicAlert, now time.Time) bool {
+	key := fmt.Sprintf("%s#%d", alert.FilePath, alert.StrategyID)
+	panicAlertsLock.Lock()
+	defer panicAlertsLock.Unlock()
+	if last, ok := panicAlerts[key]; ok && now.Sub(last) < panicAlertInterval {
+		return false
+	}
+	panicAlerts[key] = now
+	return true
+}
+
+// postPanicAlert to post the alert in background
+// fire-and-forget, 失败只打日志; 返回的chan在请求结束后关闭, 仅供测试等待
+func postPanicAlert(url, tmpl string, alert *PanicAlert) <-chan struct{} {
+	done := make(chan struct{})
+	payload, err := renderPanicAlert(tmpl, alert)
+	if err != nil {
+		dlog.Errorf("panic alert not sent [file:%s][sid:%d][err:%v]", alert.FilePath, alert.StrategyID, err)
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		client := &http.Client{Timeout: panicAlertTimeout}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			dlog.Errorf("post panic alert failed [url:%s][err:%v]", url, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			dlog.Errorf("post panic alert failed [url:%s][code:%d]", url, resp.StatusCode)
+		}
+	}()
+	return done
+}
+
+// alertPanic to send the worker panic to the configured webhook
+func (w *Worker) alertPanic(reason interface{}, sid int64) {
+	if g.Conf() == nil || g.Conf().Alerting.PanicWebhookURL == "" {
+		return
+	}
+	conf := g.Conf().Alerting
+	now := time.Now()
+	alert := &PanicAlert{
+		Reason:     fmt.Sprint(reason),
+		FilePath:   w.FilePath,
+		StrategyID: sid,
+		Worker:     w.Mark,
+		Endpoint:   g.Conf().Endpoint,
+		Time:       now.Unix(),
+	}
+	if !allowPanicAlert(alert, now) {
+		return
+	}
+	postPanicAlert(conf.PanicWebhookURL, conf.PanicWebhookPayloadTemplate, alert)
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostPanicAlert(t *testing.T) {
+	bodies := make(chan []byte, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		bodies <- bs
+	}))
+	defer srv.Close()
+
+	alert := &PanicAlert{Reason: `index out of range "3"`, FilePath: "/var/log/a.log", StrategyID: 7, Worker: "w0"}
+	<-postPanicAlert(srv.URL, "", alert)
+	var got PanicAlert
+	if err := json.Unmarshal(<-bodies, &got); err != nil || got != *alert {
+		t.Fatalf("default payload = %+v, err %v", got, err)
+	}
+
+	tmpl := `{"text": {{json (printf "%s [sid:%d] panic: %s" .FilePath .StrategyID .Reason)}}}`
+	<-postPanicAlert(srv.URL, tmpl, alert)
+	var slack map[string]string
+	if err := json.Unmarshal(<-bodies, &slack); err != nil {
+		t.Fatal(err)
+	}
+	if slack["text"] != `/var/log/a.log [sid:7] panic: index out of range "3"` {
+		t.Fatalf("slack payload = %v", slack)
+	}
+
+	// 模板错误不发送
+	<-postPanicAlert(srv.URL, "{{.Nope", alert)
+	select {
+	case bs := <-bodies:
+		t.Fatalf("sent with bad template: %s", bs)
+	default:
+	}
+}
+
+func TestPostPanicAlertTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	old := panicAlertTimeout
+	panicAlertTimeout = 100 * time.Millisecond
+	defer func() { panicAlertTimeout = old }()
+
+	start := time.Now()
+	done := postPanicAlert(srv.URL, "", &PanicAlert{Reason: "x"})
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatal("post blocked the caller")
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("post not timed out")
+	}
+}
+
+func TestAllowPanicAlert(t *testing.T) {
+	now := time.Now()
+	a := &PanicAlert{FilePath: "/var/log/allow.log", StrategyID: 1}
+	b := &PanicAlert{FilePath: "/var/log/allow.log", StrategyID: 2}
+	if !allowPanicAlert(a, now) || !allowPanicAlert(b, now) {
+		t.Fatal("first alert throttled")
+	}
+	if allowPanicAlert(a, now.Add(time.Second)) {
+		t.Fatal("repeated alert not throttled")
+	}
+	if !allowPanicAlert(a, now.Add(panicAlertInterval)) {
+		t.Fatal("alert throttled after interval")
+	}
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"math"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+const (
+	// anomalyWindow 每个策略保留最近的值的个数
+	anomalyWindow = 60
+	// anomalyMinSamples 样本太少时均值、标准差不可信, 不做判断
+	anomalyMinSamples = 10
+	// DefaultAnomalyStddevThreshold 未配置anomaly_stddev_threshold时的阈值
+	DefaultAnomalyStddevThreshold = 3.0
+)
+
+// anomalyDetector to keep a rolling window of recent values of one strategy
+type anomalyDetector struct {
+	sync.Mutex
+	values [anomalyWindow]float64
+	n      int //窗口中的值个数
+	next   int //下一个写入的位置
+}
+
+// check to get Z-score of v against the window, then add v into the window
+// 窗口内所有值相同时标准差为0, 不同的值认为是无穷大的偏离
+func (d *anomalyDetector) check(v float64) (float64, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	var z float64
+	ok := d.n >= anomalyMinSamples
+	if ok {
+		var sum, sq float64
+		for i := 0; i < d.n; i++ {
+			sum += d.values[i]
+		}
+		mean := sum / float64(d.n)
+		for i := 0; i < d.n; i++ {
+			sq += (d.values[i] - mean) * (d.values[i] - mean)
+		}
+		stddev := math.Sqrt(sq / float64(d.n))
+		switch {
+		case stddev > 0:
+			z = math.Abs(v-mean) / stddev
+		case v != mean:
+			z = math.Inf(1)
+		}
+	}
+
+	d.values[d.next] = v
+	d.next = (d.next + 1) % anomalyWindow
+	if d.n < anomalyWindow {
+		d.n++
+	}
+	return z, ok
+}
+
+var (
+	anomalyDetectors     = make(map[int64]*anomalyDetector)
+	anomalyDetectorsLock = new(sync.RWMutex)
+)
+
+func getAnomalyDetector(id int64) *anomalyDetector {
+	anomalyDetectorsLock.RLock()
+	d, ok := anomalyDetectors[id]
+	anomalyDetectorsLock.RUnlock()
+	if ok {
+		return d
+	}
+
+	anomalyDetectorsLock.Lock()
+	defer anomalyDetectorsLock.Unlock()
+	if d, ok = anomalyDetectors[id]; !ok {
+		d = new(anomalyDetector)
+		anomalyDetectors[id] = d
+	}
+	return d
+}
+
+// cleanAnomalyDetectors to drop windows of strategies deleted or no longer detecting
+func cleanAnomalyDetectors(strategyMap map[int64]*scheme.Strategy) {
+	anomalyDetectorsLock.Lock()
+	defer anomalyDetectorsLock.Unlock()
+	for id := range anomalyDetectors {
+		if st, ok := strategyMap[id]; !ok || !st.AnomalyDetect {
+			delete(anomalyDetectors, id)
+		}
+	}
+}
+
+// detectAnomaly to tag the point with anomaly="true" if its value is an outlier
+// 与滚动窗口均值的偏离超过阈值个标准差时认为异常, NaN及没有匹配而补的点不参与计算
+func detectAnomaly(st *scheme.Strategy, point *AnalysPoint) bool {
+	if point.Unmatched || math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+		return false
+	}
+	threshold := st.AnomalyStddevThreshold
+	if threshold <= 0 {
+		threshold = DefaultAnomalyStddevThreshold
+	}
+	z, ok := getAnomalyDetector(st.ID).check(point.Value)
+	if !ok || z <= threshold {
+		return false
+	}
+	point.Tags["anomaly"] = "true"
+	return true
+}
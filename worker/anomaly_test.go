@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"math"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	d := new(anomalyDetector)
+	// 样本不足时不判断
+	for i := 0; i < anomalyMinSamples; i++ {
+		if _, ok := d.check(float64(10 + i%2)); ok {
+			t.Fatalf("should not judge with %d samples", i)
+		}
+	}
+	// 均值10.5, 标准差0.5
+	if z, ok := d.check(12); !ok || math.Abs(z-3) > 1e-9 {
+		t.Fatalf("unexpected z-score %v", z)
+	}
+
+	// 窗口只保留最近的值
+	for i := 0; i < anomalyWindow; i++ {
+		d.check(100)
+	}
+	if z, _ := d.check(100); z != 0 {
+		t.Fatalf("old values should be evicted, z-score %v", z)
+	}
+	if z, _ := d.check(101); !math.IsInf(z, 1) {
+		t.Fatalf("any deviation from constant series should be infinite, got %v", z)
+	}
+}
+
+func TestDetectAnomaly(t *testing.T) {
+	st := &scheme.Strategy{ID: 1001, AnomalyDetect: true, AnomalyStddevThreshold: 2}
+	defer cleanAnomalyDetectors(nil)
+	for i := 0; i < 20; i++ {
+		p := &AnalysPoint{StrategyID: st.ID, Value: float64(10 + i%3), Tags: map[string]string{}}
+		if detectAnomaly(st, p) {
+			t.Fatalf("value %v should not be anomaly", p.Value)
+		}
+	}
+	if detectAnomaly(st, &AnalysPoint{Value: math.NaN(), Tags: map[string]string{}}) {
+		t.Fatal("NaN should be ignored")
+	}
+	// 不匹配的行补的-1不进窗口, 也不会被当作异常
+	for i := 0; i < anomalyWindow; i++ {
+		if detectAnomaly(st, &AnalysPoint{StrategyID: st.ID, Value: -1, Unmatched: true, Tags: map[string]string{}}) {
+			t.Fatal("unmatched fill point should be ignored")
+		}
+	}
+	if detectAnomaly(st, &AnalysPoint{StrategyID: st.ID, Value: 12, Tags: map[string]string{}}) {
+		t.Fatal("window should not contain fill points")
+	}
+	p := &AnalysPoint{StrategyID: st.ID, Value: 1000, Tags: map[string]string{}}
+	if !detectAnomaly(st, p) || p.Tags["anomaly"] != "true" {
+		t.Fatalf("outlier should be tagged, tags %v", p.Tags)
+	}
+
+	cleanAnomalyDetectors(map[int64]*scheme.Strategy{st.ID: {ID: st.ID}})
+	if len(anomalyDetectors) != 0 {
+		t.Fatal("window of strategy not detecting should be dropped")
+	}
+}
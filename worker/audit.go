@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+const (
+	defaultAuditLogPath      = "./log/push_audit.log"
+	defaultAuditLogMaxSizeMB = 100
+	defaultAuditLogQueueSize = 100000
+)
+
+// AuditRecord is one line of the push audit log, one for each point of each push attempt
+type AuditRecord struct {
+	Timestamp  int64   `json:"timestamp"` //推送完成的时间(毫秒)
+	StrategyID int64   `json:"strategy_id,omitempty"`
+	Metric     string  `json:"metric"`
+	PointTms   int64   `json:"point_timestamp"`
+	Value      float64 `json:"value"`
+	Tags       string  `json:"tags"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code"` //请求失败没有响应时为0
+	LatencyMs  int64   `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// AuditLogger to append a record for every pushed point to a rotating file
+// 与write_back共用按大小轮转的文件写入; 审计记录不能丢, 队列满时推送协程等待写入
+type AuditLogger struct {
+	wb *writeBackFile
+}
+
+// NewAuditLogger to create an audit logger writing to path, rotated to path.1 at maxSize bytes
+func NewAuditLogger(path string, maxSize int64, queueSize int) *AuditLogger {
+	return &AuditLogger{wb: newWriteBackFile(path, "", maxSize, queueSize)}
+}
+
+// Record to append the records of one push attempt
+func (a *AuditLogger) Record(points []*FalconPoint, url string, code int, latency time.Duration, err string) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, p := range points {
+		bs, _ := json.Marshal(&AuditRecord{
+			Timestamp:  now,
+			StrategyID: p.StrategyID,
+			Metric:     p.Metric,
+			PointTms:   p.Timestamp,
+			Value:      p.Value,
+			Tags:       p.Tags,
+			URL:        url,
+			StatusCode: code,
+			LatencyMs:  int64(latency / time.Millisecond),
+			Error:      err,
+		})
+		a.wb.queue <- string(bs) + "\n"
+	}
+}
+
+// Close to write the queued records, flush and close the file
+func (a *AuditLogger) Close() {
+	a.wb.close()
+	<-a.wb.exited
+}
+
+var (
+	auditLogger     *AuditLogger
+	auditLoggerOnce sync.Once
+)
+
+// getAuditLogger to get the audit logger, nil if audit_log.enable is false
+func getAuditLogger() *AuditLogger {
+	auditLoggerOnce.Do(func() {
+		if g.Conf() == nil || !g.Conf().AuditLog.Enable {
+			return
+		}
+		c := g.Conf().AuditLog
+		path, maxSize := c.Path, int64(c.MaxSizeMB)
+		if path == "" {
+			path = defaultAuditLogPath
+		}
+		if maxSize <= 0 {
+			maxSize = defaultAuditLogMaxSizeMB
+		}
+		auditLogger = NewAuditLogger(path, maxSize*1024*1024, defaultAuditLogQueueSize)
+		dlog.Infof("push audit log enabled [path:%s][max_size_mb:%d]", path, maxSize)
+	})
+	return auditLogger
+}
+
+// CloseAuditLog to flush the audit log before exit
+func CloseAuditLog() {
+	if a := getAuditLogger(); a != nil {
+		a.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogger(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	a := NewAuditLogger(path, 1024*1024, 100)
+	points := []*FalconPoint{
+		{Metric: "log.api.cost", Timestamp: 100, Value: 12, Tags: "api=/api", StrategyID: 1},
+		{Metric: "log.api.count", Timestamp: 100, Value: 3, Tags: "", StrategyID: 2},
+	}
+	a.Record(points, "http://127.0.0.1:1988/v1/push", 200, 15*time.Millisecond, "")
+	a.Record(points[:1], "http://127.0.0.1:1988/v1/push", 0, time.Second, "connection refused")
+	// Close返回时记录已全部写入文件
+	a.Close()
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 records, got %q", bs)
+	}
+	var r AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.StrategyID != 1 || r.Metric != "log.api.cost" || r.Value != 12 || r.Tags != "api=/api" ||
+		r.StatusCode != 200 || r.LatencyMs != 15 || r.PointTms != 100 || r.Error != "" || r.Timestamp == 0 {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 0 || r.Error != "connection refused" || r.LatencyMs != 1000 {
+		t.Fatalf("unexpected failed record: %+v", r)
+	}
+}
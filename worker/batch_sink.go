@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// sinkWriteError to tell whether a failed write of a payload can be retried
+type sinkWriteError struct {
+	err       error
+	retryable bool
+}
+
+func (e *sinkWriteError) Error() string { return e.err.Error() }
+
+// sinkPayload is an encoded request and the number of points in it
+type sinkPayload struct {
+	body []byte
+	n    int
+}
+
+// batchSink is the queue, batching and retry shared by sinks sending aggregated points in batches
+// 各sink只需提供encode(一批点编码为若干payload)及write(发送一个payload一次);
+// write返回可重试的错误时指数退避重试, 其他错误丢弃该payload并计入log.agent.sink.err.cnt, 发送成功的计数由write负责
+type batchSink struct {
+	BatchSize int //每批最多的点数, <=0时不限, 只按BatchWait攒批
+	BatchWait time.Duration
+
+	name     string //日志及计数的tag
+	target   string //日志中的对端地址
+	queue    chan *AnalysPoint
+	close    chan struct{}
+	closeMux sync.Once
+}
+
+func newBatchSink(name, target string, batchSize, queueSize int) batchSink {
+	if queueSize <= 0 {
+		queueSize = defaultSinkQueueSize
+	}
+	return batchSink{
+		BatchSize: batchSize,
+		BatchWait: sinkBatchWait,
+		name:      name,
+		target:    target,
+		queue:     make(chan *AnalysPoint, queueSize),
+		close:     make(chan struct{}),
+	}
+}
+
+// Send to enqueue an aggregated point, the point is dropped if queue is full
+func (s *batchSink) Send(p *AnalysPoint) bool {
+	select {
+	case s.queue <- p:
+		return true
+	default:
+		metric.MetricSinkDropPoint(1)
+		return false
+	}
+}
+
+// Stop to stop the sink, points in queue are dropped
+func (s *batchSink) Stop() {
+	s.closeMux.Do(func() { close(s.close) })
+}
+
+// run to encode and write batches until stopped
+func (s *batchSink) run(encode func([]*AnalysPoint) []sinkPayload, write func(sinkPayload) error) {
+	for {
+		var first *AnalysPoint
+		select {
+		case <-s.close:
+			return
+		case first = <-s.queue:
+		}
+		for _, p := range encode(s.batch(first)) {
+			if !s.retry(p, write) {
+				return
+			}
+		}
+	}
+}
+
+// retry to write the payload until it is sent or dropped, false if stopped while waiting
+func (s *batchSink) retry(p sinkPayload, write func(sinkPayload) error) bool {
+	for retry := 0; ; retry++ {
+		err := write(p)
+		if err == nil {
+			return true
+		}
+		if e, ok := err.(*sinkWriteError); ok && !e.retryable {
+			dlog.Errorf("%s write rejected, drop batch [target:%s][points:%d][err:%v]", s.name, s.target, p.n, err)
+			metric.MetricSinkError(s.name, int64(p.n))
+			return true
+		}
+		wait := sinkBackoff(retry)
+		dlog.Warningf("%s write failed, retry in %v [target:%s][points:%d][err:%v]", s.name, wait, s.target, p.n, err)
+		select {
+		case <-s.close:
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+// batch to collect points, wait at most BatchWait
+func (s *batchSink) batch(first *AnalysPoint) []*AnalysPoint {
+	points := []*AnalysPoint{first}
+	timeout := time.After(s.BatchWait)
+	for s.BatchSize <= 0 || len(points) < s.BatchSize {
+		select {
+		case p := <-s.queue:
+			points = append(points, p)
+		case <-timeout:
+			return points
+		}
+	}
+	return points
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchSink(t *testing.T) {
+	s := newBatchSink("test", "127.0.0.1:0", 3, 10)
+	s.BatchWait = 10 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		if !s.Send(&AnalysPoint{Tms: int64(i)}) {
+			t.Fatal("send should not drop before the queue is full")
+		}
+	}
+
+	// 每批最多BatchSize个点, 不可重试的错误丢弃该payload, 可重试的错误重试到成功
+	var sizes []int
+	writes := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(func(points []*AnalysPoint) []sinkPayload {
+			sizes = append(sizes, len(points))
+			return []sinkPayload{{n: len(points)}}
+		}, func(p sinkPayload) error {
+			writes++
+			switch writes {
+			case 1:
+				return &sinkWriteError{err: errors.New("bad request")}
+			case 2:
+				return &sinkWriteError{err: errors.New("unavailable"), retryable: true}
+			case 3:
+				s.Stop()
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run should return after stop")
+	}
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 2 {
+		t.Errorf("unexpected batch sizes: %v", sizes)
+	}
+	if writes != 3 {
+		t.Errorf("the rejected payload should be dropped and the failed one retried, writes %d", writes)
+	}
+
+	// 停止后的重试等待立即返回
+	s = newBatchSink("test", "127.0.0.1:0", 0, 10)
+	s.Stop()
+	if s.retry(sinkPayload{n: 1}, func(sinkPayload) error {
+		return &sinkWriteError{err: errors.New("unavailable"), retryable: true}
+	}) {
+		t.Error("retry should give up once stopped")
+	}
+}
@@ -0,0 +1,315 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// DefaultBoostMaxTTL boost时长的默认上限
+const DefaultBoostMaxTTL = 30 * time.Minute
+
+// boost在生命周期记录中的状态
+const (
+	StrategyBoosted   = "boosted"
+	StrategyUnboosted = "normal"
+)
+
+// ErrBoostActive 同一策略同时只能有一个boost, 需先DELETE或等待到期
+var ErrBoostActive = errors.New("strategy is already boosted")
+
+// BoostOverrides is the temporary overrides of a boost
+// 都只放宽本策略, 不突破全局的限制: max_points_per_second仍然生效, 降级时仍会被暂停
+type BoostOverrides struct {
+	RateMultiplier float64 `json:"rate_multiplier,omitempty"` //strategy_max_points的倍数, 需>=1
+	NoShed         bool    `json:"no_shed,omitempty"`         //降级时排在其他策略之后暂停
+	NoSampling     bool    `json:"no_sampling,omitempty"`     //产生点的错误逐条记录, 不经sample_log合并
+	Trace          bool    `json:"trace,omitempty"`           //实时事件(含miss/exclude)写入日志, 不需要订阅
+	Debug          bool    `json:"debug,omitempty"`           //本策略的调试日志以info级别输出
+}
+
+func (o *BoostOverrides) validate() error {
+	if math.IsNaN(o.RateMultiplier) || math.IsInf(o.RateMultiplier, 0) || o.RateMultiplier < 0 ||
+		(o.RateMultiplier > 0 && o.RateMultiplier < 1) {
+		return fmt.Errorf("bad rate_multiplier %v, should be at least 1", o.RateMultiplier)
+	}
+	if o.RateMultiplier <= 1 && !o.NoShed && !o.NoSampling && !o.Trace && !o.Debug {
+		return fmt.Errorf("empty overrides, set rate_multiplier, no_shed, no_sampling, trace or debug")
+	}
+	return nil
+}
+
+// Boost is a temporary overlay on one strategy for troubleshooting
+// 与暂停一样叠加在下发的策略之上, 到期、DELETE或策略定义变化时清除
+type Boost struct {
+	StrategyID int64  `json:"strategy_id"`
+	FilePath   string `json:"file_path"`
+	BoostOverrides
+	Principal  string `json:"principal"`
+	Generation int64  `json:"generation"` //加上时策略的代数
+	Since      int64  `json:"since"`
+	Until      int64  `json:"until"`
+	Remaining  int64  `json:"remaining"` //剩余秒数, 查询时计算
+}
+
+func (b *Boost) active(now int64) bool {
+	return now < b.Until
+}
+
+// Status to describe the boost in strategy status
+func (b *Boost) Status() string {
+	return fmt.Sprintf("boosted by %s, %ds left", b.Principal, b.Remaining)
+}
+
+func (b *Boost) snapshot(now int64) *Boost {
+	cp := *b
+	cp.Remaining = b.Until - now
+	if cp.Remaining < 0 {
+		cp.Remaining = 0
+	}
+	return &cp
+}
+
+var (
+	// boostNow 测试中替换为假时钟
+	boostNow = time.Now
+	// 当前的boost(map[int64]*Boost), 只整体替换, 没有boost时发射路径上只有一次原子读
+	boosts     atomic.Value
+	boostsLock = new(sync.Mutex)
+)
+
+func init() {
+	boosts.Store(map[int64]*Boost{})
+}
+
+func loadBoosts() map[int64]*Boost {
+	return boosts.Load().(map[int64]*Boost)
+}
+
+// storeBoostsLocked to replace the boosts with a copy changed by fn, must be called with boostsLock held
+func storeBoostsLocked(fn func(map[int64]*Boost)) {
+	bs := loadBoosts()
+	next := make(map[int64]*Boost, len(bs)+1)
+	for id, b := range bs {
+		next[id] = b
+	}
+	fn(next)
+	boosts.Store(next)
+}
+
+// activeBoost to get the unexpired boost of the strategy, nil if not boosted
+func activeBoost(id int64) *Boost {
+	bs := loadBoosts()
+	if len(bs) == 0 {
+		return nil
+	}
+	b, ok := bs[id]
+	if !ok || !b.active(boostNow().Unix()) {
+		return nil
+	}
+	return b
+}
+
+func boostedNoShed(id int64) bool {
+	b := activeBoost(id)
+	return b != nil && b.NoShed
+}
+
+func boostedNoSampling(id int64) bool {
+	b := activeBoost(id)
+	return b != nil && b.NoSampling
+}
+
+func boostedTrace(id int64) bool {
+	b := activeBoost(id)
+	return b != nil && b.Trace
+}
+
+func boostedDebug(id int64) bool {
+	b := activeBoost(id)
+	return b != nil && b.Debug
+}
+
+// boostedRate to get the rate multiplier of the strategy, 1 if not boosted
+func boostedRate(id int64) float64 {
+	if b := activeBoost(id); b != nil && b.RateMultiplier > 1 {
+		return b.RateMultiplier
+	}
+	return 1
+}
+
+// boostDebugf to log at debug level, or at info level when the strategy is boosted with debug
+func boostDebugf(id int64, format string, args ...interface{}) {
+	if boostedDebug(id) {
+		dlog.Infof("[boost debug]"+format, args...)
+		return
+	}
+	dlog.Debugf(format, args...)
+}
+
+// boostMaxTTL to get the hard max of boost ttl
+func boostMaxTTL() time.Duration {
+	if g.Conf() != nil && g.Conf().Worker.BoostMaxTTL > 0 {
+		return time.Duration(g.Conf().Worker.BoostMaxTTL) * time.Second
+	}
+	return DefaultBoostMaxTTL
+}
+
+// recordBoostEvent to audit the boost in lifecycle events
+func recordBoostEvent(b *Boost, from, to, principal, reason string, now int64) {
+	recordGroupEvent(GroupLifecycleEvent{
+		File:       b.FilePath,
+		StrategyID: b.StrategyID,
+		From:       from,
+		To:         to,
+		Principal:  principal,
+		Reason:     reason,
+		Time:       now,
+	})
+}
+
+// BoostStrategy to boost the strategy for ttl, ttl超过上限时按上限
+func BoostStrategy(id int64, principal string, ttl time.Duration, o BoostOverrides) (*Boost, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("bad ttl %v", ttl)
+	}
+	if max := boostMaxTTL(); ttl > max {
+		dlog.Warningf("boost ttl %v exceeds the hard max, capped to %v [sid:%d][principal:%s]", ttl, max, id, principal)
+		ttl = max
+	}
+	st, err := strategy.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	boostsLock.Lock()
+	defer boostsLock.Unlock()
+	now := boostNow()
+	if old, ok := loadBoosts()[id]; ok && old.active(now.Unix()) {
+		return nil, fmt.Errorf("%w by %s until %s", ErrBoostActive, old.Principal, time.Unix(old.Until, 0).Format(time.RFC3339))
+	}
+	b := &Boost{
+		StrategyID:     id,
+		FilePath:       st.FilePath,
+		BoostOverrides: o,
+		Principal:      principal,
+		Generation:     st.Generation,
+		Since:          now.Unix(),
+		Until:          now.Add(ttl).Unix(),
+	}
+	storeBoostsLocked(func(m map[int64]*Boost) { m[id] = b })
+	recordBoostEvent(b, StrategyUnboosted, StrategyBoosted, principal, "", b.Since)
+	dlog.Infof("[audit] boost strategy [sid:%d][principal:%s][overrides:%+v][until:%d]", id, principal, o, b.Until)
+	return b.snapshot(b.Since), nil
+}
+
+// ClearBoost to remove the boost of the strategy, nil if not boosted
+func ClearBoost(id int64, principal string) *Boost {
+	boostsLock.Lock()
+	defer boostsLock.Unlock()
+	now := boostNow().Unix()
+	b, ok := loadBoosts()[id]
+	if !ok || !b.active(now) {
+		return nil
+	}
+	storeBoostsLocked(func(m map[int64]*Boost) { delete(m, id) })
+	recordBoostEvent(b, StrategyBoosted, StrategyUnboosted, principal, "cleared", now)
+	dlog.Infof("[audit] clear boost [sid:%d][principal:%s][boosted_by:%s]", id, principal, b.Principal)
+	return b.snapshot(now)
+}
+
+// GetBoost to get the active boost of the strategy, nil if not boosted
+func GetBoost(id int64) *Boost {
+	if b := activeBoost(id); b != nil {
+		return b.snapshot(boostNow().Unix())
+	}
+	return nil
+}
+
+// GetBoosts to get active boosts ordered by strategy id
+func GetBoosts() []*Boost {
+	now := boostNow().Unix()
+	ret := make([]*Boost, 0)
+	for _, b := range loadBoosts() {
+		if b.active(now) {
+			ret = append(ret, b.snapshot(now))
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].StrategyID < ret[j].StrategyID })
+	return ret
+}
+
+// BoostStatus to get boost status of a strategy, empty if not boosted
+func BoostStatus(id int64) string {
+	if b := GetBoost(id); b != nil {
+		return b.Status()
+	}
+	return ""
+}
+
+// ExpireBoosts to remove expired boosts
+// 判断是否生效时已经按到期时间过滤, 这里只是清理和记录
+func ExpireBoosts() {
+	boostsLock.Lock()
+	defer boostsLock.Unlock()
+	now := boostNow().Unix()
+	var expired []*Boost
+	for _, b := range loadBoosts() {
+		if !b.active(now) {
+			expired = append(expired, b)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	storeBoostsLocked(func(m map[int64]*Boost) {
+		for _, b := range expired {
+			delete(m, b.StrategyID)
+		}
+	})
+	for _, b := range expired {
+		recordBoostEvent(b, StrategyBoosted, StrategyUnboosted, b.Principal, "expired", b.Until)
+		dlog.Infof("[audit] boost expired [sid:%d][boosted_by:%s]", b.StrategyID, b.Principal)
+	}
+}
+
+// cleanBoosts to clear boosts of strategies deleted or redefined
+// 策略定义变化后generation会变, boost针对的是旧定义, 不再沿用
+func cleanBoosts(strategyMap map[int64]*scheme.Strategy) {
+	boostsLock.Lock()
+	defer boostsLock.Unlock()
+	now := boostNow().Unix()
+	var cleared []*Boost
+	for id, b := range loadBoosts() {
+		if !b.active(now) {
+			continue
+		}
+		if st, ok := strategyMap[id]; !ok || st.Generation != b.Generation {
+			cleared = append(cleared, b)
+		}
+	}
+	if len(cleared) == 0 {
+		return
+	}
+	storeBoostsLocked(func(m map[int64]*Boost) {
+		for _, b := range cleared {
+			delete(m, b.StrategyID)
+		}
+	})
+	for _, b := range cleared {
+		recordBoostEvent(b, StrategyBoosted, StrategyUnboosted, b.Principal, "strategy updated", now)
+		dlog.Infof("[audit] boost cleared by strategy update [sid:%d][boosted_by:%s]", b.StrategyID, b.Principal)
+	}
+}
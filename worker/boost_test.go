@@ -0,0 +1,218 @@
+package worker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// setBoostClock to replace the clock of boosts and load the strategies, returns a function to advance it
+func setBoostClock(t *testing.T, sts ...*scheme.Strategy) func(time.Duration) {
+	now := time.Unix(1500000000, 0)
+	boostNow = func() time.Time { return now }
+	strategy.UpdateGlobalStrategy(sts)
+	t.Cleanup(func() {
+		boostNow = time.Now
+		boostsLock.Lock()
+		boosts.Store(map[int64]*Boost{})
+		boostsLock.Unlock()
+		strategy.UpdateGlobalStrategy(nil)
+	})
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+// boostEvents to get the boost records of the strategy in lifecycle events
+func boostEvents(id int64) []GroupLifecycleEvent {
+	ret := make([]GroupLifecycleEvent, 0)
+	for _, e := range GroupLifecycleEvents("/tmp/boost.log") {
+		if e.StrategyID == id {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func TestBoostOverridesTakeEffect(t *testing.T) {
+	advance := setBoostClock(t, &scheme.Strategy{ID: 801, FilePath: "/tmp/boost.log"})
+	o := BoostOverrides{RateMultiplier: 3, NoShed: true, NoSampling: true, Trace: true, Debug: true}
+	check := func(on bool) {
+		t.Helper()
+		if boostedNoShed(801) != on || boostedNoSampling(801) != on || boostedTrace(801) != on || boostedDebug(801) != on {
+			t.Errorf("overrides should be %v", on)
+		}
+		// 没有订阅者时只有trace打开事件
+		if tapping() {
+			t.Fatal("no tap client expected")
+		}
+		if tapOn(801) != on {
+			t.Errorf("tap events of the boosted strategy should be %v", on)
+		}
+		if want := map[bool]float64{true: 3, false: 1}[on]; boostedRate(801) != want {
+			t.Errorf("rate multiplier: expect %v, got %v", want, boostedRate(801))
+		}
+	}
+	check(false)
+
+	b, err := BoostStrategy(801, "alice", 10*time.Minute, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Remaining != 600 || b.Principal != "alice" || b.FilePath != "/tmp/boost.log" {
+		t.Errorf("unexpected boost %+v", b)
+	}
+	check(true)
+	if boostedTrace(802) {
+		t.Error("other strategies should not be boosted")
+	}
+
+	advance(4 * time.Minute)
+	if got := BoostStatus(801); got != "boosted by alice, 360s left" {
+		t.Errorf("unexpected status %q", got)
+	}
+
+	// 到期后即使还没清理也不再生效, 恢复得和boost之前完全一样
+	advance(6 * time.Minute)
+	check(false)
+	if GetBoost(801) != nil || len(GetBoosts()) != 0 {
+		t.Error("expired boost should not be visible")
+	}
+	ExpireBoosts()
+	if len(loadBoosts()) != 0 {
+		t.Error("expired boost should be removed")
+	}
+	events := boostEvents(801)
+	if len(events) != 2 || events[0].To != StrategyBoosted || events[0].Principal != "alice" ||
+		events[1].To != StrategyUnboosted || events[1].Reason != "expired" {
+		t.Errorf("unexpected lifecycle events %+v", events)
+	}
+}
+
+func TestBoostOnlyOneAndClear(t *testing.T) {
+	advance := setBoostClock(t, &scheme.Strategy{ID: 811, FilePath: "/tmp/boost.log"})
+	if _, err := BoostStrategy(811, "alice", time.Minute, BoostOverrides{Trace: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BoostStrategy(811, "bob", time.Minute, BoostOverrides{Debug: true}); !errors.Is(err, ErrBoostActive) {
+		t.Fatalf("second boost should be rejected, got %v", err)
+	}
+	if boostedDebug(811) {
+		t.Error("rejected boost should not take effect")
+	}
+
+	advance(10 * time.Second)
+	b := ClearBoost(811, "bob")
+	if b == nil || b.Principal != "alice" || b.Remaining != 50 {
+		t.Fatalf("unexpected cleared boost %+v", b)
+	}
+	if boostedTrace(811) || ClearBoost(811, "bob") != nil {
+		t.Error("boost should be cleared")
+	}
+	events := boostEvents(811)
+	if len(events) != 2 || events[1].Principal != "bob" || events[1].Reason != "cleared" {
+		t.Errorf("unexpected lifecycle events %+v", events)
+	}
+
+	// 清除后可以再次boost
+	if _, err := BoostStrategy(811, "bob", time.Minute, BoostOverrides{Debug: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range []BoostOverrides{{}, {RateMultiplier: 0.5}, {RateMultiplier: 1}} {
+		if _, err := BoostStrategy(811, "alice", time.Minute, o); err == nil || errors.Is(err, ErrBoostActive) {
+			t.Errorf("overrides %+v should be rejected as invalid, got %v", o, err)
+		}
+	}
+	if _, err := BoostStrategy(404, "alice", time.Minute, BoostOverrides{Trace: true}); err == nil {
+		t.Error("missing strategy should be rejected")
+	}
+}
+
+func TestBoostClearedByStrategyUpdate(t *testing.T) {
+	setBoostClock(t, &scheme.Strategy{ID: 821, FilePath: "/tmp/boost.log", Pattern: "a"})
+	if _, err := BoostStrategy(821, "alice", time.Minute, BoostOverrides{Trace: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 定义不变的重新加载沿用boost
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{{ID: 821, FilePath: "/tmp/boost.log", Pattern: "a"}})
+	cleanBoosts(strategy.GetAll())
+	if !boostedTrace(821) {
+		t.Fatal("boost should survive a reload without change")
+	}
+
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{{ID: 821, FilePath: "/tmp/boost.log", Pattern: "b"}})
+	cleanBoosts(strategy.GetAll())
+	if boostedTrace(821) {
+		t.Fatal("boost should be cleared by strategy update")
+	}
+	events := boostEvents(821)
+	if len(events) != 2 || events[1].Reason != "strategy updated" {
+		t.Errorf("unexpected lifecycle events %+v", events)
+	}
+}
+
+// TestBoostGlobalLimits checks that boosts never go beyond the global limits
+func TestBoostGlobalLimits(t *testing.T) {
+	advance := setBoostClock(t,
+		&scheme.Strategy{ID: 831, FilePath: "/tmp/boost.log", MaxLagSeconds: 10},
+		&scheme.Strategy{ID: 832, FilePath: "/tmp/boost.log"},
+		&scheme.Strategy{ID: 833, FilePath: "/tmp/boost.log"},
+	)
+
+	// ttl超过上限时按上限
+	b, err := BoostStrategy(832, "alice", 24*time.Hour, BoostOverrides{RateMultiplier: 10, NoShed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Until-b.Since != int64(DefaultBoostMaxTTL/time.Second) {
+		t.Errorf("ttl should be capped to %v, got %ds", DefaultBoostMaxTTL, b.Until-b.Since)
+	}
+
+	// strategy_max_points放大10倍, 但max_points_per_second每秒5个仍然生效
+	now := boostNow()
+	sl := &strategyLimiter{base: NewLocalRateLimiter(2, 0)}
+	sl.base.now = func() time.Time { return now }
+	global := NewLocalRateLimiter(5, 0)
+	global.now = sl.base.now
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		if sl.Allow(832) && global.Allow() {
+			admitted++
+		}
+	}
+	if admitted != 5 {
+		t.Errorf("max_points_per_second should win, admitted %d", admitted)
+	}
+
+	// no_shed的策略最后才暂停, 延迟降不下来时照样暂停, 最严格的策略始终保留
+	sts := strategy.GetListAll()
+	s := newShedder()
+	var order []int64
+	for i := 0; i < 4; i++ {
+		before := len(s.suspended)
+		s.step("/tmp/boost.log", 3600, sts, 1, 0.5)
+		if len(s.suspended) > before {
+			order = append(order, s.suspended[len(s.suspended)-1])
+		}
+	}
+	if !reflect.DeepEqual(order, []int64{833, 832}) {
+		t.Errorf("unexpected shedding order: %v", order)
+	}
+
+	// 到期后单策略限额恢复, 原来的桶不受boost期间的点影响
+	advance(DefaultBoostMaxTTL)
+	now = now.Add(DefaultBoostMaxTTL)
+	admitted = 0
+	for i := 0; i < 100; i++ {
+		if sl.Allow(832) {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Errorf("strategy limit should be restored, admitted %d", admitted)
+	}
+}
@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// HighCardinalityValue 跨策略取值数超过上限的tag统一取该值
+const HighCardinalityValue = "__high_cardinality__"
+
+// defaultTagCardinalityWindow 取值数默认的统计窗口
+const defaultTagCardinalityWindow = time.Hour
+
+// tagCardinality is the registry of distinct tag values shared by all strategies of a file
+// max_tag_sets只限制单个策略的组合数, 多个策略都用trace_id这样的tag时, falcon中的序列数仍会爆炸;
+// 这里按tag名统计同一文件所有策略的取值, 超过上限后该文件所有策略的这个tag都取__high_cardinality__, 直到窗口到期
+type tagCardinality struct {
+	filePath string
+	limit    int
+	window   time.Duration
+
+	sync.RWMutex
+	values   map[string]map[string]struct{} //tag名 -> 窗口内出现过的取值
+	high     map[string]bool                //窗口内超过上限的tag名
+	replaced map[string]int64               //启动以来被替换的取值个数
+	since    time.Time
+}
+
+// TagCardinalityStat is the distinct values of one tag key of a file
+type TagCardinalityStat struct {
+	Distinct int   `json:"distinct"` //窗口内的取值数, 超过上限后不再统计
+	High     bool  `json:"high"`     //窗口内是否已超过上限
+	Replaced int64 `json:"replaced"` //被替换为__high_cardinality__的取值个数
+}
+
+func newTagCardinality(filePath string, limit int, window time.Duration, now time.Time) *tagCardinality {
+	if window <= 0 {
+		window = defaultTagCardinalityWindow
+	}
+	return &tagCardinality{
+		filePath: filePath,
+		limit:    limit,
+		window:   window,
+		values:   make(map[string]map[string]struct{}),
+		high:     make(map[string]bool),
+		replaced: make(map[string]int64),
+		since:    now,
+	}
+}
+
+// apply to replace values of the tags over the limit in place
+// 已出现过的取值只需要读锁
+func (c *tagCardinality) apply(tags map[string]string, now time.Time) {
+	if len(tags) == 0 {
+		return
+	}
+	c.RLock()
+	known := now.Sub(c.since) < c.window
+	if known {
+		for k, v := range tags {
+			if c.high[k] {
+				known = false
+				break
+			}
+			if _, ok := c.values[k][v]; !ok {
+				known = false
+				break
+			}
+		}
+	}
+	c.RUnlock()
+	if known {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if now.Sub(c.since) >= c.window {
+		c.values = make(map[string]map[string]struct{})
+		c.high = make(map[string]bool)
+		c.since = now
+	}
+	for k, v := range tags {
+		if c.high[k] {
+			tags[k] = HighCardinalityValue
+			c.replaced[k]++
+			continue
+		}
+		vs, ok := c.values[k]
+		if !ok {
+			vs = make(map[string]struct{})
+			c.values[k] = vs
+		}
+		if _, ok := vs[v]; ok {
+			continue
+		}
+		if len(vs) < c.limit {
+			vs[v] = struct{}{}
+			continue
+		}
+		dlog.Warningf("tag over %d distinct values across strategies, use %s until %s [file:%s][tag:%s]",
+			c.limit, HighCardinalityValue, c.since.Add(c.window).Format(time.RFC3339), c.filePath, k)
+		c.high[k] = true
+		delete(c.values, k)
+		tags[k] = HighCardinalityValue
+		c.replaced[k]++
+	}
+}
+
+// stats to get the stat of each tag key
+func (c *tagCardinality) stats() map[string]TagCardinalityStat {
+	c.RLock()
+	defer c.RUnlock()
+	ret := make(map[string]TagCardinalityStat, len(c.values)+len(c.high))
+	for k, vs := range c.values {
+		ret[k] = TagCardinalityStat{Distinct: len(vs), Replaced: c.replaced[k]}
+	}
+	for k := range c.high {
+		ret[k] = TagCardinalityStat{Distinct: c.limit, High: true, Replaced: c.replaced[k]}
+	}
+	for k, n := range c.replaced {
+		if _, ok := ret[k]; !ok {
+			ret[k] = TagCardinalityStat{Replaced: n}
+		}
+	}
+	return ret
+}
+
+var (
+	tagCardinalities     = make(map[string]*tagCardinality)
+	tagCardinalitiesLock = new(sync.RWMutex)
+)
+
+// tagCardinalityEnabled to check whether the cross-strategy limit is configured
+func tagCardinalityEnabled() bool {
+	return g.Conf() != nil && g.Conf().Worker.MaxTagCardinality > 0
+}
+
+func addTagCardinality(filePath string) {
+	window := time.Duration(g.Conf().Worker.TagCardinalityWindow) * time.Second
+	tagCardinalitiesLock.Lock()
+	tagCardinalities[filePath] = newTagCardinality(filePath, g.Conf().Worker.MaxTagCardinality, window, time.Now())
+	tagCardinalitiesLock.Unlock()
+}
+
+func removeTagCardinality(filePath string) {
+	tagCardinalitiesLock.Lock()
+	delete(tagCardinalities, filePath)
+	tagCardinalitiesLock.Unlock()
+}
+
+// getTagCardinality to get the registry of the file, nil if the limit is off
+func getTagCardinality(filePath string) *tagCardinality {
+	tagCardinalitiesLock.RLock()
+	defer tagCardinalitiesLock.RUnlock()
+	return tagCardinalities[filePath]
+}
+
+// TagCardinalityStats to get the cross-strategy tag stats of all files
+func TagCardinalityStats() map[string]map[string]TagCardinalityStat {
+	tagCardinalitiesLock.RLock()
+	defer tagCardinalitiesLock.RUnlock()
+	ret := make(map[string]map[string]TagCardinalityStat, len(tagCardinalities))
+	for filePath, c := range tagCardinalities {
+		ret[filePath] = c.stats()
+	}
+	return ret
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTagCardinalityAcrossStrategies(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	c := newTagCardinality("/var/log/app.log", 10, time.Hour, now)
+
+	// 两个策略各自只有6个trace_id, 单独都不超限, 合计12个
+	for i := 0; i < 6; i++ {
+		a := map[string]string{"trace_id": fmt.Sprint("a", i), "code": "200"}
+		c.apply(a, now)
+		if a["trace_id"] != fmt.Sprint("a", i) {
+			t.Fatalf("strategy a replaced early: %v", a)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		b := map[string]string{"trace_id": fmt.Sprint("b", i)}
+		c.apply(b, now)
+		if want := fmt.Sprint("b", i); i < 4 && b["trace_id"] != want {
+			t.Fatalf("strategy b replaced early: %v", b)
+		}
+		if i >= 4 && b["trace_id"] != HighCardinalityValue {
+			t.Fatalf("strategy b over the limit: %v", b)
+		}
+	}
+
+	// 超限后已出现过的取值同样替换, 其他tag不受影响
+	a := map[string]string{"trace_id": "a0", "code": "200"}
+	c.apply(a, now.Add(time.Minute))
+	if a["trace_id"] != HighCardinalityValue || a["code"] != "200" {
+		t.Fatalf("known value after over the limit: %v", a)
+	}
+	stats := c.stats()
+	if s := stats["trace_id"]; !s.High || s.Replaced != 3 {
+		t.Fatalf("trace_id stat = %+v", s)
+	}
+	if s := stats["code"]; s.High || s.Distinct != 1 {
+		t.Fatalf("code stat = %+v", s)
+	}
+
+	// 窗口到期后重新统计
+	a = map[string]string{"trace_id": "a0"}
+	c.apply(a, now.Add(time.Hour))
+	if a["trace_id"] != "a0" {
+		t.Fatalf("not reset after window: %v", a)
+	}
+	if s := c.stats()["trace_id"]; s.High || s.Distinct != 1 || s.Replaced != 3 {
+		t.Fatalf("trace_id stat after window = %+v", s)
+	}
+}
+
+func TestTagCardinalityConcurrent(t *testing.T) {
+	now := time.Now()
+	c := newTagCardinality("/var/log/app.log", 100, time.Hour, now)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	kept := make(map[string]struct{})
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				tags := map[string]string{"id": fmt.Sprint(w, "-", i)}
+				c.apply(tags, now)
+				lock.Lock()
+				kept[tags["id"]] = struct{}{}
+				lock.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	// 上限内的100个取值保留, 其余都被替换
+	if len(kept) != 101 {
+		t.Fatalf("distinct values pushed = %d, want 100 + %s", len(kept), HighCardinalityValue)
+	}
+}
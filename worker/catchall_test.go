@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+const catchAllTestFile = "/tmp/catchall_test.log"
+
+func catchAllStrategy(id int64, pattern string) *scheme.Strategy {
+	pat, _ := utils.GetPatAndTimeFormat("yyyy-mm-dd HH:MM:SS")
+	st := &scheme.Strategy{
+		ID:         id,
+		Name:       "catchall",
+		FilePath:   catchAllTestFile,
+		Pattern:    pattern,
+		TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Func:       "cnt",
+		Interval:   60,
+		Degree:     6,
+		TimeReg:    regexp.MustCompile(pat),
+		ParseSucc:  true,
+	}
+	if pattern != "" {
+		st.PatternReg = regexp.MustCompile(pattern)
+	} else {
+		st.CatchAll = true
+	}
+	return st
+}
+
+func TestCatchAll(t *testing.T) {
+	// error没有\d+, 没匹配的行补-1; cost=(\d+)没匹配的行不产生点
+	errSt, costSt, all := catchAllStrategy(9101, "error"), catchAllStrategy(9102, `cost=(\d+)`), catchAllStrategy(9103, "")
+	costSt.Func = "sum"
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{errSt, costSt, all})
+	defer strategy.UpdateGlobalStrategy(nil)
+	for _, id := range []int64{9101, 9102, 9103} {
+		GlobalCount.deleteByID(id)
+		defer GlobalCount.deleteByID(id)
+	}
+
+	w := &Worker{
+		FilePath: catchAllTestFile,
+		Mark:     "[worker][catch-all test]",
+		Callback: func(int64, int64) {},
+		Accept:   func(int64) bool { return true },
+	}
+	for _, text := range []string{
+		"2018-01-01 12:00:01 error code=1",
+		"2018-01-01 12:00:02 cost=5",
+		"2018-01-01 12:00:03 error cost=7",
+		"2018-01-01 12:00:04 hello",
+		"2018-01-01 12:00:05 world",
+		"stack trace without time",
+	} {
+		w.analysis(reader.Line{Text: text})
+	}
+
+	// 点的时间为处理时的时间, 各周期相加
+	counted := func(id int64) int64 {
+		sc, err := GlobalCount.GetStrategyCountByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int64
+		for _, tms := range sc.GetTmsList() {
+			pc, _ := sc.GetByTms(tms)
+			for _, p := range pc.TagstringMap {
+				n += p.Count
+			}
+		}
+		return n
+	}
+	if n := counted(9103); n != 2 {
+		t.Fatalf("catch-all counted %d, want the 2 unmatched lines", n)
+	}
+	// 其他策略照常计算
+	for _, id := range []int64{9101, 9102} {
+		if _, err := GlobalCount.GetStrategyCountByID(id); err != nil {
+			t.Fatalf("strategy %d not counted: %v", id, err)
+		}
+	}
+
+	var value float64
+	pointMap := map[string]*PointCounter{"null": {Count: 2}}
+	buildFalconPoints(all, 1514779200, pointMap, "host", func(p *FalconPoint, tags map[string]string) {
+		value = p.Value
+	})
+	if value != 0.033333 {
+		t.Fatalf("catch-all value = %v, want 2 lines / 60s", value)
+	}
+}
+
+// 其他策略不归本group或被降级时不能判断行没有被匹配, catch-all不计数
+func TestCatchAllSkipsUnacceptedSiblings(t *testing.T) {
+	errSt, costSt, all := catchAllStrategy(9111, "error"), catchAllStrategy(9112, `cost=(\d+)`), catchAllStrategy(9113, "")
+	errSt.MaxLagSeconds = 10
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{errSt, costSt, all})
+	defer strategy.UpdateGlobalStrategy(nil)
+	for _, id := range []int64{9111, 9112, 9113} {
+		GlobalCount.deleteByID(id)
+		defer GlobalCount.deleteByID(id)
+	}
+
+	wg := &WorkerGroup{filePath: catchAllTestFile, shed: newShedder()}
+	w := &Worker{
+		FilePath: catchAllTestFile,
+		Mark:     "[worker][catch-all shard test]",
+		Callback: func(int64, int64) {},
+		Accept:   wg.accept,
+	}
+	counted := func() int64 {
+		sc, err := GlobalCount.GetStrategyCountByID(9113)
+		if err != nil {
+			return 0
+		}
+		var n int64
+		for _, tms := range sc.GetTmsList() {
+			pc, _ := sc.GetByTms(tms)
+			for _, p := range pc.TagstringMap {
+				n += p.Count
+			}
+		}
+		return n
+	}
+
+	// cost=策略在另一个shard
+	wg.SetStrategyIDs([]int64{9111, 9113})
+	w.analysis(reader.Line{Text: "2018-01-01 12:00:01 cost=5"})
+	w.analysis(reader.Line{Text: "2018-01-01 12:00:02 hello"})
+	if n := counted(); n != 0 {
+		t.Fatalf("catch-all counted %d lines while a sibling is in another shard", n)
+	}
+
+	// cost=策略被降级暂停
+	wg.SetStrategyIDs(nil)
+	wg.shed.step(catchAllTestFile, 60, []*scheme.Strategy{errSt, costSt}, 1, 0.5)
+	if !wg.shed.Suspended(9112) {
+		t.Fatal("strategy 9112 should be suspended")
+	}
+	w.analysis(reader.Line{Text: "2018-01-01 12:00:03 cost=5"})
+	if n := counted(); n != 0 {
+		t.Fatalf("catch-all counted %d lines while a sibling is suspended", n)
+	}
+
+	// 恢复后照常计数
+	wg.shed.step(catchAllTestFile, 1, []*scheme.Strategy{errSt, costSt}, 1, 0.5)
+	w.analysis(reader.Line{Text: "2018-01-01 12:00:04 cost=5"})
+	w.analysis(reader.Line{Text: "2018-01-01 12:00:05 hello"})
+	if n := counted(); n != 1 {
+		t.Fatalf("catch-all counted %d lines after recovery, want 1", n)
+	}
+}
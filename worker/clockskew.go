@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+)
+
+// 时钟偏差检测的默认值
+const (
+	defaultClockSkewThreshold = time.Minute
+	defaultClockSkewWindow    = 5 * time.Minute
+	clockSkewMinSamples       = 5    //窗口内样本少于这个数不估计
+	clockSkewMaxSamples       = 1200 //每个文件最多保留的样本, reader每秒最多抽样一行, 足以覆盖默认窗口
+)
+
+// ClockSkewStat is the estimated clock skew of the writer of one file
+type ClockSkewStat struct {
+	Skew      float64 `json:"skew"`            //日志时间减去读取时间的中位数, 秒, 正数表示写日志的机器时钟偏快
+	Samples   int     `json:"samples"`         //窗口内的样本数
+	Suspected bool    `json:"suspected"`       //持续超过阈值一个窗口, 会推送log.agent.clock_skew_suspected
+	Since     int64   `json:"since,omitempty"` //开始超过阈值的时间
+}
+
+type skewSample struct {
+	at   time.Time
+	skew float64
+}
+
+// clockSkew 一个文件的样本及估计, 只用刚写入的行(reader读到时已在文件末尾), 读取积压不会被误认为时钟偏差
+type clockSkew struct {
+	samples     []skewSample //按读取时间有序
+	estimate    float64
+	exceedSince time.Time //估计值持续超过阈值的起点, 零值表示未超过
+	suspected   bool
+}
+
+var (
+	clockSkews     = make(map[string]*clockSkew)
+	clockSkewsLock = new(sync.Mutex)
+)
+
+// clockSkewParams to get the threshold and window, threshold is 0 if disabled
+func clockSkewParams() (time.Duration, time.Duration) {
+	threshold, window := defaultClockSkewThreshold, defaultClockSkewWindow
+	if g.Conf() == nil {
+		return threshold, window
+	}
+	c := g.Conf().ClockSkew
+	if c.Threshold < 0 {
+		return 0, window
+	}
+	if c.Threshold > 0 {
+		threshold = time.Duration(c.Threshold) * time.Second
+	}
+	if c.Window > 0 {
+		window = time.Duration(c.Window) * time.Second
+	}
+	return threshold, window
+}
+
+// observeClockSkew to record the log time of a fresh line against the time it was read
+func observeClockSkew(file string, logTime, read time.Time) {
+	if threshold, _ := clockSkewParams(); threshold <= 0 {
+		return
+	}
+	clockSkewsLock.Lock()
+	defer clockSkewsLock.Unlock()
+	c, ok := clockSkews[file]
+	if !ok {
+		c = new(clockSkew)
+		clockSkews[file] = c
+	}
+	c.samples = append(c.samples, skewSample{at: read, skew: logTime.Sub(read).Seconds()})
+	if len(c.samples) > clockSkewMaxSamples {
+		c.samples = c.samples[len(c.samples)-clockSkewMaxSamples:]
+	}
+}
+
+// evaluateClockSkews to update the estimate of every file, returns the skew of suspected files
+// 用中位数, 一批追加写入的旧日志不会拉偏估计; 持续超过阈值一个窗口才报出
+func evaluateClockSkews(now time.Time) map[string]float64 {
+	threshold, window := clockSkewParams()
+	ret := make(map[string]float64)
+	clockSkewsLock.Lock()
+	defer clockSkewsLock.Unlock()
+	for file, c := range clockSkews {
+		i := sort.Search(len(c.samples), func(i int) bool { return now.Sub(c.samples[i].at) < window })
+		c.samples = c.samples[i:]
+		if len(c.samples) == 0 {
+			delete(clockSkews, file)
+			continue
+		}
+		if threshold <= 0 || len(c.samples) < clockSkewMinSamples {
+			c.exceedSince, c.suspected = time.Time{}, false
+			continue
+		}
+		c.estimate = medianSkew(c.samples)
+		if math.Abs(c.estimate) <= threshold.Seconds() {
+			if c.suspected {
+				dlog.Infof("clock skew of the writer of %s recovered [skew:%.1fs]", file, c.estimate)
+			}
+			c.exceedSince, c.suspected = time.Time{}, false
+			continue
+		}
+		if c.exceedSince.IsZero() {
+			c.exceedSince = now
+		}
+		if now.Sub(c.exceedSince) < window {
+			continue
+		}
+		if !c.suspected {
+			dlog.Warningf("clock of the writer of %s seems off by %.1fs since %s", file, c.estimate, c.exceedSince.Format(time.RFC3339))
+		}
+		c.suspected = true
+		ret[file] = c.estimate
+	}
+	return ret
+}
+
+func medianSkew(samples []skewSample) float64 {
+	skews := make([]float64, len(samples))
+	for i, s := range samples {
+		skews[i] = s.skew
+	}
+	sort.Float64s(skews)
+	n := len(skews)
+	if n%2 == 1 {
+		return skews[n/2]
+	}
+	return (skews[n/2-1] + skews[n/2]) / 2
+}
+
+// ClockSkewStats to get the estimated clock skew of files with fresh lines in the window
+func ClockSkewStats() map[string]ClockSkewStat {
+	clockSkewsLock.Lock()
+	defer clockSkewsLock.Unlock()
+	ret := make(map[string]ClockSkewStat, len(clockSkews))
+	for file, c := range clockSkews {
+		s := ClockSkewStat{Skew: c.estimate, Samples: len(c.samples), Suspected: c.suspected}
+		if !c.exceedSince.IsZero() {
+			s.Since = c.exceedSince.Unix()
+		}
+		ret[file] = s
+	}
+	return ret
+}
+
+// ReportClockSkews to push the estimated skew of suspected files as log.agent.clock_skew_suspected, tag file
+// 由自监控的ticker驱动, 与其他自监控数据同一间隔
+func ReportClockSkews(w ticker.Window) {
+	step := int64(w.Duration() / time.Second)
+	if step <= 0 {
+		step = 1
+	}
+	for file, skew := range evaluateClockSkews(w.End) {
+		pushQueue <- &FalconPoint{
+			Endpoint:    pushEndpoint(),
+			Metric:      "log.agent.clock_skew_suspected",
+			Timestamp:   w.End.Unix(),
+			Step:        step,
+			Value:       skew,
+			Tags:        "file=" + file,
+			CounterType: "GAUGE",
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestClockSkewDetector(t *testing.T) {
+	defer func() { clockSkews = make(map[string]*clockSkew) }()
+	// 没有经过加载的策略按本机时区解析
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.Local)
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.PatternReg = regexp.MustCompile(`GET`)
+	skewed := &Worker{FilePath: "/var/log/skewed.log", Mark: "[worker][skewed]", Callback: func(int64, int64) {}}
+	lagging := &Worker{FilePath: "/var/log/lagging.log", Mark: "[worker][lagging]", Callback: func(int64, int64) {}}
+	line := func(w *Worker, logTime, read time.Time, fresh bool) {
+		w.freshMs = 0
+		if fresh {
+			w.freshMs = read.UnixNano() / int64(time.Millisecond)
+		}
+		w.producer(fmt.Sprintf("%s GET /", logTime.Format("2006-01-02 15:04:05")), st)
+	}
+
+	var suspected map[string]float64
+	for sec := 0; sec < 900; sec++ {
+		now := start.Add(time.Duration(sec) * time.Second)
+		// 时钟快了3分钟的机器, 行写入后立即读到
+		line(skewed, now.Add(3*time.Minute), now, true)
+		// 时钟准确但读取落后10分钟, reader不在文件末尾, 不抽样
+		line(lagging, now.Add(-10*time.Minute), now, false)
+		// 追上后偶尔读到刚写入的行, 另有一批追加写入的旧日志
+		if sec%20 == 0 {
+			line(lagging, now, now, true)
+		}
+		if sec >= 400 && sec < 405 {
+			line(lagging, now.Add(-time.Hour), now, true)
+		}
+		if sec%10 == 9 {
+			suspected = evaluateClockSkews(now)
+			// 持续超过阈值一个窗口(默认5分钟)之前不报出
+			if sec < 300 && len(suspected) > 0 {
+				t.Fatalf("skew should persist a window before suspected, got %v at %ds", suspected, sec)
+			}
+		}
+	}
+
+	if len(suspected) != 1 || suspected[skewed.FilePath] != 180 {
+		t.Fatalf("only the skewed writer should be suspected, got %v", suspected)
+	}
+	stats := ClockSkewStats()
+	if s := stats[skewed.FilePath]; !s.Suspected || s.Skew != 180 || s.Since == 0 {
+		t.Errorf("unexpected stat of skewed writer %+v", s)
+	}
+	if s := stats[lagging.FilePath]; s.Suspected || s.Skew != 0 {
+		t.Errorf("unexpected stat of lagging writer %+v", s)
+	}
+
+	// 时钟校准后恢复, 没有新样本的文件在窗口过后清理
+	end := start.Add(900 * time.Second)
+	for sec := 0; sec < 300; sec++ {
+		now := end.Add(time.Duration(sec) * time.Second)
+		line(skewed, now, now, true)
+	}
+	if suspected = evaluateClockSkews(end.Add(300 * time.Second)); len(suspected) != 0 {
+		t.Fatalf("corrected clock should recover, got %v", suspected)
+	}
+	if _, ok := ClockSkewStats()[lagging.FilePath]; ok {
+		t.Error("file without samples in the window should be dropped")
+	}
+}
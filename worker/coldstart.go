@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// PartialTagk first_period为partial_tag时, 不完整周期的点带上partial=true
+const PartialTagk = "partial"
+
+// ColdStartStat is the count of first partial periods of one strategy since start
+type ColdStartStat struct {
+	Suppressed int64 `json:"suppressed"`
+	Tagged     int64 `json:"tagged"`
+}
+
+// coldStartNow is the clock of bindings, replaced in test
+var coldStartNow = time.Now
+
+var (
+	// coldStartBindings 策略加入已在消费的文件的时间, 该策略的第一个周期从此时算起
+	// 随文件一起创建的策略不记录, 以文件开始消费的时间为准
+	coldStartBindings     = make(map[int64]coldStartBinding)
+	coldStartBindingsLock = new(sync.RWMutex)
+
+	coldStartStats     = make(map[int64]*ColdStartStat)
+	coldStartStatsLock = new(sync.RWMutex)
+)
+
+type coldStartBinding struct {
+	file  string
+	since int64
+}
+
+// bindColdStart to record that the strategy joined a file already being consumed
+func bindColdStart(id int64, file string) {
+	coldStartBindingsLock.Lock()
+	coldStartBindings[id] = coldStartBinding{file: file, since: coldStartNow().Unix()}
+	coldStartBindingsLock.Unlock()
+}
+
+func unbindColdStart(id int64) {
+	coldStartBindingsLock.Lock()
+	delete(coldStartBindings, id)
+	coldStartBindingsLock.Unlock()
+}
+
+// consumeSinceFunc to get when line consumption of the file began, replaced in test
+var consumeSinceFunc = fileConsumeSince
+
+// fileConsumeSince to get when the job of the file began consuming lines
+func fileConsumeSince(file string) (int64, bool) {
+	ManagerJobLock.RLock()
+	defer ManagerJobLock.RUnlock()
+	job, ok := ManagerJob[file]
+	if !ok {
+		return 0, false
+	}
+	if r, ok := job.r.(interface{ ConsumeSince() int64 }); ok {
+		since := r.ConsumeSince()
+		return since, since > 0
+	}
+	// 命名管道、otlp输入没有checkpoint, 以job创建的时间为准
+	return job.started, job.started > 0
+}
+
+// coldStartSince to get when the strategy began seeing lines of its file
+func coldStartSince(st *scheme.Strategy) (int64, bool) {
+	since, ok := consumeSinceFunc(st.FilePath)
+	if !ok {
+		return 0, false
+	}
+	coldStartBindingsLock.RLock()
+	b, bound := coldStartBindings[st.ID]
+	coldStartBindingsLock.RUnlock()
+	if bound && b.file == st.FilePath && b.since > since {
+		since = b.since
+	}
+	return since, true
+}
+
+// firstPeriodPartial to check whether the step is the first one and began before consumption
+// 恰好在周期边界开始消费时, 第一个周期是完整的
+func firstPeriodPartial(st *scheme.Strategy, tms int64) bool {
+	since, ok := coldStartSince(st)
+	if !ok {
+		return false
+	}
+	return since > tms && AlignStepTms(st.Interval, since) == tms
+}
+
+// coldStartAction to get how the step should be pushed
+func coldStartAction(st *scheme.Strategy, tms int64) string {
+	if st.FirstPeriod == "" || st.FirstPeriod == scheme.FirstPeriodEmit || !firstPeriodPartial(st, tms) {
+		return scheme.FirstPeriodEmit
+	}
+	return st.FirstPeriod
+}
+
+func getColdStartStat(id int64) *ColdStartStat {
+	coldStartStatsLock.RLock()
+	s, ok := coldStartStats[id]
+	coldStartStatsLock.RUnlock()
+	if ok {
+		return s
+	}
+
+	coldStartStatsLock.Lock()
+	defer coldStartStatsLock.Unlock()
+	if s, ok = coldStartStats[id]; !ok {
+		s = new(ColdStartStat)
+		coldStartStats[id] = s
+	}
+	return s
+}
+
+// ColdStartStats to get first partial period counts of all strategies
+func ColdStartStats() map[int64]ColdStartStat {
+	coldStartStatsLock.RLock()
+	defer coldStartStatsLock.RUnlock()
+	ret := make(map[int64]ColdStartStat, len(coldStartStats))
+	for id, s := range coldStartStats {
+		ret[id] = ColdStartStat{
+			Suppressed: atomic.LoadInt64(&s.Suppressed),
+			Tagged:     atomic.LoadInt64(&s.Tagged),
+		}
+	}
+	return ret
+}
+
+// cleanColdStartStats to drop counts of strategies deleted
+func cleanColdStartStats(strategyMap map[int64]*scheme.Strategy) {
+	coldStartStatsLock.Lock()
+	defer coldStartStatsLock.Unlock()
+	for id := range coldStartStats {
+		if _, ok := strategyMap[id]; !ok {
+			delete(coldStartStats, id)
+		}
+	}
+}
+
+// partialEmit to add the partial tag to points of the step
+func partialEmit(emit func(p *FalconPoint, tags map[string]string)) func(p *FalconPoint, tags map[string]string) {
+	return func(p *FalconPoint, tags map[string]string) {
+		if tags == nil {
+			tags = make(map[string]string, 1)
+		}
+		tags[PartialTagk] = "true"
+		p.Tags = utils.SortedTags(tags)
+		emit(p, tags)
+	}
+}
+
+// pushStep to push the counters of a step, applying the cold-start policy of the strategy
+func pushStep(st *scheme.Strategy, tms int64, pc *PointsCounter, endpoint string) {
+	switch coldStartAction(st, tms) {
+	case scheme.FirstPeriodSuppress:
+		atomic.AddInt64(&getColdStartStat(st.ID).Suppressed, 1)
+		dlog.Infof("suppress the first partial period [sid:%d][file:%s][tms:%d]", st.ID, st.FilePath, tms)
+		return
+	case scheme.FirstPeriodPartialTag:
+		atomic.AddInt64(&getColdStartStat(st.ID).Tagged, 1)
+		buildFalconPoints(st, tms, pc.TagstringMap, endpoint, partialEmit(pushEmit(st, tms)))
+	default:
+		buildFalconPoints(st, tms, pc.TagstringMap, endpoint, pushEmit(st, tms))
+	}
+	pushOverflowStat(st, tms, pc, endpoint)
+	recordGenerationMixes(st, tms, pc)
+}
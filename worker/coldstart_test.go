@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// fakeConsumeSince to set when files began consuming lines
+func fakeConsumeSince(t *testing.T, since map[string]int64) {
+	old := consumeSinceFunc
+	consumeSinceFunc = func(file string) (int64, bool) {
+		s, ok := since[file]
+		return s, ok
+	}
+	t.Cleanup(func() { consumeSinceFunc = old })
+}
+
+func TestFirstPeriodPartial(t *testing.T) {
+	st := &scheme.Strategy{ID: 1, FilePath: "/var/log/a.log", Interval: 60, FirstPeriod: scheme.FirstPeriodSuppress}
+	cases := []struct {
+		name   string
+		since  int64
+		tms    int64
+		action string
+	}{
+		{"start mid-period", 1500000030, 1500000000, scheme.FirstPeriodSuppress},
+		{"period after start", 1500000030, 1500000060, scheme.FirstPeriodEmit},
+		{"start on boundary", 1500000060, 1500000060, scheme.FirstPeriodEmit},
+		// 续读的checkpoint在周期开始之前, 该周期的行都会读到
+		{"checkpoint covers period start", 1499999990, 1500000000, scheme.FirstPeriodEmit},
+		{"checkpoint inside period", 1500000010, 1500000000, scheme.FirstPeriodSuppress},
+	}
+	for _, c := range cases {
+		fakeConsumeSince(t, map[string]int64{st.FilePath: c.since})
+		if got := coldStartAction(st, c.tms); got != c.action {
+			t.Errorf("%s: action %s, want %s", c.name, got, c.action)
+		}
+	}
+
+	fakeConsumeSince(t, map[string]int64{})
+	if got := coldStartAction(st, 1500000000); got != scheme.FirstPeriodEmit {
+		t.Errorf("file not consumed: action %s, want emit", got)
+	}
+	emit := &scheme.Strategy{ID: 2, FilePath: st.FilePath, Interval: 60}
+	fakeConsumeSince(t, map[string]int64{st.FilePath: 1500000030})
+	if got := coldStartAction(emit, 1500000000); got != scheme.FirstPeriodEmit {
+		t.Errorf("default policy: action %s, want emit", got)
+	}
+}
+
+// 策略加入已在消费的文件时, 从加入的时间计算自己的第一个周期
+func TestFirstPeriodPerBinding(t *testing.T) {
+	defer func(now func() time.Time) { coldStartNow = now }(coldStartNow)
+	file := "/var/log/a.log"
+	fakeConsumeSince(t, map[string]int64{file: 1500000000})
+	old := &scheme.Strategy{ID: 1, FilePath: file, Interval: 60, FirstPeriod: scheme.FirstPeriodSuppress}
+	added := &scheme.Strategy{ID: 2, FilePath: file, Interval: 60, FirstPeriod: scheme.FirstPeriodSuppress}
+
+	coldStartNow = func() time.Time { return time.Unix(1500000090, 0) }
+	bindColdStart(added.ID, file)
+	defer unbindColdStart(added.ID)
+
+	if got := coldStartAction(old, 1500000060); got != scheme.FirstPeriodEmit {
+		t.Errorf("existing strategy: action %s, want emit", got)
+	}
+	if got := coldStartAction(added, 1500000060); got != scheme.FirstPeriodSuppress {
+		t.Errorf("added strategy: action %s, want suppress", got)
+	}
+	if got := coldStartAction(added, 1500000120); got != scheme.FirstPeriodEmit {
+		t.Errorf("added strategy next period: action %s, want emit", got)
+	}
+
+	// 绑定的是其他文件时不生效
+	moved := &scheme.Strategy{ID: 2, FilePath: "/var/log/b.log", Interval: 60, FirstPeriod: scheme.FirstPeriodSuppress}
+	fakeConsumeSince(t, map[string]int64{file: 1500000000, moved.FilePath: 1500000000})
+	if got := coldStartAction(moved, 1500000060); got != scheme.FirstPeriodEmit {
+		t.Errorf("strategy on other file: action %s, want emit", got)
+	}
+}
+
+func TestPushStepColdStart(t *testing.T) {
+	defer cleanColdStartStats(nil)
+	drainPushQueue()
+	file := "/var/log/a.log"
+	fakeConsumeSince(t, map[string]int64{file: 1500000030})
+	pc := &PointsCounter{TagstringMap: map[string]*PointCounter{"code=500": {Count: 3}}}
+
+	suppress := &scheme.Strategy{ID: 1, Name: "a", FilePath: file, Interval: 60, Func: "cnt", FirstPeriod: scheme.FirstPeriodSuppress}
+	pushStep(suppress, 1500000000, pc, "host1")
+	if ps := drainPushQueue(); len(ps) != 0 {
+		t.Errorf("suppressed period pushed %v", ps)
+	}
+	pushStep(suppress, 1500000060, pc, "host1")
+	if ps := drainPushQueue(); len(ps) != 1 || ps[0].Tags != "code=500" {
+		t.Errorf("second period: %v", ps)
+	}
+
+	tagged := &scheme.Strategy{ID: 2, Name: "b", FilePath: file, Interval: 60, Func: "cnt", FirstPeriod: scheme.FirstPeriodPartialTag}
+	pushStep(tagged, 1500000000, pc, "host1")
+	ps := drainPushQueue()
+	if len(ps) != 1 || ps[0].Tags != "code=500,partial=true" || ps[0].Value != 3 {
+		t.Errorf("partial period: %+v", ps)
+	}
+
+	preview := previewPush(NewGlobalCounter(1), PreviewFilter{}, time.Unix(1500000040, 0))
+	if preview.Total != 0 {
+		t.Errorf("empty counter previewed %d points", preview.Total)
+	}
+
+	stats := ColdStartStats()
+	if stats[1].Suppressed != 1 || stats[2].Tagged != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	if !strings.HasSuffix(ps[0].Tags, PartialTagk+"=true") {
+		t.Errorf("partial tag missing: %s", ps[0].Tags)
+	}
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/sample_log"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// compositeValue is the latest value of a referenced strategy
+type compositeValue struct {
+	value float64
+	tms   int64
+}
+
+// compositeState to collect values of the strategies referenced by one composite
+// 被引用的策略可能在不同的worker中计算, 需要加锁
+type compositeState struct {
+	sync.Mutex
+	values map[int64]compositeValue
+}
+
+// add to record the value of a referenced strategy
+// 窗口内所有引用的策略都有值时计算出组合的点, 并清空等待下一组;
+// 没有匹配而补的点及NaN不是成员的取值, 不记录
+func (c *compositeState) add(st *scheme.Strategy, memberID int64, p *AnalysPoint) (*AnalysPoint, error) {
+	if p.Unmatched || math.IsNaN(p.Value) {
+		return nil, nil
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	c.values[memberID] = compositeValue{value: p.Value, tms: p.Tms}
+	window := int64(st.CompositeWindowSecs)
+	for id, v := range c.values {
+		if p.Tms-v.tms > window {
+			delete(c.values, id)
+		}
+	}
+	if len(c.values) < len(st.CompositeOf) {
+		return nil, nil
+	}
+
+	vars := make(map[int64]float64, len(c.values))
+	for id, v := range c.values {
+		vars[id] = v.value
+	}
+	c.values = make(map[int64]compositeValue, len(st.CompositeOf))
+	value, err := st.CompositeCalc.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("composite value is %v", value)
+	}
+	return &AnalysPoint{
+		StrategyID: st.ID,
+		Value:      value,
+		Tms:        p.Tms,
+		Tags:       map[string]string{},
+		LogTms:     p.LogTms,
+		EventTms:   st.EventTms(time.Unix(p.LogTms, 0)),
+	}, nil
+}
+
+var (
+	compositeStates     = make(map[int64]*compositeState)
+	compositeStatesLock = new(sync.RWMutex)
+)
+
+func getCompositeState(id int64) *compositeState {
+	compositeStatesLock.RLock()
+	c, ok := compositeStates[id]
+	compositeStatesLock.RUnlock()
+	if ok {
+		return c
+	}
+
+	compositeStatesLock.Lock()
+	defer compositeStatesLock.Unlock()
+	if c, ok = compositeStates[id]; !ok {
+		c = &compositeState{values: make(map[int64]compositeValue)}
+		compositeStates[id] = c
+	}
+	return c
+}
+
+// cleanComposites to drop states of composites deleted or no longer composite
+func cleanComposites(strategyMap map[int64]*scheme.Strategy) {
+	compositeStatesLock.Lock()
+	defer compositeStatesLock.Unlock()
+	for id := range compositeStates {
+		if st, ok := strategyMap[id]; !ok || len(st.CompositeOf) == 0 {
+			delete(compositeStates, id)
+		}
+	}
+}
+
+// feedComposites to feed the point of a strategy into the composites referencing it
+func feedComposites(member *scheme.Strategy, p *AnalysPoint, mark string) {
+	for _, id := range member.CompositeRefs {
+		st, err := strategy.GetByID(id)
+		if err != nil || !st.ParseSucc || st.CompositeCalc == nil || st.Retired(time.Now()) {
+			continue
+		}
+		cp, err := getCompositeState(id).add(st, member.ID, p)
+		if err != nil {
+			sample_log.Error(fmt.Sprintf("%s[composite error][sid:%d] : %v", mark, id, err))
+			continue
+		}
+		if cp != nil {
+			dlog.Debugf("%s[composite point][sid:%d][value:%v]", mark, id, cp.Value)
+			toCounter(cp, mark)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"math"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/expr"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestCompositeState(t *testing.T) {
+	calc, err := expr.Parse("($2 - $1) * 1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &scheme.Strategy{ID: 10, CompositeOf: []int64{1, 2}, CompositeWindowSecs: 5, CompositeCalc: calc}
+	c := &compositeState{values: make(map[int64]compositeValue)}
+
+	if p, err := c.add(st, 1, &AnalysPoint{Value: 1.5, Tms: 100}); p != nil || err != nil {
+		t.Fatalf("should wait for all members, got %v %v", p, err)
+	}
+	p, err := c.add(st, 2, &AnalysPoint{Value: 1.75, Tms: 103, LogTms: 99})
+	if err != nil || p == nil {
+		t.Fatalf("expect composite point, got %v %v", p, err)
+	}
+	if p.StrategyID != 10 || p.Value != 250 || p.Tms != 103 || p.LogTms != 99 || p.Tags == nil {
+		t.Fatalf("unexpected composite point %+v", p)
+	}
+
+	// 不匹配的行补的点及NaN不作为成员的取值
+	if p, _ := c.add(st, 1, &AnalysPoint{Value: -1, Tms: 103, Unmatched: true}); p != nil {
+		t.Fatalf("unmatched fill point should be skipped, got %+v", p)
+	}
+	if p, _ := c.add(st, 1, &AnalysPoint{Value: math.NaN(), Tms: 103}); p != nil {
+		t.Fatalf("NaN should be skipped, got %+v", p)
+	}
+	if len(c.values) != 0 {
+		t.Fatalf("skipped values should not be recorded: %v", c.values)
+	}
+
+	// 凑齐后清空, 超过窗口的值过期
+	if p, _ := c.add(st, 2, &AnalysPoint{Value: 3, Tms: 104}); p != nil {
+		t.Fatal("values should be reset after emitting")
+	}
+	if p, _ := c.add(st, 1, &AnalysPoint{Value: 1, Tms: 110}); p != nil {
+		t.Fatalf("expired value should not be used, got %+v", p)
+	}
+	if p, _ := c.add(st, 2, &AnalysPoint{Value: 1, Tms: 111}); p == nil || p.Value != 0 {
+		t.Fatalf("expect composite point in new window, got %+v", p)
+	}
+
+	// 计算出错时丢弃
+	calc, _ = expr.Parse("$2 / $1")
+	st.CompositeCalc = calc
+	c.add(st, 1, &AnalysPoint{Value: 0, Tms: 120})
+	if p, err := c.add(st, 2, &AnalysPoint{Value: 1, Tms: 120}); p != nil || err == nil {
+		t.Fatalf("division by zero should fail, got %v %v", p, err)
+	}
+}
+
+func TestCleanComposites(t *testing.T) {
+	getCompositeState(10)
+	getCompositeState(11)
+	cleanComposites(map[int64]*scheme.Strategy{10: {ID: 10, CompositeOf: []int64{1, 2}}, 11: {ID: 11}})
+	if _, ok := compositeStates[10]; !ok {
+		t.Fatal("state of composite should be kept")
+	}
+	if _, ok := compositeStates[11]; ok {
+		t.Fatal("state of strategy no longer composite should be dropped")
+	}
+	cleanComposites(nil)
+}
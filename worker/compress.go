@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+// push_compression的取值
+const (
+	PushCompressionNone = "none" //默认, 不压缩
+	PushCompressionAuto = "auto" //按探测结果协商
+)
+
+// 支持的压缩编码, 按优先级排列
+var pushEncodings = []string{"gzip", "deflate"}
+
+// capabilityTTL 探测结果的缓存时间, 过期后推送时在后台重新探测
+var capabilityTTL = 10 * time.Minute
+
+// EndpointCapabilities is the negotiated capabilities of a push endpoint
+type EndpointCapabilities struct {
+	Endpoint string   `json:"endpoint"`
+	Accepted []string `json:"accepted"` //OPTIONS返回的Accept-Encoding
+	Encoding string   `json:"encoding"` //协商出的编码, 为空表示不压缩
+	ProbedAt int64    `json:"probed_at"`
+	Error    string   `json:"error,omitempty"` //探测失败的原因, 失败时不压缩
+}
+
+var (
+	capabilitiesLock sync.Mutex
+	capabilities     = make(map[string]*EndpointCapabilities)
+	probing          = make(map[string]bool) //正在后台探测的端点, 同一端点只探测一次
+)
+
+// ProbeEndpoint to probe the push endpoint with OPTIONS and cache the result
+func ProbeEndpoint(url string) *EndpointCapabilities {
+	c := &EndpointCapabilities{Endpoint: url, ProbedAt: time.Now().Unix()}
+	resp, _, errs := gorequest.New().Options(url).Timeout(5 * time.Second).End()
+	switch {
+	case errs != nil:
+		c.Error = errs[0].Error()
+	case resp.StatusCode >= 300:
+		c.Error = "status " + strconv.Itoa(resp.StatusCode)
+	default:
+		c.Accepted = parseAcceptEncoding(resp.Header.Get("Accept-Encoding"))
+		c.Encoding = negotiateEncoding(c.Accepted)
+	}
+	if c.Error != "" {
+		dlog.Warningf("probe push endpoint failed, push without compression [url:%s][err:%s]", url, c.Error)
+	} else {
+		dlog.Infof("probe push endpoint [url:%s][accepted:%v][encoding:%s]", url, c.Accepted, c.Encoding)
+	}
+
+	capabilitiesLock.Lock()
+	capabilities[url] = c
+	capabilitiesLock.Unlock()
+	return c
+}
+
+// endpointEncoding to get the cached encoding, probing in background if the cache is missing or expires
+// 推送不等待探测, 没有探测结果时不压缩, 过期时沿用旧的结果直到探测完成
+func endpointEncoding(url string) string {
+	capabilitiesLock.Lock()
+	defer capabilitiesLock.Unlock()
+	c, ok := capabilities[url]
+	if (!ok || time.Since(time.Unix(c.ProbedAt, 0)) >= capabilityTTL) && !probing[url] {
+		probing[url] = true
+		go func() {
+			ProbeEndpoint(url)
+			capabilitiesLock.Lock()
+			delete(probing, url)
+			capabilitiesLock.Unlock()
+		}()
+	}
+	if !ok {
+		return ""
+	}
+	return c.Encoding
+}
+
+// rejectEncoding to stop compressing for the endpoint until the next probe, e.g. when it answers 415
+func rejectEncoding(url, encoding string) {
+	capabilitiesLock.Lock()
+	defer capabilitiesLock.Unlock()
+	c := &EndpointCapabilities{Endpoint: url, ProbedAt: time.Now().Unix(),
+		Error: fmt.Sprintf("encoding %s rejected with status 415", encoding)}
+	if old, ok := capabilities[url]; ok {
+		c.Accepted = old.Accepted
+	}
+	capabilities[url] = c
+}
+
+// GetEndpointCapabilities to get capabilities of all probed endpoints
+func GetEndpointCapabilities() []EndpointCapabilities {
+	capabilitiesLock.Lock()
+	defer capabilitiesLock.Unlock()
+	ret := make([]EndpointCapabilities, 0, len(capabilities))
+	for _, c := range capabilities {
+		ret = append(ret, *c)
+	}
+	return ret
+}
+
+// parseAcceptEncoding to get the acceptable codings, q=0 means not acceptable
+func parseAcceptEncoding(header string) []string {
+	ret := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		acceptable := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					acceptable = false
+				}
+			}
+		}
+		if acceptable {
+			ret = append(ret, coding)
+		}
+	}
+	return ret
+}
+
+// negotiateEncoding to choose the first supported encoding accepted by the endpoint
+func negotiateEncoding(accepted []string) string {
+	for _, enc := range pushEncodings {
+		for _, a := range accepted {
+			if a == enc || a == "*" {
+				return enc
+			}
+		}
+	}
+	return ""
+}
+
+// compressPayload to compress the push payload with the encoding
+func compressPayload(payload []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		// HTTP中的deflate是zlib格式
+		w = zlib.NewWriter(&buf)
+	default:
+		return payload, nil
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendPush to post the payload, compressed with the negotiated encoding when compression is auto
+func sendPush(url string, payload []byte, contentType, compression string) (gorequest.Response, string, []error) {
+	encoding := ""
+	if compression == PushCompressionAuto {
+		encoding = endpointEncoding(url)
+	}
+	resp, body, errs, encoding := postPayload(url, payload, contentType, encoding)
+	if errs == nil && encoding != "" && resp.StatusCode == http.StatusUnsupportedMediaType {
+		// 端点不再接受该编码, 本批不压缩重发, 之后不压缩直到重新探测
+		dlog.Warningf("push endpoint rejected encoding, push without compression [url:%s][encoding:%s]", url, encoding)
+		rejectEncoding(url, encoding)
+		resp, body, errs, _ = postPayload(url, payload, contentType, "")
+	}
+	return resp, body, errs
+}
+
+// postPayload to post the payload compressed with encoding, returning the encoding actually used
+func postPayload(url string, payload []byte, contentType, encoding string) (gorequest.Response, string, []error, string) {
+	req := gorequest.New().Post(url).Timeout(10 * time.Second)
+	if contentType != "" && contentType != contentTypeJSON {
+		// 不是json的内容原样发送
+		req.Set("Content-Type", contentType)
+		req.BounceToRawString = true
+	}
+	if encoding != "" {
+		compressed, err := compressPayload(payload, encoding)
+		if err != nil {
+			dlog.Errorf("compress push payload failed, push without compression [encoding:%s][err:%v]", encoding, err)
+			encoding = ""
+		} else {
+			payload = compressed
+			req.Set("Content-Encoding", encoding)
+			// 压缩后的内容不是json, 原样发送
+			req.BounceToRawString = true
+		}
+	}
+	resp, body, errs := req.Send(string(payload)).End()
+	return resp, body, errs, encoding
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	cases := map[string][]string{
+		"":                               {},
+		"gzip":                           {"gzip"},
+		"Deflate, gzip;q=0":              {"deflate"},
+		"br;q=1.0, gzip; q=0.5, *;q=0.1": {"br", "gzip", "*"},
+	}
+	for header, want := range cases {
+		got := parseAcceptEncoding(header)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseAcceptEncoding(%q) = %v, want %v", header, got, want)
+		}
+	}
+	if enc := negotiateEncoding([]string{"br", "deflate", "gzip"}); enc != "gzip" {
+		t.Errorf("gzip should be preferred, got %s", enc)
+	}
+	if enc := negotiateEncoding([]string{"br"}); enc != "" {
+		t.Errorf("unsupported encodings should not be used, got %s", enc)
+	}
+}
+
+func TestPushNegotiation(t *testing.T) {
+	defer func(d time.Duration) { capabilityTTL = d }(capabilityTTL)
+
+	var probes int64
+	accept := "br, gzip"
+	release := make(chan struct{}, 10) //每次探测需要先放行一次
+	var lastEncoding, lastBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			<-release
+			atomic.AddInt64(&probes, 1)
+			w.Header().Set("Accept-Encoding", accept)
+			return
+		}
+		lastEncoding = r.Header.Get("Content-Encoding")
+		body := r.Body
+		if lastEncoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		} else if lastEncoding != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		bs, _ := ioutil.ReadAll(body)
+		lastBody = string(bs)
+	}))
+	defer srv.Close()
+	defer func() {
+		capabilitiesLock.Lock()
+		delete(capabilities, srv.URL)
+		capabilitiesLock.Unlock()
+	}()
+	payload := []byte(`[{"metric":"log.a","value":1}]`)
+
+	// 不开启时不探测
+	if resp, _, errs := sendPush(srv.URL, payload, contentTypeJSON, PushCompressionNone); errs != nil || resp.StatusCode != 200 {
+		t.Fatalf("push failed: %v", errs)
+	}
+	if probes != 0 || lastEncoding != "" || lastBody != string(payload) {
+		t.Fatalf("unexpected plain push: probes %d encoding %q body %s", probes, lastEncoding, lastBody)
+	}
+
+	// 推送不等待探测, 第一次不压缩并在后台探测
+	if resp, _, errs := sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto); errs != nil || resp.StatusCode != 200 {
+		t.Fatalf("push failed: %v", errs)
+	}
+	if lastEncoding != "" || lastBody != string(payload) {
+		t.Fatalf("push before probe should not be compressed: encoding %q", lastEncoding)
+	}
+	release <- struct{}{}
+	waitProbes(t, &probes, 1)
+
+	// 协商出gzip, 缓存期间不再探测
+	for i := 0; i < 3; i++ {
+		if resp, _, errs := sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto); errs != nil || resp.StatusCode != 200 {
+			t.Fatalf("push failed: %v", errs)
+		}
+	}
+	if atomic.LoadInt64(&probes) != 1 || lastEncoding != "gzip" || lastBody != string(payload) {
+		t.Fatalf("unexpected gzip push: probes %d encoding %q body %s", probes, lastEncoding, lastBody)
+	}
+	if c := endpointCaps(srv.URL); c == nil || c.Encoding != "gzip" {
+		t.Fatalf("unexpected capabilities: %+v", c)
+	}
+
+	// 过期后沿用旧的结果, 在后台只探测一次
+	capabilityTTL = 0
+	accept = "br"
+	for i := 0; i < 3; i++ {
+		sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto)
+		if lastEncoding != "gzip" {
+			t.Fatalf("expired result should be used until the probe finishes, got %q", lastEncoding)
+		}
+	}
+	capabilityTTL = 10 * time.Minute
+	release <- struct{}{}
+	waitProbes(t, &probes, 2)
+	sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto)
+	if n := atomic.LoadInt64(&probes); n != 2 || lastEncoding != "" || lastBody != string(payload) {
+		t.Fatalf("should push without compression after re-probe: probes %d encoding %q", n, lastEncoding)
+	}
+
+	// 415时本批不压缩重发, 之后不压缩也不再探测
+	accept = "deflate"
+	release <- struct{}{}
+	ProbeEndpoint(srv.URL)
+	if resp, _, errs := sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto); errs != nil || resp.StatusCode != 200 {
+		t.Fatalf("batch rejected with 415 should be resent uncompressed: %v %+v", errs, resp)
+	}
+	if lastEncoding != "" || lastBody != string(payload) {
+		t.Fatalf("resent batch should not be compressed: encoding %q body %s", lastEncoding, lastBody)
+	}
+	sendPush(srv.URL, payload, contentTypeJSON, PushCompressionAuto)
+	if n := atomic.LoadInt64(&probes); n != 3 || lastEncoding != "" {
+		t.Fatalf("should push without compression until next probe: probes %d encoding %q", n, lastEncoding)
+	}
+	if c := endpointCaps(srv.URL); c == nil || c.Encoding != "" || c.Error == "" {
+		t.Fatalf("rejected encoding should be recorded: %+v", c)
+	}
+
+	// 探测失败时不压缩
+	close(release)
+	srv.Close()
+	if c := ProbeEndpoint(srv.URL); c.Error == "" || c.Encoding != "" {
+		t.Fatalf("probe of closed server should fail: %+v", c)
+	}
+}
+
+func waitProbes(t *testing.T, probes *int64, n int64) {
+	for i := 0; i < 100; i++ {
+		capabilitiesLock.Lock()
+		done := atomic.LoadInt64(probes) == n && len(probing) == 0
+		capabilitiesLock.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expect %d probes, got %d", n, atomic.LoadInt64(probes))
+}
+
+func endpointCaps(url string) *EndpointCapabilities {
+	for _, c := range GetEndpointCapabilities() {
+		if c.Endpoint == url {
+			return &c
+		}
+	}
+	return nil
+}
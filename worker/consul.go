@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+const (
+	defaultConsulAddr            = "127.0.0.1:8500"
+	defaultConsulRefreshInterval = 30
+	defaultConsulTimeoutMs       = 3000
+)
+
+// consulServiceEntry is an entry returned by consul /v1/health/service/<name>, only the fields used
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// ConsulResolver to resolve the push url from the healthy instances of a consul service
+// 只使用consul的HTTP API(/v1/health/service/<name>?passing), 不依赖consul的客户端库;
+// 每次推送按轮询取一个实例, 定期刷新实例列表, 刷新失败或没有健康实例时沿用上一次的列表
+type ConsulResolver struct {
+	addr    string
+	service string
+	tag     string
+	token   string
+	scheme  string
+	path    string
+	client  *http.Client
+
+	lock    sync.RWMutex
+	urls    []string
+	next    uint64
+	updated int64
+	lastErr string
+}
+
+// ConsulResolverStat is the state of the consul resolver
+type ConsulResolverStat struct {
+	Service string   `json:"service"`
+	URLs    []string `json:"urls"`
+	Updated int64    `json:"updated"`
+	Error   string   `json:"error,omitempty"` //最近一次刷新的错误
+}
+
+// NewConsulResolver to create a resolver of the service by worker.push_consul
+func NewConsulResolver(addr, service, tag, token, scheme, path string, timeout time.Duration) *ConsulResolver {
+	if addr == "" {
+		addr = defaultConsulAddr
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &ConsulResolver{
+		addr:    addr,
+		service: service,
+		tag:     tag,
+		token:   token,
+		scheme:  scheme,
+		path:    path,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Refresh to query the healthy instances of the service
+func (r *ConsulResolver) Refresh() error {
+	urls, err := r.query()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil && len(urls) == 0 {
+		err = fmt.Errorf("no healthy instance of service %s", r.service)
+	}
+	if err != nil {
+		r.lastErr = err.Error()
+		dlog.Warningf("resolve push url from consul failed, keep %d urls resolved before [service:%s][err:%v]", len(r.urls), r.service, err)
+		return err
+	}
+	if strings.Join(urls, ",") != strings.Join(r.urls, ",") {
+		dlog.Infof("push urls resolved from consul [service:%s][urls:%v]", r.service, urls)
+	}
+	r.urls, r.updated, r.lastErr = urls, time.Now().Unix(), ""
+	return nil
+}
+
+func (r *ConsulResolver) query() ([]string, error) {
+	q := url.Values{}
+	q.Set("passing", "1")
+	if r.tag != "" {
+		q.Set("tag", r.tag)
+	}
+	req, err := http.NewRequest("GET", "http://"+r.addr+"/v1/health/service/"+url.PathEscape(r.service)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.token != "" {
+		req.Header.Set("X-Consul-Token", r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul status %d", resp.StatusCode)
+	}
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode consul response failed: %v", err)
+	}
+
+	urls := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		// 服务没有单独注册地址时使用节点的地址
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if host == "" || e.Service.Port <= 0 {
+			continue
+		}
+		u := r.scheme + "://" + host + ":" + strconv.Itoa(e.Service.Port) + r.path
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	// 排序后轮询的顺序与consul返回的顺序无关
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// Resolve to pick the next instance by round-robin, false if none is resolved yet
+func (r *ConsulResolver) Resolve() (string, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.urls) == 0 {
+		return "", false
+	}
+	n := atomic.AddUint64(&r.next, 1) - 1
+	return r.urls[n%uint64(len(r.urls))], true
+}
+
+// Stat to get the state of the resolver
+func (r *ConsulResolver) Stat() ConsulResolverStat {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return ConsulResolverStat{Service: r.service, URLs: append([]string{}, r.urls...), Updated: r.updated, Error: r.lastErr}
+}
+
+// Run to refresh the instances periodically
+func (r *ConsulResolver) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		r.Refresh()
+	}
+}
+
+var pushResolver atomic.Value //*ConsulResolver
+
+// StartPushResolver to resolve the push url from consul if worker.push_consul.service is configured
+func StartPushResolver() {
+	c := g.Conf().Worker.PushConsul
+	if c.Service == "" {
+		return
+	}
+	timeout := c.TimeoutMs
+	if timeout <= 0 {
+		timeout = defaultConsulTimeoutMs
+	}
+	interval := c.RefreshInterval
+	if interval <= 0 {
+		interval = defaultConsulRefreshInterval
+	}
+	r := NewConsulResolver(c.Addr, c.Service, c.Tag, c.Token, c.Scheme, c.Path, time.Duration(timeout)*time.Millisecond)
+	r.Refresh()
+	pushResolver.Store(r)
+	go r.Run(time.Duration(interval) * time.Second)
+}
+
+// GetPushResolverStat to get the state of the consul resolver, false if not used
+func GetPushResolverStat() (ConsulResolverStat, bool) {
+	r, ok := pushResolver.Load().(*ConsulResolver)
+	if !ok {
+		return ConsulResolverStat{}, false
+	}
+	return r.Stat(), true
+}
+
+// pushURL to get the url to push to, resolved from consul if configured, push_url as the fallback
+func pushURL() string {
+	if r, ok := pushResolver.Load().(*ConsulResolver); ok {
+		if u, ok := r.Resolve(); ok {
+			return u
+		}
+		dlog.Warningf("no push url resolved from consul, use push_url [service:%s][push_url:%s]", r.service, g.Conf().Worker.PushURL)
+	}
+	return g.Conf().Worker.PushURL
+}
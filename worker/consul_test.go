@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConsul to serve /v1/health/service/<name> with the entries, status other than 200 when fail is set
+func fakeConsul(t *testing.T, entries *atomic.Value, fail *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/health/service/falcon-agent" || req.URL.Query().Get("passing") == "" {
+			t.Errorf("unexpected consul request %s", req.URL)
+		}
+		if req.Header.Get("X-Consul-Token") != "secret" {
+			t.Errorf("token not sent")
+		}
+		if atomic.LoadInt32(fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entries.Load())
+	}))
+}
+
+func consulEntries(hosts ...string) []map[string]interface{} {
+	ret := make([]map[string]interface{}, 0, len(hosts))
+	for _, h := range hosts {
+		parts := strings.Split(h, ":")
+		ret = append(ret, map[string]interface{}{
+			"Node":    map[string]interface{}{"Address": "10.0.0.100"},
+			"Service": map[string]interface{}{"Address": parts[0], "Port": json.Number(parts[1])},
+		})
+	}
+	return ret
+}
+
+func TestConsulResolver(t *testing.T) {
+	var entries atomic.Value
+	var fail int32
+	entries.Store(consulEntries("10.0.0.2:1988", "10.0.0.1:1988", ":2000"))
+	srv := fakeConsul(t, &entries, &fail)
+	defer srv.Close()
+
+	r := NewConsulResolver(strings.TrimPrefix(srv.URL, "http://"), "falcon-agent", "", "secret", "", "/v1/push", time.Second)
+	if _, ok := r.Resolve(); ok {
+		t.Fatal("resolved before refresh")
+	}
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// 按轮询依次选择, 没有服务地址的实例使用节点地址
+	want := []string{"http://10.0.0.100:2000/v1/push", "http://10.0.0.1:1988/v1/push", "http://10.0.0.2:1988/v1/push"}
+	for i := 0; i < 6; i++ {
+		if u, _ := r.Resolve(); u != want[i%3] {
+			t.Fatalf("pick %d: %s, want %s", i, u, want[i%3])
+		}
+	}
+
+	// 刷新失败及没有健康实例时沿用上一次的列表
+	atomic.StoreInt32(&fail, 1)
+	if err := r.Refresh(); err == nil || r.Stat().Error == "" {
+		t.Fatal("refresh should fail")
+	}
+	atomic.StoreInt32(&fail, 0)
+	entries.Store(consulEntries())
+	if err := r.Refresh(); err == nil {
+		t.Fatal("refresh without healthy instance should fail")
+	}
+	if stat := r.Stat(); len(stat.URLs) != 3 {
+		t.Fatalf("urls %v, want kept", stat.URLs)
+	}
+
+	// 实例变化后只选择新的实例
+	entries.Store(consulEntries("10.0.0.3:1988"))
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if u, _ := r.Resolve(); u != "http://10.0.0.3:1988/v1/push" {
+			t.Fatalf("pick %s after instances changed", u)
+		}
+	}
+	if stat := r.Stat(); stat.Error != "" || stat.Updated == 0 {
+		t.Fatalf("stat %+v", stat)
+	}
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
@@ -19,10 +20,30 @@ type ConfigInfo struct {
 	FilePath string
 }
 
+// logReader is the line source of a job
+// 文件使用reader.Reader, 命名管道使用reader.FIFOReader, otlp://路径使用reader.OTLPLogReader
+type logReader interface {
+	Start()
+	Stop()
+}
+
 // Job to control job
 type Job struct {
-	r *reader.Reader
+	r logReader
 	w *WorkerGroup
+	// 开启max_strategies_per_file后, 由fan把日志行分发给各个shard
+	fan     *fanout
+	shards  []*WorkerGroup
+	started int64 //job创建的时间
+	round   int64 //创建时的策略更新轮次
+}
+
+// groups to get all worker groups of the job
+func (j *Job) groups() []*WorkerGroup {
+	if len(j.shards) == 0 {
+		return []*WorkerGroup{j.w}
+	}
+	return j.shards
 }
 
 // ManagerJob to manage jobs
@@ -33,6 +54,9 @@ var ManagerJobLock *sync.RWMutex
 // ManagerConfig to manage configs
 var ManagerConfig map[int64]*ConfigInfo
 
+// configRound 策略更新的轮次, 同一轮内为同一文件创建的策略视为同时开始
+var configRound int64
+
 func init() {
 	ManagerJob = make(map[string]*Job)
 	ManagerJobLock = new(sync.RWMutex)
@@ -40,19 +64,23 @@ func init() {
 }
 
 // UpdateConfigsLoop to update strategys
+// etcd等来源有变化时通过strategy.RequestReload立即触发一轮, 不等待update_duration
 func UpdateConfigsLoop() {
+	var reloaded chan struct{}
 	for {
 		strategy.Update()
 		strategyMap := strategy.GetAll() //最新策略
 		ManagerJobLock.Lock()
+		configRound++
 
 		for id, st := range strategyMap {
 			config := &ConfigInfo{
 				ID:       id,
 				FilePath: st.FilePath,
 			}
-			cache := make(chan string, g.Conf().Worker.QueueSize)
-			if err := createJob(config, cache, st); err != nil {
+			cache := make(chan reader.Line, g.Conf().Worker.QueueSize)
+			// 被本机其他agent锁住的文件由registry告警, 这里不再重复打错误日志
+			if err := createJob(config, cache, st); err != nil && err != ErrFileLocked {
 				dlog.Errorf("create job fail [id:%d][filePath:%s][err:%v]", config.ID, config.FilePath, err)
 			}
 		}
@@ -66,51 +94,131 @@ func UpdateConfigsLoop() {
 				deleteJob(config)
 			}
 		}
+		reshardJobs(strategyMap)
 		ManagerJobLock.Unlock()
 
 		//更新counter
 		GlobalCount.UpdateByStrategy(strategyMap)
-		time.Sleep(time.Second * time.Duration(g.Conf().Strategy.UpdateDuration))
+		cleanAnomalyDetectors(strategyMap)
+		cleanComposites(strategyMap)
+		cleanStrategyLabels(strategyMap)
+		cleanValueRangeStats(strategyMap)
+		cleanValueMapStats(strategyMap)
+		cleanDeltaStates(strategyMap)
+		cleanEpisodeTrackers(strategyMap)
+		cleanMatchSamplers(strategyMap)
+		cleanBoosts(strategyMap)
+		cleanStrategyLimiters(strategyMap)
+		cleanTombstones(strategyMap)
+		cleanTagOversizeStats(strategyMap)
+		cleanColdStartStats(strategyMap)
+		cleanFunnelStats(strategyMap)
+		cleanExcludeRatios(strategyMap)
+		cleanSlidingWindows(strategyMap)
+		closeWriteBacks(strategyMap)
+		if reloaded != nil {
+			close(reloaded)
+			reloaded = nil
+		}
+		select {
+		case reloaded = <-strategy.ReloadRequests():
+		case <-time.After(time.Second * time.Duration(g.Conf().Strategy.UpdateDuration)):
+		}
 	}
 }
 
 func GetLatestTmsAndDelay(filepath string) (int64, int64, bool) {
 	ManagerJobLock.RLock()
 	job, ok := ManagerJob[filepath]
-	ManagerJobLock.RUnlock()
-
 	if !ok {
+		ManagerJobLock.RUnlock()
 		return 0, 0, false
 	}
-	latest, delay := job.w.GetLatestTmsAndDelay()
+
+	// 多个shard时, 取最慢的latestTms和最大的乱序差值, 宁可晚推不可漏推
+	var latest, delay int64
+	for i, wg := range job.groups() {
+		l, d := wg.GetLatestTmsAndDelay()
+		if i == 0 || l < latest {
+			latest = l
+		}
+		if d > delay {
+			delay = d
+		}
+	}
+	ManagerJobLock.RUnlock()
 	return latest, delay, true
 }
 
 //添加任务到管理map( managerjob managerconfig) 启动reader和worker
-func createJob(config *ConfigInfo, cache chan string, st *scheme.Strategy) error {
+func createJob(config *ConfigInfo, cache chan reader.Line, st *scheme.Strategy) error {
+	// 引用的策略都不存在的组合策略没有文件
+	if config.FilePath == "" {
+		return errors.New("empty file path")
+	}
 	if _, ok := ManagerJob[config.FilePath]; ok {
 		if _, ok := ManagerConfig[config.ID]; !ok {
 			ManagerConfig[config.ID] = config
+			// 文件在之前的轮次已开始消费, 新加入的策略从此时开始计算第一个周期
+			if ManagerJob[config.FilePath].round != configRound {
+				bindColdStart(config.ID, config.FilePath)
+			}
 		}
 		//依赖策略的周期更新, 触发文件乱序时间戳的重置
-		ManagerJob[config.FilePath].w.ResetMaxDelay()
+		for _, wg := range ManagerJob[config.FilePath].groups() {
+			wg.ResetMaxDelay()
+		}
 		return nil
 	}
 
+	// 本机其他agent已在处理该文件时不启动, 避免重复上报
+	if err := lockJobFile(config.FilePath); err != nil {
+		return err
+	}
 	ManagerConfig[config.ID] = config
+	if replayEnabled(config.FilePath) {
+		addReplayGuard(config.FilePath)
+	}
+	if tagCardinalityEnabled() {
+		addTagCardinality(config.FilePath)
+	}
 	//启动reader
-	r, err := reader.NewReader(config.FilePath, cache)
-	if err != nil {
-		return err
+	var r logReader
+	if reader.IsOTLPPath(config.FilePath) {
+		or, err := reader.NewOTLPLogReader(config.FilePath, cache)
+		if err != nil {
+			unlockJobFile(config.FilePath)
+			return err
+		}
+		r = or
+	} else if reader.IsFIFO(config.FilePath) {
+		timeout := time.Duration(g.Conf().Reader.FIFOOpenTimeout) * time.Second
+		fr, err := reader.NewFIFOReader(config.FilePath, cache, timeout)
+		if err != nil {
+			unlockJobFile(config.FilePath)
+			return err
+		}
+		r = fr
+	} else {
+		fr, err := reader.NewReader(config.FilePath, cache)
+		if err != nil {
+			unlockJobFile(config.FilePath)
+			return err
+		}
+		r = fr
 	}
 	dlog.Infof("Add Reader : [%s]", config.FilePath)
 	//启动worker
-	w := NewWorkerGroup(config.FilePath, cache, st)
-	ManagerJob[config.FilePath] = &Job{
-		r: r,
-		w: w,
+	job := &Job{r: r, started: time.Now().Unix(), round: configRound}
+	if g.Conf().Worker.MaxStrategiesPerFile > 0 {
+		job.fan = newFanout(config.FilePath, cache)
+		job.addShard(config.FilePath, g.Conf().Worker.QueueSize)
+		go job.fan.run()
+	} else {
+		job.w = NewWorkerGroup(config.FilePath, cache, st)
+		job.w.Start()
 	}
-	w.Start()
+	ManagerJob[config.FilePath] = job
 	//启动reader
 	go r.Start()
 
@@ -130,9 +238,14 @@ func deleteJob(config *ConfigInfo) {
 	if tag <= 1 {
 		dlog.Infof("Del Reader : [%s]", config.FilePath)
 		if job, ok := ManagerJob[config.FilePath]; ok {
-			job.w.Stop() //先stop worker
+			for _, wg := range job.groups() {
+				wg.Stop() //先stop worker
+			}
 			job.r.Stop()
 			delete(ManagerJob, config.FilePath)
+			removeReplayGuard(config.FilePath)
+			removeTagCardinality(config.FilePath)
+			unlockJobFile(config.FilePath)
 		}
 	}
 	dlog.Infof("Stop reader & worker success [filePath:%s][sid:%d]", config.FilePath, config.ID)
@@ -141,4 +254,64 @@ func deleteJob(config *ConfigInfo) {
 	if _, ok := ManagerConfig[config.ID]; ok {
 		delete(ManagerConfig, config.ID)
 	}
+	unbindColdStart(config.ID)
+}
+
+// addShard to add a worker group with its own stream of size to the job
+func (j *Job) addShard(filePath string, size int) *WorkerGroup {
+	stream := make(chan reader.Line, size)
+	wg := newShardWorkerGroup(filePath, stream, len(j.shards))
+	wg.SetStrategyIDs([]int64{})
+	j.fan.add(wg)
+	j.shards = append(j.shards, wg)
+	j.w = j.shards[0]
+	wg.Start()
+	return wg
+}
+
+// removeShard to stop and remove the last worker group of the job
+func (j *Job) removeShard() {
+	last := j.shards[len(j.shards)-1]
+	// 先停worker再从fan中摘掉, 之间fan写入的行随group丢弃
+	last.Stop()
+	j.fan.remove(last)
+	j.shards = j.shards[:len(j.shards)-1]
+}
+
+// reshardJobs to assign strategies to the shards of each file
+// 单文件策略数超过max_strategies_per_file时, 按策略ID排序拆分到多个worker group
+// 调用方需持有ManagerJobLock
+func reshardJobs(strategyMap map[int64]*scheme.Strategy) {
+	max := g.Conf().Worker.MaxStrategiesPerFile
+	if max <= 0 {
+		return
+	}
+
+	fileIDs := make(map[string][]int64)
+	for id, st := range strategyMap {
+		fileIDs[st.FilePath] = append(fileIDs[st.FilePath], id)
+	}
+
+	for filePath, job := range ManagerJob {
+		if job.fan == nil {
+			continue
+		}
+		ids := fileIDs[filePath]
+		shards := shardStrategyIDs(ids, max)
+		if len(ids) > max && len(shards) != len(job.shards) {
+			dlog.Warningf("too many strategies for one file, split into worker groups [file:%s][strategies:%d][max_strategies_per_file:%d][groups:%d]",
+				filePath, len(ids), max, len(shards))
+		}
+
+		// 已有shard的队列不变, 新增的按目标shard数平分queue_size
+		for len(job.shards) < len(shards) {
+			job.addShard(filePath, shardQueueSize(g.Conf().Worker.QueueSize, len(shards)))
+		}
+		for len(job.shards) > len(shards) {
+			job.removeShard()
+		}
+		for i, shard := range shards {
+			job.shards[i].SetStrategyIDs(shard)
+		}
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
@@ -21,6 +22,10 @@ type AnalysPoint struct {
 	Value      float64
 	Tms        int64
 	Tags       map[string]string
+	LogTms     int64 //日志中解析出的时间, 只用于调试
+	EventTms   int64 //日志中解析出的时间, 精度见策略的timestamp_precision, 随点发给逐点的sink
+	Gen        int64 //产生该点的策略代数, 0表示未知
+	Unmatched  bool  //pattern没有匹配到而补零的点
 }
 
 // PointCounter to analysis
@@ -31,14 +36,19 @@ type PointCounter struct {
 	Sum   float64
 	Max   float64
 	Min   float64
+	Gens  GenSplit //参与聚合的策略代数及各自的观测数
 }
 
 // PointsCounter to index the data
 // 单策略下，单step的统计对象
 // 以Sorted的tagkv的字符串来做索引
+// tag组合数达到maxTagSets后, 新的组合合并到OverflowTagstring, 已有的组合不受影响
 type PointsCounter struct {
 	sync.RWMutex
 	TagstringMap map[string]*PointCounter
+	maxTagSets   int               //tag组合数上限, 0不限制
+	tagSets      int               //已分配的tag组合数, 不含溢出序列
+	suppressed   *suppressedSketch //合并到溢出序列的tag组合
 }
 
 // StrategyCounter to
@@ -50,67 +60,175 @@ type StrategyCounter struct {
 	TmsPoints map[int64]*PointsCounter //按照时间戳分类的分别的counter
 }
 
+// DefaultCounterShards 全局counter的分片数
+const DefaultCounterShards = 32
+
+// counterShard is one shard of the global counter
+// 每个分片有自己的锁, 不同分片的策略互不竞争
+type counterShard struct {
+	sync.RWMutex
+	StrategyCounts map[int64]*StrategyCounter
+	lockWaits      int64 //拿锁时需要等待的次数
+	lockWaitNanos  int64 //等待的总时长
+}
+
+// rlock to read lock the shard, and record the wait if contended
+func (cs *counterShard) rlock() {
+	if cs.TryRLock() {
+		return
+	}
+	start := time.Now()
+	cs.RLock()
+	atomic.AddInt64(&cs.lockWaits, 1)
+	atomic.AddInt64(&cs.lockWaitNanos, int64(time.Since(start)))
+}
+
+// lock to lock the shard, and record the wait if contended
+func (cs *counterShard) lock() {
+	if cs.TryLock() {
+		return
+	}
+	start := time.Now()
+	cs.Lock()
+	atomic.AddInt64(&cs.lockWaits, 1)
+	atomic.AddInt64(&cs.lockWaitNanos, int64(time.Since(start)))
+}
+
 // GlobalCounter to be as a global counter store
 // 全局counter对象, 以key为索引，索引每个策略的统计
-// key : Strategy ID
+// key : Strategy ID, 按ID分片, 每个分片独立加锁
 type GlobalCounter struct {
-	sync.RWMutex
-	StrategyCounts map[int64]*StrategyCounter
+	shards []*counterShard
+}
+
+// CounterShardStat to show depth and contention of one shard
+type CounterShardStat struct {
+	Shard      int     `json:"shard"`
+	Strategies int     `json:"strategies"`
+	Tms        int     `json:"tms"` //待推送的周期数
+	LockWaits  int64   `json:"lock_waits"`
+	LockWaitMs float64 `json:"lock_wait_ms"`
 }
 
 // GlobalCount to be as a global counter store
 var GlobalCount *GlobalCounter
 
 func init() {
-	GlobalCount = new(GlobalCounter)
-	GlobalCount.StrategyCounts = make(map[int64]*StrategyCounter)
+	GlobalCount = NewGlobalCounter(DefaultCounterShards)
+}
+
+// NewGlobalCounter to create a counter with n shards
+func NewGlobalCounter(n int) *GlobalCounter {
+	if n <= 0 {
+		n = 1
+	}
+	gc := &GlobalCounter{shards: make([]*counterShard, n)}
+	for i := range gc.shards {
+		gc.shards[i] = &counterShard{StrategyCounts: make(map[int64]*StrategyCounter)}
+	}
+	return gc
+}
+
+// shard to get the shard of a strategy
+// 策略ID基本是自增的, 直接取模就能均匀分布
+func (gc *GlobalCounter) shard(id int64) *counterShard {
+	return gc.shards[uint64(id)%uint64(len(gc.shards))]
+}
+
+// ShardStats to get depth and contention of all shards
+func (gc *GlobalCounter) ShardStats() []CounterShardStat {
+	ret := make([]CounterShardStat, 0, len(gc.shards))
+	for i, cs := range gc.shards {
+		stat := CounterShardStat{
+			Shard:      i,
+			LockWaits:  atomic.LoadInt64(&cs.lockWaits),
+			LockWaitMs: float64(atomic.LoadInt64(&cs.lockWaitNanos)) / float64(time.Millisecond),
+		}
+		cs.RLock()
+		stat.Strategies = len(cs.StrategyCounts)
+		for _, sc := range cs.StrategyCounts {
+			sc.RLock()
+			stat.Tms += len(sc.TmsPoints)
+			sc.RUnlock()
+		}
+		cs.RUnlock()
+		ret = append(ret, stat)
+	}
+	return ret
 }
 
 // PushToCount to push to count module
 // 提供给Worker用来Push计算后的信息
 // 需保证线程安全
 func PushToCount(Point *AnalysPoint) error {
-	stCount, err := GlobalCount.GetStrategyCountByID(Point.StrategyID)
+	return GlobalCount.Push(Point)
+}
+
+// Push to aggregate the point into counter
+func (gc *GlobalCounter) Push(Point *AnalysPoint) error {
+	tmsCount, err := gc.tmsCounter(Point.StrategyID, Point.Tms)
+	if err != nil {
+		return err
+	}
+
+	//拿到tmsCount, 更新TagstringMap
+	tagstring := utils.SortedTags(Point.Tags)
+	return tmsCount.update(tagstring, Point.Value, Point.Gen, Point.Unmatched)
+}
+
+// Merge to add a partial aggregation of the tagstring into counter, see stepAggregator
+func (gc *GlobalCounter) Merge(sid, tms int64, tagstring string, part *PointCounter) error {
+	tmsCount, err := gc.tmsCounter(sid, tms)
+	if err != nil {
+		return err
+	}
+	return tmsCount.Merge(tagstring, part)
+}
+
+// tmsCounter to get the counter of the step of tms, created if not exists
+func (gc *GlobalCounter) tmsCounter(sid, tms int64) (*PointsCounter, error) {
+	stCount, err := gc.GetStrategyCountByID(sid)
 
 	// 更新strategyCounts
 	if err != nil {
-		strategy, err := strategy.GetByID(Point.StrategyID)
+		strategy, err := strategy.GetByID(sid)
 		if err != nil {
 			dlog.Errorf("GetByID ERROR when count:[%v]", err)
-			return err
+			return nil, err
 		}
 
-		GlobalCount.AddStrategyCount(strategy)
+		gc.AddStrategyCount(strategy)
 
-		stCount, err = GlobalCount.GetStrategyCountByID(Point.StrategyID)
+		stCount, err = gc.GetStrategyCountByID(sid)
 		// 还拿不到，就出错返回吧
 		if err != nil {
 			dlog.Errorf("Get strategyCount Failed after addition: %v", err)
-			return err
+			return nil, err
 		}
 	}
 
 	// 拿到stCount，更新StepCounts
-	stepTms := AlignStepTms(stCount.Strategy.Interval, Point.Tms)
+	// 无法按step分桶的点直接报错, 避免不同step的点互相覆盖; 由调用方打日志
+	if err := strategy.CheckStep(stCount.Strategy.Interval, strategy.PushCycle()); err != nil {
+		return nil, fmt.Errorf("cannot bucket point [sid:%d]: %w", sid, err)
+	}
+	stepTms := AlignStepTms(stCount.Strategy.Interval, tms)
 	tmsCount, err := stCount.GetByTms(stepTms)
 	if err != nil {
 		err := stCount.AddTms(stepTms)
 		if err != nil {
 			dlog.Errorf("Add tms to strategy error: %v", err)
-			return err
+			return nil, err
 		}
 
 		tmsCount, err = stCount.GetByTms(stepTms)
 		// 还拿不到，就出错返回吧
 		if err != nil {
 			dlog.Errorf("Get tmsCount Failed By Twice Add: %v", err)
-			return err
+			return nil, err
 		}
 	}
-
-	//拿到tmsCount, 更新TagstringMap
-	tagstring := utils.SortedTags(Point.Tags)
-	return tmsCount.Update(tagstring, Point.Value)
+	return tmsCount, nil
 }
 
 // AlignStepTms to align the step
@@ -135,6 +253,33 @@ func (pc *PointsCounter) GetBytagstring(tagstring string) (*PointCounter, error)
 	return point, nil
 }
 
+// copy to copy the values, caller should hold the lock
+func (pc *PointCounter) copy() PointCounter {
+	return PointCounter{Count: pc.Count, Sum: pc.Sum, Max: pc.Max, Min: pc.Min, Gens: pc.Gens.clone()}
+}
+
+// clone to copy the counter of a step under the locks, the copy is not shared
+// 供/v1/push/preview使用, 不影响正在累加的counter
+func (pc *PointsCounter) clone() *PointsCounter {
+	pc.RLock()
+	defer pc.RUnlock()
+	ret := &PointsCounter{
+		TagstringMap: make(map[string]*PointCounter, len(pc.TagstringMap)),
+		maxTagSets:   pc.maxTagSets,
+		tagSets:      pc.tagSets,
+	}
+	if pc.suppressed != nil {
+		ret.suppressed = pc.suppressed.clone()
+	}
+	for tagstring, p := range pc.TagstringMap {
+		p.RLock()
+		c := p.copy()
+		p.RUnlock()
+		ret.TagstringMap[tagstring] = &c
+	}
+	return ret
+}
+
 // UpdateCnt to update count
 func (pc *PointCounter) UpdateCnt() {
 	atomic.AddInt64(&pc.Count, 1)
@@ -177,37 +322,30 @@ func (pc *PointCounter) UpdateMaxMin(value float64) {
 
 // Update to update value
 func (pc *PointsCounter) Update(tagstring string, value float64) error {
-	pointCount, err := pc.GetBytagstring(tagstring)
-	if err != nil {
-		pc.Lock()
-		tmp := new(PointCounter)
-		tmp.Count = 0
-		tmp.Sum = 0
+	return pc.update(tagstring, value, 0, false)
+}
 
-		if value == -1 {
-			tmp.Sum = math.NaN() //补零逻辑，不处理Sum
-		}
-		tmp.Max = math.NaN()
-		tmp.Min = math.NaN()
-		pc.TagstringMap[tagstring] = tmp
-		pc.Unlock()
+// UpdateUnmatched to record a fill point of a line the pattern did not match
+func (pc *PointsCounter) UpdateUnmatched(tagstring string) error {
+	return pc.update(tagstring, -1, 0, true)
+}
 
-		pointCount, err = pc.GetBytagstring(tagstring)
-		// 如果还是拿不到，就出错返回吧
-		if err != nil {
-			return fmt.Errorf("when update, cannot get pointCount after add [tagstring:%s]", tagstring)
-		}
+// update to update value observed by generation gen of the strategy, unmatched为补零的点
+func (pc *PointsCounter) update(tagstring string, value float64, gen int64, unmatched bool) error {
+	pointCount, err := pc.counterFor(tagstring, unmatched)
+	if pointCount == nil {
+		return err
 	}
 
 	pointCount.Lock()
 
-	if value == -1 {
-		//如果匹配不到默认将值置为-1，判断当值等于-1那么统计时候cnt为0，sum为-1
+	if unmatched {
+		//如果匹配不到默认将值置为-1(unmatched)，那么统计时候cnt为0，sum为-1
 		pointCount.Count = 0
 		//pointCount.Sum = -1
 	}
-	if value != -1 {
-		//如果匹配不到默认将值置为-1，判断当值不等于-1那么统计时候正常处理
+	if !unmatched {
+		//匹配到的值(包括真实的-1)正常处理
 
 	pointCount.Sum = pointCount.Sum + value
 	pointCount.Count = pointCount.Count + 1
@@ -217,6 +355,7 @@ func (pc *PointsCounter) Update(tagstring string, value float64) error {
 	if math.IsNaN(pointCount.Min) || value < pointCount.Min {
 		pointCount.Min = value
 	}
+	pointCount.Gens.add(gen, 1)
 	}
 
 
@@ -225,6 +364,70 @@ func (pc *PointsCounter) Update(tagstring string, value float64) error {
 	return nil
 }
 
+// Merge to add a partial aggregation of count, sum, max and min
+// 与逐个Update part中的每个值结果相同, 只拿一次锁
+func (pc *PointsCounter) Merge(tagstring string, part *PointCounter) error {
+	pointCount, err := pc.counterFor(tagstring, false)
+	if pointCount == nil {
+		return err
+	}
+
+	pointCount.Lock()
+	pointCount.Sum = pointCount.Sum + part.Sum
+	pointCount.Count = pointCount.Count + part.Count
+	if math.IsNaN(pointCount.Max) || part.Max > pointCount.Max {
+		pointCount.Max = part.Max
+	}
+	if math.IsNaN(pointCount.Min) || part.Min < pointCount.Min {
+		pointCount.Min = part.Min
+	}
+	pointCount.Gens.merge(part.Gens)
+	pointCount.Unlock()
+	return nil
+}
+
+// counterFor to get the counter of tagstring, created if not exists
+// 超过tag组合数上限时返回溢出序列; 补零的点不进入溢出序列, 返回nil
+func (pc *PointsCounter) counterFor(tagstring string, unmatched bool) (*PointCounter, error) {
+	pointCount, err := pc.GetBytagstring(tagstring)
+	if err != nil {
+		pc.Lock()
+		// 加锁后再检查一次, 并发创建时不覆盖其他worker已写入的统计
+		if _, ok := pc.TagstringMap[tagstring]; !ok && pc.full() {
+			pc.suppress(tagstring)
+			tagstring = OverflowTagstring
+			if unmatched {
+				// 补零的点没有观测值, 不进入溢出序列, 以免重置其计数
+				pc.Unlock()
+				return nil, nil
+			}
+			if _, ok := pc.TagstringMap[tagstring]; !ok {
+				pc.TagstringMap[tagstring] = &PointCounter{Max: math.NaN(), Min: math.NaN()}
+			}
+		} else if !ok {
+			pc.tagSets++
+			tmp := new(PointCounter)
+			tmp.Count = 0
+			tmp.Sum = 0
+
+			if unmatched {
+				tmp.Sum = math.NaN() //补零逻辑，不处理Sum
+			}
+			tmp.Max = math.NaN()
+			tmp.Min = math.NaN()
+			pc.TagstringMap[tagstring] = tmp
+		}
+		pc.Unlock()
+
+		pointCount, err = pc.GetBytagstring(tagstring)
+		// 如果还是拿不到，就出错返回吧
+		if err != nil {
+			return nil, fmt.Errorf("when update, cannot get pointCount after add [tagstring:%s]", tagstring)
+		}
+	}
+	return pointCount, nil
+}
+
 func addFloat64(val *float64, delta float64) (new float64) {
 	for {
 		old := *val
@@ -277,6 +480,7 @@ func (sc *StrategyCounter) AddTms(tms int64) error {
 	if !ok {
 		tmp := new(PointsCounter)
 		tmp.TagstringMap = make(map[string]*PointCounter, 0)
+		tmp.maxTagSets = maxTagSets(sc.Strategy)
 		sc.TmsPoints[tms] = tmp
 	}
 	sc.Unlock()
@@ -291,11 +495,9 @@ func (gc *GlobalCounter) UpdateByStrategy(globalStras map[int64]*scheme.Strategy
 	// 先以count的ID为准，更新count
 	// 若ID没有了, 那就删掉
 	for _, id := range gc.GetIDs() {
-		gc.RLock()
-		sCount, ok := gc.StrategyCounts[id]
-		gc.RUnlock()
+		sCount, err := gc.GetStrategyCountByID(id)
 
-		if !ok || sCount.Strategy == nil {
+		if err != nil || sCount.Strategy == nil {
 			//证明此策略无效，或已被删除
 			//删一下
 			delCount = delCount + 1
@@ -322,62 +524,69 @@ func (gc *GlobalCounter) UpdateByStrategy(globalStras map[int64]*scheme.Strategy
 
 // AddStrategyCount to add strategy to counter
 func (gc *GlobalCounter) AddStrategyCount(st *scheme.Strategy) {
-	gc.Lock()
-	if _, ok := gc.StrategyCounts[st.ID]; !ok {
+	cs := gc.shard(st.ID)
+	cs.lock()
+	if _, ok := cs.StrategyCounts[st.ID]; !ok {
 		tmp := new(StrategyCounter)
 		tmp.Strategy = st
 		tmp.TmsPoints = make(map[int64]*PointsCounter, 0)
-		gc.StrategyCounts[st.ID] = tmp
+		cs.StrategyCounts[st.ID] = tmp
 	}
-	gc.Unlock()
+	cs.Unlock()
 }
 
 // GetStrategyCountByID get count by strategy id
 func (gc *GlobalCounter) GetStrategyCountByID(id int64) (*StrategyCounter, error) {
-	gc.RLock()
-	stCount, ok := gc.StrategyCounts[id]
+	cs := gc.shard(id)
+	cs.rlock()
+	stCount, ok := cs.StrategyCounts[id]
 	if !ok {
-		gc.RUnlock()
+		cs.RUnlock()
 		return nil, fmt.Errorf("No this ID")
 	}
-	gc.RUnlock()
+	cs.RUnlock()
 	return stCount, nil
 }
 
 // GetIDs get ids from counter
+// 逐个分片读取, 不会同时持有多个分片的锁
 func (gc *GlobalCounter) GetIDs() []int64 {
-	gc.RLock()
 	rList := make([]int64, 0)
-	for k := range gc.StrategyCounts {
-		rList = append(rList, k)
+	for _, cs := range gc.shards {
+		cs.rlock()
+		for k := range cs.StrategyCounts {
+			rList = append(rList, k)
+		}
+		cs.RUnlock()
 	}
-	gc.RUnlock()
 	return rList
 }
 
 func (gc *GlobalCounter) deleteByID(id int64) {
-	gc.Lock()
-	delete(gc.StrategyCounts, id)
-	gc.Unlock()
+	cs := gc.shard(id)
+	cs.lock()
+	delete(cs.StrategyCounts, id)
+	cs.Unlock()
 }
 
 func (gc *GlobalCounter) cleanStrategyData(id int64) {
-	gc.RLock()
-	sCount, ok := gc.StrategyCounts[id]
-	gc.RUnlock()
-	if !ok || sCount == nil {
+	sCount, err := gc.GetStrategyCountByID(id)
+	if err != nil || sCount == nil {
 		return
 	}
+	sCount.Lock()
 	sCount.TmsPoints = make(map[int64]*PointsCounter, 0)
+	sCount.Unlock()
 	return
 }
 
 func (gc *GlobalCounter) upStrategy(st *scheme.Strategy) {
-	gc.Lock()
-	if _, ok := gc.StrategyCounts[st.ID]; ok {
-		gc.StrategyCounts[st.ID].Strategy = st
+	cs := gc.shard(st.ID)
+	cs.lock()
+	if _, ok := cs.StrategyCounts[st.ID]; ok {
+		cs.StrategyCounts[st.ID].Strategy = st
 	}
-	gc.Unlock()
+	cs.Unlock()
 }
 
 // countEqual意味着不会对统计的结构产生影响
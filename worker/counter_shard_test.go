@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+const (
+	benchWorkers    = 8
+	benchStrategies = 20
+)
+
+func newTestCounter(shards int) *GlobalCounter {
+	gc := NewGlobalCounter(shards)
+	for id := int64(1); id <= benchStrategies; id++ {
+		gc.AddStrategyCount(&scheme.Strategy{ID: id, Interval: 10, Func: "cnt"})
+	}
+	return gc
+}
+
+// testPoints to generate points of workers, values are integers so that sum is exact in any order
+// -1(补零)的语义依赖到达顺序, 不参与比较
+func testPoints(seed int64, n int) [][]*AnalysPoint {
+	r := rand.New(rand.NewSource(seed))
+	ret := make([][]*AnalysPoint, benchWorkers)
+	for w := range ret {
+		for i := 0; i < n; i++ {
+			ret[w] = append(ret[w], &AnalysPoint{
+				StrategyID: int64(r.Intn(benchStrategies) + 1),
+				Value:      float64(r.Intn(100)),
+				Tms:        1500000000 + int64(r.Intn(30)),
+				Tags:       map[string]string{"host": fmt.Sprintf("h%d", r.Intn(4))},
+			})
+		}
+	}
+	return ret
+}
+
+func pushConcurrently(gc *GlobalCounter, points [][]*AnalysPoint) {
+	var wg sync.WaitGroup
+	for _, ps := range points {
+		wg.Add(1)
+		go func(ps []*AnalysPoint) {
+			defer wg.Done()
+			for _, p := range ps {
+				gc.Push(p)
+			}
+		}(ps)
+	}
+	wg.Wait()
+}
+
+// dumpCounter to flatten counter as "sid/tms/tags" => "count sum max min"
+func dumpCounter(gc *GlobalCounter) map[string]string {
+	ret := make(map[string]string)
+	for _, id := range gc.GetIDs() {
+		sc, _ := gc.GetStrategyCountByID(id)
+		for _, tms := range sc.GetTmsList() {
+			pc, _ := sc.GetByTms(tms)
+			for tags, p := range pc.TagstringMap {
+				ret[fmt.Sprintf("%d/%d/%s", id, tms, tags)] = fmt.Sprintf("%d %v %v %v", p.Count, p.Sum, p.Max, p.Min)
+			}
+		}
+	}
+	return ret
+}
+
+func TestCounterShardDifferential(t *testing.T) {
+	points := testPoints(1, 2000)
+
+	// 顺序聚合的结果作为基准
+	want := make(map[string]*PointCounter)
+	for _, ps := range points {
+		for _, p := range ps {
+			k := fmt.Sprintf("%d/%d/%s", p.StrategyID, AlignStepTms(10, p.Tms), utils.SortedTags(p.Tags))
+			c, ok := want[k]
+			if !ok {
+				c = &PointCounter{Max: p.Value, Min: p.Value}
+				want[k] = c
+			}
+			c.Count++
+			c.Sum += p.Value
+			if p.Value > c.Max {
+				c.Max = p.Value
+			}
+			if p.Value < c.Min {
+				c.Min = p.Value
+			}
+		}
+	}
+
+	single, sharded := newTestCounter(1), newTestCounter(DefaultCounterShards)
+	pushConcurrently(single, points)
+	pushConcurrently(sharded, points)
+	a, b := dumpCounter(single), dumpCounter(sharded)
+	if len(a) != len(want) || len(b) != len(want) {
+		t.Fatalf("series count mismatch [want:%d][single:%d][sharded:%d]", len(want), len(a), len(b))
+	}
+	for k, c := range want {
+		v := fmt.Sprintf("%d %v %v %v", c.Count, c.Sum, c.Max, c.Min)
+		if a[k] != v || b[k] != v {
+			t.Fatalf("%s mismatch [want:%s][single:%s][sharded:%s]", k, v, a[k], b[k])
+		}
+	}
+}
+
+func TestCounterShardRemoval(t *testing.T) {
+	gc := newTestCounter(4)
+	pushConcurrently(gc, testPoints(2, 100))
+
+	alive := make(map[int64]*scheme.Strategy)
+	for id := int64(1); id <= benchStrategies; id += 2 {
+		sc, _ := gc.GetStrategyCountByID(id)
+		alive[id] = sc.Strategy
+	}
+	// 删除的策略第一次更新时清空数据, 下一次更新时移除
+	gc.UpdateByStrategy(alive)
+	for id := int64(2); id <= benchStrategies; id += 2 {
+		if sc, err := gc.GetStrategyCountByID(id); err == nil && len(sc.GetTmsList()) != 0 {
+			t.Fatalf("data of removed strategy %d should be cleaned", id)
+		}
+	}
+	gc.UpdateByStrategy(alive)
+
+	if ids := gc.GetIDs(); len(ids) != len(alive) {
+		t.Fatalf("expect %d strategies after removal, got %d", len(alive), len(ids))
+	}
+	total := 0
+	for _, stat := range gc.ShardStats() {
+		total += stat.Strategies
+		for id := range alive {
+			if gc.shard(id) == gc.shards[stat.Shard] && stat.Tms == 0 {
+				t.Fatalf("tms of alive strategy %d should be kept in shard %d", id, stat.Shard)
+			}
+		}
+	}
+	if total != len(alive) {
+		t.Fatalf("shard stats mismatch, total %d", total)
+	}
+}
+
+func benchmarkCounterPush(b *testing.B, shards int) {
+	gc := newTestCounter(shards)
+	points := testPoints(3, 1000)
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for w := 0; w < benchWorkers; w++ {
+		wg.Add(1)
+		go func(ps []*AnalysPoint) {
+			defer wg.Done()
+			for i := 0; i < b.N; i++ {
+				gc.Push(ps[i%len(ps)])
+			}
+		}(points[w])
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	var waitNanos int64
+	for _, cs := range gc.shards {
+		waitNanos += cs.lockWaitNanos
+	}
+	b.ReportMetric(float64(waitNanos)/float64(b.N*benchWorkers), "lock-wait-ns/op")
+}
+
+// BenchmarkCounterPush 8个worker × 20个策略, 对比单锁和分片
+func BenchmarkCounterPush(b *testing.B) {
+	b.Run("shards=1", func(b *testing.B) { benchmarkCounterPush(b, 1) })
+	b.Run(fmt.Sprintf("shards=%d", DefaultCounterShards), func(b *testing.B) { benchmarkCounterPush(b, DefaultCounterShards) })
+}
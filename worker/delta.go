@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"math"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// deltaState to hold the last values of a delta strategy by tag set
+type deltaState struct {
+	sync.Mutex
+	generation int64
+	last       map[string]float64 //tag组合 → 上一个值
+}
+
+var (
+	deltaStates     = make(map[int64]*deltaState)
+	deltaStatesLock = new(sync.RWMutex)
+)
+
+// getDeltaState to get the state of the strategy, recreated when the strategy is redefined
+func getDeltaState(st *scheme.Strategy) *deltaState {
+	deltaStatesLock.RLock()
+	s, ok := deltaStates[st.ID]
+	deltaStatesLock.RUnlock()
+	if ok && s.generation == st.Generation {
+		return s
+	}
+
+	deltaStatesLock.Lock()
+	defer deltaStatesLock.Unlock()
+	if s, ok := deltaStates[st.ID]; ok && s.generation == st.Generation {
+		return s
+	}
+	s = &deltaState{generation: st.Generation, last: make(map[string]float64)}
+	deltaStates[st.ID] = s
+	return s
+}
+
+// applyDelta to replace the value of the point with the difference from the last value of its tag set
+// 返回false表示该点不推送: 同一tag组合的第一个值只作为基准
+// 值变小视为计数器重置(如进程重启), 取0并以新值为基准; NaN不参与计算, 原样保留
+// 同一文件的多个worker并发处理时行的顺序可能被打乱, 需要严格按顺序时将worker_num配置为1
+func applyDelta(st *scheme.Strategy, point *AnalysPoint) bool {
+	if st.MetricType != scheme.MetricTypeDelta || point.Unmatched || math.IsNaN(point.Value) {
+		return true
+	}
+	key := utils.SortedTags(point.Tags)
+	s := getDeltaState(st)
+	s.Lock()
+	last, ok := s.last[key]
+	s.last[key] = point.Value
+	s.Unlock()
+
+	if !ok {
+		return false
+	}
+	if point.Value < last {
+		dlog.Infof("[delta reset][sid:%d][tags:%s][last:%v][current:%v]", st.ID, key, last, point.Value)
+		point.Value = 0
+		return true
+	}
+	point.Value -= last
+	return true
+}
+
+// cleanDeltaStates to remove the states of strategies deleted or no longer delta
+// 定义变化的策略在getDeltaState中按generation重建
+func cleanDeltaStates(strategyMap map[int64]*scheme.Strategy) {
+	deltaStatesLock.Lock()
+	defer deltaStatesLock.Unlock()
+	for id := range deltaStates {
+		if st, ok := strategyMap[id]; !ok || st.MetricType != scheme.MetricTypeDelta {
+			delete(deltaStates, id)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestProducerDelta(t *testing.T) {
+	defer cleanDeltaStates(nil)
+	w := &Worker{Mark: "[worker][delta]", Callback: func(int64, int64) {}}
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.ID = 310
+	st.Func = "sum"
+	st.MetricType = scheme.MetricTypeDelta
+	st.PatternReg = regexp.MustCompile(`total=(\d+)`)
+	st.Tags = map[string]string{"api": `api=(\w+)`}
+	st.TagRegs = map[string]*regexp.Regexp{"api": regexp.MustCompile(`api=(\w+)`)}
+
+	produce := func(line string) *AnalysPoint {
+		t.Helper()
+		p, err := w.producer("2018-01-01 12:00:01 "+line, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// 每个tag组合的第一个值只作为基准
+	if p := produce("api=pay total=100"); p != nil {
+		t.Fatalf("first value should be the baseline, got %+v", p)
+	}
+	if p := produce("api=order total=7"); p != nil {
+		t.Fatalf("first value of another tag set should be the baseline, got %+v", p)
+	}
+	for _, c := range []struct {
+		line string
+		want float64
+	}{
+		{"api=pay total=130", 30},
+		{"api=order total=9", 2},
+		{"api=pay total=130", 0},
+		{"api=pay total=20", 0}, //重置, 取0并以20为基准
+		{"api=pay total=25", 5},
+	} {
+		p := produce(c.line)
+		if p == nil || p.Value != c.want {
+			t.Errorf("%s: expect %v, got %+v", c.line, c.want, p)
+		}
+	}
+
+	// 定义变化后重新取基准
+	st.Generation++
+	if p := produce("api=pay total=40"); p != nil {
+		t.Errorf("redefined strategy should start a new baseline, got %+v", p)
+	}
+	cleanDeltaStates(map[int64]*scheme.Strategy{})
+	if len(deltaStates) != 0 {
+		t.Error("states of deleted strategies should be removed")
+	}
+}
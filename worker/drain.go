@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainIdle 排空时取不到行的等待时长, 之后重新检查队列
+// 剩下的行可能已被其他worker取走, lifo时也可能还未交到recv
+const drainIdle = 10 * time.Millisecond
+
+// streamDrain is shared by the workers of a group to drain the lines buffered when StopGraceful is called
+// 只处理调用时已在队列中的行数, reader之后写入的行不再处理
+type streamDrain struct {
+	start   chan struct{}  //开始排空时关闭
+	left    int64          //还可以取的行数, worker取行前减1
+	pending func() int     //group中尚未被取走的行数
+	done    sync.WaitGroup //进入排空的worker处理完后Done
+}
+
+// streamDrain to get the drain control shared by the workers of the group
+func (wg *WorkerGroup) streamDrain() *streamDrain {
+	wg.drainOnce.Do(func() {
+		wg.drain = &streamDrain{start: make(chan struct{}), pending: wg.backlog}
+	})
+	return wg.drain
+}
+
+// StopGraceful to stop the group after the workers finish the lines already buffered
+// worker不再接收调用之后写入的行, 调用时队列中的行(包括lifo队列)处理完后退出; 暂停中的group先放行worker再排空.
+// timeout内没有排空的强制关闭所有worker, 返回的error给出丢弃的行数; timeout<=0或group未启动时不排空, 立即关闭.
+// 重复调用及停止中的直接返回nil
+func (wg *WorkerGroup) StopGraceful(timeout time.Duration) error {
+	wg.life.Lock()
+	state := wg.stateLocked()
+	if state == GroupStopping || state == GroupStopped {
+		wg.life.Unlock()
+		return nil
+	}
+	wg.setStateLocked(GroupStopping)
+	wg.life.Unlock()
+
+	graceful := timeout > 0 && state == GroupStarted
+	wg.stopPark(graceful)
+	buffered := wg.backlog()
+	if graceful && buffered > 0 {
+		wg.waitDrain(buffered, timeout)
+	}
+	for _, worker := range wg.Workers {
+		worker.Stop()
+	}
+	// worker关闭后剩下的行, 不超过调用时队列中的行数
+	dropped := wg.backlog()
+	if dropped > buffered {
+		dropped = buffered
+	}
+	if wg.lifo != nil {
+		wg.stopLIFO()
+	}
+
+	wg.life.Lock()
+	wg.setStateLocked(GroupStopped)
+	wg.life.Unlock()
+
+	if dropped > 0 {
+		return fmt.Errorf("stop worker group [file:%s][shard:%d]: %d of %d buffered lines dropped",
+			wg.filePath, wg.Shard, dropped, buffered)
+	}
+	return nil
+}
+
+// waitDrain to let the workers take n more lines and wait for them at most timeout
+func (wg *WorkerGroup) waitDrain(n int, timeout time.Duration) {
+	d := wg.streamDrain()
+	atomic.StoreInt64(&d.left, int64(n))
+	for _, w := range wg.Workers {
+		if w.Drain == d {
+			d.done.Add(1)
+		}
+	}
+	close(d.start)
+
+	done := make(chan struct{})
+	go func() {
+		d.done.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// drainStart to get the channel closed when the group starts draining, nil if draining is not supported
+func (w *Worker) drainStart() chan struct{} {
+	if w.Drain == nil {
+		return nil
+	}
+	return w.Drain.start
+}
+
+// drainStream to analysis the buffered lines until the group has none left or the drain quota is used up
+func (w *Worker) drainStream(anaCnt *int64, yield *yielder) {
+	d := w.Drain
+	defer d.done.Done()
+	for d.pending() > 0 && atomic.AddInt64(&d.left, -1) >= 0 {
+		select {
+		case line := <-w.Stream:
+			line.Ack()
+			w.Analyzing = true
+			atomic.AddInt64(anaCnt, 1)
+			w.analysis(line)
+			w.Analyzing = false
+			yield.tick()
+		case <-time.After(drainIdle):
+			// 没有取到, 归还名额后重新检查
+			atomic.AddInt64(&d.left, 1)
+		case <-w.Close:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// fillParked to pause the group and fill its stream with n lines
+func fillParked(t *testing.T, wg *WorkerGroup, stream chan reader.Line, n int) {
+	if err := wg.Pause("alice", "fill"); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	base := time.Now().Add(-2 * time.Duration(n) * time.Second)
+	for i := 0; i < n; i++ {
+		stream <- parkLine(base, i)
+	}
+}
+
+func TestStopGracefulDrain(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	const total = 200
+	var processed int64
+	stream := make(chan reader.Line, total)
+	wg := newParkGroup(2, stream, func(tms, delay int64) {
+		atomic.AddInt64(&processed, 1)
+	})
+	wg.Start()
+	fillParked(t, wg, stream, total)
+
+	if err := wg.StopGraceful(5 * time.Second); err != nil {
+		t.Fatalf("drain should complete, got %v", err)
+	}
+	if n := atomic.LoadInt64(&processed); n != total {
+		t.Fatalf("lines buffered before StopGraceful should be processed, %d of %d", n, total)
+	}
+	if stat := wg.LifecycleStat(); stat.State != GroupStopped {
+		t.Fatalf("group should be stopped, got %s", stat.State)
+	}
+
+	// 停止后写入的行不再处理
+	stream <- parkLine(time.Now(), 0)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&processed); n != total {
+		t.Fatalf("lines after stop should not be processed, got %d", n)
+	}
+	if err := wg.StopGraceful(time.Second); err != nil {
+		t.Fatalf("repeated stop should return nil, got %v", err)
+	}
+}
+
+func TestStopGracefulTimeout(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	const total = 200
+	var processed int64
+	stream := make(chan reader.Line, total)
+	wg := newParkGroup(2, stream, func(tms, delay int64) {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&processed, 1)
+	})
+	wg.Start()
+	fillParked(t, wg, stream, total)
+
+	err := wg.StopGraceful(50 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "buffered lines dropped") {
+		t.Fatalf("expect dropped lines error, got %v", err)
+	}
+	// 强制关闭后worker处理完当前行即退出
+	time.Sleep(100 * time.Millisecond)
+	n := atomic.LoadInt64(&processed)
+	if n == 0 || n == total {
+		t.Fatalf("drain should be cut by the timeout, processed %d", n)
+	}
+	if dropped := len(stream); int64(dropped)+n != total {
+		t.Fatalf("dropped %d and processed %d should add up to %d", dropped, n, total)
+	}
+}
+
+func TestStopDropsBuffered(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	var processed int64
+	stream := make(chan reader.Line, 10)
+	wg := newParkGroup(2, stream, func(tms, delay int64) {
+		atomic.AddInt64(&processed, 1)
+	})
+	wg.Start()
+	fillParked(t, wg, stream, 10)
+
+	err := wg.StopGraceful(0)
+	if err == nil || !strings.Contains(err.Error(), "10 of 10 buffered lines dropped") {
+		t.Fatalf("expect all lines dropped, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&processed); n != 0 {
+		t.Fatalf("StopGraceful(0) should not drain, processed %d", n)
+	}
+}
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"math"
+	"sort"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// EndpointFallbackTagk endpoint取自tag却取不到时, 点推送到默认endpoint并带上该tag
+const EndpointFallbackTagk = "endpoint_fallback"
+
+// DefaultMaxEndpoints endpoint取自tag时, 单策略单周期内默认最多的endpoint数
+// 比tag组合数的上限小得多, 下游按endpoint建索引、算配额, 对数量更敏感
+const DefaultMaxEndpoints = 100
+
+// maxEndpointLen endpoint的最大字节数
+const maxEndpointLen = 255
+
+// endpointTagk 发给sink的点的tag中, endpoint与agent的不同时带上该tag
+const endpointTagk = "endpoint"
+
+// stepSeriesItem is a series of a step to push and its endpoint
+type stepSeriesItem struct {
+	endpoint string
+	tags     map[string]string
+	counter  *PointCounter
+}
+
+// maxEndpoints to get the endpoint cap of a strategy
+// 0取默认值, 负数不限制
+func maxEndpoints(st *scheme.Strategy) int {
+	if st.MaxEndpoints == 0 {
+		return DefaultMaxEndpoints
+	}
+	if st.MaxEndpoints < 0 {
+		return 0
+	}
+	return st.MaxEndpoints
+}
+
+// tagsOf to parse the tagstring of counter, null means no tag
+func tagsOf(tagstring string) map[string]string {
+	if tagstring == "null" {
+		return make(map[string]string, 0)
+	}
+	return utils.DictedTagstring(tagstring)
+}
+
+// stepSeries to get the series of a step with their endpoints
+func stepSeries(st *scheme.Strategy, pointMap map[string]*PointCounter, hostname string) []stepSeriesItem {
+	tagk := st.EndpointTag()
+	ret := make([]stepSeriesItem, 0, len(pointMap))
+	if tagk == "" {
+		for tagstring, pc := range pointMap {
+			ret = append(ret, stepSeriesItem{endpoint: hostname, tags: tagsOf(tagstring), counter: pc})
+		}
+		return ret
+	}
+	return routeEndpoints(st, tagk, pointMap, hostname)
+}
+
+// routeEndpoints to take the endpoint of each series from the tag, the tag is removed from the series
+// tag缺失(如合并到overflow=true的序列)、取值为__high_cardinality__、取值非法或超过max_endpoints的, 推送到默认endpoint并带上endpoint_fallback=true;
+// 去掉endpoint的tag后相同的序列合并, cnt/sum为合计, avg/max/min按所有观测值计算
+func routeEndpoints(st *scheme.Strategy, tagk string, pointMap map[string]*PointCounter, hostname string) []stepSeriesItem {
+	series := make([]stepSeriesItem, 0, len(pointMap))
+	observed := make(map[string]int64)
+	for tagstring, pc := range pointMap {
+		tags := tagsOf(tagstring)
+		// 跨策略取值数超限的tag不作为endpoint
+		endpoint := ""
+		if v := tags[tagk]; v != HighCardinalityValue {
+			endpoint = sanitizeEndpoint(v)
+		}
+		delete(tags, tagk)
+		if endpoint != "" {
+			observed[endpoint] += pc.Count
+		}
+		series = append(series, stepSeriesItem{endpoint: endpoint, tags: tags, counter: pc})
+	}
+
+	// 超过上限时保留观测数最多的endpoint, 相同时按名字
+	allowed := observed
+	if max := maxEndpoints(st); max > 0 && len(observed) > max {
+		endpoints := make([]string, 0, len(observed))
+		for endpoint := range observed {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Slice(endpoints, func(i, j int) bool {
+			a, b := endpoints[i], endpoints[j]
+			if observed[a] != observed[b] {
+				return observed[a] > observed[b]
+			}
+			return a < b
+		})
+		allowed = make(map[string]int64, max)
+		for _, endpoint := range endpoints[:max] {
+			allowed[endpoint] = observed[endpoint]
+		}
+	}
+
+	index := make(map[string]int, len(series))
+	copied := make([]bool, 0, len(series))
+	ret := make([]stepSeriesItem, 0, len(series))
+	for _, s := range series {
+		if _, ok := allowed[s.endpoint]; !ok || s.endpoint == "" {
+			s.endpoint = hostname
+			s.tags[EndpointFallbackTagk] = "true"
+		}
+		key := s.endpoint + "\x00" + utils.SortedTags(s.tags)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(ret)
+			ret = append(ret, s)
+			copied = append(copied, false)
+			continue
+		}
+		// 第一次合并时复制, 不修改counter中的统计
+		if !copied[i] {
+			c := snapshotCounter(ret[i].counter)
+			ret[i].counter, copied[i] = &c, true
+		}
+		mergeSeries(ret[i].counter, s.counter)
+	}
+	return ret
+}
+
+// snapshotCounter to copy the values under the lock
+func snapshotCounter(pc *PointCounter) PointCounter {
+	pc.RLock()
+	defer pc.RUnlock()
+	return pc.copy()
+}
+
+// mergeSeries to add another series of the step into dst, a copy not shared with counter
+// 补零的序列(没有观测值)不影响有观测值的序列
+func mergeSeries(dst, other *PointCounter) {
+	o := snapshotCounter(other)
+	if o.Count == 0 {
+		return
+	}
+	if dst.Count == 0 {
+		// 逐个字段复制, 不复制锁
+		dst.Count, dst.Sum, dst.Max, dst.Min, dst.Gens = o.Count, o.Sum, o.Max, o.Min, o.Gens
+		return
+	}
+	dst.Count += o.Count
+	dst.Sum += o.Sum
+	if math.IsNaN(dst.Max) || o.Max > dst.Max {
+		dst.Max = o.Max
+	}
+	if math.IsNaN(dst.Min) || o.Min < dst.Min {
+		dst.Min = o.Min
+	}
+	dst.Gens.merge(o.Gens)
+}
+
+// sanitizeEndpoint to replace characters not allowed in endpoint with _, empty means no endpoint
+func sanitizeEndpoint(v string) string {
+	if len(v) > maxEndpointLen {
+		v = v[:maxEndpointLen]
+	}
+	b := []byte(v)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// withEndpointTag to copy the tags with the endpoint of the point, for sinks
+func withEndpointTag(tags map[string]string, endpoint string) map[string]string {
+	ret := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		ret[k] = v
+	}
+	ret[endpointTagk] = endpoint
+	return ret
+}
@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// collectEndpoints to build the points of the step keyed by endpoint and tags
+func collectEndpoints(t *testing.T, st *scheme.Strategy, pc *PointsCounter) map[string]*FalconPoint {
+	ret := make(map[string]*FalconPoint)
+	err := buildFalconPoints(st, 1500000000, pc.TagstringMap, "host", func(p *FalconPoint, tags map[string]string) {
+		key := p.Endpoint + "/" + p.Tags
+		if _, ok := ret[key]; ok {
+			t.Fatalf("duplicate series %s", key)
+		}
+		ret[key] = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func pushTenant(t *testing.T, gc *GlobalCounter, sid int64, tenant string, n int) {
+	for i := 0; i < n; i++ {
+		tags := map[string]string{"code": "200"}
+		if tenant != "" {
+			tags["tenant"] = tenant
+		}
+		if err := gc.Push(&AnalysPoint{StrategyID: sid, Value: 1, Tms: 1500000000, Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEndpointSourceTag(t *testing.T) {
+	gc := NewGlobalCounter(1)
+	st := &scheme.Strategy{ID: 1, Name: "req", Interval: 10, Func: "cnt", EndpointSource: "tag:tenant"}
+	gc.AddStrategyCount(st)
+	pushTenant(t, gc, 1, "acme", 3)
+	pushTenant(t, gc, 1, "acme.io/1", 2)
+	pushTenant(t, gc, 1, "", 1)
+
+	sc, _ := gc.GetStrategyCountByID(1)
+	pc, _ := sc.GetByTms(1500000000)
+	points := collectEndpoints(t, st, pc)
+	want := map[string]float64{
+		"acme/code=200":                        3,
+		"acme.io_1/code=200":                   2,
+		"host/code=200,endpoint_fallback=true": 1,
+	}
+	if len(points) != len(want) {
+		t.Fatalf("points = %v", points)
+	}
+	for key, v := range want {
+		if p, ok := points[key]; !ok || p.Value != v {
+			t.Fatalf("point %s = %+v, want %v in %v", key, p, v, points)
+		}
+	}
+
+	// 未配置时endpoint为默认值, tag不变
+	st.EndpointSource = ""
+	points = collectEndpoints(t, st, pc)
+	if _, ok := points["host/code=200,tenant=acme"]; !ok || len(points) != 3 {
+		t.Fatalf("points without source = %v", points)
+	}
+}
+
+func TestSanitizeEndpoint(t *testing.T) {
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = 'a'
+	}
+	cases := map[string]string{
+		"acme":        "acme",
+		"acme corp/1": "acme_corp_1",
+		"a.b-c_d":     "a.b-c_d",
+		"租户":          "______",
+		string(long):  string(long[:maxEndpointLen]),
+		"":            "",
+		"x,y=z\n":     "x_y_z_",
+	}
+	for in, want := range cases {
+		if got := sanitizeEndpoint(in); got != want {
+			t.Fatalf("sanitize %q = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMaxEndpoints(t *testing.T) {
+	gc := NewGlobalCounter(1)
+	st := &scheme.Strategy{ID: 1, Name: "req", Interval: 10, Func: "sum", EndpointSource: "tag:tenant", MaxEndpoints: 2}
+	gc.AddStrategyCount(st)
+	pushTenant(t, gc, 1, "a", 5)
+	pushTenant(t, gc, 1, "b", 4)
+	pushTenant(t, gc, 1, "c", 2)
+	pushTenant(t, gc, 1, "d", 1)
+	pushTenant(t, gc, 1, "", 3)
+
+	sc, _ := gc.GetStrategyCountByID(1)
+	pc, _ := sc.GetByTms(1500000000)
+	points := collectEndpoints(t, st, pc)
+	// 观测数最少的c、d与没有tag的合并到默认endpoint
+	want := map[string]float64{
+		"a/code=200":                           5,
+		"b/code=200":                           4,
+		"host/code=200,endpoint_fallback=true": 6,
+	}
+	if len(points) != len(want) {
+		t.Fatalf("points = %v", points)
+	}
+	for key, v := range want {
+		if p, ok := points[key]; !ok || p.Value != v {
+			t.Fatalf("point %s = %+v, want %v in %v", key, p, v, points)
+		}
+	}
+	// 合并不修改counter中的统计
+	if p := pc.TagstringMap["code=200,tenant=c"]; p.Count != 2 || p.Sum != 2 {
+		t.Fatalf("counter changed = %+v", *p)
+	}
+}
+
+func TestMaxEndpointsWithTagSetOverflow(t *testing.T) {
+	gc := NewGlobalCounter(1)
+	st := &scheme.Strategy{ID: 1, Name: "req", Interval: 10, Func: "max", EndpointSource: "tag:tenant",
+		MaxEndpoints: 3, MaxTagSets: 4}
+	gc.AddStrategyCount(st)
+	for i := 0; i < 10; i++ {
+		p := &AnalysPoint{StrategyID: 1, Value: float64(i), Tms: 1500000000,
+			Tags: map[string]string{"tenant": fmt.Sprint("t", i)}}
+		if err := gc.Push(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc, _ := gc.GetStrategyCountByID(1)
+	pc, _ := sc.GetByTms(1500000000)
+	points := collectEndpoints(t, st, pc)
+	// 4个tag组合中观测数相同按名字保留3个endpoint, 第4个与overflow=true的序列合并到默认endpoint
+	if len(points) != 5 {
+		t.Fatalf("points = %v", points)
+	}
+	for _, key := range []string{"t0/", "t1/", "t2/"} {
+		if _, ok := points[key]; !ok {
+			t.Fatalf("no point %s in %v", key, points)
+		}
+	}
+	fallback, ok := points["host/endpoint_fallback=true,overflow=true"]
+	if !ok {
+		t.Fatalf("no fallback point in %v", points)
+	}
+	if fallback.Value != 9 {
+		t.Fatalf("fallback max = %v, want 9", fallback.Value)
+	}
+	if _, ok := points["host/endpoint_fallback=true"]; !ok {
+		t.Fatalf("no fallback point of t3 in %v", points)
+	}
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// episodeMaxSeries 每个策略最多跟踪的tag组合数
+const episodeMaxSeries = 10000
+
+// episode is the current burst of one tag set, timestamps are parsed from log lines
+type episode struct {
+	start int64
+	last  int64
+}
+
+// episodeTracker to find starts of bursts of one strategy
+// 同一文件可能有多个worker并发处理, 需要加锁
+type episodeTracker struct {
+	sync.Mutex
+	series map[string]*episode
+	newest int64
+}
+
+// observe to check whether the line at tms starts a new episode
+// 与上一行间隔超过gap开始新的episode; 乱序到达的行落在当前episode前后gap内时归入当前episode,
+// 早于当前episode开始gap以上的行认为是已统计过的旧episode, 不再计数
+func (e *episodeTracker) observe(tagstring string, tms, gap int64) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	if tms > e.newest {
+		e.newest = tms
+	}
+	ep, ok := e.series[tagstring]
+	if !ok {
+		if len(e.series) >= episodeMaxSeries && !e.evict(gap) {
+			return false
+		}
+		e.series[tagstring] = &episode{start: tms, last: tms}
+		return true
+	}
+	switch {
+	case tms > ep.last+gap:
+		ep.start, ep.last = tms, tms
+		return true
+	case tms < ep.start-gap:
+		return false
+	}
+	if tms < ep.start {
+		ep.start = tms
+	}
+	if tms > ep.last {
+		ep.last = tms
+	}
+	return false
+}
+
+// evict to drop tag sets silent for more than gap, their next line starts a new episode anyway
+func (e *episodeTracker) evict(gap int64) bool {
+	for tagstring, ep := range e.series {
+		if ep.last+gap < e.newest {
+			delete(e.series, tagstring)
+		}
+	}
+	return len(e.series) < episodeMaxSeries
+}
+
+var (
+	episodeTrackers     = make(map[int64]*episodeTracker)
+	episodeTrackersLock = new(sync.RWMutex)
+)
+
+func getEpisodeTracker(id int64) *episodeTracker {
+	episodeTrackersLock.RLock()
+	e, ok := episodeTrackers[id]
+	episodeTrackersLock.RUnlock()
+	if ok {
+		return e
+	}
+
+	episodeTrackersLock.Lock()
+	defer episodeTrackersLock.Unlock()
+	if e, ok = episodeTrackers[id]; !ok {
+		e = &episodeTracker{series: make(map[string]*episode)}
+		episodeTrackers[id] = e
+	}
+	return e
+}
+
+// cleanEpisodeTrackers to drop states of strategies deleted or no longer counting episodes
+func cleanEpisodeTrackers(strategyMap map[int64]*scheme.Strategy) {
+	episodeTrackersLock.Lock()
+	defer episodeTrackersLock.Unlock()
+	for id := range episodeTrackers {
+		if st, ok := strategyMap[id]; !ok || st.Func != scheme.FuncEpisodes {
+			delete(episodeTrackers, id)
+		}
+	}
+}
+
+// episodeStart to check whether the point starts a new episode, the point counts 1 if so
+// 只有开始新episode的点进入counter, 所以跨周期的episode只在开始的周期计数一次;
+// 没有匹配而补的点原样进入counter, 不开始也不延续episode
+func episodeStart(st *scheme.Strategy, point *AnalysPoint) bool {
+	if point.Unmatched {
+		return true
+	}
+	if !getEpisodeTracker(st.ID).observe(utils.SortedTags(point.Tags), point.LogTms, st.GapSeconds) {
+		return false
+	}
+	point.Value = 1
+	return true
+}
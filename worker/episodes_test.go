@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestEpisodeGapBoundary(t *testing.T) {
+	e := &episodeTracker{series: make(map[string]*episode)}
+	cases := []struct {
+		tms   int64
+		start bool
+	}{
+		{100, true},
+		{101, false},
+		{105, false},
+		{115, false}, //间隔正好等于gap, 仍是同一次
+		{126, true},  //间隔超过gap
+		{127, false},
+	}
+	for _, c := range cases {
+		if got := e.observe("null", c.tms, 10); got != c.start {
+			t.Errorf("tms %d: start %v, want %v", c.tms, got, c.start)
+		}
+	}
+	// 不同tag组合互不影响
+	if !e.observe("code=500", 127, 10) {
+		t.Error("first line of another tag set should start an episode")
+	}
+}
+
+func TestEpisodeOutOfOrder(t *testing.T) {
+	e := &episodeTracker{series: make(map[string]*episode)}
+	starts := 0
+	// 同一次突发中的行乱序到达
+	for _, tms := range []int64{200, 198, 203, 195, 201, 190, 207} {
+		if e.observe("null", tms, 5) {
+			starts++
+		}
+	}
+	if starts != 1 {
+		t.Fatalf("out of order lines in a burst should count once, got %d", starts)
+	}
+	// 早于当前episode开始gap以上的行不重复计数
+	if e.observe("null", 100, 5) {
+		t.Fatal("stale line should not start an episode")
+	}
+	if !e.observe("null", 300, 5) {
+		t.Fatal("line after the gap should start an episode")
+	}
+}
+
+func TestEpisodeAcrossPeriods(t *testing.T) {
+	st := &scheme.Strategy{ID: 301, Func: scheme.FuncEpisodes, GapSeconds: 10, Interval: 60}
+	defer cleanEpisodeTrackers(nil)
+	counts := make(map[int64]int)
+	// 第一次从55秒持续到75秒, 跨过60秒的周期边界; 第二次从100秒开始
+	for _, tms := range []int64{55, 58, 62, 66, 70, 75, 100, 101} {
+		p := &AnalysPoint{StrategyID: st.ID, Value: 42, Tms: tms, LogTms: tms, Tags: map[string]string{}}
+		if episodeStart(st, p) {
+			if p.Value != 1 {
+				t.Fatalf("episode start should count 1, got %v", p.Value)
+			}
+			counts[AlignStepTms(st.Interval, p.Tms)]++
+		}
+	}
+	if counts[0] != 1 || counts[60] != 1 || len(counts) != 2 {
+		t.Fatalf("each episode should count once in its starting period, got %v", counts)
+	}
+}
+
+func TestEpisodeMaxSeries(t *testing.T) {
+	e := &episodeTracker{series: make(map[string]*episode)}
+	for i := 0; i < episodeMaxSeries; i++ {
+		e.observe(fmt.Sprintf("id=%d", i), 100, 10)
+	}
+	if e.observe("id=new", 105, 10) {
+		t.Fatal("new tag set beyond the cap should not be tracked")
+	}
+	// 沉默超过gap的tag组合被淘汰, 腾出位置
+	if !e.observe("id=new", 200, 10) || len(e.series) != 1 {
+		t.Fatalf("silent tag sets should be evicted, %d left", len(e.series))
+	}
+}
+
+func TestCleanEpisodeTrackers(t *testing.T) {
+	getEpisodeTracker(1)
+	getEpisodeTracker(2)
+	cleanEpisodeTrackers(map[int64]*scheme.Strategy{1: {ID: 1, Func: scheme.FuncEpisodes}, 2: {ID: 2, Func: "cnt"}})
+	if _, ok := episodeTrackers[1]; !ok || len(episodeTrackers) != 1 {
+		t.Fatalf("only trackers of episodes strategies should be kept: %v", episodeTrackers)
+	}
+	cleanEpisodeTrackers(nil)
+}
+
+// 没有匹配的行补的点不开始episode, 之后匹配的行不会落进它"开始"的episode而漏计
+func TestEpisodeUnmatchedLines(t *testing.T) {
+	pat, _ := utils.GetPatAndTimeFormat("yyyy-mm-dd HH:MM:SS")
+	st := &scheme.Strategy{
+		ID:         9301,
+		FilePath:   "/tmp/episodes_test.log",
+		Pattern:    "error",
+		TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Func:       scheme.FuncEpisodes,
+		GapSeconds: 10,
+		Interval:   60,
+		Degree:     1,
+		TimeReg:    regexp.MustCompile(pat),
+		PatternReg: regexp.MustCompile("error"),
+		ParseSucc:  true,
+	}
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{st})
+	defer strategy.UpdateGlobalStrategy(nil)
+	GlobalCount.deleteByID(st.ID)
+	defer GlobalCount.deleteByID(st.ID)
+	defer cleanEpisodeTrackers(nil)
+
+	w := &Worker{
+		FilePath: st.FilePath,
+		Mark:     "[worker][episodes test]",
+		Callback: func(int64, int64) {},
+		Accept:   func(int64) bool { return true },
+	}
+	for _, text := range []string{
+		"2018-01-01 12:00:00 info ok",
+		"2018-01-01 12:00:05 error code=1",
+		"2018-01-01 12:00:07 error code=2",
+		"2018-01-01 12:00:30 error code=3",
+	} {
+		w.analysis(reader.Line{Text: text})
+	}
+
+	sc, err := GlobalCount.GetStrategyCountByID(st.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var episodes int64
+	for _, tms := range sc.GetTmsList() {
+		pc, _ := sc.GetByTms(tms)
+		for _, p := range pc.TagstringMap {
+			episodes += p.Count
+		}
+	}
+	if episodes != 2 {
+		t.Fatalf("counted %d episodes, want 2 started by the error lines", episodes)
+	}
+}
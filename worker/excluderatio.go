@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// excludeCount is the lines of one step of a strategy counted or excluded
+type excludeCount struct {
+	kept     int64 //匹配且计入本策略的行
+	excluded int64 //匹配了pattern但被exclude排除的行
+}
+
+// excludeRatioSteps is the counts of the steps of a strategy not pushed yet
+type excludeRatioSteps struct {
+	st    *scheme.Strategy //最近一次计数时的策略, 推送时使用其name和step
+	steps map[int64]*excludeCount
+}
+
+var (
+	excludeRatios     = make(map[int64]*excludeRatioSteps)
+	excludeRatiosLock = new(sync.Mutex)
+)
+
+// excludeRatioEnabled to check whether the strategy pushes exclude_ratio
+func excludeRatioEnabled(st *scheme.Strategy) bool {
+	return st.EmitExcludeRatio && st.ExcludeReg != nil
+}
+
+// recordExcludeRatio to count a line of the strategy in the step of tms
+// 与点一样按处理时的时间归入周期, 全部被排除的周期没有点, 比例仍然推送
+func recordExcludeRatio(st *scheme.Strategy, tms int64, excluded bool) {
+	step := AlignStepTms(st.Interval, tms)
+	excludeRatiosLock.Lock()
+	defer excludeRatiosLock.Unlock()
+	s, ok := excludeRatios[st.ID]
+	if !ok {
+		s = &excludeRatioSteps{steps: make(map[int64]*excludeCount)}
+		excludeRatios[st.ID] = s
+	}
+	s.st = st
+	c, ok := s.steps[step]
+	if !ok {
+		c = new(excludeCount)
+		s.steps[step] = c
+	}
+	if excluded {
+		c.excluded++
+	} else {
+		c.kept++
+	}
+}
+
+// excludeRatioPoints to take the exclude_ratio points of the steps to push, in order of strategy and step
+// 计入与排除的行数都为0的周期不推送
+func excludeRatioPoints(needPush func(st *scheme.Strategy, tms int64) bool, endpoint string) []*FalconPoint {
+	excludeRatiosLock.Lock()
+	defer excludeRatiosLock.Unlock()
+	ids := make([]int64, 0, len(excludeRatios))
+	for id := range excludeRatios {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	ret := make([]*FalconPoint, 0)
+	for _, id := range ids {
+		s := excludeRatios[id]
+		tmsList := make([]int64, 0, len(s.steps))
+		for tms := range s.steps {
+			if needPush(s.st, tms) {
+				tmsList = append(tmsList, tms)
+			}
+		}
+		sort.Slice(tmsList, func(i, j int) bool { return tmsList[i] < tmsList[j] })
+		for _, tms := range tmsList {
+			c := s.steps[tms]
+			delete(s.steps, tms)
+			total := c.kept + c.excluded
+			if total == 0 {
+				continue
+			}
+			ret = append(ret, &FalconPoint{
+				Endpoint:    endpoint,
+				Metric:      "log." + s.st.Name + ".exclude_ratio",
+				Timestamp:   tms,
+				Step:        s.st.Interval,
+				Value:       float64(c.excluded) / float64(total),
+				Tags:        "",
+				CounterType: "GAUGE",
+			})
+		}
+	}
+	return ret
+}
+
+// pushExcludeRatios to push the exclude_ratio of the steps pushed by the strategies
+func pushExcludeRatios(endpoint string) {
+	points := excludeRatioPoints(func(st *scheme.Strategy, tms int64) bool {
+		return tmsNeedPush(tms, st.FilePath, st.Interval)
+	}, endpoint)
+	for _, p := range points {
+		pushQueue <- p
+	}
+}
+
+// cleanExcludeRatios to drop counts of strategies deleted or no longer pushing exclude_ratio
+func cleanExcludeRatios(strategyMap map[int64]*scheme.Strategy) {
+	excludeRatiosLock.Lock()
+	defer excludeRatiosLock.Unlock()
+	for id := range excludeRatios {
+		if st, ok := strategyMap[id]; !ok || !excludeRatioEnabled(st) {
+			delete(excludeRatios, id)
+		}
+	}
+}
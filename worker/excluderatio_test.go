@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestExcludeRatioPeriods(t *testing.T) {
+	defer cleanExcludeRatios(nil)
+	s := funnelStrategy(301, nil, "/health")
+	s.Name = "api.requests"
+	s.Interval = 60
+	s.EmitExcludeRatio = true
+
+	// 三个周期排除的比例依次为1/4、3/4、全部排除
+	const base = 1514779200
+	for i, c := range []struct{ kept, excluded int }{{3, 1}, {1, 3}, {0, 2}} {
+		tms := int64(base + i*60 + 5)
+		for j := 0; j < c.kept; j++ {
+			recordExcludeRatio(s, tms, false)
+		}
+		for j := 0; j < c.excluded; j++ {
+			recordExcludeRatio(s, tms, true)
+		}
+	}
+	// 没有行的周期不推送
+	excludeRatios[s.ID].steps[base+180] = new(excludeCount)
+
+	before := func(end int64) func(*scheme.Strategy, int64) bool {
+		return func(_ *scheme.Strategy, tms int64) bool { return tms < end }
+	}
+	points := excludeRatioPoints(before(base+120), "host")
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want the 2 steps before the latest", len(points))
+	}
+	for i, want := range []float64{0.25, 0.75} {
+		p := points[i]
+		if p.Timestamp != int64(base+i*60) || p.Value != want {
+			t.Errorf("point %d: %+v, want value %v", i, p, want)
+		}
+		if p.Metric != "log.api.requests.exclude_ratio" || p.Endpoint != "host" || p.Step != 60 || p.Tags != "" {
+			t.Errorf("point %d: %+v", i, p)
+		}
+	}
+
+	points = excludeRatioPoints(before(base+240), "host")
+	if len(points) != 1 || points[0].Timestamp != base+120 || points[0].Value != 1 {
+		t.Fatalf("got %+v, want only the fully excluded step", points)
+	}
+	if n := len(excludeRatios[s.ID].steps); n != 0 {
+		t.Fatalf("%d steps left after push", n)
+	}
+}
+
+func TestExcludeRatioPrimaryUnchanged(t *testing.T) {
+	defer cleanFunnelStats(nil)
+	defer cleanExcludeRatios(nil)
+	lines := []string{
+		"2018-01-01 12:00:01 GET /api 200",
+		"2018-01-01 12:00:01 GET /health 200",
+		"2018-01-01 12:00:01 GET /api 502",
+		"2018-01-01 12:00:01 POST /api 200",   //没匹配pattern
+		"2018-01-01 12:00:01 GET /health 503", //exclude
+	}
+	w := &Worker{Mark: "[worker][exclude_ratio]", Callback: func(int64, int64) {}}
+	produce := func(s *scheme.Strategy) []float64 {
+		values := make([]float64, 0)
+		for _, line := range lines {
+			p, err := w.producer(line, s)
+			if err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			if p != nil {
+				values = append(values, p.Value)
+			}
+		}
+		return values
+	}
+
+	plain := funnelStrategy(302, nil, "/health")
+	enabled := funnelStrategy(303, nil, "/health")
+	enabled.EmitExcludeRatio = true
+	if got, want := produce(enabled), produce(plain); !reflect.DeepEqual(got, want) {
+		t.Fatalf("values with exclude_ratio %v, without %v", got, want)
+	}
+	if _, ok := excludeRatios[plain.ID]; ok {
+		t.Fatalf("strategy without emit_exclude_ratio should not be counted")
+	}
+	var kept, excluded int64
+	for _, c := range excludeRatios[enabled.ID].steps {
+		kept, excluded = kept+c.kept, excluded+c.excluded
+	}
+	if kept != 2 || excluded != 2 {
+		t.Fatalf("kept %d excluded %d, want 2 and 2", kept, excluded)
+	}
+}
@@ -0,0 +1,262 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// ExtensionSink 扩展点类型: 每个周期聚合后的点的去向
+const ExtensionSink = "sink"
+
+// ErrExtensionExists is returned when registering a name already in use without replace
+var ErrExtensionExists = errors.New("extension already registered")
+
+// Extension is a named extension invoked in registration order
+// 未启用的只登记不调用, 便于在/v1/extensions中看到哪些扩展没有配置
+type Extension struct {
+	Name     string
+	Kind     string
+	Enabled  bool
+	External bool   //sink发往外部, 配置了noise的策略发送加噪后的值
+	Version  string //配置的指纹, Reload时与已登记的相同则保留原实例
+
+	Send  func(p *AnalysPoint) bool
+	Start func() //加入后调用, 可以为nil
+	Stop  func() //移除或被替换后调用, 可以为nil
+}
+
+// ExtensionInfo is an extension shown in /v1/extensions
+type ExtensionInfo struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Order    int    `json:"order"`
+	Enabled  bool   `json:"enabled"`
+	External bool   `json:"external"`
+	Version  string `json:"version"`
+	Since    int64  `json:"since"` //当前实例加入的时间
+}
+
+// ExtensionRegistry to keep the extensions in registration order
+// 投递持读锁, 变更持写锁: 一次投递要么全部使用旧的扩展, 要么全部使用新的, 不会混合;
+// 变更拿到写锁时进行中的投递都已结束, 移除的扩展在解锁后Stop, 不会再收到点
+type ExtensionRegistry struct {
+	lock  sync.RWMutex
+	exts  []*Extension
+	since map[string]int64
+}
+
+// NewExtensionRegistry to create an empty registry
+func NewExtensionRegistry() *ExtensionRegistry {
+	return &ExtensionRegistry{since: make(map[string]int64)}
+}
+
+// Register to append an extension, a duplicate name is rejected unless replace
+// 替换时保持原来的位置
+func (r *ExtensionRegistry) Register(e *Extension, replace bool) error {
+	if e.Name == "" {
+		return errors.New("empty extension name")
+	}
+	r.lock.Lock()
+	var old *Extension
+	exts := make([]*Extension, 0, len(r.exts)+1)
+	for _, x := range r.exts {
+		if x.Name == e.Name {
+			if !replace {
+				r.lock.Unlock()
+				return fmt.Errorf("%w: %s", ErrExtensionExists, e.Name)
+			}
+			old = x
+			x = e
+		}
+		exts = append(exts, x)
+	}
+	if old == nil {
+		exts = append(exts, e)
+	}
+	r.exts = exts
+	r.since[e.Name] = time.Now().Unix()
+	r.lock.Unlock()
+
+	stopExtension(old)
+	startExtension(e)
+	return nil
+}
+
+// Unregister to remove an extension by name, false if not registered
+func (r *ExtensionRegistry) Unregister(name string) bool {
+	r.lock.Lock()
+	var old *Extension
+	exts := make([]*Extension, 0, len(r.exts))
+	for _, x := range r.exts {
+		if x.Name == name {
+			old = x
+			continue
+		}
+		exts = append(exts, x)
+	}
+	r.exts = exts
+	delete(r.since, name)
+	r.lock.Unlock()
+
+	stopExtension(old)
+	return old != nil
+}
+
+// ExtensionDiff is the result of a reload
+type ExtensionDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Replaced []string `json:"replaced"`
+}
+
+// Reload to replace the registered extensions with the desired ones in one step
+// 顺序以desired为准; 同名且Version、Enabled相同的保留原实例, 不重启
+func (r *ExtensionRegistry) Reload(desired []*Extension) (ExtensionDiff, error) {
+	var diff ExtensionDiff
+	seen := make(map[string]bool, len(desired))
+	for _, e := range desired {
+		if e.Name == "" {
+			return diff, errors.New("empty extension name")
+		}
+		if seen[e.Name] {
+			return diff, fmt.Errorf("%w: %s", ErrExtensionExists, e.Name)
+		}
+		seen[e.Name] = true
+	}
+
+	var started, stopped []*Extension
+	now := time.Now().Unix()
+	r.lock.Lock()
+	current := make(map[string]*Extension, len(r.exts))
+	for _, x := range r.exts {
+		current[x.Name] = x
+	}
+	exts := make([]*Extension, 0, len(desired))
+	for _, e := range desired {
+		old, ok := current[e.Name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, e.Name)
+		case old.Version == e.Version && old.Enabled == e.Enabled:
+			exts = append(exts, old)
+			continue
+		default:
+			diff.Replaced = append(diff.Replaced, e.Name)
+			stopped = append(stopped, old)
+		}
+		exts = append(exts, e)
+		started = append(started, e)
+		r.since[e.Name] = now
+	}
+	for _, x := range r.exts {
+		if !seen[x.Name] {
+			diff.Removed = append(diff.Removed, x.Name)
+			stopped = append(stopped, x)
+			delete(r.since, x.Name)
+		}
+	}
+	r.exts = exts
+	r.lock.Unlock()
+
+	for _, x := range stopped {
+		stopExtension(x)
+	}
+	for _, e := range started {
+		startExtension(e)
+	}
+	return diff, nil
+}
+
+// Deliver to call f with the enabled extensions of the kind, the set does not change during f
+func (r *ExtensionRegistry) Deliver(kind string, f func(exts []*Extension)) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	exts := make([]*Extension, 0, len(r.exts))
+	for _, x := range r.exts {
+		if x.Kind == kind && x.Enabled && x.Send != nil {
+			exts = append(exts, x)
+		}
+	}
+	f(exts)
+}
+
+// List to get the registered extensions in invocation order
+func (r *ExtensionRegistry) List() []ExtensionInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ret := make([]ExtensionInfo, 0, len(r.exts))
+	for i, x := range r.exts {
+		ret = append(ret, ExtensionInfo{
+			Name:     x.Name,
+			Kind:     x.Kind,
+			Order:    i,
+			Enabled:  x.Enabled,
+			External: x.External,
+			Version:  x.Version,
+			Since:    r.since[x.Name],
+		})
+	}
+	return ret
+}
+
+func startExtension(e *Extension) {
+	if e != nil && e.Enabled && e.Start != nil {
+		e.Start()
+	}
+}
+
+func stopExtension(e *Extension) {
+	if e != nil && e.Enabled && e.Stop != nil {
+		e.Stop()
+	}
+}
+
+var (
+	extensions     = NewExtensionRegistry()
+	extensionsOnce sync.Once
+)
+
+// getExtensions to get the registry, the sinks of the config are registered on first use
+func getExtensions() *ExtensionRegistry {
+	extensionsOnce.Do(func() {
+		if _, err := extensions.Reload(sinkExtensions(g.Conf())); err != nil {
+			dlog.Errorf("register sinks failed [err:%v]", err)
+		}
+	})
+	return extensions
+}
+
+// ReloadExtensions to apply the sinks of a reloaded config
+// 配置未变的sink不重启; 替换、移除的sink队列中尚未发出的点丢弃
+func ReloadExtensions(c *g.Config) (ExtensionDiff, error) {
+	r := getExtensions()
+	return r.Reload(sinkExtensions(c))
+}
+
+// sinkExtensions to build the sinks of the config in the documented order: otlp, influxdb, statsd
+func sinkExtensions(c *g.Config) []*Extension {
+	if c == nil {
+		return nil
+	}
+	return []*Extension{otlpSinkExtension(c), influxDBSinkExtension(c), statsDSinkExtension(c)}
+}
+
+// configVersion to get the fingerprint of the config of an extension
+func configVersion(v ...interface{}) string {
+	bs, _ := json.Marshal(v)
+	h := fnv.New64a()
+	h.Write(bs)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// ListExtensions to get the registered extensions in invocation order
+func ListExtensions() []ExtensionInfo {
+	return getExtensions().List()
+}
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testExtension to record the points received and the start/stop of an extension
+type testExtension struct {
+	name    string
+	started int32
+	stopped int32
+	late    int32 //Stop之后收到的点
+
+	lock sync.Mutex
+	got  []int64
+}
+
+func (x *testExtension) ext(version string) *Extension {
+	return &Extension{
+		Name:    x.name,
+		Kind:    ExtensionSink,
+		Enabled: true,
+		Version: version,
+		Send: func(p *AnalysPoint) bool {
+			if atomic.LoadInt32(&x.stopped) > 0 {
+				atomic.AddInt32(&x.late, 1)
+			}
+			x.lock.Lock()
+			x.got = append(x.got, p.StrategyID)
+			x.lock.Unlock()
+			return true
+		},
+		Start: func() { atomic.AddInt32(&x.started, 1) },
+		Stop:  func() { atomic.AddInt32(&x.stopped, 1) },
+	}
+}
+
+func extensionNames(r *ExtensionRegistry) []string {
+	var ret []string
+	for _, info := range r.List() {
+		ret = append(ret, info.Name)
+	}
+	return ret
+}
+
+func TestExtensionRegisterDuplicate(t *testing.T) {
+	r := NewExtensionRegistry()
+	a, b, a2 := &testExtension{name: "a"}, &testExtension{name: "b"}, &testExtension{name: "a"}
+	if err := r.Register(a.ext("1"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(b.ext("1"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(a2.ext("2"), false); !errors.Is(err, ErrExtensionExists) {
+		t.Fatalf("duplicate err = %v", err)
+	}
+	if a.stopped != 0 || a2.started != 0 {
+		t.Fatal("rejected registration touched the extensions")
+	}
+
+	// 替换保持原来的位置
+	if err := r.Register(a2.ext("2"), true); err != nil {
+		t.Fatal(err)
+	}
+	if names := extensionNames(r); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("order = %v", names)
+	}
+	if a.stopped != 1 || a2.started != 1 || r.List()[0].Version != "2" {
+		t.Fatalf("replace: old stopped %d, new started %d", a.stopped, a2.started)
+	}
+	if !r.Unregister("a") || r.Unregister("a") || a2.stopped != 1 {
+		t.Fatal("unregister")
+	}
+}
+
+func TestExtensionReloadDiff(t *testing.T) {
+	r := NewExtensionRegistry()
+	a, b, c := &testExtension{name: "a"}, &testExtension{name: "b"}, &testExtension{name: "c"}
+	if _, err := r.Reload([]*Extension{a.ext("1"), b.ext("1"), c.ext("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	a2, b2, d := &testExtension{name: "a"}, &testExtension{name: "b"}, &testExtension{name: "d"}
+	diff, err := r.Reload([]*Extension{b2.ext("1"), a2.ext("2"), d.ext("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExtensionDiff{Added: []string{"d"}, Removed: []string{"c"}, Replaced: []string{"a"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("diff = %+v, want %+v", diff, want)
+	}
+	if names := extensionNames(r); !reflect.DeepEqual(names, []string{"b", "a", "d"}) {
+		t.Fatalf("order = %v", names)
+	}
+	// 配置未变的b保留原实例
+	if b.stopped != 0 || b2.started != 0 || a.stopped != 1 || a2.started != 1 || c.stopped != 1 || d.started != 1 {
+		t.Fatal("unexpected start/stop")
+	}
+	r.Deliver(ExtensionSink, func(exts []*Extension) {
+		for _, x := range exts {
+			x.Send(&AnalysPoint{StrategyID: 1})
+		}
+	})
+	if len(b.got) != 1 || len(b2.got) != 0 {
+		t.Fatal("kept extension is not the original instance")
+	}
+
+	if _, err := r.Reload([]*Extension{a.ext("1"), a.ext("1")}); !errors.Is(err, ErrExtensionExists) {
+		t.Fatalf("duplicate in reload err = %v", err)
+	}
+	if names := extensionNames(r); !reflect.DeepEqual(names, []string{"b", "a", "d"}) {
+		t.Fatalf("rejected reload changed the registry: %v", names)
+	}
+}
+
+func TestExtensionOrderStable(t *testing.T) {
+	r := NewExtensionRegistry()
+	xs := make([]*testExtension, 5)
+	desired := func() []*Extension {
+		var ret []*Extension
+		for _, x := range xs {
+			ret = append(ret, x.ext("1"))
+		}
+		return ret
+	}
+	for i := range xs {
+		xs[i] = &testExtension{name: fmt.Sprint("ext", 4-i)}
+	}
+	r.Reload(desired())
+	first := r.List()
+	for i := 0; i < 10; i++ {
+		diff, _ := r.Reload(desired())
+		if len(diff.Added)+len(diff.Removed)+len(diff.Replaced) != 0 {
+			t.Fatalf("diff of the same set = %+v", diff)
+		}
+		if got := r.List(); !reflect.DeepEqual(got, first) {
+			t.Fatalf("reload %d: %v, want %v", i, got, first)
+		}
+	}
+	for _, x := range xs {
+		if x.started != 1 || x.stopped != 0 {
+			t.Fatalf("%s restarted", x.name)
+		}
+	}
+}
+
+// 并发投递与Reload交替进行, 每个点要么全部发往旧的一组, 要么全部发往新的一组, 停掉的扩展不再收到点
+func TestExtensionNoMixedSet(t *testing.T) {
+	r := NewExtensionRegistry()
+	var all []*testExtension
+	set := func(prefix string) []*Extension {
+		var ret []*Extension
+		for i := 0; i < 3; i++ {
+			x := &testExtension{name: fmt.Sprint(prefix, i)}
+			all = append(all, x)
+			ret = append(ret, x.ext(prefix))
+		}
+		return ret
+	}
+	r.Reload(set("a"))
+
+	var next int64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := atomic.AddInt64(&next, 1)
+				r.Deliver(ExtensionSink, func(exts []*Extension) {
+					for _, x := range exts {
+						x.Send(&AnalysPoint{StrategyID: id})
+					}
+				})
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		// 每次Reload之间都有投递
+		for atomic.LoadInt64(&next) < int64(i*20) {
+			runtime.Gosched()
+		}
+		if i%2 == 0 {
+			r.Reload(set("b"))
+		} else {
+			r.Reload(set("a"))
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// 每个点的接收者: 同一组的3个
+	receivers := make(map[int64][]string)
+	for _, x := range all {
+		if x.late > 0 {
+			t.Fatalf("%s got %d points after stopped", x.name, x.late)
+		}
+		for _, id := range x.got {
+			receivers[id] = append(receivers[id], x.name)
+		}
+	}
+	for id, names := range receivers {
+		if len(names) != 3 {
+			t.Fatalf("point %d delivered to %v", id, names)
+		}
+		for _, name := range names {
+			if name[0] != names[0][0] {
+				t.Fatalf("point %d delivered to a mixed set %v", id, names)
+			}
+		}
+	}
+	if len(receivers) == 0 {
+		t.Fatal("no point delivered")
+	}
+}
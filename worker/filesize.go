@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+)
+
+// ReportFileSizeWarnings to push the size of files larger than reader.max_file_size_warning_mb as log.agent.file.size_warning_mb, tag file
+// 由自监控的ticker驱动, 与其他自监控数据同一间隔
+func ReportFileSizeWarnings(w ticker.Window) {
+	step := int64(w.Duration() / time.Second)
+	if step <= 0 {
+		step = 1
+	}
+	for file, mb := range metric.FileSizeWarnings() {
+		pushQueue <- &FalconPoint{
+			Endpoint:    pushEndpoint(),
+			Metric:      "log.agent.file.size_warning_mb",
+			Timestamp:   w.End.Unix(),
+			Step:        step,
+			Value:       getPrecision(mb, 1),
+			Tags:        "file=" + file,
+			CounterType: "GAUGE",
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+var updateFixtures = flag.Bool("update", false, "rewrite expected points of testdata/fixtures with current producer output")
+
+const fixtureDir = "testdata/fixtures"
+
+// fixturePoint is the expected point in fixture file
+// value为数字或"NaN", 没有产生点的行写null
+type fixturePoint struct {
+	Value interface{}       `json:"value"`
+	Tags  map[string]string `json:"tags"`
+}
+
+type fixture struct {
+	Strategy json.RawMessage `json:"strategy"` //原样保留, -update时不改写
+	Lines    []string        `json:"lines"`
+	Expected []*fixturePoint `json:"expected"`
+}
+
+func readFixture(t testing.TB, name string) *fixture {
+	bs, err := ioutil.ReadFile(filepath.Join(fixtureDir, name+".json"))
+	if err != nil {
+		t.Fatalf("read fixture %s failed: %v", name, err)
+	}
+	f := new(fixture)
+	if err := json.Unmarshal(bs, f); err != nil {
+		t.Fatalf("decode fixture %s failed: %v", name, err)
+	}
+	return f
+}
+
+// LoadFixture to load strategy, lines and expected points from testdata/fixtures/{name}.json
+// 策略的正则按加载策略时的方式编译, expected中null对应的点为nil
+func LoadFixture(t testing.TB, name string) (*scheme.Strategy, []string, []*AnalysPoint) {
+	f := readFixture(t, name)
+	st := new(scheme.Strategy)
+	if err := json.Unmarshal(f.Strategy, st); err != nil {
+		t.Fatalf("decode strategy of fixture %s failed: %v", name, err)
+	}
+
+	pat, _ := utils.GetPatAndTimeFormat(st.TimeFormat)
+	st.TimeReg = regexp.MustCompile(pat)
+	if st.Pattern != "" {
+		st.PatternReg = regexp.MustCompile(st.Pattern)
+		st.ValueIndex = fixtureValueIndex(t, name, st)
+	}
+	if st.Exclude != "" {
+		st.ExcludeReg = regexp.MustCompile(st.Exclude)
+	}
+	st.TagRegs = make(map[string]*regexp.Regexp, len(st.Tags))
+	for k, v := range st.Tags {
+		st.TagRegs[k] = regexp.MustCompile(v)
+	}
+	st.ParseSucc = true
+
+	if !*updateFixtures && len(f.Expected) != len(f.Lines) {
+		t.Fatalf("fixture %s: %d lines but %d expected points", name, len(f.Lines), len(f.Expected))
+	}
+	expected := make([]*AnalysPoint, len(f.Expected))
+	for i, p := range f.Expected {
+		if p == nil {
+			continue
+		}
+		var value float64
+		switch v := p.Value.(type) {
+		case float64:
+			value = v
+		case string:
+			if v != "NaN" {
+				t.Fatalf("fixture %s: bad value %q", name, v)
+			}
+			value = math.NaN()
+		default:
+			t.Fatalf("fixture %s: bad value %v", name, p.Value)
+		}
+		tags := p.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		expected[i] = &AnalysPoint{StrategyID: st.ID, Value: value, Tags: tags}
+	}
+	return st, f.Lines, expected
+}
+
+// fixtureValueIndex to resolve value_group as strategy loading does
+func fixtureValueIndex(t testing.TB, name string, st *scheme.Strategy) int {
+	if st.ValueGroup == "" {
+		return 1
+	}
+	if index, err := strconv.Atoi(string(st.ValueGroup)); err == nil {
+		return index
+	}
+	for i, n := range st.PatternReg.SubexpNames() {
+		if i > 0 && n == string(st.ValueGroup) {
+			return i
+		}
+	}
+	t.Fatalf("fixture %s: value_group %s not found", name, st.ValueGroup)
+	return 0
+}
+
+// writeFixture to rewrite expected points with actual output, used with -update
+func writeFixture(t *testing.T, name string, got []*AnalysPoint) {
+	f := readFixture(t, name)
+	f.Expected = make([]*fixturePoint, len(got))
+	for i, p := range got {
+		if p == nil {
+			continue
+		}
+		var value interface{} = p.Value
+		if math.IsNaN(p.Value) {
+			value = "NaN"
+		}
+		f.Expected[i] = &fixturePoint{Value: value, Tags: p.Tags}
+	}
+	bs, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fixtureDir, name+".json"), append(bs, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func samePoint(a, b *AnalysPoint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if math.IsNaN(a.Value) != math.IsNaN(b.Value) || (!math.IsNaN(a.Value) && a.Value != b.Value) {
+		return false
+	}
+	return a.StrategyID == b.StrategyID && reflect.DeepEqual(a.Tags, b.Tags)
+}
+
+func TestProducerFixtures(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	sort.Strings(files)
+	if len(files) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		st, lines, expected := LoadFixture(t, name)
+		w := &Worker{
+			FilePath: st.FilePath,
+			Mark:     "[worker][fixture:" + name + "]",
+			Callback: func(int64, int64) {},
+		}
+
+		// Tms取的是处理时的机器时间, 不做比较
+		got := make([]*AnalysPoint, len(lines))
+		for i, line := range lines {
+			p, err := w.producer(line, st)
+			if err == nil && p != nil {
+				p.Tms = 0
+				got[i] = p
+			}
+		}
+
+		if *updateFixtures {
+			writeFixture(t, name, got)
+			continue
+		}
+		for i := range lines {
+			if !samePoint(got[i], expected[i]) {
+				t.Errorf("fixture %s line %d %q: got %+v, want %+v", name, i, lines[i], got[i], expected[i])
+			}
+		}
+	}
+}
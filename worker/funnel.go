@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// FunnelStat is the count of lines of one strategy passing each filter since start
+// 只统计配置了must_not_contain或exclude的策略; 依次为匹配pattern、被must_not_contain排除、被exclude排除
+type FunnelStat struct {
+	Matched        int64 `json:"matched"`
+	MustNotContain int64 `json:"must_not_contain"`
+	Excluded       int64 `json:"excluded"`
+}
+
+var (
+	funnelStats     = make(map[int64]*FunnelStat)
+	funnelStatsLock = new(sync.RWMutex)
+)
+
+// funnelTracked to check whether the strategy has filters after pattern
+func funnelTracked(st *scheme.Strategy) bool {
+	return st.ExcludeReg != nil || len(st.MustNotContainLits) > 0 || len(st.MustNotContainRegs) > 0
+}
+
+func getFunnelStat(id int64) *FunnelStat {
+	funnelStatsLock.RLock()
+	s, ok := funnelStats[id]
+	funnelStatsLock.RUnlock()
+	if ok {
+		return s
+	}
+
+	funnelStatsLock.Lock()
+	defer funnelStatsLock.Unlock()
+	if s, ok = funnelStats[id]; !ok {
+		s = new(FunnelStat)
+		funnelStats[id] = s
+	}
+	return s
+}
+
+// FunnelStats to get filter counts of all strategies
+func FunnelStats() map[int64]FunnelStat {
+	funnelStatsLock.RLock()
+	defer funnelStatsLock.RUnlock()
+	ret := make(map[int64]FunnelStat, len(funnelStats))
+	for id, s := range funnelStats {
+		ret[id] = FunnelStat{
+			Matched:        atomic.LoadInt64(&s.Matched),
+			MustNotContain: atomic.LoadInt64(&s.MustNotContain),
+			Excluded:       atomic.LoadInt64(&s.Excluded),
+		}
+	}
+	return ret
+}
+
+// cleanFunnelStats to drop counts of strategies deleted
+func cleanFunnelStats(strategyMap map[int64]*scheme.Strategy) {
+	funnelStatsLock.Lock()
+	defer funnelStatsLock.Unlock()
+	for id := range funnelStats {
+		if _, ok := strategyMap[id]; !ok {
+			delete(funnelStats, id)
+		}
+	}
+}
+
+// mustNotContain to find the first clause contained in the line, literals first
+func mustNotContain(st *scheme.Strategy, line string) (string, bool) {
+	for _, lit := range st.MustNotContainLits {
+		if strings.Contains(line, lit) {
+			return lit, true
+		}
+	}
+	for _, reg := range st.MustNotContainRegs {
+		if reg.MatchString(line) {
+			return reg.String(), true
+		}
+	}
+	return "", false
+}
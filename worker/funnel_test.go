@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func funnelStrategy(id int64, clauses []string, exclude string) *scheme.Strategy {
+	s := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	s.ID = id
+	s.PatternReg = regexp.MustCompile(`GET \S+ (\d+)`)
+	s.MustNotContain = clauses
+	for _, c := range clauses {
+		if regexp.QuoteMeta(c) == c {
+			s.MustNotContainLits = append(s.MustNotContainLits, c)
+		} else {
+			s.MustNotContainRegs = append(s.MustNotContainRegs, regexp.MustCompile(c))
+		}
+	}
+	if exclude != "" {
+		s.ExcludeReg = regexp.MustCompile(exclude)
+	}
+	return s
+}
+
+func TestMustNotContain(t *testing.T) {
+	defer cleanFunnelStats(nil)
+	// 统计没有traceid的请求, 健康检查不计入
+	s := funnelStrategy(201, []string{"traceid=", `trace_id=[0-9a-f]{16}`}, `/health`)
+	w := &Worker{Mark: "[worker][funnel]", Callback: func(int64, int64) {}}
+
+	cases := []struct {
+		line string
+		want bool
+	}{
+		{"2018-01-01 12:00:01 GET /api 200 cost=12", true},
+		{"2018-01-01 12:00:01 GET /api 200 traceid=abc", false},
+		{"2018-01-01 12:00:01 GET /api 200 trace_id=0123456789abcdef", false},
+		{"2018-01-01 12:00:01 GET /api 200 trace_id=short", true},                       //正则不命中
+		{"2018-01-01 12:00:01 GET /api 200 traceid=a trace_id=0123456789abcdef", false}, //同时命中两项只计一次
+		{"2018-01-01 12:00:01 GET /health 200", false},                                  //exclude
+		{"2018-01-01 12:00:01 GET /health 200 traceid=abc", false},                      //先must_not_contain
+		{"2018-01-01 12:00:01 POST /api traceid=abc", false},                            //没匹配pattern
+	}
+	for _, c := range cases {
+		p, err := w.producer(c.line, s)
+		if err != nil {
+			t.Fatalf("%q: %v", c.line, err)
+		}
+		if (p != nil) != c.want {
+			t.Errorf("%q: point %+v, want %v", c.line, p, c.want)
+		}
+	}
+
+	got := FunnelStats()[s.ID]
+	want := FunnelStat{Matched: 7, MustNotContain: 4, Excluded: 1}
+	if got != want {
+		t.Errorf("funnel %+v, want %+v", got, want)
+	}
+	if got.Matched-got.MustNotContain-got.Excluded != 2 {
+		t.Errorf("funnel should end with the 2 points produced: %+v", got)
+	}
+}
+
+// pattern匹配不到时补零(-1)的行不算匹配, must_not_contain不生效, exclude照常
+func TestMustNotContainZeroFill(t *testing.T) {
+	defer cleanFunnelStats(nil)
+	s := funnelStrategy(202, []string{"traceid="}, "/health")
+	s.PatternReg = regexp.MustCompile(`GET (/api)`)
+	w := &Worker{Mark: "[worker][funnel]", Callback: func(int64, int64) {}}
+
+	if p, _ := w.producer("2018-01-01 12:00:01 POST /x traceid=abc", s); p == nil || p.Value != -1 {
+		t.Errorf("zero-fill line should not be filtered by must_not_contain, got %+v", p)
+	}
+	if p, _ := w.producer("2018-01-01 12:00:01 POST /health", s); p != nil {
+		t.Errorf("exclude should apply to zero-fill line, got %+v", p)
+	}
+	if got, want := FunnelStats()[s.ID], (FunnelStat{Excluded: 1}); got != want {
+		t.Errorf("funnel %+v, want %+v", got, want)
+	}
+
+	// 没有must_not_contain和exclude的策略不统计
+	plain := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	plain.ID = 203
+	plain.PatternReg = regexp.MustCompile(`GET \S+ (\d+)`)
+	w.producer("2018-01-01 12:00:01 GET /api 200", plain)
+	if _, ok := FunnelStats()[plain.ID]; ok {
+		t.Errorf("strategy without filters should not be tracked")
+	}
+}
+
+func BenchmarkMustNotContain(b *testing.B) {
+	line := "2018-01-01 12:00:01 GET /api/v1/orders 200 cost=12 ua=Mozilla/5.0 traceid=4bf92f3577b34da6"
+	for _, bc := range []struct {
+		name    string
+		clauses []string
+	}{
+		{"literal", []string{"traceid="}},
+		{"regex", []string{`traceid=[0-9a-f]+`}},
+	} {
+		s := funnelStrategy(1, bc.clauses, "")
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mustNotContain(s, line)
+			}
+		})
+	}
+}
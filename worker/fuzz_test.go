@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// fuzzLineTimeout 单行处理时间的上限, 足够宽松, 只用于发现病态输入
+const fuzzLineTimeout = 2 * time.Second
+
+// fuzzTimeFormats 支持的全部时间格式
+var fuzzTimeFormats = []string{
+	"dd/mmm/yyyy:HH:MM:SS", "dd/mmm/yyyy HH:MM:SS", "yyyy-mm-ddTHH:MM:SS", "dd-mmm-yyyy HH:MM:SS",
+	"yyyy-mm-dd HH:MM:SS", "yyyy/mm/dd HH:MM:SS", "yyyymmdd HH:MM:SS", "mmm dd HH:MM:SS",
+	"otlp_unix_nano", "rfc3339_nano",
+}
+
+// fuzzSeeds to add lines of golden fixtures and known adversarial lines as seed corpus
+func fuzzSeeds(f *testing.F) {
+	for _, name := range fixtureNames(f) {
+		for _, line := range readFixture(f, name).Lines {
+			f.Add(line)
+		}
+	}
+	for _, line := range adversarialLines {
+		f.Add(line)
+	}
+}
+
+func fixtureNames(tb testing.TB) []string {
+	files, _ := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	sort.Strings(files)
+	if len(files) == 0 {
+		tb.Fatal("no fixtures found")
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names
+}
+
+// adversarialLines 曾经或可能导致panic、卡顿的行, 也作为回归用例
+var adversarialLines = []string{
+	"",
+	"\x00\x00\x00",
+	"2018-01-01 12:00:01 \xff\xfe\xfd cost=12 code=500",
+	"2018-01-01 24:00:00 cost=1",
+	"2018-02-30 12:00:01 cost=1",
+	"99999999999999999999 overflow nano",
+	"Feb 30 08:01:02 host kernel: oom",
+	"Jan  5 08:01:02" + strings.Repeat(" ", 1000),
+	"[01/Jan/2018:12:00:01 +0800] " + strings.Repeat("[{(", 5000) + strings.Repeat(")}]", 5000),
+	"2018-01-01 12:00:01 cost=" + strings.Repeat("9", 400),
+	"2018-01-01 12:00:01 cost=1e999 code=" + strings.Repeat("5", 1000),
+	"level=error ts=2018-01-01T12:00:01 cost=" + strings.Repeat("=", 100),
+	`{"time":"2018-01-01 12:00:01","cost":` + strings.Repeat(`{"a":`, 2000),
+	"<Event><System><TimeCreated SystemTime='2018-01-01T12:00:01Z'/>" + strings.Repeat("<a>", 2000),
+	"2018-01-01 12:00:01 " + strings.Repeat("a", 2*DefaultMaxLineBytes),
+	"2018-01-01 12:00:01 " + strings.Repeat("界", DefaultMaxLineBytes/3+1),
+}
+
+// timed to fail the iteration if fn takes longer than fuzzLineTimeout
+func timed(t *testing.T, line string, fn func()) {
+	start := time.Now()
+	fn()
+	if d := time.Since(start); d > fuzzLineTimeout {
+		t.Fatalf("line of %d bytes took %v", len(line), d)
+	}
+}
+
+func FuzzExtractTimestamp(f *testing.F) {
+	fuzzSeeds(f)
+	now := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	strategies := make([]*scheme.Strategy, len(fuzzTimeFormats))
+	for i, format := range fuzzTimeFormats {
+		strategies[i] = timestampStrategy(format)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		line = boundLine(line)
+		for _, st := range strategies {
+			timed(t, line, func() {
+				ts, format, err := extractTimestamp(line, st, now)
+				if err != nil {
+					return
+				}
+				if ts == "" || format == "" {
+					t.Fatalf("%s: empty timestamp %q or format %q without error", st.TimeFormat, ts, format)
+				}
+				// 补年份、合并空格之外, 时间串都是line的一部分
+				if st.TimeFormat != "mmm dd HH:MM:SS" && !strings.Contains(line, ts) {
+					t.Fatalf("%s: timestamp %q not in line", st.TimeFormat, ts)
+				}
+			})
+		}
+	})
+}
+
+func FuzzExtractValue(f *testing.F) {
+	fuzzSeeds(f)
+	var strategies []*scheme.Strategy
+	for _, name := range fixtureNames(f) {
+		if st, _, _ := LoadFixture(f, name); st.PatternReg != nil {
+			strategies = append(strategies, st)
+		}
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		line = boundLine(line)
+		for _, st := range strategies {
+			timed(t, line, func() {
+				value, matched, ok := extractValue(line, st)
+				if !matched && ok && value != -1 {
+					t.Fatalf("sid %d: unmatched line got value %v", st.ID, value)
+				}
+				if matched && !ok {
+					t.Fatalf("sid %d: matched line dropped", st.ID)
+				}
+			})
+		}
+	})
+}
+
+func FuzzExtractTags(f *testing.F) {
+	fuzzSeeds(f)
+	var strategies []*scheme.Strategy
+	for _, name := range fixtureNames(f) {
+		if st, _, _ := LoadFixture(f, name); len(st.Tags) > 0 {
+			strategies = append(strategies, st)
+		}
+	}
+	// 捕获任意长度的tag
+	greedy := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	greedy.Tags = map[string]string{"rest": `(.*)`, "word": `(\S+)`}
+	greedy.TagRegs = map[string]*regexp.Regexp{"rest": regexp.MustCompile(`(.*)`), "word": regexp.MustCompile(`(\S+)`)}
+	strategies = append(strategies, greedy)
+
+	f.Fuzz(func(t *testing.T, line string) {
+		line = boundLine(line)
+		for _, st := range strategies {
+			timed(t, line, func() {
+				tags, miss, err := extractTags(line, nil, st)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if miss != "" {
+					return
+				}
+				if len(tags) != len(st.Tags) {
+					t.Fatalf("sid %d: got tags %v of %v", st.ID, tags, st.Tags)
+				}
+				for k, v := range tags {
+					if max, _ := tagValueLimit(st, k); len(v) > max {
+						t.Fatalf("sid %d: tag %s of %d bytes exceeds %d", st.ID, k, len(v), max)
+					}
+					if utf8.ValidString(line) && !utf8.ValidString(v) {
+						t.Fatalf("sid %d: tag %s cut inside a rune: %q", st.ID, k, v)
+					}
+				}
+			})
+		}
+	})
+}
+
+func FuzzProducer(f *testing.F) {
+	fuzzSeeds(f)
+	var strategies []*scheme.Strategy
+	for _, name := range fixtureNames(f) {
+		st, _, _ := LoadFixture(f, name)
+		strategies = append(strategies, st)
+	}
+	w := &Worker{Mark: "[worker][fuzz]", Callback: func(int64, int64) {}}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for _, st := range strategies {
+			timed(t, line, func() {
+				// produce会recover, panic只能从计数上看出来
+				panics := atomic.LoadInt64(&producerPanics)
+				p, err := w.producer(line, st)
+				if atomic.LoadInt64(&producerPanics) != panics {
+					t.Fatalf("sid %d: producer panicked", st.ID)
+				}
+				if err != nil || p == nil {
+					return
+				}
+				if p.StrategyID != st.ID {
+					t.Fatalf("sid %d: point of strategy %d", st.ID, p.StrategyID)
+				}
+				// 产生的点大小有上限, 不随行长度增长
+				for k, v := range p.Tags {
+					if len(v) > maxLineBytes() {
+						t.Fatalf("sid %d: tag %s of %d bytes", st.ID, k, len(v))
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestBoundLine(t *testing.T) {
+	if got := boundLine("short"); got != "short" {
+		t.Errorf("short line changed: %q", got)
+	}
+	before := TruncatedLines()
+	long := strings.Repeat("a", DefaultMaxLineBytes-1) + "界"
+	got := boundLine(long)
+	if len(got) != DefaultMaxLineBytes-1 || !utf8.ValidString(got) {
+		t.Errorf("truncated to %d bytes, valid utf8 %v", len(got), utf8.ValidString(got))
+	}
+	if TruncatedLines() != before+1 {
+		t.Errorf("truncated lines %d, want %d", TruncatedLines(), before+1)
+	}
+}
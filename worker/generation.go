@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// GenerationMixedTagk 周期内由多个策略代数的观测值聚合出的点带上的tag
+const GenerationMixedTagk = "generation_mixed"
+
+const (
+	// genSplitMax 单个序列单周期最多分别记录的代数, 超出的计入最后一项
+	genSplitMax = 4
+	// generationMixMax 最多保留的混合记录数, 超过后覆盖最早的
+	generationMixMax = 10000
+)
+
+// GenCount is the number of observations made by one generation of the strategy
+type GenCount struct {
+	Gen   int64 `json:"gen"`
+	Count int64 `json:"count"`
+}
+
+// GenSplit is the generations of the strategy contributed to an accumulator, in order of arrival
+// 绝大多数周期只有一个代数, 每个观测值只比较一次最后一项
+type GenSplit []GenCount
+
+// add to count n observations of generation gen, 0 means not tracked
+func (s *GenSplit) add(gen, n int64) {
+	if gen == 0 || n == 0 {
+		return
+	}
+	l := len(*s)
+	if l > 0 && (*s)[l-1].Gen == gen {
+		(*s)[l-1].Count += n
+		return
+	}
+	for i := range *s {
+		if (*s)[i].Gen == gen {
+			(*s)[i].Count += n
+			return
+		}
+	}
+	if l >= genSplitMax {
+		(*s)[l-1].Count += n
+		return
+	}
+	*s = append(*s, GenCount{Gen: gen, Count: n})
+}
+
+// merge to add the counts of another split
+func (s *GenSplit) merge(o GenSplit) {
+	for _, c := range o {
+		s.add(c.Gen, c.Count)
+	}
+}
+
+// clone to copy the split, nil stays nil
+func (s GenSplit) clone() GenSplit {
+	if s == nil {
+		return nil
+	}
+	return append(GenSplit{}, s...)
+}
+
+// Mixed to check whether more than one generation contributed
+func (s GenSplit) Mixed() bool {
+	return len(s) > 1
+}
+
+// generationMixedTag to check whether mixed points are tagged, default true
+func generationMixedTag() bool {
+	if g.Conf() == nil || g.Conf().Worker.GenerationMixedTag == nil {
+		return true
+	}
+	return *g.Conf().Worker.GenerationMixedTag
+}
+
+// GenerationRecord is the generation split of one series of one period aggregated across a strategy update
+type GenerationRecord struct {
+	StrategyID int64    `json:"strategy_id"`
+	Tms        int64    `json:"tms"`
+	Tags       string   `json:"tags"`
+	Splits     GenSplit `json:"splits"`
+}
+
+var (
+	generationMixes   = make([]GenerationRecord, 0, generationMixMax)
+	generationMixPos  int
+	generationMixLock sync.Mutex
+)
+
+// recordGenerationMixes to record the split of series of the pushed step built from more than one generation
+func recordGenerationMixes(st *scheme.Strategy, tms int64, pc *PointsCounter) {
+	pc.RLock()
+	defer pc.RUnlock()
+	for tagstring, p := range pc.TagstringMap {
+		p.RLock()
+		if p.Gens.Mixed() {
+			recordGenerationMix(GenerationRecord{StrategyID: st.ID, Tms: tms, Tags: tagstring, Splits: p.Gens.clone()})
+		}
+		p.RUnlock()
+	}
+}
+
+func recordGenerationMix(r GenerationRecord) {
+	generationMixLock.Lock()
+	defer generationMixLock.Unlock()
+	if len(generationMixes) < generationMixMax {
+		generationMixes = append(generationMixes, r)
+		return
+	}
+	generationMixes[generationMixPos] = r
+	generationMixPos = (generationMixPos + 1) % generationMixMax
+}
+
+// GenerationMixes to get the recent mixed records of the strategy from oldest to newest, 0 means all
+func GenerationMixes(sid int64) []GenerationRecord {
+	generationMixLock.Lock()
+	defer generationMixLock.Unlock()
+	ret := make([]GenerationRecord, 0)
+	for i := range generationMixes {
+		r := generationMixes[(generationMixPos+i)%len(generationMixes)]
+		if sid == 0 || r.StrategyID == sid {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
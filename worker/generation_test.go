@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func resetGenerationMixes(t *testing.T) {
+	generationMixLock.Lock()
+	saved, savedPos := generationMixes, generationMixPos
+	generationMixes, generationMixPos = make([]GenerationRecord, 0, generationMixMax), 0
+	generationMixLock.Unlock()
+	t.Cleanup(func() {
+		generationMixLock.Lock()
+		generationMixes, generationMixPos = saved, savedPos
+		generationMixLock.Unlock()
+	})
+}
+
+func TestGenSplit(t *testing.T) {
+	var s GenSplit
+	s.add(0, 5) //未知代数不记录
+	s.add(3, 1)
+	s.add(3, 1)
+	if s.Mixed() || !reflect.DeepEqual(s, GenSplit{{3, 2}}) {
+		t.Fatalf("split %v", s)
+	}
+	var o GenSplit
+	for gen := int64(4); gen <= 8; gen++ {
+		o.add(gen, 1)
+	}
+	o.add(4, 1)
+	s.merge(o)
+	// 最多记录genSplitMax个代数, 之后的计入最后一项
+	if want := (GenSplit{{3, 2}, {4, 2}, {5, 1}, {6, 3}}); !reflect.DeepEqual(s, want) {
+		t.Fatalf("split %v, want %v", s, want)
+	}
+}
+
+// genStrategy to build the strategy as loaded, rounding differs by version
+func genStrategy(version int) *scheme.Strategy {
+	s := reloadStrategy(601, 0)
+	s.Func = "sum"
+	s.Interval = 60
+	if version > 1 {
+		decimals := 0
+		s.ValueRoundDecimals = &decimals
+	}
+	return s
+}
+
+// 周期中间更新策略, 该周期的点带上generation_mixed并记录各代数的观测数, 其他周期不受影响
+func TestGenerationMixedAtUpdateBoundary(t *testing.T) {
+	drainPushQueue()
+	resetGenerationMixes(t)
+	defer strategy.UpdateGlobalStrategy(nil)
+	defer GlobalCount.deleteByID(601)
+
+	w := &Worker{Mark: "[worker][generation]", Callback: func(int64, int64) {}}
+	// 点的时间取自时钟, 这里固定到各周期
+	produce := func(n int, tms int64) {
+		st, _ := strategy.GetByID(601)
+		for i := 0; i < n; i++ {
+			p, err := w.producer("2018-01-01 12:00:01 code=500 cost=12", st)
+			if err != nil || p == nil {
+				t.Fatalf("point %v err %v", p, err)
+			}
+			p.Tms = tms
+			if err := PushToCount(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	const period = int64(1500000000)
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{genStrategy(1)})
+	old, _ := strategy.GetByID(601)
+	produce(4, period)
+	produce(2, period+60)
+	// 定义不变的重新加载不算更新
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{genStrategy(1)})
+	produce(1, period+60)
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{genStrategy(2)})
+	cur, _ := strategy.GetByID(601)
+	if cur.Generation == old.Generation {
+		t.Fatalf("generation not bumped by update: %d", cur.Generation)
+	}
+	produce(3, period+60)
+	produce(5, period+120)
+
+	stCount, err := GlobalCount.GetStrategyCountByID(601)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tms := range []int64{period, period + 60, period + 120} {
+		pc, err := stCount.GetByTms(tms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushStep(cur, tms, pc, "host-01")
+		ps := drainPushQueue()
+		if len(ps) != 1 {
+			t.Fatalf("tms %d: got %d points", tms, len(ps))
+		}
+		mixed := strings.Contains(ps[0].Tags, GenerationMixedTagk+"=true")
+		if mixed != (tms == period+60) {
+			t.Errorf("tms %d: tags %s, mixed %v", tms, ps[0].Tags, mixed)
+		}
+	}
+
+	want := []GenerationRecord{{
+		StrategyID: 601,
+		Tms:        period + 60,
+		Tags:       "code=500",
+		Splits:     GenSplit{{old.Generation, 3}, {cur.Generation, 3}},
+	}}
+	if got := GenerationMixes(601); !reflect.DeepEqual(got, want) {
+		t.Fatalf("records %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker/lineproto"
+)
+
+const (
+	influxDBWritePath      = "/api/v2/write"
+	defaultInfluxDBPrefix  = "log."
+	defaultInfluxDBBatch   = 5000
+	influxDBValueField     = "value"
+	influxDBEndpointTagKey = "endpoint"
+)
+
+// InfluxDBSink to write aggregated points to InfluxDB v2 in line protocol
+// measurement为前缀+策略名, tag为点的tag加上endpoint, 值为value字段, 时间为周期开始(秒)
+// 失败时与OTLPSink一样指数退避重试, 429/5xx重试, 其他错误丢弃该batch并计入log.agent.sink.err.cnt
+type InfluxDBSink struct {
+	batchSink
+	URL      string //如http://127.0.0.1:8086
+	Org      string
+	Bucket   string
+	Token    string
+	Timeout  time.Duration
+	Prefix   string //measurement前缀, 默认与推送falcon的一致, 为log.
+	Host     string //endpoint tag
+	External bool   //发往外部, 配置了noise的策略发送加噪后的值
+
+	lookup func(id int64) (*scheme.Strategy, error)
+	client *http.Client
+}
+
+// NewInfluxDBSink to create an InfluxDB sink
+func NewInfluxDBSink(url, org, bucket string, queueSize int) *InfluxDBSink {
+	url = strings.TrimSuffix(url, "/")
+	return &InfluxDBSink{
+		batchSink: newBatchSink("influxdb", url, defaultInfluxDBBatch, queueSize),
+		URL:       url,
+		Org:       org,
+		Bucket:    bucket,
+		Timeout:   10 * time.Second,
+		Prefix:    defaultInfluxDBPrefix,
+		lookup:    strategy.GetByID,
+	}
+}
+
+// Start to write points until stopped
+func (s *InfluxDBSink) Start() {
+	if s.client == nil {
+		s.client = &http.Client{Timeout: s.Timeout}
+	}
+	s.run(s.encode, s.write)
+}
+
+// encode to serialize points as line protocol in one payload
+// 策略已删除及值为NaN、Inf的点丢弃
+func (s *InfluxDBSink) encode(points []*AnalysPoint) []sinkPayload {
+	var buf []byte
+	n := 0
+	for _, p := range points {
+		st, err := s.lookup(p.StrategyID)
+		if err != nil {
+			metric.MetricSinkDropPoint(1)
+			continue
+		}
+		tags := p.Tags
+		if _, ok := tags[influxDBEndpointTagKey]; !ok && s.Host != "" {
+			tags = make(map[string]string, len(p.Tags)+1)
+			for k, v := range p.Tags {
+				tags[k] = v
+			}
+			tags[influxDBEndpointTagKey] = s.Host
+		}
+		line := &lineproto.Point{
+			Measurement: s.Prefix + st.Name,
+			Tags:        tags,
+			Fields:      map[string]float64{influxDBValueField: p.Value},
+			Time:        p.Tms,
+		}
+		if buf, err = lineproto.Append(buf, line); err != nil {
+			dlog.Debugf("influxdb skip point [sid:%d][tms:%d][err:%v]", p.StrategyID, p.Tms, err)
+			metric.MetricSinkDropPoint(1)
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	return []sinkPayload{{body: buf, n: n}}
+}
+
+// write to post the lines once
+func (s *InfluxDBSink) write(p sinkPayload) error {
+	q := url.Values{}
+	q.Set("org", s.Org)
+	q.Set("bucket", s.Bucket)
+	q.Set("precision", "s")
+	req, err := http.NewRequest("POST", s.URL+influxDBWritePath+"?"+q.Encode(), bytes.NewReader(p.body))
+	if err != nil {
+		return &sinkWriteError{err: err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &sinkWriteError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		// 限流及服务端错误可以重试, 4xx(格式错误、鉴权失败、bucket不存在等)重试也不会成功
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return &sinkWriteError{err: fmt.Errorf("http status %d: %s", resp.StatusCode, respBody), retryable: retryable}
+	}
+	metric.MetricSinkSent("influxdb", int64(p.n))
+	return nil
+}
+
+// influxDBSinkExtension to build the InfluxDB sink of the config, disabled if not configured
+func influxDBSinkExtension(c *g.Config) *Extension {
+	ic := c.Sink.InfluxDB
+	e := &Extension{Name: "influxdb", Kind: ExtensionSink, External: ic.External, Version: configVersion(ic, c.Endpoint)}
+	if ic.URL == "" {
+		return e
+	}
+	s := NewInfluxDBSink(ic.URL, ic.Org, ic.Bucket, ic.QueueSize)
+	s.Token = ic.Token
+	s.Host = c.Endpoint
+	s.External = ic.External
+	if ic.BatchSize > 0 {
+		s.BatchSize = ic.BatchSize
+	}
+	if ic.BatchWaitMs > 0 {
+		s.BatchWait = time.Duration(ic.BatchWaitMs) * time.Millisecond
+	}
+	if ic.TimeoutMs > 0 {
+		s.Timeout = time.Duration(ic.TimeoutMs) * time.Millisecond
+	}
+	if ic.MeasurementPrefix != "" {
+		s.Prefix = ic.MeasurementPrefix
+	}
+	e.Enabled = true
+	e.Send, e.Start, e.Stop = s.Send, func() { go s.Start() }, s.Stop
+	return e
+}
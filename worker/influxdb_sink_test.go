@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// influxDBServer is an in-process InfluxDB v2 write API recording the lines written
+type influxDBServer struct {
+	sync.Mutex
+	lines  []string
+	querys []string
+	auths  []string
+	fail   []int //依次返回的失败状态码
+}
+
+func (c *influxDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Method != "POST" || r.URL.Path != influxDBWritePath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if len(c.fail) > 0 {
+		var fail int
+		fail, c.fail = c.fail[0], c.fail[1:]
+		w.WriteHeader(fail)
+		return
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+		c.lines = append(c.lines, l)
+	}
+	c.querys = append(c.querys, r.URL.RawQuery)
+	c.auths = append(c.auths, r.Header.Get("Authorization"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *influxDBServer) waitLines(t *testing.T, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.Lock()
+		lines := append([]string{}, c.lines...)
+		c.Unlock()
+		if len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d lines %v, want %d", len(lines), lines, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestInfluxDBSink(url string) *InfluxDBSink {
+	s := NewInfluxDBSink(url, "ops", "logs", 100)
+	s.BatchWait = 10 * time.Millisecond
+	s.Token = "secret"
+	s.Host = "host-01"
+	s.lookup = func(id int64) (*scheme.Strategy, error) {
+		if st, ok := otlpTestStrategies[id]; ok {
+			return st, nil
+		}
+		return nil, fmt.Errorf("no strategy %d", id)
+	}
+	return s
+}
+
+func TestInfluxDBSinkWrite(t *testing.T) {
+	c := &influxDBServer{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := newTestInfluxDBSink(srv.URL + "/")
+	go s.Start()
+	defer s.Stop()
+
+	for _, p := range []*AnalysPoint{
+		{StrategyID: 1, Tms: 1500000000, Value: 3, Tags: map[string]string{"code": "500", "path": "/a b,c"}},
+		{StrategyID: 3, Tms: 1500000000, Value: 12.25, Tags: nil},
+		{StrategyID: 2, Tms: 1500000000, Value: math.NaN()}, //line protocol不能表示NaN, 丢弃
+		{StrategyID: 404, Tms: 1500000000, Value: 1},        //策略已删除, 丢弃
+		{StrategyID: 1, Tms: 1500000010, Value: 5, Tags: map[string]string{"endpoint": "other"}},
+	} {
+		s.Send(p)
+	}
+
+	lines := c.waitLines(t, 3)
+	want := []string{
+		`log.err.cnt,code=500,endpoint=host-01,path=/a\ b\,c value=3 1500000000`,
+		`log.latency.avg,endpoint=host-01 value=12.25 1500000000`,
+		`log.err.cnt,endpoint=other value=5 1500000010`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("lines:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.querys[0] != "bucket=logs&org=ops&precision=s" || c.auths[0] != "Token secret" {
+		t.Fatalf("query %q auth %q", c.querys[0], c.auths[0])
+	}
+}
+
+func TestInfluxDBSinkRetry(t *testing.T) {
+	c := &influxDBServer{fail: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := newTestInfluxDBSink(srv.URL)
+	go s.Start()
+	defer s.Stop()
+
+	// 失败两次后重试成功, 点只写入一次
+	s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000000, Value: 7})
+	if lines := c.waitLines(t, 1); lines[0] != "log.err.cnt,endpoint=host-01 value=7 1500000000" {
+		t.Fatalf("lines = %v", lines)
+	}
+
+	// 4xx不重试, 丢弃该batch, 不影响之后的点
+	c.Lock()
+	c.fail = []int{http.StatusBadRequest}
+	c.Unlock()
+	s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000010, Value: 8})
+	time.Sleep(100 * time.Millisecond)
+	s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000020, Value: 9})
+	c.waitLines(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	if lines := c.waitLines(t, 2); len(lines) != 2 || !strings.Contains(lines[1], "value=9") {
+		t.Fatalf("lines = %v", lines)
+	}
+}
+
+// 多个外部sink收到同一个加噪值, 内部sink收到精确值
+func TestInfluxDBSinkNoise(t *testing.T) {
+	drainPushQueue()
+	resetNoiseAudit(t)
+	otlp := NewOTLPSink("http://127.0.0.1:4317", "", 100)
+	otlp.External = true
+	withOTLPSink(t, otlp)
+	influx := NewInfluxDBSink("http://127.0.0.1:8086", "ops", "logs", 100)
+	withSink(t, "influxdb", influx.Send, influx.External)
+
+	st := noiseStrategy(104)
+	flushNoise(st, 1500000000, 42)
+	drainPushQueue()
+	o, i := drainOTLPQueue(otlp), <-influx.queue
+	if len(o) != 1 || i.Value != 42 {
+		t.Fatalf("internal influxdb got %v, want exact 42", i.Value)
+	}
+
+	influx.External = true
+	withSink(t, "influxdb", influx.Send, influx.External)
+	flushNoise(st, 1500000060, 42)
+	drainPushQueue()
+	o, i = drainOTLPQueue(otlp), <-influx.queue
+	if len(o) != 1 || o[0].Value != i.Value || i.Value == 42 {
+		t.Fatalf("external sinks got otlp %v influxdb %v, want the same noised value", o[0].Value, i.Value)
+	}
+	if rs := NoiseAudit(st.ID); len(rs) != 2 {
+		t.Errorf("audit %v, want one record per flush", rs)
+	}
+}
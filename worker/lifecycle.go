@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+)
+
+// 生命周期状态, 只能按 created → started → stopping → stopped 前进, created也可以直接stopping
+const (
+	GroupCreated  = "created"
+	GroupStarted  = "started"
+	GroupStopping = "stopping"
+	GroupStopped  = "stopped"
+)
+
+// groupLifecycleMax 最多保留的状态变化记录数, 超过后覆盖最早的
+const groupLifecycleMax = 1000
+
+// groupLife to hold the lifecycle state of a worker group
+// Start与Stop都持锁转换状态, 并发调用时只有一个生效
+type groupLife struct {
+	sync.Mutex
+	state           string //为空表示created
+	since           time.Time
+	redundantStarts int64 //已启动后又调用Start的次数
+}
+
+// GroupLifecycleEvent is a state transition of a worker group
+// StrategyID非0时是该策略的boost记录, Principal为操作人
+type GroupLifecycleEvent struct {
+	File       string `json:"file"`
+	Shard      int    `json:"shard"`
+	StrategyID int64  `json:"strategy_id,omitempty"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Principal  string `json:"principal,omitempty"`
+	Reason     string `json:"reason,omitempty"` //boost结束的原因: cleared, expired, strategy updated
+	Time       int64  `json:"time"`
+}
+
+// GroupLifecycleStat is the lifecycle state of a worker group
+type GroupLifecycleStat struct {
+	Shard           int    `json:"shard"`
+	Generation      int64  `json:"generation"`
+	State           string `json:"state"`
+	Since           int64  `json:"since"`
+	RedundantStarts int64  `json:"redundant_starts,omitempty"`
+}
+
+var (
+	groupEvents     = make([]GroupLifecycleEvent, 0, groupLifecycleMax)
+	groupEventsPos  int
+	groupEventsLock sync.Mutex
+)
+
+func (wg *WorkerGroup) stateLocked() string {
+	if wg.life.state == "" {
+		return GroupCreated
+	}
+	return wg.life.state
+}
+
+// setStateLocked to move the group to the state and record the transition
+func (wg *WorkerGroup) setStateLocked(to string) {
+	from := wg.stateLocked()
+	wg.life.state = to
+	wg.life.since = time.Now()
+	dlog.Infof("worker group %s -> %s [file:%s][shard:%d]", from, to, wg.filePath, wg.Shard)
+	recordGroupEvent(GroupLifecycleEvent{File: wg.filePath, Shard: wg.Shard, From: from, To: to, Time: wg.life.since.Unix()})
+}
+
+// State to get the lifecycle state of the group
+func (wg *WorkerGroup) State() string {
+	wg.life.Lock()
+	defer wg.life.Unlock()
+	return wg.stateLocked()
+}
+
+// LifecycleStat to get the lifecycle state of the group
+func (wg *WorkerGroup) LifecycleStat() GroupLifecycleStat {
+	wg.life.Lock()
+	defer wg.life.Unlock()
+	return GroupLifecycleStat{
+		Shard:           wg.Shard,
+		Generation:      wg.Generation,
+		State:           wg.stateLocked(),
+		Since:           wg.life.since.Unix(),
+		RedundantStarts: wg.life.redundantStarts,
+	}
+}
+
+func recordGroupEvent(e GroupLifecycleEvent) {
+	groupEventsLock.Lock()
+	defer groupEventsLock.Unlock()
+	if len(groupEvents) < groupLifecycleMax {
+		groupEvents = append(groupEvents, e)
+		return
+	}
+	groupEvents[groupEventsPos] = e
+	groupEventsPos = (groupEventsPos + 1) % groupLifecycleMax
+}
+
+// GroupLifecycleEvents to get the recent transitions of the groups of the file from oldest to newest, empty means all
+func GroupLifecycleEvents(file string) []GroupLifecycleEvent {
+	groupEventsLock.Lock()
+	defer groupEventsLock.Unlock()
+	ret := make([]GroupLifecycleEvent, 0)
+	for i := range groupEvents {
+		e := groupEvents[(groupEventsPos+i)%len(groupEvents)]
+		if file == "" || e.File == file {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// GroupLifecycleStats to get the lifecycle state of the worker groups of all files
+func GroupLifecycleStats() map[string][]GroupLifecycleStat {
+	ret := make(map[string][]GroupLifecycleStat)
+	ManagerJobLock.RLock()
+	defer ManagerJobLock.RUnlock()
+	for file, job := range ManagerJob {
+		for _, wg := range job.groups() {
+			if wg != nil {
+				ret[file] = append(ret[file], wg.LifecycleStat())
+			}
+		}
+	}
+	return ret
+}
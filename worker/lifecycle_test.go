@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// concurrently to call f n times at the same time
+func concurrently(n int, f func(i int)) {
+	var wait sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			<-start
+			f(i)
+		}(i)
+	}
+	close(start)
+	wait.Wait()
+}
+
+func TestGroupLifecycle(t *testing.T) {
+	skipIfStalePanics(t)
+	wg := newParkGroup(2, make(chan reader.Line, 4), func(int64, int64) {})
+	if s := wg.State(); s != GroupCreated {
+		t.Fatalf("state %s, want created", s)
+	}
+
+	// 并发Start只有一次生效, 其余计数
+	errs := make([]error, 8)
+	concurrently(8, func(i int) { errs[i] = wg.Start() })
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("start %d: %v", i, err)
+		}
+	}
+	if stat := wg.LifecycleStat(); stat.State != GroupStarted || stat.RedundantStarts != 7 {
+		t.Fatalf("after concurrent starts %+v, want started with 7 redundant", stat)
+	}
+
+	// 并发Stop不panic, 之后Start、Pause、Resume都返回ErrGroupStopped
+	concurrently(8, func(int) { wg.Stop() })
+	if s := wg.State(); s != GroupStopped {
+		t.Fatalf("state %s, want stopped", s)
+	}
+	concurrently(8, func(i int) { errs[i] = wg.Start() })
+	for i, err := range errs {
+		if err != ErrGroupStopped {
+			t.Fatalf("start %d after stop: %v, want ErrGroupStopped", i, err)
+		}
+	}
+	if err := wg.Pause("test", ""); err != ErrGroupStopped {
+		t.Fatalf("pause after stop: %v", err)
+	}
+	if err := wg.Resume(); err != ErrGroupStopped {
+		t.Fatalf("resume after stop: %v", err)
+	}
+	for _, w := range wg.Workers {
+		w.Stop()
+	}
+
+	var transitions []string
+	for _, e := range GroupLifecycleEvents(parkFile) {
+		transitions = append(transitions, e.From+">"+e.To)
+	}
+	want := []string{"created>started", "started>stopping", "stopping>stopped"}
+	if len(transitions) < 3 || !equalStrings(transitions[len(transitions)-3:], want) {
+		t.Fatalf("events %v, want to end with %v", transitions, want)
+	}
+}
+
+func TestGroupLifecycleRace(t *testing.T) {
+	skipIfStalePanics(t)
+	// Start、Stop、Pause、Resume混在一起并发调用
+	for round := 0; round < 20; round++ {
+		wg := newParkGroup(2, make(chan reader.Line, 4), func(int64, int64) {})
+		wg.useLIFO(4)
+		errs := make([]error, 16)
+		concurrently(16, func(i int) {
+			switch i % 4 {
+			case 0:
+				errs[i] = wg.Start()
+			case 1:
+				wg.Stop()
+			case 2:
+				errs[i] = wg.Pause("test", "race")
+			case 3:
+				errs[i] = wg.Resume()
+			}
+		})
+		for i, err := range errs {
+			switch i % 4 {
+			case 0:
+				if err != nil && err != ErrGroupStopped {
+					t.Fatalf("start: %v", err)
+				}
+			case 2:
+				if err != nil && err != ErrGroupStopped && err != ErrGroupPaused && err != ErrGroupNotPaused && err != ErrParkTimeout {
+					t.Fatalf("pause: %v", err)
+				}
+			}
+		}
+		if s := wg.State(); s != GroupStopped {
+			t.Fatalf("state %s after stop, want stopped", s)
+		}
+	}
+}
+
+// Stop未启动的group直接停止
+func TestGroupStopBeforeStart(t *testing.T) {
+	skipIfStalePanics(t)
+	wg := newParkGroup(1, make(chan reader.Line), func(int64, int64) {})
+	wg.Stop()
+	wg.Stop()
+	if err := wg.Start(); err != ErrGroupStopped {
+		t.Fatalf("start after stop: %v", err)
+	}
+	if s := wg.State(); s != GroupStopped {
+		t.Fatalf("state %s, want stopped", s)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
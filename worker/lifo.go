@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+const (
+	// ReceiveOrderFIFO worker按读取的顺序处理, 默认
+	ReceiveOrderFIFO = "fifo"
+	// ReceiveOrderLIFO worker先处理最新读到的行, 积压时告警的延迟有上限
+	ReceiveOrderLIFO = "lifo"
+)
+
+// LIFOQueue is a fixed-size buffer popped from the newest end
+// 满了Push阻塞, 与channel一样把压力传回reader, 不丢行; 空了Pop阻塞
+// 只在最新的一端进出, 不需要记录头部位置
+type LIFOQueue struct {
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	buf      []reader.Line
+	n        int
+	closed   bool
+}
+
+// NewLIFOQueue to create a queue holding at most size lines
+func NewLIFOQueue(size int) *LIFOQueue {
+	if size <= 0 {
+		size = 1
+	}
+	q := &LIFOQueue{buf: make([]reader.Line, size)}
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+// Push to add a line as the newest, blocks while full, false if the queue is closed
+func (q *LIFOQueue) Push(line reader.Line) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.n == len(q.buf) && !q.closed {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return false
+	}
+	q.buf[q.n] = line
+	q.n++
+	q.notEmpty.Signal()
+	return true
+}
+
+// Pop to take the newest line, blocks while empty, false if the queue is closed
+// 关闭后剩余的行不再取出, 与worker停止时channel中剩余的行一样丢弃
+func (q *LIFOQueue) Pop() (reader.Line, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.n == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.closed {
+		return reader.Line{}, false
+	}
+	q.n--
+	line := q.buf[q.n]
+	q.buf[q.n] = reader.Line{}
+	q.notFull.Signal()
+	return line, true
+}
+
+// Len to get the lines in the queue
+func (q *LIFOQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.n
+}
+
+// Close to wake up all blocked Push and Pop, both return false afterwards
+func (q *LIFOQueue) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// receiveOrder to get the configured order in which workers take lines
+func receiveOrder() string {
+	if g.Conf() == nil || g.Conf().Worker.ReceiveOrder == "" {
+		return ReceiveOrderFIFO
+	}
+	return g.Conf().Worker.ReceiveOrder
+}
+
+// useLIFO to put a LIFOQueue of size between the stream of the group and its workers
+// 两个goroutine: 一个把stream中的行按读取顺序压入队列, 一个把最新的行交给空闲的worker;
+// worker仍然从channel收行, 暂停、停止的处理不变
+func (wg *WorkerGroup) useLIFO(size int) {
+	wg.lifo = NewLIFOQueue(size)
+	wg.lifoDone = make(chan struct{})
+	recv := make(chan reader.Line)
+	for _, w := range wg.Workers {
+		if wg.stream == nil {
+			wg.stream = w.Stream
+		}
+		w.Stream = recv
+	}
+	wg.recv = recv
+}
+
+// startLIFO to start moving lines through the queue
+func (wg *WorkerGroup) startLIFO() {
+	go func() {
+		for {
+			select {
+			case line, ok := <-wg.stream:
+				if !ok {
+					wg.lifo.Close()
+					return
+				}
+				if !wg.lifo.Push(line) {
+					return
+				}
+			case <-wg.lifoDone:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			line, ok := wg.lifo.Pop()
+			if !ok {
+				return
+			}
+			select {
+			case wg.recv <- line:
+			case <-wg.lifoDone:
+				return
+			}
+		}
+	}()
+}
+
+// stopLIFO to stop both goroutines, lines still in the queue are dropped
+func (wg *WorkerGroup) stopLIFO() {
+	wg.lifo.Close()
+	close(wg.lifoDone)
+}
+
+// backlog to get the lines read but not taken by workers yet
+func (wg *WorkerGroup) backlog() int {
+	n := 0
+	if wg.stream != nil {
+		n = len(wg.stream)
+	} else {
+		wg.workersLock.RLock()
+		if len(wg.Workers) > 0 {
+			n = len(wg.Workers[0].Stream)
+		}
+		wg.workersLock.RUnlock()
+	}
+	if wg.lifo != nil {
+		n += wg.lifo.Len()
+	}
+	return n
+}
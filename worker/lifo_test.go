@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestLIFOQueueOrder(t *testing.T) {
+	q := NewLIFOQueue(3)
+	for _, s := range []string{"a", "b", "c"} {
+		q.Push(reader.Line{Text: s})
+	}
+	if line, _ := q.Pop(); line.Text != "c" {
+		t.Fatalf("pop %q, want the newest c", line.Text)
+	}
+	// 出队后放入的仍然先出
+	q.Push(reader.Line{Text: "d"})
+	for _, want := range []string{"d", "b", "a"} {
+		if line, _ := q.Pop(); line.Text != want {
+			t.Fatalf("pop %q, want %q", line.Text, want)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("len %d after popping all", q.Len())
+	}
+}
+
+func TestLIFOQueueBlocking(t *testing.T) {
+	q := NewLIFOQueue(1)
+	popped := make(chan string)
+	go func() {
+		line, _ := q.Pop()
+		popped <- line.Text
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.Push(reader.Line{Text: "a"})
+	if got := <-popped; got != "a" {
+		t.Fatalf("blocked pop got %q", got)
+	}
+
+	// 满了Push阻塞, Close后返回false
+	q.Push(reader.Line{Text: "b"})
+	pushed := make(chan bool)
+	go func() { pushed <- q.Push(reader.Line{Text: "c"}) }()
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue should block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Close()
+	if <-pushed {
+		t.Fatal("push returned true after close")
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatal("pop returned a line after close")
+	}
+}
+
+func TestLIFOGroup(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	// 启动前积压的行先处理最新的
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var lock sync.Mutex
+	order := make([]int64, 0)
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(1, stream, func(tms, delay int64) {
+		lock.Lock()
+		order = append(order, tms)
+		lock.Unlock()
+	})
+	wg.useLIFO(16)
+	for i := 0; i < 5; i++ {
+		wg.lifo.Push(parkLine(base, i))
+	}
+	if wg.backlog() != 5 {
+		t.Fatalf("backlog %d, want 5", wg.backlog())
+	}
+	wait := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			lock.Lock()
+			n := len(order)
+			lock.Unlock()
+			if n == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("processed %d of %d lines", n, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wg.Start()
+	defer wg.Stop()
+	wait(5)
+	lock.Lock()
+	for i, tms := range order {
+		if want := base.Add(time.Duration(4-i) * time.Second).Unix(); tms != want {
+			t.Fatalf("processed %v, want the backlog from the newest", order)
+		}
+	}
+	lock.Unlock()
+
+	// 之后从stream读到的行照常处理
+	for i := 5; i < 8; i++ {
+		stream <- parkLine(base, i)
+	}
+	wait(8)
+	lock.Lock()
+	defer lock.Unlock()
+	seen := make(map[int64]bool)
+	for _, tms := range order {
+		seen[tms] = true
+	}
+	if len(seen) != 8 {
+		t.Fatalf("lines lost or duplicated: %v", order)
+	}
+}
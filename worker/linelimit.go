@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+)
+
+// DefaultMaxLineBytes 进入匹配的行默认的最大字节数
+const DefaultMaxLineBytes = 64 * 1024
+
+// truncatedLines 被截断的行数
+var truncatedLines int64
+
+// TruncatedLines to get the number of lines truncated by max_line_bytes
+func TruncatedLines() int64 {
+	return atomic.LoadInt64(&truncatedLines)
+}
+
+func maxLineBytes() int {
+	if g.Conf() != nil && g.Conf().Worker.MaxLineBytes > 0 {
+		return g.Conf().Worker.MaxLineBytes
+	}
+	return DefaultMaxLineBytes
+}
+
+// boundLine to cut the line to at most max_line_bytes at a rune boundary
+// 日志内容不可控, 截断后各正则的开销、捕获的长度都有上限
+func boundLine(line string) string {
+	max := maxLineBytes()
+	if len(line) <= max {
+		return line
+	}
+	atomic.AddInt64(&truncatedLines, 1)
+	cut := max
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut]
+}
+
+// lineTruncationMinLines 行数太少时p99不可信, 不告警
+const lineTruncationMinLines = 1000
+
+var (
+	truncationWarned     = make(map[string]bool)
+	truncationWarnedLock = new(sync.Mutex)
+)
+
+// lineTruncationWarnings to get warnings of files not warned before, whose p99 line length exceeds the limit
+func lineTruncationWarnings(stats map[string]metric.LineLengthStat, limit int64) []string {
+	truncationWarnedLock.Lock()
+	defer truncationWarnedLock.Unlock()
+	var ret []string
+	for file, stat := range stats {
+		if truncationWarned[file] || stat.Lines < lineTruncationMinLines {
+			continue
+		}
+		if w := stat.TruncationWarning(file, limit); w != "" {
+			truncationWarned[file] = true
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+// WarnLineTruncation to warn once per file when lines read since startup would be truncated by max_line_bytes
+// 由自监控的ticker驱动, 每个文件读够lineTruncationMinLines行后判断一次p99
+func WarnLineTruncation(w ticker.Window) {
+	for _, warning := range lineTruncationWarnings(metric.LineLengthStats(), int64(maxLineBytes())) {
+		dlog.Warning(warning)
+	}
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// lineLengthStat to get the histogram of n lines of size bytes and long lines of longSize bytes
+func lineLengthStat(n, size, long, longSize int) metric.LineLengthStat {
+	h := metric.NewLineLengths()
+	for i := 0; i < n; i++ {
+		h.Observe(size)
+	}
+	for i := 0; i < long; i++ {
+		h.Observe(longSize)
+	}
+	return h.Stat()
+}
+
+func TestLineTruncationWarnings(t *testing.T) {
+	defer func() { truncationWarned = make(map[string]bool) }()
+	stats := map[string]metric.LineLengthStat{
+		"/var/log/app.log":   lineLengthStat(970, 200, 30, 80*1024),
+		"/var/log/ok.log":    lineLengthStat(1000, 200, 0, 0),
+		"/var/log/few.log":   lineLengthStat(10, 200, 10, 80*1024), //行数太少
+		"/var/log/small.log": lineLengthStat(995, 200, 5, 80*1024), //超长的行不到1%
+	}
+	got := lineTruncationWarnings(stats, DefaultMaxLineBytes)
+	if len(got) != 1 || !strings.Contains(got[0], "you will truncate ~3.0% of lines on /var/log/app.log") {
+		t.Fatalf("warnings %v", got)
+	}
+	// 每个文件只告警一次
+	if got = lineTruncationWarnings(stats, DefaultMaxLineBytes); len(got) != 0 {
+		t.Fatalf("warned again %v", got)
+	}
+	// 上限调小后, 没告警过的文件才告警
+	if got = lineTruncationWarnings(stats, 100); len(got) != 2 {
+		t.Fatalf("warnings with small limit %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Package lineproto is a minimal encoder of the InfluxDB line protocol.
+
+Each point is one line:
+
+	measurement[,tag_key=tag_value...] field_key=field_value[,...] [timestamp]
+
+Escaping follows the line protocol reference:
+
+	measurement          comma, space
+	tag key/value        comma, equals sign, space
+	field key            comma, equals sign, space
+	string field value   double quote, backslash
+
+Backslashes are escaped in names as well so that a trailing backslash cannot
+escape the following separator, and newlines are written as \n since a line
+cannot span lines. Tags with empty key or value are not allowed by InfluxDB
+and are skipped. Tag keys are sorted, as recommended for write performance.
+*/
+package lineproto
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrBadValue float fields cannot hold NaN or Inf
+var ErrBadValue = errors.New("lineproto: NaN or Inf field value")
+
+// ErrNoMeasurement the measurement is required
+var ErrNoMeasurement = errors.New("lineproto: empty measurement")
+
+var (
+	measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// Point is one line of the line protocol with float fields
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        int64 //按写入时指定的precision, 0表示由服务端取当前时间
+}
+
+// Append to append the encoded line of the point to buf, with the trailing newline
+func Append(buf []byte, p *Point) ([]byte, error) {
+	if p.Measurement == "" {
+		return buf, ErrNoMeasurement
+	}
+	if len(p.Fields) == 0 {
+		return buf, errors.New("lineproto: no field")
+	}
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for k, v := range p.Fields {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return buf, ErrBadValue
+		}
+		fieldKeys = append(fieldKeys, k)
+	}
+	sort.Strings(fieldKeys)
+
+	buf = append(buf, measurementEscaper.Replace(p.Measurement)...)
+
+	tagKeys := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		if k != "" && v != "" {
+			tagKeys = append(tagKeys, k)
+		}
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		buf = append(buf, ',')
+		buf = append(buf, keyEscaper.Replace(k)...)
+		buf = append(buf, '=')
+		buf = append(buf, keyEscaper.Replace(p.Tags[k])...)
+	}
+
+	for i, k := range fieldKeys {
+		if i == 0 {
+			buf = append(buf, ' ')
+		} else {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, keyEscaper.Replace(k)...)
+		buf = append(buf, '=')
+		buf = strconv.AppendFloat(buf, p.Fields[k], 'g', -1, 64)
+	}
+
+	if p.Time != 0 {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, p.Time, 10)
+	}
+	return append(buf, '\n'), nil
+}
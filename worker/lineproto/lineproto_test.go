@@ -0,0 +1,59 @@
+package lineproto
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	cases := []struct {
+		name string
+		p    Point
+		want string
+	}{
+		{"plain", Point{Measurement: "log.nginx_500", Tags: map[string]string{"host": "h1", "code": "500"}, Fields: map[string]float64{"value": 3}, Time: 1500000000},
+			"log.nginx_500,code=500,host=h1 value=3 1500000000\n"},
+		{"float", Point{Measurement: "m", Fields: map[string]float64{"value": 12.25}},
+			"m value=12.25\n"},
+		{"exponent", Point{Measurement: "m", Fields: map[string]float64{"value": 1e21}},
+			"m value=1e+21\n"},
+		{"escape measurement", Point{Measurement: "a b,c=d", Fields: map[string]float64{"value": 1}},
+			"a\\ b\\,c=d value=1\n"},
+		{"escape tags", Point{Measurement: "m", Tags: map[string]string{"k ey": "a=b, c", "path": `C:\logs\`}, Fields: map[string]float64{"value": 1}},
+			"m,k\\ ey=a\\=b\\,\\ c,path=C:\\\\logs\\\\ value=1\n"},
+		{"newline", Point{Measurement: "m", Tags: map[string]string{"msg": "a\nb"}, Fields: map[string]float64{"value": 1}},
+			"m,msg=a\\nb value=1\n"},
+		{"empty tag skipped", Point{Measurement: "m", Tags: map[string]string{"empty": "", "": "x", "k": "v"}, Fields: map[string]float64{"value": -2}},
+			"m,k=v value=-2\n"},
+		{"sorted fields", Point{Measurement: "m", Fields: map[string]float64{"value": 1, "count": 2}},
+			"m count=2,value=1\n"},
+	}
+	for _, c := range cases {
+		got, err := Append(nil, &c.p)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if string(got) != c.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAppendInvalid(t *testing.T) {
+	buf := []byte("m value=1\n")
+	for _, p := range []Point{
+		{Fields: map[string]float64{"value": 1}},
+		{Measurement: "m"},
+		{Measurement: "m", Fields: map[string]float64{"value": math.NaN()}},
+		{Measurement: "m", Fields: map[string]float64{"value": math.Inf(1)}},
+	} {
+		got, err := Append(buf, &p)
+		if err == nil {
+			t.Errorf("%+v should fail", p)
+		}
+		if string(got) != string(buf) {
+			t.Errorf("buffer changed on error: %q", got)
+		}
+	}
+}
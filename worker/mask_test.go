@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+var testMaskPatterns = []scheme.MaskPattern{
+	// 卡号只保留后四位
+	{Regex: `\b(?:\d{4}[- ]?){3}(\d{4})\b`, Replacement: "****-****-****-${1}"},
+	{Regex: `\b\d{3}-\d{2}-\d{4}\b`, Replacement: "[SSN]"},
+	{Regex: `[\w.+-]+@[\w-]+(?:\.[\w-]+)+`},
+}
+
+func maskStrategy() *scheme.Strategy {
+	s := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	s.PatternReg = regexp.MustCompile(`cost=(\d+)`)
+	s.Tags = map[string]string{"user": `user=(\S+)`, "card": `card=(\S+)`}
+	s.TagRegs = map[string]*regexp.Regexp{
+		"user": regexp.MustCompile(`user=(\S+)`),
+		"card": regexp.MustCompile(`card=(\S+)`),
+	}
+	s.MaskPatterns = append([]scheme.MaskPattern{}, testMaskPatterns...)
+	for i := range s.MaskPatterns {
+		s.MaskPatterns[i].Reg = regexp.MustCompile(s.MaskPatterns[i].Regex)
+	}
+	return s
+}
+
+func TestMaskLine(t *testing.T) {
+	s := maskStrategy()
+	cases := []struct {
+		line, want string
+	}{
+		{"card=4111 1111 1111 1234 ok", "card=****-****-****-1234 ok"},
+		{"card=4111-1111-1111-1234", "card=****-****-****-1234"},
+		{"ssn=123-45-6789 checked", "ssn=[SSN] checked"},
+		{"mail to alice.smith+tag@example.co.uk done", "mail to " + scheme.DefaultMaskReplacement + " done"},
+		{"order 1234 cost=12", "order 1234 cost=12"}, //没有命中时保持原样
+	}
+	for _, c := range cases {
+		if got := s.MaskLine(c.line); got != c.want {
+			t.Errorf("MaskLine(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+
+	// 未编译的规则跳过, 没有规则时原样返回
+	if got := (&scheme.Strategy{MaskPatterns: testMaskPatterns}).MaskLine("ssn=123-45-6789"); got != "ssn=123-45-6789" {
+		t.Errorf("uncompiled pattern applied: %q", got)
+	}
+}
+
+// 脱敏在匹配之前, tag中不应出现原始的敏感值
+func TestProducerMask(t *testing.T) {
+	s := maskStrategy()
+	w := &Worker{Mark: "[worker][mask]", Callback: func(int64, int64) {}}
+	line := "2018-01-01 12:00:01 user=bob@example.com card=4111-1111-1111-1234 ssn=123-45-6789 cost=12"
+	p, err := w.producer(line, s)
+	if err != nil || p == nil {
+		t.Fatalf("producer %+v %v", p, err)
+	}
+	if p.Value != 12 {
+		t.Errorf("value %v, want 12", p.Value)
+	}
+	if p.Tags["user"] != scheme.DefaultMaskReplacement || p.Tags["card"] != "****-****-****-1234" {
+		t.Errorf("tags not masked: %v", p.Tags)
+	}
+	for _, v := range p.Tags {
+		if strings.Contains(v, "bob@") || strings.Contains(v, "4111") {
+			t.Errorf("sensitive value in tags: %v", p.Tags)
+		}
+	}
+}
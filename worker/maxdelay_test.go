@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResetMaxDelay(t *testing.T) {
+	wg := &WorkerGroup{filePath: "/var/log/maxdelay.log", stableWindow: 5 * time.Minute, Workers: []*Worker{{Mark: "[worker][maxdelay]"}}}
+	wg.SetLatestTmsAndDelay(100, 30)
+	if wg.MaxDelay != 30 || wg.DelayChangedTms == 0 {
+		t.Fatalf("max delay should be recorded with its change time, got %d at %d", wg.MaxDelay, wg.DelayChangedTms)
+	}
+	wg.SetLatestTmsAndDelay(101, 10)
+	if wg.MaxDelay != 30 {
+		t.Fatalf("smaller delay should not replace max delay, got %d", wg.MaxDelay)
+	}
+
+	// 乱序刚发生, 推迟重置
+	changed := time.Unix(wg.DelayChangedTms, 0)
+	wg.resetMaxDelay(changed.Add(time.Minute))
+	if wg.MaxDelay != 30 || wg.ResetTms != 0 {
+		t.Fatalf("reset during disorder should be deferred, got %d reset at %d", wg.MaxDelay, wg.ResetTms)
+	}
+
+	// 稳定满一个窗口后重置, 之后一天内不再重置
+	now := changed.Add(5 * time.Minute)
+	wg.resetMaxDelay(now)
+	if wg.MaxDelay != 0 || wg.ResetTms != now.Unix() {
+		t.Fatalf("stable max delay should be reset, got %d reset at %d", wg.MaxDelay, wg.ResetTms)
+	}
+	wg.MaxDelay = 20
+	wg.resetMaxDelay(now.Add(time.Hour))
+	if wg.MaxDelay != 20 {
+		t.Fatalf("max delay should be reset at most once a day, got %d", wg.MaxDelay)
+	}
+
+	// 窗口为0时不等待
+	wg = &WorkerGroup{MaxDelay: 30, DelayChangedTms: now.Unix()}
+	wg.resetMaxDelay(now)
+	if wg.MaxDelay != 0 {
+		t.Fatalf("reset without stability window should not wait, got %d", wg.MaxDelay)
+	}
+}
+
+// worker回调更新时推送协程并发读取, 在-race下检查
+func TestGetLatestTmsAndDelayConcurrent(t *testing.T) {
+	wg := &WorkerGroup{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= 1000; i++ {
+			wg.SetLatestTmsAndDelay(i, i%50)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		wg.GetLatestTmsAndDelay()
+	}
+	<-done
+	if latest, delay := wg.GetLatestTmsAndDelay(); latest != 1000 || delay != 49 {
+		t.Fatalf("got latest %d delay %d, want 1000 49", latest, delay)
+	}
+}
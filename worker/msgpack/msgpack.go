@@ -0,0 +1,254 @@
+/*
+Package msgpack is a minimal MessagePack encoder of the types in push payloads.
+
+Only arrays, maps with string keys, strings, integers, float64 and nil are
+supported, each written in the smallest format of the specification:
+
+	int       positive/negative fixint, int8/16/32/64 or uint8/16/32/64
+	float64   float 64 (0xcb), NaN and Inf are kept
+	string    fixstr, str 8/16/32 (strings are always the str family, never bin)
+	array     fixarray, array 16/32 header followed by the elements
+	map       fixmap, map 16/32 header followed by key and value pairs
+
+Decode reads the same subset back, mainly for tests and debugging.
+*/
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrTruncated is returned when the data ends in the middle of a value
+var ErrTruncated = errors.New("msgpack: truncated data")
+
+// AppendNil to append nil
+func AppendNil(buf []byte) []byte {
+	return append(buf, 0xc0)
+}
+
+// AppendArrayHeader to append the header of an array of n elements
+func AppendArrayHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(buf, 0xdc), uint16(n))
+	default:
+		return appendUint32(append(buf, 0xdd), uint32(n))
+	}
+}
+
+// AppendMapHeader to append the header of a map of n key and value pairs
+func AppendMapHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(buf, 0xde), uint16(n))
+	default:
+		return appendUint32(append(buf, 0xdf), uint32(n))
+	}
+}
+
+// AppendString to append a string
+func AppendString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = appendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = appendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// AppendInt to append an integer
+func AppendInt(buf []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v < 128:
+		return append(buf, byte(v))
+	case v >= -32 && v < 0:
+		return append(buf, byte(v)) //negative fixint 0xe0-0xff
+	case v >= 0:
+		return appendUint(buf, uint64(v))
+	case v >= math.MinInt8:
+		return append(buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return appendUint16(append(buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(buf, 0xd2), uint32(v))
+	default:
+		return appendUint64(append(buf, 0xd3), uint64(v))
+	}
+}
+
+// AppendFloat64 to append a float64
+func AppendFloat64(buf []byte, v float64) []byte {
+	return appendUint64(append(buf, 0xcb), math.Float64bits(v))
+}
+
+func appendUint(buf []byte, v uint64) []byte {
+	switch {
+	case v <= math.MaxUint8:
+		return append(buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return appendUint16(append(buf, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(buf, 0xce), uint32(v))
+	default:
+		return appendUint64(append(buf, 0xcf), v)
+	}
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return append(buf, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Decode to decode one value of the supported subset
+// 整数都解码为int64(超出范围的uint64为uint64), 数组为[]interface{}, map为map[string]interface{}
+func Decode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint to read a big-endian unsigned integer of n bytes
+func (d *decoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *decoder) value() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		if err == nil && v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		v, err := d.uint(n)
+		// 按位数做符号扩展
+		shift := uint(64 - 8*n)
+		return int64(v<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+func (d *decoder) str(n int) (string, error) {
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *decoder) arrayOf(n int) ([]interface{}, error) {
+	// 长度来自数据, 不按它预分配
+	ret := make([]interface{}, 0)
+	for i := 0; i < n; i++ {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (d *decoder) mapOf(n int) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	for i := 0; i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key %v is not a string", k)
+		}
+		if ret[key], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,89 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendFormats(t *testing.T) {
+	cases := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"fixint", AppendInt(nil, 5), []byte{0x05}},
+		{"negative fixint", AppendInt(nil, -3), []byte{0xfd}},
+		{"uint8", AppendInt(nil, 200), []byte{0xcc, 0xc8}},
+		{"uint16", AppendInt(nil, 60000), []byte{0xcd, 0xea, 0x60}},
+		{"uint32", AppendInt(nil, 1514779200), []byte{0xce, 0x5a, 0x49, 0xb2, 0x40}},
+		{"int8", AppendInt(nil, -100), []byte{0xd0, 0x9c}},
+		{"int16", AppendInt(nil, -1000), []byte{0xd1, 0xfc, 0x18}},
+		{"float64", AppendFloat64(nil, 1.5), []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", AppendString(nil, "tags"), []byte{0xa4, 't', 'a', 'g', 's'}},
+		{"fixarray", AppendArrayHeader(nil, 3), []byte{0x93}},
+		{"array16", AppendArrayHeader(nil, 1000), []byte{0xdc, 0x03, 0xe8}},
+		{"fixmap", AppendMapHeader(nil, 7), []byte{0x87}},
+		{"nil", AppendNil(nil), []byte{0xc0}},
+	}
+	for _, c := range cases {
+		if !bytes.Equal(c.got, c.want) {
+			t.Errorf("%s: expect % x, got % x", c.name, c.want, c.got)
+		}
+	}
+	if s := AppendString(nil, strings.Repeat("a", 40)); s[0] != 0xd9 || s[1] != 40 || len(s) != 42 {
+		t.Errorf("str8 expected, got % x", s[:2])
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ints := []int64{0, 127, 128, -32, -33, 255, 256, -129, 65535, 65536, -32769, math.MaxInt32 + 1, math.MinInt32 - 1, math.MaxInt64, math.MinInt64}
+	buf := AppendArrayHeader(nil, len(ints)+4)
+	for _, v := range ints {
+		buf = AppendInt(buf, v)
+	}
+	long := strings.Repeat("x", 70000)
+	buf = AppendString(buf, long)
+	buf = AppendFloat64(buf, -0.25)
+	buf = AppendNil(buf)
+	buf = AppendMapHeader(buf, 1)
+	buf = AppendString(buf, "k")
+	buf = AppendString(buf, "v")
+
+	v, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]interface{}, 0)
+	for _, i := range ints {
+		want = append(want, i)
+	}
+	want = append(want, long, -0.25, nil, map[string]interface{}{"k": "v"})
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("round trip mismatch: %v", v)
+	}
+
+	nan, _ := Decode(AppendFloat64(nil, math.NaN()))
+	if f, ok := nan.(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("NaN should be kept, got %v", nan)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":       nil,
+		"truncated":   AppendString(nil, "hello")[:3],
+		"array":       AppendArrayHeader(nil, 2),
+		"trailing":    append(AppendInt(nil, 1), 0x01),
+		"unsupported": {0xc1},
+		"non-str key": append(AppendMapHeader(nil, 1), 0x01, 0x02),
+		"huge length": {0xdb, 0xff, 0xff, 0xff, 0xff},
+		"huge array":  {0xdd, 0xff, 0xff, 0xff, 0xff},
+	} {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
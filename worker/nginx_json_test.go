@@ -0,0 +1,26 @@
+package worker
+
+import "testing"
+
+// TestNginxJSONTimeLocal checks time_local is parsed with its own offset
+func TestNginxJSONTimeLocal(t *testing.T) {
+	st, lines, _ := LoadFixture(t, "nginx_json")
+	w := &Worker{Mark: "[worker][nginx_json]", Callback: func(int64, int64) {}}
+	for i, want := range map[int]int64{0: 1514779201, 3: 1514779204} {
+		p, err := w.producer(lines[i], st)
+		if err != nil || p == nil {
+			t.Fatalf("line %d: got %+v %v", i, p, err)
+		}
+		if p.LogTms != want {
+			t.Errorf("line %d: log time %d, want %d", i, p.LogTms, want)
+		}
+	}
+
+	// 解析失败的行报错, 缺少tag字段的行不产生点
+	if _, err := w.producer(lines[4], st); err == nil {
+		t.Error("truncated line should fail to parse")
+	}
+	if p, err := w.producer(lines[5], st); p != nil || err != nil {
+		t.Errorf("line without request_time should be skipped, got %+v %v", p, err)
+	}
+}
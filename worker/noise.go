@@ -0,0 +1,104 @@
+package worker
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// noiseAuditMax 最多保留的加噪记录数, 超过后覆盖最早的
+const noiseAuditMax = 10000
+
+// NoiseRecord is the exact and noised value of one series of one period, kept for internal audit
+type NoiseRecord struct {
+	StrategyID int64   `json:"strategy_id"`
+	Tms        int64   `json:"tms"`
+	Metric     string  `json:"metric"`
+	Tags       string  `json:"tags"`
+	Exact      float64 `json:"exact"`
+	Noised     float64 `json:"noised"`
+}
+
+var (
+	noiseAudit     = make([]NoiseRecord, 0, noiseAuditMax)
+	noiseAuditPos  int
+	noiseAuditLock sync.Mutex
+
+	noiseRand     = newNoiseRand()
+	noiseRandLock sync.Mutex
+)
+
+// newNoiseRand to create the generator of noise, seeded from crypto/rand
+// 种子不可预测, 外部无法由历史值推算噪声还原精确值
+func newNoiseRand() *rand.Rand {
+	var b [8]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		dlog.Fatalf("seed noise generator failed: %v", err)
+	}
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(b[:]))))
+}
+
+// noiseUniform to get a uniform value in [0, 1), replaced in test
+var noiseUniform = func() float64 {
+	noiseRandLock.Lock()
+	defer noiseRandLock.Unlock()
+	return noiseRand.Float64()
+}
+
+// laplaceNoise to sample from Laplace(0, scale) by inverse transform
+func laplaceNoise(scale float64) float64 {
+	u := noiseUniform() - 0.5
+	for u == -0.5 {
+		u = noiseUniform() - 0.5
+	}
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// noisedValue to add noise to the aggregated value of the point and record both for audit
+// 加噪后不做截断(如cnt不取整、不截为非负), 保持噪声无偏
+func noisedValue(st *scheme.Strategy, tms int64, p *FalconPoint) float64 {
+	n := st.Noise
+	v := p.Value + laplaceNoise(*n.Sensitivity/n.Epsilon)
+	recordNoise(NoiseRecord{
+		StrategyID: st.ID,
+		Tms:        tms,
+		Metric:     p.Metric,
+		Tags:       p.Tags,
+		Exact:      p.Value,
+		Noised:     v,
+	})
+	return v
+}
+
+func recordNoise(r NoiseRecord) {
+	noiseAuditLock.Lock()
+	defer noiseAuditLock.Unlock()
+	if len(noiseAudit) < noiseAuditMax {
+		noiseAudit = append(noiseAudit, r)
+		return
+	}
+	noiseAudit[noiseAuditPos] = r
+	noiseAuditPos = (noiseAuditPos + 1) % noiseAuditMax
+}
+
+// NoiseAudit to get the recent noise records of the strategy from oldest to newest, 0 means all
+func NoiseAudit(sid int64) []NoiseRecord {
+	noiseAuditLock.Lock()
+	defer noiseAuditLock.Unlock()
+	ret := make([]NoiseRecord, 0)
+	for i := range noiseAudit {
+		r := noiseAudit[(noiseAuditPos+i)%len(noiseAudit)]
+		if sid == 0 || r.StrategyID == sid {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// withOTLPSink to register s as the otlp sink without starting it, points stay in s.queue
+func withOTLPSink(t *testing.T, s *OTLPSink) {
+	withSink(t, "otlp", s.Send, s.External)
+}
+
+// withSink to register a sink for the test, the registered sinks are restored after
+func withSink(t *testing.T, name string, send func(*AnalysPoint) bool, external bool) {
+	r := getExtensions()
+	r.lock.Lock()
+	saved := r.exts
+	r.lock.Unlock()
+	if err := r.Register(&Extension{Name: name, Kind: ExtensionSink, Enabled: true, External: external, Send: send}, true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.lock.Lock()
+		r.exts = saved
+		r.lock.Unlock()
+	})
+}
+
+// resetNoiseAudit to start the test with an empty audit
+func resetNoiseAudit(t *testing.T) {
+	noiseAuditLock.Lock()
+	saved, savedPos := noiseAudit, noiseAuditPos
+	noiseAudit, noiseAuditPos = make([]NoiseRecord, 0, noiseAuditMax), 0
+	noiseAuditLock.Unlock()
+	t.Cleanup(func() {
+		noiseAuditLock.Lock()
+		noiseAudit, noiseAuditPos = saved, savedPos
+		noiseAuditLock.Unlock()
+	})
+}
+
+func drainOTLPQueue(s *OTLPSink) []*AnalysPoint {
+	var ret []*AnalysPoint
+	for {
+		select {
+		case p := <-s.queue:
+			ret = append(ret, p)
+		default:
+			return ret
+		}
+	}
+}
+
+func noiseStrategy(id int64) *scheme.Strategy {
+	sensitivity := 2.0
+	return &scheme.Strategy{ID: id, Name: "login_fail", Interval: 60, Func: "cnt",
+		Noise: &scheme.Noise{Mechanism: scheme.NoiseLaplace, Epsilon: 0.5, Sensitivity: &sensitivity}}
+}
+
+// flushNoise to flush one period with a single series of count n
+func flushNoise(st *scheme.Strategy, tms int64, n int64) {
+	pc := &PointsCounter{TagstringMap: map[string]*PointCounter{"region=eu": {Count: n}}}
+	pushStep(st, tms, pc, "host1")
+}
+
+func TestNoiseExactToInternalSinks(t *testing.T) {
+	drainPushQueue()
+	resetNoiseAudit(t)
+	s := NewOTLPSink("http://127.0.0.1:4317", "", 100)
+	withOTLPSink(t, s)
+	st := noiseStrategy(101)
+
+	// 内部的otlp sink收到精确值
+	flushNoise(st, 1500000000, 42)
+	if ps := drainOTLPQueue(s); len(ps) != 1 || ps[0].Value != 42 {
+		t.Errorf("internal otlp sink got %+v, want exact 42", ps)
+	}
+	if ps := drainPushQueue(); len(ps) != 1 || ps[0].Value != 42 {
+		t.Errorf("falcon push got %+v, want exact 42", ps)
+	}
+	if rs := NoiseAudit(st.ID); len(rs) != 0 {
+		t.Errorf("no external sink, but audit has %v", rs)
+	}
+
+	// 外部的sink收到加噪值, 推给falcon-agent的仍是精确值
+	s.External = true
+	withOTLPSink(t, s)
+	flushNoise(st, 1500000060, 42)
+	ext := drainOTLPQueue(s)
+	if len(ext) != 1 || ext[0].Value == 42 {
+		t.Errorf("external sink got %+v, want noised value", ext)
+	}
+	if ps := drainPushQueue(); len(ps) != 1 || ps[0].Value != 42 {
+		t.Errorf("falcon push got %+v, want exact 42", ps)
+	}
+
+	// 没有配置noise的策略不加噪
+	plain := &scheme.Strategy{ID: 102, Name: "plain", Interval: 60, Func: "cnt"}
+	flushNoise(plain, 1500000060, 42)
+	if ps := drainOTLPQueue(s); len(ps) != 1 || ps[0].Value != 42 {
+		t.Errorf("strategy without noise got %+v, want exact 42", ps)
+	}
+	drainPushQueue()
+
+	rs := NoiseAudit(st.ID)
+	if len(rs) != 1 {
+		t.Fatalf("audit %v, want 1 record", rs)
+	}
+	r := rs[0]
+	if r.Tms != 1500000060 || r.Metric != "log.login_fail" || r.Tags != "region=eu" || r.Exact != 42 || r.Noised != ext[0].Value {
+		t.Errorf("audit record %+v, want exact 42 and noised %v", r, ext[0].Value)
+	}
+}
+
+// 多次推送的加噪值应服从Laplace(exact, sensitivity/epsilon), 用Kolmogorov-Smirnov检验
+func TestNoiseDistribution(t *testing.T) {
+	drainPushQueue()
+	resetNoiseAudit(t)
+	const flushes = 4000
+	s := NewOTLPSink("http://127.0.0.1:4317", "", flushes)
+	s.External = true
+	withOTLPSink(t, s)
+	st := noiseStrategy(103)
+	scale := *st.Noise.Sensitivity / st.Noise.Epsilon
+
+	values := make([]float64, 0, flushes)
+	for i := 0; i < flushes; i++ {
+		flushNoise(st, 1500000000+int64(i)*60, 100)
+		for _, p := range drainOTLPQueue(s) {
+			values = append(values, p.Value)
+		}
+		drainPushQueue()
+	}
+	if len(values) != flushes {
+		t.Fatalf("got %d values, want %d", len(values), flushes)
+	}
+
+	sort.Float64s(values)
+	cdf := func(x float64) float64 {
+		if x < 100 {
+			return 0.5 * math.Exp((x-100)/scale)
+		}
+		return 1 - 0.5*math.Exp(-(x-100)/scale)
+	}
+	d := 0.0
+	for i, v := range values {
+		f := cdf(v)
+		d = math.Max(d, math.Max(float64(i+1)/flushes-f, f-float64(i)/flushes))
+	}
+	// 显著性水平0.001的临界值
+	if crit := 1.95 / math.Sqrt(flushes); d > crit {
+		t.Errorf("KS statistic %.4f over %.4f, noise is not Laplace(100, %v)", d, crit, scale)
+	}
+
+	var sum, abs float64
+	for _, v := range values {
+		sum += v - 100
+		abs += math.Abs(v - 100)
+	}
+	if mean := sum / flushes; math.Abs(mean) > 5*math.Sqrt2*scale/math.Sqrt(flushes) {
+		t.Errorf("noise mean %.3f, should be unbiased", mean)
+	}
+	// Laplace的平均绝对偏差等于scale
+	if mad := abs / flushes; math.Abs(mad-scale)/scale > 0.1 {
+		t.Errorf("mean absolute noise %.3f, want about %v", mad, scale)
+	}
+	if rs := NoiseAudit(st.ID); len(rs) != flushes {
+		t.Errorf("audit has %d records, want %d", len(rs), flushes)
+	}
+}
+
+func TestNoiseAuditRing(t *testing.T) {
+	resetNoiseAudit(t)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < noiseAuditMax/2; i++ {
+				recordNoise(NoiseRecord{StrategyID: int64(w + 1), Tms: int64(i)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n := len(NoiseAudit(0)); n != noiseAuditMax {
+		t.Errorf("audit has %d records, want at most %d", n, noiseAuditMax)
+	}
+
+	recordNoise(NoiseRecord{StrategyID: 9, Tms: 1})
+	rs := NoiseAudit(0)
+	if last := rs[len(rs)-1]; last.StrategyID != 9 {
+		t.Errorf("newest record %+v, want sid 9 last", last)
+	}
+}
@@ -0,0 +1,302 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker/otlpmetric"
+)
+
+// 导出到OTLP collector的协议
+const (
+	OTLPProtocolGRPC = "grpc"          //默认, 端口一般为4317
+	OTLPProtocolHTTP = "http/protobuf" //POST /v1/metrics, 端口一般为4318
+)
+
+const (
+	otlpGRPCPath        = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	otlpHTTPPath        = "/v1/metrics"
+	defaultOTLPPrefix   = "log."
+	defaultOTLPBatchMax = 1000
+)
+
+// OTLPSink to export aggregated points to an OpenTelemetry collector
+// cnt/sum/episodes为delta的单调Sum, avg/max/min为Gauge; tag为attribute, endpoint为resource的host.name
+// 失败时与PointStreamSink一样指数退避重试, 重试期间队列满了的点丢弃并计入log.agent.sink.drop.cnt
+type OTLPSink struct {
+	batchSink
+	Endpoint string //如http://127.0.0.1:4317, https时使用TLS
+	Protocol string //OTLPProtocolGRPC或OTLPProtocolHTTP
+	Headers  map[string]string
+	TLS      *tls.Config
+	Timeout  time.Duration
+	Prefix   string //指标名前缀, 默认与推送falcon的一致, 为log.
+	Host     string //resource的host.name
+	External bool   //发往外部, 配置了noise的策略发送加噪后的值
+
+	lookup func(id int64) (*scheme.Strategy, error)
+	client *http.Client
+}
+
+// NewOTLPSink to create an OTLP sink
+func NewOTLPSink(endpoint, protocol string, queueSize int) *OTLPSink {
+	if protocol == "" {
+		protocol = OTLPProtocolGRPC
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	return &OTLPSink{
+		batchSink: newBatchSink("otlp", endpoint, defaultOTLPBatchMax, queueSize),
+		Endpoint:  endpoint,
+		Protocol:  protocol,
+		Timeout:   10 * time.Second,
+		Prefix:    defaultOTLPPrefix,
+		lookup:    strategy.GetByID,
+	}
+}
+
+// Start to export points until stopped
+func (s *OTLPSink) Start() {
+	if s.client == nil {
+		s.client = s.newClient()
+	}
+	s.run(s.encode, s.write)
+}
+
+// encode to encode points as one export request
+func (s *OTLPSink) encode(points []*AnalysPoint) []sinkPayload {
+	req, n := s.request(points)
+	if n == 0 {
+		return nil
+	}
+	return []sinkPayload{{body: otlpmetric.Encode(req), n: n}}
+}
+
+// request to map points to the export request, return the number of data points
+// 策略已删除的点丢弃
+func (s *OTLPSink) request(points []*AnalysPoint) (*otlpmetric.Request, int) {
+	req := &otlpmetric.Request{
+		Resource:     map[string]string{"host.name": s.Host, "service.name": "falcon-log-agent"},
+		ScopeName:    "falcon-log-agent",
+		ScopeVersion: g.AgentVersion,
+	}
+	index := make(map[int64]int) //策略ID -> req.Metrics的下标
+	n := 0
+	for _, p := range points {
+		st, err := s.lookup(p.StrategyID)
+		if err != nil {
+			metric.MetricSinkDropPoint(1)
+			continue
+		}
+		i, ok := index[st.ID]
+		if !ok {
+			m := otlpmetric.Metric{Name: s.Prefix + st.Name, Kind: otlpmetric.KindGauge}
+			switch st.Func {
+			case "cnt", "sum", scheme.FuncEpisodes:
+				m.Kind = otlpmetric.KindSum
+				m.Temporality = otlpmetric.TemporalityDelta
+				m.Monotonic = true
+			}
+			i = len(req.Metrics)
+			index[st.ID] = i
+			req.Metrics = append(req.Metrics, m)
+		}
+		m := &req.Metrics[i]
+		// 时间取周期结束, delta的Sum带周期开始
+		dp := otlpmetric.DataPoint{
+			Attributes: p.Tags,
+			TimeNano:   uint64(p.Tms+st.Interval) * uint64(time.Second),
+			AsDouble:   p.Value,
+		}
+		if dp.Attributes == nil {
+			dp.Attributes = map[string]string{}
+		}
+		if m.Kind == otlpmetric.KindSum {
+			dp.StartNano = uint64(p.Tms) * uint64(time.Second)
+		}
+		if st.Func == "cnt" || st.Func == scheme.FuncEpisodes {
+			dp.IsInt, dp.AsInt = true, int64(p.Value)
+		}
+		m.Points = append(m.Points, dp)
+		n++
+	}
+	return req, n
+}
+
+// newClient to create the http client of the protocol
+// grpc只走http2, 明文时直接建立h2c连接
+func (s *OTLPSink) newClient() *http.Client {
+	tr := &http.Transport{TLSClientConfig: s.TLS}
+	if s.Protocol == OTLPProtocolGRPC {
+		protocols := new(http.Protocols)
+		if strings.HasPrefix(s.Endpoint, "https://") {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		tr.Protocols = protocols
+	}
+	return &http.Client{Timeout: s.Timeout, Transport: tr}
+}
+
+// write to send the encoded request once
+func (s *OTLPSink) write(p sinkPayload) error {
+	body := p.body
+	url := s.Endpoint + otlpHTTPPath
+	contentType := "application/x-protobuf"
+	if s.Protocol == OTLPProtocolGRPC {
+		url = s.Endpoint + otlpGRPCPath
+		contentType = "application/grpc"
+		// gRPC消息前缀: 1字节压缩标志 + 4字节大端长度
+		framed := make([]byte, 5, 5+len(body))
+		binary.BigEndian.PutUint32(framed[1:], uint32(len(body)))
+		body = append(framed, body...)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return &sinkWriteError{err: err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.Protocol == OTLPProtocolGRPC {
+		req.Header.Set("TE", "trailers")
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &sinkWriteError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &sinkWriteError{err: err, retryable: true}
+	}
+
+	if s.Protocol == OTLPProtocolGRPC {
+		if err := grpcStatus(resp); err != nil {
+			return err
+		}
+		if len(respBody) >= 5 {
+			respBody = respBody[5:]
+		}
+	} else if resp.StatusCode != http.StatusOK {
+		// 按OTLP规范, 只有429/502/503/504可以重试
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return &sinkWriteError{err: fmt.Errorf("http status %d", resp.StatusCode), retryable: true}
+		}
+		return &sinkWriteError{err: fmt.Errorf("http status %d: %s", resp.StatusCode, respBody)}
+	}
+
+	// 部分成功时被拒绝的点不重试
+	rejected := int64(0)
+	if ps, err := otlpmetric.DecodeResponse(respBody); err == nil && ps.RejectedDataPoints > 0 {
+		rejected = ps.RejectedDataPoints
+		dlog.Warningf("otlp export partially rejected [endpoint:%s][rejected:%d][msg:%s]", s.Endpoint, rejected, ps.ErrorMessage)
+		metric.MetricSinkError("otlp", rejected)
+	}
+	metric.MetricSinkSent("otlp", int64(p.n)-rejected)
+	return nil
+}
+
+// grpcStatus to get the error from grpc-status, in trailers or in headers for trailers-only responses
+func grpcStatus(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return &sinkWriteError{err: fmt.Errorf("http status %d", resp.StatusCode), retryable: true}
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		msg = resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &sinkWriteError{err: fmt.Errorf("bad grpc-status %q", status), retryable: true}
+	}
+	if code == 0 {
+		return nil
+	}
+	// 按OTLP规范可以重试的状态码
+	retryable := false
+	switch code {
+	case 1, 4, 8, 10, 11, 14, 15: //CANCELLED DEADLINE_EXCEEDED RESOURCE_EXHAUSTED ABORTED OUT_OF_RANGE UNAVAILABLE DATA_LOSS
+		retryable = true
+	}
+	return &sinkWriteError{err: fmt.Errorf("grpc status %d: %s", code, msg), retryable: retryable}
+}
+
+// loadOTLPTLS to build the tls config of an https endpoint
+func loadOTLPTLS(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		bs, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.RootCAs == nil && cfg.Certificates == nil && !insecure {
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+// otlpSinkExtension to build the OTLP sink of the config, disabled if not configured
+func otlpSinkExtension(c *g.Config) *Extension {
+	oc := c.Sink.OTLP
+	e := &Extension{Name: "otlp", Kind: ExtensionSink, External: oc.External, Version: configVersion(oc, c.Endpoint)}
+	if oc.Endpoint == "" {
+		return e
+	}
+	tlsCfg, err := loadOTLPTLS(oc.CAFile, oc.CertFile, oc.KeyFile, oc.Insecure)
+	if err != nil {
+		dlog.Errorf("otlp sink disabled, load tls failed [endpoint:%s][err:%v]", oc.Endpoint, err)
+		return e
+	}
+	s := NewOTLPSink(oc.Endpoint, oc.Protocol, oc.QueueSize)
+	s.Headers = oc.Headers
+	s.TLS = tlsCfg
+	s.Host = c.Endpoint
+	s.External = oc.External
+	if oc.BatchSize > 0 {
+		s.BatchSize = oc.BatchSize
+	}
+	if oc.BatchWaitMs > 0 {
+		s.BatchWait = time.Duration(oc.BatchWaitMs) * time.Millisecond
+	}
+	if oc.TimeoutMs > 0 {
+		s.Timeout = time.Duration(oc.TimeoutMs) * time.Millisecond
+	}
+	if oc.MetricPrefix != "" {
+		s.Prefix = oc.MetricPrefix
+	}
+	e.Enabled = true
+	e.Send, e.Start, e.Stop = s.Send, func() { go s.Start() }, s.Stop
+	return e
+}
@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/worker/otlpmetric"
+)
+
+// otlpCollector is an in-process OTLP collector recording export requests
+type otlpCollector struct {
+	sync.Mutex
+	grpc     bool
+	requests []*otlpmetric.Request
+	headers  []http.Header
+	fail     []int //依次返回的失败状态码, http为状态码, grpc为grpc-status
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	c.Lock()
+	var fail int
+	if len(c.fail) > 0 {
+		fail, c.fail = c.fail[0], c.fail[1:]
+	}
+	c.Unlock()
+
+	if c.grpc {
+		if r.URL.Path != otlpGRPCPath || r.Header.Get("Content-Type") != "application/grpc" || len(body) < 5 ||
+			int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+		body = body[5:]
+	} else if r.URL.Path != otlpHTTPPath || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if fail != 0 {
+		if c.grpc {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Grpc-Status", fmt.Sprint(fail))
+			w.Header().Set("Grpc-Message", "collector unavailable")
+			return
+		}
+		w.WriteHeader(fail)
+		return
+	}
+
+	req, err := otlpmetric.Decode(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.Lock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	c.Unlock()
+
+	if c.grpc {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write(make([]byte, 5)) //空的ExportMetricsServiceResponse
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// points to get data points received of the metric, in arrival order
+func (c *otlpCollector) points(name string) (*otlpmetric.Metric, []otlpmetric.DataPoint) {
+	c.Lock()
+	defer c.Unlock()
+	var metric *otlpmetric.Metric
+	var ret []otlpmetric.DataPoint
+	for _, req := range c.requests {
+		for i := range req.Metrics {
+			if req.Metrics[i].Name == name {
+				metric = &req.Metrics[i]
+				ret = append(ret, req.Metrics[i].Points...)
+			}
+		}
+	}
+	return metric, ret
+}
+
+func (c *otlpCollector) waitPoints(t *testing.T, name string, n int) (*otlpmetric.Metric, []otlpmetric.DataPoint) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m, ps := c.points(name)
+		if len(ps) >= n {
+			return m, ps
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got %d points, want %d", name, len(ps), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var otlpTestStrategies = map[int64]*scheme.Strategy{
+	1: {ID: 1, Name: "err.cnt", Func: "cnt", Interval: 10},
+	2: {ID: 2, Name: "bytes.sum", Func: "sum", Interval: 10},
+	3: {ID: 3, Name: "latency.avg", Func: "avg", Interval: 10},
+	4: {ID: 4, Name: "latency.max", Func: "max", Interval: 10},
+	5: {ID: 5, Name: "latency.min", Func: "min", Interval: 10},
+	6: {ID: 6, Name: "burst", Func: scheme.FuncEpisodes, Interval: 60},
+}
+
+func newTestOTLPSink(endpoint, protocol string) *OTLPSink {
+	s := NewOTLPSink(endpoint, protocol, 100)
+	s.BatchWait = 10 * time.Millisecond
+	s.Host = "host-01"
+	s.Prefix = "otel."
+	s.Headers = map[string]string{"X-Api-Key": "secret"}
+	s.lookup = func(id int64) (*scheme.Strategy, error) {
+		if st, ok := otlpTestStrategies[id]; ok {
+			return st, nil
+		}
+		return nil, fmt.Errorf("no strategy %d", id)
+	}
+	return s
+}
+
+func TestOTLPSinkExport(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		start func(c *otlpCollector) (*httptest.Server, *OTLPSink)
+	}{
+		{"http", func(c *otlpCollector) (*httptest.Server, *OTLPSink) {
+			srv := httptest.NewServer(c)
+			return srv, newTestOTLPSink(srv.URL, OTLPProtocolHTTP)
+		}},
+		{"grpc-h2c", func(c *otlpCollector) (*httptest.Server, *OTLPSink) {
+			c.grpc = true
+			srv := httptest.NewUnstartedServer(c)
+			srv.Config.Protocols = new(http.Protocols)
+			srv.Config.Protocols.SetUnencryptedHTTP2(true)
+			srv.Start()
+			return srv, newTestOTLPSink(srv.URL, OTLPProtocolGRPC)
+		}},
+		{"grpc-tls", func(c *otlpCollector) (*httptest.Server, *OTLPSink) {
+			c.grpc = true
+			srv := httptest.NewUnstartedServer(c)
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+			s := newTestOTLPSink(srv.URL, OTLPProtocolGRPC)
+			pool := x509.NewCertPool()
+			pool.AddCert(srv.Certificate())
+			s.TLS = &tls.Config{RootCAs: pool}
+			return srv, s
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &otlpCollector{}
+			srv, s := tc.start(c)
+			defer srv.Close()
+			go s.Start()
+			defer s.Stop()
+
+			tags := map[string]string{"code": "500"}
+			for _, p := range []*AnalysPoint{
+				{StrategyID: 1, Tms: 1500000000, Value: 3, Tags: tags},
+				{StrategyID: 2, Tms: 1500000000, Value: 1024.5, Tags: map[string]string{}},
+				{StrategyID: 3, Tms: 1500000000, Value: 12.25, Tags: tags},
+				{StrategyID: 4, Tms: 1500000000, Value: 99, Tags: tags},
+				{StrategyID: 5, Tms: 1500000000, Value: 0, Tags: tags},
+				{StrategyID: 6, Tms: 1500000000, Value: 2, Tags: nil},
+				{StrategyID: 404, Tms: 1500000000, Value: 1}, //策略已删除, 丢弃
+			} {
+				s.Send(p)
+			}
+			// 第二个周期单独一个batch
+			time.Sleep(100 * time.Millisecond)
+			s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000010, Value: 5, Tags: tags})
+
+			// 计数器是delta: 每个点只包含本周期的增量, 周期首尾相接
+			m, ps := c.waitPoints(t, "otel.err.cnt", 2)
+			if m.Kind != otlpmetric.KindSum || !m.Monotonic || m.Temporality != otlpmetric.TemporalityDelta {
+				t.Fatalf("cnt metric = %+v", m)
+			}
+			if !ps[0].IsInt || ps[0].AsInt != 3 || ps[1].AsInt != 5 {
+				t.Fatalf("cnt points = %+v", ps)
+			}
+			if ps[0].StartNano != 1500000000e9 || ps[0].TimeNano != 1500000010e9 ||
+				ps[1].StartNano != ps[0].TimeNano || ps[1].TimeNano != 1500000020e9 {
+				t.Fatalf("cnt periods = %+v", ps)
+			}
+			if ps[0].Attributes["code"] != "500" {
+				t.Fatalf("attributes = %v", ps[0].Attributes)
+			}
+
+			m, ps = c.waitPoints(t, "otel.bytes.sum", 1)
+			if m.Kind != otlpmetric.KindSum || !m.Monotonic || ps[0].IsInt || ps[0].AsDouble != 1024.5 || ps[0].StartNano == 0 {
+				t.Fatalf("sum = %+v %+v", m, ps)
+			}
+			for name, want := range map[string]float64{"otel.latency.avg": 12.25, "otel.latency.max": 99, "otel.latency.min": 0} {
+				m, ps = c.waitPoints(t, name, 1)
+				if m.Kind != otlpmetric.KindGauge || ps[0].IsInt || ps[0].AsDouble != want ||
+					ps[0].StartNano != 0 || ps[0].TimeNano != 1500000010e9 {
+					t.Fatalf("%s = %+v %+v", name, m, ps)
+				}
+			}
+			m, ps = c.waitPoints(t, "otel.burst", 1)
+			if m.Kind != otlpmetric.KindSum || ps[0].AsInt != 2 || ps[0].TimeNano != 1500000060e9 {
+				t.Fatalf("episodes = %+v %+v", m, ps)
+			}
+
+			c.Lock()
+			defer c.Unlock()
+			if res := c.requests[0].Resource; res["host.name"] != "host-01" || res["service.name"] != "falcon-log-agent" {
+				t.Fatalf("resource = %v", res)
+			}
+			if c.headers[0].Get("X-Api-Key") != "secret" {
+				t.Fatalf("headers = %v", c.headers[0])
+			}
+		})
+	}
+}
+
+func TestOTLPSinkRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		grpc      bool
+		retryable int
+		rejected  int
+	}{
+		{"http", false, http.StatusServiceUnavailable, http.StatusBadRequest},
+		{"grpc", true, 14, 3}, //UNAVAILABLE可以重试, INVALID_ARGUMENT不重试
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &otlpCollector{grpc: tc.grpc, fail: []int{tc.retryable, tc.retryable}}
+			srv := httptest.NewUnstartedServer(c)
+			srv.Config.Protocols = new(http.Protocols)
+			srv.Config.Protocols.SetHTTP1(true)
+			srv.Config.Protocols.SetUnencryptedHTTP2(true)
+			srv.Start()
+			defer srv.Close()
+			protocol := OTLPProtocolHTTP
+			if tc.grpc {
+				protocol = OTLPProtocolGRPC
+			}
+			s := newTestOTLPSink(srv.URL, protocol)
+			go s.Start()
+			defer s.Stop()
+
+			// 失败两次后重试成功, 点只送达一次
+			s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000000, Value: 7})
+			_, ps := c.waitPoints(t, "otel.err.cnt", 1)
+			if ps[0].AsInt != 7 {
+				t.Fatalf("points = %+v", ps)
+			}
+
+			// 不可重试的错误丢弃该batch, 不影响之后的点
+			c.Lock()
+			c.fail = []int{tc.rejected}
+			c.Unlock()
+			s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000010, Value: 8})
+			time.Sleep(100 * time.Millisecond)
+			s.Send(&AnalysPoint{StrategyID: 1, Tms: 1500000020, Value: 9})
+			_, ps = c.waitPoints(t, "otel.err.cnt", 2)
+			time.Sleep(50 * time.Millisecond)
+			if _, ps = c.points("otel.err.cnt"); len(ps) != 2 || ps[1].AsInt != 9 {
+				t.Fatalf("points = %+v", ps)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+/*
+Package otlpmetric is a minimal protobuf encoding of the OTLP metrics export request.
+
+Only the messages needed to export gauges and sums of float or integer
+data points are covered, field numbers follow opentelemetry-proto v1:
+
+	ExportMetricsServiceRequest  1:resource_metrics
+	ResourceMetrics              1:resource 2:scope_metrics
+	Resource                     1:attributes
+	ScopeMetrics                 1:scope 2:metrics
+	InstrumentationScope         1:name 2:version
+	Metric                       1:name 5:gauge 7:sum
+	Gauge                        1:data_points
+	Sum                          1:data_points 2:aggregation_temporality 3:is_monotonic
+	NumberDataPoint              7:attributes 2:start_time_unix_nano 3:time_unix_nano 4:as_double 6:as_int
+	KeyValue                     1:key 2:value
+	AnyValue                     1:string_value
+	ExportMetricsServiceResponse 1:partial_success
+	ExportMetricsPartialSuccess  1:rejected_data_points 2:error_message
+
+Decode is the reverse of Encode, used by tests and debugging collectors.
+*/
+package otlpmetric
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrTruncated is returned when the data ends in the middle of a field
+var ErrTruncated = errors.New("otlpmetric: truncated message")
+
+// Kind of a metric
+const (
+	KindGauge = "gauge"
+	KindSum   = "sum"
+)
+
+// TemporalityDelta 每个点只包含[start, time)内的增量
+const TemporalityDelta = 1
+
+// DataPoint is a NumberDataPoint
+type DataPoint struct {
+	Attributes map[string]string
+	StartNano  uint64 //gauge为0
+	TimeNano   uint64
+	IsInt      bool //true时值为AsInt, 否则为AsDouble
+	AsInt      int64
+	AsDouble   float64
+}
+
+// Metric is a gauge or a sum
+type Metric struct {
+	Name        string
+	Kind        string
+	Temporality int  //sum的聚合方式
+	Monotonic   bool //sum是否单调
+	Points      []DataPoint
+}
+
+// Request is an ExportMetricsServiceRequest of one resource and one scope
+type Request struct {
+	Resource     map[string]string
+	ScopeName    string
+	ScopeVersion string
+	Metrics      []Metric
+}
+
+// Response is the partial success of an ExportMetricsServiceResponse
+type Response struct {
+	RejectedDataPoints int64
+	ErrorMessage       string
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(buf []byte, num, typ int) []byte {
+	return binary.AppendUvarint(buf, uint64(num<<3|typ))
+}
+
+func appendBytes(buf []byte, num int, bs []byte) []byte {
+	buf = appendTag(buf, num, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(bs)))
+	return append(buf, bs...)
+}
+
+func appendString(buf []byte, num int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return appendBytes(buf, num, []byte(s))
+}
+
+func appendFixed64(buf []byte, num int, v uint64) []byte {
+	buf = appendTag(buf, num, wireFixed64)
+	return binary.LittleEndian.AppendUint64(buf, v)
+}
+
+func appendVarint(buf []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = appendTag(buf, num, wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendAttributes to append KeyValues sorted by key
+func appendAttributes(buf []byte, num int, attrs map[string]string) []byte {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// AnyValue是oneof, 空字符串也要写出, 否则对端认为没有值
+		value := appendBytes(nil, 1, []byte(attrs[k]))
+		kv := appendString(nil, 1, k)
+		kv = appendBytes(kv, 2, value)
+		buf = appendBytes(buf, num, kv)
+	}
+	return buf
+}
+
+func encodePoint(p *DataPoint) []byte {
+	var buf []byte
+	if p.StartNano != 0 {
+		buf = appendFixed64(buf, 2, p.StartNano)
+	}
+	buf = appendFixed64(buf, 3, p.TimeNano)
+	// as_double/as_int是oneof, 0也要写出
+	if p.IsInt {
+		buf = appendFixed64(buf, 6, uint64(p.AsInt))
+	} else {
+		buf = appendFixed64(buf, 4, math.Float64bits(p.AsDouble))
+	}
+	return appendAttributes(buf, 7, p.Attributes)
+}
+
+func encodeMetric(m *Metric) []byte {
+	var data []byte
+	for i := range m.Points {
+		data = appendBytes(data, 1, encodePoint(&m.Points[i]))
+	}
+	buf := appendString(nil, 1, m.Name)
+	if m.Kind == KindSum {
+		data = appendVarint(data, 2, uint64(m.Temporality))
+		if m.Monotonic {
+			data = appendVarint(data, 3, 1)
+		}
+		return appendBytes(buf, 7, data)
+	}
+	return appendBytes(buf, 5, data)
+}
+
+// Encode to encode the request
+func Encode(r *Request) []byte {
+	resource := appendAttributes(nil, 1, r.Resource)
+
+	scope := appendString(nil, 1, r.ScopeName)
+	scope = appendString(scope, 2, r.ScopeVersion)
+	sm := appendBytes(nil, 1, scope)
+	for i := range r.Metrics {
+		sm = appendBytes(sm, 2, encodeMetric(&r.Metrics[i]))
+	}
+
+	rm := appendBytes(nil, 1, resource)
+	rm = appendBytes(rm, 2, sm)
+	return appendBytes(nil, 1, rm)
+}
+
+// field is one decoded field, val for varint/fixed64, bs for bytes
+type field struct {
+	num int
+	typ int
+	val uint64
+	bs  []byte
+}
+
+// walk to call fn on every field of the message
+func walk(buf []byte, fn func(f field) error) error {
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		buf = buf[n:]
+		f := field{num: int(tag >> 3), typ: int(tag & 7)}
+		switch f.typ {
+		case wireVarint:
+			v, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return ErrTruncated
+			}
+			f.val, buf = v, buf[n:]
+		case wireFixed64:
+			if len(buf) < 8 {
+				return ErrTruncated
+			}
+			f.val, buf = binary.LittleEndian.Uint64(buf), buf[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return ErrTruncated
+			}
+			f.bs, buf = buf[n:n+int(l)], buf[n+int(l):]
+		case 5: //fixed32
+			if len(buf) < 4 {
+				return ErrTruncated
+			}
+			f.val, buf = uint64(binary.LittleEndian.Uint32(buf)), buf[4:]
+		default:
+			return fmt.Errorf("otlpmetric: unsupported wire type %d", f.typ)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeAttribute(buf []byte, attrs map[string]string) error {
+	var key, value string
+	err := walk(buf, func(f field) error {
+		switch f.num {
+		case 1:
+			key = string(f.bs)
+		case 2:
+			return walk(f.bs, func(v field) error {
+				if v.num == 1 {
+					value = string(v.bs)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	attrs[key] = value
+	return err
+}
+
+func decodePoint(buf []byte) (DataPoint, error) {
+	p := DataPoint{Attributes: map[string]string{}}
+	err := walk(buf, func(f field) error {
+		switch f.num {
+		case 2:
+			p.StartNano = f.val
+		case 3:
+			p.TimeNano = f.val
+		case 4:
+			p.AsDouble = math.Float64frombits(f.val)
+		case 6:
+			p.IsInt, p.AsInt = true, int64(f.val)
+		case 7:
+			return decodeAttribute(f.bs, p.Attributes)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func decodeMetric(buf []byte) (Metric, error) {
+	var m Metric
+	err := walk(buf, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Name = string(f.bs)
+		case 5, 7:
+			m.Kind = KindGauge
+			if f.num == 7 {
+				m.Kind = KindSum
+			}
+			return walk(f.bs, func(d field) error {
+				switch d.num {
+				case 1:
+					p, err := decodePoint(d.bs)
+					m.Points = append(m.Points, p)
+					return err
+				case 2:
+					m.Temporality = int(d.val)
+				case 3:
+					m.Monotonic = d.val != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return m, err
+}
+
+// Decode to decode a request, all resources and scopes are merged into one
+func Decode(buf []byte) (*Request, error) {
+	r := &Request{Resource: map[string]string{}}
+	err := walk(buf, func(f field) error {
+		if f.num != 1 {
+			return nil
+		}
+		return walk(f.bs, func(rm field) error {
+			switch rm.num {
+			case 1:
+				return walk(rm.bs, func(a field) error {
+					if a.num == 1 {
+						return decodeAttribute(a.bs, r.Resource)
+					}
+					return nil
+				})
+			case 2:
+				return walk(rm.bs, func(sm field) error {
+					switch sm.num {
+					case 1:
+						return walk(sm.bs, func(s field) error {
+							switch s.num {
+							case 1:
+								r.ScopeName = string(s.bs)
+							case 2:
+								r.ScopeVersion = string(s.bs)
+							}
+							return nil
+						})
+					case 2:
+						m, err := decodeMetric(sm.bs)
+						r.Metrics = append(r.Metrics, m)
+						return err
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	return r, err
+}
+
+// EncodeResponse to encode the response, empty when nothing is rejected
+func EncodeResponse(r *Response) []byte {
+	if r.RejectedDataPoints == 0 && r.ErrorMessage == "" {
+		return nil
+	}
+	ps := appendVarint(nil, 1, uint64(r.RejectedDataPoints))
+	ps = appendString(ps, 2, r.ErrorMessage)
+	return appendBytes(nil, 1, ps)
+}
+
+// DecodeResponse to decode the partial success of a response
+func DecodeResponse(buf []byte) (*Response, error) {
+	r := &Response{}
+	err := walk(buf, func(f field) error {
+		if f.num != 1 {
+			return nil
+		}
+		return walk(f.bs, func(ps field) error {
+			switch ps.num {
+			case 1:
+				r.RejectedDataPoints = int64(ps.val)
+			case 2:
+				r.ErrorMessage = string(ps.bs)
+			}
+			return nil
+		})
+	})
+	return r, err
+}
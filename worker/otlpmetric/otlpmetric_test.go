@@ -0,0 +1,161 @@
+package otlpmetric
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func testRequest() *Request {
+	return &Request{
+		Resource:     map[string]string{"host.name": "host-01", "service.name": "falcon-log-agent"},
+		ScopeName:    "falcon-log-agent",
+		ScopeVersion: "1.0",
+		Metrics: []Metric{
+			{Name: "log.err.cnt", Kind: KindSum, Temporality: TemporalityDelta, Monotonic: true, Points: []DataPoint{
+				{Attributes: map[string]string{"code": "500", "empty": ""}, StartNano: 1500000000e9, TimeNano: 1500000010e9, IsInt: true, AsInt: 0},
+				{Attributes: map[string]string{}, StartNano: 1500000010e9, TimeNano: 1500000020e9, IsInt: true, AsInt: 42},
+			}},
+			{Name: "log.latency.avg", Kind: KindGauge, Points: []DataPoint{
+				{Attributes: map[string]string{"api": "/get"}, TimeNano: 1500000010e9, AsDouble: -0.5},
+				{Attributes: map[string]string{}, TimeNano: 1500000010e9, AsDouble: math.Inf(1)},
+			}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testRequest()
+	got, err := Decode(Encode(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, want)
+	}
+
+	// 任意位置截断都不能panic, 截断在字段边界上时是合法的更短消息
+	bs := Encode(want)
+	for i := 1; i < len(bs); i++ {
+		Decode(bs[:i])
+	}
+
+	resp := &Response{RejectedDataPoints: 3, ErrorMessage: "bad attribute"}
+	gotResp, err := DecodeResponse(EncodeResponse(resp))
+	if err != nil || *gotResp != *resp {
+		t.Fatalf("response = %+v, err %v", gotResp, err)
+	}
+	if EncodeResponse(&Response{}) != nil {
+		t.Fatal("empty response should encode to nothing")
+	}
+}
+
+// 以下按opentelemetry-proto的字段号定义, 用golang/protobuf解码, 校验编码与标准实现兼容
+type pbAnyValue struct {
+	StringValue string `protobuf:"bytes,1,opt,name=string_value"`
+}
+
+func (m *pbAnyValue) Reset()         { *m = pbAnyValue{} }
+func (m *pbAnyValue) String() string { return proto.CompactTextString(m) }
+func (*pbAnyValue) ProtoMessage()    {}
+
+type pbKeyValue struct {
+	Key   string      `protobuf:"bytes,1,opt,name=key"`
+	Value *pbAnyValue `protobuf:"bytes,2,opt,name=value"`
+}
+
+func (m *pbKeyValue) Reset()         { *m = pbKeyValue{} }
+func (m *pbKeyValue) String() string { return proto.CompactTextString(m) }
+func (*pbKeyValue) ProtoMessage()    {}
+
+type pbNumberDataPoint struct {
+	StartTimeUnixNano uint64        `protobuf:"fixed64,2,opt,name=start_time_unix_nano"`
+	TimeUnixNano      uint64        `protobuf:"fixed64,3,opt,name=time_unix_nano"`
+	AsDouble          float64       `protobuf:"fixed64,4,opt,name=as_double"`
+	AsInt             int64         `protobuf:"fixed64,6,opt,name=as_int"`
+	Attributes        []*pbKeyValue `protobuf:"bytes,7,rep,name=attributes"`
+}
+
+func (m *pbNumberDataPoint) Reset()         { *m = pbNumberDataPoint{} }
+func (m *pbNumberDataPoint) String() string { return proto.CompactTextString(m) }
+func (*pbNumberDataPoint) ProtoMessage()    {}
+
+type pbSum struct {
+	DataPoints             []*pbNumberDataPoint `protobuf:"bytes,1,rep,name=data_points"`
+	AggregationTemporality int32                `protobuf:"varint,2,opt,name=aggregation_temporality"`
+	IsMonotonic            bool                 `protobuf:"varint,3,opt,name=is_monotonic"`
+}
+
+func (m *pbSum) Reset()         { *m = pbSum{} }
+func (m *pbSum) String() string { return proto.CompactTextString(m) }
+func (*pbSum) ProtoMessage()    {}
+
+type pbMetric struct {
+	Name  string `protobuf:"bytes,1,opt,name=name"`
+	Gauge *pbSum `protobuf:"bytes,5,opt,name=gauge"`
+	Sum   *pbSum `protobuf:"bytes,7,opt,name=sum"`
+}
+
+func (m *pbMetric) Reset()         { *m = pbMetric{} }
+func (m *pbMetric) String() string { return proto.CompactTextString(m) }
+func (*pbMetric) ProtoMessage()    {}
+
+type pbScopeMetrics struct {
+	Metrics []*pbMetric `protobuf:"bytes,2,rep,name=metrics"`
+}
+
+func (m *pbScopeMetrics) Reset()         { *m = pbScopeMetrics{} }
+func (m *pbScopeMetrics) String() string { return proto.CompactTextString(m) }
+func (*pbScopeMetrics) ProtoMessage()    {}
+
+type pbResource struct {
+	Attributes []*pbKeyValue `protobuf:"bytes,1,rep,name=attributes"`
+}
+
+func (m *pbResource) Reset()         { *m = pbResource{} }
+func (m *pbResource) String() string { return proto.CompactTextString(m) }
+func (*pbResource) ProtoMessage()    {}
+
+type pbResourceMetrics struct {
+	Resource     *pbResource       `protobuf:"bytes,1,opt,name=resource"`
+	ScopeMetrics []*pbScopeMetrics `protobuf:"bytes,2,rep,name=scope_metrics"`
+}
+
+func (m *pbResourceMetrics) Reset()         { *m = pbResourceMetrics{} }
+func (m *pbResourceMetrics) String() string { return proto.CompactTextString(m) }
+func (*pbResourceMetrics) ProtoMessage()    {}
+
+type pbRequest struct {
+	ResourceMetrics []*pbResourceMetrics `protobuf:"bytes,1,rep,name=resource_metrics"`
+}
+
+func (m *pbRequest) Reset()         { *m = pbRequest{} }
+func (m *pbRequest) String() string { return proto.CompactTextString(m) }
+func (*pbRequest) ProtoMessage()    {}
+
+func TestWireCompatible(t *testing.T) {
+	var pb pbRequest
+	if err := proto.Unmarshal(Encode(testRequest()), &pb); err != nil {
+		t.Fatal(err)
+	}
+	rm := pb.ResourceMetrics[0]
+	if len(rm.Resource.Attributes) != 2 || rm.Resource.Attributes[0].Key != "host.name" || rm.Resource.Attributes[0].Value.StringValue != "host-01" {
+		t.Fatalf("resource = %v", rm.Resource)
+	}
+	metrics := rm.ScopeMetrics[0].Metrics
+	sum := metrics[0].Sum
+	if metrics[0].Name != "log.err.cnt" || sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != TemporalityDelta {
+		t.Fatalf("sum = %v", metrics[0])
+	}
+	if p := sum.DataPoints[1]; p.AsInt != 42 || p.StartTimeUnixNano != 1500000010e9 || p.TimeUnixNano != 1500000020e9 {
+		t.Fatalf("sum point = %v", p)
+	}
+	if a := sum.DataPoints[0].Attributes; len(a) != 2 || a[0].Key != "code" || a[1].Key != "empty" || a[1].Value == nil {
+		t.Fatalf("attributes = %v", a)
+	}
+	if metrics[1].Gauge == nil || metrics[1].Gauge.DataPoints[0].AsDouble != -0.5 {
+		t.Fatalf("gauge = %v", metrics[1])
+	}
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"hash/fnv"
+	"math"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// DefaultMaxTagSets 单策略单周期内默认最多的tag组合数
+const DefaultMaxTagSets = 5000
+
+// OverflowTagstring 超过上限的tag组合都合并到这条序列
+// cnt/sum是精确的合计; avg/max/min按所有被合并组合的观测值汇总计算(pooled), 不是各组合结果的平均
+const OverflowTagstring = "overflow=true"
+
+// suppressedBits 估算被合并的tag组合数用的bitmap大小, 8KB, 只在溢出时分配
+const suppressedBits = 1 << 16
+
+// maxTagSets to get the accumulator cap of a strategy
+// 0取默认值, 负数不限制
+func maxTagSets(st *scheme.Strategy) int {
+	if st == nil || st.MaxTagSets == 0 {
+		return DefaultMaxTagSets
+	}
+	if st.MaxTagSets < 0 {
+		return 0
+	}
+	return st.MaxTagSets
+}
+
+// suppressedSketch to estimate the distinct tag-sets merged into the overflow series
+// linear counting: 内存固定, 插入O(1), 远小于bitmap大小时几乎是精确值
+type suppressedSketch struct {
+	bits  []uint64
+	zeros int
+}
+
+func newSuppressedSketch() *suppressedSketch {
+	return &suppressedSketch{
+		bits:  make([]uint64, suppressedBits/64),
+		zeros: suppressedBits,
+	}
+}
+
+func (s *suppressedSketch) clone() *suppressedSketch {
+	return &suppressedSketch{
+		bits:  append([]uint64(nil), s.bits...),
+		zeros: s.zeros,
+	}
+}
+
+func (s *suppressedSketch) add(tagstring string) {
+	h := fnv.New64a()
+	h.Write([]byte(tagstring))
+	i := h.Sum64() % suppressedBits
+	if s.bits[i/64]&(1<<(i%64)) == 0 {
+		s.bits[i/64] |= 1 << (i % 64)
+		s.zeros--
+	}
+}
+
+// estimate to get the distinct count
+func (s *suppressedSketch) estimate() int64 {
+	if s.zeros == 0 {
+		// bitmap已满, 只能给出下界
+		return int64(suppressedBits * math.Log(suppressedBits))
+	}
+	return int64(math.Round(-suppressedBits * math.Log(float64(s.zeros)/suppressedBits)))
+}
+
+// full to check whether new tag-sets must go to the overflow series
+// 需持有pc的锁; 只比较计数, 与tag组合数无关
+func (pc *PointsCounter) full() bool {
+	return pc.maxTagSets > 0 && pc.tagSets >= pc.maxTagSets
+}
+
+// suppress to record a tag-set merged into the overflow series
+// 需持有pc的锁
+func (pc *PointsCounter) suppress(tagstring string) {
+	if pc.suppressed == nil {
+		pc.suppressed = newSuppressedSketch()
+	}
+	pc.suppressed.add(tagstring)
+}
+
+// Suppressed to get the estimated distinct tag-sets merged into the overflow series
+func (pc *PointsCounter) Suppressed() int64 {
+	pc.RLock()
+	defer pc.RUnlock()
+	if pc.suppressed == nil {
+		return 0
+	}
+	return pc.suppressed.estimate()
+}
+
+// overflowStatPoint to build the companion point of the overflow series
+// 该周期没有溢出时返回nil
+func overflowStatPoint(st *scheme.Strategy, tms int64, pc *PointsCounter, endpoint string) *FalconPoint {
+	n := pc.Suppressed()
+	if n == 0 {
+		return nil
+	}
+	return &FalconPoint{
+		Endpoint:    endpoint,
+		Metric:      "log." + st.Name + ".suppressed_tag_sets",
+		Timestamp:   tms,
+		Step:        st.Interval,
+		Value:       float64(n),
+		Tags:        "",
+		CounterType: "GAUGE",
+	}
+}
+
+// pushOverflowStat to push the companion point of the overflow series
+func pushOverflowStat(st *scheme.Strategy, tms int64, pc *PointsCounter, endpoint string) {
+	if p := overflowStatPoint(st, tms, pc, endpoint); p != nil {
+		dlog.Warningf("tag-sets over limit, merged into %s [sid:%d][tms:%d][limit:%d][suppressed:%v]",
+			OverflowTagstring, st.ID, tms, pc.maxTagSets, p.Value)
+		pushQueue <- p
+	}
+}
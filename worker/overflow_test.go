@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+func TestMaxTagSetsOverflow(t *testing.T) {
+	gc := NewGlobalCounter(4)
+	st := &scheme.Strategy{ID: 1, Name: "cap", Interval: 10, Func: "sum", MaxTagSets: 100}
+	gc.AddStrategyCount(st)
+	sc, _ := gc.GetStrategyCountByID(1)
+
+	// 两个tag组合爆炸: 40*50=2000个组合, 每个组合2个点
+	var total float64
+	var count int64
+	for round := 0; round < 2; round++ {
+		for a := 0; a < 40; a++ {
+			for b := 0; b < 50; b++ {
+				v := float64(a*50 + b)
+				total += v
+				count++
+				p := &AnalysPoint{StrategyID: 1, Value: v, Tms: 1500000000,
+					Tags: map[string]string{"a": fmt.Sprint(a), "b": fmt.Sprint(b)}}
+				if err := gc.Push(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	pc, err := sc.GetByTms(1500000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pc.TagstringMap) != 101 {
+		t.Fatalf("series = %d, want 100 + overflow", len(pc.TagstringMap))
+	}
+	over, ok := pc.TagstringMap[OverflowTagstring]
+	if !ok {
+		t.Fatal("no overflow series")
+	}
+
+	var sum float64
+	var cnt int64
+	min, max := math.Inf(1), math.Inf(-1)
+	for tags, p := range pc.TagstringMap {
+		sum += p.Sum
+		cnt += p.Count
+		if tags != OverflowTagstring && p.Count != 2 {
+			t.Fatalf("admitted tag-set %s count = %d, want exact 2", tags, p.Count)
+		}
+		min = math.Min(min, p.Min)
+		max = math.Max(max, p.Max)
+	}
+	if sum != total || cnt != count {
+		t.Fatalf("sum/cnt = %v/%d, want %v/%d", sum, cnt, total, count)
+	}
+	if over.Count != 2*1900 || min != 0 || max != 1999 || over.Max != 1999 {
+		t.Fatalf("overflow = %+v", *over)
+	}
+
+	// linear counting是估算值, 远小于bitmap大小时误差在几个百分点内
+	if n := pc.Suppressed(); n < 1840 || n > 1960 {
+		t.Fatalf("suppressed = %d, want about 1900", n)
+	}
+	p := overflowStatPoint(st, 1500000000, pc, "host")
+	if p == nil || p.Metric != "log.cap.suppressed_tag_sets" || p.Value != float64(pc.Suppressed()) {
+		t.Fatalf("stat point = %+v", p)
+	}
+}
+
+func TestMaxTagSetsDefault(t *testing.T) {
+	gc := NewGlobalCounter(1)
+	gc.AddStrategyCount(&scheme.Strategy{ID: 2, Interval: 10, Func: "cnt", MaxTagSets: -1})
+	gc.AddStrategyCount(&scheme.Strategy{ID: 3, Interval: 10, Func: "cnt"})
+	for _, id := range []int64{2, 3} {
+		for i := 0; i < DefaultMaxTagSets+10; i++ {
+			gc.Push(&AnalysPoint{StrategyID: id, Value: 1, Tms: 1500000000,
+				Tags: map[string]string{"i": fmt.Sprint(i)}})
+		}
+	}
+
+	unlimited, _ := gc.GetStrategyCountByID(2)
+	pc, _ := unlimited.GetByTms(1500000000)
+	if len(pc.TagstringMap) != DefaultMaxTagSets+10 || pc.Suppressed() != 0 {
+		t.Fatalf("unlimited series = %d", len(pc.TagstringMap))
+	}
+	if overflowStatPoint(unlimited.Strategy, 1500000000, pc, "host") != nil {
+		t.Fatal("stat point without overflow")
+	}
+
+	capped, _ := gc.GetStrategyCountByID(3)
+	pc, _ = capped.GetByTms(1500000000)
+	if len(pc.TagstringMap) != DefaultMaxTagSets+1 || pc.TagstringMap[OverflowTagstring].Count != 10 {
+		t.Fatalf("default cap series = %d", len(pc.TagstringMap))
+	}
+	// 补零的点不影响溢出序列
+	pc.UpdateUnmatched(utils.SortedTags(map[string]string{"i": "new"}))
+	if pc.TagstringMap[OverflowTagstring].Count != 10 {
+		t.Fatal("zero-fill point reset the overflow series")
+	}
+	// 真实取到的-1是观测值, 正常计入
+	pc.Update(utils.SortedTags(map[string]string{"i": "new"}), -1)
+	if pc.TagstringMap[OverflowTagstring].Count != 11 {
+		t.Fatal("observed -1 should be counted like other values")
+	}
+}
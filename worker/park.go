@@ -0,0 +1,234 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+var (
+	// ErrGroupStopped is returned by Pause/Resume once the group is stopped
+	ErrGroupStopped = errors.New("worker group stopped")
+	// ErrGroupPaused is returned by Pause when the group is already paused
+	ErrGroupPaused = errors.New("worker group already paused")
+	// ErrGroupNotPaused is returned by Resume when the group is not paused,
+	// and by Pause when the group is resumed before all workers parked
+	ErrGroupNotPaused = errors.New("worker group not paused")
+	// ErrParkTimeout is returned by Pause when workers do not park in time, the group stays paused
+	ErrParkTimeout = errors.New("timeout waiting for workers to park")
+)
+
+// parkTimeout Pause等待worker停下的最长时间
+// 超时后group仍处于暂停状态, 慢的worker处理完当前行后停下
+var parkTimeout = 5 * time.Second
+
+// parkGate is one pause/resume cycle of a worker group
+type parkGate struct {
+	pause  chan struct{} //Pause时关闭
+	resume chan struct{} //Resume时关闭
+	parked chan struct{} //每个worker停下时写入一次
+	count  int32         //已停下的worker数
+}
+
+func newParkGate(workers int) *parkGate {
+	return &parkGate{
+		pause:  make(chan struct{}),
+		resume: make(chan struct{}),
+		parked: make(chan struct{}, workers),
+	}
+}
+
+// parkState to hold pause state of a worker group
+type parkState struct {
+	sync.Mutex
+	gate      atomic.Value //*parkGate, worker每处理一行读取一次
+	stopped   bool
+	stopCh    chan struct{}
+	paused    bool
+	principal string
+	reason    string
+	since     time.Time
+}
+
+// GroupPauseStat to show a paused worker group
+type GroupPauseStat struct {
+	Shard     int    `json:"shard"`
+	Principal string `json:"principal"`
+	Reason    string `json:"reason,omitempty"`
+	Since     int64  `json:"since"`
+	Duration  int64  `json:"duration"` //已暂停的秒数
+	Workers   int    `json:"workers"`
+	Parked    int    `json:"parked"` //已停下的worker数, 小于workers说明还有行在处理中
+}
+
+// currentGate to get the gate of the current pause/resume cycle
+func (wg *WorkerGroup) currentGate() *parkGate {
+	if gate, ok := wg.park.gate.Load().(*parkGate); ok {
+		return gate
+	}
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	return wg.gateLocked()
+}
+
+func (wg *WorkerGroup) gateLocked() *parkGate {
+	if gate, ok := wg.park.gate.Load().(*parkGate); ok {
+		return gate
+	}
+	gate := newParkGate(len(wg.Workers))
+	wg.park.gate.Store(gate)
+	return gate
+}
+
+func (wg *WorkerGroup) stopChLocked() chan struct{} {
+	if wg.park.stopCh == nil {
+		wg.park.stopCh = make(chan struct{})
+	}
+	return wg.park.stopCh
+}
+
+// stopNotify to get the channel closed when the group stops
+func (wg *WorkerGroup) stopNotify() <-chan struct{} {
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	return wg.stopChLocked()
+}
+
+// Pause to park all workers of the group without tearing down the stream and their state
+// worker处理完当前行后停下, reader不再往stream中写入(反压), 所有worker停下后返回;
+// 聚合与推送不受影响, 周期到了照常推送已累积的数据
+func (wg *WorkerGroup) Pause(principal, reason string) error {
+	wg.park.Lock()
+	if wg.park.stopped {
+		wg.park.Unlock()
+		return wg.staleCall("Pause")
+	}
+	if wg.park.paused {
+		wg.park.Unlock()
+		return ErrGroupPaused
+	}
+	gate := wg.gateLocked()
+	workers := len(wg.Workers)
+	wg.park.paused = true
+	wg.park.principal, wg.park.reason, wg.park.since = principal, reason, time.Now()
+	reader.HoldStream(wg.filePath)
+	close(gate.pause)
+	stopCh := wg.stopChLocked()
+	wg.park.Unlock()
+	dlog.Infof("pause worker group [file:%s][shard:%d][principal:%s][reason:%s]", wg.filePath, wg.Shard, principal, reason)
+
+	timeout := time.NewTimer(parkTimeout)
+	defer timeout.Stop()
+	for i := 0; i < workers; i++ {
+		select {
+		case <-gate.parked:
+		case <-gate.resume:
+			return ErrGroupNotPaused
+		case <-stopCh:
+			return ErrGroupStopped
+		case <-timeout.C:
+			dlog.Warningf("pause worker group timeout [file:%s][shard:%d][parked:%d/%d]",
+				wg.filePath, wg.Shard, atomic.LoadInt32(&gate.count), workers)
+			return ErrParkTimeout
+		}
+	}
+	return nil
+}
+
+// Resume to restart consumption of a paused group
+func (wg *WorkerGroup) Resume() error {
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	if wg.park.stopped {
+		return wg.staleCall("Resume")
+	}
+	if !wg.park.paused {
+		return ErrGroupNotPaused
+	}
+	// 先换上新的gate再放行, 恢复后的worker读到的是新一轮的gate
+	old := wg.gateLocked()
+	wg.park.gate.Store(newParkGate(len(wg.Workers)))
+	wg.park.paused = false
+	reader.ReleaseStream(wg.filePath)
+	close(old.resume)
+	dlog.Infof("resume worker group [file:%s][shard:%d][paused:%s]", wg.filePath, wg.Shard, time.Since(wg.park.since))
+	return nil
+}
+
+// stopPark to mark the group stopped, Pause/Resume become no-ops afterwards
+// release为true时放行已停下的worker(StopGraceful排空用), 否则它们停在gate上直到Close; 返回false表示已经停止过
+func (wg *WorkerGroup) stopPark(release bool) bool {
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	if wg.park.stopped {
+		return false
+	}
+	wg.park.stopped = true
+	if wg.park.paused {
+		wg.park.paused = false
+		reader.ReleaseStream(wg.filePath)
+		if release {
+			old := wg.gateLocked()
+			wg.park.gate.Store(newParkGate(len(wg.Workers)))
+			close(old.resume)
+		}
+	}
+	close(wg.stopChLocked())
+	return true
+}
+
+// PauseStat to get pause state of the group
+func (wg *WorkerGroup) PauseStat() (GroupPauseStat, bool) {
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	if !wg.park.paused {
+		return GroupPauseStat{}, false
+	}
+	return GroupPauseStat{
+		Shard:     wg.Shard,
+		Principal: wg.park.principal,
+		Reason:    wg.park.reason,
+		Since:     wg.park.since.Unix(),
+		Duration:  int64(time.Since(wg.park.since) / time.Second),
+		Workers:   len(wg.Workers),
+		Parked:    int(atomic.LoadInt32(&wg.gateLocked().count)),
+	}, true
+}
+
+// GroupPauseStats to get paused worker groups of all files
+func GroupPauseStats() map[string][]GroupPauseStat {
+	ret := make(map[string][]GroupPauseStat)
+	ManagerJobLock.RLock()
+	defer ManagerJobLock.RUnlock()
+	for file, job := range ManagerJob {
+		for _, wg := range job.groups() {
+			if stat, ok := wg.PauseStat(); ok {
+				ret[file] = append(ret[file], stat)
+			}
+		}
+	}
+	return ret
+}
+
+// park to wait until the group is resumed or the worker is stopped
+// 返回false表示worker已被停止
+func (w *Worker) park(gate *parkGate) bool {
+	// 已被Resize移除的worker直接退出, 不计入暂停的worker数
+	select {
+	case <-w.Close:
+		return false
+	default:
+	}
+	atomic.AddInt32(&gate.count, 1)
+	gate.parked <- struct{}{}
+	select {
+	case <-gate.resume:
+		return true
+	case <-w.Close:
+		return false
+	}
+}
@@ -0,0 +1,303 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+const parkFile = "/home/app/log/park.log"
+
+func setParkStrategy(t *testing.T) {
+	pat, _ := utils.GetPatAndTimeFormat("yyyy-mm-dd HH:MM:SS")
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{{
+		ID:         1,
+		Name:       "park.cost",
+		FilePath:   parkFile,
+		TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Pattern:    `cost=([0-9]+)`,
+		Interval:   60,
+		Func:       "cnt",
+		TimeReg:    regexp.MustCompile(pat),
+		PatternReg: regexp.MustCompile(`cost=([0-9]+)`),
+		ParseSucc:  true,
+	}})
+}
+
+// newParkGroup to build a group without g.Conf(), cb is called with the log time of every line
+func newParkGroup(n int, stream chan reader.Line, cb callbackHandler) *WorkerGroup {
+	wg := &WorkerGroup{WorkerNum: n, filePath: parkFile, shed: newShedder()}
+	for i := 0; i < n; i++ {
+		wg.Workers = append(wg.Workers, &Worker{
+			FilePath: parkFile,
+			Stream:   stream,
+			Close:    make(chan struct{}),
+			Mark:     fmt.Sprintf("[worker][park][id:%d]", i),
+			Callback: cb,
+			Accept:   func(int64) bool { return true },
+			Gate:     wg.currentGate,
+			Drain:    wg.streamDrain(),
+		})
+	}
+	return wg
+}
+
+// parkLine to make a line whose log time identifies it
+func parkLine(base time.Time, i int) reader.Line {
+	// 按本机时区写出, 与没有经过加载的策略一致
+	tm := base.Add(time.Duration(i) * time.Second).In(time.Local)
+	return reader.Line{Text: tm.Format("2006-01-02 15:04:05") + " cost=1"}
+}
+
+func TestPauseResumeNoLoss(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	const total = 3000
+	base := time.Now().Add(-2 * total * time.Second)
+	var lock sync.Mutex
+	seen := make(map[int64]int)
+	var processed int64
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(4, stream, func(tms, delay int64) {
+		lock.Lock()
+		seen[tms]++
+		lock.Unlock()
+		atomic.AddInt64(&processed, 1)
+	})
+	wg.Start()
+	defer wg.Stop()
+
+	// 持续输入, 满了就阻塞
+	go func() {
+		for i := 0; i < total; i++ {
+			stream <- parkLine(base, i)
+		}
+	}()
+	for atomic.LoadInt64(&processed) < 100 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := wg.Pause("alice", "seek"); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	if err := wg.Pause("bob", ""); err != ErrGroupPaused {
+		t.Fatalf("expect ErrGroupPaused, got %v", err)
+	}
+	parked := atomic.LoadInt64(&processed)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&processed); n != parked {
+		t.Fatalf("workers should not consume while paused, %d -> %d", parked, n)
+	}
+	if len(stream) != cap(stream) || !reader.StreamHeld(parkFile) {
+		t.Fatalf("stream should be full and held while paused, len %d", len(stream))
+	}
+	stat, ok := wg.PauseStat()
+	if !ok || stat.Principal != "alice" || stat.Reason != "seek" || stat.Parked != 4 || stat.Workers != 4 {
+		t.Fatalf("unexpected pause stat: %+v", stat)
+	}
+
+	if err := wg.Resume(); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if err := wg.Resume(); err != ErrGroupNotPaused {
+		t.Fatalf("expect ErrGroupNotPaused, got %v", err)
+	}
+	if reader.StreamHeld(parkFile) {
+		t.Fatal("stream should be released after resume")
+	}
+	if _, ok := wg.PauseStat(); ok {
+		t.Fatal("group should not be paused after resume")
+	}
+
+	// 再暂停一轮, gate可以重复使用
+	if err := wg.Pause("alice", "again"); err != nil {
+		t.Fatalf("second pause failed: %v", err)
+	}
+	wg.Resume()
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&processed) < total && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(seen) != total {
+		t.Fatalf("expect %d lines, got %d", total, len(seen))
+	}
+	for i := 0; i < total; i++ {
+		tms := base.Add(time.Duration(i) * time.Second).Unix()
+		if seen[tms] != 1 {
+			t.Fatalf("line %d seen %d times", i, seen[tms])
+		}
+	}
+}
+
+func TestPauseSlowLine(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+	defer func(d time.Duration) { parkTimeout = d }(parkTimeout)
+
+	started := make(chan struct{}, 1)
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(2, stream, func(int64, int64) {
+		started <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+	})
+	wg.Start()
+	defer wg.Stop()
+	base := time.Now().Add(-time.Hour)
+
+	// 等待处理中的慢行
+	stream <- parkLine(base, 0)
+	<-started
+	begin := time.Now()
+	if err := wg.Pause("alice", "slow"); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("pause took too long: %v", d)
+	}
+	wg.Resume()
+
+	// 超时返回, group保持暂停, 慢行处理完后停下
+	parkTimeout = 50 * time.Millisecond
+	stream <- parkLine(base, 1)
+	<-started
+	begin = time.Now()
+	if err := wg.Pause("alice", "slow"); err != ErrParkTimeout {
+		t.Fatalf("expect ErrParkTimeout, got %v", err)
+	}
+	if d := time.Since(begin); d > 200*time.Millisecond {
+		t.Fatalf("pause should return after timeout, took %v", d)
+	}
+	stat, ok := wg.PauseStat()
+	if !ok || stat.Parked != 1 {
+		t.Fatalf("expect 1 parked worker, got %+v", stat)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if stat, _ = wg.PauseStat(); stat.Parked == 2 {
+			break
+		}
+	}
+	if stat.Parked != 2 {
+		t.Fatalf("slow worker should park after its line, got %+v", stat)
+	}
+	wg.Resume()
+}
+
+func TestPauseStop(t *testing.T) {
+	skipIfStalePanics(t)
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(2, stream, func(int64, int64) {})
+	wg.Start()
+
+	if err := wg.Pause("alice", "maintenance"); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	// Stop优先, 停下的worker直接退出, 反压解除
+	wg.Stop()
+	wg.Stop()
+	if reader.StreamHeld(parkFile) {
+		t.Fatal("stream should be released after stop")
+	}
+	if err := wg.Resume(); err != ErrGroupStopped {
+		t.Fatalf("expect ErrGroupStopped, got %v", err)
+	}
+	if err := wg.Pause("alice", ""); err != ErrGroupStopped {
+		t.Fatalf("expect ErrGroupStopped, got %v", err)
+	}
+
+	// 与Pause/Resume并发Stop
+	for i := 0; i < 50; i++ {
+		wg := newParkGroup(2, stream, func(int64, int64) {})
+		wg.Start()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for j := 0; j < 20; j++ {
+				if err := wg.Pause("alice", ""); err == ErrGroupStopped {
+					return
+				}
+				wg.Resume()
+			}
+		}()
+		wg.Stop()
+		<-done
+		if err := wg.Pause("alice", ""); err != ErrGroupStopped {
+			t.Fatalf("expect ErrGroupStopped, got %v", err)
+		}
+		if reader.StreamHeld(parkFile) {
+			t.Fatal("stream should be released after stop")
+		}
+	}
+}
+
+// 拆分成多个shard时暂停其中一个, fanout等待而不是丢弃该shard的行
+func TestPauseShardNoLoss(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	const total = 200
+	base := time.Now().Add(-2 * total * time.Second)
+	var processed [2]int64
+	groups := make([]*WorkerGroup, 2)
+	in := make(chan reader.Line)
+	f := newFanout(parkFile, in)
+	for i := range groups {
+		i := i
+		stream := make(chan reader.Line, 8)
+		groups[i] = newParkGroup(2, stream, func(int64, int64) { atomic.AddInt64(&processed[i], 1) })
+		groups[i].stream = stream
+		groups[i].Shard = i
+		groups[i].Start()
+		defer groups[i].Stop()
+		f.add(groups[i])
+	}
+	go f.run()
+	defer close(in)
+
+	if err := groups[0].Pause("alice", "seek"); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < total; i++ {
+			in <- parkLine(base, i)
+		}
+		close(sent)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&processed[0]); n != 0 {
+		t.Fatalf("paused shard processed %d lines", n)
+	}
+	select {
+	case <-sent:
+		t.Fatal("fanout should wait for the paused shard instead of dropping")
+	default:
+	}
+
+	if err := groups[0].Resume(); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	<-sent
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&processed[0]) < total || atomic.LoadInt64(&processed[1]) < total {
+		if time.Now().After(deadline) {
+			t.Fatalf("lines lost: processed %d %d of %d", processed[0], processed[1], total)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, wg := range groups {
+		if n := atomic.LoadInt64(&wg.fanoutDropped); n != 0 {
+			t.Fatalf("shard %d dropped %d lines", i, n)
+		}
+	}
+}
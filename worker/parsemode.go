@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// parseModeOfFormat to get the parse mode of a detected format, 为空表示按正则
+// syslog没有对应的解析方式, 与纯文本一样按正则, 时间格式"mmm dd HH:MM:SS"可以直接匹配其行首
+func parseModeOfFormat(format string) string {
+	switch format {
+	case reader.LineFormatJSON:
+		return scheme.ParseModeJSON
+	case reader.LineFormatLogfmt:
+		return scheme.ParseModeLogfmt
+	}
+	return ""
+}
+
+// detectParseMode to detect the parse mode for strategies with parse_mode auto by the first lines of the file
+// 只在group启动时检测一次, 之后加入的auto策略沿用; 不是普通文件或读取失败时按正则, ok为false
+func (wg *WorkerGroup) detectParseMode() (string, bool) {
+	format, lines, err := reader.DetectFileFormat(wg.filePath)
+	if err != nil {
+		dlog.Debugf("cannot detect parse_mode, parse with regexp [file:%s][shard:%d][err:%v]", wg.filePath, wg.Shard, err)
+		return "", false
+	}
+	mode := parseModeOfFormat(format)
+	name := mode
+	if name == "" {
+		name = "regexp"
+	}
+	dlog.Infof("detected parse_mode %s [file:%s][shard:%d][format:%s][lines:%d]", name, wg.filePath, wg.Shard, format, lines)
+	return mode, true
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// TestParseModeAuto checks strategies with parse_mode auto produce the same points as the explicit mode
+func TestParseModeAuto(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "parsemode")
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		fixture string
+		head    int //写入文件开头的行数, 之后的行是故意构造的坏行
+		mode    string
+	}{
+		{"nginx_json", 4, scheme.ParseModeJSON},
+		{"logfmt", 6, scheme.ParseModeLogfmt},
+		{"syslog", 3, ""},
+	}
+	for _, c := range cases {
+		st, lines, expected := LoadFixture(t, c.fixture)
+		file := filepath.Join(dir, c.fixture+".log")
+		ioutil.WriteFile(file, []byte(strings.Join(lines[:c.head], "\n")+"\n"), 0644)
+
+		wg := &WorkerGroup{filePath: file}
+		mode, ok := wg.detectParseMode()
+		if !ok || mode != c.mode {
+			t.Errorf("%s: detected %q %v, want %q", c.fixture, mode, ok, c.mode)
+			continue
+		}
+
+		st.ParseMode = scheme.ParseModeAuto
+		w := &Worker{Mark: "[worker][auto]", Callback: func(int64, int64) {}, parseMode: mode}
+		for i, line := range lines {
+			p, err := w.producer(line, st)
+			if err != nil {
+				p = nil
+			}
+			if !samePoint(p, expected[i]) {
+				t.Errorf("%s line %d: got %+v, want %+v", c.fixture, i, p, expected[i])
+			}
+		}
+	}
+
+	// 不是普通文件的不检测, 按正则
+	wg := &WorkerGroup{filePath: dir}
+	if mode, ok := wg.detectParseMode(); ok || mode != "" {
+		t.Errorf("directory should not be detected, got %q %v", mode, ok)
+	}
+}
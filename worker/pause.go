@@ -0,0 +1,298 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// PauseSelector to select strategies to pause
+// 各条件之间是或的关系, files支持path.Match的通配符
+type PauseSelector struct {
+	Files []string `json:"files,omitempty"`
+	Owner string   `json:"owner,omitempty"`
+	IDs   []int64  `json:"ids,omitempty"`
+	All   bool     `json:"all,omitempty"`
+}
+
+func (s *PauseSelector) validate() error {
+	if s.Owner != "" {
+		return fmt.Errorf("owner selector is not supported, strategies have no owner")
+	}
+	if !s.All && len(s.Files) == 0 && len(s.IDs) == 0 {
+		return fmt.Errorf("empty selector, set files, ids or all")
+	}
+	for _, f := range s.Files {
+		if _, err := path.Match(f, ""); err != nil {
+			return fmt.Errorf("bad file pattern %s: %v", f, err)
+		}
+	}
+	return nil
+}
+
+func (s *PauseSelector) match(id int64, filePath string) bool {
+	if s.All {
+		return true
+	}
+	for _, i := range s.IDs {
+		if i == id {
+			return true
+		}
+	}
+	for _, f := range s.Files {
+		if f == filePath {
+			return true
+		}
+		if ok, _ := path.Match(f, filePath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PauseSelector) equal(o *PauseSelector) bool {
+	if s.All != o.All || s.Owner != o.Owner || len(s.Files) != len(o.Files) || len(s.IDs) != len(o.IDs) {
+		return false
+	}
+	for i := range s.Files {
+		if s.Files[i] != o.Files[i] {
+			return false
+		}
+	}
+	for i := range s.IDs {
+		if s.IDs[i] != o.IDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Pause is a maintenance pause of strategies
+// 暂停期间策略不产生点, 只统计行数; 叠加在下发的策略之上, 策略刷新不影响
+type Pause struct {
+	ID          int64         `json:"id"`
+	Selector    PauseSelector `json:"selector"`
+	Principal   string        `json:"principal"`
+	Since       int64         `json:"since"`
+	Until       int64         `json:"until,omitempty"` //到期自动恢复, 0表示需手动恢复
+	PausedLines int64         `json:"paused_lines"`    //暂停期间跳过的行数
+}
+
+func (p *Pause) active(now int64) bool {
+	return p.Until == 0 || now < p.Until
+}
+
+// Status to describe the pause in strategy status
+func (p *Pause) Status() string {
+	if p.Until == 0 {
+		return fmt.Sprintf("paused by %s", p.Principal)
+	}
+	return fmt.Sprintf("paused by %s until %s", p.Principal, time.Unix(p.Until, 0).Format(time.RFC3339))
+}
+
+func (p *Pause) snapshot() *Pause {
+	cp := *p
+	cp.PausedLines = atomic.LoadInt64(&p.PausedLines)
+	return &cp
+}
+
+var (
+	// pauseNow 测试中替换为假时钟
+	pauseNow = time.Now
+	// 当前的暂停列表([]*Pause), 只整体替换, 发射路径上只有一次原子读
+	pauses     atomic.Value
+	pausesLock = new(sync.Mutex)
+	pauseSeq   int64
+)
+
+func init() {
+	pauses.Store([]*Pause{})
+}
+
+func loadPauses() []*Pause {
+	return pauses.Load().([]*Pause)
+}
+
+// pausedBy to get the pause covering the strategy, nil if not paused
+func pausedBy(id int64, filePath string) *Pause {
+	ps := loadPauses()
+	if len(ps) == 0 {
+		return nil
+	}
+	now := pauseNow().Unix()
+	for _, p := range ps {
+		if p.active(now) && p.Selector.match(id, filePath) {
+			return p
+		}
+	}
+	return nil
+}
+
+// countPaused to count the line if the strategy is paused
+func countPaused(id int64, filePath string) bool {
+	p := pausedBy(id, filePath)
+	if p == nil {
+		return false
+	}
+	atomic.AddInt64(&p.PausedLines, 1)
+	metric.MetricPausedLine(filePath, 1)
+	return true
+}
+
+// PauseStatus to get pause status of a strategy, empty if not paused
+func PauseStatus(id int64, filePath string) string {
+	if p := pausedBy(id, filePath); p != nil {
+		return p.Status()
+	}
+	return ""
+}
+
+// PauseStrategies to pause strategies matching the selector, d为0时需手动恢复
+func PauseStrategies(sel PauseSelector, principal string, d time.Duration) (*Pause, error) {
+	if err := sel.validate(); err != nil {
+		return nil, err
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("bad duration %v", d)
+	}
+	now := pauseNow()
+	p := &Pause{Selector: sel, Principal: principal, Since: now.Unix()}
+	if d > 0 {
+		p.Until = now.Add(d).Unix()
+	}
+
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	ps := loadPauses()
+	for _, o := range ps {
+		if o.ID > pauseSeq {
+			pauseSeq = o.ID
+		}
+	}
+	pauseSeq++
+	p.ID = pauseSeq
+	next := make([]*Pause, 0, len(ps)+1)
+	next = append(next, ps...)
+	next = append(next, p)
+	pauses.Store(next)
+	savePauses(next)
+	dlog.Infof("[audit] pause strategies [id:%d][principal:%s][selector:%+v][until:%d]", p.ID, principal, sel, p.Until)
+	return p, nil
+}
+
+// ResumeStrategies to remove pauses with the same selector, all为true时移除全部暂停
+func ResumeStrategies(sel PauseSelector, principal string) []*Pause {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	ps := loadPauses()
+	next := make([]*Pause, 0, len(ps))
+	removed := make([]*Pause, 0)
+	for _, p := range ps {
+		if sel.All || p.Selector.equal(&sel) {
+			removed = append(removed, p.snapshot())
+			continue
+		}
+		next = append(next, p)
+	}
+	if len(removed) == 0 {
+		return removed
+	}
+	pauses.Store(next)
+	savePauses(next)
+	for _, p := range removed {
+		dlog.Infof("[audit] resume strategies [id:%d][principal:%s][selector:%+v][paused_by:%s][paused_lines:%d]",
+			p.ID, principal, p.Selector, p.Principal, p.PausedLines)
+	}
+	return removed
+}
+
+// GetPauses to get active pauses
+func GetPauses() []*Pause {
+	now := pauseNow().Unix()
+	ret := make([]*Pause, 0)
+	for _, p := range loadPauses() {
+		if p.active(now) {
+			ret = append(ret, p.snapshot())
+		}
+	}
+	return ret
+}
+
+// ExpirePauses to remove expired pauses
+// 判断是否暂停时已经按到期时间过滤, 这里只是清理和记录
+func ExpirePauses() {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	now := pauseNow().Unix()
+	ps := loadPauses()
+	next := make([]*Pause, 0, len(ps))
+	for _, p := range ps {
+		if p.active(now) {
+			next = append(next, p)
+			continue
+		}
+		dlog.Infof("[audit] pause expired, resume strategies [id:%d][selector:%+v][paused_by:%s][paused_lines:%d]",
+			p.ID, p.Selector, p.Principal, atomic.LoadInt64(&p.PausedLines))
+	}
+	if len(next) == len(ps) {
+		return
+	}
+	pauses.Store(next)
+	savePauses(next)
+}
+
+// PauseLoop to expire pauses and boosts periodically
+func PauseLoop() {
+	for {
+		time.Sleep(time.Second)
+		ExpirePauses()
+		ExpireBoosts()
+	}
+}
+
+// savePauses to save pauses along with checkpoints, must be called with pausesLock held
+// 暂停操作很少, 开启checkpoint时立即落盘
+func savePauses(ps []*Pause) {
+	snapshots := make([]*Pause, 0, len(ps))
+	for _, p := range ps {
+		snapshots = append(snapshots, p.snapshot())
+	}
+	bs, err := json.Marshal(snapshots)
+	if err != nil {
+		dlog.Errorf("encode pauses failed: %v", err)
+		return
+	}
+	reader.SetPauseState(bs)
+	if g.Conf() == nil || g.Conf().Checkpoint.Path == "" {
+		return
+	}
+	if err := reader.SaveCheckpoints(g.Conf().Checkpoint.Path); err != nil {
+		dlog.Errorf("save pauses failed [path:%s][err:%v]", g.Conf().Checkpoint.Path, err)
+	}
+}
+
+// LoadPauses to restore pauses from checkpoint file, expired ones are dropped
+func LoadPauses() {
+	state := reader.GetPauseState()
+	if len(state) == 0 {
+		return
+	}
+	var ps []*Pause
+	if err := json.Unmarshal(state, &ps); err != nil {
+		dlog.Warningf("decode pauses failed, pauses are dropped: %v", err)
+		return
+	}
+	pausesLock.Lock()
+	pauses.Store(ps)
+	pausesLock.Unlock()
+	dlog.Infof("load pauses success [pauses:%d]", len(ps))
+	ExpirePauses()
+}
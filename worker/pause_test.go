@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// setPauseClock to replace the clock of pauses, returns a function to advance it
+func setPauseClock(t *testing.T) func(time.Duration) {
+	now := time.Unix(1500000000, 0)
+	pauseNow = func() time.Time { return now }
+	t.Cleanup(func() {
+		pauseNow = time.Now
+		ResumeStrategies(PauseSelector{All: true}, "test")
+	})
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPauseSelector(t *testing.T) {
+	cases := []struct {
+		sel  PauseSelector
+		id   int64
+		file string
+		want bool
+	}{
+		{PauseSelector{All: true}, 1, "/a.log", true},
+		{PauseSelector{IDs: []int64{1, 2}}, 2, "/a.log", true},
+		{PauseSelector{IDs: []int64{1, 2}}, 3, "/a.log", false},
+		{PauseSelector{Files: []string{"/var/log/a.log"}}, 3, "/var/log/a.log", true},
+		{PauseSelector{Files: []string{"/var/log/*.log"}}, 3, "/var/log/b.log", true},
+		{PauseSelector{Files: []string{"/var/log/*.log"}}, 3, "/var/log/x/b.log", false},
+		{PauseSelector{Files: []string{"/var/log/a.log"}, IDs: []int64{9}}, 9, "/other.log", true},
+	}
+	for i, c := range cases {
+		if got := c.sel.match(c.id, c.file); got != c.want {
+			t.Errorf("case %d: expect %v, got %v", i, c.want, got)
+		}
+	}
+
+	for _, sel := range []PauseSelector{{}, {Owner: "team-a"}, {Files: []string{"["}}} {
+		if err := sel.validate(); err == nil {
+			t.Errorf("selector %+v should be rejected", sel)
+		}
+	}
+}
+
+func TestPauseAutoExpire(t *testing.T) {
+	advance := setPauseClock(t)
+	p, err := PauseStrategies(PauseSelector{Files: []string{"/tmp/*.log"}}, "alice", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PauseStatus(1, "/tmp/a.log"); !strings.HasPrefix(got, "paused by alice until ") {
+		t.Fatalf("unexpected status %q", got)
+	}
+	if !countPaused(1, "/tmp/a.log") || countPaused(1, "/var/a.log") {
+		t.Fatal("pause should only cover matched file")
+	}
+	if ps := GetPauses(); len(ps) != 1 || ps[0].ID != p.ID || ps[0].PausedLines != 1 {
+		t.Fatalf("unexpected pauses %+v", ps)
+	}
+
+	advance(10 * time.Minute)
+	if pausedBy(1, "/tmp/a.log") != nil {
+		t.Fatal("pause should expire")
+	}
+	ExpirePauses()
+	if len(loadPauses()) != 0 {
+		t.Fatal("expired pause should be removed")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	setPauseClock(t)
+	sel := PauseSelector{IDs: []int64{1}}
+	if _, err := PauseStrategies(sel, "alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	PauseStrategies(PauseSelector{IDs: []int64{2}}, "bob", 0)
+	if got := PauseStatus(1, "/a.log"); got != "paused by alice" {
+		t.Fatalf("unexpected status %q", got)
+	}
+	if removed := ResumeStrategies(PauseSelector{IDs: []int64{3}}, "alice"); len(removed) != 0 {
+		t.Fatal("resume with other selector should not remove pause")
+	}
+	if removed := ResumeStrategies(sel, "alice"); len(removed) != 1 {
+		t.Fatalf("unexpected removed %+v", removed)
+	}
+	if pausedBy(1, "/a.log") != nil || pausedBy(2, "/a.log") == nil {
+		t.Fatal("only pause with the same selector should be removed")
+	}
+}
+
+func TestPausePersist(t *testing.T) {
+	advance := setPauseClock(t)
+	dir, _ := ioutil.TempDir("", "pause")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	PauseStrategies(PauseSelector{IDs: []int64{1}}, "alice", time.Hour)
+	PauseStrategies(PauseSelector{IDs: []int64{2}}, "alice", 2*time.Hour)
+	if err := reader.SaveCheckpoints(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟重启: 清空内存状态后从checkpoint文件恢复, 重启期间第一个暂停已到期
+	pauses.Store([]*Pause{})
+	reader.SetPauseState(nil)
+	advance(90 * time.Minute)
+	reader.LoadCheckpoints(path)
+	LoadPauses()
+	if pausedBy(1, "/a.log") != nil {
+		t.Fatal("pause expired during restart should be dropped")
+	}
+	if p := pausedBy(2, "/a.log"); p == nil || p.Principal != "alice" {
+		t.Fatal("pause should survive restart")
+	}
+
+	// 恢复后的暂停id不重复
+	p, _ := PauseStrategies(PauseSelector{IDs: []int64{3}}, "bob", 0)
+	if p.ID <= 2 {
+		t.Fatalf("pause id should not be reused, got %d", p.ID)
+	}
+}
+
+func TestPausePrecedence(t *testing.T) {
+	setPauseClock(t)
+	wg := &WorkerGroup{filePath: "/a.log", shed: newShedder()}
+	wg.shed.suspended = []int64{1}
+	wg.shed.stats[1] = &ShedStat{Suspended: true}
+
+	// 同时被降级和暂停时按暂停计数
+	PauseStrategies(PauseSelector{IDs: []int64{1, 2}}, "alice", 0)
+	if wg.accept(1) || wg.accept(2) {
+		t.Fatal("paused strategies should not be accepted")
+	}
+	if p := GetPauses()[0]; p.PausedLines != 2 {
+		t.Fatalf("expect 2 paused lines, got %d", p.PausedLines)
+	}
+
+	// 恢复后仍受降级控制
+	ResumeStrategies(PauseSelector{IDs: []int64{1, 2}}, "alice")
+	if wg.accept(1) || !wg.accept(2) {
+		t.Fatal("shedding should apply after resume")
+	}
+
+	// 不归属本group的策略不计数
+	wg.SetStrategyIDs([]int64{2})
+	p, _ := PauseStrategies(PauseSelector{All: true}, "alice", 0)
+	wg.accept(1)
+	if GetPauses()[0].PausedLines != 0 || p.ID == 0 {
+		t.Fatal("strategies of other groups should not be counted")
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Package pointpack is a compact binary framing of points streamed to a remote aggregator.
+
+Points of the same strategy and tag set are grouped, so the strategy id, metric,
+endpoint and tags are sent once per group, followed by tightly packed
+(delta-encoded timestamp, float64 value) pairs.
+
+Layout of version 1, integers are varint (signed ones zigzag encoded as by
+encoding/binary), strings are uvarint length followed by bytes:
+
+	batch   := version(1 byte) seq(uvarint) group_count(uvarint) group*
+	group   := strategy_id(varint) metric(string) endpoint(string)
+	           tag_count(uvarint) (key(string) value(string))* //按key排序
+	           point_count(uvarint) point*
+	point   := tms_delta(varint) value(8 bytes, little-endian IEEE 754)
+
+tms_delta of the first point in a group is relative to 0, others to the previous point.
+Version 2 only changes point to carry the unmatched flag in the lowest bit:
+
+	point   := (tms_delta*2 + unmatched)(varint) value(8 bytes, little-endian IEEE 754)
+
+The encoder writes version 1 when no point of the batch is unmatched, so aggregators
+that only read version 1 keep working until fill points are sent.
+Each batch is sent as the data of a pointpb.PackedBatch on the PointSink.StreamPacked gRPC method.
+*/
+package pointpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Version is the current layout version, 见包注释中各版本的区别
+const Version = 2
+
+// ErrTruncated is returned when the data ends in the middle of a batch
+var ErrTruncated = errors.New("pointpack: truncated batch")
+
+// Point is a timestamp and value pair
+type Point struct {
+	Tms       int64
+	Value     float64
+	Unmatched bool //pattern没有匹配到而补的点
+}
+
+// Group is points of one strategy and tag set
+type Group struct {
+	StrategyID int64
+	Metric     string
+	Endpoint   string
+	Tags       map[string]string
+	Points     []Point
+}
+
+// Batch is a batch of groups
+type Batch struct {
+	Seq    uint64
+	Groups []*Group
+}
+
+// Encoder to encode batches, buffers are reused between calls
+type Encoder struct {
+	buf  []byte
+	keys []string
+}
+
+// Encode to encode the batch, the returned bytes are valid until next call
+func (e *Encoder) Encode(b *Batch) []byte {
+	version := byte(1)
+	for _, grp := range b.Groups {
+		for _, p := range grp.Points {
+			if p.Unmatched {
+				version = Version
+			}
+		}
+	}
+	buf := append(e.buf[:0], version)
+	buf = binary.AppendUvarint(buf, b.Seq)
+	buf = binary.AppendUvarint(buf, uint64(len(b.Groups)))
+	for _, grp := range b.Groups {
+		buf = binary.AppendVarint(buf, grp.StrategyID)
+		buf = appendString(buf, grp.Metric)
+		buf = appendString(buf, grp.Endpoint)
+
+		e.keys = e.keys[:0]
+		for k := range grp.Tags {
+			e.keys = append(e.keys, k)
+		}
+		sort.Strings(e.keys)
+		buf = binary.AppendUvarint(buf, uint64(len(e.keys)))
+		for _, k := range e.keys {
+			buf = appendString(buf, k)
+			buf = appendString(buf, grp.Tags[k])
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(len(grp.Points)))
+		var last int64
+		for _, p := range grp.Points {
+			delta := p.Tms - last
+			if version >= 2 {
+				delta *= 2
+				if p.Unmatched {
+					delta++
+				}
+			}
+			buf = binary.AppendVarint(buf, delta)
+			last = p.Tms
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(p.Value))
+		}
+	}
+	e.buf = buf
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder to read fields from a batch
+type decoder struct {
+	data []byte
+	off  int
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		return 0, ErrTruncated
+	}
+	d.off += n
+	return v, nil
+}
+
+func (d *decoder) varint() (int64, error) {
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		return 0, ErrTruncated
+	}
+	d.off += n
+	return v, nil
+}
+
+// count to read a count, each element takes at least min bytes
+// 按剩余字节数校验, 避免损坏的数据导致大量分配
+func (d *decoder) count(min int) (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.off)/uint64(min) {
+		return 0, ErrTruncated
+	}
+	return int(n), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.count(1)
+	if err != nil {
+		return "", err
+	}
+	s := string(d.data[d.off : d.off+n])
+	d.off += n
+	return s, nil
+}
+
+// Decode to decode a batch
+func Decode(data []byte) (*Batch, error) {
+	if len(data) == 0 {
+		return nil, ErrTruncated
+	}
+	version := data[0]
+	if version < 1 || version > Version {
+		return nil, fmt.Errorf("pointpack: unsupported version %d", version)
+	}
+	d := &decoder{data: data, off: 1}
+	b := new(Batch)
+	var err error
+	if b.Seq, err = d.uvarint(); err != nil {
+		return nil, err
+	}
+	// 每个group至少有id、metric、endpoint、tag数、点数各1字节
+	groups, err := d.count(5)
+	if err != nil {
+		return nil, err
+	}
+	b.Groups = make([]*Group, 0, groups)
+	for i := 0; i < groups; i++ {
+		grp := new(Group)
+		if grp.StrategyID, err = d.varint(); err != nil {
+			return nil, err
+		}
+		if grp.Metric, err = d.string(); err != nil {
+			return nil, err
+		}
+		if grp.Endpoint, err = d.string(); err != nil {
+			return nil, err
+		}
+		tags, err := d.count(2)
+		if err != nil {
+			return nil, err
+		}
+		grp.Tags = make(map[string]string, tags)
+		for j := 0; j < tags; j++ {
+			k, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if grp.Tags[k], err = d.string(); err != nil {
+				return nil, err
+			}
+		}
+		points, err := d.count(9)
+		if err != nil {
+			return nil, err
+		}
+		grp.Points = make([]Point, points)
+		var last int64
+		for j := range grp.Points {
+			delta, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			if len(d.data)-d.off < 8 {
+				return nil, ErrTruncated
+			}
+			var unmatched bool
+			if version >= 2 {
+				unmatched = delta&1 == 1
+				delta >>= 1
+			}
+			last += delta
+			grp.Points[j] = Point{
+				Tms:       last,
+				Value:     math.Float64frombits(binary.LittleEndian.Uint64(d.data[d.off:])),
+				Unmatched: unmatched,
+			}
+			d.off += 8
+		}
+		b.Groups = append(b.Groups, grp)
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("pointpack: %d trailing bytes", len(data)-d.off)
+	}
+	return b, nil
+}
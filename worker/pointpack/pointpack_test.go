@@ -0,0 +1,135 @@
+package pointpack
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testBatch() *Batch {
+	b := &Batch{Seq: 42}
+	for i := int64(0); i < 4; i++ {
+		grp := &Group{
+			StrategyID: i + 1,
+			Metric:     "nginx.request.count",
+			Endpoint:   "host-01",
+			Tags:       map[string]string{"code": "200", "idc": "bj"},
+		}
+		for j := int64(0); j < 50; j++ {
+			grp.Points = append(grp.Points, Point{Tms: 1500000000 + j*10, Value: float64(j) * 1.5})
+		}
+		b.Groups = append(b.Groups, grp)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	var e Encoder
+	for _, b := range []*Batch{
+		{Seq: 1},
+		{Seq: 2, Groups: []*Group{{StrategyID: -1, Tags: map[string]string{}, Points: []Point{}}}},
+		{Seq: 3, Groups: []*Group{{StrategyID: 7, Tags: map[string]string{}, Points: []Point{{Tms: 100, Value: 1}, {Tms: 90, Value: -2.5}}}}},
+		{Seq: 4, Groups: []*Group{{StrategyID: 7, Tags: map[string]string{}, Points: []Point{{Tms: 100, Value: -1, Unmatched: true}, {Tms: 90, Value: -1}, {Tms: 95, Value: -1, Unmatched: true}}}}},
+		testBatch(),
+	} {
+		got, err := Decode(e.Encode(b))
+		if err != nil {
+			t.Fatalf("decode seq %d: %v", b.Seq, err)
+		}
+		if b.Groups == nil {
+			b.Groups = []*Group{}
+		}
+		if !reflect.DeepEqual(got, b) {
+			t.Fatalf("round trip mismatch:\n%+v\n%+v", got, b)
+		}
+	}
+}
+
+// 没有补零的点时按版本1编码, 只读版本1的聚合端仍能解码
+func TestEncodeVersion(t *testing.T) {
+	var e Encoder
+	if data := e.Encode(testBatch()); data[0] != 1 {
+		t.Errorf("batch without unmatched points should be version 1, got %d", data[0])
+	}
+	b := testBatch()
+	b.Groups[0].Points[0].Unmatched = true
+	if data := e.Encode(b); data[0] != Version {
+		t.Errorf("batch with unmatched points should be version %d, got %d", Version, data[0])
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	var e Encoder
+	data := append([]byte(nil), e.Encode(testBatch())...)
+	for i := 0; i < len(data); i++ {
+		if _, err := Decode(data[:i]); err == nil {
+			t.Fatalf("truncated at %d should fail", i)
+		}
+	}
+	if _, err := Decode(append(data, 0)); err == nil {
+		t.Fatal("trailing bytes should fail")
+	}
+	bad := append([]byte(nil), data...)
+	bad[0] = Version + 1
+	if _, err := Decode(bad); err == nil {
+		t.Fatal("unknown version should fail")
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	var e Encoder
+	data := append([]byte(nil), e.Encode(testBatch())...)
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+	f.Add([]byte{Version, 1, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		b, err := Decode(data)
+		if err != nil {
+			return
+		}
+		// 能解出来的数据重新编码后应该一致
+		var e Encoder
+		got, err := Decode(e.Encode(b))
+		if err != nil || !reflect.DeepEqual(got, b) {
+			t.Fatalf("re-encode mismatch: %v", err)
+		}
+	})
+}
+
+type jsonPoint struct {
+	Metric    string            `json:"metric"`
+	Endpoint  string            `json:"endpoint"`
+	Tags      map[string]string `json:"tags"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+func BenchmarkEncode(b *testing.B) {
+	batch := testBatch()
+	n := 0
+	for _, grp := range batch.Groups {
+		n += len(grp.Points)
+	}
+	var e Encoder
+	b.Run("packed", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			size = len(e.Encode(batch))
+		}
+		b.ReportMetric(float64(size)/float64(n), "bytes/point")
+	})
+	b.Run("json", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			points := make([]jsonPoint, 0, n)
+			for _, grp := range batch.Groups {
+				for _, p := range grp.Points {
+					points = append(points, jsonPoint{grp.Metric, grp.Endpoint, grp.Tags, p.Value, p.Tms})
+				}
+			}
+			bs, _ := json.Marshal(points)
+			size = len(bs)
+		}
+		b.ReportMetric(float64(size)/float64(n), "bytes/point")
+	})
+}
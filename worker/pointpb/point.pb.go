@@ -0,0 +1,188 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: point.proto
+
+/*
+Package pointpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	point.proto
+
+It has these top-level messages:
+
+	AnalysPoint
+	PointBatch
+	Ack
+	PackedBatch
+*/
+package pointpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type AnalysPoint struct {
+	StrategyId int64             `protobuf:"varint,1,opt,name=strategy_id,json=strategyId" json:"strategy_id,omitempty"`
+	Value      float64           `protobuf:"fixed64,2,opt,name=value" json:"value,omitempty"`
+	Tms        int64             `protobuf:"varint,3,opt,name=tms" json:"tms,omitempty"`
+	Tags       map[string]string `protobuf:"bytes,4,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	LogTms     int64             `protobuf:"varint,5,opt,name=log_tms,json=logTms" json:"log_tms,omitempty"`
+	EventTms   int64             `protobuf:"varint,6,opt,name=event_tms,json=eventTms" json:"event_tms,omitempty"`
+	Unmatched  bool              `protobuf:"varint,7,opt,name=unmatched" json:"unmatched,omitempty"`
+}
+
+func (m *AnalysPoint) Reset()                    { *m = AnalysPoint{} }
+func (m *AnalysPoint) String() string            { return proto.CompactTextString(m) }
+func (*AnalysPoint) ProtoMessage()               {}
+func (*AnalysPoint) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *AnalysPoint) GetStrategyId() int64 {
+	if m != nil {
+		return m.StrategyId
+	}
+	return 0
+}
+
+func (m *AnalysPoint) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+func (m *AnalysPoint) GetTms() int64 {
+	if m != nil {
+		return m.Tms
+	}
+	return 0
+}
+
+func (m *AnalysPoint) GetTags() map[string]string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *AnalysPoint) GetLogTms() int64 {
+	if m != nil {
+		return m.LogTms
+	}
+	return 0
+}
+
+func (m *AnalysPoint) GetEventTms() int64 {
+	if m != nil {
+		return m.EventTms
+	}
+	return 0
+}
+
+func (m *AnalysPoint) GetUnmatched() bool {
+	if m != nil {
+		return m.Unmatched
+	}
+	return false
+}
+
+type PointBatch struct {
+	Seq    uint64         `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Points []*AnalysPoint `protobuf:"bytes,2,rep,name=points" json:"points,omitempty"`
+}
+
+func (m *PointBatch) Reset()                    { *m = PointBatch{} }
+func (m *PointBatch) String() string            { return proto.CompactTextString(m) }
+func (*PointBatch) ProtoMessage()               {}
+func (*PointBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PointBatch) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *PointBatch) GetPoints() []*AnalysPoint {
+	if m != nil {
+		return m.Points
+	}
+	return nil
+}
+
+type Ack struct {
+	Seq uint64 `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+}
+
+func (m *Ack) Reset()                    { *m = Ack{} }
+func (m *Ack) String() string            { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()               {}
+func (*Ack) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Ack) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+type PackedBatch struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *PackedBatch) Reset()                    { *m = PackedBatch{} }
+func (m *PackedBatch) String() string            { return proto.CompactTextString(m) }
+func (*PackedBatch) ProtoMessage()               {}
+func (*PackedBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *PackedBatch) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*AnalysPoint)(nil), "pointpb.AnalysPoint")
+	proto.RegisterType((*PointBatch)(nil), "pointpb.PointBatch")
+	proto.RegisterType((*Ack)(nil), "pointpb.Ack")
+	proto.RegisterType((*PackedBatch)(nil), "pointpb.PackedBatch")
+}
+
+func init() { proto.RegisterFile("point.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 338 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x52, 0x4f, 0x4b, 0xeb, 0x40,
+	0x10, 0x67, 0x93, 0x34, 0x6d, 0x26, 0x3d, 0x3c, 0xf6, 0x15, 0x1a, 0xfa, 0x1e, 0x1a, 0x73, 0xca,
+	0x41, 0xa2, 0x54, 0x50, 0xf1, 0x56, 0xc1, 0x83, 0xe0, 0xa1, 0x6c, 0x7b, 0x2f, 0xdb, 0x64, 0x89,
+	0x25, 0xff, 0x6a, 0x76, 0x5b, 0xc8, 0x27, 0xf1, 0xeb, 0xca, 0x4e, 0x6a, 0x53, 0xa9, 0xb7, 0xd9,
+	0x99, 0xdf, 0x5f, 0x58, 0x70, 0xb7, 0xd5, 0xa6, 0x54, 0xd1, 0xb6, 0xae, 0x54, 0x45, 0xfb, 0xf8,
+	0xd8, 0xae, 0x83, 0x4f, 0x03, 0xdc, 0x59, 0xc9, 0xf3, 0x46, 0xce, 0xf5, 0x86, 0x5e, 0x82, 0x2b,
+	0x55, 0xcd, 0x95, 0x48, 0x9b, 0xd5, 0x26, 0xf1, 0x88, 0x4f, 0x42, 0x93, 0xc1, 0xf7, 0xea, 0x35,
+	0xa1, 0x23, 0xe8, 0xed, 0x79, 0xbe, 0x13, 0x9e, 0xe1, 0x93, 0x90, 0xb0, 0xf6, 0x41, 0xff, 0x80,
+	0xa9, 0x0a, 0xe9, 0x99, 0x08, 0xd7, 0x23, 0x9d, 0x82, 0xa5, 0x78, 0x2a, 0x3d, 0xcb, 0x37, 0x43,
+	0x77, 0x7a, 0x11, 0x1d, 0x0c, 0xa3, 0x13, 0xb3, 0x68, 0xc9, 0x53, 0xf9, 0x52, 0xaa, 0xba, 0x61,
+	0x88, 0xa5, 0x63, 0xe8, 0xe7, 0x55, 0xba, 0xd2, 0x4a, 0x3d, 0x54, 0xb2, 0xf3, 0x2a, 0x5d, 0x16,
+	0x92, 0xfe, 0x03, 0x47, 0xec, 0x45, 0xa9, 0xf0, 0x64, 0xe3, 0x69, 0x80, 0x0b, 0x7d, 0xfc, 0x0f,
+	0xce, 0xae, 0x2c, 0xb8, 0x8a, 0xdf, 0x45, 0xe2, 0xf5, 0x7d, 0x12, 0x0e, 0x58, 0xb7, 0x98, 0x3c,
+	0x80, 0x73, 0xb4, 0xd1, 0x31, 0x33, 0xd1, 0x60, 0x2b, 0x87, 0xe9, 0xf1, 0x67, 0x1d, 0xe7, 0x50,
+	0xe7, 0xc9, 0x78, 0x24, 0xc1, 0x1b, 0x00, 0xa6, 0x7c, 0xd6, 0x42, 0x9a, 0x29, 0xc5, 0x07, 0x32,
+	0x2d, 0xa6, 0x47, 0x7a, 0x0d, 0x36, 0x76, 0x92, 0x9e, 0x81, 0x15, 0x47, 0xbf, 0x55, 0x64, 0x07,
+	0x4c, 0x30, 0x06, 0x73, 0x16, 0x67, 0xe7, 0x32, 0xc1, 0x15, 0xb8, 0x73, 0x1e, 0x67, 0x22, 0x69,
+	0x7d, 0x28, 0x58, 0x09, 0x57, 0x1c, 0x11, 0x43, 0x86, 0xf3, 0x54, 0x81, 0x83, 0x62, 0x8b, 0x4d,
+	0x99, 0xd1, 0x1b, 0xb0, 0x17, 0xaa, 0x16, 0xbc, 0xa0, 0x7f, 0x8f, 0x86, 0x5d, 0xce, 0xc9, 0xb0,
+	0x4b, 0x11, 0x67, 0x21, 0xb9, 0x25, 0xf4, 0x1e, 0x86, 0x2d, 0xa1, 0xb5, 0xa1, 0x5d, 0xce, 0x13,
+	0xdf, 0x73, 0xde, 0xda, 0xc6, 0x9f, 0x72, 0xf7, 0x35, 0x00, 0x9e, 0x79, 0xcf, 0xc9, 0x38, 0x02,
+	0x00, 0x00,
+}
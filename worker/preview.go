@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// 预览每页默认及最多的点数
+const (
+	DefaultPreviewLimit = 1000
+	MaxPreviewLimit     = 10000
+)
+
+// sensitiveTagReg tag名命中时预览中的取值替换为***, 与tap的脱敏规则一致
+var sensitiveTagReg = regexp.MustCompile(`(?i)(password|passwd|pwd|token|secret|api_?key|authorization)`)
+
+// PreviewFilter to select the points of preview
+type PreviewFilter struct {
+	StrategyID int64  //0表示全部
+	FilePath   string //为空表示全部
+	Metric     string //为空表示全部, 如log.nginx_500
+	Offset     int
+	Limit      int
+}
+
+// PreviewPoint is a point that will be pushed when its step is flushed
+type PreviewPoint struct {
+	StrategyID int64        `json:"strategy_id"`
+	FilePath   string       `json:"file_path"`
+	FlushAt    int64        `json:"flush_at"` //预计推送的时间
+	Point      *FalconPoint `json:"point"`
+}
+
+// PushPreview is the payload the push layer would produce if the periods ended now
+type PushPreview struct {
+	Preview     bool            `json:"preview"` //始终为true, 表示尚未推送
+	GeneratedAt int64           `json:"generated_at"`
+	Total       int             `json:"total"`
+	Offset      int             `json:"offset"`
+	Limit       int             `json:"limit"`
+	Points      []*PreviewPoint `json:"points"`
+}
+
+// estimateFlushAt to estimate when the step will be pushed
+// 与tmsNeedPush的条件一致: step结束并等待乱序差值后, 由下一次PusherLoop推送
+func estimateFlushAt(st *scheme.Strategy, tms int64, now time.Time) int64 {
+	_, delay, _ := GetLatestTmsAndDelay(st.FilePath)
+	ready := tms + st.Interval + pushDelay(st.Interval, delay)
+	last := atomic.LoadInt64(&lastPushLoop)
+	interval := int64(0)
+	if g.Conf() != nil {
+		interval = int64(g.Conf().Worker.PushInterval)
+	}
+	if last == 0 || interval <= 0 {
+		if ready < now.Unix() {
+			return now.Unix()
+		}
+		return ready
+	}
+	next := last + interval
+	for next < ready {
+		next += interval
+	}
+	return next
+}
+
+// previewCounters to copy the counters of strategies, with the points not handed to counter by workers
+func previewCounters(gc *GlobalCounter, f *PreviewFilter) map[*scheme.Strategy]map[int64]*PointsCounter {
+	ret := make(map[*scheme.Strategy]map[int64]*PointsCounter)
+	byID := make(map[int64]*scheme.Strategy)
+	for _, id := range gc.GetIDs() {
+		if f.StrategyID != 0 && id != f.StrategyID {
+			continue
+		}
+		sc, err := gc.GetStrategyCountByID(id)
+		if err != nil || sc.Strategy == nil {
+			continue
+		}
+		if f.FilePath != "" && sc.Strategy.FilePath != f.FilePath {
+			continue
+		}
+		steps := make(map[int64]*PointsCounter)
+		sc.RLock()
+		for tms, pc := range sc.TmsPoints {
+			steps[tms] = pc.clone()
+		}
+		sc.RUnlock()
+		ret[sc.Strategy] = steps
+		byID[id] = sc.Strategy
+	}
+
+	// worker内还没合入counter的点, 合入副本
+	for _, a := range getStepAggregators() {
+		for key, part := range a.snapshot() {
+			st, ok := byID[key.sid]
+			if !ok {
+				if (f.StrategyID != 0 && key.sid != f.StrategyID) || (f.FilePath != "" && part.st.FilePath != f.FilePath) {
+					continue
+				}
+				st = part.st
+				byID[key.sid] = st
+				ret[st] = make(map[int64]*PointsCounter)
+			}
+			pc, ok := ret[st][key.tms]
+			if !ok {
+				pc = &PointsCounter{TagstringMap: make(map[string]*PointCounter), maxTagSets: maxTagSets(st)}
+				ret[st][key.tms] = pc
+			}
+			pc.Merge(key.tagstring, &part.PointCounter)
+		}
+	}
+	return ret
+}
+
+// redactTags to hide the values of sensitive tags
+func redactTags(tagstring string) string {
+	if !sensitiveTagReg.MatchString(tagstring) {
+		return tagstring
+	}
+	tags := utils.DictedTagstring(tagstring)
+	for k := range tags {
+		if sensitiveTagReg.MatchString(k) {
+			tags[k] = "***"
+		}
+	}
+	return utils.SortedTags(tags)
+}
+
+// PreviewPush to render the points of all in-progress steps as they would be pushed now
+// 只读取counter的副本, 不影响正在累加的值; 按策略、时间、metric、tag排序后分页
+func PreviewPush(f PreviewFilter) *PushPreview {
+	return previewPush(GlobalCount, f, time.Now())
+}
+
+func previewPush(gc *GlobalCounter, f PreviewFilter, now time.Time) *PushPreview {
+	if f.Limit <= 0 {
+		f.Limit = DefaultPreviewLimit
+	}
+	if f.Limit > MaxPreviewLimit {
+		f.Limit = MaxPreviewLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	endpoint := pushEndpoint()
+	points := make([]*PreviewPoint, 0)
+	for st, steps := range previewCounters(gc, &f) {
+		for tms, pc := range steps {
+			// 与pushStep一致, 冷启动的第一个周期按first_period处理
+			action := coldStartAction(st, tms)
+			if action == scheme.FirstPeriodSuppress {
+				continue
+			}
+			flushAt := estimateFlushAt(st, tms, now)
+			emit := func(p *FalconPoint, _ map[string]string) {
+				if f.Metric != "" && p.Metric != f.Metric {
+					return
+				}
+				p.Tags = redactTags(p.Tags)
+				points = append(points, &PreviewPoint{StrategyID: st.ID, FilePath: st.FilePath, FlushAt: flushAt, Point: p})
+			}
+			if action == scheme.FirstPeriodPartialTag {
+				buildFalconPoints(st, tms, pc.TagstringMap, endpoint, partialEmit(emit))
+			} else {
+				buildFalconPoints(st, tms, pc.TagstringMap, endpoint, emit)
+			}
+			if p := overflowStatPoint(st, tms, pc, endpoint); p != nil {
+				emit(p, nil)
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.StrategyID != b.StrategyID {
+			return a.StrategyID < b.StrategyID
+		}
+		if a.Point.Timestamp != b.Point.Timestamp {
+			return a.Point.Timestamp < b.Point.Timestamp
+		}
+		if a.Point.Metric != b.Point.Metric {
+			return a.Point.Metric < b.Point.Metric
+		}
+		return strings.Compare(a.Point.Tags, b.Point.Tags) < 0
+	})
+
+	ret := &PushPreview{
+		Preview:     true,
+		GeneratedAt: now.Unix(),
+		Total:       len(points),
+		Offset:      f.Offset,
+		Limit:       f.Limit,
+		Points:      []*PreviewPoint{},
+	}
+	if f.Offset < len(points) {
+		end := f.Offset + f.Limit
+		if end > len(points) {
+			end = len(points)
+		}
+		ret.Points = points[f.Offset:end]
+	}
+	return ret
+}
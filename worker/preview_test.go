@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func previewTestCounter() (*GlobalCounter, []*scheme.Strategy) {
+	sts := []*scheme.Strategy{
+		{ID: 1, Name: "latency", FilePath: "/var/log/a.log", Interval: 10, Func: "avg", Degree: 2},
+		{ID: 2, Name: "errors", FilePath: "/var/log/b.log", Interval: 10, Func: "cnt"},
+	}
+	gc := NewGlobalCounter(2)
+	for _, st := range sts {
+		gc.AddStrategyCount(st)
+	}
+	return gc, sts
+}
+
+// drainPushQueue to get the points actually produced by ToPushQueue
+func drainPushQueue() []*FalconPoint {
+	var ret []*FalconPoint
+	for {
+		select {
+		case p := <-pushQueue:
+			ret = append(ret, p)
+		default:
+			return ret
+		}
+	}
+}
+
+func pointJSON(t *testing.T, ps []*FalconPoint) map[string]string {
+	ret := make(map[string]string)
+	for _, p := range ps {
+		bs, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[p.Metric+"/"+p.Tags] = string(bs)
+	}
+	return ret
+}
+
+func TestPreviewPushMatchesFlush(t *testing.T) {
+	drainPushQueue()
+	gc, sts := previewTestCounter()
+	tms := int64(1500000000)
+	for i, v := range []float64{10, 20, 35} {
+		gc.Push(&AnalysPoint{StrategyID: 1, Value: v, Tms: tms + int64(i), Tags: map[string]string{"api": "/login"}})
+	}
+	gc.Push(&AnalysPoint{StrategyID: 2, Value: 1, Tms: tms + 3, Tags: map[string]string{"code": "500"}})
+
+	// 周期中途的worker内未合入counter的点也要包含
+	a := newStepAggregator(gc)
+	a.add(sts[1], &AnalysPoint{StrategyID: 2, Value: 1, Tms: tms + 5, Tags: map[string]string{"code": "500"}})
+	a.add(sts[1], &AnalysPoint{StrategyID: 2, Value: 1, Tms: tms + 6, Tags: map[string]string{"code": "502"}})
+	registerStepAggregator(a)
+	defer unregisterStepAggregator(a)
+
+	before := dumpCounter(gc)
+	preview := previewPush(gc, PreviewFilter{}, time.Unix(tms+7, 0))
+	if !preview.Preview || preview.Total != 3 || len(preview.Points) != 3 {
+		t.Fatalf("preview %+v, want 3 points", preview)
+	}
+	if after := dumpCounter(gc); !reflect.DeepEqual(before, after) {
+		t.Errorf("preview changed counter\nbefore %v\nafter  %v", before, after)
+	}
+	if a.pending() != 2 {
+		t.Errorf("preview should not flush the aggregator")
+	}
+	for _, p := range preview.Points {
+		if p.FlushAt < tms+10 {
+			t.Errorf("flush_at %d before the end of step", p.FlushAt)
+		}
+	}
+
+	// 实际推送
+	flushStepAggregators(nil)
+	for _, st := range sts {
+		sc, _ := gc.GetStrategyCountByID(st.ID)
+		pc, _ := sc.GetByTms(tms)
+		ToPushQueue(st, tms, pc.TagstringMap)
+	}
+	want := pointJSON(t, drainPushQueue())
+	var previewed []*FalconPoint
+	for _, p := range preview.Points {
+		previewed = append(previewed, p.Point)
+	}
+	if got := pointJSON(t, previewed); !reflect.DeepEqual(want, got) {
+		t.Errorf("preview differs from flush\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestPreviewPushFilter(t *testing.T) {
+	gc, _ := previewTestCounter()
+	for i := 0; i < 5; i++ {
+		gc.Push(&AnalysPoint{StrategyID: 2, Value: 1, Tms: 1500000000, Tags: map[string]string{"code": string(rune('0' + i))}})
+	}
+	gc.Push(&AnalysPoint{StrategyID: 1, Value: 1, Tms: 1500000000, Tags: map[string]string{"token": "abc"}})
+	now := time.Unix(1500000005, 0)
+
+	if p := previewPush(gc, PreviewFilter{StrategyID: 2}, now); p.Total != 5 {
+		t.Errorf("strategy filter: total %d, want 5", p.Total)
+	}
+	if p := previewPush(gc, PreviewFilter{FilePath: "/var/log/a.log"}, now); p.Total != 1 {
+		t.Errorf("file filter: total %d, want 1", p.Total)
+	}
+	if p := previewPush(gc, PreviewFilter{Metric: "log.errors"}, now); p.Total != 5 {
+		t.Errorf("metric filter: total %d, want 5", p.Total)
+	}
+
+	page := previewPush(gc, PreviewFilter{StrategyID: 2, Offset: 3, Limit: 2}, now)
+	if page.Total != 5 || len(page.Points) != 2 || page.Points[0].Point.Tags != "code=3" {
+		t.Errorf("page %+v, want code=3,code=4", page.Points)
+	}
+	if page := previewPush(gc, PreviewFilter{Offset: 100}, now); len(page.Points) != 0 || page.Points == nil {
+		t.Errorf("page beyond total should be empty")
+	}
+
+	p := previewPush(gc, PreviewFilter{StrategyID: 1}, now)
+	if len(p.Points) != 1 || p.Points[0].Point.Tags != "token=***" {
+		t.Errorf("sensitive tag not redacted: %+v", p.Points[0].Point)
+	}
+}
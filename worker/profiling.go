@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// profiling to check whether CPU profile of workers is labeled by strategy
+func profiling() bool {
+	return g.Conf() != nil && g.Conf().Profiling.Continuous
+}
+
+type labelKey struct {
+	id   int64
+	path string
+}
+
+// 每个策略、文件的label只生成一次, 避免每行都分配
+var strategyLabels sync.Map
+
+// setStrategyLabels to label the current goroutine with the strategy being evaluated
+// CPU profile中的样本带上strategy_id、file_path, 可在go tool pprof(-tagfocus)或Pyroscope中按策略下钻
+func setStrategyLabels(id int64, path string) {
+	key := labelKey{id, path}
+	ctx, ok := strategyLabels.Load(key)
+	if !ok {
+		ctx, _ = strategyLabels.LoadOrStore(key, pprof.WithLabels(context.Background(),
+			pprof.Labels("strategy_id", strconv.FormatInt(id, 10), "file_path", path)))
+	}
+	pprof.SetGoroutineLabels(ctx.(context.Context))
+}
+
+// clearStrategyLabels to remove labels of the current goroutine
+func clearStrategyLabels() {
+	pprof.SetGoroutineLabels(context.Background())
+}
+
+// cleanStrategyLabels to drop labels of deleted strategies
+func cleanStrategyLabels(strategyMap map[int64]*scheme.Strategy) {
+	strategyLabels.Range(func(k, v interface{}) bool {
+		if st, ok := strategyMap[k.(labelKey).id]; !ok || st.FilePath != k.(labelKey).path {
+			strategyLabels.Delete(k)
+		}
+		return true
+	})
+}
@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestStrategyLabels(t *testing.T) {
+	defer clearStrategyLabels()
+	setStrategyLabels(7, "/tmp/a.log")
+	setStrategyLabels(7, "/tmp/a.log")
+	setStrategyLabels(8, "/tmp/a.log")
+
+	v, ok := strategyLabels.Load(labelKey{7, "/tmp/a.log"})
+	if !ok {
+		t.Fatal("labels should be cached")
+	}
+	ctx := v.(context.Context)
+	if id, _ := pprof.Label(ctx, "strategy_id"); id != "7" {
+		t.Fatalf("unexpected strategy_id label %q", id)
+	}
+	if path, _ := pprof.Label(ctx, "file_path"); path != "/tmp/a.log" {
+		t.Fatalf("unexpected file_path label %q", path)
+	}
+
+	// 策略删除或换了文件后清理
+	cleanStrategyLabels(map[int64]*scheme.Strategy{7: {ID: 7, FilePath: "/tmp/b.log"}})
+	n := 0
+	strategyLabels.Range(func(k, v interface{}) bool { n++; return true })
+	if n != 0 {
+		t.Fatalf("stale labels should be dropped, %d left", n)
+	}
+}
@@ -1,20 +1,19 @@
 package worker
 
 import (
-	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
 	"github.com/didi/falcon-log-agent/common/g"
 	"github.com/didi/falcon-log-agent/common/scheme"
 	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/strategy"
 
 	"github.com/didi/falcon-log-agent/common/proc/metric"
-
-	"github.com/parnurzeal/gorequest"
 )
 
 // FalconPoint to push to falcon-agent
@@ -26,6 +25,7 @@ type FalconPoint struct {
 	Value       float64 `json:"value"`
 	CounterType string  `json:"counterType"`
 	Tags        string  `json:"tags"`
+	StrategyID  int64   `json:"-"` //只用于审计日志, 不推送
 }
 
 // SortByTms to be used by sort
@@ -37,6 +37,9 @@ func (p SortByTms) Less(i, j int) bool { return p[i].Timestamp < p[j].Timestamp
 
 var pushQueue chan *FalconPoint
 
+// lastPushLoop PusherLoop上次检查的时间, 用于预估各step的推送时间
+var lastPushLoop int64
+
 func init() {
 	//拍一个队列大小,10s一清
 	pushQueue = make(chan *FalconPoint, 1024*100)
@@ -44,6 +47,10 @@ func init() {
 
 // PusherStart to start push loop
 func PusherStart() {
+	StartPushResolver()
+	if g.Conf().Worker.PushCompression == PushCompressionAuto {
+		ProbeEndpoint(pushURL())
+	}
 	PosterLoop() //归类，批量发送给odin-agent
 	PusherLoop() //计算，推送给发送队列
 }
@@ -52,6 +59,8 @@ func PusherStart() {
 // 循环推送，10s一次
 func PosterLoop() {
 	dlog.Info("PosterLoop Start")
+	pool := NewPushWorkerPool(pushWorkers(), postToFalconAgent)
+	pool.Start()
 	go func() {
 		for {
 			select {
@@ -70,8 +79,8 @@ func PosterLoop() {
 				}
 				//先推到cache中
 				PostToCache(points)
-				//开一个协程，异步发送至odin-agent
-				go postToFalconAgent(points)
+				//交给推送协程, 异步发送至odin-agent
+				pool.Submit(points)
 			}
 			time.Sleep(10 * time.Second)
 		}
@@ -82,22 +91,29 @@ func PosterLoop() {
 func PusherLoop() {
 	dlog.Info("PushLoop Start")
 	for {
+		atomic.StoreInt64(&lastPushLoop, time.Now().Unix())
+		// worker内按step合并的点先合入counter, 只合入即将推送的step
+		flushStepAggregators(func(st *scheme.Strategy, tms int64) bool {
+			return tmsNeedPush(tms, st.FilePath, st.Interval)
+		})
 		gIds := GlobalCount.GetIDs()
 		for _, id := range gIds {
 			stCount, err := GlobalCount.GetStrategyCountByID(id)
-			step := stCount.Strategy.Interval
-
-			filePath := stCount.Strategy.FilePath
 			if err != nil {
 				dlog.Errorf("get strategy count by id error : %v", err)
 				continue
 			}
+			step := stCount.Strategy.Interval
+			filePath := stCount.Strategy.FilePath
 			tmsList := stCount.GetTmsList()
 			for _, tms := range tmsList {
 				if tmsNeedPush(tms, filePath, step) {
 					pointsCount, err := stCount.GetByTms(tms)
 					if err == nil {
-						ToPushQueue(stCount.Strategy, tms, pointsCount.TagstringMap)
+						pushStep(stCount.Strategy, tms, pointsCount, g.Conf().Endpoint)
+						if rg := getReplayGuard(filePath); rg != nil {
+							rg.seal(id, tms)
+						}
 					} else {
 						dlog.Errorf("get by tms [%d] error : %v", tms, err)
 					}
@@ -105,6 +121,9 @@ func PusherLoop() {
 				}
 			}
 		}
+		pushTombstones(strategy.GetAll(), time.Now(), g.Conf().Endpoint)
+		pushExcludeRatios(g.Conf().Endpoint)
+		pushSlidingWindows(time.Now().Unix(), g.Conf().Endpoint)
 		time.Sleep(time.Second * time.Duration(g.Conf().Worker.PushInterval))
 	}
 }
@@ -114,10 +133,23 @@ func tmsNeedPush(tms int64, filePath string, step int64) bool {
 	// 如果日志时间戳没有乱序, 那么小于该窗口的点都可以push
 	latest, delay, found := GetLatestTmsAndDelay(filePath)
 
+	// 聚合端没有读该文件, 按收到的点的时间判断
+	if !found {
+		latest, found = getRemoteLatest(filePath)
+	}
 	if !found {
 		return true
 	}
 
+	if tms < AlignStepTms(step, latest-pushDelay(step, delay)) {
+		return true
+	}
+
+	return false
+}
+
+// pushDelay to cap the out-of-order delay waited before pushing a step
+func pushDelay(step, delay int64) int64 {
 	// 为解决日志时间戳乱序的最大等待时间, hard code
 	// delay == 0时, 不用额外等待, 进而提高时效性
 	if delay > 0 {
@@ -133,23 +165,67 @@ func tmsNeedPush(tms int64, filePath string, step int64) bool {
 			delay = maxDelay
 		}
 	}
-
-	if tms < AlignStepTms(step, latest-delay) {
-		return true
-	}
-
-	return false
+	return delay
 }
 
 // ToPushQueue to push data to pusher queue
 // 这个参数是为了最大限度的对接
 // pointMap的key，是打平了的tagkv
 func ToPushQueue(strategy *scheme.Strategy, tms int64, pointMap map[string]*PointCounter) error {
-	for tagstring, PointCounter := range pointMap {
+	return buildFalconPoints(strategy, tms, pointMap, pushEndpoint(), pushEmit(strategy, tms))
+}
+
+// pushEmit to send a built point to the push queue and the registered sinks
+func pushEmit(strategy *scheme.Strategy, tms int64) func(p *FalconPoint, tags map[string]string) {
+	return func(p *FalconPoint, tags map[string]string) {
+		p.StrategyID = strategy.ID
+		pushQueue <- p
+		// endpoint取自tag时, sink以点的endpoint tag为准
+		if p.Endpoint != pushEndpoint() {
+			tags = withEndpointTag(tags, p.Endpoint)
+		}
+		// 同一个点发往同一组sink, 按登记顺序; 只有发往外部的sink加噪, 内部的始终是精确值, 多个外部sink收到同一个加噪值
+		getExtensions().Deliver(ExtensionSink, func(sinks []*Extension) {
+			if len(sinks) == 0 {
+				return
+			}
+			exact := &AnalysPoint{StrategyID: strategy.ID, Value: p.Value, Tms: tms, Tags: tags}
+			var noised *AnalysPoint
+			for _, sink := range sinks {
+				if !sink.External || strategy.Noise == nil {
+					sink.Send(exact)
+					continue
+				}
+				if noised == nil {
+					noised = &AnalysPoint{StrategyID: strategy.ID, Value: noisedValue(strategy, tms, p), Tms: tms, Tags: tags}
+				}
+				sink.Send(noised)
+			}
+		})
+	}
+}
+
+// pushEndpoint to get the endpoint of pushed points
+func pushEndpoint() string {
+	if g.Conf() == nil {
+		return ""
+	}
+	return g.Conf().Endpoint
+}
+
+// buildFalconPoints to convert the counters of a step to the points pushed to falcon
+// 推送和/v1/push/preview共用, 保证预览与实际推送的内容一致; NaN及Inf的值不推送
+func buildFalconPoints(strategy *scheme.Strategy, tms int64, pointMap map[string]*PointCounter, hostname string, emit func(p *FalconPoint, tags map[string]string)) error {
+	for _, series := range stepSeries(strategy, pointMap, hostname) {
+		PointCounter := series.counter
 		var value float64
 		switch strategy.Func {
-		case "cnt":
+		case "cnt", scheme.FuncEpisodes:
 			value = float64(PointCounter.Count)
+			// catch-all推送每秒没有被其他策略匹配的行数
+			if strategy.CatchAll && strategy.Interval > 0 {
+				value = getPrecision(value/float64(strategy.Interval), strategy.Degree)
+			}
 		case "avg":
 			if PointCounter.Count == 0 {
 				//这种就不用往监控推了
@@ -169,28 +245,17 @@ func ToPushQueue(strategy *scheme.Strategy, tms int64, pointMap map[string]*Poin
 			return fmt.Errorf("Strategy Func Error: %s ", strategy.Func)
 		}
 
-		var tags map[string]string
-		if tagstring == "null" {
-			tags = make(map[string]string, 0)
-		} else {
-			tags = utils.DictedTagstring(tagstring)
-		}
-
-		//hostname, err := utils.GetEndpoint(g.Conf().Endpoint)
-		hostname := fmt.Sprintf(g.Conf().Endpoint)
-		//hostname, err := fmt.Sprintf(g.Conf().Endpoint)
-		/*if err != nil {
-			dlog.Errorf("cannot get hostname : %v", err)
-			return err
-		}
-		*/
-
-		if math.IsNaN(value) {
+		tags := series.tags
+		if math.IsNaN(value) || math.IsInf(value, 0) {
 			continue
 		}
+		// 周期内策略更新过, 值混合了新旧两种定义
+		if PointCounter.Gens.Mixed() && generationMixedTag() {
+			tags[GenerationMixedTagk] = "true"
+		}
 
 		tmpPoint := &FalconPoint{
-			Endpoint:    hostname,
+			Endpoint:    series.endpoint,
 			Metric:      "log."+ strategy.Name,
 			Timestamp:   tms,
 			Step:        strategy.Interval,
@@ -198,7 +263,7 @@ func ToPushQueue(strategy *scheme.Strategy, tms int64, pointMap map[string]*Poin
 			Tags:        utils.SortedTags(tags),
 			CounterType: "GAUGE",
 		}
-		pushQueue <- tmpPoint
+		emit(tmpPoint, tags)
 	}
 
 	return nil
@@ -208,7 +273,8 @@ func postToFalconAgent(paramPoints []*FalconPoint) {
 
 	sort.Sort(SortByTms(paramPoints))
 
-	param, err := json.Marshal(&paramPoints)
+	format := pushSerialization()
+	param, contentType, err := marshalPushPoints(paramPoints, format)
 
 	start := time.Now()
 	num := int64(len(paramPoints))
@@ -218,16 +284,27 @@ func postToFalconAgent(paramPoints []*FalconPoint) {
 		return
 	}
 
-	dlog.Infof("to falcon agent: %s", string(param))
+	if format == PushSerializationJSON {
+		dlog.Infof("to falcon agent: %s", string(param))
+	} else {
+		dlog.Infof("to falcon agent: [points:%d][format:%s][bytes:%d]", num, format, len(param))
+	}
 
-	url := fmt.Sprintf(g.Conf().Worker.PushURL)
+	url := pushURL()
 
-	resp, body, errs := gorequest.New().Post(url).
-		Timeout(10 * time.Second).
-		Send(string(param)).
-		End()
+	resp, body, errs := sendPushWithRetry(url, param, contentType, g.Conf().Worker.PushCompression, getPushRetryPolicy())
 
 	metric.MetricPushLatency(int64(time.Now().Sub(start) / time.Second))
+	if a := getAuditLogger(); a != nil {
+		code, errMsg := 0, ""
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		if errs != nil {
+			errMsg = fmt.Sprint(errs)
+		}
+		a.Record(paramPoints, url, code, time.Since(start), errMsg)
+	}
 
 	if errs != nil {
 		dlog.Errorf("Post to falcon agent Request err : %s", errs)
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// pushWorkers to get the number of goroutines posting to falcon-agent, default 1
+func pushWorkers() int {
+	if g.Conf() != nil && g.Conf().Worker.PushWorkers > 0 {
+		return g.Conf().Worker.PushWorkers
+	}
+	return 1
+}
+
+// PushWorkerPool to post batches of points to falcon-agent by a fixed number of goroutines
+// falcon-agent单次请求延迟高但接受并发连接时, 一批点拆成多份由多个协程同时推送;
+// 协程都在忙时Submit等待, 点在pushQueue中积压, 不再为每批点开一个协程
+type PushWorkerPool struct {
+	workers int
+	batches chan []*FalconPoint
+	post    func([]*FalconPoint)
+	wg      sync.WaitGroup
+}
+
+// NewPushWorkerPool to create a pool of workers goroutines calling post
+func NewPushWorkerPool(workers int, post func([]*FalconPoint)) *PushWorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &PushWorkerPool{
+		workers: workers,
+		batches: make(chan []*FalconPoint, workers),
+		post:    post,
+	}
+}
+
+// Start to start the goroutines
+func (p *PushWorkerPool) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for points := range p.batches {
+				p.post(points)
+			}
+		}()
+	}
+}
+
+// Submit to split the points into one part per worker and queue them
+func (p *PushWorkerPool) Submit(points []*FalconPoint) {
+	for _, part := range splitPushBatch(points, p.workers) {
+		p.batches <- part
+	}
+}
+
+// Stop to wait for the queued batches to be posted, Submit must not be called after it
+func (p *PushWorkerPool) Stop() {
+	close(p.batches)
+	p.wg.Wait()
+}
+
+// splitPushBatch to split points ordered by timestamp into at most n parts of nearly equal size
+// 按时间排序后切分, 每份的时间范围连续
+func splitPushBatch(points []*FalconPoint, n int) [][]*FalconPoint {
+	if len(points) == 0 {
+		return nil
+	}
+	sort.Stable(SortByTms(points))
+	if n > len(points) {
+		n = len(points)
+	}
+	if n <= 1 {
+		return [][]*FalconPoint{points}
+	}
+	ret := make([][]*FalconPoint, 0, n)
+	size, extra := len(points)/n, len(points)%n
+	for start := 0; start < len(points); {
+		end := start + size
+		if len(ret) < extra {
+			end++
+		}
+		ret = append(ret, points[start:end])
+		start = end
+	}
+	return ret
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSplitPushBatch(t *testing.T) {
+	points := make([]*FalconPoint, 0)
+	for i := 10; i > 0; i-- {
+		points = append(points, &FalconPoint{Timestamp: int64(i)})
+	}
+	parts := splitPushBatch(points, 4)
+	sizes := []int{}
+	var last int64
+	for _, part := range parts {
+		sizes = append(sizes, len(part))
+		for _, p := range part {
+			if p.Timestamp < last {
+				t.Fatal("parts should be ordered by timestamp")
+			}
+			last = p.Timestamp
+		}
+	}
+	if len(sizes) != 4 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 2 || sizes[3] != 2 {
+		t.Errorf("unexpected part sizes %v", sizes)
+	}
+
+	if parts := splitPushBatch(points[:2], 8); len(parts) != 2 {
+		t.Errorf("should not create empty parts, got %d", len(parts))
+	}
+	if parts := splitPushBatch(points, 1); len(parts) != 1 || len(parts[0]) != 10 {
+		t.Error("one worker should post the whole batch")
+	}
+	if parts := splitPushBatch(nil, 4); len(parts) != 0 {
+		t.Error("empty batch should not be posted")
+	}
+}
+
+func TestPushWorkerPoolConcurrent(t *testing.T) {
+	var active, maxActive, posted int32
+	var lock sync.Mutex
+	pool := NewPushWorkerPool(4, func(points []*FalconPoint) {
+		n := atomic.AddInt32(&active, 1)
+		lock.Lock()
+		if n > maxActive {
+			maxActive = n
+		}
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond) //模拟falcon-agent的请求延迟
+		atomic.AddInt32(&posted, int32(len(points)))
+		atomic.AddInt32(&active, -1)
+	})
+	pool.Start()
+
+	points := make([]*FalconPoint, 100)
+	for i := range points {
+		points[i] = &FalconPoint{Timestamp: int64(i)}
+	}
+	start := time.Now()
+	pool.Submit(points)
+	pool.Stop()
+	if posted != 100 {
+		t.Errorf("all points should be posted, got %d", posted)
+	}
+	if maxActive != 4 {
+		t.Errorf("parts should be posted concurrently, max active %d", maxActive)
+	}
+	if cost := time.Since(start); cost > 150*time.Millisecond {
+		t.Errorf("concurrent posting should take about one request latency, took %v", cost)
+	}
+}
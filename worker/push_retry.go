@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+// 推送falcon-agent失败时重试的默认退避
+const (
+	DefaultPushBackoffBase   = time.Second
+	DefaultPushBackoffMax    = 30 * time.Second
+	DefaultPushBackoffJitter = 0.2
+)
+
+// pushRetryPolicy to retry posting points to falcon-agent
+type pushRetryPolicy struct {
+	MaxRetries            int //0不重试
+	BackoffBase           time.Duration
+	BackoffMax            time.Duration
+	BackoffJitterFraction float64 //乘性抖动, 实际等待在[d*(1-j), d*(1+j)]之间
+}
+
+// getPushRetryPolicy to get the policy of worker.push_max_retries etc.
+func getPushRetryPolicy() *pushRetryPolicy {
+	p := &pushRetryPolicy{
+		BackoffBase:           DefaultPushBackoffBase,
+		BackoffMax:            DefaultPushBackoffMax,
+		BackoffJitterFraction: DefaultPushBackoffJitter,
+	}
+	if g.Conf() == nil {
+		return p
+	}
+	wc := g.Conf().Worker
+	p.MaxRetries = wc.PushMaxRetries
+	if wc.PushBackoffBaseMs > 0 {
+		p.BackoffBase = time.Duration(wc.PushBackoffBaseMs) * time.Millisecond
+	}
+	if wc.PushBackoffMaxMs > 0 {
+		p.BackoffMax = time.Duration(wc.PushBackoffMaxMs) * time.Millisecond
+	}
+	if wc.PushBackoffJitter != nil {
+		p.BackoffJitterFraction = *wc.PushBackoffJitter
+	}
+	return p
+}
+
+// backoff to get the wait before the n-th retry, n starts from 0
+// 指数退避到BackoffMax后, 再乘以[1-j, 1+j]内的随机数, 避免大量worker在falcon-agent恢复时同时重试
+func (p *pushRetryPolicy) backoff(n int, random func() float64) time.Duration {
+	d := p.BackoffBase
+	for i := 0; i < n && d < p.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > p.BackoffMax {
+		d = p.BackoffMax
+	}
+	j := p.BackoffJitterFraction
+	if j < 0 {
+		j = 0
+	}
+	if j > 1 {
+		j = 1
+	}
+	return time.Duration(float64(d) * (1 - j + 2*j*random()))
+}
+
+// pushRetryable to check whether the push failure may succeed later
+// 网络错误、429及5xx重试, 其他4xx重试也不会成功
+func pushRetryable(code int, errs []error) bool {
+	return errs != nil || code == 429 || code >= 500
+}
+
+// pushSleep to wait between retries, replaced in test
+var pushSleep = time.Sleep
+
+// pushRand to draw the jitter, replaced in test
+var pushRand = rand.Float64
+
+// pushSend to post the payload once, replaced in test
+var pushSend = sendPush
+
+// sendPushWithRetry to post the payload, retrying by the policy
+func sendPushWithRetry(url string, payload []byte, contentType, compression string, p *pushRetryPolicy) (gorequest.Response, string, []error) {
+	for n := 0; ; n++ {
+		resp, body, errs := pushSend(url, payload, contentType, compression)
+		code := 0
+		if errs == nil {
+			code = resp.StatusCode
+		}
+		if (errs == nil && code == 200) || n >= p.MaxRetries || !pushRetryable(code, errs) {
+			return resp, body, errs
+		}
+		wait := p.backoff(n, pushRand)
+		dlog.Warningf("post to falcon agent failed, retry in %v [url:%s][retry:%d/%d][code:%d][errs:%v]", wait, url, n+1, p.MaxRetries, code, errs)
+		pushSleep(wait)
+	}
+}
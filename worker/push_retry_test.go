@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+func TestPushBackoffJitterBounds(t *testing.T) {
+	p := &pushRetryPolicy{BackoffBase: time.Second, BackoffMax: 8 * time.Second, BackoffJitterFraction: 0.25}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	r := rand.New(rand.NewSource(1))
+	for n, d := range want {
+		lo, hi := time.Duration(float64(d)*0.75), time.Duration(float64(d)*1.25)
+		if got := p.backoff(n, func() float64 { return 0 }); got != lo {
+			t.Errorf("retry %d: lowest %v, want %v", n, got, lo)
+		}
+		if got := p.backoff(n, func() float64 { return 1 }); got != hi {
+			t.Errorf("retry %d: highest %v, want %v", n, got, hi)
+		}
+		var min, max time.Duration
+		for i := 0; i < 1000; i++ {
+			got := p.backoff(n, r.Float64)
+			if got < lo || got > hi {
+				t.Fatalf("retry %d: %v out of [%v, %v]", n, got, lo, hi)
+			}
+			if i == 0 || got < min {
+				min = got
+			}
+			if got > max {
+				max = got
+			}
+		}
+		// 抖动应覆盖区间的大部分, 而不是集中在某个值
+		if max-min < (hi-lo)*8/10 {
+			t.Errorf("retry %d: jitter spread [%v, %v] too narrow", n, min, max)
+		}
+	}
+
+	p.BackoffJitterFraction = 0
+	if got := p.backoff(1, r.Float64); got != 2*time.Second {
+		t.Errorf("no jitter: %v, want 2s", got)
+	}
+}
+
+func TestSendPushWithRetry(t *testing.T) {
+	defer func(send func(string, []byte, string, string) (gorequest.Response, string, []error), sleep func(time.Duration)) {
+		pushSend, pushSleep = send, sleep
+	}(pushSend, pushSleep)
+
+	var waits []time.Duration
+	pushSleep = func(d time.Duration) { waits = append(waits, d) }
+	p := &pushRetryPolicy{MaxRetries: 3, BackoffBase: time.Second, BackoffMax: time.Minute}
+
+	cases := []struct {
+		results  []interface{} //状态码或error
+		attempts int
+		ok       bool
+	}{
+		{[]interface{}{200}, 1, true},
+		{[]interface{}{503, errors.New("connection refused"), 200}, 3, true},
+		{[]interface{}{429, 200}, 2, true},
+		{[]interface{}{400}, 1, false}, //4xx不重试
+		{[]interface{}{500, 500, 500, 500, 200}, 4, false},
+	}
+	for i, c := range cases {
+		attempts := 0
+		waits = nil
+		pushSend = func(string, []byte, string, string) (gorequest.Response, string, []error) {
+			r := c.results[attempts]
+			attempts++
+			if err, ok := r.(error); ok {
+				return nil, "", []error{err}
+			}
+			return &http.Response{StatusCode: r.(int)}, "", nil
+		}
+		resp, _, errs := sendPushWithRetry("http://falcon", nil, contentTypeJSON, "", p)
+		ok := errs == nil && resp.StatusCode == 200
+		if attempts != c.attempts || ok != c.ok || len(waits) != attempts-1 {
+			t.Errorf("case %d: attempts %d ok %v waits %v, want %d %v", i, attempts, ok, waits, c.attempts, c.ok)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// TestTagOrderPayload checks that the same tags inserted in different orders push one series with the same payload
+func TestTagOrderPayload(t *testing.T) {
+	keys := []string{"api", "code", "dc", "host", "method", "region", "tenant", "zone"}
+	forward := make(map[string]string, len(keys))
+	for _, k := range keys {
+		forward[k] = k + "-v"
+	}
+	reverse := make(map[string]string, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		reverse[keys[i]] = keys[i] + "-v"
+	}
+
+	payload := func(tagMaps ...map[string]string) string {
+		gc := NewGlobalCounter(1)
+		st := &scheme.Strategy{ID: 1, Name: "req", Interval: 10, Func: "cnt"}
+		gc.AddStrategyCount(st)
+		for _, tags := range tagMaps {
+			// 每个点用独立的map, 与producer一致
+			copied := make(map[string]string, len(tags))
+			for k, v := range tags {
+				copied[k] = v
+			}
+			if err := gc.Push(&AnalysPoint{StrategyID: 1, Value: 1, Tms: 1500000000, Tags: copied}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sc, _ := gc.GetStrategyCountByID(1)
+		pc, _ := sc.GetByTms(1500000000)
+		var points []*FalconPoint
+		buildFalconPoints(st, 1500000000, pc.TagstringMap, "host", func(p *FalconPoint, tags map[string]string) {
+			points = append(points, p)
+		})
+		bs, _ := json.Marshal(points)
+		return string(bs)
+	}
+
+	// 不同插入顺序的tag合并为同一个序列
+	mixed := payload(forward, reverse, forward, reverse)
+	want := `[{"endpoint":"host","metric":"log.req","timestamp":1500000000,"step":10,"value":4,` +
+		`"counterType":"GAUGE","tags":"api=api-v,code=code-v,dc=dc-v,host=host-v,method=method-v,region=region-v,tenant=tenant-v,zone=zone-v"}]`
+	if mixed != want {
+		t.Fatalf("payload = %s, want %s", mixed, want)
+	}
+	for i := 0; i < 20; i++ {
+		if a, b := payload(forward), payload(reverse); a != b {
+			t.Fatalf("payloads differ by insertion order:\n%s\n%s", a, b)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// pointLimiter to limit points pushed to counter per second
+type pointLimiter interface {
+	Allow() bool
+}
+
+// LocalRateLimiter to limit points per second within this agent
+// 令牌桶, 每秒补充limit个令牌, 桶容量为burst, 可以吸收短时间的突发
+type LocalRateLimiter struct {
+	sync.Mutex
+	limit  float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLocalRateLimiter to create a local limiter
+// burst <= 0 时桶容量等于limit, 即最多允许一秒的量
+func NewLocalRateLimiter(limit, burst int64) *LocalRateLimiter {
+	if burst <= 0 {
+		burst = limit
+	}
+	return &LocalRateLimiter{
+		limit:  float64(limit),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// Allow to take one token
+func (l *LocalRateLimiter) Allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.limit
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+const (
+	// 每次从redis预取的配额, 避免每个点都访问redis
+	defaultRedisRateBatch = 10
+	// redis不可用后, 这段时间内直接使用本地限速, 不再重连; 连续失败时翻倍, 最多redisMaxRetryInterval
+	redisRetryInterval    = 5 * time.Second
+	redisMaxRetryInterval = time.Minute
+)
+
+// RedisRateLimiter to limit points per second across agents sharing a redis
+// 多个agent处理同一个文件(NFS等)时, 以 key:{秒} 为计数器INCRBY, 第一次创建时EXPIRE,
+// 每次预取batch个配额, redis不可用时退化为本地限速
+// 共享配额按秒计数, burst只作用于退化后的本地限速
+// 锁只保护本地状态, 访问redis时不持有; 同一时刻只有一个调用访问redis, 其他调用等待其结果
+type RedisRateLimiter struct {
+	sync.Mutex
+	Addr     string
+	Password string
+	Key      string
+	Limit    int64
+	Batch    int64
+	Timeout  time.Duration
+
+	// conn及rd只由持有inflight的调用使用
+	conn net.Conn
+	rd   *bufio.Reader
+
+	second    int64
+	tokens    int64
+	exhausted bool          //本秒的共享配额已用完, 到下一秒前不再访问redis
+	inflight  chan struct{} //正在访问redis时非nil, 结束后关闭
+	failures  int           //连续失败次数, 决定下次重连的间隔
+	retryAt   time.Time
+	local     *LocalRateLimiter
+	now       func() time.Time
+}
+
+// NewRedisRateLimiter to create a distributed limiter
+func NewRedisRateLimiter(addr, password, key string, limit, burst int64) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		Addr:     addr,
+		Password: password,
+		Key:      key,
+		Limit:    limit,
+		Batch:    defaultRedisRateBatch,
+		Timeout:  100 * time.Millisecond,
+		local:    NewLocalRateLimiter(limit, burst),
+		now:      time.Now,
+	}
+}
+
+// Allow to take one point from the shared quota of current second
+func (l *RedisRateLimiter) Allow() bool {
+	for {
+		l.Lock()
+		sec := l.now().Unix()
+		if sec != l.second {
+			l.second = sec
+			l.tokens = 0
+			l.exhausted = false
+		}
+		if l.tokens > 0 {
+			l.tokens--
+			l.Unlock()
+			return true
+		}
+		if l.exhausted {
+			l.Unlock()
+			return false
+		}
+		if l.now().Before(l.retryAt) {
+			l.Unlock()
+			return l.local.Allow()
+		}
+		if wait := l.inflight; wait != nil {
+			// 其他调用正在预取, 等它结束后重新检查
+			l.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		l.inflight = done
+		l.Unlock()
+
+		granted, err := l.acquire(sec)
+		if err != nil {
+			l.close()
+		}
+
+		l.Lock()
+		l.inflight = nil
+		close(done)
+		if err != nil {
+			backoff := l.backoffLocked()
+			l.Unlock()
+			dlog.Warningf("redis rate limiter unavailable, fallback to local [addr:%s][retry_in:%v][err:%v]", l.Addr, backoff, err)
+			return l.local.Allow()
+		}
+		l.failures = 0
+		if granted <= 0 {
+			if sec == l.second {
+				l.exhausted = true
+			}
+			l.Unlock()
+			return false
+		}
+		// 预取期间进入了下一秒的, 只用掉本次的一个
+		if sec == l.second {
+			l.tokens += granted - 1
+		}
+		l.Unlock()
+		return true
+	}
+}
+
+// backoffLocked to schedule the next reconnection after a failure, return the interval
+func (l *RedisRateLimiter) backoffLocked() time.Duration {
+	backoff := redisRetryInterval
+	for i := 0; i < l.failures && backoff < redisMaxRetryInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > redisMaxRetryInterval {
+		backoff = redisMaxRetryInterval
+	}
+	l.failures++
+	l.retryAt = l.now().Add(backoff)
+	return backoff
+}
+
+// acquire to reserve a batch of quota, return the granted count
+func (l *RedisRateLimiter) acquire(sec int64) (int64, error) {
+	batch := l.Batch
+	if batch <= 0 || batch > l.Limit {
+		batch = 1
+	}
+	key := fmt.Sprintf("%s:%d", l.Key, sec)
+	n, err := l.do("INCRBY", key, strconv.FormatInt(batch, 10))
+	if err != nil {
+		return 0, err
+	}
+	if n == batch {
+		// 本秒第一次, 设置过期时间
+		if _, err := l.do("EXPIRE", key, "2"); err != nil {
+			return 0, err
+		}
+	}
+	// 本次之前已用掉 n-batch
+	granted := l.Limit - (n - batch)
+	if granted > batch {
+		granted = batch
+	}
+	return granted, nil
+}
+
+func (l *RedisRateLimiter) dial() error {
+	conn, err := net.DialTimeout("tcp", l.Addr, l.Timeout)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.rd = bufio.NewReader(conn)
+	if l.Password != "" {
+		if _, err := l.do("AUTH", l.Password); err != nil {
+			l.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *RedisRateLimiter) close() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// do to send a command with RESP, only integer and status replies are supported
+func (l *RedisRateLimiter) do(args ...string) (int64, error) {
+	if l.conn == nil {
+		if err := l.dial(); err != nil {
+			return 0, err
+		}
+	}
+	l.conn.SetDeadline(time.Now().Add(l.Timeout))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := l.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+
+	line, err := l.rd.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return 0, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '+':
+		return 0, nil
+	case '-':
+		return 0, fmt.Errorf("redis error: %s", line[1:])
+	}
+	return 0, fmt.Errorf("unexpected redis reply: %s", line)
+}
+
+var (
+	limiter     pointLimiter
+	limiterOnce sync.Once
+)
+
+// getLimiter to get the limiter of points pushed to counter, nil if not limited
+// max_points_per_second是整个agent的上限, 所有策略共用一个令牌桶, 不受burst_allowance影响;
+// 配置了rate_limit_redis时使用分布式限速, 否则使用本地限速
+func getLimiter() pointLimiter {
+	limiterOnce.Do(func() {
+		if g.Conf() == nil || g.Conf().Worker.MaxPointsPerSecond <= 0 {
+			return
+		}
+		limit := g.Conf().Worker.MaxPointsPerSecond
+		rc := g.Conf().Worker.RateLimitRedis
+		if rc.Addr == "" {
+			limiter = NewLocalRateLimiter(limit, 0)
+			return
+		}
+		key := rc.Key
+		if key == "" {
+			key = "falcon-log-agent:points"
+		}
+		rl := NewRedisRateLimiter(rc.Addr, rc.Password, key, limit, 0)
+		if rc.Batch > 0 {
+			rl.Batch = rc.Batch
+		}
+		if rc.TimeoutMs > 0 {
+			rl.Timeout = time.Duration(rc.TimeoutMs) * time.Millisecond
+		}
+		limiter = rl
+	})
+	return limiter
+}
+
+// strategyLimiter to limit points per second of one strategy
+// 桶容量为burst_allowance, 各策略的桶分开, 一个策略的突发不占用其他策略的配额;
+// boost期间另用放大后的令牌桶, 到期后回到原来的桶, 原来的桶不受boost期间的点影响
+type strategyLimiter struct {
+	base         *LocalRateLimiter
+	boosted      *LocalRateLimiter
+	boostedLimit int64
+}
+
+var (
+	strategyLimiters     = make(map[int64]*strategyLimiter)
+	strategyLimitersLock = new(sync.Mutex)
+)
+
+// allowStrategyPoint to take one token of the strategy, always true if strategy_max_points is not configured
+func allowStrategyPoint(id int64) bool {
+	if g.Conf() == nil || g.Conf().Worker.StrategyMaxPoints <= 0 {
+		return true
+	}
+	return strategyLimiterOf(id, g.Conf().Worker.StrategyMaxPoints, g.Conf().Worker.BurstAllowance).Allow(id)
+}
+
+// strategyLimiterOf to get the limiter of the strategy with the base limit and burst
+func strategyLimiterOf(id, limit, burst int64) *strategyLimiter {
+	strategyLimitersLock.Lock()
+	defer strategyLimitersLock.Unlock()
+	if burst <= 0 {
+		burst = limit
+	}
+	l, ok := strategyLimiters[id]
+	if !ok || l.base.limit != float64(limit) || l.base.burst != float64(burst) {
+		l = &strategyLimiter{base: NewLocalRateLimiter(limit, burst)}
+		strategyLimiters[id] = l
+	}
+	return l
+}
+
+// Allow to take one token, from the boosted bucket if the strategy is boosted with rate_multiplier
+func (l *strategyLimiter) Allow(id int64) bool {
+	m := boostedRate(id)
+	if m <= 1 {
+		return l.base.Allow()
+	}
+	limit := int64(math.Ceil(l.base.limit * m))
+	strategyLimitersLock.Lock()
+	if l.boosted == nil || l.boostedLimit != limit {
+		// 突发容量按同样的倍数放大
+		l.boosted = NewLocalRateLimiter(limit, int64(math.Ceil(l.base.burst*m)))
+		l.boosted.now = l.base.now
+		l.boostedLimit = limit
+	}
+	boosted := l.boosted
+	strategyLimitersLock.Unlock()
+	return boosted.Allow()
+}
+
+// cleanStrategyLimiters to drop limiters of deleted strategies
+func cleanStrategyLimiters(strategyMap map[int64]*scheme.Strategy) {
+	strategyLimitersLock.Lock()
+	defer strategyLimitersLock.Unlock()
+	for id := range strategyLimiters {
+		if _, ok := strategyMap[id]; !ok {
+			delete(strategyLimiters, id)
+		}
+	}
+}
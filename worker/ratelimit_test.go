@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStrategyLimiterBurst(t *testing.T) {
+	defer cleanStrategyLimiters(nil)
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	a := strategyLimiterOf(861, 10, 100)
+	a.base.now = clock
+	b := strategyLimiterOf(862, 10, 100)
+	b.base.now = clock
+
+	// 各策略的突发容量分开, 一个策略用完不影响另一个
+	for _, c := range []struct {
+		id int64
+		l  *strategyLimiter
+	}{{861, a}, {862, b}} {
+		allowed := 0
+		for i := 0; i < 150; i++ {
+			if c.l.Allow(c.id) {
+				allowed++
+			}
+		}
+		if allowed != 100 {
+			t.Errorf("strategy %d burst allowed %d, want 100", c.id, allowed)
+		}
+	}
+
+	// 配置不变时复用同一个桶, burst变化时重建
+	if strategyLimiterOf(861, 10, 100) != a {
+		t.Error("limiter should be reused")
+	}
+	if l := strategyLimiterOf(861, 10, 0); l == a || l.base.burst != 10 {
+		t.Errorf("limiter should be rebuilt with burst %v, want 10", l.base.burst)
+	}
+}
+
+// fakeRedis to serve INCRBY/EXPIRE/AUTH over RESP
+type fakeRedis struct {
+	sync.Mutex
+	ln      net.Listener
+	counter map[string]int64
+	expires map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, counter: make(map[string]int64), expires: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			rd.ReadString('\n')
+			arg, _ := rd.ReadString('\n')
+			args = append(args, strings.TrimSpace(arg))
+		}
+		r.Lock()
+		switch args[0] {
+		case "INCRBY":
+			by, _ := strconv.ParseInt(args[2], 10, 64)
+			r.counter[args[1]] += by
+			fmt.Fprintf(conn, ":%d\r\n", r.counter[args[1]])
+		case "EXPIRE":
+			r.expires[args[1]] = args[2]
+			fmt.Fprint(conn, ":1\r\n")
+		case "AUTH":
+			fmt.Fprint(conn, "+OK\r\n")
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+		r.Unlock()
+	}
+}
+
+func TestLocalRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLocalRateLimiter(3, 0)
+	l.now = func() time.Time { return now }
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d in one second, want 3", allowed)
+	}
+	now = now.Add(time.Second)
+	if !l.Allow() {
+		t.Error("quota should be renewed in next second")
+	}
+}
+
+func TestLocalRateLimiterBurst(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLocalRateLimiter(10, 100)
+	l.now = func() time.Time { return now }
+
+	// 突发的100个点都能通过
+	allowed := 0
+	for i := 0; i < 150; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 100 {
+		t.Errorf("burst allowed %d, want 100", allowed)
+	}
+
+	// 之后按速率补充
+	now = now.Add(500 * time.Millisecond)
+	allowed = 0
+	for i := 0; i < 50; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d after 0.5s, want 5", allowed)
+	}
+
+	// 空闲足够久, 桶重新填满但不超过容量
+	now = now.Add(time.Hour)
+	allowed = 0
+	for i := 0; i < 150; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 100 {
+		t.Errorf("refilled burst allowed %d, want 100", allowed)
+	}
+}
+
+func TestRedisRateLimiterShared(t *testing.T) {
+	r := newFakeRedis(t)
+	defer r.ln.Close()
+
+	now := time.Unix(2000, 0)
+	// 两个agent共享25个/秒的配额
+	limiters := make([]*RedisRateLimiter, 2)
+	for i := range limiters {
+		limiters[i] = NewRedisRateLimiter(r.ln.Addr().String(), "pwd", "test", 25, 0)
+		limiters[i].now = func() time.Time { return now }
+	}
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if limiters[i%2].Allow() {
+			allowed++
+		}
+	}
+	if allowed != 25 {
+		t.Errorf("allowed %d across agents, want 25", allowed)
+	}
+	if r.expires["test:2000"] != "2" {
+		t.Errorf("key should expire, got %v", r.expires)
+	}
+	// 配额用完后本秒不再访问redis, 每个agent最多多预取一次
+	r.Lock()
+	used := r.counter["test:2000"]
+	r.Unlock()
+	if used > 25+2*2*defaultRedisRateBatch {
+		t.Errorf("redis counter %d, exhausted second should not be requested again", used)
+	}
+}
+
+func TestRedisRateLimiterConcurrent(t *testing.T) {
+	r := newFakeRedis(t)
+	defer r.ln.Close()
+
+	now := time.Unix(3000, 0)
+	l := NewRedisRateLimiter(r.ln.Addr().String(), "", "test", 25, 0)
+	l.now = func() time.Time { return now }
+	var allowed int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if l.Allow() {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 25 {
+		t.Errorf("allowed %d concurrently, want 25", allowed)
+	}
+}
+
+func TestRedisRateLimiterFallback(t *testing.T) {
+	l := NewRedisRateLimiter("127.0.0.1:1", "", "test", 2, 0)
+	l.Timeout = 10 * time.Millisecond
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("should fallback to local limiter, allowed %d", allowed)
+	}
+}
+
+func TestRedisRateLimiterBackoff(t *testing.T) {
+	now := time.Unix(2000, 0)
+	l := NewRedisRateLimiter("127.0.0.1:1", "", "test", 2, 0)
+	l.Timeout = 10 * time.Millisecond
+	l.now = func() time.Time { return now }
+
+	// 连续失败时重连间隔翻倍, 不超过redisMaxRetryInterval
+	for _, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		l.Allow()
+		if got := l.retryAt.Sub(now); got != want {
+			t.Fatalf("retry in %v, want %v", got, want)
+		}
+		now = l.retryAt
+	}
+}
@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// DefaultLockDir 同一台机器上的agent在这里为各自处理的文件加锁
+const DefaultLockDir = "/tmp/falcon-log-agent/locks"
+
+// ErrFileLocked is returned when the file is handled by another agent
+var ErrFileLocked = errors.New("file is locked by another agent")
+
+// fileLock is the content of a lock file
+type fileLock struct {
+	Path     string `json:"path"`
+	Pid      int    `json:"pid"`
+	Instance string `json:"instance"` //区分同一进程内的多个registry, 仅测试时出现
+	Since    int64  `json:"since"`
+}
+
+// AgentRegistry to keep agents on the same host from handling the same file
+// 每个处理中的文件在Dir下有一个锁文件, 记录持有者的pid; 持有者进程已退出的锁视为失效, 可以接管
+type AgentRegistry struct {
+	Dir string
+
+	lock     sync.Mutex
+	pid      int
+	instance string
+	held     map[string]string //文件路径 -> 锁文件
+	warned   map[string]int    //已告警过的文件及持有者pid, 持有者不变时不重复告警
+}
+
+// NewAgentRegistry to create a registry of the lock dir
+func NewAgentRegistry(dir string) *AgentRegistry {
+	return &AgentRegistry{
+		Dir:      dir,
+		pid:      os.Getpid(),
+		instance: fmt.Sprintf("%x", rand.Int63()),
+		held:     make(map[string]string),
+		warned:   make(map[string]int),
+	}
+}
+
+// lockFile to get the lock file of a path
+// 路径可能很长, 用hash作文件名, 路径写在内容里
+func (r *AgentRegistry) lockFile(path string) string {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return filepath.Join(r.Dir, fmt.Sprintf("%016x.lock", h.Sum64()))
+}
+
+// processAlive to check whether the pid is running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func readFileLock(file string) (*fileLock, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	l := new(fileLock)
+	if err := json.Unmarshal(bs, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Acquire to lock the file for this agent
+// 已被其他存活的agent锁住时返回持有者的pid及ErrFileLocked
+func (r *AgentRegistry) Acquire(path string) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.held[path]; ok {
+		return r.pid, nil
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return 0, err
+	}
+
+	file := r.lockFile(path)
+	content, _ := json.Marshal(&fileLock{Path: path, Pid: r.pid, Instance: r.instance, Since: time.Now().Unix()})
+	// 失效的锁删除后再试一次
+	for retry := 0; retry < 2; retry++ {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(content)
+			f.Close()
+			if err != nil {
+				os.Remove(file)
+				return 0, err
+			}
+			r.held[path] = file
+			delete(r.warned, path)
+			return r.pid, nil
+		}
+		if !os.IsExist(err) {
+			return 0, err
+		}
+
+		owner, err := readFileLock(file)
+		if err == nil && owner.Path == path && processAlive(owner.Pid) &&
+			(owner.Pid != r.pid || owner.Instance != r.instance) {
+			if r.warned[path] != owner.Pid {
+				r.warned[path] = owner.Pid
+				dlog.Warningf("file is handled by another agent, skip it [file:%s][owner_pid:%d][since:%s][lock:%s]",
+					path, owner.Pid, time.Unix(owner.Since, 0).Format(time.RFC3339), file)
+			}
+			return owner.Pid, ErrFileLocked
+		}
+		// 内容损坏、持有者已退出或hash冲突到了其他文件的锁都视为失效
+		dlog.Infof("remove stale lock [file:%s][lock:%s]", path, file)
+		os.Remove(file)
+	}
+	return 0, fmt.Errorf("cannot lock %s", file)
+}
+
+// Release to unlock the file
+func (r *AgentRegistry) Release(path string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.release(path)
+}
+
+func (r *AgentRegistry) release(path string) {
+	file, ok := r.held[path]
+	if !ok {
+		return
+	}
+	delete(r.held, path)
+	// 只删除自己的锁, 避免误删被其他agent接管后的锁
+	if owner, err := readFileLock(file); err == nil && owner.Pid == r.pid && owner.Instance == r.instance {
+		os.Remove(file)
+	}
+}
+
+// ReleaseAll to unlock all files, called on graceful shutdown
+func (r *AgentRegistry) ReleaseAll() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for path := range r.held {
+		r.release(path)
+	}
+}
+
+// Held to get the files locked by this agent
+func (r *AgentRegistry) Held() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ret := make([]string, 0, len(r.held))
+	for path := range r.held {
+		ret = append(ret, path)
+	}
+	return ret
+}
+
+var (
+	registry     *AgentRegistry
+	registryOnce sync.Once
+)
+
+// GetRegistry to get the registry of this agent, nil if disabled
+// worker.lock_dir为"-"时不加锁
+func GetRegistry() *AgentRegistry {
+	registryOnce.Do(func() {
+		if g.Conf() == nil || g.Conf().Worker.LockDir == "-" {
+			return
+		}
+		dir := g.Conf().Worker.LockDir
+		if dir == "" {
+			dir = DefaultLockDir
+		}
+		registry = NewAgentRegistry(dir)
+	})
+	return registry
+}
+
+// lockJobFile to lock the file of a job, otlp输入不是文件, 不加锁
+func lockJobFile(path string) error {
+	r := GetRegistry()
+	if r == nil || reader.IsOTLPPath(path) {
+		return nil
+	}
+	_, err := r.Acquire(path)
+	return err
+}
+
+func unlockJobFile(path string) {
+	if r := GetRegistry(); r != nil {
+		r.Release(path)
+	}
+}
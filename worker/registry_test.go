@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestAgentRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := NewAgentRegistry(dir)
+	b := NewAgentRegistry(dir)
+	if _, err := a.Acquire("/var/log/a.log"); err != nil {
+		t.Fatal(err)
+	}
+	// 重复加锁是幂等的
+	if _, err := a.Acquire("/var/log/a.log"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := b.Acquire("/var/log/a.log"); err != ErrFileLocked || owner != os.Getpid() {
+		t.Fatalf("acquire locked file = %d, %v", owner, err)
+	}
+	if _, err := b.Acquire("/var/log/b.log"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 释放后可以被其他agent接管, 不会误删别人的锁
+	a.Release("/var/log/a.log")
+	if _, err := b.Acquire("/var/log/a.log"); err != nil {
+		t.Fatal(err)
+	}
+	a.Release("/var/log/a.log")
+	if _, err := a.Acquire("/var/log/a.log"); err != ErrFileLocked {
+		t.Fatalf("lock removed by non-owner: %v", err)
+	}
+
+	b.ReleaseAll()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("locks left after release all: %d", len(files))
+	}
+}
+
+func TestAgentRegistryStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 持有者进程已退出, 如被kill -9
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	r := NewAgentRegistry(dir)
+	bs, _ := json.Marshal(&fileLock{Path: "/var/log/a.log", Pid: cmd.Process.Pid, Instance: "dead"})
+	if err := ioutil.WriteFile(r.lockFile("/var/log/a.log"), bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 内容损坏的锁
+	if err := ioutil.WriteFile(r.lockFile("/var/log/b.log"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/var/log/a.log", "/var/log/b.log"} {
+		if _, err := r.Acquire(path); err != nil {
+			t.Fatalf("take over stale lock of %s: %v", path, err)
+		}
+		l, err := readFileLock(r.lockFile(path))
+		if err != nil || l.Pid != os.Getpid() || l.Path != path {
+			t.Fatalf("lock of %s = %+v, %v", path, l, err)
+		}
+	}
+	if len(r.Held()) != 2 {
+		t.Fatalf("held = %v", r.Held())
+	}
+}
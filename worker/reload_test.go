@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// reloadStrategy to build the strategy as loaded by one hot-reload, the tags differ by version
+func reloadStrategy(id int64, version int) *scheme.Strategy {
+	s := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	s.ID = id
+	s.FilePath = "/var/log/reload.log"
+	s.ParseSucc = true
+	s.PatternReg = regexp.MustCompile(`cost=(\d+)`)
+	s.Tags = map[string]string{"code": `code=(\d+)`}
+	s.TagRegs = map[string]*regexp.Regexp{"code": regexp.MustCompile(`code=(\d+)`)}
+	if version%2 == 1 {
+		s.Tags["api"] = `api=(\S+)`
+		s.TagRegs["api"] = regexp.MustCompile(`api=(\S+)`)
+	}
+	return s
+}
+
+// 热加载与worker处理并发进行, 用go test -race检查策略的读写
+func TestHotReloadWhileProducing(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{reloadStrategy(1, 0), reloadStrategy(2, 0)})
+
+	const reloads = 200
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := &Worker{Mark: fmt.Sprintf("[worker][reload][%d]", i), Callback: func(int64, int64) {}}
+			line := "2018-01-01 12:00:01 api=/login code=500 cost=12"
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, st := range strategy.GetAll() {
+					p, err := w.producer(line, st)
+					if err != nil || p == nil {
+						errs <- fmt.Errorf("sid %d: point %+v err %v", st.ID, p, err)
+						return
+					}
+					// 同一个策略对象的tag必须来自同一次加载
+					if len(p.Tags) != len(st.Tags) || p.Tags["code"] != "500" {
+						errs <- fmt.Errorf("sid %d: tags %v of strategy tags %v", st.ID, p.Tags, st.Tags)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	for v := 1; v <= reloads; v++ {
+		// 策略1每次重新加载; 策略2模拟整组推迟的change-set, 沿用已发布的对象
+		cur := strategy.GetAll()
+		strategy.UpdateGlobalStrategy([]*scheme.Strategy{reloadStrategy(1, v), cur[2]})
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := strategy.GetAll()[1]; len(got.Tags) != 1 {
+		t.Errorf("last reload tags %v", got.Tags)
+	}
+}
@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// defaultReplayWindow 默认只记录最近一小时内推送过的周期
+const defaultReplayWindow = 3600
+
+type replayPeriod struct {
+	sid int64
+	tms int64
+}
+
+type replayKey struct {
+	sid int64
+	gen int64
+}
+
+type replayMark struct {
+	offset int64 //已推送的最大偏移
+	tms    int64 //最近一次推送的周期
+}
+
+// replayGuard to avoid counting lines twice after a backward seek or checkpoint restore
+// 未推送的周期按 (策略, 周期, 文件代数) 记录已计入的最大偏移, 周期推送后并入该代文件的高水位,
+// 偏移不超过高水位的行属于已推送的周期, 视为重放, 只计数不再聚合; 其余行正常合并
+type replayGuard struct {
+	sync.Mutex
+	filePath string
+	window   int64
+	latest   int64 //最近推送的周期
+	pending  map[replayPeriod]map[int64]int64
+	marks    map[replayKey]*replayMark
+	replayed int64
+}
+
+func newReplayGuard(filePath string, window int64) *replayGuard {
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	rg := &replayGuard{
+		filePath: filePath,
+		window:   window,
+		pending:  make(map[replayPeriod]map[int64]int64),
+		marks:    make(map[replayKey]*replayMark),
+	}
+	for _, m := range reader.GetReplayMarks(filePath) {
+		rg.marks[replayKey{sid: m.StrategyID, gen: m.Gen}] = &replayMark{offset: m.Offset, tms: m.Tms}
+		if m.Tms > rg.latest {
+			rg.latest = m.Tms
+		}
+	}
+	return rg
+}
+
+// admit to check whether the line should be aggregated into the period
+// tms为按step对齐后的周期
+func (rg *replayGuard) admit(line reader.Line, sid, tms int64) bool {
+	rg.Lock()
+	defer rg.Unlock()
+	if m, ok := rg.marks[replayKey{sid: sid, gen: line.Gen}]; ok && line.Offset <= m.offset {
+		rg.replayed++
+		metric.MetricReplayLine(rg.filePath, 1)
+		return false
+	}
+
+	p := replayPeriod{sid: sid, tms: tms}
+	gens, ok := rg.pending[p]
+	if !ok {
+		gens = make(map[int64]int64)
+		rg.pending[p] = gens
+	}
+	if line.Offset > gens[line.Gen] {
+		gens[line.Gen] = line.Offset
+	}
+	return true
+}
+
+// seal to mark the period as pushed
+func (rg *replayGuard) seal(sid, tms int64) {
+	rg.Lock()
+	defer rg.Unlock()
+	p := replayPeriod{sid: sid, tms: tms}
+	for gen, offset := range rg.pending[p] {
+		k := replayKey{sid: sid, gen: gen}
+		m, ok := rg.marks[k]
+		if !ok {
+			m = &replayMark{}
+			rg.marks[k] = m
+		}
+		if offset > m.offset {
+			m.offset = offset
+		}
+		m.tms = tms
+	}
+	delete(rg.pending, p)
+
+	if tms > rg.latest {
+		rg.latest = tms
+		rg.prune()
+	}
+	reader.SetReplayMarks(rg.filePath, rg.export())
+}
+
+// prune to drop marks out of window, memory is bounded by strategies * generations in window
+func (rg *replayGuard) prune() {
+	for p := range rg.pending {
+		if p.tms < rg.latest-rg.window {
+			delete(rg.pending, p)
+		}
+	}
+	for k, m := range rg.marks {
+		if m.tms < rg.latest-rg.window {
+			delete(rg.marks, k)
+		}
+	}
+}
+
+func (rg *replayGuard) export() []*reader.ReplayMark {
+	ret := make([]*reader.ReplayMark, 0, len(rg.marks))
+	for k, m := range rg.marks {
+		ret = append(ret, &reader.ReplayMark{Gen: k.gen, StrategyID: k.sid, Tms: m.tms, Offset: m.offset})
+	}
+	return ret
+}
+
+// Replayed to get count of lines skipped as replayed
+func (rg *replayGuard) Replayed() int64 {
+	rg.Lock()
+	defer rg.Unlock()
+	return rg.replayed
+}
+
+var (
+	replayGuards     = make(map[string]*replayGuard)
+	replayGuardsLock = new(sync.RWMutex)
+)
+
+// replayEnabled to check whether replay protection is on for the file
+func replayEnabled(filePath string) bool {
+	if g.Conf() == nil {
+		return false
+	}
+	for _, f := range g.Conf().Replay.Files {
+		if f == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+func addReplayGuard(filePath string) {
+	window := int64(g.Conf().Replay.Window)
+	replayGuardsLock.Lock()
+	replayGuards[filePath] = newReplayGuard(filePath, window)
+	replayGuardsLock.Unlock()
+}
+
+func removeReplayGuard(filePath string) {
+	replayGuardsLock.Lock()
+	delete(replayGuards, filePath)
+	replayGuardsLock.Unlock()
+}
+
+// getReplayGuard to get guard of the file, nil if replay protection is off
+func getReplayGuard(filePath string) *replayGuard {
+	replayGuardsLock.RLock()
+	defer replayGuardsLock.RUnlock()
+	return replayGuards[filePath]
+}
+
+// ReplayStats to get count of replayed lines of all protected files
+func ReplayStats() map[string]int64 {
+	replayGuardsLock.RLock()
+	defer replayGuardsLock.RUnlock()
+	ret := make(map[string]int64, len(replayGuards))
+	for filePath, rg := range replayGuards {
+		ret[filePath] = rg.Replayed()
+	}
+	return ret
+}
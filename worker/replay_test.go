@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+const replayTestFile = "/tmp/replay_test.log"
+
+func replayTestWorker(t *testing.T) (*Worker, *scheme.Strategy) {
+	pat, _ := utils.GetPatAndTimeFormat("yyyy-mm-dd HH:MM:SS")
+	st := &scheme.Strategy{
+		ID:         9001,
+		FilePath:   replayTestFile,
+		Pattern:    "error",
+		TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Func:       "cnt",
+		Interval:   60,
+		Degree:     1,
+		TimeReg:    regexp.MustCompile(pat),
+		PatternReg: regexp.MustCompile("error"),
+		ParseSucc:  true,
+	}
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{st})
+	GlobalCount.deleteByID(st.ID)
+
+	w := &Worker{
+		FilePath: replayTestFile,
+		Mark:     "[worker][replay test]",
+		Callback: func(int64, int64) {},
+		Accept:   func(int64) bool { return true },
+		Replay:   newReplayGuard(replayTestFile, 0),
+	}
+	return w, st
+}
+
+// replayTestLines to build lines of 3 minutes, offsets are accumulated like reader does
+func replayTestLines() []reader.Line {
+	lines := make([]reader.Line, 0)
+	var offset int64
+	for minute := 0; minute < 3; minute++ {
+		for i := 0; i < minute+2; i++ {
+			text := fmt.Sprintf("2018-01-01 12:%02d:%02d error code=%d", minute, i*10, i)
+			offset += int64(len(text) + 1)
+			lines = append(lines, reader.Line{Text: text, Gen: 0, Offset: offset})
+		}
+	}
+	return lines
+}
+
+// flush to push all periods like PusherLoop, and accumulate counts
+func replayTestFlush(w *Worker, st *scheme.Strategy, counts map[int64]int64) {
+	stCount, err := GlobalCount.GetStrategyCountByID(st.ID)
+	if err != nil {
+		return
+	}
+	for _, tms := range stCount.GetTmsList() {
+		pc, _ := stCount.GetByTms(tms)
+		for _, p := range pc.TagstringMap {
+			counts[tms] += p.Count
+		}
+		stCount.DeleteTms(tms)
+		w.Replay.seal(st.ID, tms)
+	}
+}
+
+func sum(counts map[int64]int64) int64 {
+	var ret int64
+	for _, cnt := range counts {
+		ret += cnt
+	}
+	return ret
+}
+
+func TestReplaySeekBackward(t *testing.T) {
+	lines := replayTestLines()
+	reader.RemoveCheckpoint(replayTestFile)
+
+	// 单次读取的结果
+	w, st := replayTestWorker(t)
+	want := make(map[int64]int64)
+	for _, line := range lines {
+		w.analysis(line)
+	}
+	replayTestFlush(w, st, want)
+	if len(want) == 0 {
+		t.Fatal("nothing counted")
+	}
+
+	// 读完后seek回第3行重新读, 再追加新的一行
+	reader.RemoveCheckpoint(replayTestFile)
+	defer reader.RemoveCheckpoint(replayTestFile)
+	w, st = replayTestWorker(t)
+	got := make(map[int64]int64)
+	for _, line := range lines {
+		w.analysis(line)
+	}
+	replayTestFlush(w, st, got)
+	for _, line := range lines[2:] {
+		w.analysis(line)
+	}
+	replayTestFlush(w, st, got)
+	if w.Replay.Replayed() != int64(len(lines)-2) {
+		t.Errorf("replayed lines: got %d, want %d", w.Replay.Replayed(), len(lines)-2)
+	}
+
+	last := lines[len(lines)-1]
+	text := "2018-01-01 12:03:00 error code=0"
+	w.analysis(reader.Line{Text: text, Offset: last.Offset + int64(len(text)+1)})
+	replayTestFlush(w, st, got)
+
+	if sum(got) != sum(want)+1 {
+		t.Errorf("count after seek backward: got %v, want %v plus the appended line", got, want)
+	}
+}
+
+func TestReplayRestore(t *testing.T) {
+	lines := replayTestLines()
+	reader.RemoveCheckpoint(replayTestFile)
+	w, st := replayTestWorker(t)
+	counts := make(map[int64]int64)
+	for _, line := range lines {
+		w.analysis(line)
+	}
+	replayTestFlush(w, st, counts)
+	defer reader.RemoveCheckpoint(replayTestFile)
+
+	// 重启后从checkpoint中恢复高水位, 旧offset开始重读不会重复计数
+	w, st = replayTestWorker(t)
+	for _, line := range lines {
+		w.analysis(line)
+	}
+	restored := make(map[int64]int64)
+	replayTestFlush(w, st, restored)
+	if len(restored) != 0 {
+		t.Errorf("lines should not be counted again after restore: %v", restored)
+	}
+
+	// 新一代文件(轮转后)的同样offset不受影响
+	w.analysis(reader.Line{Text: lines[0].Text, Gen: 1, Offset: lines[0].Offset})
+	replayTestFlush(w, st, restored)
+	if len(restored) != 1 {
+		t.Errorf("line of new generation should be counted: %v", restored)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	rg := newReplayGuard("/tmp/replay_window.log", 120)
+	defer reader.RemoveCheckpoint("/tmp/replay_window.log")
+	for tms := int64(0); tms < 6000; tms += 60 {
+		rg.admit(reader.Line{Offset: tms + 1}, 1, tms)
+		rg.seal(1, tms)
+	}
+	for gen := int64(0); gen < 100; gen++ {
+		rg.admit(reader.Line{Gen: gen, Offset: 1}, 1, 6000+gen*60)
+		rg.seal(1, 6000+gen*60)
+	}
+	if len(rg.marks) > 3 || len(rg.pending) != 0 {
+		t.Errorf("marks should be bounded by window, got %d", len(rg.marks))
+	}
+}
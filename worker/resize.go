@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"errors"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// ErrBadWorkerNum is returned by Resize when the worker number is less than 1
+var ErrBadWorkerNum = errors.New("worker number should be at least 1")
+
+// Resize to change the number of workers of the group without restarting it
+// 增加时创建新的worker追加到Workers末尾, group已启动的立即启动; 减少时停止并移除末尾的worker,
+// 被移除的worker处理完当前行后退出; stream不变, 队列中的行由剩下的worker继续处理.
+// 与Start/Stop、Pause/Resume互斥, 暂停中返回ErrGroupPaused, 停止中或已停止的返回ErrGroupStopped
+func (wg *WorkerGroup) Resize(n int) error {
+	if n < 1 {
+		return ErrBadWorkerNum
+	}
+	wg.life.Lock()
+	defer wg.life.Unlock()
+	state := wg.stateLocked()
+	if state == GroupStopping || state == GroupStopped {
+		return wg.staleCall("Resize")
+	}
+	wg.park.Lock()
+	defer wg.park.Unlock()
+	if wg.park.paused {
+		return ErrGroupPaused
+	}
+
+	wg.workersLock.Lock()
+	old := len(wg.Workers)
+	if n == old {
+		wg.workersLock.Unlock()
+		return nil
+	}
+	wg.WorkerNum = n
+	var added, removed []*Worker
+	if n > old {
+		stream := wg.workerStream()
+		for i := old; i < n; i++ {
+			w := wg.newWorker(i, stream)
+			// 与已有的worker一致, 包括启动时检测出的parse_mode
+			if old > 0 {
+				tmpl := wg.Workers[0]
+				w.Callback, w.Accept, w.Gate = tmpl.Callback, tmpl.Accept, tmpl.Gate
+				w.Replay, w.Cardinality, w.parseMode = tmpl.Replay, tmpl.Cardinality, tmpl.parseMode
+			}
+			added = append(added, w)
+		}
+		wg.Workers = append(wg.Workers[:old:old], added...)
+	} else {
+		removed = wg.Workers[n:]
+		wg.Workers = append([]*Worker{}, wg.Workers[:n]...)
+	}
+	wg.workersLock.Unlock()
+
+	// 先停止再换gate, 被移除的worker不会在新gate上停下
+	for _, w := range removed {
+		w.Stop()
+	}
+	wg.resizeGateLocked(n)
+	if state == GroupStarted {
+		for _, w := range added {
+			w.Start()
+		}
+	}
+	dlog.Infof("resize worker group [file:%s][shard:%d][worker_num:%d -> %d]", wg.filePath, wg.Shard, old, n)
+	return nil
+}
+
+// workerStream to get the channel workers of the group read lines from
+func (wg *WorkerGroup) workerStream() chan reader.Line {
+	if wg.lifo != nil {
+		return wg.recv
+	}
+	if len(wg.Workers) > 0 {
+		return wg.Workers[0].Stream
+	}
+	return wg.stream
+}
+
+// resizeGateLocked to replace the gate by one sized for n workers, must be called with park held and not paused
+// 正在运行的worker持有旧gate, 关闭旧gate的pause及resume让它们走一遍停下-恢复, 之后读到新的gate
+func (wg *WorkerGroup) resizeGateLocked(n int) {
+	old, ok := wg.park.gate.Load().(*parkGate)
+	wg.park.gate.Store(newParkGate(n))
+	if !ok {
+		return
+	}
+	close(old.resume)
+	close(old.pause)
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestResizeNoLoss(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	setParkStrategy(t)
+
+	const total = 3000
+	base := time.Now().Add(-2 * total * time.Second)
+	var lock sync.Mutex
+	seen := make(map[int64]int)
+	var processed int64
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(2, stream, func(tms, delay int64) {
+		lock.Lock()
+		seen[tms]++
+		lock.Unlock()
+		atomic.AddInt64(&processed, 1)
+	})
+	wg.Start()
+	defer wg.Stop()
+
+	go func() {
+		for i := 0; i < total; i++ {
+			stream <- parkLine(base, i)
+		}
+	}()
+
+	// 处理过程中反复扩缩容
+	var removed []*Worker
+	for i, n := range []int{4, 1, 3, 2} {
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&processed) < int64(500*(i+1)); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("lines not processed after resize, %d", atomic.LoadInt64(&processed))
+			}
+		}
+		before := wg.Workers
+		if err := wg.Resize(n); err != nil {
+			t.Fatalf("resize to %d failed: %v", n, err)
+		}
+		if wg.WorkerNum != n || len(wg.Workers) != n {
+			t.Fatalf("expect %d workers, got %d/%d", n, wg.WorkerNum, len(wg.Workers))
+		}
+		if len(before) > n {
+			removed = append(removed, before[n:]...)
+		}
+		for _, w := range wg.Workers {
+			if w.Stream != stream {
+				t.Fatal("workers should share the stream of the group")
+			}
+		}
+	}
+	if mark := wg.Workers[1].Mark; mark != "[worker][file:"+parkFile+"][num:3][id:1]" {
+		t.Errorf("unexpected mark of added worker %s", mark)
+	}
+	for _, w := range removed {
+		select {
+		case <-w.Close:
+		default:
+			t.Errorf("removed worker %s should be stopped", w.Mark)
+		}
+	}
+
+	// 扩缩容后gate按新的worker数暂停
+	if err := wg.Pause("alice", "resized"); err != nil {
+		t.Fatalf("pause after resize failed: %v", err)
+	}
+	if stat, _ := wg.PauseStat(); stat.Workers != 2 || stat.Parked != 2 {
+		t.Fatalf("unexpected pause stat %+v", stat)
+	}
+	if err := wg.Resize(3); err != ErrGroupPaused {
+		t.Errorf("expect ErrGroupPaused while paused, got %v", err)
+	}
+	wg.Resume()
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&processed) < total && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(seen) != total {
+		t.Fatalf("expect %d lines, got %d", total, len(seen))
+	}
+	for i := 0; i < total; i++ {
+		if tms := base.Add(time.Duration(i) * time.Second).Unix(); seen[tms] != 1 {
+			t.Fatalf("line %d seen %d times", i, seen[tms])
+		}
+	}
+}
+
+func TestResizeNotStarted(t *testing.T) {
+	skipIfStalePanics(t)
+	stream := make(chan reader.Line, 16)
+	wg := newParkGroup(1, stream, func(int64, int64) {})
+	if err := wg.Resize(0); err != ErrBadWorkerNum {
+		t.Errorf("expect ErrBadWorkerNum, got %v", err)
+	}
+
+	// 未启动的group只追加, Start时一起启动
+	if err := wg.Resize(3); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range wg.Workers[1:] {
+		if w.Analyzing || w.Stream != stream {
+			t.Fatalf("unexpected added worker %+v", w)
+		}
+	}
+	wg.Start()
+	if err := wg.Pause("alice", ""); err != nil {
+		t.Fatalf("all workers should be started: %v", err)
+	}
+	wg.Resume()
+
+	wg.Stop()
+	if err := wg.Resize(2); err != ErrGroupStopped {
+		t.Errorf("expect ErrGroupStopped, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package worker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// 文件风险的各个因素, 也是worker.risk_weights的key
+const (
+	RiskLag       = "lag"        //处理延迟相对策略容忍度
+	RiskDrop      = "drop"       //队列满丢弃、半行超时丢弃的行占读入的比例
+	RiskAccess    = "access"     //文件打不开、没有权限、格式变化
+	RiskStrategy  = "strategy"   //文件上不生效、被降级暂停、被维护暂停的策略比例
+	RiskMatchRate = "match_rate" //匹配率相对基线的下降
+	RiskBacklog   = "backlog"    //队列积压占队列大小的比例
+)
+
+// riskDefaultWeights 默认权重, 合计100, 各因素严重程度都为1时得分100
+var riskDefaultWeights = map[string]float64{
+	RiskLag:       30,
+	RiskDrop:      20,
+	RiskAccess:    20,
+	RiskStrategy:  10,
+	RiskMatchRate: 10,
+	RiskBacklog:   10,
+}
+
+const (
+	// riskLagTolerance 文件上的策略都没有声明max_lag_seconds时的容忍度
+	riskLagTolerance = 300
+	// riskDropScale 丢弃比例达到该值时严重程度为1
+	riskDropScale = 0.1
+	// riskMatchMinLines 周期内分析行数少于该值时不评估匹配率
+	riskMatchMinLines = 100
+	// riskBaselineAlpha 匹配率基线的平滑系数, 每次上报更新一次
+	riskBaselineAlpha = 0.2
+)
+
+// RiskFactor is one factor of the risk score of a file with the raw values it is computed from
+type RiskFactor struct {
+	Name         string             `json:"name"`
+	Severity     float64            `json:"severity"` //0-1
+	Weight       float64            `json:"weight"`
+	Contribution float64            `json:"contribution"` //severity * weight
+	Raw          map[string]float64 `json:"raw"`
+	Detail       string             `json:"detail,omitempty"`
+}
+
+// FileRisk is the risk score of a file, factors from the largest contribution
+type FileRisk struct {
+	File    string       `json:"file"`
+	Score   float64      `json:"score"`
+	Factors []RiskFactor `json:"factors"`
+}
+
+// fileSignals is the signals of a file read from the existing registries
+type fileSignals struct {
+	lag, tolerance     int64
+	counts             metric.FileCount
+	accessError        string
+	permissionDenied   bool
+	strategies         int
+	invalid, suspended int
+	paused             int
+	baseline           float64 //匹配率基线, 0表示还没有
+	backlog, capacity  int
+}
+
+// riskWeights to get the configured weights, missing ones take the default
+func riskWeights() map[string]float64 {
+	ret := make(map[string]float64, len(riskDefaultWeights))
+	for k, v := range riskDefaultWeights {
+		ret[k] = v
+	}
+	if g.Conf() == nil {
+		return ret
+	}
+	for k, v := range g.Conf().Worker.RiskWeights {
+		if _, ok := ret[k]; !ok {
+			dlog.Warningf("unknown risk factor %s in worker.risk_weights", k)
+			continue
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
+// matchRate to get the matched ratio of analysed lines, false if too few lines
+func (s fileSignals) matchRate() (float64, bool) {
+	if s.counts.Analysis < riskMatchMinLines {
+		return 0, false
+	}
+	return float64(s.counts.AnalysisSucc) / float64(s.counts.Analysis), true
+}
+
+// scoreFile to compute the risk of a file from its signals
+func scoreFile(file string, s fileSignals, weights map[string]float64) FileRisk {
+	factors := make([]RiskFactor, 0, len(riskDefaultWeights))
+	add := func(name string, severity float64, raw map[string]float64, detail string) {
+		severity = math.Max(0, math.Min(1, severity))
+		w := weights[name]
+		factors = append(factors, RiskFactor{
+			Name:         name,
+			Severity:     round2(severity),
+			Weight:       w,
+			Contribution: round2(severity * w),
+			Raw:          raw,
+			Detail:       detail,
+		})
+	}
+
+	tolerance := s.tolerance
+	if tolerance <= 0 {
+		tolerance = riskLagTolerance
+	}
+	add(RiskLag, float64(s.lag)/float64(tolerance),
+		map[string]float64{"lag_seconds": float64(s.lag), "tolerance_seconds": float64(tolerance)}, "")
+
+	dropped := s.counts.Dropped + s.counts.ReadTimeout
+	var dropRatio float64
+	if s.counts.Read > 0 {
+		dropRatio = float64(dropped) / float64(s.counts.Read)
+	} else if dropped > 0 {
+		dropRatio = 1
+	}
+	add(RiskDrop, dropRatio/riskDropScale, map[string]float64{
+		"read":         float64(s.counts.Read),
+		"dropped":      float64(s.counts.Dropped),
+		"read_timeout": float64(s.counts.ReadTimeout),
+	}, "")
+
+	var access float64
+	detail := s.accessError
+	switch {
+	case s.accessError != "" || s.permissionDenied:
+		access = 1
+		if detail == "" {
+			detail = "permission denied"
+		}
+	case s.counts.FormatChange > 0:
+		access = 0.5
+		detail = "format changed"
+	}
+	add(RiskAccess, access, map[string]float64{"format_change": float64(s.counts.FormatChange)}, detail)
+
+	impaired := s.invalid + s.suspended + s.paused
+	var strategyRatio float64
+	if s.strategies > 0 {
+		strategyRatio = float64(impaired) / float64(s.strategies)
+	}
+	add(RiskStrategy, strategyRatio, map[string]float64{
+		"strategies": float64(s.strategies),
+		"invalid":    float64(s.invalid),
+		"suspended":  float64(s.suspended),
+		"paused":     float64(s.paused),
+	}, "")
+
+	var matchDrop float64
+	rate, ok := s.matchRate()
+	if ok && s.baseline > 0 && rate < s.baseline {
+		matchDrop = (s.baseline - rate) / s.baseline
+	}
+	add(RiskMatchRate, matchDrop, map[string]float64{
+		"analysis":      float64(s.counts.Analysis),
+		"analysis_succ": float64(s.counts.AnalysisSucc),
+		"match_rate":    round2(rate),
+		"baseline":      round2(s.baseline),
+	}, "")
+
+	var backlog float64
+	if s.capacity > 0 {
+		backlog = float64(s.backlog) / float64(s.capacity)
+	}
+	add(RiskBacklog, backlog, map[string]float64{"backlog": float64(s.backlog), "capacity": float64(s.capacity)}, "")
+
+	// 贡献相同时按名字, 结果稳定
+	sort.SliceStable(factors, func(i, j int) bool {
+		if factors[i].Contribution != factors[j].Contribution {
+			return factors[i].Contribution > factors[j].Contribution
+		}
+		return factors[i].Name < factors[j].Name
+	})
+	var score float64
+	for _, f := range factors {
+		score += f.Contribution
+	}
+	return FileRisk{File: file, Score: round2(score), Factors: factors}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// rankFileRisks to sort files worst first, by name when the scores are equal
+func rankFileRisks(risks []FileRisk) {
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].Score != risks[j].Score {
+			return risks[i].Score > risks[j].Score
+		}
+		return risks[i].File < risks[j].File
+	})
+}
+
+var (
+	riskBaselines     = make(map[string]float64) //各文件匹配率的基线
+	riskBaselinesLock = new(sync.RWMutex)
+)
+
+// collectFileSignals to read the signals of all tailed files, nothing is computed per line
+func collectFileSignals(now time.Time) map[string]fileSignals {
+	ret := make(map[string]fileSignals)
+	queueSize := 0
+	if g.Conf() != nil {
+		queueSize = g.Conf().Worker.QueueSize
+	}
+	sts := strategy.GetAll()
+
+	ManagerJobLock.RLock()
+	for file, job := range ManagerJob {
+		s := fileSignals{}
+		for _, wg := range job.groups() {
+			if wg == nil {
+				continue
+			}
+			if lag := wg.lag(now.Unix()); lag > s.lag {
+				s.lag = lag
+			}
+			if b := wg.backlog(); b > s.backlog {
+				s.backlog = b
+			}
+			s.capacity = queueSize
+		}
+		for id, st := range sts {
+			if st.FilePath != file {
+				continue
+			}
+			s.strategies++
+			if st.MaxLagSeconds > 0 && (s.tolerance == 0 || st.MaxLagSeconds < s.tolerance) {
+				s.tolerance = st.MaxLagSeconds
+			}
+			switch {
+			case !st.ParseSucc:
+				s.invalid++
+			case pausedBy(id, file) != nil || groupsPaused(job, id):
+				s.paused++
+			case groupsSuspended(job, id):
+				s.suspended++
+			}
+		}
+		ret[file] = s
+	}
+	ManagerJobLock.RUnlock()
+
+	// 打不开的文件可能没有job
+	for file, access := range reader.FileAccessStats() {
+		s := ret[file]
+		s.accessError = access.Class + ": " + access.Error
+		ret[file] = s
+	}
+	riskBaselinesLock.RLock()
+	defer riskBaselinesLock.RUnlock()
+	for file, s := range ret {
+		s.counts = metric.FileCounts(file)
+		s.permissionDenied = metric.IsPermissionDenied(file)
+		s.baseline = riskBaselines[file]
+		ret[file] = s
+	}
+	return ret
+}
+
+// groupsPaused to check whether the group handling the strategy is paused by WorkerGroup.Pause
+func groupsPaused(job *Job, id int64) bool {
+	for _, wg := range job.groups() {
+		if wg == nil || !wg.Owns(id) {
+			continue
+		}
+		if _, paused := wg.PauseStat(); paused {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsSuspended to check whether the strategy is suspended for lag
+func groupsSuspended(job *Job, id int64) bool {
+	for _, wg := range job.groups() {
+		if wg != nil && wg.Owns(id) && wg.shed.Suspended(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileRiskReport to get the risk of all tailed files, worst first
+func FileRiskReport() []FileRisk {
+	weights := riskWeights()
+	signals := collectFileSignals(time.Now())
+	ret := make([]FileRisk, 0, len(signals))
+	for file, s := range signals {
+		ret = append(ret, scoreFile(file, s, weights))
+	}
+	rankFileRisks(ret)
+	return ret
+}
+
+// updateRiskBaselines to move the match rate baselines towards the rates of this period
+func updateRiskBaselines(signals map[string]fileSignals) {
+	riskBaselinesLock.Lock()
+	defer riskBaselinesLock.Unlock()
+	for file, s := range signals {
+		rate, ok := s.matchRate()
+		if !ok {
+			continue
+		}
+		if b, ok := riskBaselines[file]; ok {
+			riskBaselines[file] = b + riskBaselineAlpha*(rate-b)
+		} else {
+			riskBaselines[file] = rate
+		}
+	}
+	for file := range riskBaselines {
+		if _, ok := signals[file]; !ok {
+			delete(riskBaselines, file)
+		}
+	}
+}
+
+// ReportFileRisks to push the score of each file as log.agent.file.risk_score, tag file
+// 由自监控的ticker驱动, 与其他自监控数据同一间隔
+func ReportFileRisks(w ticker.Window) {
+	weights := riskWeights()
+	signals := collectFileSignals(w.End)
+	step := int64(w.Duration() / time.Second)
+	if step <= 0 {
+		step = 1
+	}
+	for file, s := range signals {
+		r := scoreFile(file, s, weights)
+		pushQueue <- &FalconPoint{
+			Endpoint:    pushEndpoint(),
+			Metric:      "log.agent.file.risk_score",
+			Timestamp:   w.End.Unix(),
+			Step:        step,
+			Value:       r.Score,
+			Tags:        "file=" + file,
+			CounterType: "GAUGE",
+		}
+	}
+	// 评分使用更新前的基线, 下降当期就能体现
+	updateRiskBaselines(signals)
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+)
+
+// topFactor to get the name of the factor contributing the most
+func topFactor(r FileRisk) string {
+	if len(r.Factors) == 0 || r.Factors[0].Contribution == 0 {
+		return ""
+	}
+	return r.Factors[0].Name
+}
+
+func TestScoreFileFactors(t *testing.T) {
+	cases := []struct {
+		name   string
+		s      fileSignals
+		factor string
+		score  float64
+	}{
+		{"healthy", fileSignals{lag: 1, counts: metric.FileCount{Read: 1000}, strategies: 2, capacity: 100}, RiskLag, 0.1},
+		{"lag", fileSignals{lag: 90, tolerance: 60}, RiskLag, 30},
+		{"default tolerance", fileSignals{lag: 150}, RiskLag, 15},
+		{"drop", fileSignals{counts: metric.FileCount{Read: 1000, Dropped: 30, ReadTimeout: 20}}, RiskDrop, 10},
+		{"access", fileSignals{accessError: "not_found: no such file"}, RiskAccess, 20},
+		{"permission", fileSignals{permissionDenied: true}, RiskAccess, 20},
+		{"format change", fileSignals{counts: metric.FileCount{FormatChange: 1}}, RiskAccess, 10},
+		{"strategy", fileSignals{strategies: 4, invalid: 1, suspended: 1, paused: 1}, RiskStrategy, 7.5},
+		{"match rate", fileSignals{counts: metric.FileCount{Analysis: 200, AnalysisSucc: 50}, baseline: 0.5}, RiskMatchRate, 5},
+		{"match rate few lines", fileSignals{counts: metric.FileCount{Analysis: 50}, baseline: 0.5}, "", 0},
+		{"backlog", fileSignals{backlog: 80, capacity: 100}, RiskBacklog, 8},
+	}
+	for _, c := range cases {
+		r := scoreFile(c.name, c.s, riskDefaultWeights)
+		if got := topFactor(r); got != c.factor {
+			t.Errorf("%s: top factor %q, want %q: %+v", c.name, got, c.factor, r.Factors)
+		}
+		if r.Score != c.score {
+			t.Errorf("%s: score %v, want %v", c.name, r.Score, c.score)
+		}
+		if len(r.Factors) != len(riskDefaultWeights) {
+			t.Errorf("%s: %d factors, want all of them", c.name, len(r.Factors))
+		}
+	}
+}
+
+func TestScoreFileAttribution(t *testing.T) {
+	s := fileSignals{
+		lag: 600, tolerance: 60, //严重程度封顶为1
+		counts:   metric.FileCount{Read: 100, Dropped: 5},
+		backlog:  50,
+		capacity: 100,
+	}
+	r := scoreFile("/var/log/a.log", s, riskDefaultWeights)
+	want := []string{RiskLag, RiskDrop, RiskBacklog, RiskAccess, RiskMatchRate, RiskStrategy}
+	var got []string
+	for _, f := range r.Factors {
+		got = append(got, f.Name)
+	}
+	if !equalStrings(got, want) {
+		t.Fatalf("factors %v, want %v", got, want)
+	}
+	if r.Score != 30+10+5 {
+		t.Fatalf("score %v, want 45", r.Score)
+	}
+	lag := r.Factors[0]
+	if lag.Severity != 1 || lag.Raw["lag_seconds"] != 600 || lag.Raw["tolerance_seconds"] != 60 {
+		t.Fatalf("lag factor %+v", lag)
+	}
+
+	// 权重为0的因素不计分, 但依然列出
+	r = scoreFile("/var/log/a.log", s, map[string]float64{RiskDrop: 20, RiskBacklog: 10})
+	if r.Score != 15 || topFactor(r) != RiskDrop {
+		t.Fatalf("without lag weight %+v", r)
+	}
+}
+
+func TestRankFileRisks(t *testing.T) {
+	signals := map[string]fileSignals{
+		"/var/log/ok.log":      {},
+		"/var/log/b.log":       {backlog: 50, capacity: 100},
+		"/var/log/a.log":       {backlog: 50, capacity: 100},
+		"/var/log/lag.log":     {lag: 300},
+		"/var/log/missing.log": {accessError: "permission: denied"},
+	}
+	// 多次排序结果相同
+	for i := 0; i < 5; i++ {
+		risks := make([]FileRisk, 0, len(signals))
+		for file, s := range signals {
+			risks = append(risks, scoreFile(file, s, riskDefaultWeights))
+		}
+		rankFileRisks(risks)
+		var got []string
+		for _, r := range risks {
+			got = append(got, r.File)
+		}
+		want := []string{"/var/log/lag.log", "/var/log/missing.log", "/var/log/a.log", "/var/log/b.log", "/var/log/ok.log"}
+		if !equalStrings(got, want) {
+			t.Fatalf("ranking %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRiskBaselines(t *testing.T) {
+	defer func() { riskBaselines = make(map[string]float64) }()
+	file := "/var/log/match.log"
+	updateRiskBaselines(map[string]fileSignals{file: {counts: metric.FileCount{Analysis: 100, AnalysisSucc: 80}}})
+	if b := riskBaselines[file]; b != 0.8 {
+		t.Fatalf("first baseline %v, want 0.8", b)
+	}
+	// 行数太少不更新
+	updateRiskBaselines(map[string]fileSignals{file: {counts: metric.FileCount{Analysis: 10}}})
+	if b := riskBaselines[file]; b != 0.8 {
+		t.Fatalf("baseline %v after few lines, want 0.8", b)
+	}
+	updateRiskBaselines(map[string]fileSignals{file: {counts: metric.FileCount{Analysis: 100, AnalysisSucc: 30}}})
+	if b := round2(riskBaselines[file]); b != 0.7 {
+		t.Fatalf("baseline %v, want 0.7", b)
+	}
+	// 不再采集的文件清理
+	updateRiskBaselines(map[string]fileSignals{})
+	if len(riskBaselines) != 0 {
+		t.Fatalf("baselines %v left", riskBaselines)
+	}
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestRoundValue(t *testing.T) {
+	cases := []struct {
+		v    float64
+		n    int
+		want float64
+	}{
+		{0.33333333333333, 2, 0.33},
+		{2.5, 0, 3},
+		{-2.5, 0, -3},
+		{1.005e3, 1, 1005},
+		{123.456, 1, 123.5},
+		{1e300, 20, 1e300}, //溢出时保持原值
+	}
+	for _, c := range cases {
+		if got := roundValue(c.v, c.n); got != c.want {
+			t.Errorf("roundValue(%v, %d) = %v, want %v", c.v, c.n, got, c.want)
+		}
+	}
+	if !math.IsNaN(roundValue(math.NaN(), 2)) || !math.IsInf(roundValue(math.Inf(-1), 2), -1) {
+		t.Error("NaN and Inf should be kept")
+	}
+}
+
+func TestValueRoundDecimals(t *testing.T) {
+	var st scheme.Strategy
+	if err := json.Unmarshal([]byte(`{"id": 1}`), &st); err != nil || st.ValueRoundDecimals != nil {
+		t.Fatalf("value_round_decimals should be unset by default, got %v %v", st.ValueRoundDecimals, err)
+	}
+	if err := json.Unmarshal([]byte(`{"id": 1, "value_round_decimals": 0}`), &st); err != nil || st.ValueRoundDecimals == nil || *st.ValueRoundDecimals != 0 {
+		t.Fatalf("explicit 0 should be kept, got %v %v", st.ValueRoundDecimals, err)
+	}
+
+	s := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	s.PatternReg = regexp.MustCompile(`ratio=([0-9.]+)`)
+	w := &Worker{Mark: "[worker][round]", Callback: func(int64, int64) {}}
+	line := "2018-01-01 12:00:01 ratio=0.33333333333333"
+	// 零值的策略不处理
+	if p, _ := w.producer(line, s); p == nil || p.Value != 0.33333333333333 {
+		t.Fatalf("should not round by default, got %+v", p)
+	}
+	decimals := 3
+	s.ValueRoundDecimals = &decimals
+	if p, _ := w.producer(line, s); p == nil || p.Value != 0.333 {
+		t.Fatalf("should round to 3 decimals, got %+v", p)
+	}
+}
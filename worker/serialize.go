@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"encoding/json"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/worker/msgpack"
+)
+
+// push_serialization的取值
+const (
+	PushSerializationJSON    = "json"    //默认
+	PushSerializationMsgpack = "msgpack" //体积更小, 需要推送端点支持
+)
+
+// push请求的Content-Type
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeMsgpack = "application/msgpack"
+)
+
+// pushSerialization to get the serialization format of push payloads, unknown values fall back to json
+func pushSerialization() string {
+	if g.Conf() == nil {
+		return PushSerializationJSON
+	}
+	switch f := g.Conf().Worker.PushSerialization; f {
+	case "", PushSerializationJSON:
+		return PushSerializationJSON
+	case PushSerializationMsgpack:
+		return f
+	default:
+		dlog.Warningf("unknown push_serialization %q, use json", f)
+		return PushSerializationJSON
+	}
+}
+
+// marshalPushPoints to serialize the points by the format, returning the payload and its Content-Type
+func marshalPushPoints(points []*FalconPoint, format string) ([]byte, string, error) {
+	if format != PushSerializationMsgpack {
+		bs, err := json.Marshal(&points)
+		return bs, contentTypeJSON, err
+	}
+	return appendMsgpackPoints(make([]byte, 0, 160*len(points)), points), contentTypeMsgpack, nil
+}
+
+// appendMsgpackPoints to encode the points as an array of maps, keys are the same as json
+func appendMsgpackPoints(buf []byte, points []*FalconPoint) []byte {
+	buf = msgpack.AppendArrayHeader(buf, len(points))
+	for _, p := range points {
+		buf = msgpack.AppendMapHeader(buf, 7)
+		buf = msgpack.AppendString(buf, "endpoint")
+		buf = msgpack.AppendString(buf, p.Endpoint)
+		buf = msgpack.AppendString(buf, "metric")
+		buf = msgpack.AppendString(buf, p.Metric)
+		buf = msgpack.AppendString(buf, "timestamp")
+		buf = msgpack.AppendInt(buf, p.Timestamp)
+		buf = msgpack.AppendString(buf, "step")
+		buf = msgpack.AppendInt(buf, p.Step)
+		buf = msgpack.AppendString(buf, "value")
+		buf = msgpack.AppendFloat64(buf, p.Value)
+		buf = msgpack.AppendString(buf, "counterType")
+		buf = msgpack.AppendString(buf, p.CounterType)
+		buf = msgpack.AppendString(buf, "tags")
+		buf = msgpack.AppendString(buf, p.Tags)
+	}
+	return buf
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/worker/msgpack"
+)
+
+func serializeTestPoints(n int) []*FalconPoint {
+	points := make([]*FalconPoint, 0, n)
+	for i := 0; i < n; i++ {
+		points = append(points, &FalconPoint{
+			Endpoint:    "host-01.example",
+			Metric:      "log.api.latency",
+			Timestamp:   1514779200 + int64(i),
+			Step:        60,
+			Value:       float64(i) * 1.5,
+			CounterType: "GAUGE",
+			Tags:        fmt.Sprintf("api=/v1/order,code=%d", 200+i%5),
+			StrategyID:  7,
+		})
+	}
+	return points
+}
+
+func TestMarshalPushPoints(t *testing.T) {
+	points := serializeTestPoints(3)
+	bs, ct, err := marshalPushPoints(points, PushSerializationJSON)
+	if err != nil || ct != contentTypeJSON {
+		t.Fatalf("unexpected json result [ct:%s][err:%v]", ct, err)
+	}
+	var fromJSON []map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.UseNumber()
+	if err := d.Decode(&fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, ct, err = marshalPushPoints(points, PushSerializationMsgpack)
+	if err != nil || ct != contentTypeMsgpack {
+		t.Fatalf("unexpected msgpack result [ct:%s][err:%v]", ct, err)
+	}
+	v, err := msgpack.Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两种格式的字段名、取值一致, 不推送的字段都不出现
+	fromMsgpack, _ := v.([]interface{})
+	if len(fromMsgpack) != 3 {
+		t.Fatalf("unexpected msgpack points %v", v)
+	}
+	for i, m := range fromMsgpack {
+		p := m.(map[string]interface{})
+		if len(p) != len(fromJSON[i]) {
+			t.Fatalf("fields should be the same as json: %v %v", p, fromJSON[i])
+		}
+		for k, jv := range fromJSON[i] {
+			if fmt.Sprint(p[k]) != fmt.Sprint(jv) {
+				t.Errorf("point %d field %s: msgpack %v, json %v", i, k, p[k], jv)
+			}
+		}
+	}
+}
+
+func TestSendPushMsgpack(t *testing.T) {
+	var gotType string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	payload, ct, _ := marshalPushPoints(serializeTestPoints(2), PushSerializationMsgpack)
+	if resp, _, errs := sendPush(srv.URL, payload, ct, PushCompressionNone); errs != nil || resp.StatusCode != 200 {
+		t.Fatalf("push failed: %v", errs)
+	}
+	if gotType != contentTypeMsgpack || !bytes.Equal(gotBody, payload) {
+		t.Errorf("msgpack payload should be sent as is [content-type:%s][equal:%v]", gotType, bytes.Equal(gotBody, payload))
+	}
+
+	payload, ct, _ = marshalPushPoints(serializeTestPoints(2), PushSerializationJSON)
+	sendPush(srv.URL, payload, ct, PushCompressionNone)
+	var points []*FalconPoint
+	if gotType != contentTypeJSON || json.Unmarshal(gotBody, &points) != nil || !reflect.DeepEqual(points[1].Tags, "api=/v1/order,code=201") {
+		t.Errorf("unexpected json push [content-type:%s][body:%s]", gotType, gotBody)
+	}
+}
+
+// 推送一批点的序列化开销及体积, 见readme中push_serialization的说明
+// go test -run xxx -bench MarshalPushPoints ./worker/
+func BenchmarkMarshalPushPoints(b *testing.B) {
+	points := serializeTestPoints(1000)
+	for _, format := range []string{PushSerializationJSON, PushSerializationMsgpack} {
+		b.Run(format, func(b *testing.B) {
+			var size int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bs, _, err := marshalPushPoints(points, format)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(bs)
+			}
+			b.ReportMetric(float64(size), "bytes/batch")
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// shardStrategyIDs to split strategies of one file into shards
+// 按策略ID排序后顺序切分, 保证重启后分配结果一致
+// max <= 0 时不拆分
+func shardStrategyIDs(ids []int64, max int) [][]int64 {
+	sorted := make([]int64, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	if max <= 0 || len(sorted) <= max {
+		return [][]int64{sorted}
+	}
+
+	shards := make([][]int64, 0, (len(sorted)+max-1)/max)
+	for start := 0; start < len(sorted); start += max {
+		end := start + max
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		shards = append(shards, sorted[start:end])
+	}
+	return shards
+}
+
+// fanout to copy every line read from one file to the streams of all its worker groups
+// 单文件拆分成多个group后, 每个group都需要看到全部的日志行.
+// 发送不阻塞: 某个shard处理慢时只丢弃该shard的行并计数, 不影响其他shard;
+// 某个shard被Pause时文件处于hold状态, 与reader一样在该shard的队列满时等待(反压), 不丢弃
+type fanout struct {
+	sync.RWMutex
+	filePath string
+	in       chan reader.Line
+	outs     []*WorkerGroup
+}
+
+func newFanout(filePath string, in chan reader.Line) *fanout {
+	return &fanout{
+		filePath: filePath,
+		in:       in,
+		outs:     make([]*WorkerGroup, 0),
+	}
+}
+
+// run until the reader closes the input stream
+func (f *fanout) run() {
+	for line := range f.in {
+		f.RLock()
+		for _, out := range f.outs {
+			f.send(out, line)
+		}
+		f.RUnlock()
+	}
+}
+
+// send to put a line into the stream of a shard, dropped and counted if it is full
+// 文件被hold(有shard暂停)时等待, shard停止或解除hold后仍写不进去才丢弃
+func (f *fanout) send(out *WorkerGroup, line reader.Line) bool {
+	select {
+	case out.stream <- line:
+		return true
+	default:
+	}
+	if reader.StreamHeld(f.filePath) && reader.WaitStream(f.filePath, out.stream, line, out.stopNotify()) {
+		return true
+	}
+	atomic.AddInt64(&out.fanoutDropped, 1)
+	metric.MetricDropLine(f.filePath, 1)
+	return false
+}
+
+func (f *fanout) add(out *WorkerGroup) {
+	f.Lock()
+	f.outs = append(f.outs, out)
+	f.Unlock()
+}
+
+func (f *fanout) remove(out *WorkerGroup) {
+	f.Lock()
+	for i, o := range f.outs {
+		if o == out {
+			f.outs = append(f.outs[:i], f.outs[i+1:]...)
+			break
+		}
+	}
+	f.Unlock()
+}
+
+// shardQueueSize to get the stream size of each shard when a file is split into shards groups
+// 各shard平分worker.queue_size, 内存不随shard数增长
+func shardQueueSize(queueSize, shards int) int {
+	if shards <= 1 {
+		return queueSize
+	}
+	if size := queueSize / shards; size > 0 {
+		return size
+	}
+	return 1
+}
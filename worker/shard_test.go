@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+func TestShardStrategyIDs(t *testing.T) {
+	ids := []int64{7, 3, 5, 1, 9, 2}
+
+	shards := shardStrategyIDs(ids, 0)
+	if !reflect.DeepEqual(shards, [][]int64{{1, 2, 3, 5, 7, 9}}) {
+		t.Errorf("max 0 should not split, got %v", shards)
+	}
+
+	shards = shardStrategyIDs(ids, 4)
+	if !reflect.DeepEqual(shards, [][]int64{{1, 2, 3, 5}, {7, 9}}) {
+		t.Errorf("unexpected shards %v", shards)
+	}
+
+	// 输入顺序不同, 分配结果必须一致
+	again := shardStrategyIDs([]int64{9, 2, 1, 7, 5, 3}, 4)
+	if !reflect.DeepEqual(shards, again) {
+		t.Errorf("sharding not deterministic: %v vs %v", shards, again)
+	}
+}
+
+func TestWorkerGroupOwns(t *testing.T) {
+	wg := &WorkerGroup{}
+	if !wg.Owns(1) {
+		t.Error("group without strategy ids should own every strategy")
+	}
+	wg.SetStrategyIDs([]int64{1, 2})
+	if !wg.Owns(2) || wg.Owns(3) {
+		t.Error("group should only own assigned strategies")
+	}
+	wg.SetStrategyIDs(nil)
+	if !wg.Owns(3) {
+		t.Error("reset group should own every strategy")
+	}
+}
+
+func TestFanoutNonBlocking(t *testing.T) {
+	in := make(chan reader.Line)
+	f := newFanout("/home/app/log/fanout.log", in)
+	// slow的队列只能放1行且没有worker消费, 不能拖住fast
+	slow := &WorkerGroup{stream: make(chan reader.Line, 1)}
+	fast := &WorkerGroup{stream: make(chan reader.Line, 10)}
+	f.add(slow)
+	f.add(fast)
+	done := make(chan struct{})
+	go func() {
+		f.run()
+		close(done)
+	}()
+	for i := 0; i < 5; i++ {
+		in <- reader.Line{Text: "line"}
+	}
+	close(in)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fanout should not block on a full shard")
+	}
+	if len(fast.stream) != 5 || len(slow.stream) != 1 {
+		t.Fatalf("unexpected stream lens fast %d slow %d", len(fast.stream), len(slow.stream))
+	}
+	if slow.fanoutDropped != 4 || fast.fanoutDropped != 0 {
+		t.Fatalf("drops should be counted per shard, slow %d fast %d", slow.fanoutDropped, fast.fanoutDropped)
+	}
+}
+
+func TestShardQueueSize(t *testing.T) {
+	if n := shardQueueSize(1000, 1); n != 1000 {
+		t.Errorf("one shard should keep the queue size, got %d", n)
+	}
+	if n := shardQueueSize(1000, 4); n != 250 {
+		t.Errorf("queue size should be split across shards, got %d", n)
+	}
+	if n := shardQueueSize(3, 4); n != 1 {
+		t.Errorf("shard queue size should be at least 1, got %d", n)
+	}
+}
@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// 降级状态
+// 只由处理延迟驱动; agent只在启动时按max_cpu_rate设置GOMAXPROCS, 没有运行时的CPU自我保护, 不与之共享状态
+const (
+	DegradeNormal   = "normal"
+	DegradeShedding = "shedding"
+)
+
+const (
+	defaultShedFactor       = 1.0
+	defaultShedRecoverRatio = 0.5
+	// ShedCheckInterval 每个周期最多暂停或恢复一个策略, 逐步降级
+	ShedCheckInterval = 5 * time.Second
+)
+
+// ShedStat to show shedding state of one strategy
+type ShedStat struct {
+	Suspended     bool  `json:"suspended"`
+	SuspendedAt   int64 `json:"suspended_at"`
+	SuspendCnt    int64 `json:"suspend_cnt"`
+	ResumeCnt     int64 `json:"resume_cnt"`
+	MaxLagSeconds int64 `json:"max_lag_seconds"`
+}
+
+// shedder to suspend strategies of one file when the group can't keep up
+// 当处理延迟超过某个策略声明的max_lag_seconds * shed_factor时,
+// 按 未声明 > 容忍度大 的顺序逐个暂停其他策略, 延迟低于阈值 * shed_recover_ratio 时逆序恢复
+type shedder struct {
+	sync.RWMutex
+	state     string
+	suspended []int64 //按暂停顺序
+	stats     map[int64]*ShedStat
+}
+
+func newShedder() *shedder {
+	return &shedder{
+		state: DegradeNormal,
+		stats: make(map[int64]*ShedStat),
+	}
+}
+
+// Suspended to check whether the strategy is suspended
+func (s *shedder) Suspended(id int64) bool {
+	s.RLock()
+	defer s.RUnlock()
+	if len(s.suspended) == 0 {
+		return false
+	}
+	st, ok := s.stats[id]
+	return ok && st.Suspended
+}
+
+// State to get degrade state
+func (s *shedder) State() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.state
+}
+
+// Stats to get shedding stats
+func (s *shedder) Stats() map[int64]ShedStat {
+	s.RLock()
+	defer s.RUnlock()
+	ret := make(map[int64]ShedStat, len(s.stats))
+	for id, st := range s.stats {
+		ret[id] = *st
+	}
+	return ret
+}
+
+// shedOrder to sort strategies by shedding priority, first to shed first
+// 未声明max_lag_seconds的最先, 其次容忍度从大到小, 同级按ID;
+// boost了no_shed的排在其他可暂停的策略之后, 但仍在最严格的策略之前, 延迟降不下来时照样暂停
+func shedOrder(sts []*scheme.Strategy) []*scheme.Strategy {
+	var tolerance int64
+	for _, st := range sts {
+		if st.MaxLagSeconds > 0 && (tolerance == 0 || st.MaxLagSeconds < tolerance) {
+			tolerance = st.MaxLagSeconds
+		}
+	}
+	rank := func(st *scheme.Strategy) int {
+		switch {
+		case tolerance > 0 && st.MaxLagSeconds == tolerance:
+			return 2
+		case boostedNoShed(st.ID):
+			return 1
+		}
+		return 0
+	}
+	ret := make([]*scheme.Strategy, len(sts))
+	copy(ret, sts)
+	sort.Slice(ret, func(i, j int) bool {
+		if ri, rj := rank(ret[i]), rank(ret[j]); ri != rj {
+			return ri < rj
+		}
+		a, b := ret[i].MaxLagSeconds, ret[j].MaxLagSeconds
+		if (a <= 0) != (b <= 0) {
+			return a <= 0
+		}
+		if a != b {
+			return a > b
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// step to suspend or resume at most one strategy according to lag
+func (s *shedder) step(filePath string, lag int64, sts []*scheme.Strategy, factor, recoverRatio float64) {
+	// 有声明的最小容忍度, 作为本文件的延迟阈值
+	var tolerance int64
+	for _, st := range sts {
+		if st.MaxLagSeconds > 0 && (tolerance == 0 || st.MaxLagSeconds < tolerance) {
+			tolerance = st.MaxLagSeconds
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if tolerance == 0 && len(s.suspended) == 0 {
+		if len(s.stats) > 0 {
+			s.stats = make(map[int64]*ShedStat)
+		}
+		return
+	}
+
+	// 已删除的策略, 清理掉统计
+	alive := make(map[int64]bool, len(sts))
+	for _, st := range sts {
+		alive[st.ID] = true
+		if _, ok := s.stats[st.ID]; !ok {
+			s.stats[st.ID] = &ShedStat{}
+		}
+		s.stats[st.ID].MaxLagSeconds = st.MaxLagSeconds
+	}
+	for id, stat := range s.stats {
+		if !alive[id] && !stat.Suspended {
+			delete(s.stats, id)
+		}
+	}
+
+	switch {
+	case tolerance > 0 && float64(lag) > float64(tolerance)*factor:
+		for _, st := range shedOrder(sts) {
+			if st.MaxLagSeconds == tolerance {
+				// 只剩最严格的策略了, 不再暂停
+				break
+			}
+			stat := s.stats[st.ID]
+			if stat.Suspended {
+				continue
+			}
+			stat.Suspended = true
+			stat.SuspendedAt = time.Now().Unix()
+			stat.SuspendCnt++
+			s.suspended = append(s.suspended, st.ID)
+			s.state = DegradeShedding
+			dlog.Warningf("processing lag over tolerance, suspend strategy [file:%s][sid:%d][lag:%d][tolerance:%d]",
+				filePath, st.ID, lag, tolerance)
+			break
+		}
+	case tolerance == 0 || float64(lag) < float64(tolerance)*recoverRatio:
+		if len(s.suspended) == 0 {
+			return
+		}
+		id := s.suspended[len(s.suspended)-1]
+		s.suspended = s.suspended[:len(s.suspended)-1]
+		if stat, ok := s.stats[id]; ok {
+			stat.Suspended = false
+			stat.ResumeCnt++
+		}
+		if len(s.suspended) == 0 {
+			s.state = DegradeNormal
+		}
+		dlog.Infof("processing lag recovered, resume strategy [file:%s][sid:%d][lag:%d][tolerance:%d]",
+			filePath, id, lag, tolerance)
+	}
+}
+
+// lag to get processing lag of the group
+// 队列中没有积压时认为没有延迟, 避免空闲文件的latestTms被误判为延迟
+func (wg *WorkerGroup) lag(now int64) int64 {
+	wg.workersLock.RLock()
+	idle := len(wg.Workers) == 0
+	wg.workersLock.RUnlock()
+	if idle || wg.backlog() == 0 {
+		return 0
+	}
+	latest, _ := wg.GetLatestTmsAndDelay()
+	if latest == 0 || now < latest {
+		return 0
+	}
+	return now - latest
+}
+
+// ShedLoop to check processing lag of all groups
+func ShedLoop() {
+	for {
+		time.Sleep(ShedCheckInterval)
+
+		factor := g.Conf().Worker.ShedFactor
+		if factor <= 0 {
+			factor = defaultShedFactor
+		}
+		recoverRatio := g.Conf().Worker.ShedRecoverRatio
+		if recoverRatio <= 0 {
+			recoverRatio = defaultShedRecoverRatio
+		}
+
+		fileStrategies := make(map[string][]*scheme.Strategy)
+		for _, st := range strategy.GetAll() {
+			fileStrategies[st.FilePath] = append(fileStrategies[st.FilePath], st)
+		}
+
+		now := time.Now().Unix()
+		ManagerJobLock.RLock()
+		for filePath, job := range ManagerJob {
+			for _, wg := range job.groups() {
+				sts := make([]*scheme.Strategy, 0)
+				for _, st := range fileStrategies[filePath] {
+					// 暂停的策略不参与降级
+					if wg.Owns(st.ID) && pausedBy(st.ID, filePath) == nil {
+						sts = append(sts, st)
+					}
+				}
+				wg.shed.step(filePath, wg.lag(now), sts, factor, recoverRatio)
+			}
+		}
+		ManagerJobLock.RUnlock()
+	}
+}
+
+// ShedStats to get shedding state of all files
+func ShedStats() map[string]map[int64]ShedStat {
+	ret := make(map[string]map[int64]ShedStat)
+	ManagerJobLock.RLock()
+	defer ManagerJobLock.RUnlock()
+	for filePath, job := range ManagerJob {
+		stats := make(map[int64]ShedStat)
+		for _, wg := range job.groups() {
+			for id, st := range wg.shed.Stats() {
+				stats[id] = st
+			}
+		}
+		ret[filePath] = stats
+	}
+	return ret
+}
@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func suspendedIDs(s *shedder, sts []*scheme.Strategy) []int64 {
+	ret := make([]int64, 0)
+	for _, st := range sts {
+		if s.Suspended(st.ID) {
+			ret = append(ret, st.ID)
+		}
+	}
+	return ret
+}
+
+func TestShedder(t *testing.T) {
+	sts := []*scheme.Strategy{
+		{ID: 1, MaxLagSeconds: 10}, // 告警用, 最严格
+		{ID: 2},
+		{ID: 3, MaxLagSeconds: 300},
+		{ID: 4},
+	}
+	s := newShedder()
+
+	// 延迟未超过阈值, 不暂停
+	s.step("f", 10, sts, 1, 0.5)
+	if len(suspendedIDs(s, sts)) != 0 || s.State() != DegradeNormal {
+		t.Fatal("should not shed under tolerance")
+	}
+
+	// 持续过载, 按 未声明(ID序) > 容忍度大 的顺序逐个暂停, 最严格的策略始终保留
+	var order []int64
+	for i := 0; i < 5; i++ {
+		before := suspendedIDs(s, sts)
+		s.step("f", 60, sts, 1, 0.5)
+		after := suspendedIDs(s, sts)
+		if len(after) > len(before) {
+			for _, id := range after {
+				found := false
+				for _, b := range before {
+					found = found || b == id
+				}
+				if !found {
+					order = append(order, id)
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(order, []int64{2, 4, 3}) {
+		t.Errorf("unexpected shedding order: %v", order)
+	}
+	if s.Suspended(1) {
+		t.Error("the strictest strategy should never be suspended")
+	}
+	if s.State() != DegradeShedding {
+		t.Errorf("unexpected state: %s", s.State())
+	}
+
+	// 处于回滞区间, 保持不变
+	s.step("f", 8, sts, 1, 0.5)
+	if len(suspendedIDs(s, sts)) != 3 {
+		t.Error("should keep shedding inside hysteresis")
+	}
+
+	// 恢复, 逆序逐个恢复
+	for i := 0; i < 3; i++ {
+		s.step("f", 1, sts, 1, 0.5)
+	}
+	if len(suspendedIDs(s, sts)) != 0 || s.State() != DegradeNormal {
+		t.Error("should resume all strategies after recovery")
+	}
+	stats := s.Stats()
+	if stats[2].SuspendCnt != 1 || stats[2].ResumeCnt != 1 {
+		t.Errorf("unexpected stats: %+v", stats[2])
+	}
+}
+
+func TestShedderWithoutDeclaration(t *testing.T) {
+	sts := []*scheme.Strategy{{ID: 1}, {ID: 2}}
+	s := newShedder()
+	s.step("f", 3600, sts, 1, 0.5)
+	if len(suspendedIDs(s, sts)) != 0 || len(s.Stats()) != 0 {
+		t.Error("nothing should happen without max_lag_seconds")
+	}
+}
+
+// 模拟过载的处理流程: 每行对每个参与计算的策略各花一个单位, 每秒能处理capacity个单位,
+// 行按rate匀速到达, 延迟按积压的行数折算
+func TestShedderSyntheticOverload(t *testing.T) {
+	sts := []*scheme.Strategy{
+		{ID: 1, MaxLagSeconds: 10}, // 告警用, 最严格
+		{ID: 2},
+		{ID: 3, MaxLagSeconds: 300},
+		{ID: 4},
+	}
+	wg := &WorkerGroup{filePath: "synthetic", shed: newShedder()}
+	const capacity = 1000.0
+	interval := ShedCheckInterval.Seconds()
+
+	var backlog float64
+	var order []int64
+	run := func(rate float64, rounds int) (maxLag int64) {
+		for i := 0; i < rounds; i++ {
+			active := 0
+			for _, st := range sts {
+				if wg.accept(st.ID) {
+					active++
+				}
+			}
+			backlog += (rate - capacity/float64(active)) * interval
+			if backlog < 0 {
+				backlog = 0
+			}
+			lag := int64(backlog / rate)
+			if lag > maxLag {
+				maxLag = lag
+			}
+			before := len(wg.shed.suspended)
+			wg.shed.step(wg.filePath, lag, sts, 1, 0.5)
+			if n := len(wg.shed.suspended); n > before {
+				order = append(order, wg.shed.suspended[n-1])
+			}
+			if !wg.accept(1) {
+				t.Fatal("the strictest strategy should always be evaluated")
+			}
+		}
+		return maxLag
+	}
+
+	// 4个策略时每秒只能处理250行, 远低于到达的600行, 按顺序暂停到只剩最严格的策略
+	run(600, 20)
+	if len(order) < 3 || !reflect.DeepEqual(order[:3], []int64{2, 4, 3}) {
+		t.Errorf("unexpected shedding order: %v", order)
+	}
+	if wg.shed.State() != DegradeShedding {
+		t.Errorf("unexpected state: %s", wg.shed.State())
+	}
+
+	// 持续过载时, 最严格策略的延迟保持在容忍度附近, 不会无限增长
+	if maxLag := run(600, 60); maxLag > 2*sts[0].MaxLagSeconds {
+		t.Errorf("lag of the critical strategy should stay bounded, got %ds", maxLag)
+	}
+
+	// 负载下降后逐个恢复, 回到正常状态
+	run(200, 20)
+	if ids := suspendedIDs(wg.shed, sts); len(ids) != 0 || wg.shed.State() != DegradeNormal {
+		t.Errorf("should recover cleanly, suspended %v, state %s", ids, wg.shed.State())
+	}
+	if backlog != 0 {
+		t.Errorf("backlog should be drained, got %v", backlog)
+	}
+	for _, st := range sts {
+		if stat := wg.shed.Stats()[st.ID]; stat.SuspendCnt != stat.ResumeCnt {
+			t.Errorf("strategy %d suspended %d times but resumed %d times", st.ID, stat.SuspendCnt, stat.ResumeCnt)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker/pointpack"
+	"github.com/didi/falcon-log-agent/worker/pointpb"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	defaultSinkWindow    = 16
+	defaultSinkQueueSize = 100000
+	defaultSinkBatchSize = 200
+	sinkBatchWait        = 200 * time.Millisecond
+	sinkBackoffMin       = 100 * time.Millisecond
+	sinkBackoffMax       = 30 * time.Second
+	// 单个消息最大字节数, 防止对端发来异常长度
+	sinkMaxFrame = 16 * 1024 * 1024
+)
+
+// PointSink服务的gRPC方法, 见pointpb/point.proto
+const (
+	sinkMethodStream = "/pointpb.PointSink/Stream"
+	sinkMethodPacked = "/pointpb.PointSink/StreamPacked"
+)
+
+// 发送点的格式, ack始终是protobuf
+const (
+	SinkFormatProtobuf = "protobuf" //默认, 逐个点编码为PointBatch
+	SinkFormatPacked   = "packed"   //见pointpack, 同一策略、tag的点只发一次头部
+)
+
+// writeFrame to write a protobuf message as a gRPC length-prefixed message
+// 前缀: 1字节压缩标志(不压缩) + 4字节大端长度
+func writeFrame(w io.Writer, m proto.Message) error {
+	bs, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5, 5+len(bs))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(bs)))
+	_, err = w.Write(append(frame, bs...))
+	return err
+}
+
+// readFrame to read a gRPC length-prefixed protobuf message, io.EOF at the end of the stream
+func readFrame(r io.Reader, m proto.Message) error {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated message header")
+		}
+		return err
+	}
+	if head[0] != 0 {
+		return fmt.Errorf("compressed message not supported")
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > sinkMaxFrame {
+		return fmt.Errorf("message too large: %d", size)
+	}
+	bs := make([]byte, size)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return fmt.Errorf("truncated message: %v", err)
+	}
+	return proto.Unmarshal(bs, m)
+}
+
+// sinkStatus to get the error from grpc-status of a finished call, in trailers or in headers for trailers-only responses
+func sinkStatus(resp *http.Response) error {
+	status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("bad grpc-status %q", status)
+	}
+	if code == 0 {
+		return fmt.Errorf("stream closed by server")
+	}
+	return fmt.Errorf("grpc status %d: %s", code, msg)
+}
+
+// packBatch to group points of a batch by strategy and tags
+func packBatch(b *pointpb.PointBatch, endpoint string) *pointpack.Batch {
+	ret := &pointpack.Batch{Seq: b.Seq}
+	groups := make(map[string]*pointpack.Group)
+	for _, p := range b.Points {
+		key := fmt.Sprintf("%d/%s", p.StrategyId, utils.SortedTags(p.Tags))
+		grp, ok := groups[key]
+		if !ok {
+			grp = &pointpack.Group{StrategyID: p.StrategyId, Endpoint: endpoint, Tags: p.Tags}
+			if st, err := strategy.GetByID(p.StrategyId); err == nil {
+				grp.Metric = st.Name
+			}
+			groups[key] = grp
+			ret.Groups = append(ret.Groups, grp)
+		}
+		grp.Points = append(grp.Points, pointpack.Point{Tms: p.Tms, Value: p.Value, Unmatched: p.Unmatched})
+	}
+	return ret
+}
+
+func toPB(p *AnalysPoint) *pointpb.AnalysPoint {
+	return &pointpb.AnalysPoint{StrategyId: p.StrategyID, Value: p.Value, Tms: p.Tms, Tags: p.Tags, LogTms: p.LogTms, EventTms: p.EventTms, Unmatched: p.Unmatched}
+}
+
+func fromPB(p *pointpb.AnalysPoint) *AnalysPoint {
+	tags := p.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return &AnalysPoint{StrategyID: p.StrategyId, Value: p.Value, Tms: p.Tms, Tags: tags, LogTms: p.LogTms, EventTms: p.EventTms, Unmatched: p.Unmatched}
+}
+
+// sinkBackoff to get the wait before the n-th retry, exponential with jitter
+func sinkBackoff(n int) time.Duration {
+	d := sinkBackoffMin
+	for i := 0; i < n && d < sinkBackoffMax; i++ {
+		d *= 2
+	}
+	if d > sinkBackoffMax {
+		d = sinkBackoffMax
+	}
+	// 加上最多一半的随机抖动, 避免大量agent同时重连
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// PointSink to send points somewhere other than the local counter
+// PointStreamSink发送聚合前的点, OTLPSink发送每个周期聚合后的点
+type PointSink interface {
+	// Send to enqueue a point, return false if the point is dropped
+	Send(p *AnalysPoint) bool
+	// Start to send points until stopped
+	Start()
+	// Stop to stop the sink, points in queue are dropped
+	Stop()
+}
+
+// PointStreamSink to stream points to a remote aggregator instead of aggregating locally
+// 调用聚合端PointSink服务的双向流方法(gRPC, 明文时为h2c), 点按batch发送, 每个batch带递增的seq, 最多window个batch未确认(流控);
+// 流断开后指数退避重连, 未确认的batch重新发送(至少一次)
+type PointStreamSink struct {
+	Addr      string //host:port为明文h2c, 也可以是http://或https://开头的地址
+	Window    int
+	BatchSize int
+	Timeout   time.Duration
+	Format    string //SinkFormatProtobuf或SinkFormatPacked
+	Endpoint  string //packed格式中每组带的endpoint
+
+	packer   pointpack.Encoder
+	client   *http.Client
+	queue    chan *AnalysPoint
+	close    chan struct{}
+	closeMux sync.Once
+	seq      uint64
+	unacked  []*pointpb.PointBatch //按seq有序
+}
+
+// NewPointStreamSink to create a sink
+func NewPointStreamSink(addr string, window, queueSize int) *PointStreamSink {
+	if window <= 0 {
+		window = defaultSinkWindow
+	}
+	if queueSize <= 0 {
+		queueSize = defaultSinkQueueSize
+	}
+	return &PointStreamSink{
+		Addr:      addr,
+		Window:    window,
+		BatchSize: defaultSinkBatchSize,
+		Timeout:   10 * time.Second,
+		Format:    SinkFormatProtobuf,
+		queue:     make(chan *AnalysPoint, queueSize),
+		close:     make(chan struct{}),
+	}
+}
+
+// Send to enqueue a point, the point is dropped if queue is full
+func (s *PointStreamSink) Send(p *AnalysPoint) bool {
+	select {
+	case s.queue <- p:
+		return true
+	default:
+		metric.MetricSinkDropPoint(1)
+		return false
+	}
+}
+
+// Start to send points until stopped
+func (s *PointStreamSink) Start() {
+	s.client = s.newClient()
+	for retry := 0; ; {
+		select {
+		case <-s.close:
+			return
+		default:
+		}
+
+		sent, err := s.stream()
+		if sent {
+			retry = 0
+		}
+		select {
+		case <-s.close:
+			return
+		default:
+		}
+		wait := sinkBackoff(retry)
+		retry++
+		dlog.Warningf("point sink disconnected, retry in %v [addr:%s][err:%v]", wait, s.Addr, err)
+		select {
+		case <-s.close:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// url to get the url of the gRPC method
+func (s *PointStreamSink) url(method string) string {
+	if strings.Contains(s.Addr, "://") {
+		return strings.TrimSuffix(s.Addr, "/") + method
+	}
+	return "http://" + s.Addr + method
+}
+
+// newClient to create the HTTP/2 client, h2c unless the address is https
+// 流是长连接, 不设置整体超时, 只限制建连及单个batch的写入
+func (s *PointStreamSink) newClient() *http.Client {
+	protocols := new(http.Protocols)
+	if strings.HasPrefix(s.Addr, "https://") {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	return &http.Client{Transport: &http.Transport{Protocols: protocols, DialContext: dialer.DialContext}}
+}
+
+// stream to send batches over one call, return whether any batch is acked
+func (s *PointStreamSink) stream() (bool, error) {
+	packed := s.Format == SinkFormatPacked
+	method := sinkMethodStream
+	if packed {
+		method = sinkMethodPacked
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body, w := io.Pipe()
+	defer w.Close()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url(method), body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	dlog.Infof("point sink stream started [addr:%s][unacked:%d]", s.Addr, len(s.unacked))
+	acks := make(chan uint64, s.Window)
+	readErr := make(chan error, 1)
+	go func() {
+		resp, err := s.client.Do(req)
+		if err != nil {
+			readErr <- err
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			readErr <- fmt.Errorf("http status %d", resp.StatusCode)
+			return
+		}
+		for {
+			ack := new(pointpb.Ack)
+			if err := readFrame(resp.Body, ack); err != nil {
+				if err == io.EOF {
+					err = sinkStatus(resp)
+				}
+				readErr <- err
+				return
+			}
+			select {
+			case acks <- ack.Seq:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	write := func(b *pointpb.PointBatch) error {
+		// 写入阻塞超过timeout时取消整个调用
+		timer := time.AfterFunc(s.Timeout, cancel)
+		defer timer.Stop()
+		var err error
+		if packed {
+			err = writeFrame(w, &pointpb.PackedBatch{Data: s.packer.Encode(packBatch(b, s.Endpoint))})
+		} else {
+			err = writeFrame(w, b)
+		}
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("write batch timeout after %v", s.Timeout)
+		}
+		return err
+	}
+	// 重连后先补发未确认的batch
+	for _, b := range s.unacked {
+		if err := write(b); err != nil {
+			return false, err
+		}
+	}
+
+	acked := false
+	for {
+		// 未确认的batch达到window时只等ack, 不再从队列取点
+		var queue chan *AnalysPoint
+		if len(s.unacked) < s.Window {
+			queue = s.queue
+		}
+		select {
+		case <-s.close:
+			return acked, nil
+		case err := <-readErr:
+			return acked, err
+		case seq := <-acks:
+			acked = true
+			s.ack(seq)
+		case p := <-queue:
+			b := s.batch(p)
+			s.unacked = append(s.unacked, b)
+			if err := write(b); err != nil {
+				return acked, err
+			}
+		}
+	}
+}
+
+// batch to collect points into a batch, wait at most sinkBatchWait
+func (s *PointStreamSink) batch(first *AnalysPoint) *pointpb.PointBatch {
+	s.seq++
+	b := &pointpb.PointBatch{Seq: s.seq, Points: []*pointpb.AnalysPoint{toPB(first)}}
+	timeout := time.After(sinkBatchWait)
+	for len(b.Points) < s.BatchSize {
+		select {
+		case p := <-s.queue:
+			b.Points = append(b.Points, toPB(p))
+		case <-timeout:
+			return b
+		}
+	}
+	return b
+}
+
+// ack to remove batches not after seq
+func (s *PointStreamSink) ack(seq uint64) {
+	i, n := 0, 0
+	for i < len(s.unacked) && s.unacked[i].Seq <= seq {
+		n += len(s.unacked[i].Points)
+		i++
+	}
+	metric.MetricSinkSent("stream", int64(n))
+	s.unacked = s.unacked[i:]
+}
+
+// Stop to stop the sink, points in queue are dropped
+func (s *PointStreamSink) Stop() {
+	s.closeMux.Do(func() { close(s.close) })
+}
+
+var (
+	sink     *PointStreamSink
+	sinkOnce sync.Once
+)
+
+// getSink to get the remote sink, nil if points are aggregated locally
+func getSink() PointSink {
+	sinkOnce.Do(func() {
+		if g.Conf() == nil || g.Conf().Sink.Addr == "" {
+			return
+		}
+		sc := g.Conf().Sink
+		sink = NewPointStreamSink(sc.Addr, sc.Window, sc.QueueSize)
+		if sc.BatchSize > 0 {
+			sink.BatchSize = sc.BatchSize
+		}
+		if sc.Format != "" {
+			sink.Format = sc.Format
+		}
+		sink.Endpoint = g.Conf().Endpoint
+		go sink.Start()
+	})
+	// 不能直接返回nil的*PointStreamSink, 否则interface不为nil
+	if sink == nil {
+		return nil
+	}
+	return sink
+}
@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+	"github.com/didi/falcon-log-agent/worker/pointpack"
+	"github.com/didi/falcon-log-agent/worker/pointpb"
+)
+
+// ServePointStream to receive points streamed by agents, used by the remote aggregator
+// 提供PointSink服务(gRPC, 明文h2c), 每个batch处理完后回复ack, 发送端据此做流控; handle在流的goroutine中同步调用
+func ServePointStream(ln net.Listener, handle func(*AnalysPoint)) error {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { servePointStream(w, r, handle) }),
+		Protocols: protocols,
+	}
+	return srv.Serve(ln)
+}
+
+func servePointStream(w http.ResponseWriter, r *http.Request, handle func(*AnalysPoint)) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	var next func() (uint64, error)
+	switch r.URL.Path {
+	case sinkMethodStream:
+		next = func() (uint64, error) { return readPointBatch(r.Body, handle) }
+	case sinkMethodPacked:
+		next = func() (uint64, error) { return readPackedBatch(r.Body, handle) }
+	default:
+		// Trailers-Only
+		w.Header().Set("Grpc-Status", "12")
+		w.Header().Set("Grpc-Message", "unknown method "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	remote := r.RemoteAddr
+	dlog.Infof("point stream connected [remote:%s][method:%s]", remote, r.URL.Path)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	code, msg := 0, ""
+	for {
+		seq, err := next()
+		if err == io.EOF {
+			dlog.Infof("point stream closed [remote:%s]", remote)
+			break
+		}
+		if err != nil {
+			// 格式错误(INVALID_ARGUMENT), 对端断开时写status也无妨
+			dlog.Warningf("point stream failed [remote:%s][err:%v]", remote, err)
+			code, msg = 3, err.Error()
+			break
+		}
+		if err := writeFrame(w, &pointpb.Ack{Seq: seq}); err != nil {
+			dlog.Warningf("write ack failed [remote:%s][err:%v]", remote, err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", msg)
+	}
+}
+
+// readPointBatch to read one PointBatch and handle its points, return its seq
+func readPointBatch(r io.Reader, handle func(*AnalysPoint)) (uint64, error) {
+	b := new(pointpb.PointBatch)
+	if err := readFrame(r, b); err != nil {
+		return 0, err
+	}
+	for _, p := range b.Points {
+		handle(fromPB(p))
+	}
+	return b.Seq, nil
+}
+
+// readPackedBatch to read one PackedBatch and handle its points, return its seq
+func readPackedBatch(r io.Reader, handle func(*AnalysPoint)) (uint64, error) {
+	pb := new(pointpb.PackedBatch)
+	if err := readFrame(r, pb); err != nil {
+		return 0, err
+	}
+	b, err := pointpack.Decode(pb.Data)
+	if err != nil {
+		return 0, err
+	}
+	for _, grp := range b.Groups {
+		for _, p := range grp.Points {
+			handle(&AnalysPoint{
+				StrategyID: grp.StrategyID,
+				Value:      p.Value,
+				Unmatched:  p.Unmatched,
+				Tms:        p.Tms,
+				Tags:       scheme.DeepCopyStringMap(grp.Tags),
+			})
+		}
+	}
+	return b.Seq, nil
+}
+
+var (
+	// 聚合端收到的各文件最新的点的时间, 本机没有读该文件时用来判断周期是否可以推送
+	remoteLatest     = make(map[string]int64)
+	remoteLatestLock = new(sync.RWMutex)
+)
+
+// AggregateRemotePoint to aggregate a point received from agents into local counters
+// 之后和本机产生的点一样, 由PusherLoop批量推送
+func AggregateRemotePoint(p *AnalysPoint) {
+	st, err := strategy.GetByID(p.StrategyID)
+	if err != nil {
+		dlog.Debugf("drop remote point of unknown strategy [sid:%d]", p.StrategyID)
+		return
+	}
+	remoteLatestLock.Lock()
+	if p.Tms > remoteLatest[st.FilePath] {
+		remoteLatest[st.FilePath] = p.Tms
+	}
+	remoteLatestLock.Unlock()
+
+	if err := PushToCount(p); err != nil {
+		dlog.Errorf("push remote point to counter error [sid:%d]: %v", p.StrategyID, err)
+	}
+}
+
+func getRemoteLatest(filePath string) (int64, bool) {
+	remoteLatestLock.RLock()
+	defer remoteLatestLock.RUnlock()
+	tms, ok := remoteLatest[filePath]
+	return tms, ok
+}
+
+// StartPointStreamServer to listen and aggregate points from agents
+func StartPointStreamServer(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		dlog.Errorf("listen point stream failed [addr:%s][err:%v]", addr, err)
+		return
+	}
+	dlog.Infof("point stream server listening [addr:%s]", addr)
+	if err := ServePointStream(ln, AggregateRemotePoint); err != nil {
+		dlog.Errorf("point stream server quit [addr:%s][err:%v]", addr, err)
+	}
+}
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/worker/pointpb"
+)
+
+type pointCollector struct {
+	sync.Mutex
+	points []*AnalysPoint
+}
+
+func (c *pointCollector) handle(p *AnalysPoint) {
+	c.Lock()
+	defer c.Unlock()
+	c.points = append(c.points, p)
+}
+
+func (c *pointCollector) wait(t *testing.T, n int) []*AnalysPoint {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.Lock()
+		if len(c.points) >= n {
+			ret := c.points
+			c.Unlock()
+			return ret
+		}
+		c.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Lock()
+	defer c.Unlock()
+	t.Fatalf("expect %d points, got %d", n, len(c.points))
+	return nil
+}
+
+func TestPointStreamSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(pointCollector)
+	go ServePointStream(ln, c.handle)
+
+	s := NewPointStreamSink(ln.Addr().String(), 2, 100)
+	s.BatchSize = 3
+	go s.Start()
+	defer s.Stop()
+
+	for i := 0; i < 10; i++ {
+		s.Send(&AnalysPoint{StrategyID: 1, Value: float64(i), Tms: 100, LogTms: 99, Tags: map[string]string{"host": "a"}})
+	}
+	points := c.wait(t, 10)
+	for i, p := range points {
+		if p.StrategyID != 1 || p.Value != float64(i) || p.Tms != 100 || p.LogTms != 99 || p.Tags["host"] != "a" {
+			t.Fatalf("unexpected point %d: %+v", i, p)
+		}
+	}
+
+	// 聚合端重启后重连并继续发送
+	ln.Close()
+	ln2, err := net.Listen("tcp", ln.Addr().String())
+	if err != nil {
+		t.Skipf("cannot listen on same address: %v", err)
+	}
+	defer ln2.Close()
+	go ServePointStream(ln2, c.handle)
+	for i := 10; i < 15; i++ {
+		s.Send(&AnalysPoint{StrategyID: 1, Value: float64(i), Tms: 100, Tags: map[string]string{}})
+	}
+	points = c.wait(t, 15)
+	seen := make(map[float64]bool)
+	for _, p := range points {
+		seen[p.Value] = true
+	}
+	for i := 0; i < 15; i++ {
+		if !seen[float64(i)] {
+			t.Fatalf("point %d not delivered", i)
+		}
+	}
+}
+
+func TestPointStreamSinkAck(t *testing.T) {
+	s := NewPointStreamSink("", 4, 10)
+	for i := 0; i < 3; i++ {
+		s.unacked = append(s.unacked, s.batch(&AnalysPoint{Tags: map[string]string{}}))
+	}
+	s.ack(2)
+	if len(s.unacked) != 1 || s.unacked[0].Seq != 3 {
+		t.Fatalf("unexpected unacked after ack: %+v", s.unacked)
+	}
+}
+
+func TestSinkBackoff(t *testing.T) {
+	for n := 0; n < 20; n++ {
+		d := sinkBackoff(n)
+		if d < sinkBackoffMin/2 || d > sinkBackoffMax {
+			t.Fatalf("backoff %d out of range: %v", n, d)
+		}
+	}
+	if d := sinkBackoff(30); d < sinkBackoffMax/2 {
+		t.Fatalf("backoff should be capped near max, got %v", d)
+	}
+}
+
+func TestPointStreamSinkPacked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c := new(pointCollector)
+	go ServePointStream(ln, c.handle)
+
+	s := NewPointStreamSink(ln.Addr().String(), 2, 100)
+	s.BatchSize = 4
+	s.Format = SinkFormatPacked
+	go s.Start()
+	defer s.Stop()
+
+	for i := 0; i < 10; i++ {
+		s.Send(&AnalysPoint{StrategyID: int64(i%2 + 1), Value: float64(i), Tms: int64(100 + i), Tags: map[string]string{"host": "a"}})
+	}
+	points := c.wait(t, 10)
+	seen := make(map[float64]bool)
+	for _, p := range points {
+		if p.StrategyID != int64(int(p.Value)%2+1) || p.Tms != int64(100+int(p.Value)) || p.Tags["host"] != "a" {
+			t.Fatalf("unexpected point: %+v", p)
+		}
+		seen[p.Value] = true
+	}
+	if len(seen) != 10 {
+		t.Fatalf("expect 10 distinct points, got %d", len(seen))
+	}
+}
+
+// 按gRPC的HTTP/2协议调用, 与grpc-go客户端看到的一样
+func TestPointStreamGRPCWire(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c := new(pointCollector)
+	go ServePointStream(ln, c.handle)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	call := func(method string, body []byte) (*http.Response, []byte) {
+		req, _ := http.NewRequest("POST", "http://"+ln.Addr().String()+method, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		return resp, bs
+	}
+
+	var body bytes.Buffer
+	writeFrame(&body, &pointpb.PointBatch{Seq: 7, Points: []*pointpb.AnalysPoint{{StrategyId: 1, Value: 2, Tms: 100}}})
+	resp, bs := call(sinkMethodStream, body.Bytes())
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("expect a grpc response over http2, got %s %q", resp.Proto, resp.Header.Get("Content-Type"))
+	}
+	ack := new(pointpb.Ack)
+	if err := readFrame(bytes.NewReader(bs), ack); err != nil || ack.Seq != 7 {
+		t.Fatalf("expect ack of seq 7, got %+v %v", ack, err)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("expect grpc-status 0 in trailers, got %q", status)
+	}
+	if points := c.wait(t, 1); points[0].Value != 2 {
+		t.Fatalf("unexpected point %+v", points[0])
+	}
+
+	resp, _ = call("/pointpb.PointSink/Unknown", nil)
+	if status := resp.Header.Get("Grpc-Status"); status != "12" {
+		t.Fatalf("unknown method should be UNIMPLEMENTED, got %q", status)
+	}
+}
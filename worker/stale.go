@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+)
+
+// groupGenerations 每创建一个worker group加一, 同一文件热加载后新group的代数比旧的大
+var groupGenerations int64
+
+func nextGroupGeneration() int64 {
+	return atomic.AddInt64(&groupGenerations, 1)
+}
+
+// IsStale to check whether the group has been stopped
+// 热加载时旧group被停止、换成新的group, 持有旧引用的调用方应从ManagerJob重新获取
+func (wg *WorkerGroup) IsStale() bool {
+	s := wg.State()
+	return s == GroupStopping || s == GroupStopped
+}
+
+// staleCall to report a method called on a stale group, returns ErrGroupStopped
+// 带debug构建标签(go build -tags debug)时直接panic, 尽早发现持有旧引用的调用方
+func (wg *WorkerGroup) staleCall(method string) error {
+	msg := fmt.Sprintf("%s called on stale worker group [file:%s][shard:%d][generation:%d]", method, wg.filePath, wg.Shard, wg.Generation)
+	if staleGroupPanics {
+		panic(msg)
+	}
+	dlog.Warning(msg)
+	return ErrGroupStopped
+}
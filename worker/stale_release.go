@@ -0,0 +1,7 @@
+//go:build !debug
+// +build !debug
+
+package worker
+
+// staleGroupPanics 对已停止的group调用方法时只记录日志并返回错误
+const staleGroupPanics = false
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+// skipIfStalePanics to skip tests calling methods on stopped groups in debug builds
+func skipIfStalePanics(t *testing.T) {
+	if staleGroupPanics {
+		t.Skip("methods on stale groups panic in debug builds")
+	}
+}
+
+// staleResult to call f on a stale group, a panic in debug builds is taken as ErrGroupStopped
+func staleResult(t *testing.T, f func() error) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			if !staleGroupPanics {
+				t.Fatalf("unexpected panic: %v", r)
+			}
+			err = ErrGroupStopped
+		}
+	}()
+	err = f()
+	if staleGroupPanics {
+		t.Fatal("should panic in debug builds")
+	}
+	return err
+}
+
+func TestStaleGroup(t *testing.T) {
+	old := newParkGroup(1, make(chan reader.Line, 1), func(int64, int64) {})
+	old.Generation = nextGroupGeneration()
+	if old.IsStale() {
+		t.Fatal("created group should not be stale")
+	}
+	if err := old.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if old.IsStale() {
+		t.Fatal("started group should not be stale")
+	}
+
+	// 热加载: 停掉旧group, 为同一文件创建新的group
+	old.Stop()
+	cur := newParkGroup(1, make(chan reader.Line, 1), func(int64, int64) {})
+	cur.Generation = nextGroupGeneration()
+	if !old.IsStale() || cur.IsStale() {
+		t.Fatal("only the stopped group should be stale")
+	}
+	if cur.Generation <= old.Generation {
+		t.Errorf("generation should increase, old %d new %d", old.Generation, cur.Generation)
+	}
+	if stat := old.LifecycleStat(); stat.Generation != old.Generation {
+		t.Errorf("generation should be in lifecycle stat: %+v", stat)
+	}
+
+	for name, f := range map[string]func() error{
+		"Start":          old.Start,
+		"Resume":         old.Resume,
+		"Pause":          func() error { return old.Pause("alice", "") },
+		"SetStrategyIDs": func() error { return old.SetStrategyIDs([]int64{1}) },
+	} {
+		if err := staleResult(t, f); err != ErrGroupStopped {
+			t.Errorf("%s on stale group: expect ErrGroupStopped, got %v", name, err)
+		}
+	}
+	if !old.Owns(1) {
+		t.Error("stale group should not be changed")
+	}
+	if err := cur.SetStrategyIDs([]int64{1}); err != nil || cur.Owns(2) {
+		t.Errorf("current group should be changed, err %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+const (
+	// 单个UDP包的上限, 以太网MTU减去IP、UDP头部后留有余量, 避免分片
+	defaultStatsDMaxPacket = 1400
+	defaultStatsDPrefix    = "log."
+	statsDEndpointTagKey   = "endpoint"
+)
+
+// statsDEscaper 名称及tag中statsd的分隔符替换为下划线
+var statsDEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", " ", "_")
+
+// StatsDSink to send aggregated points to a local StatsD/Telegraf agent over UDP
+// 每个点一行gauge: log.{name}:value|g|#k:v,..., tag为DogStatsD扩展格式
+// 多行以换行拼接, 每个包不超过MaxPacket字节; UDP不重试, 发送失败计入log.agent.sink.err.cnt
+type StatsDSink struct {
+	batchSink
+	Addr       string //如127.0.0.1:8125
+	MaxPacket  int
+	BufferSize int    //socket写缓冲, 0为系统默认
+	Prefix     string //metric前缀, 默认与推送falcon的一致, 为log.
+	Host       string //endpoint tag
+	External   bool   //发往外部, 配置了noise的策略发送加噪后的值
+
+	lookup func(id int64) (*scheme.Strategy, error)
+	conn   net.PacketConn
+	raddr  net.Addr
+}
+
+// NewStatsDSink to create a StatsD sink
+func NewStatsDSink(addr string, queueSize int) *StatsDSink {
+	// 包的大小由MaxPacket限制, 每批不限点数, 取BatchWait内到达的点
+	return &StatsDSink{
+		batchSink: newBatchSink("statsd", addr, 0, queueSize),
+		Addr:      addr,
+		MaxPacket: defaultStatsDMaxPacket,
+		Prefix:    defaultStatsDPrefix,
+		lookup:    strategy.GetByID,
+	}
+}
+
+// open to create the packet conn and resolve the agent address
+func (s *StatsDSink) open() error {
+	raddr, err := net.ResolveUDPAddr("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	if s.BufferSize > 0 {
+		if uc, ok := conn.(*net.UDPConn); ok {
+			uc.SetWriteBuffer(s.BufferSize)
+		}
+	}
+	s.conn, s.raddr = conn, raddr
+	return nil
+}
+
+// Start to send points until stopped
+func (s *StatsDSink) Start() {
+	if err := s.open(); err != nil {
+		dlog.Errorf("statsd sink open failed, sink disabled [addr:%s][err:%v]", s.Addr, err)
+		return
+	}
+	defer s.conn.Close()
+	s.run(s.encode, s.write)
+}
+
+// encode to serialize points as statsd lines and pack them into packets of at most MaxPacket bytes
+// 策略已删除及值为NaN、Inf的点丢弃; 单行超过MaxPacket时独占一个包
+func (s *StatsDSink) encode(points []*AnalysPoint) []sinkPayload {
+	var packets []sinkPayload
+	var cur sinkPayload
+	for _, p := range points {
+		st, err := s.lookup(p.StrategyID)
+		if err != nil || math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			metric.MetricSinkDropPoint(1)
+			continue
+		}
+		line := s.line(st.Name, p)
+		if cur.n > 0 && len(cur.body)+1+len(line) > s.MaxPacket {
+			packets = append(packets, cur)
+			cur = sinkPayload{}
+		}
+		if cur.n > 0 {
+			cur.body = append(cur.body, '\n')
+		}
+		cur.body = append(cur.body, line...)
+		cur.n++
+	}
+	if cur.n > 0 {
+		packets = append(packets, cur)
+	}
+	return packets
+}
+
+// line to format a point as a gauge line with DogStatsD tags, tags are sorted
+func (s *StatsDSink) line(name string, p *AnalysPoint) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, statsDEscaper.Replace(s.Prefix+name)...)
+	buf = append(buf, ':')
+	buf = strconv.AppendFloat(buf, p.Value, 'f', -1, 64)
+	buf = append(buf, "|g"...)
+
+	tags := p.Tags
+	if _, ok := tags[statsDEndpointTagKey]; !ok && s.Host != "" {
+		tags = make(map[string]string, len(p.Tags)+1)
+		for k, v := range p.Tags {
+			tags[k] = v
+		}
+		tags[statsDEndpointTagKey] = s.Host
+	}
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return buf
+	}
+	sort.Strings(keys)
+	buf = append(buf, "|#"...)
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, statsDEscaper.Replace(k)...)
+		buf = append(buf, ':')
+		buf = append(buf, statsDEscaper.Replace(tags[k])...)
+	}
+	return buf
+}
+
+// write to send a packet once, UDP is not retried
+func (s *StatsDSink) write(packet sinkPayload) error {
+	if _, err := s.conn.WriteTo(packet.body, s.raddr); err != nil {
+		return &sinkWriteError{err: err}
+	}
+	metric.MetricSinkSent("statsd", int64(packet.n))
+	return nil
+}
+
+// statsDSinkExtension to build the StatsD sink of the config, disabled if not configured
+func statsDSinkExtension(c *g.Config) *Extension {
+	sc := c.Sink.StatsD
+	e := &Extension{Name: "statsd", Kind: ExtensionSink, External: sc.External, Version: configVersion(sc, c.Endpoint)}
+	if sc.Addr == "" {
+		return e
+	}
+	s := NewStatsDSink(sc.Addr, sc.QueueSize)
+	s.Host = c.Endpoint
+	s.External = sc.External
+	s.BufferSize = sc.BufferSize
+	if sc.MaxPacketBytes > 0 {
+		s.MaxPacket = sc.MaxPacketBytes
+	}
+	if sc.BatchWaitMs > 0 {
+		s.BatchWait = time.Duration(sc.BatchWaitMs) * time.Millisecond
+	}
+	if sc.MetricPrefix != "" {
+		s.Prefix = sc.MetricPrefix
+	}
+	e.Enabled = true
+	e.Send, e.Start, e.Stop = s.Send, func() { go s.Start() }, s.Stop
+	return e
+}
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func newTestStatsDSink(addr string) *StatsDSink {
+	s := NewStatsDSink(addr, 100)
+	s.BatchWait = 10 * time.Millisecond
+	s.Host = "host-01"
+	s.lookup = func(id int64) (*scheme.Strategy, error) {
+		if st, ok := otlpTestStrategies[id]; ok {
+			return st, nil
+		}
+		return nil, fmt.Errorf("no strategy %d", id)
+	}
+	return s
+}
+
+// readPackets to read n packets from the udp listener
+func readPackets(t *testing.T, conn net.PacketConn, n int) []string {
+	var packets []string
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(packets) < n {
+		l, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("got %d packets %q, want %d: %v", len(packets), packets, n, err)
+		}
+		packets = append(packets, string(buf[:l]))
+	}
+	return packets
+}
+
+func TestStatsDSinkSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newTestStatsDSink(conn.LocalAddr().String())
+	go s.Start()
+	defer s.Stop()
+
+	for _, p := range []*AnalysPoint{
+		{StrategyID: 1, Tms: 1500000000, Value: 3, Tags: map[string]string{"code": "500", "path": "/a:b|c,d"}},
+		{StrategyID: 3, Tms: 1500000000, Value: 12.25, Tags: nil},
+		{StrategyID: 2, Tms: 1500000000, Value: math.NaN()}, //statsd不能表示NaN, 丢弃
+		{StrategyID: 404, Tms: 1500000000, Value: 1},        //策略已删除, 丢弃
+		{StrategyID: 2, Tms: 1500000010, Value: 1e21, Tags: map[string]string{"endpoint": "other", "empty": ""}},
+	} {
+		s.Send(p)
+	}
+
+	got := strings.Join(readPackets(t, conn, 1), "\n")
+	want := strings.Join([]string{
+		"log.err.cnt:3|g|#code:500,endpoint:host-01,path:/a_b_c_d",
+		"log.latency.avg:12.25|g|#endpoint:host-01",
+		"log.bytes.sum:1000000000000000000000|g|#endpoint:other",
+	}, "\n")
+	if got != want {
+		t.Fatalf("packet:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStatsDSinkPacketSize(t *testing.T) {
+	s := newTestStatsDSink("127.0.0.1:8125")
+	var points []*AnalysPoint
+	for i := 0; i < 200; i++ {
+		points = append(points, &AnalysPoint{StrategyID: 1, Value: float64(i), Tags: map[string]string{"idx": fmt.Sprint(i)}})
+	}
+	// 单行超过上限, 独占一个包
+	points = append(points, &AnalysPoint{StrategyID: 1, Value: 1, Tags: map[string]string{"long": strings.Repeat("x", 2000)}})
+
+	packets := s.encode(points)
+	total := 0
+	for i, p := range packets {
+		total += p.n
+		if strings.Count(string(p.body), "\n")+1 != p.n {
+			t.Errorf("packet %d: %d lines, counted %d", i, strings.Count(string(p.body), "\n")+1, p.n)
+		}
+		if i < len(packets)-1 && len(p.body) > defaultStatsDMaxPacket {
+			t.Errorf("packet %d of %d bytes exceeds %d", i, len(p.body), defaultStatsDMaxPacket)
+		}
+	}
+	if total != len(points) {
+		t.Fatalf("packed %d points, want %d", total, len(points))
+	}
+	if last := packets[len(packets)-1]; last.n != 1 || len(last.body) <= defaultStatsDMaxPacket {
+		t.Errorf("oversized line packed with %d points in %d bytes", last.n, len(last.body))
+	}
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// groupParallelMin to get the minimum number of strategies in a group to evaluate patterns in parallel
+// 默认4, 负数关闭; 每行启动协程有开销, 策略少或pattern简单时串行更快
+func groupParallelMin() int {
+	if g.Conf() != nil && g.Conf().Worker.GroupParallelMin != 0 {
+		return g.Conf().Worker.GroupParallelMin
+	}
+	return 4
+}
+
+// groupTime 组内第一个策略提取出的时间
+type groupTime struct {
+	tms     time.Time
+	err     error
+	missing bool //没有匹配到时间, 错误信息按各策略重新生成
+}
+
+// lineCache 正在分析的行按策略组共享的结果, 每行重置
+type lineCache struct {
+	groups     map[int64]*strategy.Group
+	times      map[*strategy.Group]*groupTime
+	prefetched map[*strategy.Group]bool
+	matches    map[int64][]string //并行计算出的pattern匹配结果, 没匹配到为nil
+}
+
+// reset to start a new line with the groups of the strategies being used
+func (c *lineCache) reset(groups map[int64]*strategy.Group) {
+	c.groups = groups
+	if len(groups) == 0 {
+		return
+	}
+	if c.times == nil {
+		c.times = make(map[*strategy.Group]*groupTime)
+		c.prefetched = make(map[*strategy.Group]bool)
+		c.matches = make(map[int64][]string)
+	}
+	for k := range c.times {
+		delete(c.times, k)
+	}
+	for k := range c.prefetched {
+		delete(c.prefetched, k)
+	}
+	for k := range c.matches {
+		delete(c.matches, k)
+	}
+}
+
+// groupOf to get the group of the strategy, nil if it is not grouped
+// 组与策略来自同一次更新, 按指针确认是同一个策略对象
+func (c *lineCache) groupOf(st *scheme.Strategy) *strategy.Group {
+	grp, ok := c.groups[st.ID]
+	if !ok {
+		return nil
+	}
+	for _, m := range grp.Members {
+		if m == st {
+			return grp
+		}
+	}
+	return nil
+}
+
+// lineTimestamp to get the time of the line, extracted once for all strategies in the same group
+func (w *Worker) lineTimestamp(line string, st *scheme.Strategy, now time.Time) (time.Time, error) {
+	grp := w.line.groupOf(st)
+	if grp == nil {
+		tms, _, err := parseTimestamp(line, st, now)
+		return tms, err
+	}
+	if t, ok := w.line.times[grp]; ok {
+		if t.missing {
+			return time.Time{}, noTimestampError(st)
+		}
+		return t.tms, t.err
+	}
+	tms, found, err := parseTimestamp(line, st, now)
+	w.line.times[grp] = &groupTime{tms: tms, err: err, missing: !found}
+	return tms, err
+}
+
+// patternSubmatch to match the pattern of the strategy against the line
+// 组内策略足够多时, 第一次调用并行计算组内所有策略的pattern, 之后直接取结果
+func (w *Worker) patternSubmatch(line string, st *scheme.Strategy) []string {
+	grp := w.line.groupOf(st)
+	if grp == nil {
+		return st.PatternReg.FindStringSubmatch(line)
+	}
+	if !w.line.prefetched[grp] {
+		w.line.prefetched[grp] = true
+		if min := groupParallelMin(); min > 0 && len(grp.Members) >= min {
+			w.prefetchPatterns(line, grp)
+		}
+	}
+	if v, ok := w.line.matches[st.ID]; ok {
+		return v
+	}
+	return st.PatternReg.FindStringSubmatch(line)
+}
+
+// prefetchPatterns to evaluate the patterns of the group members processed by this worker in parallel
+func (w *Worker) prefetchPatterns(line string, grp *strategy.Group) {
+	regs := make([]*regexp.Regexp, 0, len(grp.Members))
+	ids := make([]int64, 0, len(grp.Members))
+	for _, m := range grp.Members {
+		if m.PatternReg == nil || (w.Accept != nil && !w.Accept(m.ID)) {
+			continue
+		}
+		regs = append(regs, m.PatternReg)
+		ids = append(ids, m.ID)
+	}
+	if len(regs) < 2 {
+		return
+	}
+	results := make([][]string, len(regs))
+	var wg sync.WaitGroup
+	for i := 1; i < len(regs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = regs[i].FindStringSubmatch(line)
+		}(i)
+	}
+	results[0] = regs[0].FindStringSubmatch(line)
+	wg.Wait()
+	for i, id := range ids {
+		w.line.matches[id] = results[i]
+	}
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestStrategyGroup(t *testing.T) {
+	sts := make([]*scheme.Strategy, 0)
+	for i := int64(0); i < 5; i++ {
+		st := catchAllStrategy(9301+i, fmt.Sprintf(`k%d=(\d+)`, i))
+		st.Func = "sum"
+		sts = append(sts, st)
+	}
+	strategy.UpdateGlobalStrategy(sts)
+	defer strategy.UpdateGlobalStrategy(nil)
+	for _, st := range sts {
+		GlobalCount.deleteByID(st.ID)
+		defer GlobalCount.deleteByID(st.ID)
+	}
+
+	w := &Worker{
+		FilePath: catchAllTestFile,
+		Mark:     "[worker][strategy group test]",
+		Callback: func(int64, int64) {},
+		Accept:   func(id int64) bool { return id != 9305 },
+	}
+	all, groups := strategy.GetAllWithGroups()
+	grp := groups[9301]
+	if grp == nil || len(grp.Members) != 5 {
+		t.Fatalf("strategies should be grouped: %+v", grp)
+	}
+
+	line := "2018-01-01 12:00:01 k0=1 k2=3 k4=5"
+	w.line.reset(groups)
+	for i := int64(0); i < 4; i++ {
+		st := all[9301+i]
+		p, err := w.producer(line, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// k1、k3没有匹配到, pattern有\d+不产生点
+		if i%2 == 1 {
+			if p != nil {
+				t.Errorf("sid %d should not produce, got %+v", st.ID, p)
+			}
+			continue
+		}
+		if p == nil || p.Value != float64(i+1) || p.LogTms != time.Date(2018, 1, 1, 12, 0, 1, 0, time.Local).Unix() {
+			t.Errorf("sid %d: unexpected point %+v", st.ID, p)
+		}
+	}
+	if len(w.line.times) != 1 {
+		t.Errorf("time should be extracted once for the group, got %d", len(w.line.times))
+	}
+	// 组内4个策略属于本worker, 并行算出pattern; 9305不属于本worker, 不计算
+	if len(w.line.matches) != 4 {
+		t.Errorf("patterns of the group should be evaluated together: %v", w.line.matches)
+	}
+	if _, ok := w.line.matches[9305]; ok {
+		t.Error("strategies not accepted by the worker should be skipped")
+	}
+
+	// 没有时间的行各策略报自己的错误
+	w.line.reset(groups)
+	for _, id := range []int64{9301, 9302} {
+		_, err := w.producer("no time k0=1", all[id])
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("[sid:%d]", id)) {
+			t.Errorf("sid %d: unexpected error %v", id, err)
+		}
+	}
+
+	// 整行分析的结果与不分组时一致
+	for _, text := range []string{line, "2018-01-01 12:00:02 k1=2 k4=1", "bad line"} {
+		w.analysis(reader.Line{Text: text})
+	}
+	sum := func(id int64) float64 {
+		sc, err := GlobalCount.GetStrategyCountByID(id)
+		if err != nil {
+			return 0
+		}
+		var v float64
+		for _, tms := range sc.GetTmsList() {
+			pc, _ := sc.GetByTms(tms)
+			for _, p := range pc.TagstringMap {
+				v += p.Sum
+			}
+		}
+		return v
+	}
+	for id, want := range map[int64]float64{9301: 1, 9302: 2, 9303: 3, 9304: 0, 9305: 0} {
+		if got := sum(id); got != want {
+			t.Errorf("sid %d: expect sum %v, got %v", id, want, got)
+		}
+	}
+}
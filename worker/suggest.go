@@ -0,0 +1,246 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/reader"
+)
+
+const (
+	// 每个策略最多保留的匹配行样本数, 样本已脱敏截断
+	suggestSamples = 200
+	// 占比超过该值的模板才给出建议
+	DefaultSuggestMinShare = 0.3
+	// 最多给出的建议数及候选单词的最短长度
+	suggestMaxSuggestions = 5
+	suggestMinWordLen     = 3
+)
+
+// suggestSampleEvery 每隔多少个匹配行采样一行
+var suggestSampleEvery int64 = 10
+
+// ExcludeSuggestion is a suggested exclude for a noisy template
+type ExcludeSuggestion struct {
+	Template       string  `json:"template"`
+	TemplateShare  float64 `json:"template_share"`  //模板在样本中的占比
+	Exclude        string  `json:"exclude"`         //建议的exclude正则
+	Removed        int     `json:"removed"`         //样本中会被排除的行数
+	RemovedShare   float64 `json:"removed_share"`   //样本中会被排除的比例
+	Collateral     int     `json:"collateral"`      //会被排除但不属于该模板的行数
+	EstimatedLines int64   `json:"estimated_lines"` //按比例估计, 采样窗口内会被排除的匹配行数
+	Example        string  `json:"example"`
+}
+
+// ExcludeSuggestions is the result of SuggestExcludes
+type ExcludeSuggestions struct {
+	StrategyID  int64                `json:"sid"`
+	Samples     int                  `json:"samples"`
+	Matched     int64                `json:"matched"` //样本覆盖的匹配行数
+	MinShare    float64              `json:"min_share"`
+	Suggestions []*ExcludeSuggestion `json:"suggestions"`
+}
+
+type matchSample struct {
+	line    string
+	matched int64 //采样时累计的匹配行数
+}
+
+// matchSampler to retain recent matched lines of a strategy
+type matchSampler struct {
+	sync.Mutex
+	matched int64
+	ring    []matchSample
+	pos     int
+	full    bool
+}
+
+var matchSamplers sync.Map //int64 -> *matchSampler
+
+// observeMatched to count a matched line of the strategy, every n-th line is sampled
+func observeMatched(sid int64, line string) {
+	v, ok := matchSamplers.Load(sid)
+	if !ok {
+		v, _ = matchSamplers.LoadOrStore(sid, &matchSampler{ring: make([]matchSample, suggestSamples)})
+	}
+	s := v.(*matchSampler)
+	n := atomic.AddInt64(&s.matched, 1)
+	if n%suggestSampleEvery != 0 {
+		return
+	}
+	sample := matchSample{line: redactLine(line), matched: n}
+	s.Lock()
+	s.ring[s.pos] = sample
+	s.pos = (s.pos + 1) % len(s.ring)
+	if s.pos == 0 {
+		s.full = true
+	}
+	s.Unlock()
+}
+
+// samples to get retained samples, oldest first
+func (s *matchSampler) samples() []matchSample {
+	s.Lock()
+	defer s.Unlock()
+	if !s.full {
+		return append([]matchSample(nil), s.ring[:s.pos]...)
+	}
+	return append(append([]matchSample(nil), s.ring[s.pos:]...), s.ring[:s.pos]...)
+}
+
+func cleanMatchSamplers(strategyMap map[int64]*scheme.Strategy) {
+	matchSamplers.Range(func(k, v interface{}) bool {
+		if _, ok := strategyMap[k.(int64)]; !ok {
+			matchSamplers.Delete(k)
+		}
+		return true
+	})
+}
+
+type templateGroup struct {
+	template string
+	tokens   []string
+	lines    []int //样本下标
+}
+
+// SuggestExcludes to suggest excludes for templates with a share above minShare
+// 只分析保留的样本, 不重新读文件, 不会修改策略
+func SuggestExcludes(sid int64, minShare float64) (*ExcludeSuggestions, error) {
+	v, ok := matchSamplers.Load(sid)
+	if !ok {
+		return nil, fmt.Errorf("no matched lines sampled for strategy %d", sid)
+	}
+	samples := v.(*matchSampler).samples()
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no matched lines sampled for strategy %d", sid)
+	}
+	if minShare <= 0 {
+		minShare = DefaultSuggestMinShare
+	}
+	ret := &ExcludeSuggestions{
+		StrategyID:  sid,
+		Samples:     len(samples),
+		Matched:     samples[len(samples)-1].matched - samples[0].matched + suggestSampleEvery,
+		MinShare:    minShare,
+		Suggestions: make([]*ExcludeSuggestion, 0),
+	}
+
+	groups := make(map[string]*templateGroup)
+	member := make([]*templateGroup, len(samples))
+	for i, sample := range samples {
+		tokens := reader.Template(sample.line)
+		key := strings.Join(tokens, " ")
+		grp, ok := groups[key]
+		if !ok {
+			grp = &templateGroup{template: key, tokens: tokens}
+			groups[key] = grp
+		}
+		grp.lines = append(grp.lines, i)
+		member[i] = grp
+	}
+	sorted := make([]*templateGroup, 0, len(groups))
+	for _, grp := range groups {
+		sorted = append(sorted, grp)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].lines) != len(sorted[j].lines) {
+			return len(sorted[i].lines) > len(sorted[j].lines)
+		}
+		return sorted[i].template < sorted[j].template
+	})
+
+	total := float64(len(samples))
+	for _, grp := range sorted {
+		share := float64(len(grp.lines)) / total
+		if share < minShare || len(ret.Suggestions) >= suggestMaxSuggestions {
+			break
+		}
+		best := bestExclude(grp, samples, member)
+		if best == nil {
+			continue
+		}
+		best.TemplateShare = share
+		best.RemovedShare = float64(best.Removed) / total
+		best.EstimatedLines = int64(best.RemovedShare * float64(ret.Matched))
+		best.Example = samples[grp.lines[0]].line
+		ret.Suggestions = append(ret.Suggestions, best)
+	}
+	return ret, nil
+}
+
+// bestExclude to choose the literal of the template that removes most of its lines and fewest of others
+// 候选为模板中的单词及相邻的两个单词, 效果相同时优先单个单词, 其次更具体(更长)的
+func bestExclude(grp *templateGroup, samples []matchSample, member []*templateGroup) *ExcludeSuggestion {
+	type candidate struct {
+		reg  string
+		pair bool
+	}
+	candidates := make([]candidate, 0)
+	for i, tok := range grp.tokens {
+		// 被脱敏的key(如token)不作为候选
+		if !literalWord(tok) || sensitiveReg.MatchString(tok+"=") {
+			continue
+		}
+		quoted := regexp.QuoteMeta(tok)
+		candidates = append(candidates, candidate{reg: `\b` + quoted + `\b`})
+		if i+1 < len(grp.tokens) && literalWord(grp.tokens[i+1]) {
+			candidates = append(candidates, candidate{reg: `\b` + quoted + `\s+` + regexp.QuoteMeta(grp.tokens[i+1]) + `\b`, pair: true})
+		}
+	}
+
+	var best *ExcludeSuggestion
+	var bestCovered int
+	var bestPair bool
+	for _, cand := range candidates {
+		reg := regexp.MustCompile(cand.reg)
+		covered, collateral := 0, 0
+		for i, sample := range samples {
+			if !reg.MatchString(sample.line) {
+				continue
+			}
+			if member[i] == grp {
+				covered++
+			} else {
+				collateral++
+			}
+		}
+		// 至少覆盖模板一半的行
+		if covered*2 < len(grp.lines) {
+			continue
+		}
+		better := best == nil || collateral < best.Collateral
+		if !better && collateral == best.Collateral {
+			switch {
+			case covered != bestCovered:
+				better = covered > bestCovered
+			case cand.pair != bestPair:
+				better = !cand.pair
+			default:
+				better = len(cand.reg) > len(best.Exclude)
+			}
+		}
+		if better {
+			best = &ExcludeSuggestion{Template: grp.template, Exclude: cand.reg, Removed: covered + collateral, Collateral: collateral}
+			bestCovered, bestPair = covered, cand.pair
+		}
+	}
+	return best
+}
+
+// literalWord to check whether the template token is a word kept literally
+func literalWord(tok string) bool {
+	if len(tok) < suggestMinWordLen || strings.HasPrefix(tok, "<") {
+		return false
+	}
+	for i := 0; i < len(tok); i++ {
+		if !(tok[i] == '_' || (tok[i] >= 'a' && tok[i] <= 'z') || (tok[i] >= 'A' && tok[i] <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
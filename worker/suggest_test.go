@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestSuggestExcludesNoisyTemplate(t *testing.T) {
+	defer func(n int64) { suggestSampleEvery = n }(suggestSampleEvery)
+	suggestSampleEvery = 1
+	const sid = 901
+	defer matchSamplers.Delete(int64(sid))
+
+	// 70%的匹配行是健康检查, 其余分散在3个模板
+	for i := 0; i < 1000; i++ {
+		var line string
+		switch {
+		case i%10 < 7:
+			line = fmt.Sprintf("2018-01-01 12:00:%02d GET /healthcheck status=200 cost=%d token=abc%d", i%60, i%7, i)
+		case i%10 == 7:
+			line = fmt.Sprintf("2018-01-01 12:00:%02d GET /api/order status=200 cost=%d", i%60, i)
+		case i%10 == 8:
+			line = fmt.Sprintf("2018-01-01 12:00:%02d POST /api/pay status=500 cost=%d", i%60, i)
+		default:
+			line = fmt.Sprintf("2018-01-01 12:00:%02d GET /api/user status=404 cost=%d", i%60, i)
+		}
+		observeMatched(sid, line)
+	}
+
+	ret, err := SuggestExcludes(sid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Samples != suggestSamples || ret.Matched != suggestSamples || len(ret.Suggestions) != 1 {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+	s := ret.Suggestions[0]
+	if s.Exclude != `\bhealthcheck\b` || s.Collateral != 0 || s.Removed != 140 || s.RemovedShare != 0.7 || s.EstimatedLines != 140 {
+		t.Fatalf("unexpected suggestion: %+v", s)
+	}
+	// 建议的正则恰好排除该模板
+	removed := 0
+	for _, sample := range matchSamplersOf(sid).samples() {
+		if regexp.MustCompile(s.Exclude).MatchString(sample.line) {
+			removed++
+		}
+	}
+	if removed != s.Removed {
+		t.Fatalf("exclude removes %d lines, suggestion says %d", removed, s.Removed)
+	}
+	// 样本已脱敏
+	if regexp.MustCompile(`token=abc`).MatchString(s.Example) {
+		t.Fatalf("example should be redacted: %s", s.Example)
+	}
+}
+
+func TestSuggestExcludesBalanced(t *testing.T) {
+	defer func(n int64) { suggestSampleEvery = n }(suggestSampleEvery)
+	suggestSampleEvery = 2
+	const sid = 902
+	defer matchSamplers.Delete(int64(sid))
+
+	paths := []string{"order", "pay", "user", "item", "cart"}
+	for i := 0; i < 1000; i++ {
+		observeMatched(sid, fmt.Sprintf("GET /api/%s status=200 cost=%d", paths[i%5], i))
+	}
+	ret, err := SuggestExcludes(sid, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ret.Suggestions) != 0 || ret.Matched != 400 {
+		t.Fatalf("balanced distribution should have no suggestion: %+v", ret)
+	}
+	// 调低阈值后给出
+	if ret, _ = SuggestExcludes(sid, 0.1); len(ret.Suggestions) != 5 {
+		t.Fatalf("expect 5 suggestions, got %+v", ret.Suggestions)
+	}
+
+	if _, err := SuggestExcludes(903, 0); err == nil {
+		t.Fatal("strategy without samples should fail")
+	}
+}
+
+func matchSamplersOf(sid int64) *matchSampler {
+	v, _ := matchSamplers.Load(sid)
+	return v.(*matchSampler)
+}
+
+// 没有匹配pattern而补零的行不计入匹配样本
+func TestSuggestSkipsUnmatchedLines(t *testing.T) {
+	defer func(n int64) { suggestSampleEvery = n }(suggestSampleEvery)
+	suggestSampleEvery = 1
+	st := catchAllStrategy(9104, "error")
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{st})
+	defer strategy.UpdateGlobalStrategy(nil)
+	GlobalCount.deleteByID(st.ID)
+	defer GlobalCount.deleteByID(st.ID)
+	defer matchSamplers.Delete(st.ID)
+
+	w := &Worker{
+		FilePath: catchAllTestFile,
+		Mark:     "[worker][suggest test]",
+		Callback: func(int64, int64) {},
+		Accept:   func(int64) bool { return true },
+	}
+	for _, text := range []string{
+		"2018-01-01 12:00:01 error code=1",
+		"2018-01-01 12:00:02 info ok",
+		"2018-01-01 12:00:03 info ok",
+	} {
+		w.analysis(reader.Line{Text: text})
+	}
+	v, ok := matchSamplers.Load(st.ID)
+	if !ok {
+		t.Fatal("matched line should be sampled")
+	}
+	if n := v.(*matchSampler).matched; n != 1 {
+		t.Fatalf("sampled %d lines, want only the matched one", n)
+	}
+}
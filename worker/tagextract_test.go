@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestExtractOrderedTags(t *testing.T) {
+	defer cleanTagOversizeStats(nil)
+	st := &scheme.Strategy{
+		ID:      310,
+		Tags:    map[string]string{"dc": `dc=([\w-]+)`},
+		TagRegs: map[string]*regexp.Regexp{"dc": regexp.MustCompile(`dc=([\w-]+)`)},
+		OrderedTagExtracts: []scheme.TagExtract{
+			{Key: "region", Regex: `^([a-z]+)-`, SourceTag: "dc"},
+			{Key: "zone", Regex: `^([a-z]+)`, SourceTag: "region"},
+			{Key: "api", Regex: `GET (/\w+)`},
+		},
+	}
+	for i := range st.OrderedTagExtracts {
+		e := &st.OrderedTagExtracts[i]
+		e.Reg = regexp.MustCompile(e.Regex)
+	}
+
+	tag, miss, err := extractTags("GET /orders dc=bj-01 cost=12", nil, st)
+	if err != nil || miss != "" {
+		t.Fatalf("unexpected miss %q, err %v", miss, err)
+	}
+	want := map[string]string{"dc": "bj-01", "region": "bj", "zone": "bj", "api": "/orders"}
+	if !reflect.DeepEqual(tag, want) {
+		t.Errorf("expect %v, got %v", want, tag)
+	}
+
+	// 只匹配source_tag的值, 行中其他位置的内容不算
+	_, miss, _ = extractTags("GET /orders dc=01 region=sh-02", nil, st)
+	if miss != "tag region not matched" {
+		t.Errorf("unexpected miss %q", miss)
+	}
+
+	// 超长按前面提取出的值处理, 被截断的值再作为source_tag
+	st.TagLimits = map[string]*scheme.TagLimit{"dc": {MaxLen: 2}}
+	tag, miss, _ = extractTags("GET /orders dc=bj-01", nil, st)
+	if miss != "tag region not matched" || tag != nil {
+		t.Errorf("truncated source tag should be used, got %q %v", miss, tag)
+	}
+	st.TagLimits = nil
+
+	// 没有编译的条目报错
+	st.OrderedTagExtracts[2].Reg = nil
+	if _, _, err := extractTags("GET /orders dc=bj-01", nil, st); err == nil || !strings.Contains(err.Error(), "tagk:api") {
+		t.Errorf("expect error of uncompiled extract, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// DefaultMaxTagValueLen tag取值默认的最大字节数
+const DefaultMaxTagValueLen = 255
+
+// tagTruncateSuffix 截断后的tag取值带上该后缀
+const tagTruncateSuffix = "..."
+
+// TagOversizeStat is the count of oversized tag values of one strategy since start
+type TagOversizeStat struct {
+	Truncated int64 `json:"truncated"`
+	Dropped   int64 `json:"dropped"`
+}
+
+var (
+	tagOversizeStats     = make(map[int64]*TagOversizeStat)
+	tagOversizeStatsLock = new(sync.RWMutex)
+)
+
+func getTagOversizeStat(id int64) *TagOversizeStat {
+	tagOversizeStatsLock.RLock()
+	s, ok := tagOversizeStats[id]
+	tagOversizeStatsLock.RUnlock()
+	if ok {
+		return s
+	}
+
+	tagOversizeStatsLock.Lock()
+	defer tagOversizeStatsLock.Unlock()
+	if s, ok = tagOversizeStats[id]; !ok {
+		s = new(TagOversizeStat)
+		tagOversizeStats[id] = s
+	}
+	return s
+}
+
+// TagOversizeStats to get oversized tag value counts of all strategies
+func TagOversizeStats() map[int64]TagOversizeStat {
+	tagOversizeStatsLock.RLock()
+	defer tagOversizeStatsLock.RUnlock()
+	ret := make(map[int64]TagOversizeStat, len(tagOversizeStats))
+	for id, s := range tagOversizeStats {
+		ret[id] = TagOversizeStat{
+			Truncated: atomic.LoadInt64(&s.Truncated),
+			Dropped:   atomic.LoadInt64(&s.Dropped),
+		}
+	}
+	return ret
+}
+
+// cleanTagOversizeStats to drop counts of strategies deleted
+func cleanTagOversizeStats(strategyMap map[int64]*scheme.Strategy) {
+	tagOversizeStatsLock.Lock()
+	defer tagOversizeStatsLock.Unlock()
+	for id := range tagOversizeStats {
+		if _, ok := strategyMap[id]; !ok {
+			delete(tagOversizeStats, id)
+		}
+	}
+}
+
+// tagValueLimit to get the max length and oversize policy of a tag
+func tagValueLimit(st *scheme.Strategy, tagk string) (int, string) {
+	max, policy := 0, scheme.OversizeTruncate
+	if l := st.TagLimits[tagk]; l != nil {
+		max = l.MaxLen
+		if l.OnOversize != "" {
+			policy = l.OnOversize
+		}
+	}
+	if max <= 0 && g.Conf() != nil {
+		max = g.Conf().Worker.MaxTagValueLen
+	}
+	if max <= 0 {
+		max = DefaultMaxTagValueLen
+	}
+	return max, policy
+}
+
+// truncateTagValue to cut the value to at most max bytes at a rune boundary, with the suffix
+// 返回新分配的字符串, 不再引用原日志行
+func truncateTagValue(v string, max int) string {
+	suffix := tagTruncateSuffix
+	if max <= len(suffix) {
+		suffix = ""
+	}
+	cut := max - len(suffix)
+	for cut > 0 && !utf8.RuneStart(v[cut]) {
+		cut--
+	}
+	if suffix == "" {
+		return strings.Clone(v[:cut])
+	}
+	return v[:cut] + suffix
+}
+
+// boundTagValue to apply the length limit to a captured tag value, false means the point should be dropped
+// 未超长时原样返回, 不分配内存
+func boundTagValue(st *scheme.Strategy, tagk, v string) (string, bool) {
+	max, policy := tagValueLimit(st, tagk)
+	if len(v) <= max {
+		return v, true
+	}
+	stat := getTagOversizeStat(st.ID)
+	if policy == scheme.OversizeDrop {
+		atomic.AddInt64(&stat.Dropped, 1)
+		return "", false
+	}
+	atomic.AddInt64(&stat.Truncated, 1)
+	return truncateTagValue(v, max), true
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestTruncateTagValue(t *testing.T) {
+	cases := []struct {
+		v    string
+		max  int
+		want string
+	}{
+		{"abcdefghij", 8, "abcde..."},
+		{"中文的标签取值", 10, "中文..."}, //每个汉字3字节, 不能截在字符中间
+		{"中文的标签取值", 11, "中文..."},
+		{"中文的标签取值", 12, "中文的..."},
+		{"abcdef", 3, "abc"}, //放不下后缀时不加
+	}
+	for _, c := range cases {
+		got := truncateTagValue(c.v, c.max)
+		if got != c.want {
+			t.Errorf("truncateTagValue(%q, %d) = %q, want %q", c.v, c.max, got, c.want)
+		}
+		if len(got) > c.max || !utf8.ValidString(got) {
+			t.Errorf("truncateTagValue(%q, %d) = %q, over limit or invalid utf8", c.v, c.max, got)
+		}
+	}
+}
+
+func TestBoundTagValue(t *testing.T) {
+	defer cleanTagOversizeStats(nil)
+	st := &scheme.Strategy{ID: 46, TagLimits: map[string]*scheme.TagLimit{
+		"ua":  {MaxLen: 16},
+		"uid": {MaxLen: 8, OnOversize: scheme.OversizeDrop},
+	}}
+
+	if v, ok := boundTagValue(st, "ua", "curl/7.1"); !ok || v != "curl/7.1" {
+		t.Errorf("short value changed: %q %v", v, ok)
+	}
+	if v, ok := boundTagValue(st, "ua", strings.Repeat("x", 100)); !ok || len(v) != 16 || !strings.HasSuffix(v, tagTruncateSuffix) {
+		t.Errorf("long value not truncated: %q %v", v, ok)
+	}
+	if _, ok := boundTagValue(st, "uid", strings.Repeat("1", 9)); ok {
+		t.Errorf("long value should be dropped")
+	}
+	// 没有配置的tag使用默认上限
+	if v, ok := boundTagValue(st, "host", strings.Repeat("h", DefaultMaxTagValueLen+1)); !ok || len(v) != DefaultMaxTagValueLen {
+		t.Errorf("default limit not applied: len %d %v", len(v), ok)
+	}
+
+	stat := TagOversizeStats()[46]
+	if stat.Truncated != 2 || stat.Dropped != 1 {
+		t.Errorf("stat %+v, want 2 truncated 1 dropped", stat)
+	}
+}
+
+func TestBoundTagValueNoAlloc(t *testing.T) {
+	st := &scheme.Strategy{ID: 1}
+	v := "GET /index.html"
+	allocs := testing.AllocsPerRun(100, func() {
+		boundTagValue(st, "path", v)
+	})
+	if allocs != 0 {
+		t.Errorf("value under limit should not allocate, got %v", allocs)
+	}
+}
+
+// 超长的捕获截断后不能再引用原日志行, 否则每个tag都会把整行留在内存里
+func TestBoundTagValueReleasesLine(t *testing.T) {
+	defer cleanTagOversizeStats(nil)
+	st := &scheme.Strategy{ID: 1}
+	kept := make([]string, 0, 1000)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 1000; i++ {
+		line := strings.Repeat("a", 100<<10)
+		v, _ := boundTagValue(st, "msg", line)
+		kept = append(kept, v)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	if grow := int64(after.HeapInuse) - int64(before.HeapInuse); grow > 10<<20 {
+		t.Errorf("heap grew %d bytes keeping %d truncated values", grow, len(kept))
+	}
+	runtime.KeepAlive(kept)
+}
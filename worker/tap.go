@@ -0,0 +1,245 @@
+package worker
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// 调试用的实时事件类型
+const (
+	TapMatch   = "match"
+	TapMiss    = "miss"
+	TapExclude = "exclude"
+	TapSummary = "summary"
+)
+
+const (
+	defaultTapMaxEvents = 50
+	defaultTapBuffer    = 256
+	// 事件中日志原文最多保留的字节数
+	tapExcerptBytes = 256
+)
+
+// TapEvent is a live event of one strategy, for interactive debugging
+type TapEvent struct {
+	Type       string            `json:"type"`
+	StrategyID int64             `json:"sid"`
+	LogTms     int64             `json:"log_tms,omitempty"`   //日志中解析出的时间
+	EventTms   int64             `json:"event_tms,omitempty"` //match: 按策略timestamp_precision的日志时间
+	Value      interface{}       `json:"value,omitempty"`     //数字或"NaN"
+	Tags       map[string]string `json:"tags,omitempty"`
+	Line       string            `json:"line,omitempty"` //脱敏、截断后的日志原文
+	Reason     string            `json:"reason,omitempty"`
+	Sent       int64             `json:"sent,omitempty"`    //summary: 距上次summary发送的事件数
+	Dropped    int64             `json:"dropped,omitempty"` //summary: 距上次summary因限速丢弃的事件数
+}
+
+// sensitiveReg to redact secrets in line excerpt
+var sensitiveReg = regexp.MustCompile(`(?i)(password|passwd|pwd|token|secret|api_?key|authorization)(\s*[=:]\s*)("[^"]*"|\S+)`)
+
+// redactLine to redact and truncate the line for tap events
+func redactLine(line string) string {
+	if len(line) > tapExcerptBytes {
+		line = line[:tapExcerptBytes] + "..."
+	}
+	return sensitiveReg.ReplaceAllString(line, "${1}${2}***")
+}
+
+func tapValue(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "NaN"
+	}
+	return v
+}
+
+// TapClient is a subscriber of live events of one strategy
+// 缓冲有上限, 写不进去说明客户端消费太慢, 直接断开
+type TapClient struct {
+	StrategyID int64
+	Verbose    bool //是否包含miss/exclude事件
+
+	events    chan *TapEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	limiter   *LocalRateLimiter
+	slow      int32
+	sent      int64
+	dropped   int64
+}
+
+var (
+	// 订阅者总数, 为0时发射路径上只有一次原子读
+	tapClients int32
+	taps       = make(map[int64]map[*TapClient]struct{})
+	tapsLock   = new(sync.Mutex)
+	// taps的只读副本(map[int64][]*TapClient), 订阅变化时在tapsLock下整体替换, 发射路径不加锁
+	tapView atomic.Value
+)
+
+// tapClientsOf to get the subscribers of the strategy from the copy-on-write view
+func tapClientsOf(sid int64) []*TapClient {
+	view, _ := tapView.Load().(map[int64][]*TapClient)
+	return view[sid]
+}
+
+// storeTapViewLocked to rebuild the view after taps changed, must be called with tapsLock held
+func storeTapViewLocked() {
+	view := make(map[int64][]*TapClient, len(taps))
+	for sid, clients := range taps {
+		for c := range clients {
+			view[sid] = append(view[sid], c)
+		}
+	}
+	tapView.Store(view)
+}
+
+// tapping to check whether any client is subscribing
+func tapping() bool {
+	return atomic.LoadInt32(&tapClients) > 0
+}
+
+// tapOn to check whether events of the strategy are wanted, by subscribers or a boost with trace
+func tapOn(sid int64) bool {
+	return (tapping() && len(tapClientsOf(sid)) > 0) || boostedTrace(sid)
+}
+
+// TapClientCount to get count of subscribing clients
+func TapClientCount() int {
+	return int(atomic.LoadInt32(&tapClients))
+}
+
+// SubscribeTap to subscribe live events of a strategy
+func SubscribeTap(sid int64, verbose bool) *TapClient {
+	maxEvents, buffer := defaultTapMaxEvents, defaultTapBuffer
+	if g.Conf() != nil {
+		if g.Conf().Http.StreamMaxEvents > 0 {
+			maxEvents = g.Conf().Http.StreamMaxEvents
+		}
+		if g.Conf().Http.StreamBuffer > 0 {
+			buffer = g.Conf().Http.StreamBuffer
+		}
+	}
+	c := &TapClient{
+		StrategyID: sid,
+		Verbose:    verbose,
+		events:     make(chan *TapEvent, buffer),
+		done:       make(chan struct{}),
+		limiter:    NewLocalRateLimiter(int64(maxEvents), 0),
+	}
+
+	tapsLock.Lock()
+	defer tapsLock.Unlock()
+	clients, ok := taps[sid]
+	if !ok {
+		clients = make(map[*TapClient]struct{})
+		taps[sid] = clients
+	}
+	clients[c] = struct{}{}
+	atomic.AddInt32(&tapClients, 1)
+	storeTapViewLocked()
+	return c
+}
+
+// Events to get the event channel
+func (c *TapClient) Events() <-chan *TapEvent {
+	return c.events
+}
+
+// Done is closed when the client is unsubscribed
+func (c *TapClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Slow to check whether the client is disconnected for not consuming in time
+func (c *TapClient) Slow() bool {
+	return atomic.LoadInt32(&c.slow) == 1
+}
+
+// Summary to get a summary event, counters are reset
+func (c *TapClient) Summary() *TapEvent {
+	return &TapEvent{
+		Type:       TapSummary,
+		StrategyID: c.StrategyID,
+		Sent:       atomic.SwapInt64(&c.sent, 0),
+		Dropped:    atomic.SwapInt64(&c.dropped, 0),
+	}
+}
+
+// Close to unsubscribe
+func (c *TapClient) Close() {
+	tapsLock.Lock()
+	defer tapsLock.Unlock()
+	c.unsubscribe()
+}
+
+// unsubscribe must be called with tapsLock held
+func (c *TapClient) unsubscribe() {
+	c.closeOnce.Do(func() {
+		clients := taps[c.StrategyID]
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(taps, c.StrategyID)
+		}
+		atomic.AddInt32(&tapClients, -1)
+		storeTapViewLocked()
+		close(c.done)
+	})
+}
+
+// publishTap to send the event to subscribers of its strategy
+// boost了trace的策略, 事件同时写入日志
+func publishTap(ev *TapEvent) {
+	if boostedTrace(ev.StrategyID) {
+		dlog.Infof("[boost trace][sid:%d][type:%s][log_tms:%d][value:%v][tags:%v][reason:%s] %s",
+			ev.StrategyID, ev.Type, ev.LogTms, ev.Value, ev.Tags, ev.Reason, ev.Line)
+	}
+	for _, c := range tapClientsOf(ev.StrategyID) {
+		if ev.Type != TapMatch && !c.Verbose {
+			continue
+		}
+		if !c.limiter.Allow() {
+			atomic.AddInt64(&c.dropped, 1)
+			continue
+		}
+		select {
+		case c.events <- ev:
+			atomic.AddInt64(&c.sent, 1)
+		default:
+			atomic.StoreInt32(&c.slow, 1)
+			c.Close()
+		}
+	}
+}
+
+// tapPoint to publish an emitted point
+func tapPoint(point *AnalysPoint, line string) {
+	tags := make(map[string]string, len(point.Tags))
+	for k, v := range point.Tags {
+		tags[k] = v
+	}
+	publishTap(&TapEvent{
+		Type:       TapMatch,
+		StrategyID: point.StrategyID,
+		LogTms:     point.LogTms,
+		EventTms:   point.EventTms,
+		Value:      tapValue(point.Value),
+		Tags:       tags,
+		Line:       redactLine(line),
+	})
+}
+
+// tapDecision to publish a line not emitted
+func tapDecision(typ string, sid, logTms int64, line, reason string) {
+	publishTap(&TapEvent{
+		Type:       typ,
+		StrategyID: sid,
+		LogTms:     logTms,
+		Line:       redactLine(line),
+		Reason:     reason,
+	})
+}
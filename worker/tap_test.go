@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+	"github.com/didi/falcon-log-agent/reader"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+const tapFile = "/home/app/log/tap.log"
+
+func startTapWorker(t *testing.T) (chan reader.Line, *Worker) {
+	pat, _ := utils.GetPatAndTimeFormat("yyyy-mm-dd HH:MM:SS")
+	st := &scheme.Strategy{
+		ID:         1,
+		Name:       "api.cost",
+		FilePath:   tapFile,
+		TimeFormat: "yyyy-mm-dd HH:MM:SS",
+		Pattern:    `cost=([0-9]+)`,
+		Exclude:    "healthcheck",
+		Interval:   60,
+		Func:       "sum",
+		Degree:     1,
+		Tags:       map[string]string{"path": `path=(\S+)`},
+		TimeReg:    regexp.MustCompile(pat),
+		PatternReg: regexp.MustCompile(`cost=([0-9]+)`),
+		ExcludeReg: regexp.MustCompile("healthcheck"),
+		TagRegs:    map[string]*regexp.Regexp{"path": regexp.MustCompile(`path=(\S+)`)},
+		ParseSucc:  true,
+	}
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{st})
+
+	stream := make(chan reader.Line, 1000)
+	w := &Worker{
+		FilePath: tapFile,
+		Stream:   stream,
+		Close:    make(chan struct{}),
+		Mark:     "[worker][tap]",
+		Callback: func(int64, int64) {},
+		Accept:   func(int64) bool { return true },
+	}
+	w.Start()
+	return stream, w
+}
+
+func nextTap(t *testing.T, c *TapClient) *TapEvent {
+	select {
+	case ev := <-c.Events():
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+func TestTapStream(t *testing.T) {
+	defer strategy.UpdateGlobalStrategy(nil)
+	stream, w := startTapWorker(t)
+	defer w.Stop()
+
+	c := SubscribeTap(1, true)
+	now := time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05")
+	stream <- reader.Line{Text: now + " path=/api/a cost=42 token=abc123"}
+	stream <- reader.Line{Text: now + " path=/healthcheck cost=1"}
+	stream <- reader.Line{Text: now + " cost=7"}
+
+	logTms, _ := time.ParseInLocation("2006-01-02 15:04:05", now, time.Local)
+	ev := nextTap(t, c)
+	if ev.Type != TapMatch || ev.Value != float64(42) || ev.Tags["path"] != "/api/a" || ev.LogTms != logTms.Unix() {
+		t.Errorf("unexpected match event: %+v", ev)
+	}
+	if strings.Contains(ev.Line, "abc123") || !strings.Contains(ev.Line, "token=***") {
+		t.Errorf("line should be redacted: %s", ev.Line)
+	}
+	if ev = nextTap(t, c); ev.Type != TapExclude {
+		t.Errorf("expect exclude event, got %+v", ev)
+	}
+	if ev = nextTap(t, c); ev.Type != TapMiss || !strings.Contains(ev.Reason, "tag path") {
+		t.Errorf("expect miss event, got %+v", ev)
+	}
+
+	// 限速: 默认每秒50个, 其余计入summary的dropped
+	c.Summary()
+	total := int64(300)
+	for i := int64(0); i < total; i++ {
+		stream <- reader.Line{Text: now + " path=/api/b cost=1"}
+	}
+	var sent, dropped int64
+	for deadline := time.Now().Add(3 * time.Second); sent+dropped < total && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		s := c.Summary()
+		sent, dropped = sent+s.Sent, dropped+s.Dropped
+	}
+	if sent+dropped != total || dropped == 0 || sent > 100 || int64(len(c.Events())) != sent {
+		t.Errorf("events should be rate limited: sent %d dropped %d buffered %d", sent, dropped, len(c.Events()))
+	}
+
+	// 断开后取消订阅, 发射路径不再有订阅者
+	c.Close()
+	stream <- reader.Line{Text: now + " path=/api/c cost=1"}
+	if tapping() || TapClientCount() != 0 {
+		t.Errorf("client should be unsubscribed, %d left", TapClientCount())
+	}
+}
+
+func TestTapSlowClient(t *testing.T) {
+	c := SubscribeTap(1, false)
+	defer c.Close()
+	if !tapping() || TapClientCount() != 1 {
+		t.Fatal("client should be subscribed")
+	}
+	c.limiter = NewLocalRateLimiter(1000000, 0)
+	// 只有订阅了的策略才生成事件
+	if !tapOn(1) || tapOn(2) {
+		t.Errorf("events should be on for subscribed strategy only")
+	}
+
+	// 非verbose不接收miss
+	publishTap(&TapEvent{Type: TapMiss, StrategyID: 1})
+	// 其他策略的事件不接收
+	publishTap(&TapEvent{Type: TapMatch, StrategyID: 2})
+	if len(c.events) != 0 {
+		t.Fatalf("unexpected events: %d", len(c.events))
+	}
+
+	for i := 0; i <= cap(c.events); i++ {
+		publishTap(&TapEvent{Type: TapMatch, StrategyID: 1})
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("slow client should be disconnected")
+	}
+	if !c.Slow() || tapping() {
+		t.Errorf("slow client should be unsubscribed")
+	}
+	if s := c.Summary(); s.Sent != int64(cap(c.events)) {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+	// 重复Close无影响
+	c.Close()
+	if TapClientCount() != 0 {
+		t.Errorf("client count should not go negative: %d", TapClientCount())
+	}
+}
+
+func TestRedactLine(t *testing.T) {
+	got := redactLine(`user=a password="p w" Authorization: Bearer token=abc`)
+	if got != `user=a password=*** Authorization: *** token=***` {
+		t.Errorf("unexpected redacted line: %s", got)
+	}
+}
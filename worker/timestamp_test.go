@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+func timestampStrategy(format string) *scheme.Strategy {
+	pat, _ := utils.GetPatAndTimeFormat(format)
+	return &scheme.Strategy{ID: 1, TimeFormat: format, TimeReg: regexp.MustCompile(pat)}
+}
+
+func TestExtractTimestamp(t *testing.T) {
+	now := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		format, line, want, wantFormat string
+	}{
+		{"dd/mmm/yyyy:HH:MM:SS", `1.1.1.1 [01/Jan/2018:12:00:01 +0800] "GET /"`, "01/Jan/2018:12:00:01", "02/Jan/2006:15:04:05"},
+		{"yyyy-mm-dd HH:MM:SS", "2018-01-01 12:00:01 error", "2018-01-01 12:00:01", "2006-01-02 15:04:05"},
+		{"yyyymmdd HH:MM:SS", "20180101 12:00:01 error", "20180101 12:00:01", "20060102 15:04:05"},
+		{"otlp_unix_nano", "1514779200000000000 pay", "1514779200000000000", "otlp_unix_nano"},
+		// 补年份, 合并多余空格
+		{"mmm dd HH:MM:SS", "Jan  5 08:01:02 host01 kernel: oom", "2018 Jan 5 08:01:02", "2006 Jan 2 15:04:05"},
+		{"mmm dd HH:MM:SS", "Dec 25 08:01:02 host01 kernel: oom", "2018 Dec 25 08:01:02", "2006 Jan 2 15:04:05"},
+	}
+	for _, c := range cases {
+		got, format, err := extractTimestamp(c.line, timestampStrategy(c.format), now)
+		if err != nil || got != c.want || format != c.wantFormat {
+			t.Errorf("extractTimestamp(%q, %s) = %q, %q, %v; want %q, %q", c.line, c.format, got, format, err, c.want, c.wantFormat)
+		}
+	}
+
+	if _, _, err := extractTimestamp("no time here", timestampStrategy("yyyy-mm-dd HH:MM:SS"), now); err == nil {
+		t.Error("line without timestamp should fail")
+	}
+}
+
+// 时间串的规整只作用于拷贝, pattern和tag仍然基于原始行(含两个空格)
+func TestExtractTimestampKeepsLine(t *testing.T) {
+	st := timestampStrategy("mmm dd HH:MM:SS")
+	st.Pattern = `^Jan  5 \S+ \S+ app: cost=(\d+)`
+	st.PatternReg = regexp.MustCompile(st.Pattern)
+	st.Tags = map[string]string{"host": `^Jan  5 \S+ (\S+)`}
+	st.TagRegs = map[string]*regexp.Regexp{"host": regexp.MustCompile(st.Tags["host"])}
+
+	line := "Jan  5 08:01:02 host01 app: cost=35"
+	orig := line
+	w := &Worker{Mark: "[worker][timestamp test]", Callback: func(int64, int64) {}}
+	p, err := w.producer(line, st)
+	if err != nil || p == nil {
+		t.Fatalf("producer failed: %v", err)
+	}
+	if line != orig {
+		t.Fatalf("line mutated: %q", line)
+	}
+	if p.Value != 35 || p.Tags["host"] != "host01" {
+		t.Errorf("value and tags should refer to the original line, got %v %v", p.Value, p.Tags)
+	}
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	const nano = 1514779200123456789
+	cases := []struct {
+		precision string
+		want      int64
+	}{
+		{"", 1514779200},
+		{scheme.TimestampSecond, 1514779200},
+		{scheme.TimestampMillisecond, 1514779200123},
+		{scheme.TimestampNanosecond, nano},
+	}
+	for _, c := range cases {
+		st := timestampStrategy(utils.TimeFormatUnixNano)
+		st.Pattern = `cost=(\d+)`
+		st.PatternReg = regexp.MustCompile(st.Pattern)
+		st.TimestampPrecision = c.precision
+		w := &Worker{Mark: "[worker][timestamp test]", Callback: func(int64, int64) {}}
+		p, err := w.producer("1514779200123456789 cost=3", st)
+		if err != nil || p == nil {
+			t.Fatalf("producer failed: %v", err)
+		}
+		// 聚合及调试用的时间仍为秒
+		if p.EventTms != c.want || p.LogTms != 1514779200 {
+			t.Errorf("precision %q: event tms %d log tms %d, want %d", c.precision, p.EventTms, p.LogTms, c.want)
+		}
+		if pb := toPB(p); fromPB(pb).EventTms != c.want {
+			t.Errorf("precision %q: event tms lost in sink encoding", c.precision)
+		}
+	}
+}
+
+func TestParseTimestampTimeZone(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	now := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	line := "2018-01-01 12:00:01 error"
+
+	// 没有经过加载的策略按本机时区
+	want := time.Date(2018, 1, 1, 12, 0, 1, 0, time.Local).Unix()
+	if tms, found, err := parseTimestamp(line, st, now); !found || err != nil || tms.Unix() != want {
+		t.Errorf("default time zone should be the local zone, got %v %v", tms, err)
+	}
+	for loc, want := range map[*time.Location]int64{time.UTC: 1514808001, ny: 1514826001} {
+		st.TimeLoc = loc
+		if tms, _, err := parseTimestamp(line, st, now); err != nil || tms.Unix() != want {
+			t.Errorf("%s: expect %d, got %d %v", loc, want, tms.Unix(), err)
+		}
+	}
+
+	// 时间后的时区偏移优先于策略的时区
+	st.TimeLoc = ny
+	for line, want := range map[string]int64{
+		"2018-01-01 12:00:01 +0800 error":  1514779201,
+		"2018-01-01 12:00:01+08:00 error":  1514779201,
+		"2018-01-01 12:00:01 -0530 error":  1514827801,
+		"2018-01-01 12:00:01Z error":       1514808001,
+		"2018-01-01 12:00:01 +1000ms cost": 1514826001, //后面紧跟字母的是取值, 不是偏移
+		"2018-01-01 12:00:01 -1700 error":  1514826001, //超出范围, 不是偏移
+		"2018-01-01 12:00:01 -0007 error":  1514826001,
+		"2018-01-01 12:00:01 Zebra":        1514826001,
+	} {
+		if tms, _, err := parseTimestamp(line, st, now); err != nil || tms.Unix() != want {
+			t.Errorf("%q: expect %d, got %d %v", line, want, tms.Unix(), err)
+		}
+	}
+	// time_format本身带时区的按格式解析
+	nginx := timestampStrategy("dd/mmm/yyyy:HH:MM:SS Z")
+	nginx.TimeLoc = ny
+	if tms, _, err := parseTimestamp("[01/Jan/2018:12:00:01 +0800]", nginx, now); err != nil || tms.Unix() != 1514779201 {
+		t.Errorf("zone in time_format should be used, got %d %v", tms.Unix(), err)
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoneOffsetReg 时间串之后紧跟的时区偏移, 如" +0800"、"+08:00"、"Z"
+var zoneOffsetReg = regexp.MustCompile(`^(?: ?([+-])([01][0-9]):?([0-5][0-9])|Z)(?:[^0-9A-Za-z]|$)`)
+
+// zoneOffsets 按偏移秒数缓存的固定时区
+var zoneOffsets sync.Map
+
+// trailingZone to get the zone of the offset following the timestamp in the line
+// time_format本身带时区的按格式解析; 小时超过14或分钟不是整刻钟的不像时区偏移, 不使用
+func trailingZone(rest, timeFormat string) (*time.Location, bool) {
+	if strings.Contains(timeFormat, "-0700") || strings.Contains(timeFormat, "Z07") || strings.Contains(timeFormat, "MST") {
+		return nil, false
+	}
+	m := zoneOffsetReg.FindStringSubmatch(rest)
+	if m == nil {
+		return nil, false
+	}
+	if m[1] == "" {
+		return time.UTC, true
+	}
+	hours, _ := strconv.Atoi(m[2])
+	minutes, _ := strconv.Atoi(m[3])
+	if hours > 14 || minutes%15 != 0 {
+		return nil, false
+	}
+	offset := hours*3600 + minutes*60
+	if m[1] == "-" {
+		offset = -offset
+	}
+	if loc, ok := zoneOffsets.Load(offset); ok {
+		return loc.(*time.Location), true
+	}
+	loc, _ := zoneOffsets.LoadOrStore(offset, time.FixedZone("", offset))
+	return loc.(*time.Location), true
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+var (
+	tombstonesLock sync.Mutex
+	tombstones     = make(map[int64]time.Time) //已推送过退役点的策略及其RetireAt
+)
+
+// tombstonePoint to build the retirement point of a strategy
+// 在[RetireAt, RetireAt+step)内返回一个不带tag的点, 推送过或不在窗口内返回nil;
+// 时间戳为RetireAt之后的第一个周期, RetireAt所在的周期可能已有退役前的数据, 不能覆盖
+func tombstonePoint(st *scheme.Strategy, now time.Time, endpoint string) *FalconPoint {
+	if !st.Retired(now) || !now.Before(st.RetireAt.Add(time.Duration(st.Interval)*time.Second)) {
+		return nil
+	}
+	tombstonesLock.Lock()
+	defer tombstonesLock.Unlock()
+	// 同一个RetireAt只推一次, 修改了RetireAt可以再推
+	if at, ok := tombstones[st.ID]; ok && at.Equal(st.RetireAt) {
+		return nil
+	}
+	tombstones[st.ID] = st.RetireAt
+	return &FalconPoint{
+		Endpoint:    endpoint,
+		Metric:      "log." + st.Name,
+		Timestamp:   retirementTms(st.Interval, st.RetireAt.Unix()),
+		Step:        st.Interval,
+		Value:       st.RetirementValue,
+		Tags:        "",
+		CounterType: "GAUGE",
+	}
+}
+
+// retirementTms to get the start of the first period at or after the retire time
+func retirementTms(step, retireAt int64) int64 {
+	tms := AlignStepTms(step, retireAt)
+	if tms < retireAt {
+		tms += step
+	}
+	return tms
+}
+
+// pushTombstones to push retirement points of strategies in their retirement period
+func pushTombstones(sts map[int64]*scheme.Strategy, now time.Time, endpoint string) {
+	for _, st := range sts {
+		if p := tombstonePoint(st, now, endpoint); p != nil {
+			dlog.Infof("push retirement point [sid:%d][metric:%s][retire_at:%s][value:%v]",
+				st.ID, p.Metric, st.RetireAt.Format(time.RFC3339), p.Value)
+			pushQueue <- p
+		}
+	}
+}
+
+func cleanTombstones(strategyMap map[int64]*scheme.Strategy) {
+	tombstonesLock.Lock()
+	defer tombstonesLock.Unlock()
+	for id := range tombstones {
+		if st, ok := strategyMap[id]; !ok || st.RetireAt.IsZero() {
+			delete(tombstones, id)
+		}
+	}
+}
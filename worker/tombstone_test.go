@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func TestTombstonePoint(t *testing.T) {
+	defer cleanTombstones(nil)
+	retireAt := time.Unix(1700000030, 0)
+	st := &scheme.Strategy{ID: 7, Name: "api.cost", Interval: 60, RetireAt: retireAt, RetirementValue: -1}
+
+	if p := tombstonePoint(st, retireAt.Add(-time.Second), "host1"); p != nil {
+		t.Fatalf("should not push before retire_at: %+v", p)
+	}
+	p := tombstonePoint(st, retireAt.Add(10*time.Second), "host1")
+	if p == nil || p.Metric != "log.api.cost" || p.Value != -1 || p.Timestamp != 1700000040 || p.Step != 60 || p.Endpoint != "host1" || p.Tags != "" {
+		t.Fatalf("unexpected retirement point: %+v", p)
+	}
+	// 只推一次
+	if p := tombstonePoint(st, retireAt.Add(20*time.Second), "host1"); p != nil {
+		t.Fatalf("should push once: %+v", p)
+	}
+	// 修改RetireAt后可以再推, 超过一个周期不再推
+	st.RetireAt = retireAt.Add(time.Hour)
+	if p := tombstonePoint(st, st.RetireAt.Add(60*time.Second), "host1"); p != nil {
+		t.Fatalf("should not push after the retirement period: %+v", p)
+	}
+	if p := tombstonePoint(st, st.RetireAt, "host1"); p == nil || p.Timestamp != 1700003640 {
+		t.Fatalf("should push for the new retire_at: %+v", p)
+	}
+	// 退役时间在周期边界上时就是该周期
+	if tms := retirementTms(60, 1700000040); tms != 1700000040 {
+		t.Fatalf("aligned retire time should be kept, got %d", tms)
+	}
+
+	// 没有配置RetireAt的策略
+	if p := tombstonePoint(&scheme.Strategy{ID: 8, Interval: 60}, time.Now(), "host1"); p != nil {
+		t.Fatalf("unexpected point: %+v", p)
+	}
+	if st.Retired(st.RetireAt.Add(-time.Nanosecond)) || !st.Retired(st.RetireAt) {
+		t.Fatal("strategy should retire at retire_at")
+	}
+
+	// 策略删除后清理
+	cleanTombstones(map[int64]*scheme.Strategy{})
+	tombstonesLock.Lock()
+	n := len(tombstones)
+	tombstonesLock.Unlock()
+	if n != 0 {
+		t.Fatalf("tombstones should be cleaned, got %d", n)
+	}
+}
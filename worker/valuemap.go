@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// ValueMapStat is the hit counts of value_map entries of one strategy since start
+// 一行命中多个条目时每个条目都计数, 与mode无关, 用于查看条目之间的重叠
+type ValueMapStat struct {
+	Hits     []int64 `json:"hits"`     //按条目的顺序
+	Unmapped int64   `json:"unmapped"` //一个条目都没命中而丢弃的行
+}
+
+var (
+	valueMapStats     = make(map[int64]*ValueMapStat)
+	valueMapStatsLock = new(sync.RWMutex)
+)
+
+// getValueMapStat to get the stat of the strategy, reset when the number of entries changes
+func getValueMapStat(id int64, entries int) *ValueMapStat {
+	valueMapStatsLock.RLock()
+	s, ok := valueMapStats[id]
+	valueMapStatsLock.RUnlock()
+	if ok && len(s.Hits) == entries {
+		return s
+	}
+
+	valueMapStatsLock.Lock()
+	defer valueMapStatsLock.Unlock()
+	if s, ok = valueMapStats[id]; !ok || len(s.Hits) != entries {
+		s = &ValueMapStat{Hits: make([]int64, entries)}
+		valueMapStats[id] = s
+	}
+	return s
+}
+
+// ValueMapStats to get value_map hit counts of all strategies
+func ValueMapStats() map[int64]ValueMapStat {
+	valueMapStatsLock.RLock()
+	defer valueMapStatsLock.RUnlock()
+	ret := make(map[int64]ValueMapStat, len(valueMapStats))
+	for id, s := range valueMapStats {
+		hits := make([]int64, len(s.Hits))
+		for i := range s.Hits {
+			hits[i] = atomic.LoadInt64(&s.Hits[i])
+		}
+		ret[id] = ValueMapStat{Hits: hits, Unmapped: atomic.LoadInt64(&s.Unmapped)}
+	}
+	return ret
+}
+
+// cleanValueMapStats to drop counts of strategies deleted or without value_map
+func cleanValueMapStats(strategyMap map[int64]*scheme.Strategy) {
+	valueMapStatsLock.Lock()
+	defer valueMapStatsLock.Unlock()
+	for id := range valueMapStats {
+		if st, ok := strategyMap[id]; !ok || st.ValueMap == nil {
+			delete(valueMapStats, id)
+		}
+	}
+}
+
+// resolveValueMap to get the value of the line by value_map entries, false means no entry matched
+// 所有条目都会匹配以便计数, 取值按mode从命中的条目中得出
+func resolveValueMap(st *scheme.Strategy, line string) (float64, bool) {
+	m := st.ValueMap
+	stat := getValueMapStat(st.ID, len(m.Entries))
+	var value float64
+	hit := false
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Reg == nil || !e.Reg.MatchString(line) {
+			continue
+		}
+		atomic.AddInt64(&stat.Hits[i], 1)
+		if !hit {
+			value, hit = e.Value, true
+			continue
+		}
+		switch m.Mode {
+		case scheme.ValueMapMaxValue:
+			value = math.Max(value, e.Value)
+		case scheme.ValueMapMinValue:
+			value = math.Min(value, e.Value)
+		case scheme.ValueMapSum:
+			value += e.Value
+		}
+	}
+	if !hit {
+		atomic.AddInt64(&stat.Unmapped, 1)
+	}
+	return value, hit
+}
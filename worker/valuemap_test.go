@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func valueMapStrategy(id int64, mode string) *scheme.Strategy {
+	entry := func(reg string, v float64) scheme.ValueMapEntry {
+		return scheme.ValueMapEntry{Regex: reg, Value: v, Reg: regexp.MustCompile(reg)}
+	}
+	return &scheme.Strategy{ID: id, ValueMap: &scheme.ValueMap{Mode: mode, Entries: []scheme.ValueMapEntry{
+		entry("started", 1), entry("retry", 2), entry("fatal", 5),
+	}}}
+}
+
+func TestResolveValueMap(t *testing.T) {
+	defer cleanValueMapStats(nil)
+	overlap := "started successfully after fatal retry"
+	cases := []struct {
+		mode string
+		want float64
+	}{
+		{"", 1}, //默认first_match
+		{scheme.ValueMapFirstMatch, 1},
+		{scheme.ValueMapMaxValue, 5},
+		{scheme.ValueMapMinValue, 1},
+		{scheme.ValueMapSum, 8},
+	}
+	for i, c := range cases {
+		st := valueMapStrategy(int64(200+i), c.mode)
+		v, ok := resolveValueMap(st, overlap)
+		if !ok || v != c.want {
+			t.Errorf("mode %q: got %v %v, want %v", c.mode, v, ok, c.want)
+		}
+		// 只命中一个条目的行在各mode下一样
+		if v, ok := resolveValueMap(st, "fatal error"); !ok || v != 5 {
+			t.Errorf("mode %q single match: got %v %v, want 5", c.mode, v, ok)
+		}
+		if _, ok := resolveValueMap(st, "nothing here"); ok {
+			t.Errorf("mode %q: unmatched line should not be mapped", c.mode)
+		}
+
+		// 每个命中的条目都计数, 与mode无关
+		stat := ValueMapStats()[st.ID]
+		if !reflect.DeepEqual(stat.Hits, []int64{1, 1, 2}) || stat.Unmapped != 1 {
+			t.Errorf("mode %q: unexpected stat %+v", c.mode, stat)
+		}
+	}
+
+	// 条目数变化后重新计数, 删除的策略被清理
+	st := valueMapStrategy(200, "")
+	st.ValueMap.Entries = st.ValueMap.Entries[:2]
+	resolveValueMap(st, overlap)
+	if stat := ValueMapStats()[200]; !reflect.DeepEqual(stat.Hits, []int64{1, 1}) {
+		t.Errorf("stat should be reset when entries change: %+v", stat)
+	}
+	cleanValueMapStats(map[int64]*scheme.Strategy{200: st, 201: {ID: 201}})
+	if stats := ValueMapStats(); len(stats) != 1 {
+		t.Errorf("stats of strategies deleted or without value_map should be cleaned: %+v", stats)
+	}
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+// ValueRangeStat is the count of out of range values of one strategy since start
+type ValueRangeStat struct {
+	Dropped int64 `json:"dropped"`
+	Clamped int64 `json:"clamped"`
+	Kept    int64 `json:"kept"`
+}
+
+var (
+	valueRangeStats     = make(map[int64]*ValueRangeStat)
+	valueRangeStatsLock = new(sync.RWMutex)
+)
+
+func getValueRangeStat(id int64) *ValueRangeStat {
+	valueRangeStatsLock.RLock()
+	s, ok := valueRangeStats[id]
+	valueRangeStatsLock.RUnlock()
+	if ok {
+		return s
+	}
+
+	valueRangeStatsLock.Lock()
+	defer valueRangeStatsLock.Unlock()
+	if s, ok = valueRangeStats[id]; !ok {
+		s = new(ValueRangeStat)
+		valueRangeStats[id] = s
+	}
+	return s
+}
+
+// ValueRangeStats to get out of range counts of all strategies
+func ValueRangeStats() map[int64]ValueRangeStat {
+	valueRangeStatsLock.RLock()
+	defer valueRangeStatsLock.RUnlock()
+	ret := make(map[int64]ValueRangeStat, len(valueRangeStats))
+	for id, s := range valueRangeStats {
+		ret[id] = ValueRangeStat{
+			Dropped: atomic.LoadInt64(&s.Dropped),
+			Clamped: atomic.LoadInt64(&s.Clamped),
+			Kept:    atomic.LoadInt64(&s.Kept),
+		}
+	}
+	return ret
+}
+
+// cleanValueRangeStats to drop counts of strategies deleted or without value_range
+func cleanValueRangeStats(strategyMap map[int64]*scheme.Strategy) {
+	valueRangeStatsLock.Lock()
+	defer valueRangeStatsLock.Unlock()
+	for id := range valueRangeStats {
+		if st, ok := strategyMap[id]; !ok || st.ValueRange == nil {
+			delete(valueRangeStats, id)
+		}
+	}
+}
+
+// applyValueRange to check the value against value_range, false means the point should be dropped
+// NaN(没有取值)不做检查; 等于边界的值在范围内
+func applyValueRange(st *scheme.Strategy, point *AnalysPoint) bool {
+	r := st.ValueRange
+	if r == nil || math.IsNaN(point.Value) {
+		return true
+	}
+	bound := point.Value
+	if r.Min != nil && point.Value < *r.Min {
+		bound = *r.Min
+	} else if r.Max != nil && point.Value > *r.Max {
+		bound = *r.Max
+	} else {
+		return true
+	}
+
+	stat := getValueRangeStat(st.ID)
+	switch r.OnOutOfRange {
+	case scheme.OutOfRangeKeep:
+		atomic.AddInt64(&stat.Kept, 1)
+		return true
+	case scheme.OutOfRangeClamp:
+		atomic.AddInt64(&stat.Clamped, 1)
+		point.Value = bound
+		point.Tags["clamped"] = "true"
+		return true
+	default:
+		atomic.AddInt64(&stat.Dropped, 1)
+		return false
+	}
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestApplyValueRange(t *testing.T) {
+	defer cleanValueRangeStats(nil)
+	cases := []struct {
+		policy  string
+		value   float64
+		keep    bool
+		want    float64
+		clamped bool
+	}{
+		{scheme.OutOfRangeDrop, -1, false, -1, false},
+		{"", -1, false, -1, false}, //默认drop
+		{scheme.OutOfRangeDrop, 0, true, 0, false},
+		{scheme.OutOfRangeDrop, 100, true, 100, false},
+		{scheme.OutOfRangeDrop, 101, false, 101, false},
+		{scheme.OutOfRangeClamp, -5, true, 0, true},
+		{scheme.OutOfRangeClamp, 500, true, 100, true},
+		{scheme.OutOfRangeClamp, 0, true, 0, false},
+		{scheme.OutOfRangeClamp, 100, true, 100, false},
+		{scheme.OutOfRangeClamp, 50, true, 50, false},
+		{scheme.OutOfRangeKeep, -5, true, -5, false},
+		{scheme.OutOfRangeDrop, math.NaN(), true, math.NaN(), false},
+		{scheme.OutOfRangeClamp, math.NaN(), true, math.NaN(), false},
+	}
+	for i, c := range cases {
+		st := &scheme.Strategy{ID: int64(100 + i), ValueRange: &scheme.ValueRange{Min: float(0), Max: float(100), OnOutOfRange: c.policy}}
+		p := &AnalysPoint{Value: c.value, Tags: map[string]string{}}
+		if got := applyValueRange(st, p); got != c.keep {
+			t.Errorf("policy %q value %v: keep %v, want %v", c.policy, c.value, got, c.keep)
+			continue
+		}
+		if p.Value != c.want && !(math.IsNaN(c.want) && math.IsNaN(p.Value)) {
+			t.Errorf("policy %q value %v: got %v, want %v", c.policy, c.value, p.Value, c.want)
+		}
+		if _, ok := p.Tags["clamped"]; ok != c.clamped {
+			t.Errorf("policy %q value %v: clamped tag %v, want %v", c.policy, c.value, ok, c.clamped)
+		}
+	}
+
+	stats := ValueRangeStats()
+	if stats[100].Dropped != 1 || stats[101].Dropped != 1 || stats[104].Dropped != 1 {
+		t.Errorf("drops should be counted: %+v", stats)
+	}
+	if stats[105].Clamped != 1 || stats[106].Clamped != 1 || stats[110].Kept != 1 {
+		t.Errorf("clamps and keeps should be counted: %+v", stats)
+	}
+	if _, ok := stats[102]; ok {
+		t.Errorf("in range values should not be counted: %+v", stats[102])
+	}
+}
+
+func TestApplyValueRangeOneBound(t *testing.T) {
+	st := &scheme.Strategy{ID: 200, ValueRange: &scheme.ValueRange{Min: float(0), OnOutOfRange: scheme.OutOfRangeClamp}}
+	defer cleanValueRangeStats(nil)
+	p := &AnalysPoint{Value: 1e12, Tags: map[string]string{}}
+	if !applyValueRange(st, p) || p.Value != 1e12 || p.Tags["clamped"] != "" {
+		t.Fatalf("no max should not limit, got %+v", p)
+	}
+	p = &AnalysPoint{Value: -3, Tags: map[string]string{}}
+	if !applyValueRange(st, p) || p.Value != 0 || p.Tags["clamped"] != "true" {
+		t.Fatalf("should clamp to min, got %+v", p)
+	}
+}
+
+func TestProducerValueRange(t *testing.T) {
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.PatternReg = regexp.MustCompile(`cost=(-?\d+)`)
+	st.ValueRange = &scheme.ValueRange{Min: float(0)}
+	defer cleanValueRangeStats(nil)
+	w := &Worker{Mark: "[worker][range]", Callback: func(int64, int64) {}}
+
+	if p, err := w.producer("2018-01-01 12:00:01 cost=-12", st); p != nil || err != nil {
+		t.Fatalf("negative value should be dropped, got %+v %v", p, err)
+	}
+	if p, _ := w.producer("2018-01-01 12:00:01 cost=12", st); p == nil || p.Value != 12 {
+		t.Fatalf("value in range should be kept, got %+v", p)
+	}
+	if ValueRangeStats()[st.ID].Dropped != 1 {
+		t.Fatalf("drop should be counted, got %+v", ValueRangeStats()[st.ID])
+	}
+}
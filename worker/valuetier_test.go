@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func latencyTiers() *scheme.ValueTier {
+	return &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+		{UpperBound: float(100), Label: "fast"},
+		{UpperBound: float(500), Label: "ok"},
+		{Label: "slow"},
+	}}
+}
+
+func TestValueTierLabel(t *testing.T) {
+	tiers := latencyTiers()
+	cases := []struct {
+		v    float64
+		want string
+	}{
+		{-1, "fast"},
+		{100, "fast"}, //等于上界属于该档
+		{100.5, "ok"},
+		{500, "ok"},
+		{501, "slow"}, //兜底
+		{math.Inf(1), "slow"},
+		{math.NaN(), ""},
+	}
+	for _, c := range cases {
+		if got := tiers.Label(c.v); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.v, got, c.want)
+		}
+	}
+
+	// 没有兜底档时超出所有上界不分档
+	tiers.Tiers = tiers.Tiers[:2]
+	if got := tiers.Label(501); got != "" {
+		t.Errorf("value above all bounds should not be tiered, got %q", got)
+	}
+	if tiers.TagName() != scheme.DefaultValueTierTag {
+		t.Errorf("default tag should be %s, got %s", scheme.DefaultValueTierTag, tiers.TagName())
+	}
+}
+
+func TestProducerValueTier(t *testing.T) {
+	defer cleanValueRangeStats(nil)
+	defer cleanValueMapStats(nil)
+	w := &Worker{Mark: "[worker][tier]", Callback: func(int64, int64) {}}
+
+	// 按clamp之后的值分档
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.ID = 300
+	st.PatternReg = regexp.MustCompile(`cost=(\d+)`)
+	st.ValueRange = &scheme.ValueRange{Max: float(1000), OnOutOfRange: "clamp"}
+	st.ValueTier = latencyTiers()
+	st.ValueTier.Tiers[2].UpperBound = float(999)
+	st.ValueTier.Tag = "latency"
+	for line, want := range map[string]string{
+		"2018-01-01 12:00:01 cost=100":  "fast",
+		"2018-01-01 12:00:01 cost=300":  "ok",
+		"2018-01-01 12:00:01 cost=999":  "slow",
+		"2018-01-01 12:00:01 cost=5000": "", //clamp到1000, 超出所有上界
+	} {
+		p, err := w.producer(line, st)
+		if p == nil || err != nil {
+			t.Fatalf("%s: got %+v %v", line, p, err)
+		}
+		if got, ok := p.Tags["latency"]; got != want || ok != (want != "") {
+			t.Errorf("%s: got tier %q, want %q", line, got, want)
+		}
+	}
+
+	// value_map替代取值后再分档, 原有的tag保留
+	st = timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.ID = 301
+	st.PatternReg = regexp.MustCompile(`level=`)
+	st.TagRegs = map[string]*regexp.Regexp{"app": regexp.MustCompile(`app=(\w+)`)}
+	st.Tags = map[string]string{"app": `app=(\w+)`}
+	st.ValueMap = valueMapStrategy(st.ID, "").ValueMap
+	st.ValueTier = &scheme.ValueTier{Tiers: []scheme.ValueTierItem{
+		{UpperBound: float(2), Label: "minor"},
+		{Label: "major"},
+	}}
+	p, _ := w.producer("2018-01-01 12:00:01 level= retry app=pay", st)
+	if p == nil || p.Value != 2 || p.Tags["tier"] != "minor" || p.Tags["app"] != "pay" {
+		t.Fatalf("unexpected point %+v", p)
+	}
+	p, _ = w.producer("2018-01-01 12:00:01 level= fatal app=pay", st)
+	if p == nil || p.Tags["tier"] != "major" {
+		t.Fatalf("unexpected point %+v", p)
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestProducerVariant(t *testing.T) {
+	st := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	st.PatternReg = regexp.MustCompile(`cost=(\d+)`)
+	variant := timestampStrategy("yyyy-mm-dd HH:MM:SS")
+	variant.PatternReg = regexp.MustCompile(`cost=([0-9]+)ms`)
+	variant.ParseSucc = true
+	st.Variant = variant
+	st.VariantWeight = 0.25
+
+	defer func(f func() float64) { variantRand = f }(variantRand)
+	w := &Worker{Mark: "[worker][variant]", Callback: func(int64, int64) {}}
+	line := "2018-01-01 12:00:01 cost=12s"
+
+	cases := []struct {
+		rand    float64
+		variant string
+		value   float64
+	}{
+		{0.1, "test", -1},
+		{0.25, "control", 12},
+		{0.9, "control", 12},
+	}
+	for _, c := range cases {
+		variantRand = func() float64 { return c.rand }
+		p, err := w.producer(line, st)
+		if err != nil || p == nil {
+			t.Fatalf("rand %v: unexpected result %v %v", c.rand, p, err)
+		}
+		if p.Tags["variant"] != c.variant || p.Value != c.value || p.StrategyID != st.ID {
+			t.Errorf("rand %v: got %+v, want variant %s value %v", c.rand, p, c.variant, c.value)
+		}
+	}
+
+	// variant不可用时都按control计算
+	variant.ParseSucc = false
+	variantRand = func() float64 { return 0 }
+	if p, _ := w.producer(line, st); p == nil || p.Tags["variant"] != "control" {
+		t.Errorf("invalid variant should fall back to control: %+v", p)
+	}
+
+	// 未开启时不带variant tag
+	st.VariantWeight = 0
+	if p, _ := w.producer(line, st); p == nil || len(p.Tags) != 0 {
+		t.Errorf("point without variant should have no tag: %+v", p)
+	}
+}
@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/common/utils"
+)
+
+// slideBucket is the observations of one slide of a series
+type slideBucket struct {
+	tms   int64
+	count int64
+	sum   float64
+	max   float64
+	min   float64
+}
+
+// slidingSeries is a ring buffer of per-slide buckets covering one window of a series
+// (timestamp, value)按slide合并, 窗口的边界都在slide上, 合并不影响结果;
+// 保留两个窗口的桶, 推送稍有延迟时新的观测不会覆盖还没推送的窗口; 内存与行数无关
+type slidingSeries struct {
+	slide   int64
+	buckets []slideBucket
+	latest  int64 //最近一次观测所在的桶
+}
+
+func newSlidingSeries(window, slide int64) *slidingSeries {
+	s := &slidingSeries{slide: slide, buckets: make([]slideBucket, 2*window/slide)}
+	for i := range s.buckets {
+		s.buckets[i].tms = -1
+	}
+	return s
+}
+
+// add to put an observation at tms, unmatched only keeps the series alive
+func (s *slidingSeries) add(tms int64, value float64, unmatched bool) {
+	tms = AlignStepTms(s.slide, tms)
+	b := &s.buckets[(tms/s.slide)%int64(len(s.buckets))]
+	if b.tms != tms {
+		// 比环中的数据还早的观测丢弃
+		if b.tms > tms {
+			return
+		}
+		*b = slideBucket{tms: tms, max: math.NaN(), min: math.NaN()}
+	}
+	if tms > s.latest {
+		s.latest = tms
+	}
+	if unmatched {
+		return
+	}
+	b.count++
+	b.sum += value
+	if math.IsNaN(b.max) || value > b.max {
+		b.max = value
+	}
+	if math.IsNaN(b.min) || value < b.min {
+		b.min = value
+	}
+}
+
+// aggregate to get the aggregation of the buckets in [from, to), false if there is none
+func (s *slidingSeries) aggregate(from, to int64) (*PointCounter, bool) {
+	pc := &PointCounter{Max: math.NaN(), Min: math.NaN()}
+	found := false
+	for _, b := range s.buckets {
+		if b.tms < from || b.tms >= to {
+			continue
+		}
+		found = true
+		if b.count == 0 {
+			continue
+		}
+		pc.Count += b.count
+		pc.Sum += b.sum
+		if math.IsNaN(pc.Max) || b.max > pc.Max {
+			pc.Max = b.max
+		}
+		if math.IsNaN(pc.Min) || b.min < pc.Min {
+			pc.Min = b.min
+		}
+	}
+	return pc, found
+}
+
+// slidingWindow is the series of a strategy aggregated over sliding windows
+type slidingWindow struct {
+	sync.Mutex
+	st     *scheme.Strategy //最近一次观测时的策略
+	series map[string]*slidingSeries
+	next   int64 //下一个待推送的窗口的结束时间
+}
+
+// slideResult is the aggregation of one window ending at a slide
+type slideResult struct {
+	tms      int64 //窗口的开始时间, slide等于step时与tumbling窗口相同
+	pointMap map[string]*PointCounter
+}
+
+// due to take the windows ended before now, from oldest
+// 窗口内没有观测的序列不推送, 整个窗口都没有观测的序列被清理
+func (sw *slidingWindow) due(now int64) []slideResult {
+	sw.Lock()
+	defer sw.Unlock()
+	slide := int64(sw.st.WindowSlide / time.Second)
+	window := sw.st.Interval
+	if slide <= 0 || window <= 0 {
+		return nil
+	}
+	if sw.next == 0 {
+		sw.next = AlignStepTms(slide, now) + slide
+		return nil
+	}
+	// 跳过结束在最早的桶之前的窗口, 长时间空闲后不用逐个检查
+	earliest := int64(-1)
+	for _, s := range sw.series {
+		for _, b := range s.buckets {
+			if b.tms >= 0 && (earliest < 0 || b.tms < earliest) {
+				earliest = b.tms
+			}
+		}
+	}
+	if earliest < 0 {
+		if sw.next <= now {
+			sw.next = AlignStepTms(slide, now) + slide
+		}
+		return nil
+	}
+	if sw.next < earliest+slide {
+		sw.next = earliest + slide
+	}
+	ret := make([]slideResult, 0)
+	for ; sw.next <= now; sw.next += slide {
+		from := sw.next - window
+		pointMap := make(map[string]*PointCounter)
+		for tagstring, s := range sw.series {
+			if pc, ok := s.aggregate(from, sw.next); ok {
+				pointMap[tagstring] = pc
+			}
+		}
+		if len(pointMap) > 0 {
+			ret = append(ret, slideResult{tms: from, pointMap: pointMap})
+		}
+	}
+	for tagstring, s := range sw.series {
+		if s.latest < sw.next-window {
+			delete(sw.series, tagstring)
+		}
+	}
+	return ret
+}
+
+var (
+	slidingWindows     = make(map[int64]*slidingWindow)
+	slidingWindowsLock = new(sync.RWMutex)
+)
+
+// observeSliding to add a point of a sliding strategy, by the slide of its Tms
+func observeSliding(st *scheme.Strategy, p *AnalysPoint) {
+	slidingWindowsLock.RLock()
+	sw, ok := slidingWindows[st.ID]
+	slidingWindowsLock.RUnlock()
+	if !ok {
+		slidingWindowsLock.Lock()
+		if sw, ok = slidingWindows[st.ID]; !ok {
+			sw = &slidingWindow{st: st, series: make(map[string]*slidingSeries)}
+			slidingWindows[st.ID] = sw
+		}
+		slidingWindowsLock.Unlock()
+	}
+
+	tagstring := utils.SortedTags(p.Tags)
+	sw.Lock()
+	defer sw.Unlock()
+	// step变化后之前的数据不再适用
+	if sw.st.Interval != st.Interval || sw.st.WindowSlide != st.WindowSlide {
+		sw.series = make(map[string]*slidingSeries)
+		sw.next = 0
+	}
+	sw.st = st
+	s, ok := sw.series[tagstring]
+	if !ok {
+		s = newSlidingSeries(st.Interval, int64(st.WindowSlide/time.Second))
+		sw.series[tagstring] = s
+	}
+	s.add(p.Tms, p.Value, p.Unmatched)
+}
+
+// pushSlidingWindows to push the windows of sliding strategies ended before now
+// 点的step为滑动间隔, 时间戳为窗口的开始时间
+func pushSlidingWindows(now int64, endpoint string) {
+	slidingWindowsLock.RLock()
+	ids := make([]int64, 0, len(slidingWindows))
+	for id := range slidingWindows {
+		ids = append(ids, id)
+	}
+	slidingWindowsLock.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		slidingWindowsLock.RLock()
+		sw, ok := slidingWindows[id]
+		slidingWindowsLock.RUnlock()
+		if !ok {
+			continue
+		}
+		for _, r := range sw.due(now) {
+			st := sw.current()
+			emit := pushEmit(st, r.tms)
+			buildFalconPoints(st, r.tms, r.pointMap, endpoint, func(p *FalconPoint, tags map[string]string) {
+				p.Step = int64(st.WindowSlide / time.Second)
+				emit(p, tags)
+			})
+		}
+	}
+}
+
+// current to get the strategy of the window
+func (sw *slidingWindow) current() *scheme.Strategy {
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.st
+}
+
+// cleanSlidingWindows to drop windows of strategies deleted or no longer sliding
+func cleanSlidingWindows(strategyMap map[int64]*scheme.Strategy) {
+	slidingWindowsLock.Lock()
+	defer slidingWindowsLock.Unlock()
+	for id := range slidingWindows {
+		if st, ok := strategyMap[id]; !ok || !st.Sliding() {
+			delete(slidingWindows, id)
+		}
+	}
+}
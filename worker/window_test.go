@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+func slidingStrategy(id int64, step int64, slide time.Duration) *scheme.Strategy {
+	return &scheme.Strategy{ID: id, Name: "sliding", Func: "sum", Interval: step, WindowType: scheme.WindowSliding, WindowSlide: slide}
+}
+
+// windowValues to get the sum of the series without tags of each window by its start
+func windowValues(rs []slideResult) map[int64]float64 {
+	ret := make(map[int64]float64)
+	for _, r := range rs {
+		if pc, ok := r.pointMap[""]; ok {
+			ret[r.tms] = pc.Sum
+		}
+	}
+	return ret
+}
+
+func TestSlidingWindow(t *testing.T) {
+	defer cleanSlidingWindows(nil)
+	st := slidingStrategy(401, 60, 20*time.Second)
+	const base = 1514779200
+	for i, v := range []float64{1, 2, 4, 8} {
+		observeSliding(st, &AnalysPoint{StrategyID: st.ID, Value: v, Tms: int64(base + i*20 + 5)})
+	}
+	sw := slidingWindows[st.ID]
+	if rs := sw.due(base + 1); len(rs) != 0 {
+		t.Fatalf("first check should only set the next slide, got %+v", rs)
+	}
+
+	// 长度60s的窗口每20s推送一次, 相邻窗口重叠40s
+	got := windowValues(sw.due(base + 80))
+	want := map[int64]float64{base - 40: 1, base - 20: 3, base: 7, base + 20: 14}
+	if len(got) != len(want) {
+		t.Fatalf("windows %v, want %v", got, want)
+	}
+	for tms, v := range want {
+		if got[tms] != v {
+			t.Errorf("window at %d = %v, want %v", tms, got[tms], v)
+		}
+	}
+
+	// 已推送的窗口不重复, 没有观测的窗口不推送, 序列随之清理
+	got = windowValues(sw.due(base + 200))
+	want = map[int64]float64{base + 40: 12, base + 60: 8}
+	if len(got) != len(want) || got[base+40] != 12 || got[base+60] != 8 {
+		t.Fatalf("windows %v, want %v", got, want)
+	}
+	if len(sw.series) != 0 {
+		t.Fatalf("%d series left after their window passed", len(sw.series))
+	}
+}
+
+// slide等于step时与tumbling窗口相同, 补零的点只保留序列
+func TestSlidingWindowAsTumbling(t *testing.T) {
+	defer cleanSlidingWindows(nil)
+	st := slidingStrategy(402, 60, time.Minute)
+	st.Func = "cnt"
+	const base = 1514779200
+	sw := &slidingWindow{st: st, series: make(map[string]*slidingSeries), next: base}
+	slidingWindows[st.ID] = sw
+	for _, tms := range []int64{base + 1, base + 30, base + 59, base + 60} {
+		observeSliding(st, &AnalysPoint{StrategyID: st.ID, Value: 1, Tms: tms})
+	}
+	rs := sw.due(base + 61)
+	observeSliding(st, &AnalysPoint{StrategyID: st.ID, Value: -1, Tms: base + 130, Unmatched: true})
+	rs = append(rs, sw.due(base+180)...)
+	counts := make(map[int64]int64)
+	for _, r := range rs {
+		counts[r.tms] = r.pointMap[""].Count
+	}
+	if len(counts) != 3 || counts[base] != 3 || counts[base+60] != 1 || counts[base+120] != 0 {
+		t.Fatalf("counts %v, want 3, 1 and a zero window", counts)
+	}
+}
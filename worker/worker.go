@@ -3,36 +3,52 @@ package worker
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/didi/falcon-log-agent/reader"
 	"github.com/didi/falcon-log-agent/strategy"
 
 	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/errstore"
 	"github.com/didi/falcon-log-agent/common/g"
 	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/proc/ticker"
 	"github.com/didi/falcon-log-agent/common/sample_log"
 	"github.com/didi/falcon-log-agent/common/scheme"
 	"github.com/didi/falcon-log-agent/common/utils"
 )
 
 type callbackHandler func(int64, int64)
+type acceptHandler func(int64) bool
 
 // Worker to analysis
 // 单个worker对象
 type Worker struct {
-	FilePath  string
-	Counter   int64
-	LatestTms int64 //正在处理的单条日志时间
-	Delay     int64 //时间戳乱序差值, 每个worker独立更新
-	Close     chan struct{}
-	Stream    chan string
-	Mark      string //标记该worker信息，方便打log及上报自监控指标, 追查问题
-	Analyzing bool   //标记当前Worker状态是否在分析中,还是空闲状态
-	Callback  callbackHandler
+	FilePath    string
+	Counter     int64
+	LatestTms   int64 //正在处理的单条日志时间
+	Delay       int64 //时间戳乱序差值, 每个worker独立更新
+	Close       chan struct{}
+	Stream      chan reader.Line
+	Mark        string //标记该worker信息，方便打log及上报自监控指标, 追查问题
+	Analyzing   bool   //标记当前Worker状态是否在分析中,还是空闲状态
+	Callback    callbackHandler
+	Accept      acceptHandler    //判断策略是否归属本worker所在的group
+	Replay      *replayGuard     //未开启防重放时为nil
+	Gate        func() *parkGate //所在group的暂停控制, 为nil时不支持暂停
+	Drain       *streamDrain     //所在group的StopGraceful排空控制, 为nil时停止不排空
+	Aggregator  *stepAggregator  //未开启worker.step_aggregate时为nil
+	Cardinality *tagCardinality  //所在group共享的跨策略tag取值统计, 未开启worker.max_tag_cardinality时为nil
+	freshMs     int64            //正在分析的行的FreshMs, 第一个解析出时间的策略用于估计时钟偏差后清零
+	parseMode   string           //所在group启动时检测出的parse_mode, 供parse_mode为auto的策略使用, 为空按正则
+	line        lineCache        //正在分析的行按策略组共享的时间及pattern结果
+	closeOnce   sync.Once
 }
 
 // WorkerGroup is group of workers
@@ -42,12 +58,31 @@ type WorkerGroup struct {
 	LatestTms          int64 //日志文件最新处理的时间戳
 	MaxDelay           int64 //日志文件存在的时间戳乱序最大差值
 	ResetTms           int64 //maxDelay上次重置的时间
+	DelayChangedTms    int64 //maxDelay上次变大的时间
 	Workers            []*Worker
 	TimeFormatStrategy string
+	Shard              int   //同一文件拆分成多个group时的序号
+	Generation         int64 //创建时分配, 单调递增, 用于区分热加载前后的group
+	filePath           string
+	sharded            bool             //由newShardWorkerGroup创建, worker的Mark带有shard
+	workersLock        sync.RWMutex     //Resize替换Workers、WorkerNum时持有写锁, 不经过life或park锁的读取持有读锁
+	strategyIDs        atomic.Value     //map[int64]struct{}, 未设置时处理该文件的全部策略
+	shed               *shedder         //处理延迟过大时暂停部分策略
+	cardinality        *tagCardinality  //同一文件的各group共享
+	stableWindow       time.Duration    //maxDelay持续这么久没有变大才允许每日重置, 0不等待
+	park               parkState        //Pause/Resume的状态
+	life               groupLife        //created → started → stopping → stopped
+	stream             chan reader.Line //reader写入的队列
+	lifo               *LIFOQueue       //receive_order为lifo时在stream与worker之间, 否则为nil
+	lifoDone           chan struct{}
+	recv               chan reader.Line //lifo时worker收行的channel
+	drain              *streamDrain     //StopGraceful排空控制, 由streamDrain()创建
+	drainOnce          sync.Once
+	fanoutDropped      int64 //拆分成多个shard时, 队列满被fanout丢弃的行数
 }
 
-func (wg WorkerGroup) GetLatestTmsAndDelay() (tms int64, delay int64) {
-	return wg.LatestTms, wg.MaxDelay
+func (wg *WorkerGroup) GetLatestTmsAndDelay() (tms int64, delay int64) {
+	return atomic.LoadInt64(&wg.LatestTms), atomic.LoadInt64(&wg.MaxDelay)
 }
 
 func (wg *WorkerGroup) SetLatestTmsAndDelay(tms int64, delay int64) {
@@ -56,7 +91,7 @@ func (wg *WorkerGroup) SetLatestTmsAndDelay(tms int64, delay int64) {
 	if latest < tms {
 		swapped := atomic.CompareAndSwapInt64(&wg.LatestTms, latest, tms)
 		if swapped {
-			dlog.Debugf("[work group:%s][set latestTms:%d]", wg.Workers[0].Mark, tms)
+			dlog.Debugf("[work group:%s][shard:%d][set latestTms:%d]", wg.filePath, wg.Shard, tms)
 		}
 	}
 
@@ -66,62 +101,189 @@ func (wg *WorkerGroup) SetLatestTmsAndDelay(tms int64, delay int64) {
 
 	newest := atomic.LoadInt64(&wg.MaxDelay)
 	if newest < delay {
-		atomic.CompareAndSwapInt64(&wg.MaxDelay, newest, delay)
+		if atomic.CompareAndSwapInt64(&wg.MaxDelay, newest, delay) {
+			atomic.StoreInt64(&wg.DelayChangedTms, time.Now().Unix())
+		}
 	}
 }
 
 // NewWorkerGroup to new a worker group
 // filepath和stream依赖外部，其他的都自己创建
-func NewWorkerGroup(filePath string, stream chan string, st *scheme.Strategy) *WorkerGroup {
+func NewWorkerGroup(filePath string, stream chan reader.Line, st *scheme.Strategy) *WorkerGroup {
 
 	wg := &WorkerGroup{
-		WorkerNum: g.Conf().Worker.WorkerNum,
-		Workers:   make([]*Worker, 0),
+		WorkerNum:    g.Conf().Worker.WorkerNum,
+		Workers:      make([]*Worker, 0),
+		Generation:   nextGroupGeneration(),
+		filePath:     filePath,
+		shed:         newShedder(),
+		cardinality:  getTagCardinality(filePath),
+		stableWindow: delayStableWindow(),
+		stream:       stream,
 	}
 
 	dlog.Infof("new worker group, [file:%s][worker_num:%d]", filePath, g.Conf().Worker.WorkerNum)
 
 	for i := 0; i < wg.WorkerNum; i++ {
-		mark := fmt.Sprintf("[worker][file:%s][num:%d][id:%d]", filePath, g.Conf().Worker.WorkerNum, i)
-		w := Worker{}
-		w.Close = make(chan struct{})
-		// w.ParentGroup = wg
-		w.FilePath = filePath
-		w.Stream = stream
-		w.Mark = mark
-		w.Analyzing = false
-		w.Counter = 0
-		w.LatestTms = 0
-		w.Delay = 0
-		w.Callback = wg.SetLatestTmsAndDelay
-		wg.Workers = append(wg.Workers, &w)
+		wg.Workers = append(wg.Workers, wg.newWorker(i, stream))
+	}
+	if receiveOrder() == ReceiveOrderLIFO {
+		wg.useLIFO(g.Conf().Worker.QueueSize)
 	}
 
 	return wg
 }
 
+// newWorker to new the i-th worker of the group reading lines from stream
+func (wg *WorkerGroup) newWorker(i int, stream chan reader.Line) *Worker {
+	w := Worker{}
+	w.Close = make(chan struct{})
+	// w.ParentGroup = wg
+	w.FilePath = wg.filePath
+	w.Stream = stream
+	w.Mark = wg.workerMark(i)
+	w.Analyzing = false
+	w.Counter = 0
+	w.LatestTms = 0
+	w.Delay = 0
+	w.Callback = wg.SetLatestTmsAndDelay
+	w.Accept = wg.accept
+	w.Replay = getReplayGuard(wg.filePath)
+	w.Cardinality = wg.cardinality
+	w.Gate = wg.currentGate
+	w.Drain = wg.streamDrain()
+	return &w
+}
+
+// workerMark to format the mark of the i-th worker by the current WorkerNum
+func (wg *WorkerGroup) workerMark(i int) string {
+	if wg.sharded {
+		return fmt.Sprintf("[worker][file:%s][shard:%d][num:%d][id:%d]", wg.filePath, wg.Shard, wg.WorkerNum, i)
+	}
+	return fmt.Sprintf("[worker][file:%s][num:%d][id:%d]", wg.filePath, wg.WorkerNum, i)
+}
+
+// newShardWorkerGroup to new a worker group for one shard of a file's strategies
+func newShardWorkerGroup(filePath string, stream chan reader.Line, shard int) *WorkerGroup {
+	wg := NewWorkerGroup(filePath, stream, nil)
+	wg.Shard = shard
+	wg.sharded = true
+	for i, w := range wg.Workers {
+		w.Mark = wg.workerMark(i)
+	}
+	return wg
+}
+
+// SetStrategyIDs to limit the group to the given strategies
+// 传nil则处理该文件的全部策略; 已停止的group返回ErrGroupStopped
+func (wg *WorkerGroup) SetStrategyIDs(ids []int64) error {
+	if wg.IsStale() {
+		return wg.staleCall("SetStrategyIDs")
+	}
+	if ids == nil {
+		wg.strategyIDs.Store(map[int64]struct{}(nil))
+		return nil
+	}
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	wg.strategyIDs.Store(set)
+	return nil
+}
+
+// Owns to check whether the strategy is handled by this group
+func (wg *WorkerGroup) Owns(id int64) bool {
+	set, _ := wg.strategyIDs.Load().(map[int64]struct{})
+	if set == nil {
+		return true
+	}
+	_, ok := set[id]
+	return ok
+}
+
+// accept to check whether workers of the group should evaluate the strategy
+func (wg *WorkerGroup) accept(id int64) bool {
+	if !wg.Owns(id) {
+		return false
+	}
+	// 维护期暂停优先于降级, 暂停的策略只计数不计算
+	if countPaused(id, wg.filePath) {
+		return false
+	}
+	return !wg.shed.Suspended(id)
+}
+
 // Start to start a workergroup
-func (wg *WorkerGroup) Start() {
+// 已启动的group再次Start不做任何事, 只计数; 停止中或已停止的返回ErrGroupStopped
+func (wg *WorkerGroup) Start() error {
+	wg.life.Lock()
+	defer wg.life.Unlock()
+	switch wg.stateLocked() {
+	case GroupStarted:
+		wg.life.redundantStarts++
+		dlog.Warningf("worker group already started [file:%s][shard:%d][redundant_starts:%d]",
+			wg.filePath, wg.Shard, wg.life.redundantStarts)
+		return nil
+	case GroupStopping, GroupStopped:
+		return wg.staleCall("Start")
+	}
+	if mode, ok := wg.detectParseMode(); ok {
+		for _, worker := range wg.Workers {
+			worker.parseMode = mode
+		}
+	}
 	for _, worker := range wg.Workers {
 		worker.Start()
 	}
+	if wg.lifo != nil {
+		wg.startLIFO()
+	}
+	wg.setStateLocked(GroupStarted)
+	return nil
 }
 
 // Stop to stop a workergroup
+// 可以与Pause/Resume并发调用, Stop之后两者都返回ErrGroupStopped; 重复调用直接返回
+// 立即关闭worker, 队列中尚未取走的行丢弃, 等同于StopGraceful(0)
 func (wg *WorkerGroup) Stop() {
-	for _, worker := range wg.Workers {
-		worker.Stop()
+	wg.StopGraceful(0)
+}
+
+// defaultDelayStableWindow maxDelay默认需要稳定的时长
+const defaultDelayStableWindow = 5 * time.Minute
+
+// delayStableWindow to get how long maxDelay should be stable before the daily reset
+func delayStableWindow() time.Duration {
+	secs := g.Conf().Worker.DelayStableWindow
+	if secs == 0 {
+		return defaultDelayStableWindow
+	}
+	if secs < 0 {
+		return 0
 	}
+	return time.Duration(secs) * time.Second
 }
 
 // ResetMaxDelay reset maxDelay record
 func (wg *WorkerGroup) ResetMaxDelay() {
-	// reset every day, hard code
-	ts := time.Now().Unix()
-	if ts-wg.ResetTms > 86400 {
-		wg.ResetTms = ts
-		atomic.StoreInt64(&wg.MaxDelay, 0)
+	wg.resetMaxDelay(time.Now())
+}
+
+// resetMaxDelay to reset maxDelay every day, unless it grew within stableWindow
+// 乱序正在发生时重置会掩盖它, 推迟到maxDelay稳定后的下一次调用
+func (wg *WorkerGroup) resetMaxDelay(now time.Time) {
+	ts := now.Unix()
+	if ts-wg.ResetTms <= 86400 {
+		return
+	}
+	changed := atomic.LoadInt64(&wg.DelayChangedTms)
+	if wg.stableWindow > 0 && now.Sub(time.Unix(changed, 0)) < wg.stableWindow {
+		dlog.Debugf("[work group:%s][max delay changed at %d, reset is deferred]", wg.filePath, changed)
+		return
 	}
+	wg.ResetTms = ts
+	atomic.StoreInt64(&wg.MaxDelay, 0)
 }
 
 // Start to start a worker
@@ -131,9 +293,11 @@ func (w *Worker) Start() {
 	}()
 }
 
-// Stop to stop a worker
+// Stop to stop a worker, calling more than once is allowed
 func (w *Worker) Stop() {
-	close(w.Close)
+	w.closeOnce.Do(func() {
+		close(w.Close)
+	})
 }
 
 // Work to analysis logs
@@ -141,38 +305,62 @@ func (w *Worker) Work() {
 	defer func() {
 		if reason := recover(); reason != nil {
 			dlog.Infof("%s -- worker quit: panic reason: %v", w.Mark, reason)
+			w.alertPanic(reason, 0)
 		} else {
 			dlog.Infof("%s -- worker quit: normally", w.Mark)
 		}
 	}()
 	dlog.Infof("worker starting...[%s]", w.Mark)
 
-	var anaCnt, anaSwp int64
-	analysClose := make(chan int, 0)
+	if stepAggregateEnabled() {
+		w.Aggregator = newStepAggregator(GlobalCount)
+		registerStepAggregator(w.Aggregator)
+		// 退出时把未推送的step全部合入counter
+		defer unregisterStepAggregator(w.Aggregator)
+	}
 
-	go func() {
-		for {
-			//休眠10s
+	// 分析行数由共享的ticker按周期上报, 退出时上报最后不完整的周期
+	var anaCnt, anaSwp int64
+	report := ticker.Register(w.Mark, func(ticker.Window) {
+		a := atomic.LoadInt64(&anaCnt)
+		metric.MetricAnalysis(w.FilePath, a-anaSwp)
+		anaSwp = a
+	})
+	defer report.Unregister()
+
+	yield := newYielder(cpuYieldLines())
+	for {
+		// 暂停优先于读取新行, 正在处理的行处理完后才会停下
+		var gate *parkGate
+		var pause chan struct{}
+		if w.Gate != nil {
+			gate = w.Gate()
+			pause = gate.pause
 			select {
-			case <-analysClose:
-				return
-			case <-time.After(time.Second * 10):
+			case <-pause:
+				if !w.park(gate) {
+					return
+				}
+				continue
+			default:
 			}
-			a := anaCnt
-			metric.MetricAnalysis(w.FilePath, a-anaSwp)
-			anaSwp = a
 		}
-	}()
-
-	for {
 		select {
+		case <-pause:
+			if !w.park(gate) {
+				return
+			}
 		case line := <-w.Stream:
+			line.Ack()
 			w.Analyzing = true
-			anaCnt = anaCnt + 1
+			atomic.AddInt64(&anaCnt, 1)
 			w.analysis(line)
 			w.Analyzing = false
+			yield.tick()
+		case <-w.drainStart():
+			w.drainStream(&anaCnt, yield)
+			return
 		case <-w.Close:
-			analysClose <- 0
 			return
 		}
 
@@ -182,72 +370,271 @@ func (w *Worker) Work() {
 //内部的分析方法
 //轮全局的规则列表
 //单次遍历
-func (w *Worker) analysis(line string) {
+func (w *Worker) analysis(line reader.Line) {
+	// 只取本文件的策略, 没有策略的文件直接返回
+	sts, groups := strategy.GetByFilePathWithGroups(w.FilePath)
+	if len(sts) == 0 {
+		return
+	}
+	var sid int64 //正在处理的策略, panic告警用
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Infof("%s[analysis panic] : %v", w.Mark, err)
+			w.alertPanic(err, sid)
 		}
 	}()
 
-	sts := strategy.GetAll()
-	for _, strategy := range sts {
-		if strategy.FilePath == w.FilePath && strategy.ParseSucc {
-			analyspoint, err := w.producer(line, strategy)
+	labeled := profiling()
+	if labeled {
+		defer clearStrategyLabels()
+	}
 
-			if err != nil {
-				log := fmt.Sprintf("%s[producer error][sid:%d] : %v", w.Mark, strategy.ID, err)
-				sample_log.Error(log)
-				continue
-			} else {
-				if analyspoint != nil {
-					metric.MetricAnalysisSucc(w.FilePath, 1)
-					toCounter(analyspoint, w.Mark)
+	now := time.Now()
+	w.freshMs = line.FreshMs
+	w.line.reset(groups)
+	var catchAll *scheme.Strategy
+	matched := false //是否有其他策略匹配了该行
+	skipped := false //是否有其他策略不归本group、被降级或暂停而没有判断, 此时无法确定该行没有策略匹配
+	for _, strategy := range sts {
+		if strategy.ParseSucc && len(strategy.CompositeOf) == 0 && !strategy.Retired(now) {
+			if !w.Accept(strategy.ID) {
+				if !strategy.CatchAll {
+					skipped = true
 				}
+				continue
+			}
+			// catch-all策略只处理其他策略都没有匹配的行, 放到最后
+			if strategy.CatchAll {
+				catchAll = strategy
+				continue
 			}
+			sid = strategy.ID
+			if labeled {
+				setStrategyLabels(strategy.ID, w.FilePath)
+			}
+			if w.analysisStrategy(line, strategy, now) {
+				matched = true
+			}
+		}
+	}
+	if catchAll != nil && !matched && !skipped {
+		sid = catchAll.ID
+		if labeled {
+			setStrategyLabels(catchAll.ID, w.FilePath)
+		}
+		w.analysisStrategy(line, catchAll, now)
+	}
+}
+
+// analysisStrategy to analysis the line with one strategy, true if the line matched the strategy
+// 产生了点即为匹配, pattern没匹配到而补零的不算; 之后被防重放、异常检测等丢弃的仍算匹配
+func (w *Worker) analysisStrategy(line reader.Line, strategy *scheme.Strategy, now time.Time) bool {
+	analyspoint, err := w.producer(line.Text, strategy)
+	if err != nil {
+		log := fmt.Sprintf("%s[producer error][sid:%d] : %v", w.Mark, strategy.ID, err)
+		// boost了no_sampling的策略逐条记录
+		if boostedNoSampling(strategy.ID) {
+			dlog.Error(log)
+		} else {
+			sample_log.Error(log)
+		}
+		// 记录及调试输出的行同样要脱敏
+		text := strategy.MaskLine(line.Text)
+		errstore.Record(&errstore.Entry{
+			Timestamp:   time.Now(),
+			StrategyID:  strategy.ID,
+			FilePath:    w.FilePath,
+			LineExcerpt: text,
+			ErrorReason: err.Error(),
+			WorkerID:    w.Mark,
+		})
+		if tapOn(strategy.ID) {
+			tapDecision(TapMiss, strategy.ID, 0, text, err.Error())
+		}
+		return false
+	}
+	if analyspoint == nil {
+		return false
+	}
+	matched := !analyspoint.Unmatched
+
+	text := strategy.MaskLine(line.Text)
+	// 补零的点不是匹配行, 不作为exclude建议的样本
+	if matched {
+		observeMatched(strategy.ID, text)
+	}
+	if w.Replay != nil && !w.Replay.admit(line, strategy.ID, AlignStepTms(strategy.Interval, analyspoint.Tms)) {
+		return matched
+	}
+	if strategy.Func == scheme.FuncEpisodes && !episodeStart(strategy, analyspoint) {
+		return matched
+	}
+	if strategy.AnomalyDetect && detectAnomaly(strategy, analyspoint) {
+		metric.MetricAnomalyPoint(w.FilePath, 1)
+		if strategy.AnomalySuppress {
+			if tapOn(strategy.ID) {
+				tapDecision(TapExclude, strategy.ID, analyspoint.LogTms, text, "anomaly suppressed")
+			}
+			return matched
 		}
 	}
+	if w.Cardinality != nil {
+		w.Cardinality.apply(analyspoint.Tags, now)
+	}
+	if tapOn(strategy.ID) {
+		tapPoint(analyspoint, text)
+	}
+	if strategy.WriteBackPath != "" {
+		writeBack(strategy, text, analyspoint)
+	}
+	metric.MetricAnalysisSucc(w.FilePath, 1)
+	w.toCounter(strategy, analyspoint)
+	if len(strategy.CompositeRefs) > 0 {
+		feedComposites(strategy, analyspoint, w.Mark)
+	}
+	return matched
 }
 
 func (w *Worker) producer(line string, strategy *scheme.Strategy) (*AnalysPoint, error) {
+	// 超长的行截断, 限制之后每个正则的开销及产生的tag
+	line = boundLine(line)
+	// 先脱敏, 之后的时间、pattern、tag都作用于脱敏后的行
+	line = strategy.MaskLine(line)
+	point, err := w.produceVariant(line, strategy)
+	if point == nil {
+		return point, err
+	}
+	point.Gen = strategy.Generation
+	// 累计计数器先取差值, 之后的处理都作用于差值
+	if !applyDelta(strategy, point) {
+		return nil, nil
+	}
+	if d := strategy.ValueRoundDecimals; d != nil && *d >= 0 {
+		point.Value = roundValue(point.Value, *d)
+	}
+	// 超出范围的值先处理, 再做异常检测
+	if strategy.ValueRange != nil && !applyValueRange(strategy, point) {
+		if tapOn(strategy.ID) {
+			tapDecision(TapExclude, strategy.ID, point.LogTms, line, "value out of range")
+		}
+		return nil, nil
+	}
+	applyValueTier(strategy, point)
+	return point, err
+}
+
+// applyValueTier to tag the point with the tier its final value falls in
+// 补零的点没有取值, 不加tag; NaN或超出所有上界(没有兜底档)时也不加
+func applyValueTier(strategy *scheme.Strategy, point *AnalysPoint) {
+	if strategy.ValueTier == nil || point.Unmatched {
+		return
+	}
+	label := strategy.ValueTier.Label(point.Value)
+	if label == "" {
+		return
+	}
+	// extractTags返回的map每个点独有, 可以直接修改
+	if point.Tags == nil {
+		point.Tags = make(map[string]string, 1)
+	}
+	point.Tags[strategy.ValueTier.TagName()] = label
+}
+
+// roundValue to round v to n decimal places, NaN and Inf are kept
+func roundValue(v float64, n int) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	p := math.Pow10(n)
+	r := math.Round(v*p) / p
+	// 位数过大时v*p溢出, 保持原值
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return v
+	}
+	return r
+}
+
+// produceVariant to analysis the line with the strategy or its variant
+func (w *Worker) produceVariant(line string, strategy *scheme.Strategy) (*AnalysPoint, error) {
+	if strategy.VariantWeight <= 0 || strategy.Variant == nil {
+		return w.produce(line, strategy)
+	}
+
+	// A/B测试, 按比例选择variant计算, 两者的点用tag区分
+	st, variant := strategy, "control"
+	if strategy.Variant.ParseSucc && variantRand() < strategy.VariantWeight {
+		st, variant = strategy.Variant, "test"
+	}
+	point, err := w.produce(line, st)
+	if point != nil {
+		point.Tags["variant"] = variant
+	}
+	return point, err
+}
+
+// produce to analysis the line with one strategy
+func (w *Worker) produce(line string, strategy *scheme.Strategy) (*AnalysPoint, error) {
 	defer func() {
 		if err := recover(); err != nil {
+			atomic.AddInt64(&producerPanics, 1)
 			dlog.Errorf("%s[producer panic] : %v", w.Mark, err)
+			w.alertPanic(err, strategy.ID)
 		}
 	}()
 
-	var reg *regexp.Regexp
-	_, timeFormat := utils.GetPatAndTimeFormat(strategy.TimeFormat)
-
-	reg = strategy.TimeReg
-
-	t := reg.FindString(line)
-	if len(t) <= 0 {
-		return nil, fmt.Errorf("cannot get timestamp:[sname:%s][sid:%d][timeFormat:%v]", strategy.Name, strategy.ID, timeFormat)
+	// logfmt/json模式下时间、取值及tag_fields都按key从解析结果中获取
+	var fields map[string]string
+	timeSrc := line
+	mode := strategy.ParseMode
+	if mode == scheme.ParseModeAuto {
+		mode = w.parseMode
 	}
-
-	// 如果没有年，需添加当前年
-	// 需干掉内部的多于空格, 如Dec  7,有的有一个空格，有的有两个，这里统一替换成一个
-	if timeFormat == "Jan 2 15:04:05" {
-		timeFormat = fmt.Sprintf("2006 %s", timeFormat)
-		t = fmt.Sprintf("%d %s", time.Now().Year(), t)
-		reg := regexp.MustCompile(`\s+`)
-		rep := " "
-		t = reg.ReplaceAllString(t, rep)
+	switch mode {
+	case scheme.ParseModeWindowsEventXML:
+		// 时间取TimeCreated, 之后的正则都作用于Message
+		ev, err := reader.ParseWindowsEvent(line)
+		if err != nil {
+			return nil, err
+		}
+		fields, timeSrc, line = ev.Fields(), ev.TimeCreated.SystemTime, ev.Text()
+		if strategy.TimeField != "" {
+			timeSrc = fields[strategy.TimeField]
+		}
+	case scheme.ParseModeLogfmt, scheme.ParseModeJSON:
+		var err error
+		if mode == scheme.ParseModeJSON {
+			fields, err = jsonLineParser.Parse(line)
+		} else {
+			fields, err = logfmtParser.Parse(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strategy.TimeField != "" {
+			v, ok := fields[strategy.TimeField]
+			if !ok {
+				return nil, fmt.Errorf("cannot get time field:[sname:%s][sid:%d][field:%s]", strategy.Name, strategy.ID, strategy.TimeField)
+			}
+			timeSrc = v
+		}
 	}
 
-	// [风险]统一使用东八区
-	loc, err := time.LoadLocation("Asia/Shanghai")
-	tms, err := time.ParseInLocation(timeFormat, t, loc)
-	dlog.Debugf("日志获取到的时间： %v",t)
-	dlog.Debugf("日志时间转换为tms时间： %v",tms)
+	// 同一策略组的时间每行只提取一次
+	tms, err := w.lineTimestamp(timeSrc, strategy, time.Now())
 	if err != nil {
 		return nil, err
 	}
 
+	// 在丢弃超前的时间戳之前, 时钟偏快的机器写的行也要计入
+	if w.freshMs != 0 {
+		observeClockSkew(w.FilePath, tms, time.Unix(0, w.freshMs*int64(time.Millisecond)))
+		w.freshMs = 0
+	}
+
 	tmsUnix := tms.Unix()
 	// 日志时间戳大于机器时间, 直接丢弃, 脏数据影响 latestTms 对推点的逻辑判断
 	if tmsUnix > time.Now().Unix() {
-		dlog.Debugf("%s[illegal timestamp][id:%d][tmsUnix:%d][current:%d]",
+		boostDebugf(strategy.ID, "%s[illegal timestamp][id:%d][tmsUnix:%d][current:%d]",
 			w.Mark, strategy.ID, tmsUnix, time.Now().Unix())
 		return nil, fmt.Errorf("illegal timestamp, greater than current")
 	}
@@ -261,7 +648,7 @@ func (w *Worker) producer(line string, strategy *scheme.Strategy) (*AnalysPoint,
 		w.LatestTms = tmsUnix
 
 	} else if w.LatestTms > tmsUnix {
-		dlog.Debugf("%s[timestamp disorder][id:%d][latest:%d][producing:%d]",
+		boostDebugf(strategy.ID, "%s[timestamp disorder][id:%d][latest:%d][producing:%d]",
 			w.Mark, strategy.ID, w.LatestTms, tmsUnix)
 
 		delay = w.LatestTms - tmsUnix
@@ -273,72 +660,101 @@ func (w *Worker) producer(line string, strategy *scheme.Strategy) (*AnalysPoint,
 	//处理用户正则
 	var patternReg, excludeReg *regexp.Regexp
 	var value float64
+	matched := false //匹配了pattern(或没有配置pattern), 补零的-1不算
 	patternReg = strategy.PatternReg
 	dlog.Debugf("用户正则表达式： %v",patternReg)
-	if patternReg != nil {
-		hostname := fmt.Sprintf("v%",patternReg)
-		v := patternReg.FindStringSubmatch(line)
-		var vString string
-		if v != nil && len(v) != 0 {
-			if len(v) > 1 {
-				vString = v[1]
-				dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的字符串： %v",v)
-				dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的被匹配行： %v",line)
-				dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的vString： %v",vString)
-
-			} else {
-				vString = ""
+	if fields != nil && strategy.ValueField != "" {
+		// 配置了pattern时作为过滤条件, 匹配不到不产生点
+		if patternReg != nil && !patternReg.MatchString(line) {
+			if tapOn(strategy.ID) {
+				tapDecision(TapMiss, strategy.ID, tmsUnix, line, "pattern not matched")
 			}
-			value, err = strconv.ParseFloat(vString, 64)
-			dlog.Debugf("用户正则转换vString是否存在err： %v",err)
-			if err != nil {
-				value = math.NaN()
-				//value = -1
+			return nil, nil
+		}
+		v, ok := fields[strategy.ValueField]
+		if !ok {
+			if tapOn(strategy.ID) {
+				tapDecision(TapMiss, strategy.ID, tmsUnix, line, "value field "+strategy.ValueField+" not found")
 			}
-		} else {
-			//外边匹配err之后，要确保返回值不是nil再推送至counter
-			//正则有表达式，没匹配到，直接返回
-			//return nil, nil
-			//https://www.nhooo.com/golang/go-given-characters-in-string.html
-			if strings.Contains(hostname, "d+") {
-				dlog.Debugf("用户正则匹配到的字符串包含d+,字符串是： %v",hostname)
-				return nil, nil
-
-			} else {
-				value = -1
+			return nil, nil
+		}
+		value = fieldValue(v)
+		matched = true
+	} else if patternReg != nil {
+		var ok bool
+		if value, matched, ok = matchValue(line, w.patternSubmatch(line, strategy), strategy); !ok {
+			if tapOn(strategy.ID) {
+				tapDecision(TapMiss, strategy.ID, tmsUnix, line, "pattern not matched")
 			}
-			
-			//匹配不到将每次的值置为-1
+			return nil, nil
 		}
-
 	} else {
 		value = math.NaN()
+		matched = true
 	}
 	dlog.Debugf("用户正则value： %v",value)
 
+	var funnel *FunnelStat
+	if funnelTracked(strategy) {
+		funnel = getFunnelStat(strategy.ID)
+		if matched {
+			atomic.AddInt64(&funnel.Matched, 1)
+		}
+	}
+
+	//处理must_not_contain, 只作用于匹配了pattern的行, 在exclude之前
+	if matched {
+		if clause, ok := mustNotContain(strategy, line); ok {
+			if funnel != nil {
+				atomic.AddInt64(&funnel.MustNotContain, 1)
+			}
+			if tapOn(strategy.ID) {
+				tapDecision(TapExclude, strategy.ID, tmsUnix, line, "must_not_contain matched: "+clause)
+			}
+			return nil, nil
+		}
+	}
+
 	//处理exclude
 	excludeReg = strategy.ExcludeReg
 	if excludeReg != nil {
 		v := excludeReg.FindStringSubmatch(line)
 		if v != nil && len(v) != 0 {
 			//匹配到exclude了，需要返回
+			if funnel != nil {
+				atomic.AddInt64(&funnel.Excluded, 1)
+			}
+			if matched && excludeRatioEnabled(strategy) {
+				recordExcludeRatio(strategy, time.Now().Unix(), true)
+			}
+			if tapOn(strategy.ID) {
+				tapDecision(TapExclude, strategy.ID, tmsUnix, line, "exclude matched")
+			}
 			return nil, nil
 		}
 	}
 
 	//处理tag 正则
-	tag := map[string]string{}
-	for tagk, tagv := range strategy.Tags {
-		var regTag *regexp.Regexp
-		regTag, ok := strategy.TagRegs[tagk]
-		if !ok {
-			dlog.Errorf("%s[get tag reg error][sid:%d][tagk:%s][tagv:%s]", w.Mark, strategy.ID, tagk, tagv)
-			return nil, nil
+	//策略发布后只读, 热加载替换整个策略表而不是修改Tags, 遍历不需要加锁
+	tag, miss, err := extractTags(line, fields, strategy)
+	if err != nil {
+		dlog.Errorf("%s%v", w.Mark, err)
+		return nil, nil
+	}
+	if miss != "" {
+		if tapOn(strategy.ID) {
+			tapDecision(TapMiss, strategy.ID, tmsUnix, line, miss)
 		}
-		t := regTag.FindStringSubmatch(line)
-		if t != nil && len(t) > 1 {
-			tag[tagk] = t[1]
-		} else {
+		return nil, nil
+	}
+
+	//按value_map取值, 替代pattern提取的值
+	if matched && strategy.ValueMap != nil {
+		var ok bool
+		if value, ok = resolveValueMap(strategy, line); !ok {
+			if tapOn(strategy.ID) {
+				tapDecision(TapMiss, strategy.ID, tmsUnix, line, "value_map not matched")
+			}
 			return nil, nil
 		}
 	}
@@ -346,16 +762,228 @@ func (w *Worker) producer(line string, strategy *scheme.Strategy) (*AnalysPoint,
 	ret := &AnalysPoint{
 		StrategyID: strategy.ID,
 		Value:      value,
+		Unmatched:  !matched,
 		//Tms:        tms.Unix(),
 		Tms:    time.Now().Unix(),
 		Tags:       tag,
+		LogTms:     tmsUnix,
+		EventTms:   strategy.EventTms(tms),
+	}
+	boostDebugf(strategy.ID, "%s[produced][id:%d][value:%v][tags:%v][log_tms:%d]", w.Mark, strategy.ID, value, tag, tmsUnix)
+	if matched && excludeRatioEnabled(strategy) {
+		recordExcludeRatio(strategy, ret.Tms, false)
 	}
-	dlog.Debugf("匹配完成后塞入ret的值： %v",ret)
 	return ret, nil
 }
 
+var logfmtParser reader.LogfmtParser
+
+var jsonLineParser reader.JSONLineParser
+
+// producerPanics produce中recover的panic次数
+var producerPanics int64
+
+// extractValue to get the value of the line by pattern of the strategy
+// matched为false表示pattern没有匹配到; ok为false时该行不产生点, 否则没匹配到的行取值为-1
+func extractValue(line string, strategy *scheme.Strategy) (value float64, matched bool, ok bool) {
+	return matchValue(line, strategy.PatternReg.FindStringSubmatch(line), strategy)
+}
+
+// matchValue to get the value by the submatches of pattern, same as extractValue
+func matchValue(line string, v []string, strategy *scheme.Strategy) (value float64, matched bool, ok bool) {
+	patternReg := strategy.PatternReg
+	hostname := fmt.Sprintf("v%",patternReg)
+	index := valueIndex(strategy)
+	// 显式配置了value_group时, 该组捕获为空视为没匹配到
+	if strategy.ValueGroup != "" && len(v) > index && v[index] == "" {
+		v = nil
+	}
+	var vString string
+	if v != nil && len(v) != 0 {
+		if len(v) > index {
+			vString = v[index]
+			dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的字符串： %v",v)
+			dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的被匹配行： %v",line)
+			dlog.Debugf("用户正则匹配返回完全匹配和局部匹配的vString： %v",vString)
+
+		} else {
+			vString = ""
+		}
+		value, err := strconv.ParseFloat(vString, 64)
+		dlog.Debugf("用户正则转换vString是否存在err： %v",err)
+		if err != nil {
+			value = math.NaN()
+			//value = -1
+		}
+		return value, true, true
+	}
+
+	//外边匹配err之后，要确保返回值不是nil再推送至counter
+	//正则有表达式，没匹配到，直接返回
+	//https://www.nhooo.com/golang/go-given-characters-in-string.html
+	if strings.Contains(hostname, "d+") {
+		dlog.Debugf("用户正则匹配到的字符串包含d+,字符串是： %v",hostname)
+		return 0, false, false
+	}
+	//匹配不到将每次的值置为-1
+	return -1, false, true
+}
+
+// extractTags to get the tags of the line by tag regexps, tag_fields and ordered_tag_extracts of the strategy
+// miss非空时该行不产生点, 为没有匹配到或超长被丢弃的原因
+func extractTags(line string, fields map[string]string, strategy *scheme.Strategy) (map[string]string, string, error) {
+	tag := make(map[string]string, len(strategy.Tags)+len(strategy.TagFields)+len(strategy.OrderedTagExtracts))
+	for tagk, tagv := range strategy.Tags {
+		regTag, ok := strategy.TagRegs[tagk]
+		if !ok {
+			return nil, "", fmt.Errorf("[get tag reg error][sid:%d][tagk:%s][tagv:%s]", strategy.ID, tagk, tagv)
+		}
+		t := regTag.FindStringSubmatch(line)
+		if len(t) <= 1 {
+			return nil, "tag " + tagk + " not matched", nil
+		}
+		v, ok := boundTagValue(strategy, tagk, t[1])
+		if !ok {
+			return nil, "tag " + tagk + " oversized", nil
+		}
+		tag[tagk] = v
+	}
+	for tagk, field := range strategy.TagFields {
+		if field == "" {
+			continue
+		}
+		t, ok := fields[field]
+		if !ok {
+			return nil, "tag " + tagk + " field " + field + " not found", nil
+		}
+		v, ok := boundTagValue(strategy, tagk, t)
+		if !ok {
+			return nil, "tag " + tagk + " oversized", nil
+		}
+		tag[tagk] = v
+	}
+	// 按顺序提取, source_tag取已提取出的值(经过长度限制)
+	for i := range strategy.OrderedTagExtracts {
+		e := &strategy.OrderedTagExtracts[i]
+		src := line
+		if e.SourceTag != "" {
+			var ok bool
+			if src, ok = tag[e.SourceTag]; !ok {
+				return nil, "", fmt.Errorf("[get source tag error][sid:%d][tagk:%s][source_tag:%s]", strategy.ID, e.Key, e.SourceTag)
+			}
+		}
+		if e.Reg == nil {
+			return nil, "", fmt.Errorf("[get tag reg error][sid:%d][tagk:%s][tagv:%s]", strategy.ID, e.Key, e.Regex)
+		}
+		t := e.Reg.FindStringSubmatch(src)
+		if len(t) <= 1 {
+			return nil, "tag " + e.Key + " not matched", nil
+		}
+		v, ok := boundTagValue(strategy, e.Key, t[1])
+		if !ok {
+			return nil, "tag " + e.Key + " oversized", nil
+		}
+		tag[e.Key] = v
+	}
+	return tag, "", nil
+}
+
+// valueIndex to get index of the capture group used as value, group 1 by default
+func valueIndex(strategy *scheme.Strategy) int {
+	if strategy.ValueIndex > 0 {
+		return strategy.ValueIndex
+	}
+	return 1
+}
+
+// variantRand to decide which of control and variant a line goes to, replaced in test
+var variantRand = rand.Float64
+
+// numberPrefixReg to get the number part of a value with unit, like 42ms
+var numberPrefixReg = regexp.MustCompile(`^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?`)
+
+// fieldValue to convert value of logfmt field to float, NaN if not a number
+func fieldValue(v string) float64 {
+	value, err := strconv.ParseFloat(numberPrefixReg.FindString(v), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return value
+}
+
+// spaceReg to squeeze spaces of syslog timestamp
+var spaceReg = regexp.MustCompile(`\s+`)
+
+// extractTimestamp to extract timestamp string from line and normalize it
+// 返回的是从line中拷贝出的时间串及对应的time包格式, 所有规整(补年份、合并空格)只作用于拷贝,
+// 后续pattern/exclude/tag等都基于原始line, 不受影响
+func extractTimestamp(line string, strategy *scheme.Strategy, now time.Time) (string, string, error) {
+	t, timeFormat, _, err := findTimestamp(line, strategy, now)
+	return t, timeFormat, err
+}
+
+// findTimestamp to extract timestamp string like extractTimestamp, rest is the part of line after it
+func findTimestamp(line string, strategy *scheme.Strategy, now time.Time) (t, timeFormat, rest string, err error) {
+	_, timeFormat = utils.GetPatAndTimeFormat(strategy.TimeFormat)
+
+	loc := strategy.TimeReg.FindStringIndex(line)
+	if loc == nil || loc[1] <= loc[0] {
+		return "", timeFormat, "", noTimestampError(strategy)
+	}
+	t, rest = line[loc[0]:loc[1]], line[loc[1]:]
+
+	// 如果没有年，需添加当前年
+	// 需干掉内部的多于空格, 如Dec  7,有的有一个空格，有的有两个，这里统一替换成一个
+	if timeFormat == "Jan 2 15:04:05" {
+		timeFormat = fmt.Sprintf("2006 %s", timeFormat)
+		t = fmt.Sprintf("%d %s", now.Year(), t)
+		t = spaceReg.ReplaceAllString(t, " ")
+	}
+	return t, timeFormat, rest, nil
+}
+
+// noTimestampError to get the error of a line without timestamp
+func noTimestampError(strategy *scheme.Strategy) error {
+	_, timeFormat := utils.GetPatAndTimeFormat(strategy.TimeFormat)
+	return fmt.Errorf("cannot get timestamp:[sname:%s][sid:%d][timeFormat:%v]", strategy.Name, strategy.ID, timeFormat)
+}
+
+// parseTimestamp to extract the timestamp of the line and parse it, found is false if no timestamp matched
+// 时间后紧跟的时区偏移优先于策略的时区
+func parseTimestamp(line string, strategy *scheme.Strategy, now time.Time) (tms time.Time, found bool, err error) {
+	t, timeFormat, rest, err := findTimestamp(line, strategy, now)
+	if err != nil {
+		return tms, false, err
+	}
+
+	if timeFormat == utils.TimeFormatUnixNano {
+		var nano int64
+		nano, err = strconv.ParseInt(t, 10, 64)
+		tms = time.Unix(0, nano)
+	} else {
+		// 按策略的时区解析, 加载时已解析好
+		loc := strategy.Location()
+		if zone, ok := trailingZone(rest, timeFormat); ok {
+			loc = zone
+		}
+		tms, err = time.ParseInLocation(timeFormat, t, loc)
+	}
+	dlog.Debugf("日志获取到的时间： %v",t)
+	dlog.Debugf("日志时间转换为tms时间： %v",tms)
+	return tms, true, err
+}
+
 //将解析数据给counter
 func toCounter(analyspoint *AnalysPoint, mark string) {
+	if l := getLimiter(); l != nil && !l.Allow() {
+		metric.MetricLimitedPoint(1)
+		return
+	}
+	// 配置了远端聚合时只发送, 不在本地聚合
+	if s := getSink(); s != nil {
+		s.Send(analyspoint)
+		return
+	}
 	if err := PushToCount(analyspoint); err != nil {
 		dlog.Errorf("%s push to counter error: %v", mark, err)
 	}
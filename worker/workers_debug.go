@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+// WorkerStrategy is a strategy evaluated by a worker group, with its documentation fields
+type WorkerStrategy struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	RunbookURL  string `json:"runbook_url,omitempty"`
+}
+
+// WorkerGroupDebug is a worker group and the strategies it evaluates
+type WorkerGroupDebug struct {
+	File       string           `json:"file"`
+	Shard      int              `json:"shard"`
+	Generation int64            `json:"generation"`
+	State      string           `json:"state"`
+	WorkerNum  int              `json:"worker_num"`
+	Dropped    int64            `json:"fanout_dropped,omitempty"` //拆分成多个shard时队列满丢弃的行数
+	Strategies []WorkerStrategy `json:"strategies"`
+}
+
+// DebugWorkers to get the worker groups of all files ordered by file and shard
+func DebugWorkers() []WorkerGroupDebug {
+	byFile := make(map[string][]*scheme.Strategy)
+	for _, st := range strategy.GetAll() {
+		byFile[st.FilePath] = append(byFile[st.FilePath], st)
+	}
+
+	ret := make([]WorkerGroupDebug, 0)
+	ManagerJobLock.RLock()
+	for file, job := range ManagerJob {
+		for _, wg := range job.groups() {
+			if wg == nil {
+				continue
+			}
+			stat := wg.LifecycleStat()
+			wg.workersLock.RLock()
+			d := WorkerGroupDebug{
+				File:       file,
+				Shard:      wg.Shard,
+				Generation: stat.Generation,
+				State:      stat.State,
+				WorkerNum:  len(wg.Workers),
+				Dropped:    atomic.LoadInt64(&wg.fanoutDropped),
+				Strategies: make([]WorkerStrategy, 0),
+			}
+			wg.workersLock.RUnlock()
+			for _, st := range byFile[file] {
+				if wg.Owns(st.ID) {
+					d.Strategies = append(d.Strategies, newWorkerStrategy(st))
+				}
+			}
+			sort.Slice(d.Strategies, func(i, j int) bool { return d.Strategies[i].ID < d.Strategies[j].ID })
+			ret = append(ret, d)
+		}
+	}
+	ManagerJobLock.RUnlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].File != ret[j].File {
+			return ret[i].File < ret[j].File
+		}
+		return ret[i].Shard < ret[j].Shard
+	})
+	return ret
+}
+
+func newWorkerStrategy(st *scheme.Strategy) WorkerStrategy {
+	return WorkerStrategy{
+		ID:          st.ID,
+		Name:        st.Name,
+		Description: st.Description,
+		Owner:       st.Owner,
+		RunbookURL:  st.RunbookURL,
+	}
+}
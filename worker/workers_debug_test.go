@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/didi/falcon-log-agent/common/scheme"
+	"github.com/didi/falcon-log-agent/strategy"
+)
+
+func TestDebugWorkers(t *testing.T) {
+	const file = "/tmp/debug_workers.log"
+	strategy.UpdateGlobalStrategy([]*scheme.Strategy{
+		{ID: 9402, Name: "api.err", FilePath: file, Description: "5xx responses", Owner: "api-team", RunbookURL: "https://wiki/api-err"},
+		{ID: 9401, Name: "api.cost", FilePath: file},
+		{ID: 9403, Name: "api.slow", FilePath: file, Owner: "api-team"},
+		{ID: 9404, Name: "other", FilePath: "/tmp/other.log"},
+	})
+	defer strategy.UpdateGlobalStrategy(nil)
+
+	shard0 := &WorkerGroup{Shard: 0, Generation: 7, Workers: []*Worker{{}, {}}}
+	shard0.SetStrategyIDs([]int64{9401, 9402})
+	shard1 := &WorkerGroup{Shard: 1, Generation: 8, Workers: []*Worker{{}}}
+	shard1.SetStrategyIDs([]int64{9403})
+	ManagerJobLock.Lock()
+	ManagerJob[file] = &Job{shards: []*WorkerGroup{shard1, shard0}}
+	ManagerJobLock.Unlock()
+	defer func() {
+		ManagerJobLock.Lock()
+		delete(ManagerJob, file)
+		ManagerJobLock.Unlock()
+	}()
+
+	var got []WorkerGroupDebug
+	for _, d := range DebugWorkers() {
+		if d.File == file {
+			got = append(got, d)
+		}
+	}
+	want := []WorkerGroupDebug{
+		{File: file, Shard: 0, Generation: 7, State: GroupCreated, WorkerNum: 2, Strategies: []WorkerStrategy{
+			{ID: 9401, Name: "api.cost"},
+			{ID: 9402, Name: "api.err", Description: "5xx responses", Owner: "api-team", RunbookURL: "https://wiki/api-err"},
+		}},
+		{File: file, Shard: 1, Generation: 8, State: GroupCreated, WorkerNum: 1, Strategies: []WorkerStrategy{
+			{ID: 9403, Name: "api.slow", Owner: "api-team"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected worker groups:\n%+v\nexpected:\n%+v", got, want)
+	}
+}
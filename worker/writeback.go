@@ -0,0 +1,289 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/didi/falcon-log-agent/common/dlog"
+	"github.com/didi/falcon-log-agent/common/g"
+	"github.com/didi/falcon-log-agent/common/proc/metric"
+	"github.com/didi/falcon-log-agent/common/scheme"
+)
+
+const (
+	defaultWriteBackMaxSizeMB = 100
+	defaultWriteBackQueueSize = 10000
+	defaultWriteBackDir       = "./log/write_back"
+	writeBackFlushInterval    = time.Second
+
+	// writeBackMarker 写在agent创建的write-back文件的第一行, 没有该行的文件不追加、不轮转
+	writeBackMarker = "# falcon-log-agent write-back\n"
+)
+
+var errNotOwned = errors.New("file exists and was not created by the agent")
+
+// writeBackPoint is the json of AnalysPoint in write-back file, NaN写为"NaN"
+type writeBackPoint struct {
+	StrategyID int64
+	Value      interface{}
+	Tms        int64
+	Tags       map[string]string
+	LogTms     int64
+	EventTms   int64 `json:",omitempty"`
+}
+
+// writeBackFile to append matched lines with their points to a file
+// 写入在单独的goroutine中进行, 队列满时丢弃, 不阻塞worker;
+// 文件超过上限后重命名为.1(覆盖旧的.1)再新建; marker不为空时只写入以marker开头的文件
+type writeBackFile struct {
+	path    string
+	marker  string
+	maxSize int64
+	queue   chan string
+	done    chan struct{}
+	exited  chan struct{} //run退出, 剩余内容已写入文件
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func newWriteBackFile(path, marker string, maxSize int64, queueSize int) *writeBackFile {
+	wb := &writeBackFile{
+		path:    path,
+		marker:  marker,
+		maxSize: maxSize,
+		queue:   make(chan string, queueSize),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	go wb.run()
+	return wb
+}
+
+// add to enqueue an annotated line, dropped if queue is full
+func (wb *writeBackFile) add(line string, point *AnalysPoint) {
+	bs, err := json.Marshal(&writeBackPoint{
+		StrategyID: point.StrategyID,
+		Value:      tapValue(point.Value),
+		Tms:        point.Tms,
+		Tags:       point.Tags,
+		LogTms:     point.LogTms,
+		EventTms:   point.EventTms,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case wb.queue <- line + "\t# " + string(bs) + "\n":
+	default:
+		metric.MetricWriteBackDrop(wb.path, 1)
+	}
+}
+
+func (wb *writeBackFile) open() error {
+	if wb.marker != "" {
+		return wb.openOwned()
+	}
+	f, err := os.OpenFile(wb.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	wb.f, wb.w, wb.size = f, bufio.NewWriter(f), fi.Size()
+	return nil
+}
+
+// openOwned to create the file with the marker, or append to an existing one created by the agent
+func (wb *writeBackFile) openOwned() error {
+	if err := os.MkdirAll(filepath.Dir(wb.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(wb.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err == nil {
+		if _, err = f.WriteString(wb.marker); err != nil {
+			f.Close()
+			return err
+		}
+		wb.f, wb.w, wb.size = f, bufio.NewWriter(f), int64(len(wb.marker))
+		return nil
+	}
+	if !os.IsExist(err) {
+		return err
+	}
+	if owned, err := wb.owned(wb.path); err != nil {
+		return err
+	} else if !owned {
+		return errNotOwned
+	}
+	f, err = os.OpenFile(wb.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	wb.f, wb.w, wb.size = f, bufio.NewWriter(f), fi.Size()
+	return nil
+}
+
+// owned to check path is a regular file starting with the marker, 不跟随符号链接
+func (wb *writeBackFile) owned(path string) (bool, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(wb.marker))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false, nil
+	}
+	return string(buf) == wb.marker, nil
+}
+
+func (wb *writeBackFile) rotate() error {
+	wb.w.Flush()
+	wb.f.Close()
+	wb.f = nil
+	if wb.marker != "" {
+		// 不覆盖不是agent创建的.1
+		if owned, err := wb.owned(wb.path + ".1"); err == nil && !owned {
+			return errNotOwned
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(wb.path, wb.path+".1"); err != nil {
+		return err
+	}
+	return wb.open()
+}
+
+func (wb *writeBackFile) write(s string) {
+	if wb.f == nil {
+		if err := wb.open(); err != nil {
+			dlog.Errorf("open write-back file failed [path:%s][err:%v]", wb.path, err)
+			return
+		}
+	}
+	if wb.size > 0 && wb.size+int64(len(s)) > wb.maxSize {
+		if err := wb.rotate(); err != nil {
+			dlog.Errorf("rotate write-back file failed [path:%s][err:%v]", wb.path, err)
+			return
+		}
+	}
+	n, _ := wb.w.WriteString(s)
+	wb.size += int64(n)
+}
+
+func (wb *writeBackFile) run() {
+	ticker := time.NewTicker(writeBackFlushInterval)
+	defer ticker.Stop()
+	defer func() {
+		if wb.f != nil {
+			wb.w.Flush()
+			wb.f.Close()
+		}
+		close(wb.exited)
+	}()
+	for {
+		select {
+		case s := <-wb.queue:
+			wb.write(s)
+		case <-ticker.C:
+			if wb.w != nil {
+				wb.w.Flush()
+			}
+		case <-wb.done:
+			// 写完队列中剩余的内容
+			for {
+				select {
+				case s := <-wb.queue:
+					wb.write(s)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (wb *writeBackFile) close() {
+	close(wb.done)
+}
+
+var (
+	writeBacks     = make(map[string]*writeBackFile)
+	writeBacksLock = new(sync.RWMutex)
+)
+
+func getWriteBack(path string) *writeBackFile {
+	writeBacksLock.RLock()
+	wb, ok := writeBacks[path]
+	writeBacksLock.RUnlock()
+	if ok {
+		return wb
+	}
+
+	maxSize, queueSize, dir := int64(defaultWriteBackMaxSizeMB), defaultWriteBackQueueSize, defaultWriteBackDir
+	if g.Conf() != nil {
+		if g.Conf().WriteBack.Dir != "" {
+			dir = g.Conf().WriteBack.Dir
+		}
+		if g.Conf().WriteBack.MaxSizeMB > 0 {
+			maxSize = int64(g.Conf().WriteBack.MaxSizeMB)
+		}
+		if g.Conf().WriteBack.QueueSize > 0 {
+			queueSize = g.Conf().WriteBack.QueueSize
+		}
+	}
+	writeBacksLock.Lock()
+	defer writeBacksLock.Unlock()
+	if wb, ok = writeBacks[path]; !ok {
+		wb = newWriteBackFile(filepath.Join(dir, path), writeBackMarker, maxSize*1024*1024, queueSize)
+		writeBacks[path] = wb
+	}
+	return wb
+}
+
+// writeBack to write the matched line with its point to the write-back file of the strategy
+func writeBack(st *scheme.Strategy, line string, point *AnalysPoint) {
+	getWriteBack(st.WriteBackPath).add(line, point)
+}
+
+// closeWriteBacks to close write-back files no longer used by any strategy
+func closeWriteBacks(strategyMap map[int64]*scheme.Strategy) {
+	used := make(map[string]bool)
+	for _, st := range strategyMap {
+		if st.WriteBackPath != "" {
+			used[st.WriteBackPath] = true
+		}
+	}
+	writeBacksLock.Lock()
+	defer writeBacksLock.Unlock()
+	for path, wb := range writeBacks {
+		if !used[path] {
+			wb.close()
+			delete(writeBacks, path)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteBackFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "writeback")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wb.log")
+
+	wb := newWriteBackFile(path, "", 1024, 100)
+	wb.add("GET /api cost=12", &AnalysPoint{StrategyID: 1, Value: 12, Tms: 100, Tags: map[string]string{"api": "/api"}})
+	wb.add("GET /api cost=-", &AnalysPoint{StrategyID: 1, Value: math.NaN(), Tms: 100, Tags: map[string]string{}})
+	wb.close()
+	time.Sleep(50 * time.Millisecond)
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 lines, got %q", bs)
+	}
+	parts := strings.SplitN(lines[0], "\t# ", 2)
+	if parts[0] != "GET /api cost=12" {
+		t.Fatalf("line should be kept as is, got %q", parts[0])
+	}
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(parts[1]), &p); err != nil || p["Value"] != 12.0 || p["StrategyID"] != 1.0 {
+		t.Fatalf("bad point json %s: %v", parts[1], err)
+	}
+	if !strings.HasSuffix(lines[1], `"Value":"NaN","Tms":100,"Tags":{},"LogTms":0}`) {
+		t.Fatalf("NaN should be written as string, got %s", lines[1])
+	}
+}
+
+func TestWriteBackRotate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "writeback")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wb.log")
+
+	wb := newWriteBackFile(path, "", 200, 100)
+	for i := 0; i < 10; i++ {
+		wb.add(strings.Repeat("x", 20), &AnalysPoint{StrategyID: 1, Value: 1, Tags: map[string]string{}})
+	}
+	wb.close()
+	time.Sleep(50 * time.Millisecond)
+
+	for _, p := range []string{path, path + ".1"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 200 {
+			t.Fatalf("%s exceeds max size: %d", p, fi.Size())
+		}
+	}
+}
+
+func TestWriteBackNotBlock(t *testing.T) {
+	// 没有消费者时队列满了直接丢弃
+	wb := &writeBackFile{path: "/nonexistent/wb.log", queue: make(chan string, 1)}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			wb.add("line", &AnalysPoint{Tags: map[string]string{}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("add should not block when queue is full")
+	}
+}
+
+func TestWriteBackNotOwned(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "writeback")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wb.log")
+
+	// 已存在且不是agent创建的文件不追加
+	ioutil.WriteFile(path, []byte("important\n"), 0644)
+	wb := newWriteBackFile(path, writeBackMarker, 1024, 100)
+	wb.add("line", &AnalysPoint{Tags: map[string]string{}})
+	wb.close()
+	<-wb.exited
+	if bs, _ := ioutil.ReadFile(path); string(bs) != "important\n" {
+		t.Fatalf("file not created by the agent was written: %q", bs)
+	}
+
+	// 轮转时不覆盖不是agent创建的.1
+	os.Remove(path)
+	ioutil.WriteFile(path+".1", []byte("important\n"), 0644)
+	wb = newWriteBackFile(path, writeBackMarker, 100, 100)
+	for i := 0; i < 10; i++ {
+		wb.add(strings.Repeat("x", 20), &AnalysPoint{Tags: map[string]string{}})
+	}
+	wb.close()
+	<-wb.exited
+	if bs, _ := ioutil.ReadFile(path + ".1"); string(bs) != "important\n" {
+		t.Fatalf("file not created by the agent was overwritten: %q", bs)
+	}
+	if bs, _ := ioutil.ReadFile(path); !strings.HasPrefix(string(bs), writeBackMarker) {
+		t.Fatalf("created file should start with the marker: %q", bs)
+	}
+
+	// agent创建的文件重新打开后继续追加, 并正常轮转
+	os.Remove(path + ".1")
+	wb = newWriteBackFile(path, writeBackMarker, 100, 100)
+	for i := 0; i < 10; i++ {
+		wb.add(strings.Repeat("x", 20), &AnalysPoint{Tags: map[string]string{}})
+	}
+	wb.close()
+	<-wb.exited
+	if bs, _ := ioutil.ReadFile(path + ".1"); !strings.HasPrefix(string(bs), writeBackMarker) {
+		t.Fatalf("rotated file should start with the marker: %q", bs)
+	}
+}
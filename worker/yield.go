@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"runtime"
+
+	"github.com/didi/falcon-log-agent/common/g"
+)
+
+// cpuYieldLines to get how many lines a worker processes before yielding, 0 means never yield
+func cpuYieldLines() int {
+	if g.Conf() == nil || g.Conf().Worker.CPUYieldLines <= 0 {
+		return 0
+	}
+	return g.Conf().Worker.CPUYieldLines
+}
+
+// yielder to yield the processor after every n lines
+// 复杂正则连续匹配时不会主动让出, GOMAXPROCS=1或绑定线程时同一P上的其他goroutine(推送、http、reader)
+// 要等到异步抢占(约10ms)才能运行; 每处理n行调用一次runtime.Gosched
+type yielder struct {
+	every int
+	n     int
+}
+
+func newYielder(every int) *yielder {
+	return &yielder{every: every}
+}
+
+// tick to count a processed line, yield when the budget is used up
+func (y *yielder) tick() {
+	if y.every <= 0 {
+		return
+	}
+	y.n++
+	if y.n >= y.every {
+		y.n = 0
+		runtime.Gosched()
+	}
+}
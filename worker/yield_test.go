@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestYielder(t *testing.T) {
+	y := newYielder(3)
+	for i := 1; i <= 7; i++ {
+		y.tick()
+		if want := i % 3; y.n != want {
+			t.Errorf("after %d lines: count %d, want %d", i, y.n, want)
+		}
+	}
+	off := newYielder(0)
+	off.tick()
+	if off.n != 0 {
+		t.Error("disabled yielder should not count")
+	}
+}
+
+// BenchmarkCPUYield measures how late an IO-bound goroutine wakes up while a worker runs regexps on the same P
+// GOMAXPROCS=1时不让出的worker只能被异步抢占, late-us/op为10ms以上; 让出间隔越短延迟越低,
+// lines/op为每次等待期间worker处理的行数, 即这段时间worker实际拿到的处理器
+func BenchmarkCPUYield(b *testing.B) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	reg := regexp.MustCompile(`(\w+)=(\d+)ms.*(error|timeout)`)
+	line := strings.Repeat("key=value ", 50) + "cost=12ms"
+	const wait = 100 * time.Microsecond
+
+	for _, every := range []int{0, 1000, 100} {
+		b.Run(fmt.Sprintf("every=%d", every), func(b *testing.B) {
+			stop, done := make(chan struct{}), make(chan int64)
+			go func() {
+				y := newYielder(every)
+				var lines int64
+				for {
+					select {
+					case <-stop:
+						done <- lines
+						return
+					default:
+					}
+					reg.MatchString(line)
+					lines++
+					y.tick()
+				}
+			}()
+
+			var late time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				time.Sleep(wait)
+				late += time.Since(start) - wait
+			}
+			b.StopTimer()
+			close(stop)
+			lines := <-done
+			b.ReportMetric(float64(late.Microseconds())/float64(b.N), "late-us/op")
+			b.ReportMetric(float64(lines)/float64(b.N), "lines/op")
+		})
+	}
+}